@@ -0,0 +1,460 @@
+// Package api holds the JSON request and response types of the HTTP API,
+// shared by the server (internal/httpapi) and its clients (cmd/raijinctl).
+// Types only: behavior stays with the server.
+package api
+
+import "time"
+
+type User struct {
+	ID        string `json:"id"`
+	Email     string `json:"email"`
+	Name      string `json:"name"`
+	Role      string `json:"role"`
+	Phone     string `json:"phone,omitempty"` // E.164, set once verified
+	Suspended bool   `json:"suspended,omitempty"`
+	// DeleteAfter is set while the account is pending deletion: it is
+	// purged then unless the user cancels the deletion first.
+	DeleteAfter time.Time `json:"delete_after,omitzero"`
+	// TermsAccepted lists every acceptance of the terms of service and
+	// privacy policy, oldest first.
+	TermsAccepted []TermsAcceptance `json:"terms_accepted,omitempty"`
+	// Preferences are the settings the user saved, unset fields empty.
+	Preferences Preferences `json:"preferences,omitzero"`
+	// Unsubscribed lists the notification categories the user opted out
+	// of (NotifyNewDevice, ...); their mail to the user is suppressed.
+	Unsubscribed []string `json:"unsubscribed,omitempty"`
+	// Flags mark the account for review by an admin, such as
+	// UserFlagDisposableEmail.
+	Flags    []string `json:"flags,omitempty"`
+	Password string   `json:"-"` // bcrypt hash; never serialized
+	// TokensValidAfter is when the user's credentials were last revoked
+	// (see TokenRevoked), rounded up to the whole second.
+	TokensValidAfter time.Time `json:"-"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// User flags.
+const (
+	UserFlagDisposableEmail = "disposable_email" // registered with a throwaway mailbox (DISPOSABLE_EMAIL_ACTION=flag)
+	UserFlagUnknownRole     = "unknown_role"     // the role was dropped from ROLES; only in the admin list, not stored
+)
+
+// PendingDeletion reports whether the account is scheduled for deletion.
+func (u *User) PendingDeletion() bool { return !u.DeleteAfter.IsZero() }
+
+// TokenRevoked reports whether an access token issued at iat (Unix
+// seconds) predates the latest revocation of the user's credentials.
+func (u *User) TokenRevoked(iat int64) bool {
+	return !u.TokensValidAfter.IsZero() && iat < u.TokensValidAfter.Unix()
+}
+
+// AcceptedTerms reports whether the user's latest acceptance is of these
+// versions of the terms of service and privacy policy.
+func (u *User) AcceptedTerms(terms, privacy string) bool {
+	if len(u.TermsAccepted) == 0 {
+		return false
+	}
+	last := u.TermsAccepted[len(u.TermsAccepted)-1]
+	return last.TermsVersion == terms && last.PrivacyVersion == privacy
+}
+
+// Preferences are a user's settings for the frontends, kept on the server
+// so they follow the user across devices. An empty field is unset and
+// reads as its DefaultPreferences value.
+type Preferences struct {
+	Theme        string `json:"theme,omitempty"`          // ThemeSystem, ThemeLight or ThemeDark
+	Locale       string `json:"locale,omitempty"`         // a language tag the server has messages for
+	Timezone     string `json:"timezone,omitempty"`       // IANA name, e.g. America/Sao_Paulo
+	ItemsPerPage int    `json:"items_per_page,omitempty"` // MinItemsPerPage to MaxItemsPerPage
+}
+
+// Preference values.
+const (
+	ThemeSystem = "system"
+	ThemeLight  = "light"
+	ThemeDark   = "dark"
+
+	MinItemsPerPage = 5
+	MaxItemsPerPage = 100
+)
+
+// DefaultPreferences are the settings of a user who saved none.
+var DefaultPreferences = Preferences{Theme: ThemeSystem, Locale: "en", Timezone: "UTC", ItemsPerPage: 20}
+
+// WithDefaults returns p with its unset fields set from DefaultPreferences.
+func (p Preferences) WithDefaults() Preferences {
+	if p.Theme == "" {
+		p.Theme = DefaultPreferences.Theme
+	}
+	if p.Locale == "" {
+		p.Locale = DefaultPreferences.Locale
+	}
+	if p.Timezone == "" {
+		p.Timezone = DefaultPreferences.Timezone
+	}
+	if p.ItemsPerPage == 0 {
+		p.ItemsPerPage = DefaultPreferences.ItemsPerPage
+	}
+	return p
+}
+
+// UpdatePreferencesRequest is the body of PUT /users/me/preferences. Only
+// the fields present change; "" (0 for items_per_page) resets one to its
+// default.
+type UpdatePreferencesRequest struct {
+	Theme        *string `json:"theme"`
+	Locale       *string `json:"locale"`
+	Timezone     *string `json:"timezone"`
+	ItemsPerPage *int    `json:"items_per_page"`
+}
+
+// Notification categories: the optional mail a user can opt out of.
+// Security mail (verification, password reset) has no category and is
+// always sent.
+const (
+	NotifyNewDevice            = "new_device"
+	NotifyProductAnnouncements = "product_announcements"
+)
+
+// NotificationPreferences has a toggle per notification category, true
+// while its mail is sent to the user.
+type NotificationPreferences struct {
+	NewDevice            bool `json:"new_device"`
+	ProductAnnouncements bool `json:"product_announcements"`
+}
+
+// UpdateNotificationsRequest is the body of PUT /users/me/notifications:
+// only the categories present change.
+type UpdateNotificationsRequest struct {
+	NewDevice            *bool `json:"new_device"`
+	ProductAnnouncements *bool `json:"product_announcements"`
+}
+
+// Lockout is an email address locked out of password login by too many
+// failures (LOGIN_FAILURE_LIMIT within LOGIN_FAILURE_WINDOW). Unknown
+// emails are locked out too, so the limit does not tell which accounts
+// exist: UnknownAccount marks those, attack traffic rather than a user.
+type Lockout struct {
+	Email          string    `json:"email"` // lowercased
+	UserID         string    `json:"user_id,omitempty"`
+	UnknownAccount bool      `json:"unknown_account"`
+	Failures       int       `json:"failures"`
+	LockedSince    time.Time `json:"locked_since"`
+	LockedUntil    time.Time `json:"locked_until"` // unless an admin unlocks it first
+	SourceIPs      []string  `json:"source_ips"`   // of the failures, newest last
+}
+
+// UnsubscribeResponse confirms GET /notifications/unsubscribe.
+type UnsubscribeResponse struct {
+	Category     string `json:"category"`
+	Unsubscribed bool   `json:"unsubscribed"`
+}
+
+// TermsAcceptance records that a user accepted the given versions of the
+// terms of service and privacy policy, when and from where.
+type TermsAcceptance struct {
+	TermsVersion   string    `json:"terms_version"`
+	PrivacyVersion string    `json:"privacy_version"`
+	AcceptedAt     time.Time `json:"accepted_at"`
+	IP             string    `json:"ip"`
+}
+
+// LoginRequest and RegisterRequest carry CaptchaToken, the token of the
+// CAPTCHA widget, when the server asks for one (error captcha_required).
+type LoginRequest struct {
+	Email        string `json:"email"`
+	Password     string `json:"password"`
+	CaptchaToken string `json:"captcha_token,omitempty"`
+}
+
+type RegisterRequest struct {
+	Email        string `json:"email"`
+	Name         string `json:"name"`
+	Password     string `json:"password"`
+	CaptchaToken string `json:"captcha_token,omitempty"`
+	// AcceptTerms must be true while the server tracks policy acceptance
+	// (TERMS_VERSION or PRIVACY_VERSION set).
+	AcceptTerms bool `json:"accept_terms"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// CreateUserRequest is an admin creating an account; Role defaults to
+// "user".
+type CreateUserRequest struct {
+	Email    string `json:"email"`
+	Name     string `json:"name"`
+	Password string `json:"password"`
+	Role     string `json:"role,omitempty"`
+}
+
+type SetRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// RevokedTokens reports how many refresh tokens a revocation removed.
+type RevokedTokens struct {
+	Revoked int `json:"revoked"`
+}
+
+// AuthResponseV2 is the /api/v2 login, register and refresh response, with
+// the token lifetimes in seconds.
+type AuthResponseV2 struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"` // always "Bearer"
+	ExpiresIn        int64  `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token"`
+	RefreshExpiresIn int64  `json:"refresh_expires_in"`
+	User             User   `json:"user"`
+	CSRFToken        string `json:"csrf_token"`
+	PasswordWarning  string `json:"password_warning,omitempty"` // see AuthResponse
+}
+
+// WhoAmI is the current user and the access token the request was
+// authenticated with.
+type WhoAmI struct {
+	User  User      `json:"user"`
+	Token TokenInfo `json:"token"`
+}
+
+// TokenInfo describes an access token. ExpiresIn is the seconds left, for
+// scheduling a refresh ahead of ExpiresAt. AuthTime, the last time the
+// user presented credentials, is unset on tokens issued by a refresh;
+// Fresh says whether it is recent enough for the operations that ask for
+// a recent login (REAUTH_MAX_AGE).
+type TokenInfo struct {
+	Credential       string     `json:"credential"` // where it was sent: "bearer" (Authorization header)
+	IssuedAt         time.Time  `json:"issued_at"`
+	ExpiresAt        time.Time  `json:"expires_at"`
+	ExpiresIn        int64      `json:"expires_in"`
+	Role             string     `json:"role"`
+	SessionID        string     `json:"session_id,omitempty"`
+	OrgID            string     `json:"org_id,omitempty"`
+	OrgRole          string     `json:"org_role,omitempty"`
+	SessionStartedAt *time.Time `json:"session_started_at,omitempty"`
+	AuthTime         *time.Time `json:"auth_time,omitempty"`
+	Fresh            bool       `json:"fresh"`
+}
+
+// Organization is a tenant: a group of users, its members, each with a
+// role in it apart from their account role.
+type Organization struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Slug      string    `json:"slug"` // unique; lowercase letters, digits and hyphens
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Roles in an organization. Owners manage its members; an organization
+// always keeps at least one.
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleMember = "member"
+)
+
+// OrgMembership is an organization the current user belongs to.
+type OrgMembership struct {
+	Organization
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// OrgMember is a user in an organization.
+type OrgMember struct {
+	UserID   string    `json:"user_id"`
+	Email    string    `json:"email"`
+	Name     string    `json:"name"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// CreateOrgRequest creates an organization owned by the caller or, on the
+// admin route, where it is required, by the user with OwnerEmail. Slug is
+// derived from Name when empty.
+type CreateOrgRequest struct {
+	Name       string `json:"name"`
+	Slug       string `json:"slug,omitempty"`
+	OwnerEmail string `json:"owner_email,omitempty"`
+}
+
+// UpdateOrgRequest changes the fields that are set.
+type UpdateOrgRequest struct {
+	Name *string `json:"name,omitempty"`
+	Slug *string `json:"slug,omitempty"`
+}
+
+// AddOrgMemberRequest adds an existing user, by email, to an
+// organization; Role defaults to "member".
+type AddOrgMemberRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role,omitempty"`
+}
+
+// SwitchOrgRequest picks the organization the access token acts in; an
+// empty OrgID leaves every organization.
+type SwitchOrgRequest struct {
+	OrgID string `json:"org_id"`
+}
+
+// SwitchOrgResponse is an access token for the organization switched to,
+// Org (unset when none). The refresh token stays the same: its refreshes
+// keep the organization.
+type SwitchOrgResponse struct {
+	AccessToken string         `json:"access_token"`
+	ExpiresIn   int64          `json:"expires_in"`
+	Org         *OrgMembership `json:"org,omitempty"`
+}
+
+// ListPage is a list response from v2 on.
+type ListPage[T any] struct {
+	Data []T      `json:"data" fields:"items"`
+	Page PageInfo `json:"page"`
+}
+
+type PageInfo struct {
+	Limit  int     `json:"limit"`
+	Offset int     `json:"offset"`
+	Total  int     `json:"total"`
+	Next   *string `json:"next"` // the next page's path and query; null on the last page
+}
+
+// Error codes returned in APIError.ErrorCode. Clients branch on these, so
+// they are a stable contract: add new ones freely, never rename or reuse.
+const (
+	ErrCodeInvalidRequest      = "invalid_request"                 // body is not valid JSON
+	ErrCodeValidationFailed    = "validation_failed"               // a field failed validation
+	ErrCodePayloadTooLarge     = "payload_too_large"               // request body over the limit
+	ErrCodeInvalidCredentials  = "invalid_credentials"             // wrong email or password
+	ErrCodeEmailTaken          = "email_taken"                     // registration with a known email
+	ErrCodeInvalidEmail        = "invalid_email_format"            // the email is not a deliverable address; see fields
+	ErrCodeEmailDomain         = "email_domain_not_allowed"        // registration from a domain outside the allowlist or on the blocklist
+	ErrCodeEmailDisposable     = "disposable_email_not_allowed"    // registration with a throwaway mailbox
+	ErrCodePhoneTaken          = "phone_taken"                     // the phone number belongs to another account
+	ErrCodeOTPInvalid          = "otp_invalid"                     // wrong, expired or used one-time code; request a new one
+	ErrCodeAuthMissing         = "auth_missing"                    // no Authorization header
+	ErrCodeAuthMalformed       = "auth_malformed"                  // Authorization is not "Bearer <token>"
+	ErrCodeTokenMalformed      = "token_malformed"                 // the token is not a JWT; log in again
+	ErrCodeTokenInvalid        = "token_invalid"                   // bad signature; log in again
+	ErrCodeTokenExpired        = "token_expired"                   // access token expired; refresh it
+	ErrCodeTokenRevoked        = "token_revoked"                   // issued before the user's credentials were revoked; log in again
+	ErrCodeReauthRequired      = "reauth_required"                 // the operation needs a recent login; log in again
+	ErrCodeSessionExpired      = "session_expired_reauth_required" // the session is older than MAX_SESSION_LIFETIME; log in again
+	ErrCodeRefreshInvalid      = "refresh_token_invalid"           // unknown or revoked refresh token
+	ErrCodeSignatureInvalid    = "signature_invalid"               // an internal caller's signed request failed verification; see the message
+	ErrCodeCSRFInvalid         = "csrf_invalid"                    // missing or unknown X-CSRF-Token
+	ErrCodeForbidden           = "forbidden"                       // authenticated but not allowed
+	ErrCodeUnknownRole         = "unknown_role"                    // the role is not in the catalog; see GET /roles
+	ErrCodeAccountSuspended    = "account_suspended"               // the account is suspended; ask an admin
+	ErrCodeAccountDeleting     = "account_pending_deletion"        // the account is scheduled for deletion; cancel it to log in
+	ErrCodeTermsRequired       = "terms_acceptance_required"       // accept the current terms at POST /users/me/accept-terms
+	ErrCodeSAMLInvalid         = "saml_invalid"                    // SAML response rejected; start the login again
+	ErrCodeCaptchaRequired     = "captcha_required"                // render the CAPTCHA widget and resend with captcha_token
+	ErrCodeCaptchaUnavailable  = "captcha_unavailable"             // the CAPTCHA provider could not be reached; retry later
+	ErrCodeUserNotFound        = "user_not_found"                  // the referenced user does not exist
+	ErrCodeOrgNotFound         = "org_not_found"                   // no such organization, or the caller is not in it
+	ErrCodeOrgSlugTaken        = "org_slug_taken"                  // another organization has the slug
+	ErrCodeAlreadyMember       = "already_member"                  // the user is in the organization already
+	ErrCodeOrgLastOwner        = "org_last_owner"                  // the organization would be left without an owner
+	ErrCodeOrgHasMembers       = "org_has_members"                 // members other than the owners remain; remove them or force
+	ErrCodeOrgContextRequired  = "org_context_required"            // the route acts in an organization; pick one at POST /auth/switch-org
+	ErrCodeUnsubscribeInvalid  = "unsubscribe_token_invalid"       // the unsubscribe link is not one the server sent
+	ErrCodeRateLimited         = "rate_limited"                    // too many requests; see Retry-After
+	ErrCodeMaintenance         = "maintenance"                     // maintenance mode; see Retry-After
+	ErrCodeShuttingDown        = "shutting_down"                   // instance draining; retry elsewhere
+	ErrCodeOverloaded          = "overloaded"                      // too many concurrent requests; see Retry-After
+	ErrCodeUpstreamUnavailable = "upstream_unavailable"            // a proxied route's upstream failed or timed out
+	ErrCodeIdempotencyMismatch = "idempotency_key_mismatch"        // key reused with a different body
+	ErrCodeIdempotencyInFlight = "idempotency_key_in_progress"     // key's first request still running
+	ErrCodeNotFound            = "not_found"                       // no such route
+	ErrCodeMethodNotAllowed    = "method_not_allowed"              // route exists; see Allow
+	ErrCodeInternal            = "internal_error"                  // unexpected server failure
+)
+
+type APIError struct {
+	Error     string       `json:"error"`
+	ErrorCode string       `json:"error_code"`
+	Message   string       `json:"message"`
+	Code      int          `json:"code"`
+	Fields    []FieldError `json:"fields,omitempty"`
+}
+
+// FieldError pinpoints a validation failure on one request field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ProblemDetails is the RFC 7807 error shape, with our error code and field
+// errors as extension members.
+type ProblemDetails struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     string       `json:"code"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+type HealthResponse struct {
+	Status      string             `json:"status"`
+	Version     string             `json:"version"`
+	Timestamp   string             `json:"timestamp"`
+	Uptime      string             `json:"uptime"`
+	Maintenance *MaintenanceStatus `json:"maintenance,omitempty"`
+	Draining    bool               `json:"draining,omitempty"` // shutting down: /ready fails
+
+	// Verbose fields, only populated for admins with ?verbose=1.
+	Build       *BuildInfo        `json:"build,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Goroutines  int               `json:"goroutines,omitempty"`
+	Memory      *MemoryStats      `json:"memory,omitempty"`
+	Checks      map[string]string `json:"checks,omitempty"`
+	Warmup      []WarmupTask      `json:"warmup,omitempty"`
+	// Outbound is the circuit breaker of each third party the server
+	// calls, by dependency.
+	Outbound map[string]OutboundStatus `json:"outbound,omitempty"`
+}
+
+// OutboundStatus is the circuit breaker of one outbound dependency.
+type OutboundStatus struct {
+	State     string     `json:"state"`    // closed | open | half_open (the next call probes)
+	Failures  int        `json:"failures"` // consecutive
+	OpenUntil *time.Time `json:"open_until,omitempty"`
+	Opened    int64      `json:"opened"`   // times the circuit opened
+	Rejected  int64      `json:"rejected"` // calls refused while open
+}
+
+// WarmupTask is how one startup warmup task went. Until it ends Status is
+// "running", and DurationMS how long it has run so far.
+type WarmupTask struct {
+	Name       string `json:"name"`
+	Fatal      bool   `json:"fatal"`  // its failure stops the server, rather than being logged
+	Status     string `json:"status"` // running | ok | failed | timeout
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+type BuildInfo struct {
+	Version   string `json:"version"`
+	BuildTime string `json:"build_time"`
+	GitCommit string `json:"git_commit"`
+	GoVersion string `json:"go_version"`
+}
+
+type MemoryStats struct {
+	AllocBytes      uint64 `json:"alloc_bytes"`
+	TotalAllocBytes uint64 `json:"total_alloc_bytes"`
+	SysBytes        uint64 `json:"sys_bytes"`
+	HeapObjects     uint64 `json:"heap_objects"`
+	NumGC           uint32 `json:"num_gc"`
+}
+
+type MaintenanceStatus struct {
+	Enabled bool      `json:"enabled"`
+	Message string    `json:"message,omitempty"`
+	Since   time.Time `json:"since"`
+}
@@ -0,0 +1,625 @@
+// Command raijinctl administers a server through its HTTP API (/api/v2).
+//
+//	raijinctl [--url URL] [--config FILE] [--json] <command> [args]
+//
+//	health                              server health (no login)
+//	user list                           all users
+//	user create --email E --name N --password P [--role R]
+//	user suspend USER [--lift]          suspend (or lift a suspension)
+//	user set-role USER ROLE
+//	token revoke --user USER            revoke a user's refresh tokens
+//	backup                              dump users and webhooks (JSON) to stdout
+//
+// USER is an ID or an email. The server URL and the admin credentials come
+// from the flags, then RAIJIN_URL, RAIJIN_EMAIL and RAIJIN_PASSWORD, then the
+// config file ($RAIJINCTL_CONFIG or <user config dir>/raijinctl/config.json,
+// {"url": ..., "email": ..., "password": ...}). Tokens are cached in
+// <user cache dir>/raijinctl/session.json and refreshed as needed.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+)
+
+const defaultURL = "http://localhost:8080"
+
+// Config is where to reach the server and who to log in as.
+type Config struct {
+	URL      string `json:"url"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// loadConfig merges the config file, the environment and the flags, in
+// increasing precedence.
+func loadConfig(path, flagURL string) (Config, error) {
+	var cfg Config
+	explicit := path != ""
+	if path == "" {
+		path = os.Getenv("RAIJINCTL_CONFIG")
+		explicit = path != ""
+	}
+	if path == "" {
+		if dir, err := os.UserConfigDir(); err == nil {
+			path = filepath.Join(dir, "raijinctl", "config.json")
+		}
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		switch {
+		case err == nil:
+			if err := json.Unmarshal(data, &cfg); err != nil {
+				return cfg, fmt.Errorf("%s: %w", path, err)
+			}
+		case explicit || !errors.Is(err, os.ErrNotExist):
+			return cfg, err
+		}
+	}
+	for dst, env := range map[*string]string{&cfg.URL: "RAIJIN_URL", &cfg.Email: "RAIJIN_EMAIL", &cfg.Password: "RAIJIN_PASSWORD"} {
+		if v := os.Getenv(env); v != "" {
+			*dst = v
+		}
+	}
+	if flagURL != "" {
+		cfg.URL = flagURL
+	}
+	if cfg.URL == "" {
+		cfg.URL = defaultURL
+	}
+	cfg.URL = strings.TrimSuffix(cfg.URL, "/")
+	return cfg, nil
+}
+
+// ===========================================================================
+// Client
+// ===========================================================================
+
+// session is the cached login of one user on one server.
+type session struct {
+	URL          string    `json:"url"`
+	Email        string    `json:"email"`
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	CSRFToken    string    `json:"csrf_token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// Client calls the API as the configured admin, logging in and refreshing
+// tokens as needed.
+type Client struct {
+	cfg       Config
+	http      *http.Client
+	cachePath string // "" disables the session cache
+	sess      *session
+}
+
+func NewClient(cfg Config, cachePath string) *Client {
+	c := &Client{cfg: cfg, http: &http.Client{Timeout: 30 * time.Second}, cachePath: cachePath}
+	if data, err := os.ReadFile(cachePath); err == nil {
+		var s session
+		if json.Unmarshal(data, &s) == nil && s.URL == cfg.URL && s.Email == cfg.Email {
+			c.sess = &s
+		}
+	}
+	return c
+}
+
+// APIError is an error response from the server.
+type APIError struct {
+	Status  int
+	Problem api.ProblemDetails
+}
+
+func (e *APIError) Error() string {
+	msg := e.Problem.Detail
+	if msg == "" {
+		msg = http.StatusText(e.Status)
+	}
+	if e.Problem.Code != "" {
+		msg += " (" + e.Problem.Code + ")"
+	}
+	for _, f := range e.Problem.Errors {
+		msg += fmt.Sprintf("\n  %s: %s", f.Field, f.Message)
+	}
+	return msg
+}
+
+// Do sends an authenticated request to /api/v2+path, JSON-encoding in
+// (unless nil) and decoding the response into out (unless nil). A request
+// refused for an expired session is retried once with a new one.
+func (c *Client) Do(ctx context.Context, method, path string, in, out any) error {
+	resp, err := c.send(ctx, method, path, in)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return decode(resp, out)
+}
+
+// send is Do without decoding the response. The caller closes its body.
+func (c *Client) send(ctx context.Context, method, path string, in any) (*http.Response, error) {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return nil, err
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		if err := c.ensureSession(ctx); err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, method, c.cfg.URL+"/api/v2"+path, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+c.sess.AccessToken)
+		req.Header.Set("X-CSRF-Token", c.sess.CSRFToken)
+		if in != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if attempt > 0 || !staleSession(resp) {
+			return resp, nil
+		}
+		resp.Body.Close()
+		c.sess.ExpiresAt = time.Time{} // refresh (or log in) and retry
+	}
+}
+
+// staleSession reports whether resp refuses the session itself rather than
+// the request: the tokens expired or were revoked, or the CSRF token did.
+func staleSession(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusUnauthorized && resp.StatusCode != http.StatusForbidden {
+		return false
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	var p api.ProblemDetails
+	_ = json.Unmarshal(data, &p)
+	switch p.Code {
+	case api.ErrCodeTokenExpired, api.ErrCodeTokenInvalid, api.ErrCodeTokenMalformed, api.ErrCodeTokenRevoked, api.ErrCodeCSRFInvalid:
+		return true
+	}
+	return false
+}
+
+// ensureSession makes sure c.sess holds a token valid for a while longer,
+// refreshing it or logging in again.
+func (c *Client) ensureSession(ctx context.Context) error {
+	if c.sess != nil && time.Until(c.sess.ExpiresAt) > 30*time.Second {
+		return nil
+	}
+	var auth api.AuthResponseV2
+	err := errors.New("no session")
+	if c.sess != nil && c.sess.RefreshToken != "" {
+		err = c.post(ctx, "/api/v2/auth/refresh", api.RefreshRequest{RefreshToken: c.sess.RefreshToken}, &auth)
+	}
+	if err != nil {
+		if c.cfg.Email == "" || c.cfg.Password == "" {
+			return errors.New("not logged in: set RAIJIN_EMAIL and RAIJIN_PASSWORD or the config file")
+		}
+		if err := c.post(ctx, "/api/v2/auth/login", api.LoginRequest{Email: c.cfg.Email, Password: c.cfg.Password}, &auth); err != nil {
+			return fmt.Errorf("login: %w", err)
+		}
+	}
+	c.sess = &session{
+		URL: c.cfg.URL, Email: c.cfg.Email,
+		AccessToken: auth.AccessToken, RefreshToken: auth.RefreshToken, CSRFToken: auth.CSRFToken,
+		ExpiresAt: time.Now().Add(time.Duration(auth.ExpiresIn) * time.Second),
+	}
+	c.saveSession()
+	return nil
+}
+
+// saveSession caches c.sess, readable only by the user. Failing to is not
+// an error: the next run logs in again.
+func (c *Client) saveSession() {
+	if c.cachePath == "" {
+		return
+	}
+	data, err := json.Marshal(c.sess)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(c.cachePath), 0o700); err != nil {
+		return
+	}
+	_ = os.WriteFile(c.cachePath, data, 0o600)
+}
+
+// post sends an unauthenticated JSON POST to path.
+func (c *Client) post(ctx context.Context, path string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.URL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.fetch(req, out)
+}
+
+// Health reads /health, which needs no login. A 503 still carries the
+// report, so it is returned along with the error.
+func (c *Client) Health(ctx context.Context) (api.HealthResponse, error) {
+	var h api.HealthResponse
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.cfg.URL+"/health", nil)
+	if err != nil {
+		return h, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return h, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&h); err != nil {
+		return h, fmt.Errorf("health: %s: %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return h, fmt.Errorf("health: %s", resp.Status)
+	}
+	return h, nil
+}
+
+func (c *Client) fetch(req *http.Request, out any) error {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return decode(resp, out)
+}
+
+// decode reads a successful response into out, or returns the error the
+// server described.
+func decode(resp *http.Response, out any) error {
+	if resp.StatusCode >= 400 {
+		apiErr := &APIError{Status: resp.StatusCode}
+		_ = json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&apiErr.Problem)
+		return apiErr
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Users lists every user, following the pages.
+func (c *Client) Users(ctx context.Context) ([]api.User, error) {
+	var users []api.User
+	next := "/users?limit=1000"
+	for next != "" {
+		var page api.ListPage[api.User]
+		if err := c.Do(ctx, http.MethodGet, next, nil, &page); err != nil {
+			return nil, err
+		}
+		users = append(users, page.Data...)
+		next = ""
+		if page.Page.Next != nil {
+			next = strings.TrimPrefix(*page.Page.Next, "/api/v2")
+		}
+	}
+	return users, nil
+}
+
+// userID resolves ref, an ID or an email, to an ID.
+func (c *Client) userID(ctx context.Context, ref string) (string, error) {
+	if !strings.Contains(ref, "@") {
+		return ref, nil
+	}
+	users, err := c.Users(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, u := range users {
+		if strings.EqualFold(u.Email, ref) {
+			return u.ID, nil
+		}
+	}
+	return "", fmt.Errorf("no user with email %s", ref)
+}
+
+// ===========================================================================
+// Commands
+// ===========================================================================
+
+const usage = `usage: raijinctl [--url URL] [--config FILE] [--json] <command> [args]
+
+commands:
+  health
+  user list
+  user create --email E --name N --password P [--role R]   (--password - reads stdin)
+  user suspend USER [--lift]
+  user set-role USER ROLE
+  token revoke --user USER
+  backup > dump.json
+`
+
+// errUsage makes main print the usage and exit with status 2.
+var errUsage = errors.New("usage")
+
+type cli struct {
+	client *Client
+	out    io.Writer
+	json   bool
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	err := run(ctx, os.Args[1:], os.Stdout)
+	stop()
+	switch {
+	case errors.Is(err, errUsage):
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	case err != nil:
+		fmt.Fprintln(os.Stderr, "raijinctl:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, out io.Writer) error {
+	c := &cli{out: out}
+	fs := flag.NewFlagSet("raijinctl", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	flagURL := fs.String("url", "", "server URL (default $RAIJIN_URL or "+defaultURL+")")
+	configPath := fs.String("config", "", "config file")
+	fs.BoolVar(&c.json, "json", false, "print JSON instead of a table")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	cfg, err := loadConfig(*configPath, *flagURL)
+	if err != nil {
+		return err
+	}
+	cachePath := ""
+	if dir, err := os.UserCacheDir(); err == nil {
+		cachePath = filepath.Join(dir, "raijinctl", "session.json")
+	}
+	c.client = NewClient(cfg, cachePath)
+
+	args = fs.Args()
+	if len(args) == 0 {
+		return errUsage
+	}
+	cmd, args := args[0], args[1:]
+	if (cmd == "user" || cmd == "token") && len(args) > 0 {
+		cmd, args = cmd+" "+args[0], args[1:]
+	}
+	switch cmd {
+	case "health":
+		return c.health(ctx, args)
+	case "user list":
+		return c.userList(ctx, args)
+	case "user create":
+		return c.userCreate(ctx, args)
+	case "user suspend":
+		return c.userSuspend(ctx, args)
+	case "user set-role":
+		return c.userSetRole(ctx, args)
+	case "token revoke":
+		return c.tokenRevoke(ctx, args)
+	case "backup":
+		return c.backup(ctx, args)
+	}
+	return errUsage
+}
+
+// flags returns a command's flag set, which also accepts --json.
+func (c *cli) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.BoolVar(&c.json, "json", c.json, "print JSON instead of a table")
+	return fs
+}
+
+// parse parses args into fs and checks the positional argument count.
+func parse(fs *flag.FlagSet, args []string, positional int) ([]string, error) {
+	if err := fs.Parse(args); err != nil || fs.NArg() != positional {
+		return nil, errUsage
+	}
+	return fs.Args(), nil
+}
+
+func (c *cli) health(ctx context.Context, args []string) error {
+	if _, err := parse(c.flags("health"), args, 0); err != nil {
+		return err
+	}
+	h, err := c.client.Health(ctx)
+	if h.Status == "" {
+		return err
+	}
+	if c.json {
+		c.printJSON(h)
+		return err
+	}
+	maintenance := "off"
+	if h.Maintenance != nil && h.Maintenance.Enabled {
+		maintenance = "on"
+	}
+	c.table([]string{"STATUS", "VERSION", "UPTIME", "MAINTENANCE"}, [][]string{{h.Status, h.Version, h.Uptime, maintenance}})
+	return err
+}
+
+func (c *cli) userList(ctx context.Context, args []string) error {
+	if _, err := parse(c.flags("user list"), args, 0); err != nil {
+		return err
+	}
+	users, err := c.client.Users(ctx)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		c.printJSON(users)
+		return nil
+	}
+	c.printUsers(users...)
+	return nil
+}
+
+func (c *cli) userCreate(ctx context.Context, args []string) error {
+	fs := c.flags("user create")
+	var req api.CreateUserRequest
+	fs.StringVar(&req.Email, "email", "", "email")
+	fs.StringVar(&req.Name, "name", "", "name")
+	fs.StringVar(&req.Password, "password", "", `password ("-" reads a line from stdin)`)
+	fs.StringVar(&req.Role, "role", "user", "role")
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	if req.Password == "-" {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("reading password: %w", err)
+		}
+		req.Password = strings.TrimRight(line, "\r\n")
+	}
+	var user api.User
+	if err := c.client.Do(ctx, http.MethodPost, "/admin/users", req, &user); err != nil {
+		return err
+	}
+	c.printUser(user)
+	return nil
+}
+
+func (c *cli) userSuspend(ctx context.Context, args []string) error {
+	fs := c.flags("user suspend")
+	lift := fs.Bool("lift", false, "lift the suspension instead")
+	// Accept the flags after USER too: user suspend USER --lift.
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		args = append(args[1:], args[0])
+	}
+	pos, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	id, err := c.client.userID(ctx, pos[0])
+	if err != nil {
+		return err
+	}
+	method := http.MethodPost
+	if *lift {
+		method = http.MethodDelete
+	}
+	var user api.User
+	if err := c.client.Do(ctx, method, "/admin/users/"+url.PathEscape(id)+"/suspend", nil, &user); err != nil {
+		return err
+	}
+	c.printUser(user)
+	return nil
+}
+
+func (c *cli) userSetRole(ctx context.Context, args []string) error {
+	pos, err := parse(c.flags("user set-role"), args, 2)
+	if err != nil {
+		return err
+	}
+	id, err := c.client.userID(ctx, pos[0])
+	if err != nil {
+		return err
+	}
+	var user api.User
+	if err := c.client.Do(ctx, http.MethodPut, "/admin/users/"+url.PathEscape(id)+"/role", api.SetRoleRequest{Role: pos[1]}, &user); err != nil {
+		return err
+	}
+	c.printUser(user)
+	return nil
+}
+
+func (c *cli) tokenRevoke(ctx context.Context, args []string) error {
+	fs := c.flags("token revoke")
+	ref := fs.String("user", "", "user ID or email")
+	if _, err := parse(fs, args, 0); err != nil || *ref == "" {
+		return errUsage
+	}
+	id, err := c.client.userID(ctx, *ref)
+	if err != nil {
+		return err
+	}
+	var res api.RevokedTokens
+	if err := c.client.Do(ctx, http.MethodPost, "/admin/users/"+url.PathEscape(id)+"/revoke-tokens", nil, &res); err != nil {
+		return err
+	}
+	if c.json {
+		c.printJSON(res)
+		return nil
+	}
+	fmt.Fprintf(c.out, "revoked %d refresh token(s) of %s\n", res.Revoked, id)
+	return nil
+}
+
+// backup copies the server's dump to the output as is: it is always JSON.
+func (c *cli) backup(ctx context.Context, args []string) error {
+	if _, err := parse(c.flags("backup"), args, 0); err != nil {
+		return err
+	}
+	resp, err := c.client.send(ctx, http.MethodGet, "/admin/backup", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return decode(resp, nil)
+	}
+	_, err = io.Copy(c.out, resp.Body)
+	return err
+}
+
+// ===========================================================================
+// Output
+// ===========================================================================
+
+func (c *cli) printJSON(v any) {
+	enc := json.NewEncoder(c.out)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+func (c *cli) printUser(u api.User) {
+	if c.json {
+		c.printJSON(u)
+		return
+	}
+	c.printUsers(u)
+}
+
+func (c *cli) printUsers(users ...api.User) {
+	rows := make([][]string, 0, len(users))
+	for _, u := range users {
+		rows = append(rows, []string{u.ID, u.Email, u.Name, u.Role, strconv.FormatBool(u.Suspended), u.CreatedAt.Format(time.RFC3339)})
+	}
+	c.table([]string{"ID", "EMAIL", "NAME", "ROLE", "SUSPENDED", "CREATED"}, rows)
+}
+
+func (c *cli) table(header []string, rows [][]string) {
+	tw := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	_ = tw.Flush()
+}
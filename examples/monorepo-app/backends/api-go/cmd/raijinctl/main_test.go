@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/raijintest"
+)
+
+// isolate points the config and cache lookups of run at fresh directories
+// and clears the RAIJIN_ variables.
+func isolate(t *testing.T) {
+	t.Helper()
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	for _, k := range []string{"RAIJINCTL_CONFIG", "RAIJIN_URL", "RAIJIN_EMAIL", "RAIJIN_PASSWORD"} {
+		t.Setenv(k, "")
+	}
+}
+
+// ctl runs raijinctl with args and returns what it printed.
+func ctl(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	err := run(context.Background(), args, &out)
+	return out.String(), err
+}
+
+func TestLoadConfig(t *testing.T) {
+	isolate(t)
+	file := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(file, []byte(`{"url":"http://file:1/","email":"file@example.com","password":"from-file"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := loadConfig("", "")
+	if err != nil || cfg != (Config{URL: defaultURL}) {
+		t.Errorf("no file, no environment: %+v, %v", cfg, err)
+	}
+	cfg, err = loadConfig(file, "")
+	if err != nil || cfg != (Config{URL: "http://file:1", Email: "file@example.com", Password: "from-file"}) {
+		t.Errorf("file: %+v, %v", cfg, err)
+	}
+	t.Setenv("RAIJINCTL_CONFIG", file)
+	t.Setenv("RAIJIN_URL", "http://env:2")
+	t.Setenv("RAIJIN_PASSWORD", "from-env")
+	cfg, err = loadConfig("", "")
+	if err != nil || cfg != (Config{URL: "http://env:2", Email: "file@example.com", Password: "from-env"}) {
+		t.Errorf("environment over $RAIJINCTL_CONFIG: %+v, %v", cfg, err)
+	}
+	cfg, err = loadConfig("", "http://flag:3")
+	if err != nil || cfg.URL != "http://flag:3" {
+		t.Errorf("--url: %+v, %v", cfg, err)
+	}
+
+	// A file that was asked for must exist; the default one need not.
+	if _, err := loadConfig(filepath.Join(t.TempDir(), "nope.json"), ""); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing --config: %v", err)
+	}
+	bad := filepath.Join(t.TempDir(), "bad.json")
+	os.WriteFile(bad, []byte("{"), 0o600)
+	if _, err := loadConfig(bad, ""); err == nil || !strings.Contains(err.Error(), bad) {
+		t.Errorf("malformed --config: %v", err)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	isolate(t)
+	srv := raijintest.NewServer(t)
+	srv.CreateUser(t, "ops@example.com", raijintest.Password, "admin")
+	t.Setenv("RAIJIN_URL", srv.URL)
+	t.Setenv("RAIJIN_EMAIL", "ops@example.com")
+	t.Setenv("RAIJIN_PASSWORD", raijintest.Password)
+
+	out, err := ctl(t, "health")
+	if err != nil || !strings.HasPrefix(out, "STATUS") || !strings.Contains(out, "healthy") {
+		t.Errorf("health: %v\n%s", err, out)
+	}
+
+	out, err = ctl(t, "--json", "user", "create", "--email", "new@example.com", "--name", "New", "--password", "new-user-password")
+	var created api.User
+	if err != nil || json.Unmarshal([]byte(out), &created) != nil || created.Email != "new@example.com" || created.Role != "user" {
+		t.Fatalf("user create: %v\n%s", err, out)
+	}
+
+	out, err = ctl(t, "user", "list")
+	if err != nil || !strings.HasPrefix(out, "ID") || !strings.Contains(out, "new@example.com") || !strings.Contains(out, "ops@example.com") {
+		t.Errorf("user list: %v\n%s", err, out)
+	}
+	out, err = ctl(t, "user", "list", "--json")
+	var users []api.User
+	if err != nil || json.Unmarshal([]byte(out), &users) != nil || len(users) < 2 {
+		t.Errorf("user list --json: %v\n%s", err, out)
+	}
+
+	// USER may be an email or an ID.
+	for _, step := range []struct {
+		args []string
+		ok   func(api.User) bool
+	}{
+		{[]string{"user", "set-role", "new@example.com", "admin"}, func(u api.User) bool { return u.Role == "admin" }},
+		{[]string{"user", "suspend", created.ID}, func(u api.User) bool { return u.Suspended }},
+		{[]string{"user", "suspend", "new@example.com", "--lift"}, func(u api.User) bool { return !u.Suspended }},
+	} {
+		out, err := ctl(t, append([]string{"--json"}, step.args...)...)
+		var user api.User
+		if err != nil || json.Unmarshal([]byte(out), &user) != nil || user.ID != created.ID || !step.ok(user) {
+			t.Errorf("%s: %v\n%s", strings.Join(step.args, " "), err, out)
+		}
+	}
+
+	out, err = ctl(t, "token", "revoke", "--user", "new@example.com")
+	if err != nil || !strings.HasPrefix(out, "revoked ") || !strings.Contains(out, created.ID) {
+		t.Errorf("token revoke: %v\n%s", err, out)
+	}
+
+	out, err = ctl(t, "backup")
+	if err != nil || !json.Valid([]byte(out)) || !strings.Contains(out, "new@example.com") {
+		t.Errorf("backup: %v\n%.200s", err, out)
+	}
+
+	// Server errors come back with their message and code.
+	_, err = ctl(t, "user", "set-role", "new@example.com", "no-such-role")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusBadRequest || !strings.Contains(err.Error(), "(") {
+		t.Errorf("bad role: %v", err)
+	}
+	if _, err := ctl(t, "user", "suspend", "ghost@example.com"); err == nil || !strings.Contains(err.Error(), "no user with email") {
+		t.Errorf("unknown email: %v", err)
+	}
+
+	for _, args := range [][]string{{}, {"nope"}, {"user"}, {"user", "set-role", "x"}, {"token", "revoke"}, {"--bogus", "health"}} {
+		if _, err := ctl(t, args...); !errors.Is(err, errUsage) {
+			t.Errorf("%q: %v, want the usage", args, err)
+		}
+	}
+}
+
+// fakeAPI serves login, refresh and a user list whose first request, when
+// expire is set, is refused with token_expired.
+type fakeAPI struct {
+	logins, refreshes, lists atomic.Int32
+	expire                   atomic.Bool
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	auth := func(n int32) {
+		json.NewEncoder(w).Encode(api.AuthResponseV2{
+			AccessToken: "access-" + strconv.Itoa(int(n)), RefreshToken: "refresh", CSRFToken: "csrf", ExpiresIn: 900,
+		})
+	}
+	switch r.URL.Path {
+	case "/api/v2/auth/login":
+		auth(f.logins.Add(1))
+	case "/api/v2/auth/refresh":
+		auth(10 + f.refreshes.Add(1))
+	case "/api/v2/users":
+		f.lists.Add(1)
+		if f.expire.CompareAndSwap(true, false) {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(api.ProblemDetails{Code: api.ErrCodeTokenExpired})
+			return
+		}
+		if r.Header.Get("X-CSRF-Token") != "csrf" || !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer access-") {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(api.ProblemDetails{Code: api.ErrCodeForbidden, Detail: "bad credentials"})
+			return
+		}
+		json.NewEncoder(w).Encode(api.ListPage[api.User]{Data: []api.User{{ID: "u1", Email: "a@example.com"}}})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// The client logs in once, caches the session, and refreshes it when the
+// server says it expired.
+func TestClientSession(t *testing.T) {
+	fake := &fakeAPI{}
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	cfg := Config{URL: ts.URL, Email: "ops@example.com", Password: "secret"}
+	cache := filepath.Join(t.TempDir(), "raijinctl", "session.json")
+	ctx := context.Background()
+
+	if _, err := NewClient(cfg, cache).Users(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(cache); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Fatalf("session cache: %v, %v", fi, err)
+	}
+
+	// A new run reuses the cached session.
+	c := NewClient(cfg, cache)
+	if _, err := c.Users(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := fake.logins.Load(); n != 1 {
+		t.Errorf("%d logins, want one", n)
+	}
+
+	fake.expire.Store(true)
+	users, err := c.Users(ctx)
+	if err != nil || len(users) != 1 {
+		t.Fatalf("after expiry: %v, %v", users, err)
+	}
+	if fake.refreshes.Load() != 1 || fake.logins.Load() != 1 || fake.lists.Load() != 4 {
+		t.Errorf("logins %d, refreshes %d, lists %d; want 1, 1, 4", fake.logins.Load(), fake.refreshes.Load(), fake.lists.Load())
+	}
+
+	// A session for another server or user is not reused.
+	other := cfg
+	other.Email = "someone@example.com"
+	if _, err := NewClient(other, cache).Users(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := fake.logins.Load(); n != 2 {
+		t.Errorf("%d logins, want a second one for another user", n)
+	}
+
+	if _, err := NewClient(Config{URL: ts.URL}, "").Users(ctx); err == nil || !strings.Contains(err.Error(), "not logged in") {
+		t.Errorf("no credentials: %v", err)
+	}
+}
+
+func TestAPIErrorMessage(t *testing.T) {
+	err := &APIError{Status: http.StatusBadRequest, Problem: api.ProblemDetails{
+		Detail: "invalid request", Code: api.ErrCodeValidationFailed,
+		Errors: []api.FieldError{{Field: "email", Message: "is required"}},
+	}}
+	if got, want := err.Error(), "invalid request ("+api.ErrCodeValidationFailed+")\n  email: is required"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := (&APIError{Status: http.StatusBadGateway}).Error(); got != "Bad Gateway" {
+		t.Errorf("no problem details: %q", got)
+	}
+}
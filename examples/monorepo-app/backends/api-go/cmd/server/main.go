@@ -1,679 +1,411 @@
+// Command server runs the API: it loads the configuration, wires the
+// in-memory store into internal/httpapi and serves it on the configured
+// listeners until SIGINT or SIGTERM, then shuts down gracefully; a second
+// SIGINT or SIGTERM exits at once. SIGHUP reloads the configuration and
+// SIGUSR2 reopens the log files (on Unix).
+//
+// Before listening it runs httpapi.SelfCheck and refuses to start if a
+// check fails. With --check it only runs the checks, prints the report as
+// JSON and exits 0, or 1 if one failed, for a container's init step.
+// Once serving it runs the warmup tasks, and shuts down if a fatal one
+// fails.
+//
+// Under systemd it takes over the sockets of its socket unit instead of
+// binding (named "internal" and "grpc" for those listeners, anything else
+// public) and reports READY=1 once /ready passes, RELOADING=1 around
+// SIGHUP and STOPPING=1 at shutdown, pinging the watchdog when
+// WatchdogSec= is set.
 package main
 
 import (
 	"context"
-	"crypto/hmac"
-	"crypto/rand"
-	"crypto/sha256"
-	"encoding/base64"
-	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io/fs"
 	"log"
+	"maps"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"strings"
-	"sync"
 	"syscall"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+	"github.com/your-org/your-app/backends/api-go/internal/httpapi"
+	"github.com/your-org/your-app/backends/api-go/internal/proxyproto"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+	"github.com/your-org/your-app/backends/api-go/internal/systemd"
 )
 
-// ===========================================================================
-// Build info
-// ===========================================================================
-
+// Set at build time with -ldflags "-X main.Version=... -X main.BuildTime=...
+// -X main.GitCommit=...". Unset values fall back to the module build info.
 var (
 	Version   = "dev"
 	BuildTime = "unknown"
-	startTime = time.Now()
+	GitCommit = "unknown"
 )
 
-// ===========================================================================
-// Configuration
-// ===========================================================================
-
-type Config struct {
-	Port           string
-	Environment    string
-	AllowedOrigins []string
-	JWTSecret      string
-}
-
-func LoadConfig() *Config {
-	origins := getEnv("CORS_ORIGINS", "http://localhost:5173")
-	port := getEnv("SERVER_PORT", "8080")
-	env := getEnv("SERVER_ENVIRONMENT", "development")
-	jwtSecret := getEnv("JWT_SECRET", "dev-jwt-secret-CHANGE-IN-PRODUCTION")
-
-	return &Config{
-		Port:           port,
-		Environment:    env,
-		AllowedOrigins: strings.Split(origins, ","),
-		JWTSecret:      jwtSecret,
-	}
-}
-
-func getEnv(key, fallback string) string {
-	if v := os.Getenv(key); v != "" {
-		return v
-	}
-	return fallback
-}
-
-// ===========================================================================
-// Models
-// ===========================================================================
-
-type User struct {
-	ID        string    `json:"id"`
-	Email     string    `json:"email"`
-	Name      string    `json:"name"`
-	Role      string    `json:"role"`
-	Password  string    `json:"-"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-}
-
-type LoginRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
-}
-
-type RegisterRequest struct {
-	Email    string `json:"email"`
-	Name     string `json:"name"`
-	Password string `json:"password"`
-}
-
-type AuthResponse struct {
-	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
-	User         User   `json:"user"`
-	CSRFToken    string `json:"csrf_token"`
-}
-
-type APIError struct {
-	Error   string `json:"error"`
-	Message string `json:"message"`
-	Code    int    `json:"code"`
-}
-
-type HealthResponse struct {
-	Status    string `json:"status"`
-	Version   string `json:"version"`
-	Timestamp string `json:"timestamp"`
-	Uptime    string `json:"uptime"`
-}
-
-// ===========================================================================
-// In-Memory Store (swap for PostgreSQL/pgx in production)
-// ===========================================================================
-
-type Store struct {
-	mu            sync.RWMutex
-	users         map[string]*User
-	emailIndex    map[string]string
-	refreshTokens map[string]string
-	csrfTokens    map[string]time.Time
-}
-
-func NewStore() *Store {
-	s := &Store{
-		users:         make(map[string]*User),
-		emailIndex:    make(map[string]string),
-		refreshTokens: make(map[string]string),
-		csrfTokens:    make(map[string]time.Time),
+// listen opens addr, which is either a TCP address (":8080", "tcp://:8080")
+// or a Unix socket ("unix:///var/run/raijin.sock") created with mode.
+func listen(addr string, mode os.FileMode) (net.Listener, error) {
+	addr = strings.TrimSpace(addr)
+	path, ok := strings.CutPrefix(addr, "unix://")
+	if !ok {
+		return net.Listen("tcp", strings.TrimPrefix(addr, "tcp://"))
 	}
-
-	hashedPw, _ := bcrypt.GenerateFromPassword([]byte("admin123"), bcrypt.DefaultCost)
-	adminID := generateID()
-	now := time.Now()
-	s.users[adminID] = &User{
-		ID: adminID, Email: "admin@example.com", Name: "Admin",
-		Role: "admin", Password: string(hashedPw),
-		CreatedAt: now, UpdatedAt: now,
-	}
-	s.emailIndex["admin@example.com"] = adminID
-
-	return s
-}
-
-func (s *Store) CreateUser(email, name, password, role string) (*User, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if _, exists := s.emailIndex[email]; exists {
-		return nil, fmt.Errorf("email already registered")
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
 	}
-	hashedPw, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	ln, err := net.Listen("unix", path)
 	if err != nil {
 		return nil, err
 	}
-	id := generateID()
-	now := time.Now()
-	user := &User{
-		ID: id, Email: email, Name: name, Role: role,
-		Password: string(hashedPw), CreatedAt: now, UpdatedAt: now,
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, err
 	}
-	s.users[id] = user
-	s.emailIndex[email] = id
-	return user, nil
+	return ln, nil
 }
 
-func (s *Store) GetUserByEmail(email string) (*User, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	id, ok := s.emailIndex[email]
-	if !ok {
-		return nil, fmt.Errorf("user not found")
+// removeStaleSocket deletes a socket file left behind by a previous run,
+// refusing if another process is still accepting on it.
+func removeStaleSocket(path string) error {
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return nil
 	}
-	return s.users[id], nil
-}
-
-func (s *Store) GetUserByID(id string) (*User, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	user, ok := s.users[id]
-	if !ok {
-		return nil, fmt.Errorf("user not found")
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("socket %s is in use", path)
 	}
-	return user, nil
-}
-
-func (s *Store) ListUsers() []*User {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	users := make([]*User, 0, len(s.users))
-	for _, u := range s.users {
-		users = append(users, u)
-	}
-	return users
+	return os.Remove(path)
 }
 
-func (s *Store) StoreRefreshToken(token, userID string) {
-	s.mu.Lock()
-	s.refreshTokens[token] = userID
-	s.mu.Unlock()
-}
-func (s *Store) ValidateRefreshToken(token string) (string, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	uid, ok := s.refreshTokens[token]
-	return uid, ok
-}
-func (s *Store) RevokeRefreshToken(token string) {
-	s.mu.Lock()
-	delete(s.refreshTokens, token)
-	s.mu.Unlock()
-}
-func (s *Store) StoreCSRFToken(token string) {
-	s.mu.Lock()
-	s.csrfTokens[token] = time.Now().Add(24 * time.Hour)
-	s.mu.Unlock()
-}
-func (s *Store) ValidateCSRFToken(token string) bool {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	exp, ok := s.csrfTokens[token]
-	return ok && time.Now().Before(exp)
+// notify reports state to systemd, logging a failure.
+func notify(state string) {
+	if err := systemd.Notify(state); err != nil {
+		log.Printf("systemd notify %s: %v", state, err)
+	}
 }
 
-// ===========================================================================
-// JWT  (HS256 — stdlib only, zero deps)
-// ===========================================================================
-
-type JWTClaims struct {
-	UserID string `json:"sub"`
-	Email  string `json:"email"`
-	Role   string `json:"role"`
-	Exp    int64  `json:"exp"`
-	Iat    int64  `json:"iat"`
+// shutdownAll gracefully stops every non-nil server concurrently under the
+// same deadline.
+func shutdownAll(ctx context.Context, servers ...*http.Server) error {
+	errs := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			if srv == nil {
+				errs <- nil
+				return
+			}
+			errs <- srv.Shutdown(ctx)
+		}(srv)
+	}
+	var all []error
+	for range servers {
+		all = append(all, <-errs)
+	}
+	return errors.Join(all...)
 }
 
-func createJWT(secret string, claims JWTClaims) (string, error) {
-	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
-	claimsJSON, err := json.Marshal(claims)
+// reloadConfig loads and validates the configuration again and swaps its
+// reloadable settings into api, which it leaves alone on error.
+func reloadConfig(api *httpapi.Server) error {
+	next, err := config.Load()
+	if err == nil {
+		err = next.Validate()
+	}
 	if err != nil {
-		return "", err
-	}
-	payload := base64.RawURLEncoding.EncodeToString(claimsJSON)
-	signingInput := header + "." + payload
-	mac := hmac.New(sha256.New, []byte(secret))
-	mac.Write([]byte(signingInput))
-	signature := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
-	return signingInput + "." + signature, nil
+		return err
+	}
+	api.Reload(next)
+	return nil
 }
 
-func verifyJWT(secret, tokenStr string) (*JWTClaims, error) {
-	parts := strings.Split(tokenStr, ".")
-	if len(parts) != 3 {
-		return nil, fmt.Errorf("invalid token format")
-	}
-	signingInput := parts[0] + "." + parts[1]
-	mac := hmac.New(sha256.New, []byte(secret))
-	mac.Write([]byte(signingInput))
-	expectedSig := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
-	if !hmac.Equal([]byte(parts[2]), []byte(expectedSig)) {
-		return nil, fmt.Errorf("invalid signature")
-	}
-	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
-	if err != nil {
-		return nil, fmt.Errorf("invalid payload")
-	}
-	var claims JWTClaims
-	if err := json.Unmarshal(claimsJSON, &claims); err != nil {
-		return nil, fmt.Errorf("invalid claims")
+// publicServer is the server for the public listeners.
+func publicServer(cfg *config.Config, h http.Handler) *http.Server {
+	srv := &http.Server{
+		Handler:           h,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    1 << 20,
 	}
-	if time.Now().Unix() > claims.Exp {
-		return nil, fmt.Errorf("token expired")
+	if cfg.EnableH2C {
+		// Prior-knowledge HTTP/2 over cleartext alongside HTTP/1.1. Over
+		// HTTP/2 the read/write timeouts apply per stream, not per connection.
+		srv.Protocols = new(http.Protocols)
+		srv.Protocols.SetHTTP1(true)
+		srv.Protocols.SetUnencryptedHTTP2(true)
 	}
-	return &claims, nil
-}
-
-// ===========================================================================
-// Utility
-// ===========================================================================
-
-func generateID() string {
-	b := make([]byte, 16)
-	_, _ = rand.Read(b)
-	return hex.EncodeToString(b)
-}
-
-func generateToken() string {
-	b := make([]byte, 32)
-	_, _ = rand.Read(b)
-	return hex.EncodeToString(b)
-}
-
-// ===========================================================================
-// Middleware
-// ===========================================================================
-
-type contextKey string
-
-const (
-	ctxUserID contextKey = "user_id"
-	ctxEmail  contextKey = "email"
-	ctxRole   contextKey = "role"
-)
-
-type Middleware struct {
-	cfg   *Config
-	store *Store
+	return srv
 }
 
-func NewMiddleware(cfg *Config, store *Store) *Middleware {
-	return &Middleware{cfg: cfg, store: store}
+// runCheck prints the self-check report of cfg, or of the error loading
+// it, and returns the exit code.
+func runCheck(cfg *config.Config, loadErr error) int {
+	report := httpapi.SelfCheckReport{Status: httpapi.SelfCheckFail}
+	if loadErr != nil {
+		report.Checks = []httpapi.SelfCheckResult{{Name: "config", Status: httpapi.SelfCheckFail, Detail: loadErr.Error()}}
+	} else {
+		report = httpapi.SelfCheck(context.Background(), cfg, store.NewMemory())
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+	if report.Status == httpapi.SelfCheckFail {
+		return 1
+	}
+	return 0
 }
 
-func (m *Middleware) SecurityHeaders(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("X-Content-Type-Options", "nosniff")
-		w.Header().Set("X-Frame-Options", "DENY")
-		w.Header().Set("X-XSS-Protection", "1; mode=block")
-		w.Header().Set("Referrer-Policy", "strict-origin-when-cross-origin")
-		w.Header().Set("Permissions-Policy", "camera=(), microphone=(), geolocation=()")
-		w.Header().Set("Content-Security-Policy",
-			"default-src 'none'; script-src 'self'; style-src 'self' 'unsafe-inline'; "+
-				"img-src 'self' data:; font-src 'self'; connect-src 'self'; "+
-				"base-uri 'self'; form-action 'self'; frame-ancestors 'none'")
-		if m.cfg.Environment == "production" {
-			w.Header().Set("Strict-Transport-Security", "max-age=63072000; includeSubDomains; preload")
+func main() {
+	check := flag.Bool("check", false, "run the startup self-check, print it as JSON and exit")
+	flag.Parse()
+	httpapi.Version, httpapi.BuildTime, httpapi.GitCommit = Version, BuildTime, GitCommit
+	cfg, err := config.Load()
+	if *check {
+		os.Exit(runCheck(cfg, err))
+	}
+	if err != nil {
+		log.Fatalf("Config: %v", err)
+	}
+	st := store.NewMemory()
+	report := httpapi.SelfCheck(context.Background(), cfg, st)
+	for _, c := range report.Warnings() {
+		log.Printf("WARN self-check %s: %s", c.Name, c.Detail)
+	}
+	if failed := report.Failed(); len(failed) > 0 {
+		var msgs []string
+		for _, c := range failed {
+			msgs = append(msgs, c.Name+": "+c.Detail)
 		}
-		next.ServeHTTP(w, r)
-	})
-}
+		log.Fatalf("Self-check failed, not starting:\n%s", strings.Join(msgs, "\n"))
+	}
+	if effective, err := json.Marshal(cfg.Effective()); err == nil {
+		log.Printf("Effective config: %s", effective)
+	}
+	api, err := httpapi.New(cfg, st)
+	if err != nil {
+		log.Fatalf("Server: %v", err)
+	}
 
-func (m *Middleware) CORS(next http.Handler) http.Handler {
-	allowed := make(map[string]bool)
-	for _, o := range m.cfg.AllowedOrigins {
-		allowed[strings.TrimSpace(o)] = true
-	}
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		origin := r.Header.Get("Origin")
-		if origin != "" && allowed[origin] {
-			w.Header().Set("Access-Control-Allow-Origin", origin)
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-CSRF-Token, X-Request-ID")
-			w.Header().Set("Access-Control-Allow-Credentials", "true")
-			w.Header().Set("Access-Control-Max-Age", "86400")
-			w.Header().Set("Vary", "Origin")
+	var grpcSrv *http.Server
+	if api.GRPC != nil {
+		grpcSrv = &http.Server{
+			Handler:           api.GRPC,
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+			IdleTimeout:       cfg.IdleTimeout,
+			Protocols:         new(http.Protocols),
 		}
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusNoContent)
-			return
+		grpcSrv.Protocols.SetUnencryptedHTTP2(true)
+	}
+	var internalSrv *http.Server
+	if api.Internal != nil {
+		// Profiles run for up to 30s by default, past the public WriteTimeout.
+		internalSrv = &http.Server{
+			Handler:           api.Internal,
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      2 * time.Minute,
 		}
-		next.ServeHTTP(w, r)
-	})
-}
+	}
+	srv := publicServer(cfg, api.Handler)
 
-func (m *Middleware) Auth(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		h := r.Header.Get("Authorization")
-		if h == "" {
-			writeError(w, http.StatusUnauthorized, "missing authorization header")
-			return
-		}
-		parts := strings.SplitN(h, " ", 2)
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			writeError(w, http.StatusUnauthorized, "invalid authorization format")
-			return
+	// Sockets from systemd replace the configured addresses, and their
+	// files belong to the socket unit: they are not removed on exit.
+	activated, err := systemd.Listeners()
+	if err != nil {
+		log.Fatalf("Socket activation: %v", err)
+	}
+	inherited := make(map[string]bool)
+	for _, lns := range activated {
+		for _, ln := range lns {
+			inherited[ln.Addr().String()] = true
 		}
-		claims, err := verifyJWT(m.cfg.JWTSecret, parts[1])
-		if err != nil {
-			writeError(w, http.StatusUnauthorized, "invalid or expired token")
-			return
+	}
+	takeActivated := func(name string, enabled bool) net.Listener {
+		lns := activated[name]
+		delete(activated, name)
+		if len(lns) == 0 {
+			return nil
 		}
-		ctx := context.WithValue(r.Context(), ctxUserID, claims.UserID)
-		ctx = context.WithValue(ctx, ctxEmail, claims.Email)
-		ctx = context.WithValue(ctx, ctxRole, claims.Role)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
-}
-
-func (m *Middleware) CSRFProtection(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
-			next.ServeHTTP(w, r)
-			return
+		if !enabled {
+			log.Fatalf("Socket activation: %q socket passed, but that server is off", name)
 		}
-		token := r.Header.Get("X-CSRF-Token")
-		if token == "" || !m.store.ValidateCSRFToken(token) {
-			writeError(w, http.StatusForbidden, "invalid or missing CSRF token")
-			return
+		if len(lns) > 1 {
+			log.Fatalf("Socket activation: %d %q sockets passed, want one", len(lns), name)
 		}
-		next.ServeHTTP(w, r)
-	})
-}
+		return lns[0]
+	}
+	internalLn := takeActivated("internal", internalSrv != nil)
+	grpcLn := takeActivated("grpc", grpcSrv != nil)
 
-func (m *Middleware) RequireRole(role string) func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			userRole, _ := r.Context().Value(ctxRole).(string)
-			if userRole != role {
-				writeError(w, http.StatusForbidden, "insufficient permissions")
-				return
+	var listeners []net.Listener
+	for _, name := range slices.Sorted(maps.Keys(activated)) {
+		listeners = append(listeners, activated[name]...)
+	}
+	if len(listeners) == 0 {
+		for _, addr := range cfg.Listen {
+			ln, err := listen(addr, cfg.SocketMode)
+			if err != nil {
+				log.Fatalf("Listen %s: %v", addr, err)
 			}
-			next.ServeHTTP(w, r)
-		})
+			listeners = append(listeners, ln)
+		}
+	}
+	if cfg.ProxyProtocol.Enabled {
+		// Validate checked the CIDRs. A TLS listener would wrap these.
+		allowed, _ := config.ParseCIDRs(cfg.ProxyProtocol.Allowed)
+		for i, ln := range listeners {
+			listeners[i] = &proxyproto.Listener{Listener: ln, Timeout: cfg.ProxyProtocol.Timeout, Allowed: allowed}
+		}
+	}
+	if internalSrv != nil && internalLn == nil {
+		if internalLn, err = listen(cfg.InternalAddr, cfg.SocketMode); err != nil {
+			log.Fatalf("Listen internal %s: %v", cfg.InternalAddr, err)
+		}
+	}
+	if grpcSrv != nil && grpcLn == nil {
+		if grpcLn, err = listen(cfg.GRPCAddr, cfg.SocketMode); err != nil {
+			log.Fatalf("Listen gRPC %s: %v", cfg.GRPCAddr, err)
+		}
 	}
-}
 
-// RateLimiter — simple in-memory, use Redis in production
-type RateLimiter struct {
-	mu       sync.Mutex
-	requests map[string][]time.Time
-	limit    int
-	window   time.Duration
-}
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
-func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
-	rl := &RateLimiter{requests: make(map[string][]time.Time), limit: limit, window: window}
+	// SIGHUP reloads the configuration. On error the running configuration
+	// is left untouched.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 	go func() {
-		for range time.Tick(5 * time.Minute) {
-			rl.mu.Lock()
-			now := time.Now()
-			for k, times := range rl.requests {
-				var valid []time.Time
-				for _, t := range times {
-					if now.Sub(t) < rl.window {
-						valid = append(valid, t)
-					}
-				}
-				if len(valid) == 0 {
-					delete(rl.requests, k)
-				} else {
-					rl.requests[k] = valid
-				}
+		for range hup {
+			notify("RELOADING=1")
+			if err := reloadConfig(api); err != nil {
+				log.Printf("Reload failed, keeping current configuration:\n%v", err)
 			}
-			rl.mu.Unlock()
+			notify("READY=1")
 		}
 	}()
-	return rl
-}
 
-func (rl *RateLimiter) Wrap(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := r.RemoteAddr
-		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
-			ip = strings.Split(fwd, ",")[0]
+	build := httpapi.Build()
+	log.Printf("API server (env=%s, version=%s, commit=%s)", cfg.Environment, build.Version, build.GitCommit)
+	for _, ln := range listeners {
+		from := ""
+		if inherited[ln.Addr().String()] {
+			from = " (from systemd)"
 		}
-		rl.mu.Lock()
-		now := time.Now()
-		var valid []time.Time
-		for _, t := range rl.requests[ip] {
-			if now.Sub(t) < rl.window {
-				valid = append(valid, t)
-			}
-		}
-		if len(valid) >= rl.limit {
-			rl.mu.Unlock()
-			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(rl.window.Seconds())))
-			writeError(w, http.StatusTooManyRequests, "rate limit exceeded")
-			return
-		}
-		rl.requests[ip] = append(valid, now)
-		rl.mu.Unlock()
-		next.ServeHTTP(w, r)
-	})
-}
-
-// RequestLogger logs requests
-func RequestLogger(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		rec := &statusRecorder{ResponseWriter: w, code: 200}
-		next.ServeHTTP(rec, r)
-		log.Printf("[%s] %d %s %s %v", time.Now().Format("15:04:05"), rec.code, r.Method, r.URL.Path, time.Since(start))
-	})
-}
-
-type statusRecorder struct {
-	http.ResponseWriter
-	code int
-}
-
-func (sr *statusRecorder) WriteHeader(code int) { sr.code = code; sr.ResponseWriter.WriteHeader(code) }
-
-// ===========================================================================
-// Handlers
-// ===========================================================================
-
-type Handlers struct {
-	cfg   *Config
-	store *Store
-}
-
-func NewHandlers(cfg *Config, store *Store) *Handlers {
-	return &Handlers{cfg: cfg, store: store}
-}
-
-func (h *Handlers) Health(w http.ResponseWriter, _ *http.Request) {
-	writeJSON(w, http.StatusOK, HealthResponse{
-		Status: "healthy", Version: Version,
-		Timestamp: time.Now().UTC().Format(time.RFC3339),
-		Uptime:    time.Since(startTime).Round(time.Second).String(),
-	})
-}
-
-func (h *Handlers) Ready(w http.ResponseWriter, _ *http.Request) {
-	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
-}
-
-func (h *Handlers) Register(w http.ResponseWriter, r *http.Request) {
-	var req RegisterRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid request body")
-		return
-	}
-	if req.Email == "" || req.Password == "" || req.Name == "" {
-		writeError(w, http.StatusBadRequest, "email, name and password are required")
-		return
-	}
-	if len(req.Password) < 8 {
-		writeError(w, http.StatusBadRequest, "password must be at least 8 characters")
-		return
-	}
-	user, err := h.store.CreateUser(req.Email, req.Name, req.Password, "user")
-	if err != nil {
-		writeError(w, http.StatusConflict, err.Error())
-		return
-	}
-	h.respondAuth(w, http.StatusCreated, user)
-}
-
-func (h *Handlers) Login(w http.ResponseWriter, r *http.Request) {
-	var req LoginRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid request body")
-		return
-	}
-	user, err := h.store.GetUserByEmail(req.Email)
-	if err != nil {
-		writeError(w, http.StatusUnauthorized, "invalid credentials")
-		return
+		log.Printf("  Listening on %s://%s%s", ln.Addr().Network(), ln.Addr(), from)
 	}
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
-		writeError(w, http.StatusUnauthorized, "invalid credentials")
-		return
+	api.LogSummary()
+	if api.LogsToFiles() {
+		reopenLogsOnSignal(api)
 	}
-	h.respondAuth(w, http.StatusOK, user)
-}
-
-func (h *Handlers) RefreshToken(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		RefreshToken string `json:"refresh_token"`
+	if internalSrv != nil {
+		log.Printf("  Internal (/metrics, /debug/) on %s://%s", internalLn.Addr().Network(), internalLn.Addr())
+		go func() {
+			if err := internalSrv.Serve(internalLn); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Internal server error: %v", err)
+			}
+		}()
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid request body")
-		return
+	if grpcSrv != nil {
+		log.Printf("  gRPC (h2c) on %s://%s", grpcLn.Addr().Network(), grpcLn.Addr())
+		go func() {
+			if err := grpcSrv.Serve(grpcLn); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("gRPC server error: %v", err)
+			}
+		}()
 	}
-	userID, ok := h.store.ValidateRefreshToken(req.RefreshToken)
-	if !ok {
-		writeError(w, http.StatusUnauthorized, "invalid refresh token")
-		return
+	if cfg.EnablePprof {
+		log.Printf("  pprof: enabled on /debug/pprof/")
 	}
-	h.store.RevokeRefreshToken(req.RefreshToken)
-	user, err := h.store.GetUserByID(userID)
-	if err != nil {
-		writeError(w, http.StatusUnauthorized, "user not found")
-		return
+	for _, ln := range listeners {
+		go func(ln net.Listener) {
+			if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Server error: %v", err)
+			}
+		}(ln)
 	}
-	h.respondAuth(w, http.StatusOK, user)
-}
 
-func (h *Handlers) GetCurrentUser(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value(ctxUserID).(string)
-	user, err := h.store.GetUserByID(userID)
-	if err != nil {
-		writeError(w, http.StatusNotFound, "user not found")
-		return
+	// Warm up while serving, so probes see /ready fail until it is done
+	// rather than a refused connection.
+	ready, cancelReady := context.WithCancel(context.Background())
+	warmupFailed := make(chan error, 1)
+	go func() {
+		if err := api.Warmup(ready); err != nil {
+			warmupFailed <- err
+		}
+	}()
+	// systemd starts the units ordered after this one on READY=1, so it
+	// waits for the checks a load balancer would wait for.
+	if systemd.Notifying() {
+		go func() {
+			for !api.Ready(ready) {
+				select {
+				case <-ready.Done():
+					return
+				case <-time.After(time.Second):
+				}
+			}
+			notify("READY=1")
+		}()
 	}
-	writeJSON(w, http.StatusOK, user)
-}
-
-func (h *Handlers) ListUsers(w http.ResponseWriter, _ *http.Request) {
-	users := h.store.ListUsers()
-	writeJSON(w, http.StatusOK, map[string]interface{}{"users": users, "total": len(users)})
-}
-
-func (h *Handlers) respondAuth(w http.ResponseWriter, status int, user *User) {
-	accessToken, _ := createJWT(h.cfg.JWTSecret, JWTClaims{
-		UserID: user.ID, Email: user.Email, Role: user.Role,
-		Exp: time.Now().Add(15 * time.Minute).Unix(), Iat: time.Now().Unix(),
-	})
-	refreshToken := generateToken()
-	h.store.StoreRefreshToken(refreshToken, user.ID)
-	csrfToken := generateToken()
-	h.store.StoreCSRFToken(csrfToken)
-	writeJSON(w, status, AuthResponse{
-		AccessToken: accessToken, RefreshToken: refreshToken,
-		User: *user, CSRFToken: csrfToken,
-	})
-}
-
-// ===========================================================================
-// Response helpers
-// ===========================================================================
-
-func writeJSON(w http.ResponseWriter, status int, data interface{}) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(status)
-	_ = json.NewEncoder(w).Encode(data)
-}
-
-func writeError(w http.ResponseWriter, status int, message string) {
-	writeJSON(w, status, APIError{Error: http.StatusText(status), Message: message, Code: status})
-}
-
-// ===========================================================================
-// Main
-// ===========================================================================
-
-func main() {
-	cfg := LoadConfig()
-	store := NewStore()
-	handlers := NewHandlers(cfg, store)
-	mw := NewMiddleware(cfg, store)
-
-	authRL := NewRateLimiter(10, time.Minute)
-	apiRL := NewRateLimiter(100, time.Minute)
-
-	mux := http.NewServeMux()
-
-	// Public
-	mux.HandleFunc("GET /health", handlers.Health)
-	mux.HandleFunc("GET /ready", handlers.Ready)
-
-	// Auth (rate limited)
-	mux.Handle("POST /api/v1/auth/register", authRL.Wrap(http.HandlerFunc(handlers.Register)))
-	mux.Handle("POST /api/v1/auth/login", authRL.Wrap(http.HandlerFunc(handlers.Login)))
-	mux.Handle("POST /api/v1/auth/refresh", authRL.Wrap(http.HandlerFunc(handlers.RefreshToken)))
-
-	// Protected
-	protect := func(h http.HandlerFunc) http.Handler {
-		return apiRL.Wrap(mw.Auth(mw.CSRFProtection(http.HandlerFunc(h))))
+	if interval := systemd.WatchdogInterval(); interval > 0 {
+		go func() {
+			for range time.Tick(interval) {
+				notify("WATCHDOG=1")
+			}
+		}()
 	}
-	mux.Handle("GET /api/v1/users/me", protect(handlers.GetCurrentUser))
-	mux.Handle("GET /api/v1/users", protect(mw.RequireRole("admin")(http.HandlerFunc(handlers.ListUsers)).ServeHTTP))
 
-	// Apply global middleware
-	var handler http.Handler = mux
-	handler = mw.CORS(handler)
-	handler = mw.SecurityHeaders(handler)
-	handler = RequestLogger(handler)
-
-	srv := &http.Server{
-		Addr:              ":" + cfg.Port,
-		Handler:           handler,
-		ReadTimeout:       10 * time.Second,
-		ReadHeaderTimeout: 5 * time.Second,
-		WriteTimeout:      15 * time.Second,
-		IdleTimeout:       120 * time.Second,
-		MaxHeaderBytes:    1 << 20,
+	exitCode := 0
+	select {
+	case <-quit:
+	case err := <-warmupFailed:
+		log.Printf("Warmup failed, shutting down:\n%v", err)
+		exitCode = 1
 	}
-
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-
+	cancelReady()
+	notify("STOPPING=1")
 	go func() {
-		log.Printf("API server on :%s (env=%s, version=%s)", cfg.Port, cfg.Environment, Version)
-		log.Printf("  CORS origins: %v", cfg.AllowedOrigins)
-		log.Printf("  Demo user: admin@example.com / admin123")
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server error: %v", err)
-		}
+		<-quit
+		log.Printf("Second signal, exiting without finishing the shutdown")
+		os.Exit(1)
 	}()
-
-	<-quit
-	log.Println("Shutting down...")
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	// Fail readiness first so load balancers stop routing here, give them
+	// DRAIN_DELAY to notice, then stop accepting and wait for what's left.
+	// A preStop hook on POST /internal/drain may have waited already.
+	if since := api.Drain(); since > 0 {
+		log.Printf("Draining since %v ago (delay %v, grace %v)...", since.Round(time.Millisecond), cfg.DrainDelay, cfg.DrainGrace)
+		time.Sleep(cfg.DrainDelay - since)
+	} else {
+		log.Printf("Draining (delay %v, grace %v)...", cfg.DrainDelay, cfg.DrainGrace)
+		time.Sleep(cfg.DrainDelay)
+	}
+	log.Printf("Shutting down, waiting for %d in-flight requests...", api.InFlight())
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatalf("Forced shutdown: %v", err)
+	api.CloseStreams(ctx)
+	if err := shutdownAll(ctx, srv, internalSrv, grpcSrv); err != nil {
+		log.Printf("Forced shutdown with %d requests in flight: %v", api.InFlight(), err)
+		exitCode = 1
+	}
+	if err := api.Close(ctx); err != nil {
+		exitCode = 1
+	}
+	for _, ln := range append(listeners, internalLn, grpcLn) {
+		if ln != nil && ln.Addr().Network() == "unix" && !inherited[ln.Addr().String()] {
+			if err := os.Remove(ln.Addr().String()); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				log.Printf("Remove socket: %v", err)
+			}
+		}
 	}
 	log.Println("Server exited")
+	os.Exit(exitCode)
 }
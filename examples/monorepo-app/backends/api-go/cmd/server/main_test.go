@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+	"github.com/your-org/your-app/backends/api-go/internal/httpapi"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+)
+
+func testConfig() *config.Config {
+	cfg := config.Defaults()
+	cfg.Environment = "test"
+	cfg.JWTSecret = "main-test-jwt-secret-not-for-production"
+	cfg.AuditLogOutput = "off"
+	cfg.AccessLogOutput = os.DevNull
+	return cfg
+}
+
+// serve runs srv on a loopback port until the test ends and returns its
+// URL.
+func serve(t *testing.T, srv *http.Server) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return "http://" + ln.Addr().String()
+}
+
+// h2cClient speaks HTTP/2 with prior knowledge and nothing else.
+func h2cClient() *http.Client {
+	tr := &http.Transport{Protocols: new(http.Protocols)}
+	tr.Protocols.SetUnencryptedHTTP2(true)
+	return &http.Client{Transport: tr, Timeout: 5 * time.Second}
+}
+
+func TestPublicServerH2C(t *testing.T) {
+	cfg := testConfig()
+	cfg.EnableH2C = true
+	api, err := httpapi.New(cfg, store.NewMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := api.Warmup(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		api.Drain()
+		api.CloseStreams(ctx)
+		api.Close(ctx)
+	})
+	url := serve(t, publicServer(cfg, api.Handler))
+	h2 := h2cClient()
+
+	for _, c := range []struct {
+		name   string
+		client *http.Client
+		proto  int
+	}{{"HTTP/2", h2, 2}, {"HTTP/1.1", &http.Client{Timeout: 5 * time.Second}, 1}} {
+		resp, err := c.client.Get(url + "/health")
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || resp.ProtoMajor != c.proto {
+			t.Errorf("%s: %d over %s", c.name, resp.StatusCode, resp.Proto)
+		}
+		if resp.Header.Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("%s: the middleware did not run", c.name)
+		}
+	}
+
+	// The middleware chain keeps http.Flusher over HTTP/2: a live stream
+	// sends its first event before the handler returns.
+	resp, err := h2.Post(url+"/api/v1/auth/login", "application/json",
+		strings.NewReader(`{"email":"admin@example.com","password":"admin123"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var login struct {
+		AccessToken string `json:"access_token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&login)
+	resp.Body.Close()
+	if err != nil || login.AccessToken == "" {
+		t.Fatalf("login: %d %v", resp.StatusCode, err)
+	}
+	req, _ := http.NewRequest("GET", url+"/api/v1/events", nil)
+	req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+	stream := &http.Client{Transport: h2.Transport}
+	resp, err = stream.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ProtoMajor != 2 {
+		t.Fatalf("events: %d over %s", resp.StatusCode, resp.Proto)
+	}
+	line := make(chan string, 1)
+	go func() {
+		s, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		line <- s
+	}()
+	select {
+	case s := <-line:
+		if s == "" {
+			t.Error("the event stream ended without data")
+		}
+	case <-time.After(3 * time.Second):
+		t.Error("nothing flushed on the event stream")
+	}
+}
+
+func TestPublicServerWithoutH2C(t *testing.T) {
+	url := serve(t, publicServer(testConfig(), http.NotFoundHandler()))
+	if resp, err := h2cClient().Get(url); err == nil {
+		resp.Body.Close()
+		t.Errorf("HTTP/2 served without ENABLE_H2C: %s", resp.Proto)
+	}
+}
+
+// Over HTTP/2 WriteTimeout bounds each stream: a slow response is cut
+// off, and the connection keeps serving past the timeout.
+func TestPublicServerH2CWriteTimeout(t *testing.T) {
+	cfg := testConfig()
+	cfg.EnableH2C = true
+	cfg.WriteTimeout = 200 * time.Millisecond
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(3 * cfg.WriteTimeout)
+		w.Write([]byte("late"))
+	})
+	mux.HandleFunc("/fast", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) })
+	url := serve(t, publicServer(cfg, mux))
+	client := h2cClient()
+
+	var conns []string
+	get := func(path string) error {
+		trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) {
+			conns = append(conns, info.Conn.LocalAddr().String())
+		}}
+		req, _ := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), "GET", url+path, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, err = io.ReadAll(resp.Body)
+		return err
+	}
+
+	if err := get("/fast"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * cfg.WriteTimeout)
+	if err := get("/fast"); err != nil {
+		t.Fatalf("a stream opened past the timeout: %v", err)
+	}
+	if err := get("/slow"); err == nil {
+		t.Error("a stream outlived WriteTimeout")
+	}
+	if err := get("/fast"); err != nil {
+		t.Fatalf("after a stream timed out: %v", err)
+	}
+	for _, c := range conns[1:] {
+		if c != conns[0] {
+			t.Errorf("requests went over %v, want one connection", conns)
+			break
+		}
+	}
+}
+
+// shutdownAll stops every server under one deadline: a stuck request on
+// each costs the deadline once, not once per server.
+func TestShutdownAllSharesDeadline(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	stuck := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-release })
+	var servers []*http.Server
+	for range 2 {
+		srv := &http.Server{Handler: stuck}
+		url := serve(t, srv)
+		go func() {
+			if resp, err := http.Get(url); err == nil {
+				resp.Body.Close()
+			}
+		}()
+		servers = append(servers, srv)
+	}
+	time.Sleep(100 * time.Millisecond) // let both requests arrive
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := shutdownAll(ctx, servers[0], nil, servers[1])
+	if elapsed := time.Since(start); elapsed > 550*time.Millisecond {
+		t.Errorf("shutdown took %v, want about one 300ms deadline", elapsed)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want the deadline", err)
+	}
+
+	idle := &http.Server{Handler: stuck}
+	serve(t, idle)
+	if err := shutdownAll(context.Background(), idle, nil); err != nil {
+		t.Errorf("idle server: %v", err)
+	}
+}
+
+// A second server on a busy address fails to bind with an error naming
+// the address, which main reports before serving anything.
+func TestListenFailsOnBusyAddress(t *testing.T) {
+	ln, err := listen("127.0.0.1:0", 0o660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	for _, addr := range []string{ln.Addr().String(), "tcp://" + ln.Addr().String()} {
+		if ln2, err := listen(addr, 0o660); err == nil {
+			ln2.Close()
+			t.Errorf("%s: bound twice", addr)
+		} else if !strings.Contains(err.Error(), ln.Addr().String()) {
+			t.Errorf("%s: %v does not name the address", addr, err)
+		}
+	}
+
+	sock := filepath.Join(t.TempDir(), "api.sock")
+	uln, err := listen("unix://"+sock, 0o660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uln.Close()
+	if _, err := listen("unix://"+sock, 0o660); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("a socket in use: %v", err)
+	}
+}
+
+// TestReloadConfig drives what SIGHUP runs: new CORS origins and rate
+// limits apply to the running server, a setting that needs a restart is
+// only warned about, and a configuration that fails to load changes
+// nothing.
+func TestReloadConfig(t *testing.T) {
+	for k, v := range map[string]string{
+		"CONFIG_FILE":        "",
+		"SERVER_ENVIRONMENT": "test",
+		"JWT_SECRET":         "reload-test-jwt-secret-not-for-production",
+		"AUDIT_LOG_OUTPUT":   "off",
+		"ACCESS_LOG_OUTPUT":  os.DevNull,
+		"CORS_ORIGINS":       "https://a.example.com",
+		"RATE_LIMIT_BUCKETS": "auth:2/1m:ip, api:1000/1m:ip",
+	} {
+		t.Setenv(k, v)
+	}
+	cfg, err := config.Load()
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	api, err := httpapi.New(cfg, store.NewMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { api.Close(context.Background()) })
+	url := serve(t, &http.Server{Handler: api.Handler})
+	var logs strings.Builder
+	out := log.Writer()
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(out) })
+
+	allowed := func(origin string) bool {
+		req, _ := http.NewRequest("GET", url+"/health", nil)
+		req.Header.Set("Origin", origin)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.Header.Get("Access-Control-Allow-Origin") == origin
+	}
+	// limited reports whether n requests to the auth bucket hit its limit.
+	limited := func(n int) bool {
+		for range n {
+			resp, err := http.Post(url+"/api/v1/auth/login", "application/json", strings.NewReader("{}"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusTooManyRequests {
+				return true
+			}
+		}
+		return false
+	}
+
+	if !allowed("https://a.example.com") || allowed("https://b.example.com") || !limited(3) {
+		t.Fatal("the initial configuration is not in effect")
+	}
+
+	t.Setenv("CORS_ORIGINS", "https://b.example.com")
+	t.Setenv("RATE_LIMIT_BUCKETS", "auth:1000/1m:ip, api:1000/1m:ip")
+	t.Setenv("SERVER_PORT", "9999")
+	if err := reloadConfig(api); err != nil {
+		t.Fatal(err)
+	}
+	if allowed("https://a.example.com") || !allowed("https://b.example.com") {
+		t.Error("CORS_ORIGINS not reloaded")
+	}
+	if limited(5) {
+		t.Error("RATE_LIMIT_BUCKETS not reloaded")
+	}
+	if !strings.Contains(logs.String(), "restart required") {
+		t.Errorf("no restart warning for SERVER_PORT:\n%s", logs.String())
+	}
+
+	t.Setenv("CORS_ORIGINS", "https://c.example.com")
+	t.Setenv("RATE_LIMIT_BUCKETS", "auth:nonsense")
+	if err := reloadConfig(api); err == nil || !strings.Contains(err.Error(), "RATE_LIMIT_BUCKETS") {
+		t.Errorf("reloading a broken configuration: %v", err)
+	}
+	if !allowed("https://b.example.com") || allowed("https://c.example.com") {
+		t.Error("a failed reload changed CORS_ORIGINS")
+	}
+}
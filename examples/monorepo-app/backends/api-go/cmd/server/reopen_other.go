@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "github.com/your-org/your-app/backends/api-go/internal/httpapi"
+
+// reopenLogsOnSignal does nothing: there is no SIGUSR2 outside Unix.
+func reopenLogsOnSignal(api *httpapi.Server) {}
@@ -0,0 +1,23 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/your-org/your-app/backends/api-go/internal/httpapi"
+)
+
+// reopenLogsOnSignal reopens the log files on SIGUSR2, which logrotate
+// sends after moving them.
+func reopenLogsOnSignal(api *httpapi.Server) {
+	reopen := make(chan os.Signal, 1)
+	signal.Notify(reopen, syscall.SIGUSR2)
+	go func() {
+		for range reopen {
+			api.ReopenLogs()
+		}
+	}()
+}
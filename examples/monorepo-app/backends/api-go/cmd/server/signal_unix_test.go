@@ -0,0 +1,239 @@
+//go:build unix
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// When RAIJIN_TEST_MAIN is set the test binary runs main instead of the
+// tests, so a test can signal a real server process.
+func init() {
+	if os.Getenv("RAIJIN_TEST_MAIN") == "1" {
+		os.Args = os.Args[:1]
+		main()
+	}
+}
+
+// startMain runs the server in a child process with env added and returns
+// it along with its log lines as they come.
+func startMain(t *testing.T, env ...string) (*exec.Cmd, <-chan string) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), append([]string{
+		"RAIJIN_TEST_MAIN=1", "SERVER_LISTEN=127.0.0.1:0", "SERVER_ENVIRONMENT=test",
+		"AUDIT_LOG_OUTPUT=off", "ACCESS_LOG_OUTPUT=" + os.DevNull, "CONFIG_FILE=",
+	}, env...)...)
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cmd.Process.Kill() })
+	lines := make(chan string, 1000)
+	go func() {
+		defer close(lines)
+		sc := bufio.NewScanner(stderr)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+	}()
+	return cmd, lines
+}
+
+// waitLog returns the log lines up to the first one containing substr.
+func waitLog(t *testing.T, lines <-chan string, substr string) []string {
+	t.Helper()
+	var seen []string
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatalf("exited before logging %q:\n%s", substr, strings.Join(seen, "\n"))
+			}
+			seen = append(seen, line)
+			if strings.Contains(line, substr) {
+				return seen
+			}
+		case <-timeout:
+			t.Fatalf("no %q logged:\n%s", substr, strings.Join(seen, "\n"))
+		}
+	}
+}
+
+// exitCode waits for cmd and returns its exit status.
+func exitCode(t *testing.T, cmd *exec.Cmd, within time.Duration) int {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		var exit *exec.ExitError
+		if errors.As(err, &exit) {
+			return exit.ExitCode()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return 0
+	case <-time.After(within):
+		t.Fatalf("still running after %s", within)
+		return -1
+	}
+}
+
+// SIGTERM drains, shuts the server down and runs the shutdown hooks last
+// registered first, then exits 0.
+func TestSignalShutdown(t *testing.T) {
+	cmd, lines := startMain(t, "DRAIN_DELAY=0s")
+	waitLog(t, lines, "Listening on")
+	cmd.Process.Signal(syscall.SIGTERM)
+	logged := strings.Join(waitLog(t, lines, "Server exited"), "\n")
+	events, webhooks := strings.Index(logged, "Shutdown: events done"), strings.Index(logged, "Shutdown: webhooks done")
+	if events < 0 || webhooks < 0 || events > webhooks {
+		t.Errorf("want the events hook before the webhooks hook:\n%s", logged)
+	}
+	if code := exitCode(t, cmd, 5*time.Second); code != 0 {
+		t.Errorf("exit status %d", code)
+	}
+}
+
+// A second SIGINT while draining exits at once, with status 1.
+func TestSecondSignalExits(t *testing.T) {
+	cmd, lines := startMain(t, "DRAIN_DELAY=50s")
+	waitLog(t, lines, "Listening on")
+	cmd.Process.Signal(syscall.SIGINT)
+	waitLog(t, lines, "Draining")
+	start := time.Now()
+	cmd.Process.Signal(syscall.SIGINT)
+	waitLog(t, lines, "Second signal, exiting without finishing the shutdown")
+	if code := exitCode(t, cmd, 5*time.Second); code != 1 {
+		t.Errorf("exit status %d, want 1", code)
+	}
+	if took := time.Since(start); took > 5*time.Second {
+		t.Errorf("took %s to exit", took)
+	}
+}
+
+// listenURL finds the URL of the listener logged with prefix in logged.
+func listenURL(t *testing.T, logged []string, prefix string) string {
+	t.Helper()
+	for _, line := range logged {
+		if _, addr, ok := strings.Cut(line, prefix+" on tcp://"); ok {
+			return "http://" + addr
+		}
+	}
+	t.Fatalf("no %q listener logged:\n%s", prefix, strings.Join(logged, "\n"))
+	return ""
+}
+
+// waitReady polls url/ready until it answers want, and fails the test if
+// the server stops accepting connections first.
+func waitReady(t *testing.T, url string, want int) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		resp, err := http.Get(url + "/ready")
+		if err != nil {
+			t.Fatalf("/ready: %v before answering %d", err, want)
+		}
+		resp.Body.Close()
+		if resp.StatusCode == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("/ready still %d", resp.StatusCode)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// On SIGTERM /ready fails while the listener still accepts, for
+// DRAIN_DELAY, and only then does the listener close.
+func TestDrainBeforeClose(t *testing.T) {
+	cmd, lines := startMain(t, "DRAIN_DELAY=1s", "DRAIN_GRACE=1m")
+	url := listenURL(t, waitLog(t, lines, "Listening on"), "Listening")
+	waitReady(t, url, http.StatusOK)
+
+	start := time.Now()
+	cmd.Process.Signal(syscall.SIGTERM)
+	waitReady(t, url, http.StatusServiceUnavailable)
+	if took := time.Since(start); took > 500*time.Millisecond {
+		t.Errorf("readiness failed %s after the signal", took)
+	}
+	for {
+		resp, err := http.Get(url + "/health")
+		if err != nil {
+			break
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("/health while draining: %d", resp.StatusCode)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if took := time.Since(start); took < time.Second {
+		t.Errorf("the listener closed %s after the signal, before DRAIN_DELAY", took)
+	}
+	if code := exitCode(t, cmd, 5*time.Second); code != 0 {
+		t.Errorf("exit status %d", code)
+	}
+}
+
+// A preStop hook on POST /internal/drain fails /ready and waits out
+// DRAIN_DELAY, so the SIGTERM that follows shuts down without waiting
+// again.
+func TestPreStopThenSignal(t *testing.T) {
+	cmd, lines := startMain(t, "DRAIN_DELAY=1s", "DRAIN_GRACE=1m", "INTERNAL_ADDR=localhost:0") // distinct from SERVER_LISTEN
+	logged := waitLog(t, lines, "Internal (")
+	url, internal := listenURL(t, logged, "Listening"), listenURL(t, logged, "Internal (/metrics, /debug/)")
+	waitReady(t, url, http.StatusOK)
+
+	start := time.Now()
+	held := make(chan error, 1)
+	go func() {
+		resp, err := http.Post(internal+"/internal/drain", "application/json", nil)
+		if err == nil {
+			resp.Body.Close()
+		}
+		held <- err
+	}()
+	waitReady(t, url, http.StatusServiceUnavailable)
+	if err := <-held; err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(start); took < time.Second {
+		t.Errorf("the hook returned after %s, before DRAIN_DELAY", took)
+	}
+
+	cmd.Process.Signal(syscall.SIGTERM)
+	logged = waitLog(t, lines, "Server exited")
+	if code := exitCode(t, cmd, 10*time.Second); code != 0 {
+		t.Errorf("exit status %d", code)
+	}
+	// The shutdown's log tells whether it waited again, which a wall
+	// clock on a busy machine cannot.
+	var since time.Duration
+	for _, line := range logged {
+		if strings.Contains(line, "Draining (delay") {
+			t.Errorf("SIGTERM waited out DRAIN_DELAY again: %s", line)
+		}
+		if _, rest, ok := strings.Cut(line, "Draining since "); ok {
+			since, _ = time.ParseDuration(strings.Fields(rest)[0])
+		}
+	}
+	if since < time.Second {
+		t.Errorf("SIGTERM found the drain started %s ago, want DRAIN_DELAY spent:\n%s", since, strings.Join(logged, "\n"))
+	}
+}
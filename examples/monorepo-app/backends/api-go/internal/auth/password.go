@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MaxPasswordBytes is the longest password bcrypt hashes in full; it
+// silently ignores anything past it.
+const MaxPasswordBytes = 72
+
+// ErrPasswordTooLong is returned for passwords over MaxPasswordBytes.
+var ErrPasswordTooLong = errors.New("password longer than 72 bytes")
+
+// HashPassword returns the bcrypt hash stored in User.Password.
+func HashPassword(password string) (string, error) {
+	if len(password) > MaxPasswordBytes {
+		return "", ErrPasswordTooLong
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// CheckPassword returns nil if password matches hash, a HashPassword result.
+// A password over MaxPasswordBytes never matches, though bcrypt would
+// compare only its first 72 bytes.
+func CheckPassword(hash, password string) error {
+	if len(password) > MaxPasswordBytes {
+		return ErrPasswordTooLong
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+}
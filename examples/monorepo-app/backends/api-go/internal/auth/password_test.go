@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// A password one byte past bcrypt's limit must not pass for its 72-byte
+// prefix, which is all bcrypt would compare.
+func TestPasswordByteLimit(t *testing.T) {
+	for name, prefix := range map[string]string{
+		"ASCII":     strings.Repeat("a", MaxPasswordBytes),
+		"multibyte": strings.Repeat("€", MaxPasswordBytes/3), // 3 bytes each
+	} {
+		hash, err := HashPassword(prefix)
+		if err != nil {
+			t.Fatalf("%s: hashing %d bytes: %v", name, len(prefix), err)
+		}
+		if err := CheckPassword(hash, prefix); err != nil {
+			t.Errorf("%s: the password itself: %v", name, err)
+		}
+		for _, longer := range []string{prefix + "x", prefix + "€"} {
+			if _, err := HashPassword(longer); !errors.Is(err, ErrPasswordTooLong) {
+				t.Errorf("%s: hashing %d bytes: %v", name, len(longer), err)
+			}
+			if err := CheckPassword(hash, longer); !errors.Is(err, ErrPasswordTooLong) {
+				t.Errorf("%s: %d bytes matched the %d-byte prefix: %v", name, len(longer), len(prefix), err)
+			}
+		}
+	}
+	// 25 euro signs are 25 characters but 75 bytes.
+	if _, err := HashPassword(strings.Repeat("€", 25)); !errors.Is(err, ErrPasswordTooLong) {
+		t.Errorf("25 three-byte characters: %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"time"
+)
+
+// Now and Rand are the clock and the randomness behind tokens, IDs and
+// expiry checks. raijintest swaps them for reproducible runs; nothing else
+// should.
+var (
+	Now            = time.Now
+	Rand io.Reader = rand.Reader
+)
+
+// ReadRand fills b from Rand. It panics if Rand fails, as crypto/rand.Read
+// does: with no entropy, nothing secret can be issued, and going on with
+// zero-filled bytes would hand out predictable IDs and tokens.
+func ReadRand(b []byte) {
+	if _, err := io.ReadFull(Rand, b); err != nil {
+		panic("auth: reading randomness: " + err.Error())
+	}
+}
+
+// GenerateID returns a random 128-bit hex ID.
+func GenerateID() string {
+	b := make([]byte, 16)
+	ReadRand(b)
+	return hex.EncodeToString(b)
+}
+
+// GenerateToken returns a random 256-bit hex token.
+func GenerateToken() string {
+	b := make([]byte, 32)
+	ReadRand(b)
+	return hex.EncodeToString(b)
+}
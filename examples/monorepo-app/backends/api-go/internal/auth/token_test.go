@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// withRand swaps Rand for r until the test ends.
+func withRand(t *testing.T, r io.Reader) {
+	prev := Rand
+	Rand = r
+	t.Cleanup(func() { Rand = prev })
+}
+
+func TestGenerateFailsWithoutRandomness(t *testing.T) {
+	readers := map[string]io.Reader{
+		"error":      iotest.ErrReader(errors.New("no entropy")),
+		"short read": strings.NewReader("only 8 b"),
+	}
+	generators := map[string]func() string{"GenerateID": GenerateID, "GenerateToken": GenerateToken}
+	for rname, r := range readers {
+		for gname, generate := range generators {
+			t.Run(rname+"/"+gname, func(t *testing.T) {
+				withRand(t, r)
+				var issued string
+				defer func() {
+					if recover() == nil {
+						t.Errorf("%s did not panic", gname)
+					}
+					if issued != "" {
+						t.Errorf("%s issued %q", gname, issued)
+					}
+				}()
+				issued = generate()
+			})
+		}
+	}
+}
+
+func TestGenerateReadsRand(t *testing.T) {
+	withRand(t, strings.NewReader(strings.Repeat("\x01", 16)+strings.Repeat("\x02", 32)))
+	if id := GenerateID(); id != strings.Repeat("01", 16) {
+		t.Errorf("GenerateID = %s", id)
+	}
+	if token := GenerateToken(); token != strings.Repeat("02", 32) {
+		t.Errorf("GenerateToken = %s", token)
+	}
+}
@@ -0,0 +1,1414 @@
+// Package config resolves the server configuration from the environment
+// and an optional JSON or YAML file, and validates it.
+package config
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	mrand "math/rand/v2"
+	"net"
+	"net/mail"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the resolved server configuration. The config tag names the
+// setting (environment variable / file key) for Effective; ",secret" marks
+// values that must only ever be logged as a fingerprint.
+type Config struct {
+	Port               string        `config:"SERVER_PORT"`
+	Environment        string        `config:"SERVER_ENVIRONMENT"`
+	AllowedOrigins     []string      `config:"CORS_ORIGINS"`
+	JWTSecret          string        `config:"JWT_SECRET,secret"`
+	JWTSecretPrevious  string        `config:"JWT_SECRET_PREVIOUS,secret"` // being rotated out: still verifies, never signs
+	UnsubscribeSecret  string        `config:"UNSUBSCRIBE_SECRET,secret"`  // keys mailed unsubscribe links; "" uses JWTSecret
+	MaintenanceMode    bool          `config:"MAINTENANCE_MODE"`
+	MaintenanceMessage string        `config:"MAINTENANCE_MESSAGE"`
+	RateLimitSweep     time.Duration `config:"RATE_LIMIT_SWEEP_INTERVAL"`
+	RateLimitMaxKeys   int           `config:"RATE_LIMIT_MAX_KEYS"` // keys tracked per limiter before random ones are evicted
+	ReadyCheckTimeout  time.Duration `config:"READY_CHECK_TIMEOUT"`
+	ReadyCacheTTL      time.Duration `config:"READY_CACHE_TTL"`
+	WarmupTimeout      time.Duration `config:"WARMUP_TIMEOUT"` // per warmup task
+	EnableH2C          bool          `config:"ENABLE_H2C"`
+	Listen             []string      `config:"SERVER_LISTEN"`
+	SocketMode         os.FileMode   `config:"SERVER_SOCKET_MODE"`
+	EnablePprof        bool          `config:"ENABLE_PPROF"`
+	EnableDocs         bool          `config:"ENABLE_DOCS"`
+	InternalAddr       string        `config:"INTERNAL_ADDR"`
+	AccessLogFormat    string        `config:"ACCESS_LOG_FORMAT"`
+	AccessLogOutput    string        `config:"ACCESS_LOG_OUTPUT"`
+	AccessLogFilter    LogFilter
+	SlowThreshold      time.Duration `config:"SLOW_REQUEST_THRESHOLD"`
+	ErrorFormat        string        `config:"ERROR_FORMAT"`
+	ProblemTypeBase    string        `config:"PROBLEM_TYPE_BASE"`
+	Security           SecurityHeadersConfig
+	DrainDelay         time.Duration     `config:"DRAIN_DELAY"`
+	DrainGrace         time.Duration     `config:"DRAIN_GRACE"`
+	ShutdownTimeout    time.Duration     `config:"SHUTDOWN_TIMEOUT"`
+	MaxConcurrent      int               `config:"MAX_CONCURRENT_REQUESTS"` // 0 disables
+	MaxConcurrentAuth  int               `config:"MAX_CONCURRENT_AUTH"`     // 0 disables
+	ConcurrencyWait    time.Duration     `config:"CONCURRENCY_WAIT"`
+	RateLimitBuckets   []RateLimitBucket `config:"RATE_LIMIT_BUCKETS"`
+	RateLimitRoutes    map[string]string `config:"RATE_LIMIT_ROUTES"`   // route pattern -> bucket
+	LoginFailureLimit  int               `config:"LOGIN_FAILURE_LIMIT"` // failed logins per email and window; 0 disables
+	LoginFailureWindow time.Duration     `config:"LOGIN_FAILURE_WINDOW"`
+	AccessTokenTTL     time.Duration     `config:"ACCESS_TOKEN_TTL"`
+	RefreshTokenTTL    time.Duration     `config:"REFRESH_TOKEN_TTL"`
+	RefreshSliding     bool              `config:"REFRESH_SLIDING"`         // each refresh extends the session by REFRESH_TOKEN_TTL
+	MaxSessionAge      time.Duration     `config:"REFRESH_MAX_SESSION_AGE"` // cap on a sliding session, from its login
+	MaxSessionLifetime time.Duration     `config:"MAX_SESSION_LIFETIME"`    // hard limit on any session, from its login; 0 disables
+	CSRFTokenTTL       time.Duration     `config:"CSRF_TOKEN_TTL"`
+	CSRFExemptBearer   bool              `config:"CSRF_EXEMPT_BEARER"`     // skip CSRF for requests authenticated by an Authorization header
+	ReauthMaxAge       time.Duration     `config:"REAUTH_MAX_AGE"`         // how recent a login sensitive operations need
+	TokenExpiryHeaders bool              `config:"TOKEN_EXPIRY_HEADERS"`   // X-Token-Expires-In on authenticated responses
+	DeletionGrace      time.Duration     `config:"ACCOUNT_DELETION_GRACE"` // how long a deleted account can be restored
+	PurgeInterval      time.Duration     `config:"ACCOUNT_PURGE_INTERVAL"` // how often accounts past their grace period are purged
+	ReadTimeout        time.Duration     `config:"SERVER_READ_TIMEOUT"`
+	ReadHeaderTimeout  time.Duration     `config:"SERVER_READ_HEADER_TIMEOUT"`
+	WriteTimeout       time.Duration     `config:"SERVER_WRITE_TIMEOUT"`
+	IdleTimeout        time.Duration     `config:"SERVER_IDLE_TIMEOUT"`
+	AuditLogOutput     string            `config:"AUDIT_LOG_OUTPUT"`
+	AuditLogRetention  int               `config:"AUDIT_LOG_RETENTION"`
+	WebhookWorkers     int               `config:"WEBHOOK_WORKERS"`
+	WebhookQueueSize   int               `config:"WEBHOOK_QUEUE_SIZE"`
+	WebhookMaxAttempts int               `config:"WEBHOOK_MAX_ATTEMPTS"`
+	WebhookBackoff     time.Duration     `config:"WEBHOOK_BACKOFF"`
+	WebhookTimeout     time.Duration     `config:"WEBHOOK_TIMEOUT"`
+	WebhookTripAfter   int               `config:"WEBHOOK_BREAKER_THRESHOLD"` // consecutive failures that open a subscription's circuit
+	WebhookCooldown    time.Duration     `config:"WEBHOOK_BREAKER_COOLDOWN"`  // how long an open circuit holds deliveries back
+	GRPCAddr           string            `config:"GRPC_ADDR"`
+	GRPCRateLimits     map[string]string `config:"GRPC_RATE_LIMITS"` // full method or "*" -> bucket
+	WSMaxConnections   int               `config:"WS_MAX_CONNECTIONS"`
+	WSPingInterval     time.Duration     `config:"WS_PING_INTERVAL"`
+	SSEMaxStreams      int               `config:"SSE_MAX_STREAMS"`
+	MailDriver         string            `config:"MAIL_DRIVER"`
+	MailFrom           string            `config:"MAIL_FROM"`
+	MailWorkers        int               `config:"MAIL_WORKERS"`
+	MailQueueSize      int               `config:"MAIL_QUEUE_SIZE"`
+	MailMaxAttempts    int               `config:"MAIL_MAX_ATTEMPTS"`
+	MailBackoff        time.Duration     `config:"MAIL_BACKOFF"`
+	SMTPHost           string            `config:"SMTP_HOST"`
+	SMTPPort           int               `config:"SMTP_PORT"`
+	SMTPUsername       string            `config:"SMTP_USERNAME"`
+	SMTPPassword       string            `config:"SMTP_PASSWORD,secret"`
+	SMTPTLS            string            `config:"SMTP_TLS"`
+	SMTPTimeout        time.Duration     `config:"SMTP_TIMEOUT"`
+	EmailTemplatesDir  string            `config:"EMAIL_TEMPLATES_DIR"`
+	AppURL             string            `config:"APP_URL"`           // public URL of the frontend, for links in emails
+	NewDeviceAlerts    bool              `config:"NEW_DEVICE_ALERTS"` // email users on a login from an unknown device
+	SAML               SAMLConfig
+	Captcha            CaptchaConfig
+	SMS                SMSConfig
+	Terms              TermsConfig
+	Pwned              PwnedConfig
+	Roles              []string `config:"ROLES"` // roles besides the built-in user and admin
+	Domains            DomainsConfig
+	Disposable         DisposableConfig
+	RateLimitExempt    RateLimitExemptConfig
+	Static             StaticConfig
+	Proxy              ProxyConfig
+	ProxyProtocol      ProxyProtocolConfig
+	InternalAuth       InternalAuthConfig
+	Outbound           OutboundConfig
+	BodyLog            BodyLogConfig
+	FeatureFlags       []FeatureFlag `config:"FEATURE_FLAGS"` // defaults; the admin API flips them at runtime
+	AdminStatsCacheTTL time.Duration `config:"ADMIN_STATS_CACHE_TTL"`
+
+	sources map[string]string // setting -> "env", "file", ...; see configSource
+}
+
+// SecurityHeadersConfig shapes the headers set by the SecurityHeaders middleware.
+// The defaults reproduce the historical fixed header set.
+type SecurityHeadersConfig struct {
+	CSPOverride           string `config:"CSP_OVERRIDE"`     // full CSP string; wins over CSPExtra
+	CSPExtra              string `config:"CSP_EXTRA"`        // "directive src ...; ..." merged into the default CSP
+	FrameProtection       string `config:"FRAME_PROTECTION"` // "both", "x-frame-options" or "frame-ancestors"
+	HSTSMaxAge            int    `config:"HSTS_MAX_AGE"`
+	HSTSIncludeSubDomains bool   `config:"HSTS_INCLUDE_SUBDOMAINS"`
+	HSTSPreload           bool   `config:"HSTS_PRELOAD"`
+	XSSProtection         bool   `config:"XSS_PROTECTION_HEADER"` // legacy X-XSS-Protection header
+}
+
+// SAMLConfig sets up SAML single sign-on. It is off unless IdPSSOURL is
+// set.
+type SAMLConfig struct {
+	IdPSSOURL      string        `config:"SAML_IDP_SSO_URL"`
+	IdPEntityID    string        `config:"SAML_IDP_ENTITY_ID"`
+	IdPCertPath    string        `config:"SAML_IDP_CERT_PATH"` // PEM signing certificate of the IdP
+	BaseURL        string        `config:"SAML_SP_BASE_URL"`   // public URL of this API; the ACS URL is derived from it
+	EntityID       string        `config:"SAML_SP_ENTITY_ID"`  // default: the metadata URL
+	EmailAttribute string        `config:"SAML_EMAIL_ATTRIBUTE"`
+	NameAttribute  string        `config:"SAML_NAME_ATTRIBUTE"`
+	RoleAttribute  string        `config:"SAML_ROLE_ATTRIBUTE"`
+	ClockSkew      time.Duration `config:"SAML_CLOCK_SKEW"`
+}
+
+// Enabled reports whether SAML login is configured.
+func (c SAMLConfig) Enabled() bool { return c.IdPSSOURL != "" }
+
+// CaptchaConfig sets up the CAPTCHA challenge on registration and on
+// logins after repeated failures. It is off unless Provider is set.
+type CaptchaConfig struct {
+	Provider    string        `config:"CAPTCHA_PROVIDER"` // hcaptcha, turnstile, recaptcha or static
+	Secret      string        `config:"CAPTCHA_SECRET,secret"`
+	VerifyURL   string        `config:"CAPTCHA_VERIFY_URL"` // default: the provider's siteverify endpoint
+	Timeout     time.Duration `config:"CAPTCHA_TIMEOUT"`
+	FailOpen    bool          `config:"CAPTCHA_FAIL_OPEN"` // let requests through while the provider is unreachable
+	Register    bool          `config:"CAPTCHA_REGISTER"`
+	LoginAfter  int           `config:"CAPTCHA_LOGIN_AFTER"` // failed logins per IP or email before login needs it; 0: never
+	LoginWindow time.Duration `config:"CAPTCHA_LOGIN_WINDOW"`
+}
+
+// Enabled reports whether a CAPTCHA provider is configured.
+func (c CaptchaConfig) Enabled() bool { return c.Provider != "" }
+
+// SMSConfig sets up text messages, which carry the one-time codes of the
+// phone login and of phone verification. It is off unless Driver is set.
+type SMSConfig struct {
+	Driver     string        `config:"SMS_DRIVER"`   // log or http
+	URL        string        `config:"SMS_HTTP_URL"` // gateway endpoint; receives {"to", "body"} as JSON
+	Token      string        `config:"SMS_HTTP_TOKEN,secret"`
+	Timeout    time.Duration `config:"SMS_TIMEOUT"`
+	PhoneLimit int           `config:"OTP_PHONE_LIMIT"` // codes requested per phone and window
+	IPLimit    int           `config:"OTP_IP_LIMIT"`    // codes requested per client IP and window
+	Window     time.Duration `config:"OTP_LIMIT_WINDOW"`
+}
+
+// Enabled reports whether an SMS driver is configured.
+func (c SMSConfig) Enabled() bool { return c.Driver != "" }
+
+// TermsConfig names the current versions of the terms of service and of
+// the privacy policy. While either is set, registration requires
+// accepting them and users who accepted other versions must accept again.
+type TermsConfig struct {
+	Version        string `config:"TERMS_VERSION"`
+	PrivacyVersion string `config:"PRIVACY_VERSION"`
+}
+
+// Enabled reports whether acceptance of the policies is tracked.
+func (c TermsConfig) Enabled() bool { return c.Version != "" || c.PrivacyVersion != "" }
+
+// PwnedConfig sets up the breached-password check against a Pwned
+// Passwords range API. It is off unless Policy is warn or block.
+type PwnedConfig struct {
+	Policy    string        `config:"BREACH_CHECK"` // off, warn or block
+	URL       string        `config:"BREACH_CHECK_URL"`
+	Timeout   time.Duration `config:"BREACH_CHECK_TIMEOUT"`
+	CacheSize int           `config:"BREACH_CHECK_CACHE_SIZE"` // range responses kept in memory
+}
+
+// Enabled reports whether passwords are checked.
+func (c PwnedConfig) Enabled() bool { return c.Policy == "warn" || c.Policy == "block" }
+
+// DomainsConfig restricts self-registration by email domain. Entries
+// are domains ("example.com") or wildcards matching any subdomain
+// ("*.example.com"). Admin-created and SAML-provisioned users are exempt.
+type DomainsConfig struct {
+	Allowed []string `config:"REGISTRATION_ALLOWED_DOMAINS"` // empty: any domain not blocked
+	Blocked []string `config:"REGISTRATION_BLOCKED_DOMAINS"` // wins over Allowed
+}
+
+// DisposableConfig sets up the detection of disposable (throwaway) email
+// domains at registration. It is off unless Action is reject or flag.
+type DisposableConfig struct {
+	Action  string        `config:"DISPOSABLE_EMAIL_ACTION"`   // off, reject or flag
+	ListURL string        `config:"DISPOSABLE_EMAIL_LIST_URL"` // domains, one per line, added to the embedded list
+	Refresh time.Duration `config:"DISPOSABLE_EMAIL_REFRESH"`  // how often ListURL is fetched again
+}
+
+// Enabled reports whether registrations are checked.
+func (c DisposableConfig) Enabled() bool { return c.Action == "reject" || c.Action == "flag" }
+
+// StaticConfig serves a single-page frontend at / next to the API. It is
+// off unless Enabled.
+type StaticConfig struct {
+	Enabled  bool   `config:"ENABLE_STATIC"`
+	Dir      string `config:"STATIC_DIR"`       // served instead of the files embedded in the binary
+	CSPExtra string `config:"STATIC_CSP_EXTRA"` // merged into the CSP of HTML pages
+}
+
+// ProxyConfig forwards path prefixes to other upstreams, for routes not
+// yet moved off the legacy backend. It is off while Routes is empty.
+type ProxyConfig struct {
+	Routes []ProxyRoute `config:"PROXY_ROUTES"`
+	Secret string       `config:"PROXY_SECRET,secret"` // signs the X-User-ID sent upstream
+}
+
+// ProxyProtocolConfig reads the PROXY protocol header a TCP load balancer
+// sends ahead of each connection on the SERVER_LISTEN listeners, so
+// requests carry the client's address. It is off unless Enabled.
+type ProxyProtocolConfig struct {
+	Enabled bool          `config:"PROXY_PROTOCOL"`
+	Allowed []string      `config:"PROXY_PROTOCOL_ALLOWED_CIDRS"` // balancers whose header is read; others are served as they are. Empty: every peer must send one
+	Timeout time.Duration `config:"PROXY_PROTOCOL_TIMEOUT"`       // for the header to arrive
+}
+
+// OutboundConfig is the policy of the calls the server makes to third
+// parties: the CAPTCHA provider, the breach check, the SMS gateway, the
+// disposable email list, the SMTP relay and webhook subscribers. Each has
+// its own timeout setting. Every dependency but webhooks gets a circuit
+// breaker; webhook subscriptions have their own (WEBHOOK_BREAKER_*).
+type OutboundConfig struct {
+	MaxConns  int           `config:"OUTBOUND_MAX_CONNS"`         // per host and dependency, idle or in use
+	TripAfter int           `config:"OUTBOUND_BREAKER_THRESHOLD"` // consecutive failures that open a circuit; 0 disables the breakers
+	Cooldown  time.Duration `config:"OUTBOUND_BREAKER_COOLDOWN"`  // how long an open circuit refuses calls before a probe
+}
+
+// InternalAuthConfig lets trusted services, such as batch jobs, call the
+// API with requests signed by a shared key instead of a user's token. It
+// is off while Callers is empty.
+type InternalAuthConfig struct {
+	Callers []InternalCaller  `config:"INTERNAL_CALLERS"`
+	Secrets map[string]string `config:"INTERNAL_CALLER_SECRETS,secret"` // caller name -> HMAC key
+	MaxSkew time.Duration     `config:"INTERNAL_AUTH_MAX_SKEW"`         // between the caller's clock and ours
+}
+
+// InternalCaller is a caller from INTERNAL_CALLERS.
+type InternalCaller struct {
+	Name   string
+	Role   string   // of the identity its requests run as
+	Scopes []string // path prefixes it may call, as in /api/v1; empty: /api/v1/admin
+}
+
+// ParseInternalCaller parses "name=role[ scope prefix]", e.g.
+// "billing-batch=admin scope /api/v1/admin/users", where "scope" can
+// repeat.
+func ParseInternalCaller(spec string) (InternalCaller, error) {
+	name, rest, ok := strings.Cut(spec, "=")
+	fields := strings.Fields(rest)
+	if !ok || len(fields) == 0 {
+		return InternalCaller{}, errors.New(`want "name=role[ scope prefix]"`)
+	}
+	c := InternalCaller{Name: strings.TrimSpace(name), Role: fields[0]}
+	for opts := fields[1:]; len(opts) > 0; opts = opts[2:] {
+		if opts[0] != "scope" || len(opts) < 2 {
+			return InternalCaller{}, fmt.Errorf("option %q must be scope with a path prefix", opts[0])
+		}
+		c.Scopes = append(c.Scopes, opts[1])
+	}
+	return c, nil
+}
+
+func (c InternalCaller) String() string {
+	spec := c.Name + "=" + c.Role
+	for _, s := range c.Scopes {
+		spec += " scope " + s
+	}
+	return spec
+}
+
+// ProxyRoute is a rule from PROXY_ROUTES.
+type ProxyRoute struct {
+	Prefix   string            // without the trailing slash; every path below it is proxied
+	Upstream string            // absolute http(s) URL
+	Strip    bool              // remove Prefix from the path sent upstream
+	Timeout  time.Duration     // for the upstream's response headers
+	MaxIdle  int               // idle connections kept to the upstream
+	Headers  map[string]string // set on every proxied request
+}
+
+// ParseProxyRoute parses "prefix=upstream[ options]", e.g.
+// "/api/v1/reports=http://legacy:8080 strip timeout 10s". Options are
+// "strip", "timeout d", "idle n" and "header Name:value", which can
+// repeat. Timeout defaults to 30s and idle to 16.
+func ParseProxyRoute(spec string) (ProxyRoute, error) {
+	prefix, rest, ok := strings.Cut(spec, "=")
+	fields := strings.Fields(rest)
+	if !ok || len(fields) == 0 {
+		return ProxyRoute{}, errors.New(`want "prefix=upstream[ strip][ timeout d][ idle n][ header Name:value]"`)
+	}
+	p := ProxyRoute{Prefix: strings.TrimSuffix(strings.TrimSpace(prefix), "/"), Upstream: fields[0], Timeout: 30 * time.Second, MaxIdle: 16}
+	var err error
+	for opts := fields[1:]; len(opts) > 0; {
+		if opts[0] == "strip" {
+			p.Strip = true
+			opts = opts[1:]
+			continue
+		}
+		if len(opts) < 2 {
+			return ProxyRoute{}, fmt.Errorf("option %q must be strip, or timeout, idle or header with a value", opts[0])
+		}
+		switch opts[0] {
+		case "timeout":
+			if p.Timeout, err = time.ParseDuration(opts[1]); err != nil || p.Timeout <= 0 {
+				return ProxyRoute{}, fmt.Errorf("timeout %q must be a positive duration", opts[1])
+			}
+		case "idle":
+			if p.MaxIdle, err = strconv.Atoi(opts[1]); err != nil || p.MaxIdle < 0 {
+				return ProxyRoute{}, fmt.Errorf("idle %q must be a non-negative integer", opts[1])
+			}
+		case "header":
+			name, value, ok := strings.Cut(opts[1], ":")
+			if !ok || name == "" {
+				return ProxyRoute{}, fmt.Errorf(`header %q: want "Name:value"`, opts[1])
+			}
+			if p.Headers == nil {
+				p.Headers = make(map[string]string)
+			}
+			p.Headers[name] = value
+		default:
+			return ProxyRoute{}, fmt.Errorf("option %q must be strip, timeout, idle or header", opts[0])
+		}
+		opts = opts[2:]
+	}
+	return p, nil
+}
+
+func (p ProxyRoute) String() string {
+	spec := fmt.Sprintf("%s=%s", p.Prefix, p.Upstream)
+	if p.Strip {
+		spec += " strip"
+	}
+	spec += fmt.Sprintf(" timeout %s idle %d", p.Timeout, p.MaxIdle)
+	names := make([]string, 0, len(p.Headers))
+	for name := range p.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		spec += fmt.Sprintf(" header %s:%s", name, p.Headers[name])
+	}
+	return spec
+}
+
+// BodyLogConfig logs request and response bodies, with secrets redacted,
+// for reproducing client bugs. It is off unless Enabled, and refused in
+// production unless AllowProduction is set as well.
+type BodyLogConfig struct {
+	Enabled         bool     `config:"BODY_LOG_ENABLED"`
+	AllowProduction bool     `config:"BODY_LOG_ALLOW_PRODUCTION"`
+	MaxBytes        int      `config:"BODY_LOG_MAX_BYTES"`     // larger bodies are left out
+	RedactFields    []string `config:"BODY_LOG_REDACT_FIELDS"` // JSON field names, at any depth, case-insensitive; form and query parameters too
+}
+
+// LogFilter decides which successful requests are left out of the access
+// log. Paths match exactly; non-2xx responses are always logged.
+type LogFilter struct {
+	Skip        map[string]bool `config:"ACCESS_LOG_SKIP_PATHS"`   // never log on 2xx
+	Sample      map[string]bool `config:"ACCESS_LOG_SAMPLE_PATHS"` // log only SampleRate of 2xx
+	SampleRate  float64         `config:"ACCESS_LOG_SAMPLE_RATE"`
+	SkipMetrics bool            `config:"ACCESS_LOG_SKIP_METRICS"` // also leave skipped requests out of requestsTotal
+}
+
+// Excludes reports whether a request for path answered with status is left
+// out of the access log.
+func (f LogFilter) Excludes(path string, status int) bool {
+	if status < 200 || status >= 300 {
+		return false
+	}
+	if f.Skip[path] {
+		return true
+	}
+	return f.Sample[path] && mrand.Float64() >= f.SampleRate
+}
+
+// RateLimitExemptConfig lets trusted callers, such as uptime monitors and
+// gateway health checks, skip the rate limit buckets that honor
+// exemptions (see RateLimitBucket.Exempt).
+type RateLimitExemptConfig struct {
+	CIDRs          []string `config:"RATE_LIMIT_EXEMPT_CIDRS"`         // client networks, matched against the address TrustedProxies vouch for
+	TrustedProxies []string `config:"TRUSTED_PROXIES"`                 // proxies whose X-Forwarded-For is believed for the client address
+	BypassSecret   string   `config:"RATE_LIMIT_BYPASS_SECRET,secret"` // value of the X-RateLimit-Bypass header
+}
+
+// ParseCIDRs parses networks ("10.0.0.0/8") and single addresses
+// ("192.0.2.7"), masked to their network address.
+func ParseCIDRs(list []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, item := range list {
+		p, err := netip.ParsePrefix(item)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(item)
+			if aerr != nil {
+				return nil, fmt.Errorf("%q is not an address or CIDR", item)
+			}
+			p = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+// RateLimitBucket is a named rate limit from RATE_LIMIT_BUCKETS.
+type RateLimitBucket struct {
+	Name   string
+	Limit  int
+	Window time.Duration
+	Burst  int    // requests allowed at once, above Limit; 0 when not set
+	Key    string // "ip" or "user"
+	// Exempt is whether RATE_LIMIT_EXEMPT_CIDRS and the bypass header skip
+	// the bucket: by default every bucket but auth, which guards login and
+	// registration. The "exempt" and "noexempt" options override it.
+	Exempt bool
+}
+
+// ParseRateLimitBucket parses "name:limit/window[ options][:key]", e.g.
+// "auth:10/1m:ip" or "auth:10/1m burst 20 exempt:ip". The options are
+// "burst n", "exempt" and "noexempt". Key defaults to ip.
+func ParseRateLimitBucket(spec string) (RateLimitBucket, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+		return RateLimitBucket{}, errors.New(`want "name:limit/window[ burst n][ exempt|noexempt][:ip|user]"`)
+	}
+	b := RateLimitBucket{Name: parts[0], Key: "ip", Exempt: parts[0] != "auth"}
+	rate := strings.Fields(parts[1])
+	if len(rate) == 0 {
+		return RateLimitBucket{}, errors.New(`want "limit/window", e.g. "10/1m"`)
+	}
+	limit, window, ok := strings.Cut(rate[0], "/")
+	if !ok {
+		return RateLimitBucket{}, errors.New(`want "limit/window", e.g. "10/1m"`)
+	}
+	var err error
+	if b.Limit, err = strconv.Atoi(limit); err != nil || b.Limit < 1 {
+		return RateLimitBucket{}, fmt.Errorf("limit %q must be a positive integer", limit)
+	}
+	if b.Window, err = time.ParseDuration(window); err != nil || b.Window <= 0 {
+		return RateLimitBucket{}, fmt.Errorf("window %q must be a positive duration", window)
+	}
+	for opts := rate[1:]; len(opts) > 0; {
+		switch opts[0] {
+		case "burst":
+			if len(opts) < 2 {
+				return RateLimitBucket{}, errors.New(`want "burst n", e.g. "10/1m burst 20"`)
+			}
+			if b.Burst, err = strconv.Atoi(opts[1]); err != nil || b.Burst < b.Limit {
+				return RateLimitBucket{}, fmt.Errorf("burst %q must be an integer of at least the limit, %d", opts[1], b.Limit)
+			}
+			opts = opts[2:]
+		case "exempt", "noexempt":
+			b.Exempt = opts[0] == "exempt"
+			opts = opts[1:]
+		default:
+			return RateLimitBucket{}, fmt.Errorf("option %q must be burst, exempt or noexempt", opts[0])
+		}
+	}
+	if len(parts) == 3 {
+		b.Key = parts[2]
+	}
+	if b.Key != "ip" && b.Key != "user" {
+		return RateLimitBucket{}, fmt.Errorf("key %q must be ip or user", b.Key)
+	}
+	return b, nil
+}
+
+func (b RateLimitBucket) String() string {
+	rate := fmt.Sprintf("%d/%s", b.Limit, b.Window)
+	if b.Burst > 0 {
+		rate += fmt.Sprintf(" burst %d", b.Burst)
+	}
+	switch defaultExempt := b.Name != "auth"; {
+	case b.Exempt && !defaultExempt:
+		rate += " exempt"
+	case !b.Exempt && defaultExempt:
+		rate += " noexempt"
+	}
+	return fmt.Sprintf("%s:%s:%s", b.Name, rate, b.Key)
+}
+
+// FeatureFlag is a flag from FEATURE_FLAGS with its default state.
+type FeatureFlag struct {
+	Name    string
+	Enabled bool
+	Rollout int // percent of users it is on for when enabled, by user ID; 100 for everyone
+}
+
+// ParseFeatureFlag parses "name[=state]", where state is on (the
+// default), off, or a rollout percentage such as "25%".
+func ParseFeatureFlag(spec string) (FeatureFlag, error) {
+	name, state, _ := strings.Cut(spec, "=")
+	f := FeatureFlag{Name: strings.TrimSpace(name), Enabled: true, Rollout: 100}
+	switch state = strings.TrimSpace(state); state {
+	case "", "on":
+	case "off":
+		f.Enabled = false
+	default:
+		pct, ok := strings.CutSuffix(state, "%")
+		n, err := strconv.Atoi(pct)
+		if !ok || err != nil || n < 0 || n > 100 {
+			return f, fmt.Errorf("want on, off or a percentage from 0%% to 100%%, got %q", state)
+		}
+		f.Rollout = n
+	}
+	return f, nil
+}
+
+func (f FeatureFlag) String() string {
+	switch {
+	case !f.Enabled:
+		return f.Name + "=off"
+	case f.Rollout < 100:
+		return fmt.Sprintf("%s=%d%%", f.Name, f.Rollout)
+	}
+	return f.Name + "=on"
+}
+
+// defaultJWTSecret is the development fallback for JWT_SECRET. Validate
+// refuses it in production.
+const defaultJWTSecret = "dev-jwt-secret-CHANGE-IN-PRODUCTION"
+
+// minBypassSecretLen is the shortest RATE_LIMIT_BYPASS_SECRET accepted in
+// production: the header lifts the rate limits, so it must not be
+// guessable.
+const minBypassSecretLen = 32
+
+// minJWTSecretLen is the shortest JWT secret accepted in production (256
+// bits for HS256).
+const minJWTSecretLen = 32
+
+// Load builds the configuration from environment variables and, if
+// CONFIG_FILE is set, a JSON or YAML file. File keys use the environment
+// variable names (case-insensitive; nested maps are joined with "_"), and
+// environment variables override file values. Unknown file keys are
+// warnings, or errors when CONFIG_STRICT is true. Malformed values are
+// reported together.
+func Load() (*Config, error) {
+	src := newConfigSource(os.LookupEnv)
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		file, err := readConfigFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("config file %s: %w", path, err)
+		}
+		src.file, src.path = file, path
+	}
+	return src.config()
+}
+
+// Defaults is the configuration with every setting at its default; the
+// environment and CONFIG_FILE are ignored. Test harnesses start from it.
+func Defaults() *Config {
+	cfg, err := newConfigSource(func(string) (string, bool) { return "", false }).config()
+	if err != nil {
+		panic(err) // the defaults are constants
+	}
+	return cfg
+}
+
+// config resolves every setting from src.
+func (src *configSource) config() (*Config, error) {
+	port := src.String("SERVER_PORT", "8080")
+	env := src.String("SERVER_ENVIRONMENT", "development")
+	cfg := &Config{
+		Port:               port,
+		Environment:        env,
+		AllowedOrigins:     src.List("CORS_ORIGINS", "http://localhost:5173"),
+		JWTSecret:          src.Secret("JWT_SECRET", defaultJWTSecret),
+		JWTSecretPrevious:  src.Secret("JWT_SECRET_PREVIOUS", ""),
+		UnsubscribeSecret:  src.Secret("UNSUBSCRIBE_SECRET", ""),
+		MaintenanceMode:    src.Bool("MAINTENANCE_MODE", false),
+		MaintenanceMessage: src.String("MAINTENANCE_MESSAGE", "service under maintenance, please try again later"),
+		RateLimitSweep:     src.Duration("RATE_LIMIT_SWEEP_INTERVAL", 5*time.Minute),
+		RateLimitMaxKeys:   src.Int("RATE_LIMIT_MAX_KEYS", 100_000),
+		ReadyCheckTimeout:  src.Duration("READY_CHECK_TIMEOUT", 2*time.Second),
+		ReadyCacheTTL:      src.Duration("READY_CACHE_TTL", 5*time.Second),
+		WarmupTimeout:      src.Duration("WARMUP_TIMEOUT", 30*time.Second),
+		EnableH2C:          src.Bool("ENABLE_H2C", false),
+		Listen:             src.List("SERVER_LISTEN", ":"+port),
+		SocketMode:         src.FileMode("SERVER_SOCKET_MODE", 0o660),
+		EnablePprof:        src.Bool("ENABLE_PPROF", false),
+		EnableDocs:         src.Bool("ENABLE_DOCS", env != "production"),
+		InternalAddr:       src.String("INTERNAL_ADDR", src.String("DEBUG_ADDR", "")),
+		AccessLogFormat:    src.String("ACCESS_LOG_FORMAT", "dev"),
+		AccessLogOutput:    src.String("ACCESS_LOG_OUTPUT", "stdout"),
+		AccessLogFilter: LogFilter{
+			Skip:        toSet(src.List("ACCESS_LOG_SKIP_PATHS", "/health,/ready,/version")),
+			Sample:      toSet(src.List("ACCESS_LOG_SAMPLE_PATHS", "")),
+			SampleRate:  src.Float("ACCESS_LOG_SAMPLE_RATE", 1),
+			SkipMetrics: src.Bool("ACCESS_LOG_SKIP_METRICS", false),
+		},
+		SlowThreshold:   src.Duration("SLOW_REQUEST_THRESHOLD", time.Second),
+		ErrorFormat:     src.String("ERROR_FORMAT", "json"),
+		ProblemTypeBase: src.String("PROBLEM_TYPE_BASE", ""),
+		Security: SecurityHeadersConfig{
+			CSPOverride:           src.String("CSP_OVERRIDE", ""),
+			CSPExtra:              src.String("CSP_EXTRA", ""),
+			FrameProtection:       src.String("FRAME_PROTECTION", "both"),
+			HSTSMaxAge:            src.Int("HSTS_MAX_AGE", 63072000),
+			HSTSIncludeSubDomains: src.Bool("HSTS_INCLUDE_SUBDOMAINS", true),
+			HSTSPreload:           src.Bool("HSTS_PRELOAD", true),
+			XSSProtection:         src.Bool("XSS_PROTECTION_HEADER", true),
+		},
+		DrainDelay:         src.Duration("DRAIN_DELAY", src.Duration("PRE_SHUTDOWN_DELAY", 5*time.Second)),
+		DrainGrace:         src.Duration("DRAIN_GRACE", 3*time.Second),
+		ShutdownTimeout:    src.Duration("SHUTDOWN_TIMEOUT", 30*time.Second),
+		MaxConcurrent:      src.Int("MAX_CONCURRENT_REQUESTS", 0),
+		MaxConcurrentAuth:  src.Int("MAX_CONCURRENT_AUTH", 0),
+		ConcurrencyWait:    src.Duration("CONCURRENCY_WAIT", 100*time.Millisecond),
+		RateLimitBuckets:   src.Buckets("RATE_LIMIT_BUCKETS", "auth:10/1m:ip, api:100/1m:ip"),
+		RateLimitRoutes:    src.Map("RATE_LIMIT_ROUTES", ""),
+		LoginFailureLimit:  src.Int("LOGIN_FAILURE_LIMIT", 5),
+		LoginFailureWindow: src.Duration("LOGIN_FAILURE_WINDOW", time.Minute),
+		AccessTokenTTL:     src.Duration("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL:    src.Duration("REFRESH_TOKEN_TTL", 7*24*time.Hour),
+		RefreshSliding:     src.Bool("REFRESH_SLIDING", true),
+		MaxSessionAge:      src.Duration("REFRESH_MAX_SESSION_AGE", 30*24*time.Hour),
+		MaxSessionLifetime: src.Duration("MAX_SESSION_LIFETIME", 0),
+		CSRFTokenTTL:       src.Duration("CSRF_TOKEN_TTL", 24*time.Hour),
+		CSRFExemptBearer:   src.Bool("CSRF_EXEMPT_BEARER", false),
+		ReauthMaxAge:       src.Duration("REAUTH_MAX_AGE", 10*time.Minute),
+		TokenExpiryHeaders: src.Bool("TOKEN_EXPIRY_HEADERS", true),
+		DeletionGrace:      src.Duration("ACCOUNT_DELETION_GRACE", 14*24*time.Hour),
+		PurgeInterval:      src.Duration("ACCOUNT_PURGE_INTERVAL", time.Hour),
+		ReadTimeout:        src.Duration("SERVER_READ_TIMEOUT", 10*time.Second),
+		ReadHeaderTimeout:  src.Duration("SERVER_READ_HEADER_TIMEOUT", 5*time.Second),
+		WriteTimeout:       src.Duration("SERVER_WRITE_TIMEOUT", 15*time.Second),
+		IdleTimeout:        src.Duration("SERVER_IDLE_TIMEOUT", 120*time.Second),
+		AuditLogOutput:     src.String("AUDIT_LOG_OUTPUT", "stdout"),
+		AuditLogRetention:  src.Int("AUDIT_LOG_RETENTION", 10000),
+		WebhookWorkers:     src.Int("WEBHOOK_WORKERS", 4),
+		WebhookQueueSize:   src.Int("WEBHOOK_QUEUE_SIZE", 1000),
+		WebhookMaxAttempts: src.Int("WEBHOOK_MAX_ATTEMPTS", 6),
+		WebhookBackoff:     src.Duration("WEBHOOK_BACKOFF", time.Second),
+		WebhookTimeout:     src.Duration("WEBHOOK_TIMEOUT", 10*time.Second),
+		WebhookTripAfter:   src.Int("WEBHOOK_BREAKER_THRESHOLD", 5),
+		WebhookCooldown:    src.Duration("WEBHOOK_BREAKER_COOLDOWN", time.Minute),
+		GRPCAddr:           src.String("GRPC_ADDR", ""),
+		GRPCRateLimits:     src.Map("GRPC_RATE_LIMITS", "*=api"),
+		WSMaxConnections:   src.Int("WS_MAX_CONNECTIONS", 100),
+		WSPingInterval:     src.Duration("WS_PING_INTERVAL", 30*time.Second),
+		SSEMaxStreams:      src.Int("SSE_MAX_STREAMS", 100),
+		MailDriver:         src.String("MAIL_DRIVER", "log"),
+		MailFrom:           src.String("MAIL_FROM", "Raijin <no-reply@localhost>"),
+		MailWorkers:        src.Int("MAIL_WORKERS", 2),
+		MailQueueSize:      src.Int("MAIL_QUEUE_SIZE", 1000),
+		MailMaxAttempts:    src.Int("MAIL_MAX_ATTEMPTS", 5),
+		MailBackoff:        src.Duration("MAIL_BACKOFF", 2*time.Second),
+		SMTPHost:           src.String("SMTP_HOST", ""),
+		SMTPPort:           src.Int("SMTP_PORT", 587),
+		SMTPUsername:       src.String("SMTP_USERNAME", ""),
+		SMTPPassword:       src.Secret("SMTP_PASSWORD", ""),
+		SMTPTLS:            src.String("SMTP_TLS", "starttls"),
+		SMTPTimeout:        src.Duration("SMTP_TIMEOUT", 10*time.Second),
+		EmailTemplatesDir:  src.String("EMAIL_TEMPLATES_DIR", ""),
+		AppURL:             strings.TrimSuffix(src.String("APP_URL", "http://localhost:5173"), "/"),
+		NewDeviceAlerts:    src.Bool("NEW_DEVICE_ALERTS", true),
+		SAML: SAMLConfig{
+			IdPSSOURL:      src.String("SAML_IDP_SSO_URL", ""),
+			IdPEntityID:    src.String("SAML_IDP_ENTITY_ID", ""),
+			IdPCertPath:    src.String("SAML_IDP_CERT_PATH", ""),
+			BaseURL:        strings.TrimSuffix(src.String("SAML_SP_BASE_URL", ""), "/"),
+			EntityID:       src.String("SAML_SP_ENTITY_ID", ""),
+			EmailAttribute: src.String("SAML_EMAIL_ATTRIBUTE", ""),
+			NameAttribute:  src.String("SAML_NAME_ATTRIBUTE", "displayName"),
+			RoleAttribute:  src.String("SAML_ROLE_ATTRIBUTE", ""),
+			ClockSkew:      src.Duration("SAML_CLOCK_SKEW", 2*time.Minute),
+		},
+		Captcha: CaptchaConfig{
+			Provider:    src.String("CAPTCHA_PROVIDER", ""),
+			Secret:      src.Secret("CAPTCHA_SECRET", ""),
+			VerifyURL:   src.String("CAPTCHA_VERIFY_URL", ""),
+			Timeout:     src.Duration("CAPTCHA_TIMEOUT", 5*time.Second),
+			FailOpen:    src.Bool("CAPTCHA_FAIL_OPEN", false),
+			Register:    src.Bool("CAPTCHA_REGISTER", true),
+			LoginAfter:  src.Int("CAPTCHA_LOGIN_AFTER", 3),
+			LoginWindow: src.Duration("CAPTCHA_LOGIN_WINDOW", 15*time.Minute),
+		},
+		SMS: SMSConfig{
+			Driver:     src.String("SMS_DRIVER", ""),
+			URL:        src.String("SMS_HTTP_URL", ""),
+			Token:      src.Secret("SMS_HTTP_TOKEN", ""),
+			Timeout:    src.Duration("SMS_TIMEOUT", 10*time.Second),
+			PhoneLimit: src.Int("OTP_PHONE_LIMIT", 5),
+			IPLimit:    src.Int("OTP_IP_LIMIT", 20),
+			Window:     src.Duration("OTP_LIMIT_WINDOW", time.Hour),
+		},
+		Pwned: PwnedConfig{
+			Policy:    src.String("BREACH_CHECK", "off"),
+			URL:       src.String("BREACH_CHECK_URL", "https://api.pwnedpasswords.com/range/"),
+			Timeout:   src.Duration("BREACH_CHECK_TIMEOUT", 2*time.Second),
+			CacheSize: src.Int("BREACH_CHECK_CACHE_SIZE", 1024),
+		},
+		Roles: src.List("ROLES", ""),
+		Domains: DomainsConfig{
+			Allowed: src.List("REGISTRATION_ALLOWED_DOMAINS", ""),
+			Blocked: src.List("REGISTRATION_BLOCKED_DOMAINS", ""),
+		},
+		FeatureFlags:       src.FeatureFlags("FEATURE_FLAGS", ""),
+		AdminStatsCacheTTL: src.Duration("ADMIN_STATS_CACHE_TTL", 30*time.Second),
+		Disposable: DisposableConfig{
+			Action:  src.String("DISPOSABLE_EMAIL_ACTION", "off"),
+			ListURL: src.String("DISPOSABLE_EMAIL_LIST_URL", ""),
+			Refresh: src.Duration("DISPOSABLE_EMAIL_REFRESH", 24*time.Hour),
+		},
+		RateLimitExempt: RateLimitExemptConfig{
+			CIDRs:          src.List("RATE_LIMIT_EXEMPT_CIDRS", ""),
+			TrustedProxies: src.List("TRUSTED_PROXIES", ""),
+			BypassSecret:   src.Secret("RATE_LIMIT_BYPASS_SECRET", ""),
+		},
+		Static: StaticConfig{
+			Enabled:  src.Bool("ENABLE_STATIC", false),
+			Dir:      src.String("STATIC_DIR", ""),
+			CSPExtra: src.String("STATIC_CSP_EXTRA", ""),
+		},
+		BodyLog: BodyLogConfig{
+			Enabled:         src.Bool("BODY_LOG_ENABLED", false),
+			AllowProduction: src.Bool("BODY_LOG_ALLOW_PRODUCTION", false),
+			MaxBytes:        src.Int("BODY_LOG_MAX_BYTES", 8<<10),
+			RedactFields:    src.List("BODY_LOG_REDACT_FIELDS", "password,current_password,new_password,access_token,refresh_token,csrf_token,captcha_token,token,code,secret"),
+		},
+		ProxyProtocol: ProxyProtocolConfig{
+			Enabled: src.Bool("PROXY_PROTOCOL", false),
+			Allowed: src.List("PROXY_PROTOCOL_ALLOWED_CIDRS", ""),
+			Timeout: src.Duration("PROXY_PROTOCOL_TIMEOUT", 5*time.Second),
+		},
+		Outbound: OutboundConfig{
+			MaxConns:  src.Int("OUTBOUND_MAX_CONNS", 32),
+			TripAfter: src.Int("OUTBOUND_BREAKER_THRESHOLD", 5),
+			Cooldown:  src.Duration("OUTBOUND_BREAKER_COOLDOWN", 30*time.Second),
+		},
+		InternalAuth: InternalAuthConfig{
+			Callers: src.InternalCallers("INTERNAL_CALLERS", ""),
+			Secrets: src.SecretMap("INTERNAL_CALLER_SECRETS"),
+			MaxSkew: src.Duration("INTERNAL_AUTH_MAX_SKEW", 5*time.Minute),
+		},
+		Proxy: ProxyConfig{
+			Routes: src.ProxyRoutes("PROXY_ROUTES", ""),
+			Secret: src.Secret("PROXY_SECRET", ""),
+		},
+		Terms: TermsConfig{
+			Version:        src.String("TERMS_VERSION", ""),
+			PrivacyVersion: src.String("PRIVACY_VERSION", ""),
+		},
+		sources: src.sources,
+	}
+	if _, ok := src.sources["INTERNAL_ADDR"]; !ok && src.sources["DEBUG_ADDR"] != "" {
+		src.sources["INTERNAL_ADDR"] = src.sources["DEBUG_ADDR"] + " (DEBUG_ADDR)"
+	}
+	if _, ok := src.sources["DRAIN_DELAY"]; !ok && src.sources["PRE_SHUTDOWN_DELAY"] != "" {
+		src.sources["DRAIN_DELAY"] = src.sources["PRE_SHUTDOWN_DELAY"] + " (PRE_SHUTDOWN_DELAY)"
+	}
+
+	strict := src.Bool("CONFIG_STRICT", false)
+	for _, key := range src.unknown() {
+		if strict {
+			src.errs = append(src.errs, fmt.Errorf("%s: unknown key in %s", key, src.path))
+		} else {
+			log.Printf("WARN config: unknown key %s in %s (ignored)", key, src.path)
+		}
+	}
+	if err := errors.Join(src.errs...); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate checks the configuration for values the server cannot run with
+// and reports every problem at once. Risky-but-workable settings (default
+// JWT secret, empty CORS list) are errors in production and only logged as
+// warnings elsewhere.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+	risky := func(format string, args ...any) {
+		if c.Environment == "production" {
+			fail(format, args...)
+		} else {
+			log.Printf("WARN config: "+format, args...)
+		}
+	}
+
+	walkConfig(reflect.ValueOf(c).Elem(), func(key string, secret bool, _ reflect.Value) {
+		if !secret && secretLikeKey.MatchString(key) {
+			fail("%s looks like a secret but its Config field lacks the config:\"...,secret\" tag", key)
+		}
+	})
+	if n, err := strconv.Atoi(c.Port); err != nil || n < 1 || n > 65535 {
+		fail("SERVER_PORT: %q is not a port number", c.Port)
+	}
+	if c.JWTSecret == defaultJWTSecret {
+		risky("JWT_SECRET: using the development default")
+	} else if len(c.JWTSecret) < minJWTSecretLen {
+		risky("JWT_SECRET: must be at least %d bytes, got %d", minJWTSecretLen, len(c.JWTSecret))
+	}
+	if c.JWTSecretPrevious != "" && c.JWTSecretPrevious == c.JWTSecret {
+		fail("JWT_SECRET_PREVIOUS: same as JWT_SECRET; set the new secret as JWT_SECRET")
+	}
+	if c.UnsubscribeSecret != "" && len(c.UnsubscribeSecret) < minJWTSecretLen {
+		risky("UNSUBSCRIBE_SECRET: must be at least %d bytes, got %d", minJWTSecretLen, len(c.UnsubscribeSecret))
+	}
+
+	if len(c.AllowedOrigins) == 0 {
+		risky("CORS_ORIGINS: empty, browsers on other origins will be rejected")
+	}
+	for _, o := range c.AllowedOrigins {
+		u, err := url.Parse(o)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			fail("CORS_ORIGINS: %q is not an origin (scheme://host[:port])", o)
+		}
+	}
+
+	for _, addr := range c.Listen {
+		if path, ok := strings.CutPrefix(addr, "unix://"); ok {
+			if path == "" {
+				fail("SERVER_LISTEN: %q has no socket path", addr)
+			}
+		} else if _, _, err := net.SplitHostPort(addr); err != nil {
+			fail("SERVER_LISTEN: %q: %v", addr, err)
+		}
+		if c.InternalAddr != "" && addr == c.InternalAddr {
+			fail("INTERNAL_ADDR: %q is also a public listen address", addr)
+		}
+		if c.GRPCAddr != "" && addr == c.GRPCAddr {
+			fail("GRPC_ADDR: %q is also a public listen address", addr)
+		}
+	}
+	if len(c.Listen) == 0 {
+		fail("SERVER_LISTEN: no listen addresses")
+	}
+	if c.InternalAddr != "" {
+		if _, _, err := net.SplitHostPort(c.InternalAddr); err != nil {
+			fail("INTERNAL_ADDR: %q: %v", c.InternalAddr, err)
+		}
+	}
+	if c.GRPCAddr != "" {
+		if path, ok := strings.CutPrefix(c.GRPCAddr, "unix://"); ok {
+			if path == "" {
+				fail("GRPC_ADDR: %q has no socket path", c.GRPCAddr)
+			}
+		} else if _, _, err := net.SplitHostPort(c.GRPCAddr); err != nil {
+			fail("GRPC_ADDR: %q: %v", c.GRPCAddr, err)
+		}
+		if c.GRPCAddr == c.InternalAddr {
+			fail("GRPC_ADDR: %q is also INTERNAL_ADDR", c.GRPCAddr)
+		}
+	}
+
+	switch c.AccessLogFormat {
+	case "dev", "json", "combined":
+	default:
+		fail("ACCESS_LOG_FORMAT: unknown format %q", c.AccessLogFormat)
+	}
+	if r := c.AccessLogFilter.SampleRate; r < 0 || r > 1 {
+		fail("ACCESS_LOG_SAMPLE_RATE: %v is outside [0, 1]", r)
+	}
+	if len(c.AccessLogFilter.Sample) > 0 && c.AccessLogFilter.SampleRate == 1 {
+		log.Printf("WARN config: ACCESS_LOG_SAMPLE_PATHS set but ACCESS_LOG_SAMPLE_RATE is 1, nothing is sampled out")
+	}
+	if c.ErrorFormat != "json" && c.ErrorFormat != "problem" {
+		fail("ERROR_FORMAT: %q is not json or problem", c.ErrorFormat)
+	}
+	if c.ProblemTypeBase != "" {
+		if u, err := url.Parse(c.ProblemTypeBase); err != nil || !u.IsAbs() {
+			fail("PROBLEM_TYPE_BASE: %q is not an absolute URL", c.ProblemTypeBase)
+		}
+	}
+	switch c.Security.FrameProtection {
+	case "both", "x-frame-options", "frame-ancestors":
+	default:
+		fail("FRAME_PROTECTION: %q is not both, x-frame-options or frame-ancestors", c.Security.FrameProtection)
+	}
+	if c.Security.HSTSMaxAge < 0 {
+		fail("HSTS_MAX_AGE: must not be negative")
+	}
+	if c.Security.HSTSPreload && (!c.Security.HSTSIncludeSubDomains || c.Security.HSTSMaxAge < 31536000) {
+		risky("HSTS_PRELOAD: preload lists require HSTS_INCLUDE_SUBDOMAINS and HSTS_MAX_AGE >= 31536000")
+	}
+
+	inRange := func(key string, d, lo, hi time.Duration) {
+		if d < lo || d > hi {
+			fail("%s: %s is outside [%s, %s]", key, d, lo, hi)
+		}
+	}
+	inRange("ACCESS_TOKEN_TTL", c.AccessTokenTTL, time.Minute, 24*time.Hour)
+	inRange("REFRESH_TOKEN_TTL", c.RefreshTokenTTL, time.Hour, 90*24*time.Hour)
+	inRange("CSRF_TOKEN_TTL", c.CSRFTokenTTL, time.Minute, 7*24*time.Hour)
+	inRange("REAUTH_MAX_AGE", c.ReauthMaxAge, time.Minute, 24*time.Hour)
+	inRange("ACCOUNT_DELETION_GRACE", c.DeletionGrace, time.Minute, 365*24*time.Hour)
+	inRange("ACCOUNT_PURGE_INTERVAL", c.PurgeInterval, time.Second, 24*time.Hour)
+	if c.RefreshTokenTTL < c.AccessTokenTTL {
+		fail("REFRESH_TOKEN_TTL (%s) is shorter than ACCESS_TOKEN_TTL (%s)", c.RefreshTokenTTL, c.AccessTokenTTL)
+	}
+	if c.MaxSessionLifetime != 0 {
+		inRange("MAX_SESSION_LIFETIME", c.MaxSessionLifetime, time.Minute, 365*24*time.Hour)
+	}
+	if c.RefreshSliding {
+		inRange("REFRESH_MAX_SESSION_AGE", c.MaxSessionAge, time.Hour, 365*24*time.Hour)
+		if c.MaxSessionAge < c.RefreshTokenTTL {
+			fail("REFRESH_MAX_SESSION_AGE (%s) is shorter than REFRESH_TOKEN_TTL (%s)", c.MaxSessionAge, c.RefreshTokenTTL)
+		}
+	}
+	inRange("SHUTDOWN_TIMEOUT", c.ShutdownTimeout, time.Second, 10*time.Minute)
+	inRange("WARMUP_TIMEOUT", c.WarmupTimeout, time.Second, 10*time.Minute)
+	// POST /internal/drain holds its request for DRAIN_DELAY, within the
+	// internal listener's 2m WriteTimeout.
+	inRange("DRAIN_DELAY", c.DrainDelay, 0, time.Minute)
+	// 0 computes the admin stats on every request.
+	inRange("ADMIN_STATS_CACHE_TTL", c.AdminStatsCacheTTL, 0, time.Hour)
+	inRange("SERVER_READ_HEADER_TIMEOUT", c.ReadHeaderTimeout, time.Second, 5*time.Minute)
+	// 0 disables the remaining server timeouts, as in http.Server.
+	inRange("SERVER_READ_TIMEOUT", c.ReadTimeout, 0, time.Hour)
+	inRange("SERVER_WRITE_TIMEOUT", c.WriteTimeout, 0, time.Hour)
+	inRange("SERVER_IDLE_TIMEOUT", c.IdleTimeout, 0, time.Hour)
+	if c.ReadTimeout > 0 && c.ReadTimeout < c.ReadHeaderTimeout {
+		fail("SERVER_READ_TIMEOUT (%s) is shorter than SERVER_READ_HEADER_TIMEOUT (%s)", c.ReadTimeout, c.ReadHeaderTimeout)
+	}
+
+	buckets := make(map[string]bool, len(c.RateLimitBuckets))
+	for _, b := range c.RateLimitBuckets {
+		if buckets[b.Name] {
+			fail("RATE_LIMIT_BUCKETS: bucket %q defined twice", b.Name)
+		}
+		buckets[b.Name] = true
+	}
+	for route, name := range c.RateLimitRoutes {
+		if !buckets[name] {
+			fail("RATE_LIMIT_ROUTES: %q uses undefined bucket %q", route, name)
+		}
+	}
+	for method, name := range c.GRPCRateLimits {
+		if !buckets[name] {
+			fail("GRPC_RATE_LIMITS: %q uses undefined bucket %q", method, name)
+		}
+	}
+	if c.RateLimitMaxKeys < 1 {
+		fail("RATE_LIMIT_MAX_KEYS: must be at least 1")
+	}
+	if _, err := ParseCIDRs(c.RateLimitExempt.CIDRs); err != nil {
+		fail("RATE_LIMIT_EXEMPT_CIDRS: %v", err)
+	}
+	if _, err := ParseCIDRs(c.RateLimitExempt.TrustedProxies); err != nil {
+		fail("TRUSTED_PROXIES: %v", err)
+	}
+	if _, err := ParseCIDRs(c.ProxyProtocol.Allowed); err != nil {
+		fail("PROXY_PROTOCOL_ALLOWED_CIDRS: %v", err)
+	} else if len(c.ProxyProtocol.Allowed) > 0 && !c.ProxyProtocol.Enabled {
+		fail("PROXY_PROTOCOL_ALLOWED_CIDRS: set without PROXY_PROTOCOL=true")
+	}
+	if c.ProxyProtocol.Timeout <= 0 {
+		fail("PROXY_PROTOCOL_TIMEOUT: must be positive")
+	}
+	if secret := c.RateLimitExempt.BypassSecret; secret != "" && len(secret) < minBypassSecretLen {
+		risky("RATE_LIMIT_BYPASS_SECRET: shorter than %d characters", minBypassSecretLen)
+	}
+	if c.LoginFailureLimit < 0 {
+		fail("LOGIN_FAILURE_LIMIT: must not be negative")
+	}
+	inRange("LOGIN_FAILURE_WINDOW", c.LoginFailureWindow, time.Second, 24*time.Hour)
+	if c.WebhookWorkers < 1 || c.WebhookQueueSize < 1 || c.WebhookMaxAttempts < 1 {
+		fail("WEBHOOK_WORKERS, WEBHOOK_QUEUE_SIZE and WEBHOOK_MAX_ATTEMPTS must be at least 1")
+	}
+	inRange("WEBHOOK_BACKOFF", c.WebhookBackoff, 10*time.Millisecond, time.Hour)
+	inRange("WEBHOOK_TIMEOUT", c.WebhookTimeout, time.Second, 5*time.Minute)
+	if c.WebhookTripAfter < 1 {
+		fail("WEBHOOK_BREAKER_THRESHOLD: must be at least 1")
+	}
+	inRange("WEBHOOK_BREAKER_COOLDOWN", c.WebhookCooldown, time.Second, time.Hour)
+	if c.Outbound.MaxConns < 1 || c.Outbound.MaxConns > 1000 {
+		fail("OUTBOUND_MAX_CONNS: must be between 1 and 1000")
+	}
+	if c.Outbound.TripAfter < 0 {
+		fail("OUTBOUND_BREAKER_THRESHOLD: must not be negative")
+	}
+	inRange("OUTBOUND_BREAKER_COOLDOWN", c.Outbound.Cooldown, time.Second, time.Hour)
+	if c.AuditLogRetention < 0 {
+		fail("AUDIT_LOG_RETENTION: must not be negative")
+	}
+	if c.MaxConcurrent < 0 {
+		fail("MAX_CONCURRENT_REQUESTS: must not be negative")
+	}
+	if c.MaxConcurrentAuth < 0 {
+		fail("MAX_CONCURRENT_AUTH: must not be negative")
+	}
+	if c.MaxConcurrent > 0 && c.MaxConcurrentAuth > c.MaxConcurrent {
+		log.Printf("WARN config: MAX_CONCURRENT_AUTH (%d) exceeds MAX_CONCURRENT_REQUESTS (%d)", c.MaxConcurrentAuth, c.MaxConcurrent)
+	}
+	if c.WSMaxConnections < 1 {
+		fail("WS_MAX_CONNECTIONS: must be at least 1")
+	}
+	if c.SSEMaxStreams < 1 {
+		fail("SSE_MAX_STREAMS: must be at least 1")
+	}
+	inRange("WS_PING_INTERVAL", c.WSPingInterval, time.Second, 10*time.Minute)
+	switch c.MailDriver {
+	case "log":
+		if c.Environment == "production" {
+			log.Printf("WARN config: MAIL_DRIVER=log: emails are written to the log, not sent")
+		}
+	case "smtp":
+		if c.SMTPHost == "" {
+			fail("SMTP_HOST: required with MAIL_DRIVER=smtp")
+		}
+	default:
+		fail("MAIL_DRIVER: %q is not log or smtp", c.MailDriver)
+	}
+	if _, err := mail.ParseAddress(c.MailFrom); err != nil {
+		fail("MAIL_FROM: %v", err)
+	}
+	if c.MailWorkers < 1 || c.MailQueueSize < 1 || c.MailMaxAttempts < 1 {
+		fail("MAIL_WORKERS, MAIL_QUEUE_SIZE and MAIL_MAX_ATTEMPTS must be at least 1")
+	}
+	inRange("MAIL_BACKOFF", c.MailBackoff, 10*time.Millisecond, time.Hour)
+	if c.SMTPPort < 1 || c.SMTPPort > 65535 {
+		fail("SMTP_PORT: %d is not a port", c.SMTPPort)
+	}
+	if !slices.Contains([]string{"starttls", "tls", "none"}, c.SMTPTLS) {
+		fail("SMTP_TLS: %q is not starttls, tls or none", c.SMTPTLS)
+	}
+	inRange("SMTP_TIMEOUT", c.SMTPTimeout, time.Second, 5*time.Minute)
+	if c.EmailTemplatesDir != "" {
+		if st, err := os.Stat(c.EmailTemplatesDir); err != nil || !st.IsDir() {
+			fail("EMAIL_TEMPLATES_DIR: %q is not a directory", c.EmailTemplatesDir)
+		}
+	}
+	if c.Static.Dir != "" {
+		if !c.Static.Enabled {
+			fail("STATIC_DIR: set without ENABLE_STATIC=true")
+		} else if st, err := os.Stat(filepath.Join(c.Static.Dir, "index.html")); err != nil || !st.Mode().IsRegular() {
+			fail("STATIC_DIR: %q has no index.html", c.Static.Dir)
+		}
+	}
+	if c.BodyLog.Enabled {
+		if c.Environment == "production" && !c.BodyLog.AllowProduction {
+			fail("BODY_LOG_ENABLED: refused in production; set BODY_LOG_ALLOW_PRODUCTION=true as well to log bodies anyway")
+		}
+		if c.BodyLog.MaxBytes < 1 {
+			fail("BODY_LOG_MAX_BYTES: must be at least 1")
+		}
+	}
+	prefixes := make(map[string]bool, len(c.Proxy.Routes))
+	for _, p := range c.Proxy.Routes {
+		if !strings.HasPrefix(p.Prefix, "/") || strings.ContainsAny(p.Prefix, "{} ") {
+			fail("PROXY_ROUTES: prefix %q must be a path below / without wildcards", p.Prefix)
+		}
+		if prefixes[p.Prefix] {
+			fail("PROXY_ROUTES: prefix %q proxied twice", p.Prefix)
+		}
+		prefixes[p.Prefix] = true
+		if u, err := url.Parse(p.Upstream); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("PROXY_ROUTES: upstream %q for %s is not an http(s) URL", p.Upstream, p.Prefix)
+		}
+	}
+	if len(c.Proxy.Routes) > 0 {
+		if c.Proxy.Secret == "" {
+			fail("PROXY_SECRET: required with PROXY_ROUTES, so upstreams can verify X-User-ID")
+		} else if len(c.Proxy.Secret) < minJWTSecretLen {
+			risky("PROXY_SECRET: must be at least %d bytes, got %d", minJWTSecretLen, len(c.Proxy.Secret))
+		}
+	}
+	callers := make(map[string]bool, len(c.InternalAuth.Callers))
+	for _, ic := range c.InternalAuth.Callers {
+		if !callerPattern.MatchString(ic.Name) {
+			fail("INTERNAL_CALLERS: %q is not a caller name (letters, digits, ., _ and -)", ic.Name)
+		}
+		if callers[ic.Name] {
+			fail("INTERNAL_CALLERS: caller %q defined twice", ic.Name)
+		}
+		callers[ic.Name] = true
+		if ic.Role != "user" && ic.Role != "admin" && !slices.Contains(c.Roles, ic.Role) {
+			fail("INTERNAL_CALLERS: role %q of %s is not user, admin or one of ROLES", ic.Role, ic.Name)
+		}
+		for _, scope := range ic.Scopes {
+			if !strings.HasPrefix(scope, "/") || strings.ContainsAny(scope, "{} ") {
+				fail("INTERNAL_CALLERS: scope %q of %s must be a path below / without wildcards", scope, ic.Name)
+			}
+		}
+		switch secret := c.InternalAuth.Secrets[ic.Name]; {
+		case secret == "":
+			fail("INTERNAL_CALLER_SECRETS: no key for caller %q", ic.Name)
+		case len(secret) < minJWTSecretLen:
+			risky("INTERNAL_CALLER_SECRETS: key of %s must be at least %d bytes, got %d", ic.Name, minJWTSecretLen, len(secret))
+		}
+	}
+	for name := range c.InternalAuth.Secrets {
+		if !callers[name] {
+			fail("INTERNAL_CALLER_SECRETS: key for %q, which is not in INTERNAL_CALLERS", name)
+		}
+	}
+	if len(c.InternalAuth.Callers) > 0 {
+		inRange("INTERNAL_AUTH_MAX_SKEW", c.InternalAuth.MaxSkew, 10*time.Second, time.Hour)
+	}
+	if u, err := url.Parse(c.AppURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		fail("APP_URL: %q is not an http(s) URL", c.AppURL)
+	}
+	if c.SAML.Enabled() {
+		for _, kv := range [][2]string{{"SAML_IDP_SSO_URL", c.SAML.IdPSSOURL}, {"SAML_SP_BASE_URL", c.SAML.BaseURL}} {
+			if u, err := url.Parse(kv[1]); err != nil || !u.IsAbs() {
+				fail("%s: %q is not an absolute URL", kv[0], kv[1])
+			}
+		}
+		if c.SAML.IdPEntityID == "" {
+			fail("SAML_IDP_ENTITY_ID: required with SAML_IDP_SSO_URL")
+		}
+		if c.SAML.IdPCertPath == "" {
+			fail("SAML_IDP_CERT_PATH: required with SAML_IDP_SSO_URL")
+		} else if data, err := os.ReadFile(c.SAML.IdPCertPath); err != nil {
+			fail("SAML_IDP_CERT_PATH: %v", err)
+		} else if block, _ := pem.Decode(data); block == nil || block.Type != "CERTIFICATE" {
+			fail("SAML_IDP_CERT_PATH: %s holds no PEM certificate", c.SAML.IdPCertPath)
+		} else if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			fail("SAML_IDP_CERT_PATH: %v", err)
+		}
+		if strings.HasPrefix(c.SAML.BaseURL, "http://") && c.Environment == "production" {
+			risky("SAML_SP_BASE_URL: assertions would be posted over plain HTTP")
+		}
+		inRange("SAML_CLOCK_SKEW", c.SAML.ClockSkew, 0, 10*time.Minute)
+	}
+	if c.Captcha.Enabled() {
+		switch c.Captcha.Provider {
+		case "hcaptcha", "turnstile", "recaptcha":
+		case "static":
+			risky("CAPTCHA_PROVIDER: static accepts a fixed token and stops no bot")
+		default:
+			fail("CAPTCHA_PROVIDER: %q is not hcaptcha, turnstile, recaptcha or static", c.Captcha.Provider)
+		}
+		if c.Captcha.Secret == "" {
+			fail("CAPTCHA_SECRET: required with CAPTCHA_PROVIDER")
+		}
+		if c.Captcha.VerifyURL != "" {
+			if u, err := url.Parse(c.Captcha.VerifyURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				fail("CAPTCHA_VERIFY_URL: %q is not an http(s) URL", c.Captcha.VerifyURL)
+			}
+		}
+		inRange("CAPTCHA_TIMEOUT", c.Captcha.Timeout, 100*time.Millisecond, time.Minute)
+		if c.Captcha.LoginAfter < 0 {
+			fail("CAPTCHA_LOGIN_AFTER: must not be negative")
+		}
+		inRange("CAPTCHA_LOGIN_WINDOW", c.Captcha.LoginWindow, time.Second, 24*time.Hour)
+		if c.Captcha.FailOpen && c.Environment == "production" {
+			log.Printf("WARN config: CAPTCHA_FAIL_OPEN: registrations and logins skip the challenge while the provider is down")
+		}
+	}
+	switch c.Pwned.Policy {
+	case "off":
+	case "warn", "block":
+		if u, err := url.Parse(c.Pwned.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("BREACH_CHECK_URL: %q is not an http(s) URL", c.Pwned.URL)
+		}
+		inRange("BREACH_CHECK_TIMEOUT", c.Pwned.Timeout, 100*time.Millisecond, 30*time.Second)
+		if c.Pwned.CacheSize < 0 {
+			fail("BREACH_CHECK_CACHE_SIZE: must not be negative")
+		}
+	default:
+		fail("BREACH_CHECK: %q is not off, warn or block", c.Pwned.Policy)
+	}
+	for _, role := range c.Roles {
+		if !rolePattern.MatchString(role) {
+			fail("ROLES: %q is not a role name (lowercase letters, digits, _ and -)", role)
+		}
+	}
+	flags := make(map[string]bool, len(c.FeatureFlags))
+	for _, f := range c.FeatureFlags {
+		if !rolePattern.MatchString(f.Name) {
+			fail("FEATURE_FLAGS: %q is not a flag name (lowercase letters, digits, _ and -)", f.Name)
+		}
+		if flags[f.Name] {
+			fail("FEATURE_FLAGS: flag %q defined twice", f.Name)
+		}
+		flags[f.Name] = true
+	}
+	checkDomains := func(key string, domains []string) {
+		for _, d := range domains {
+			if !domainPattern.MatchString(strings.TrimPrefix(d, "*.")) {
+				fail("%s: %q is not a domain or *.domain", key, d)
+			}
+		}
+	}
+	checkDomains("REGISTRATION_ALLOWED_DOMAINS", c.Domains.Allowed)
+	checkDomains("REGISTRATION_BLOCKED_DOMAINS", c.Domains.Blocked)
+	switch c.Disposable.Action {
+	case "off":
+	case "reject", "flag":
+		if c.Disposable.ListURL != "" {
+			if u, err := url.Parse(c.Disposable.ListURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				fail("DISPOSABLE_EMAIL_LIST_URL: %q is not an http(s) URL", c.Disposable.ListURL)
+			}
+			inRange("DISPOSABLE_EMAIL_REFRESH", c.Disposable.Refresh, time.Minute, 7*24*time.Hour)
+		}
+	default:
+		fail("DISPOSABLE_EMAIL_ACTION: %q is not off, reject or flag", c.Disposable.Action)
+	}
+	if c.SMS.Enabled() {
+		switch c.SMS.Driver {
+		case "log":
+			risky("SMS_DRIVER: log writes one-time codes to the log instead of sending them")
+		case "http":
+			if u, err := url.Parse(c.SMS.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				fail("SMS_HTTP_URL: %q is not an http(s) URL (required with SMS_DRIVER=http)", c.SMS.URL)
+			}
+		default:
+			fail("SMS_DRIVER: %q is not log or http", c.SMS.Driver)
+		}
+		inRange("SMS_TIMEOUT", c.SMS.Timeout, 100*time.Millisecond, time.Minute)
+		if c.SMS.PhoneLimit < 1 || c.SMS.IPLimit < 1 {
+			fail("OTP_PHONE_LIMIT and OTP_IP_LIMIT must be at least 1")
+		}
+		inRange("OTP_LIMIT_WINDOW", c.SMS.Window, time.Minute, 24*time.Hour)
+	}
+	return errors.Join(errs...)
+}
+
+// EffectiveSetting is one resolved setting as reported by Effective.
+type EffectiveSetting struct {
+	Value  string `json:"value"`
+	Source string `json:"source"` // env, file, secret file or default
+}
+
+// rolePattern is a role name in ROLES.
+var rolePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
+
+// callerPattern is a caller name in INTERNAL_CALLERS.
+var callerPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// domainPattern loosely matches a domain name: dot-separated labels of
+// anything but spaces, "@", "*" and dots. IDN labels may be given in
+// Unicode or as punycode.
+var domainPattern = regexp.MustCompile(`^[^\s@*.]+(\.[^\s@*.]+)*$`)
+
+// secretLikeKey matches setting names that must carry the secret tag.
+// Effective redacts them regardless and Validate refuses to start, so a new
+// secret field cannot leak by forgetting the tag.
+var secretLikeKey = regexp.MustCompile(`SECRET|PASSWORD|PASSWD|PRIVATE_KEY|API_KEY|ENCRYPTION_KEY|CREDENTIAL|DATABASE_URL|DSN`)
+
+// Effective lists every tagged setting with its value and source. Secrets
+// are replaced by a fingerprint ("sha256:" and 12 hex digits) so operators
+// can tell which secret is loaded without seeing it.
+func (c *Config) Effective() map[string]EffectiveSetting {
+	out := make(map[string]EffectiveSetting)
+	walkConfig(reflect.ValueOf(c).Elem(), func(key string, secret bool, v reflect.Value) {
+		value := formatConfigValue(v)
+		if (secret || secretLikeKey.MatchString(key)) && value != "" {
+			sum := sha256.Sum256([]byte(value))
+			value = "sha256:" + hex.EncodeToString(sum[:])[:12]
+		}
+		source := c.sources[key]
+		if source == "" {
+			source = "default"
+		}
+		out[key] = EffectiveSetting{Value: value, Source: source}
+	})
+	return out
+}
+
+// walkConfig calls fn for every field with a config tag, descending into
+// untagged struct fields.
+func walkConfig(v reflect.Value, fn func(key string, secret bool, v reflect.Value)) {
+	for i := range v.NumField() {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag, ok := field.Tag.Lookup("config")
+		if !ok {
+			if field.Type.Kind() == reflect.Struct {
+				walkConfig(v.Field(i), fn)
+			}
+			continue
+		}
+		key, opts, _ := strings.Cut(tag, ",")
+		fn(key, opts == "secret", v.Field(i))
+	}
+}
+
+func formatConfigValue(v reflect.Value) string {
+	switch x := v.Interface().(type) {
+	case time.Duration:
+		return x.String()
+	case os.FileMode:
+		return fmt.Sprintf("%04o", uint32(x))
+	case []string:
+		return strings.Join(x, ",")
+	case []RateLimitBucket:
+		specs := make([]string, len(x))
+		for i, b := range x {
+			specs[i] = b.String()
+		}
+		return strings.Join(specs, ",")
+	case []FeatureFlag:
+		specs := make([]string, len(x))
+		for i, f := range x {
+			specs[i] = f.String()
+		}
+		return strings.Join(specs, ",")
+	case []ProxyRoute:
+		specs := make([]string, len(x))
+		for i, p := range x {
+			specs[i] = p.String()
+		}
+		return strings.Join(specs, ",")
+	case []InternalCaller:
+		specs := make([]string, len(x))
+		for i, c := range x {
+			specs[i] = c.String()
+		}
+		return strings.Join(specs, ",")
+	case map[string]string:
+		pairs := make([]string, 0, len(x))
+		for k, v := range x {
+			pairs = append(pairs, k+"="+v)
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ",")
+	case map[string]bool:
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return strings.Join(keys, ",")
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+// reloadable are the fields Reload takes from a fresh Config. Changes to
+// any other field only take effect after a restart.
+var reloadable = map[string]bool{
+	"AllowedOrigins":     true,
+	"MaintenanceMode":    true,
+	"MaintenanceMessage": true,
+	"AccessLogFilter":    true,
+	"SlowThreshold":      true,
+	"Security":           true,
+	"RateLimitBuckets":   true,
+	"RateLimitExempt":    true,
+	"LoginFailureLimit":  true,
+	"LoginFailureWindow": true,
+	"Roles":              true,
+	"FeatureFlags":       true,
+}
+
+// Reload returns a copy of c with next's reloadable fields swapped in, and
+// the names of those that changed, logging a warning for every other field
+// that changed. With no reloadable changes it returns c itself.
+func (c *Config) Reload(next *Config) (*Config, []string) {
+	effective := *c
+	cur, nv, ev := reflect.ValueOf(c).Elem(), reflect.ValueOf(next).Elem(), reflect.ValueOf(&effective).Elem()
+	var changed []string
+	for i := range cur.NumField() {
+		field := cur.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := field.Name
+		if reflect.DeepEqual(cur.Field(i).Interface(), nv.Field(i).Interface()) {
+			continue
+		}
+		if reloadable[name] {
+			ev.Field(i).Set(nv.Field(i))
+			changed = append(changed, name)
+		} else {
+			log.Printf("WARN reload: %s changed, restart required to apply", name)
+		}
+	}
+	if len(changed) == 0 {
+		return c, nil
+	}
+	effective.sources = next.sources
+	return &effective, changed
+}
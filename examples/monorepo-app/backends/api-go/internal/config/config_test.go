@@ -0,0 +1,389 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// notSecret lists the settings whose names look like secrets but hold
+// none. Adding one here is a decision, made in review.
+var notSecret = map[string]string{
+	"RATE_LIMIT_MAX_KEYS":  "a count of rate limiter keys",
+	"ACCESS_TOKEN_TTL":     "a lifetime",
+	"REFRESH_TOKEN_TTL":    "a lifetime",
+	"CSRF_TOKEN_TTL":       "a lifetime",
+	"TOKEN_EXPIRY_HEADERS": "a switch",
+}
+
+// TestSecretSettingsAreTagged fails when a setting named like a credential
+// lacks the ",secret" tag, which keeps it out of Effective and the
+// startup log. It is broader than secretLikeKey, which Validate enforces
+// at startup and cannot have exceptions.
+func TestSecretSettingsAreTagged(t *testing.T) {
+	looksSecret := regexp.MustCompile(`SECRET|PASSWORD|TOKEN|KEY`)
+	seen := make(map[string]bool)
+	walkConfig(reflect.ValueOf(Defaults()).Elem(), func(key string, secret bool, v reflect.Value) {
+		seen[key] = true
+		if secret || !looksSecret.MatchString(key) {
+			return
+		}
+		reason, ok := notSecret[key]
+		switch {
+		case !ok:
+			t.Errorf("%s looks like a secret: tag its field config:\"%s,secret\", or add it to notSecret", key, key)
+		case v.Kind() == reflect.String || v.Kind() == reflect.Map || v.Kind() == reflect.Slice:
+			t.Errorf("%s is in notSecret as %s, but holds a %s", key, reason, v.Type())
+		}
+	})
+	for key := range notSecret {
+		if !seen[key] {
+			t.Errorf("notSecret lists %s, which is not a setting", key)
+		}
+	}
+}
+
+// TestEffectiveHidesSecrets sets every secret to a known value and expects
+// Effective to show only its fingerprint.
+func TestEffectiveHidesSecrets(t *testing.T) {
+	const value = "effective-canary-secret"
+	cfg := Defaults()
+	var secrets []string
+	walkConfig(reflect.ValueOf(cfg).Elem(), func(key string, secret bool, v reflect.Value) {
+		if !secret {
+			return
+		}
+		secrets = append(secrets, key)
+		switch v.Kind() {
+		case reflect.String:
+			v.SetString(value)
+		case reflect.Map:
+			v.Set(reflect.ValueOf(map[string]string{"caller": value}))
+		default:
+			t.Fatalf("%s: secret of unsupported type %s", key, v.Type())
+		}
+	})
+	if len(secrets) == 0 {
+		t.Fatal("no secret settings")
+	}
+	effective := cfg.Effective()
+	for _, key := range secrets {
+		got := effective[key].Value
+		if strings.Contains(got, value) || !strings.HasPrefix(got, "sha256:") {
+			t.Errorf("%s = %q, want a fingerprint", key, got)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	cur := Defaults()
+	if got, changed := cur.Reload(Defaults()); got != cur || len(changed) != 0 {
+		t.Errorf("an identical configuration: %v", changed)
+	}
+
+	next := Defaults()
+	next.AllowedOrigins = []string{"https://new.example.com"}
+	next.MaintenanceMessage = "back soon"
+	next.Port = "9999"
+	next.JWTSecret = "rotated-secret"
+	got, changed := cur.Reload(next)
+	if want := []string{"AllowedOrigins", "MaintenanceMessage"}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("changed %v, want %v", changed, want)
+	}
+	if !reflect.DeepEqual(got.AllowedOrigins, next.AllowedOrigins) || got.MaintenanceMessage != "back soon" {
+		t.Errorf("reloadable settings not applied: %v %q", got.AllowedOrigins, got.MaintenanceMessage)
+	}
+	if got.Port != cur.Port || got.JWTSecret != cur.JWTSecret {
+		t.Errorf("settings that need a restart were applied: port %q, secret %q", got.Port, got.JWTSecret)
+	}
+	if got == cur || cur.MaintenanceMessage == "back soon" {
+		t.Error("Reload changed the running configuration in place")
+	}
+
+	// Every reloadable name is a field, so none is silently ignored.
+	fields := reflect.TypeOf(Config{})
+	for name := range reloadable {
+		if _, ok := fields.FieldByName(name); !ok {
+			t.Errorf("reloadable lists %s, which is not a Config field", name)
+		}
+	}
+}
+
+// loadEnv resolves the configuration from vars alone.
+func loadEnv(vars map[string]string) (*Config, error) {
+	return newConfigSource(envOf(vars)).config()
+}
+
+func TestDurationSettings(t *testing.T) {
+	cfg, err := loadEnv(map[string]string{
+		"ACCESS_TOKEN_TTL":     "5m",
+		"CSRF_TOKEN_TTL":       "1h30m",
+		"SHUTDOWN_TIMEOUT":     "45s",
+		"SERVER_WRITE_TIMEOUT": "1m",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.AccessTokenTTL != 5*time.Minute || cfg.CSRFTokenTTL != 90*time.Minute ||
+		cfg.ShutdownTimeout != 45*time.Second || cfg.WriteTimeout != time.Minute {
+		t.Errorf("got %v %v %v %v", cfg.AccessTokenTTL, cfg.CSRFTokenTTL, cfg.ShutdownTimeout, cfg.WriteTimeout)
+	}
+	if d := Defaults(); d.AccessTokenTTL != 15*time.Minute || d.CSRFTokenTTL != 24*time.Hour || d.RefreshTokenTTL != 7*24*time.Hour {
+		t.Errorf("defaults %v %v %v", d.AccessTokenTTL, d.CSRFTokenTTL, d.RefreshTokenTTL)
+	}
+
+	// Every malformed value is reported, by name, in one error.
+	_, err = loadEnv(map[string]string{
+		"ACCESS_TOKEN_TTL":    "15",
+		"CSRF_TOKEN_TTL":      "a day",
+		"SHUTDOWN_TIMEOUT":    "-30s",
+		"SERVER_IDLE_TIMEOUT": "2 minutes",
+	})
+	if err == nil {
+		t.Fatal("malformed durations accepted")
+	}
+	for _, want := range []string{
+		`ACCESS_TOKEN_TTL="15": time: missing unit`,
+		`CSRF_TOKEN_TTL="a day"`,
+		`SHUTDOWN_TIMEOUT="-30s": must not be negative`,
+		`SERVER_IDLE_TIMEOUT="2 minutes"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("no %q in:\n%v", want, err)
+		}
+	}
+}
+
+func TestDurationBounds(t *testing.T) {
+	tests := []struct {
+		vars map[string]string
+		want string // "" when valid
+	}{
+		{map[string]string{"ACCESS_TOKEN_TTL": "1m"}, ""},
+		{map[string]string{"ACCESS_TOKEN_TTL": "24h", "REFRESH_TOKEN_TTL": "48h"}, ""},
+		{map[string]string{"ACCESS_TOKEN_TTL": "59s"}, "ACCESS_TOKEN_TTL: 59s is outside [1m0s, 24h0m0s]"},
+		{map[string]string{"ACCESS_TOKEN_TTL": "25h", "REFRESH_TOKEN_TTL": "48h"}, "ACCESS_TOKEN_TTL: 25h0m0s is outside"},
+		{map[string]string{"REFRESH_TOKEN_TTL": "91d"}, `REFRESH_TOKEN_TTL="91d"`},
+		{map[string]string{"REFRESH_TOKEN_TTL": "2200h"}, "REFRESH_TOKEN_TTL: 2200h0m0s is outside"},
+		{map[string]string{"ACCESS_TOKEN_TTL": "2h", "REFRESH_TOKEN_TTL": "1h"}, "REFRESH_TOKEN_TTL (1h0m0s) is shorter than ACCESS_TOKEN_TTL (2h0m0s)"},
+		{map[string]string{"REFRESH_TOKEN_TTL": "48h", "REFRESH_MAX_SESSION_AGE": "24h"}, "REFRESH_MAX_SESSION_AGE (24h0m0s) is shorter than REFRESH_TOKEN_TTL (48h0m0s)"},
+		{map[string]string{"REFRESH_SLIDING": "false", "REFRESH_TOKEN_TTL": "48h", "REFRESH_MAX_SESSION_AGE": "24h"}, ""}, // no cap to respect
+		{map[string]string{"REFRESH_MAX_SESSION_AGE": "30m"}, "REFRESH_MAX_SESSION_AGE: 30m0s is outside"},
+		{map[string]string{"CSRF_TOKEN_TTL": "30s"}, "CSRF_TOKEN_TTL: 30s is outside"},
+		{map[string]string{"CSRF_TOKEN_TTL": "169h"}, "CSRF_TOKEN_TTL: 169h0m0s is outside"},
+		{map[string]string{"SHUTDOWN_TIMEOUT": "0s"}, "SHUTDOWN_TIMEOUT: 0s is outside"},
+		{map[string]string{"SHUTDOWN_TIMEOUT": "11m"}, "SHUTDOWN_TIMEOUT: 11m0s is outside"},
+		{map[string]string{"DRAIN_DELAY": "0s"}, ""},
+		{map[string]string{"DRAIN_DELAY": "1m"}, ""},
+		{map[string]string{"DRAIN_DELAY": "61s"}, "DRAIN_DELAY: 1m1s is outside"},
+		{map[string]string{"PRE_SHUTDOWN_DELAY": "2m"}, "DRAIN_DELAY: 2m0s is outside"},
+		{map[string]string{"SERVER_WRITE_TIMEOUT": "0s"}, ""}, // no timeout
+		{map[string]string{"SERVER_WRITE_TIMEOUT": "2h"}, "SERVER_WRITE_TIMEOUT: 2h0m0s is outside"},
+		{map[string]string{"SERVER_READ_TIMEOUT": "2s", "SERVER_READ_HEADER_TIMEOUT": "5s"}, "SERVER_READ_TIMEOUT (2s) is shorter than SERVER_READ_HEADER_TIMEOUT (5s)"},
+	}
+	for _, tt := range tests {
+		cfg, err := loadEnv(tt.vars)
+		if err == nil {
+			err = cfg.Validate()
+		}
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%v: %v", tt.vars, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%v: %v, want %q", tt.vars, err, tt.want)
+		}
+	}
+}
+
+// PRE_SHUTDOWN_DELAY is the old name of DRAIN_DELAY, which wins when both
+// are set.
+func TestDrainDelayAlias(t *testing.T) {
+	for _, tt := range []struct {
+		vars map[string]string
+		want time.Duration
+	}{
+		{nil, 5 * time.Second},
+		{map[string]string{"PRE_SHUTDOWN_DELAY": "12s"}, 12 * time.Second},
+		{map[string]string{"DRAIN_DELAY": "3s"}, 3 * time.Second},
+		{map[string]string{"DRAIN_DELAY": "3s", "PRE_SHUTDOWN_DELAY": "12s"}, 3 * time.Second},
+	} {
+		cfg, err := loadEnv(tt.vars)
+		if err != nil {
+			t.Errorf("%v: %v", tt.vars, err)
+		} else if cfg.DrainDelay != tt.want {
+			t.Errorf("%v: DrainDelay %v, want %v", tt.vars, cfg.DrainDelay, tt.want)
+		}
+	}
+}
+
+func TestJWTSecretPrevious(t *testing.T) {
+	const primary, previous = "a-jwt-secret-of-at-least-32-bytes!", "the-old-jwt-secret-being-rotated-out"
+	for _, tt := range []struct {
+		previous string
+		want     string // "" when valid
+	}{
+		{"", ""},
+		{previous, ""},
+		{primary, "JWT_SECRET_PREVIOUS: same as JWT_SECRET"},
+	} {
+		cfg, err := loadEnv(map[string]string{"JWT_SECRET": primary, "JWT_SECRET_PREVIOUS": tt.previous})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.JWTSecretPrevious != tt.previous {
+			t.Errorf("JWTSecretPrevious %q, want %q", cfg.JWTSecretPrevious, tt.previous)
+		}
+		err = cfg.Validate()
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("previous %q: %v", tt.previous, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("previous %q: %v, want %q", tt.previous, err, tt.want)
+		}
+	}
+}
+
+func TestParseRateLimitBurst(t *testing.T) {
+	for _, tt := range []struct {
+		spec  string
+		burst int
+		want  string // the error, or "" when valid
+	}{
+		{"auth:10/1m:ip", 0, ""},
+		{"auth:10/1m burst 20:ip", 20, ""},
+		{"api:10/1m burst 10", 10, ""},
+		{"auth:10/1m burst 9", 0, `burst "9" must be an integer of at least the limit, 10`},
+		{"auth:10/1m burst lots", 0, `burst "lots"`},
+		{"auth:10/1m burst", 0, `want "burst n"`},
+		{"auth:10/1m bust 20", 0, `option "bust"`},
+	} {
+		b, err := ParseRateLimitBucket(tt.spec)
+		switch {
+		case tt.want == "" && (err != nil || b.Burst != tt.burst):
+			t.Errorf("%s: burst %d, %v; want %d", tt.spec, b.Burst, err, tt.burst)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: %v, want %q", tt.spec, err, tt.want)
+		case err == nil:
+			if again, err := ParseRateLimitBucket(b.String()); err != nil || again != b {
+				t.Errorf("%s: %s parses to %+v, %v", tt.spec, b, again, err)
+			}
+		}
+	}
+}
+
+// Every bucket honors the exemptions but auth, which guards login and
+// registration, unless the spec says otherwise.
+func TestParseRateLimitExempt(t *testing.T) {
+	for spec, want := range map[string]bool{
+		"auth:10/1m":                  false,
+		"auth:10/1m exempt":           true,
+		"auth:10/1m burst 20 exempt":  true,
+		"api:100/1m:user":             true,
+		"api:100/1m noexempt:user":    false,
+		"export:1/1h noexempt exempt": true, // the last one wins
+	} {
+		b, err := ParseRateLimitBucket(spec)
+		if err != nil || b.Exempt != want {
+			t.Errorf("%s: exempt %v, %v; want %v", spec, b.Exempt, err, want)
+			continue
+		}
+		if again, err := ParseRateLimitBucket(b.String()); err != nil || again != b {
+			t.Errorf("%s: %s parses to %+v, %v", spec, b, again, err)
+		}
+	}
+
+	cfg, err := loadEnv(map[string]string{"RATE_LIMIT_EXEMPT_CIDRS": "10.0.0.0/8, not-a-network"})
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err == nil || !strings.Contains(err.Error(), "RATE_LIMIT_EXEMPT_CIDRS") {
+		t.Errorf("a malformed exempt network: %v", err)
+	}
+}
+
+// STATIC_DIR needs ENABLE_STATIC and an index.html to serve.
+func TestStaticDir(t *testing.T) {
+	build, empty := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(build, "index.html"), []byte("<!doctype html>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		vars map[string]string
+		want string // "" when valid
+	}{
+		{map[string]string{"ENABLE_STATIC": "true"}, ""}, // the embedded build
+		{map[string]string{"ENABLE_STATIC": "true", "STATIC_DIR": build}, ""},
+		{map[string]string{"STATIC_DIR": build}, "STATIC_DIR: set without ENABLE_STATIC=true"},
+		{map[string]string{"ENABLE_STATIC": "true", "STATIC_DIR": empty}, "has no index.html"},
+		{map[string]string{"ENABLE_STATIC": "true", "STATIC_DIR": filepath.Join(empty, "nope")}, "has no index.html"},
+	} {
+		cfg, err := loadEnv(tt.vars)
+		if err == nil {
+			err = cfg.Validate()
+		}
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%v: %v", tt.vars, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%v: %v, want %q", tt.vars, err, tt.want)
+		}
+	}
+}
+
+func TestParseInternalCaller(t *testing.T) {
+	for spec, want := range map[string]InternalCaller{
+		"billing-batch=admin": {Name: "billing-batch", Role: "admin"},
+		" sync = user ":       {Name: "sync", Role: "user"},
+		"sync=admin scope /api/v1/admin/users scope /api/v1/orgs": {Name: "sync", Role: "admin", Scopes: []string{"/api/v1/admin/users", "/api/v1/orgs"}},
+	} {
+		got, err := ParseInternalCaller(spec)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: %+v, %v; want %+v", spec, got, err, want)
+		}
+		if again, err := ParseInternalCaller(got.String()); err != nil || !reflect.DeepEqual(again, got) {
+			t.Errorf("%q: String() %q does not parse back", spec, got.String())
+		}
+	}
+	for _, spec := range []string{"billing-batch", "billing-batch=", "sync=admin scope", "sync=admin role user"} {
+		if _, err := ParseInternalCaller(spec); err == nil {
+			t.Errorf("%q parsed", spec)
+		}
+	}
+}
+
+func TestInternalCallers(t *testing.T) {
+	const key = "a-caller-key-of-at-least-32-bytes!"
+	for _, tt := range []struct {
+		callers, secrets string
+		want             string // "" when valid
+	}{
+		{"batch=admin", "batch=" + key, ""},
+		{"batch=admin scope /api/v1/admin/users", "batch=" + key, ""},
+		{"batch=admin", "", `no key for caller "batch"`},
+		{"batch=admin", "batch=" + key + ",other=" + key, `key for "other", which is not in INTERNAL_CALLERS`},
+		{"batch=admin,batch=user", "batch=" + key, `caller "batch" defined twice`},
+		{"bad name!=admin", "bad name!=" + key, "is not a caller name"},
+		{"batch=root", "batch=" + key, `role "root" of batch`},
+		{"batch=admin scope api/v1", "batch=" + key, "must be a path below /"},
+		{"batch=admin scope /api/v1/users/{id}", "batch=" + key, "without wildcards"},
+	} {
+		cfg, err := loadEnv(map[string]string{"INTERNAL_CALLERS": tt.callers, "INTERNAL_CALLER_SECRETS": tt.secrets})
+		if err == nil {
+			err = cfg.Validate()
+		}
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: %v", tt.callers, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: %v, want %q", tt.callers, err, tt.want)
+		}
+	}
+	cfg, _ := loadEnv(map[string]string{"INTERNAL_CALLERS": "batch=admin", "INTERNAL_CALLER_SECRETS": "batch=" + key, "INTERNAL_AUTH_MAX_SKEW": "2h"})
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "INTERNAL_AUTH_MAX_SKEW") {
+		t.Errorf("a 2h skew: %v", err)
+	}
+}
@@ -0,0 +1,346 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// configSource resolves settings from the environment, then the config
+// file, then the fallback. It records malformed values instead of silently
+// using the fallback, and which keys were read so unknown file keys can be
+// reported.
+type configSource struct {
+	env     func(key string) (string, bool) // os.LookupEnv
+	file    map[string]string
+	path    string
+	used    map[string]bool
+	sources map[string]string // key -> "env", "file" or "secret file"
+	errs    []error
+}
+
+func newConfigSource(env func(key string) (string, bool)) *configSource {
+	return &configSource{env: env, used: make(map[string]bool), sources: make(map[string]string)}
+}
+
+// lookup returns the raw value for key. A non-empty environment variable
+// wins over the file; an empty one only counts if the file lacks the key.
+func (c *configSource) lookup(key string) (string, bool) {
+	c.used[key] = true
+	env, inEnv := c.env(key)
+	if inEnv && env != "" {
+		c.sources[key] = "env"
+		return env, true
+	}
+	if v, ok := c.file[key]; ok {
+		c.sources[key] = "file"
+		return v, true
+	}
+	if inEnv {
+		c.sources[key] = "env"
+	}
+	return env, inEnv
+}
+
+func (c *configSource) invalid(key, value string, err error) {
+	c.errs = append(c.errs, fmt.Errorf("%s=%q: %w", key, value, err))
+}
+
+func (c *configSource) String(key, fallback string) string {
+	if v, _ := c.lookup(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// Secret resolves key like String, or reads it from the file named by
+// key+"_FILE" (the Docker/Kubernetes secrets convention), trimming
+// surrounding whitespace. Setting both variants, or pointing _FILE at a
+// missing or empty file, is an error. Secret values never appear in errors.
+func (c *configSource) Secret(key, fallback string) string {
+	path := c.String(key+"_FILE", "")
+	if path == "" {
+		return c.String(key, fallback)
+	}
+	if c.String(key, "") != "" {
+		c.errs = append(c.errs, fmt.Errorf("%s and %s_FILE are both set, use one", key, key))
+		return fallback
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		c.errs = append(c.errs, fmt.Errorf("%s_FILE: %w", key, err))
+		return fallback
+	}
+	v := strings.TrimSpace(string(data))
+	if v == "" {
+		c.errs = append(c.errs, fmt.Errorf("%s_FILE: %s is empty", key, path))
+		return fallback
+	}
+	c.sources[key] = "secret file"
+	return v
+}
+
+// List splits a comma-separated value. Unlike String, an explicitly empty
+// value yields an empty list rather than the fallback.
+func (c *configSource) List(key, fallback string) []string {
+	v, ok := c.lookup(key)
+	if !ok {
+		v = fallback
+	}
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// Map parses a list of "key=value" pairs.
+func (c *configSource) Map(key, fallback string) map[string]string {
+	m := make(map[string]string)
+	for _, item := range c.List(key, fallback) {
+		k, v, ok := strings.Cut(item, "=")
+		if !ok {
+			c.invalid(key, item, errors.New(`want "key=value"`))
+			continue
+		}
+		m[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return m
+}
+
+// Buckets parses a list of rate limit bucket specs; see ParseRateLimitBucket.
+func (c *configSource) Buckets(key, fallback string) []RateLimitBucket {
+	var buckets []RateLimitBucket
+	for _, item := range c.List(key, fallback) {
+		b, err := ParseRateLimitBucket(item)
+		if err != nil {
+			c.invalid(key, item, err)
+			continue
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets
+}
+
+// FeatureFlags parses a list of feature flag specs; see ParseFeatureFlag.
+func (c *configSource) FeatureFlags(key, fallback string) []FeatureFlag {
+	var flags []FeatureFlag
+	for _, item := range c.List(key, fallback) {
+		f, err := ParseFeatureFlag(item)
+		if err != nil {
+			c.invalid(key, item, err)
+			continue
+		}
+		flags = append(flags, f)
+	}
+	return flags
+}
+
+// ProxyRoutes parses a list of proxy rule specs; see ParseProxyRoute.
+func (c *configSource) ProxyRoutes(key, fallback string) []ProxyRoute {
+	var routes []ProxyRoute
+	for _, item := range c.List(key, fallback) {
+		p, err := ParseProxyRoute(item)
+		if err != nil {
+			c.invalid(key, item, err)
+			continue
+		}
+		routes = append(routes, p)
+	}
+	return routes
+}
+
+// InternalCallers parses a list of internal caller specs; see
+// ParseInternalCaller.
+func (c *configSource) InternalCallers(key, fallback string) []InternalCaller {
+	var callers []InternalCaller
+	for _, item := range c.List(key, fallback) {
+		ic, err := ParseInternalCaller(item)
+		if err != nil {
+			c.invalid(key, item, err)
+			continue
+		}
+		callers = append(callers, ic)
+	}
+	return callers
+}
+
+// SecretMap parses a Secret holding "key=value" pairs, as Map does. Like
+// Secret, it keeps the values out of errors.
+func (c *configSource) SecretMap(key string) map[string]string {
+	m := make(map[string]string)
+	for _, item := range strings.Split(c.Secret(key, ""), ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(item, "=")
+		if !ok {
+			c.errs = append(c.errs, fmt.Errorf(`%s: want "key=value" pairs`, key))
+			continue
+		}
+		m[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return m
+}
+
+func (c *configSource) Int(key string, fallback int) int {
+	v, _ := c.lookup(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		c.invalid(key, v, err)
+		return fallback
+	}
+	return n
+}
+
+func (c *configSource) Float(key string, fallback float64) float64 {
+	v, _ := c.lookup(key)
+	if v == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		c.invalid(key, v, err)
+		return fallback
+	}
+	return f
+}
+
+func (c *configSource) Duration(key string, fallback time.Duration) time.Duration {
+	v, _ := c.lookup(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err == nil && d < 0 {
+		err = errors.New("must not be negative")
+	}
+	if err != nil {
+		c.invalid(key, v, err)
+		return fallback
+	}
+	return d
+}
+
+func (c *configSource) FileMode(key string, fallback os.FileMode) os.FileMode {
+	v, _ := c.lookup(key)
+	if v == "" {
+		return fallback
+	}
+	m, err := strconv.ParseUint(v, 8, 32)
+	if err != nil {
+		c.invalid(key, v, err)
+		return fallback
+	}
+	return os.FileMode(m)
+}
+
+func (c *configSource) Bool(key string, fallback bool) bool {
+	v, _ := c.lookup(key)
+	if v == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		c.invalid(key, v, err)
+		return fallback
+	}
+	return b
+}
+
+// unknown lists file keys that no setting read.
+func (c *configSource) unknown() []string {
+	var keys []string
+	for key := range c.file {
+		if !c.used[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func toSet(items []string) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[item] = true
+	}
+	return set
+}
+
+// readConfigFile loads a .json, .yaml or .yml file into a flat map keyed by
+// upper-cased environment variable names.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tree map[string]any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		err = dec.Decode(&tree)
+	case ".yaml", ".yml":
+		tree, err = parseYAML(data)
+	default:
+		err = errors.New("unsupported extension (want .json, .yaml or .yml)")
+	}
+	if err != nil {
+		return nil, err
+	}
+	flat := make(map[string]string)
+	if err := flattenConfig("", tree, flat); err != nil {
+		return nil, err
+	}
+	return flat, nil
+}
+
+func flattenConfig(prefix string, tree map[string]any, out map[string]string) error {
+	for k, v := range tree {
+		key := strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(k))
+		if prefix != "" {
+			key = prefix + "_" + key
+		}
+		switch v := v.(type) {
+		case map[string]any:
+			if err := flattenConfig(key, v, out); err != nil {
+				return err
+			}
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				switch item := item.(type) {
+				case map[string]any, []any:
+					return fmt.Errorf("%s: nested lists and maps are not supported", key)
+				case nil:
+				default:
+					items[i] = fmt.Sprint(item)
+				}
+				// Lists are read back split on commas, like the
+				// environment variables, so an item cannot hold one.
+				if strings.Contains(items[i], ",") {
+					return fmt.Errorf("%s: list item %q contains a comma", key, items[i])
+				}
+			}
+			out[key] = strings.Join(items, ",")
+		case nil:
+			out[key] = ""
+		default:
+			out[key] = fmt.Sprint(v)
+		}
+	}
+	return nil
+}
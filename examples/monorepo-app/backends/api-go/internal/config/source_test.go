@@ -0,0 +1,119 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// envOf is a LookupEnv over a map.
+func envOf(vars map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := vars[key]
+		return v, ok
+	}
+}
+
+// writeSecret writes content to a file in a fresh directory of t.
+func writeSecret(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSecretFromFile(t *testing.T) {
+	const value = "s3cr3t-value"
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    string
+		source  string
+		wantErr string
+	}{
+		{"plain variable", map[string]string{"X_SECRET": value}, value, "env", ""},
+		{"neither", map[string]string{}, "fallback", "", ""},
+		{"file", map[string]string{"X_SECRET_FILE": writeSecret(t, value)}, value, "secret file", ""},
+		{"trailing newline", map[string]string{"X_SECRET_FILE": writeSecret(t, value+"\n")}, value, "secret file", ""},
+		{"CRLF and spaces", map[string]string{"X_SECRET_FILE": writeSecret(t, "  "+value+" \r\n")}, value, "secret file", ""},
+		{"inner whitespace kept", map[string]string{"X_SECRET_FILE": writeSecret(t, "a b\n")}, "a b", "secret file", ""},
+		{"empty _FILE ignored", map[string]string{"X_SECRET_FILE": "", "X_SECRET": value}, value, "env", ""},
+		{"both set", map[string]string{"X_SECRET_FILE": writeSecret(t, value), "X_SECRET": "other"}, "fallback", "", "X_SECRET and X_SECRET_FILE are both set"},
+		{"missing file", map[string]string{"X_SECRET_FILE": filepath.Join(t.TempDir(), "nope")}, "fallback", "", "X_SECRET_FILE: open"},
+		{"empty file", map[string]string{"X_SECRET_FILE": writeSecret(t, "")}, "fallback", "", "is empty"},
+		{"blank file", map[string]string{"X_SECRET_FILE": writeSecret(t, " \n\n")}, "fallback", "", "is empty"},
+		{"directory", map[string]string{"X_SECRET_FILE": t.TempDir()}, "fallback", "", "X_SECRET_FILE: read"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := newConfigSource(envOf(tt.env))
+			if got := src.Secret("X_SECRET", "fallback"); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if got := src.sources["X_SECRET"]; tt.source != "" && got != tt.source {
+				t.Errorf("source %q, want %q", got, tt.source)
+			}
+			switch {
+			case tt.wantErr == "" && len(src.errs) > 0:
+				t.Errorf("errors: %v", src.errs)
+			case tt.wantErr != "" && (len(src.errs) != 1 || !strings.Contains(src.errs[0].Error(), tt.wantErr)):
+				t.Errorf("errors %v, want one with %q", src.errs, tt.wantErr)
+			}
+			for _, err := range src.errs {
+				if strings.Contains(err.Error(), value) || strings.Contains(err.Error(), "other") {
+					t.Errorf("the secret leaked into %q", err)
+				}
+			}
+		})
+	}
+}
+
+func TestSecretFileUnreadable(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root reads any file")
+	}
+	path := writeSecret(t, "s3cr3t-value")
+	if err := os.Chmod(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	src := newConfigSource(envOf(map[string]string{"X_SECRET_FILE": path}))
+	src.Secret("X_SECRET", "")
+	if len(src.errs) != 1 || !strings.Contains(src.errs[0].Error(), "permission denied") {
+		t.Errorf("errors %v, want permission denied", src.errs)
+	}
+}
+
+// Every string setting tagged secret can come from a _FILE, and Load
+// reports a bad one by name.
+func TestSecretSettingsReadFiles(t *testing.T) {
+	env := map[string]string{}
+	want := map[string]string{}
+	walkConfig(reflect.ValueOf(Defaults()).Elem(), func(key string, secret bool, v reflect.Value) {
+		if secret && v.Kind() == reflect.String {
+			want[key] = "from-file-" + strings.ToLower(key)
+			env[key+"_FILE"] = writeSecret(t, want[key]+"\n")
+		}
+	})
+	if len(want) < 5 {
+		t.Fatalf("only %d string secrets: %v", len(want), want)
+	}
+	cfg, err := newConfigSource(envOf(env)).config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	walkConfig(reflect.ValueOf(cfg).Elem(), func(key string, secret bool, v reflect.Value) {
+		if w, ok := want[key]; ok && v.String() != w {
+			t.Errorf("%s = %q, want %q from %s_FILE", key, v.String(), w, key)
+		}
+	})
+
+	env["SMTP_PASSWORD"] = "also-set"
+	_, err = newConfigSource(envOf(env)).config()
+	if err == nil || !strings.Contains(err.Error(), "SMTP_PASSWORD and SMTP_PASSWORD_FILE are both set") {
+		t.Errorf("both variants set: %v", err)
+	}
+}
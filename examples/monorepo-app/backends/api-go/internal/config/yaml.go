@@ -0,0 +1,238 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseYAML understands the subset of YAML config files need: nested maps
+// by indentation (spaces only), plain and quoted scalars, "- item" block
+// lists, [a, b] flow lists and # comments. Anything else (flow maps,
+// nested flow lists, anchors, aliases, tags, multi-line strings, multiple
+// documents) is an error rather than a value YAML would read differently.
+func parseYAML(data []byte) (map[string]any, error) {
+	p := &yamlParser{}
+	for i, raw := range strings.Split(string(data), "\n") {
+		text := strings.TrimRight(raw, " \t\r")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || trimmed[0] == '#' || trimmed == "---" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", i+1)
+		}
+		p.lines = append(p.lines, yamlLine{indent: len(text) - len(trimmed), text: trimmed, num: i + 1})
+	}
+	if len(p.lines) == 0 {
+		return map[string]any{}, nil
+	}
+	tree, err := p.parseMap(p.lines[0].indent)
+	if err == nil && p.pos < len(p.lines) {
+		err = fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].num)
+	}
+	return tree, err
+}
+
+type yamlLine struct {
+	indent int
+	text   string
+	num    int
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+func (p *yamlParser) parseMap(indent int) (map[string]any, error) {
+	m := make(map[string]any)
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", line.num)
+		}
+		key, rest, ok := strings.Cut(line.text, ":")
+		if !ok || strings.HasPrefix(line.text, "-") {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", line.num)
+		}
+		key, err := yamlKey(strings.TrimSpace(key))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line.num, err)
+		}
+		rest = strings.TrimSpace(rest)
+		p.pos++
+		if !yamlEnd(rest) {
+			if m[key], err = yamlValue(rest); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line.num, err)
+			}
+			continue
+		}
+		if p.pos == len(p.lines) {
+			m[key] = nil
+			continue
+		}
+		next := p.lines[p.pos]
+		isList := strings.HasPrefix(next.text, "- ") || next.text == "-"
+		switch {
+		case isList && next.indent >= indent:
+			m[key], err = p.parseList(next.indent)
+		case next.indent > indent:
+			m[key], err = p.parseMap(next.indent)
+		default:
+			m[key] = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (p *yamlParser) parseList(indent int) ([]any, error) {
+	var list []any
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent != indent || !(strings.HasPrefix(line.text, "- ") || line.text == "-") {
+			break
+		}
+		item := strings.TrimSpace(strings.TrimPrefix(line.text, "-"))
+		if strings.HasPrefix(item, "[") || strings.HasPrefix(item, "- ") {
+			return nil, fmt.Errorf("line %d: nested lists are not supported", line.num)
+		}
+		v, err := yamlValue(item)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line.num, err)
+		}
+		list = append(list, v)
+		p.pos++
+	}
+	return list, nil
+}
+
+// yamlValue parses an inline value, a [flow, list] or a scalar, followed
+// by nothing but an optional comment.
+func yamlValue(s string) (any, error) {
+	if strings.HasPrefix(s, "[") {
+		return yamlFlowList(s)
+	}
+	v, quoted, rest, err := yamlScalar(s, false)
+	if err != nil {
+		return nil, err
+	}
+	if !yamlEnd(rest) {
+		return nil, fmt.Errorf("unexpected %q after the value", rest)
+	}
+	if !quoted {
+		if strings.Contains(v, ": ") || strings.HasSuffix(v, ":") {
+			return nil, fmt.Errorf("%q: inline maps are not supported (quote the value if it is a string)", v)
+		}
+		if v == "" || v == "~" || v == "null" {
+			return nil, nil
+		}
+	}
+	return v, nil
+}
+
+// yamlFlowList parses a [flow, list] of scalars.
+func yamlFlowList(s string) ([]any, error) {
+	list := []any{}
+	rest := strings.TrimLeft(s[1:], " \t")
+	for {
+		if strings.HasPrefix(rest, "]") {
+			break
+		}
+		if rest == "" || rest[0] == '#' {
+			return nil, fmt.Errorf("%s: missing \"]\" (flow lists must fit on one line)", s)
+		}
+		if strings.HasPrefix(rest, "[") {
+			return nil, fmt.Errorf("%s: nested lists are not supported", s)
+		}
+		item, quoted, after, err := yamlScalar(rest, true)
+		if err != nil {
+			return nil, err
+		}
+		if item == "" && !quoted {
+			return nil, fmt.Errorf("%s: empty list item", s)
+		}
+		list = append(list, item)
+		rest = strings.TrimLeft(after, " \t")
+		if strings.HasPrefix(rest, ",") {
+			rest = strings.TrimLeft(rest[1:], " \t")
+		} else if !strings.HasPrefix(rest, "]") {
+			return nil, fmt.Errorf("%s: expected \",\" or \"]\" before %q", s, rest)
+		}
+	}
+	if rest = strings.TrimSpace(rest[1:]); !yamlEnd(rest) {
+		return nil, fmt.Errorf("unexpected %q after the list", rest)
+	}
+	return list, nil
+}
+
+// yamlScalar reads the scalar s starts with and returns it with what
+// follows, trimmed. Quoted scalars are unquoted; plain ones run up to a
+// " #" comment, or in a flow list up to "," or "]", and are kept verbatim
+// since every setting is parsed from its string form anyway.
+func yamlScalar(s string, flow bool) (v string, quoted bool, rest string, err error) {
+	if s == "" {
+		return "", false, "", nil
+	}
+	switch s[0] {
+	case '"':
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '"':
+				u, err := strconv.Unquote(s[:i+1])
+				if err != nil {
+					return "", false, "", fmt.Errorf("%s: unsupported escape in a double-quoted string", s[:i+1])
+				}
+				return u, true, strings.TrimSpace(s[i+1:]), nil
+			}
+		}
+		return "", false, "", fmt.Errorf("%s: missing closing quote (strings must fit on one line)", s)
+	case '\'':
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			if s[i] != '\'' {
+				b.WriteByte(s[i])
+				continue
+			}
+			if i+1 < len(s) && s[i+1] == '\'' {
+				b.WriteByte('\'')
+				i++
+				continue
+			}
+			return b.String(), true, strings.TrimSpace(s[i+1:]), nil
+		}
+		return "", false, "", fmt.Errorf("%s: missing closing quote (strings must fit on one line)", s)
+	case '{', '&', '*', '!', '|', '>', '%', '@', '`':
+		return "", false, "", fmt.Errorf("%s: flow maps, anchors, aliases, tags and multi-line strings are not supported", s)
+	}
+	end := len(s)
+	for i := 0; i < len(s); i++ {
+		if s[i] == '#' && i > 0 && (s[i-1] == ' ' || s[i-1] == '\t') || flow && (s[i] == ',' || s[i] == ']') {
+			end = i
+			break
+		}
+	}
+	return strings.TrimSpace(s[:end]), false, s[end:], nil
+}
+
+// yamlKey unquotes a map key.
+func yamlKey(s string) (string, error) {
+	key, _, rest, err := yamlScalar(s, false)
+	if err == nil && (rest != "" || key == "") {
+		err = fmt.Errorf("%q: invalid key", s)
+	}
+	return key, err
+}
+
+// yamlEnd reports whether s, trimmed, holds nothing but a comment.
+func yamlEnd(s string) bool {
+	return s == "" || s[0] == '#'
+}
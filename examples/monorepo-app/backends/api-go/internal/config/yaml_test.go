@@ -0,0 +1,190 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// flatYAML parses doc as a config file.
+func flatYAML(doc string) (map[string]string, error) {
+	tree, err := parseYAML([]byte(doc))
+	if err != nil {
+		return nil, err
+	}
+	flat := make(map[string]string)
+	if err := flattenConfig("", tree, flat); err != nil {
+		return nil, err
+	}
+	return flat, nil
+}
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want map[string]string
+	}{
+		{"plain", "name: raijin", map[string]string{"NAME": "raijin"}},
+		{"apostrophe before a comment", "name: it's # c", map[string]string{"NAME": "it's"}},
+		{"hash inside a word", "url: https://example.com/a#top", map[string]string{"URL": "https://example.com/a#top"}},
+		{"double quoted", `msg: "a # b: \"c\"\t" # c`, map[string]string{"MSG": "a # b: \"c\"\t"}},
+		{"single quoted", `msg: 'it''s # here' # c`, map[string]string{"MSG": "it's # here"}},
+		{"null", "a: ~\nb: null\nc:", map[string]string{"A": "", "B": "", "C": ""}},
+		{"empty quoted", `a: ""`, map[string]string{"A": ""}},
+		{"flow list", `origins: ["https://a.example", 'https://b.example', c] # c`, map[string]string{"ORIGINS": "https://a.example,https://b.example,c"}},
+		{"flow list with a bracket in a quote", `a: ["x]", y]`, map[string]string{"A": "x],y"}},
+		{"empty flow list", "a: []", map[string]string{"A": ""}},
+		{"block list", "a:\n  - x # c\n  - 'y # z'\n# between\n  - z", map[string]string{"A": "x,y # z,z"}},
+		{"nested maps", "server: # the API\n  port: 8080\n  limits:\n    burst: 5\nother: x", map[string]string{"SERVER_PORT": "8080", "SERVER_LIMITS_BURST": "5", "OTHER": "x"}},
+		{"document marker and comments", "---\n# top\nport: 1 # c\n", map[string]string{"PORT": "1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := flatYAML(tt.doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseYAMLRejectsUnsupported(t *testing.T) {
+	for _, doc := range []string{
+		`origins: ["a,b", c]`,
+		"origins:\n  - 'a,b'",
+		"a: [x, [y]]",
+		"a: [x, y",
+		"a: [x,, y]",
+		"a: [x] y",
+		"a: {b: c}",
+		"a: &anchor x",
+		"a: *anchor",
+		"a: !!str x",
+		"a: |\n  text",
+		"a: >\n  text",
+		`a: "unterminated`,
+		`a: 'unterminated`,
+		`a: "x" y`,
+		`a: "\q"`,
+		"a: b: c",
+		"a:\n  - b: c",
+		"a:\n  - [b]",
+		"a:\n\t- b",
+		"- a",
+		"a: x\n   b: y",
+	} {
+		if flat, err := flatYAML(doc); err == nil {
+			t.Errorf("%q: got %q, want an error", doc, flat)
+		}
+	}
+}
+
+// TestParseYAMLRoundTrip writes awkward strings the ways YAML can quote
+// them and expects to read each one back unchanged.
+func TestParseYAMLRoundTrip(t *testing.T) {
+	values := []string{
+		"plain", "it's", `say "hi"`, "a # b", "#start", "key: value", "x]", "[x]", "{x}",
+		"back\\slash", "tab\there", "naïve ☕", " padded ", "~", "null", "",
+	}
+	single := func(s string) string { return "'" + strings.ReplaceAll(s, "'", "''") + "'" }
+	for _, v := range values {
+		for name, quoted := range map[string]string{"double": strconv.Quote(v), "single": single(v)} {
+			doc := fmt.Sprintf("a: %s # comment\nlist: [%s, %s]\nblock:\n  - %s # comment\n", quoted, quoted, quoted, quoted)
+			tree, err := parseYAML([]byte(doc))
+			if err != nil {
+				t.Errorf("%s %q: %v", name, v, err)
+				continue
+			}
+			want := map[string]any{"a": v, "list": []any{v, v}, "block": []any{v}}
+			if !reflect.DeepEqual(tree, want) {
+				t.Errorf("%s %q: got %#v", name, v, tree)
+			}
+		}
+	}
+}
+
+// TestParseYAMLMatchesJSON reads the same settings from a YAML and a JSON
+// file.
+func TestParseYAMLMatchesJSON(t *testing.T) {
+	const doc = `
+server:
+  port: "8080"
+  environment: staging # c
+cors:
+  origins: [https://a.example, "https://b.example"]
+rate_limit:
+  buckets:
+    - api=100/m
+    - 'auth=5/m'
+maintenance_message: "back at 10:00 # really"
+`
+	tree, err := parseYAML([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, _ := json.Marshal(tree)
+	var fromJSON map[string]any
+	dec := json.NewDecoder(bytes.NewReader(encoded))
+	dec.UseNumber()
+	if err := dec.Decode(&fromJSON); err != nil {
+		t.Fatal(err)
+	}
+	yamlFlat, jsonFlat := make(map[string]string), make(map[string]string)
+	if err := flattenConfig("", tree, yamlFlat); err != nil {
+		t.Fatal(err)
+	}
+	if err := flattenConfig("", fromJSON, jsonFlat); err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(yamlFlat, jsonFlat) {
+		t.Errorf("YAML %q, JSON %q", yamlFlat, jsonFlat)
+	}
+	if got := yamlFlat["MAINTENANCE_MESSAGE"]; got != "back at 10:00 # really" {
+		t.Errorf("MAINTENANCE_MESSAGE = %q", got)
+	}
+}
+
+// TestExampleConfigIsDefaults loads config.example.yaml, which documents
+// every setting at its default, and expects the defaults.
+func TestExampleConfigIsDefaults(t *testing.T) {
+	path := filepath.Join("..", "..", "config.example.yaml")
+	if _, err := os.Stat(path); err != nil {
+		t.Skip(err)
+	}
+	file, err := readConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := newConfigSource(func(string) (string, bool) { return "", false })
+	src.file, src.path = file, path
+	cfg, err := src.config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unknown := src.unknown(); len(unknown) > 0 {
+		t.Errorf("unknown keys: %v", unknown)
+	}
+	want := Defaults()
+	cfg.sources, want.sources = nil, nil
+	if reflect.DeepEqual(cfg, want) {
+		return
+	}
+	got, wantV := reflect.ValueOf(*cfg), reflect.ValueOf(*want)
+	for i := range got.NumField() {
+		field := got.Type().Field(i)
+		if field.IsExported() && !reflect.DeepEqual(got.Field(i).Interface(), wantV.Field(i).Interface()) {
+			t.Errorf("%s = %v, default %v", field.Name, got.Field(i), wantV.Field(i))
+		}
+	}
+}
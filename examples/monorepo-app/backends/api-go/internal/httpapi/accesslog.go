@@ -0,0 +1,327 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+)
+
+// AccessLogEntry is everything an access log format may render.
+type AccessLogEntry struct {
+	Time      time.Time     `json:"time"`
+	Method    string        `json:"method"`
+	Path      string        `json:"path"`
+	Proto     string        `json:"proto"`
+	Status    int           `json:"status"`
+	Bytes     int64         `json:"bytes"`
+	Duration  time.Duration `json:"duration_ns"`
+	IP        string        `json:"ip"`
+	UserAgent string        `json:"user_agent,omitempty"`
+	Referer   string        `json:"referer,omitempty"`
+	RequestID string        `json:"request_id,omitempty"`
+	TraceID   string        `json:"trace_id,omitempty"`
+	SpanID    string        `json:"span_id,omitempty"`
+	UserID    string        `json:"user_id"`          // empty for anonymous requests
+	Caller    string        `json:"caller,omitempty"` // the internal caller that signed the request
+	Error     string        `json:"error,omitempty"`  // the response could not be encoded or sent
+}
+
+// AccessLogFormat renders one entry as a single line (without newline).
+type AccessLogFormat func(e *AccessLogEntry) string
+
+var accessLogFormats = map[string]AccessLogFormat{
+	"dev":      formatDev,
+	"json":     formatJSON,
+	"combined": formatCombined,
+}
+
+func formatDev(e *AccessLogEntry) string {
+	line := fmt.Sprintf("%s %d %s %s %v %s", e.Time.Format("2006/01/02 15:04:05"),
+		e.Status, e.Method, e.Path, e.Duration, e.IP)
+	if e.UserID != "" {
+		line += " user=" + e.UserID
+	}
+	return line
+}
+
+func formatJSON(e *AccessLogEntry) string {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Sprintf(`{"error":%q}`, err.Error())
+	}
+	return string(b)
+}
+
+// formatCombined renders the Apache/NCSA combined log format.
+func formatCombined(e *AccessLogEntry) string {
+	user, bytes := "-", "-"
+	if e.UserID != "" {
+		user = e.UserID
+	}
+	if e.Bytes > 0 {
+		bytes = strconv.FormatInt(e.Bytes, 10)
+	}
+	referer, ua := e.Referer, e.UserAgent
+	if referer == "" {
+		referer = "-"
+	}
+	if ua == "" {
+		ua = "-"
+	}
+	return fmt.Sprintf(`%s - %s [%s] "%s %s %s" %d %s %q %q`, e.IP, user,
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"), e.Method, e.Path, e.Proto,
+		e.Status, bytes, referer, ua)
+}
+
+// requestInfo is a mutable per-request slot that inner middleware (Auth)
+// fills in so the outer access logger can see it.
+type requestInfo struct {
+	pattern       string // the route the mux matched
+	userID        string
+	caller        string // internal caller name, for signed requests
+	slowThreshold time.Duration
+}
+
+// setRequestUser records the authenticated user for the access log.
+func setRequestUser(r *http.Request, userID string) {
+	if info, ok := r.Context().Value(ctxRequestInfo).(*requestInfo); ok {
+		info.userID = userID
+	}
+}
+
+// setRequestPattern records the matched route for the access log and
+// metrics. r.Pattern can't carry it out: the logger's r is an ancestor of
+// the one the mux sets it on, since middleware in between (ErrorFormat,
+// Auth) pass copies down. The first match wins, so a batch is logged as
+// the batch route and not as its last sub-request.
+func setRequestPattern(r *http.Request, pattern string) {
+	if info, ok := r.Context().Value(ctxRequestInfo).(*requestInfo); ok && info.pattern == "" {
+		info.pattern = pattern
+	}
+}
+
+// setRequestCaller records the internal caller that signed the request,
+// besides the user it acts as.
+func setRequestCaller(r *http.Request, name string) {
+	if info, ok := r.Context().Value(ctxRequestInfo).(*requestInfo); ok {
+		info.caller = name
+	}
+}
+
+// authLabel is "authenticated" or "anonymous", the auth label of
+// requestsByAuth.
+func (info *requestInfo) authLabel() string {
+	if info.userID != "" {
+		return "authenticated"
+	}
+	return "anonymous"
+}
+
+var (
+	// requestsTotal counts requests by "<route pattern> <status>".
+	requestsTotal = expvar.NewMap("http_requests_total")
+	// slowRequestsTotal counts requests over their slow threshold by route pattern.
+	slowRequestsTotal = expvar.NewMap("http_slow_requests_total")
+	// requestsByAuth counts requests by "<route pattern> <authenticated|anonymous>".
+	requestsByAuth = expvar.NewMap("http_requests_by_auth")
+)
+
+// SlowThreshold overrides the logger's slow-request threshold for a route
+// group that is legitimately slow (exports, reports).
+func SlowThreshold(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if info, ok := r.Context().Value(ctxRequestInfo).(*requestInfo); ok {
+				info.slowThreshold = d
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequestLogger writes one access log line per request.
+type RequestLogger struct {
+	mu     sync.Mutex
+	out    io.Writer
+	format AccessLogFormat
+	filter atomic.Pointer[config.LogFilter]
+	slow   atomic.Int64 // time.Duration
+}
+
+// NewRequestLogger logs in format to out. Requests slower than slow (0
+// disables) also get a WARN line on the standard logger.
+func NewRequestLogger(format string, out io.Writer, filter config.LogFilter, slow time.Duration) (*RequestLogger, error) {
+	f, ok := accessLogFormats[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown access log format %q", format)
+	}
+	l := &RequestLogger{out: out, format: f}
+	l.SetFilter(filter, slow)
+	return l, nil
+}
+
+// SetFilter replaces the filter and slow threshold for subsequent requests.
+func (l *RequestLogger) SetFilter(filter config.LogFilter, slow time.Duration) {
+	l.filter.Store(&filter)
+	l.slow.Store(int64(slow))
+}
+
+func (l *RequestLogger) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &requestInfo{}
+		r = r.WithContext(context.WithValue(r.Context(), ctxRequestInfo, info))
+		rec := &statusRecorder{ResponseWriter: w, code: 200}
+		next.ServeHTTP(rec, r)
+		duration := time.Since(start)
+		pattern := info.pattern
+		if pattern == "" {
+			pattern = r.Pattern
+		}
+		if pattern == "" {
+			pattern = "unmatched"
+		}
+
+		tc, _ := TraceFrom(r.Context())
+		threshold := time.Duration(l.slow.Load())
+		if info.slowThreshold > 0 {
+			threshold = info.slowThreshold
+		}
+		if threshold > 0 && duration > threshold {
+			slowRequestsTotal.Add(pattern, 1)
+			log.Printf("WARN slow request: route=%q path=%s status=%d duration=%v threshold=%v user=%s ip=%s request_id=%s trace_id=%s",
+				pattern, r.URL.Path, rec.code, duration, threshold, info.userID, clientIP(r), r.Header.Get("X-Request-ID"), tc.TraceID)
+		}
+
+		if rec.writeErr != nil {
+			log.Printf("ERROR writing response: route=%q path=%s status=%d user=%s request_id=%s: %v",
+				pattern, r.URL.Path, rec.code, info.userID, r.Header.Get("X-Request-ID"), rec.writeErr)
+		}
+
+		filter := l.filter.Load()
+		skip := filter.Excludes(r.URL.Path, rec.code)
+		if !skip || !filter.SkipMetrics {
+			requestsTotal.Add(pattern+" "+strconv.Itoa(rec.code), 1)
+			requestsByAuth.Add(pattern+" "+info.authLabel(), 1)
+		}
+		if skip {
+			return
+		}
+		e := &AccessLogEntry{
+			Time: start, Method: r.Method, Path: r.URL.RequestURI(), Proto: r.Proto,
+			Status: rec.code, Bytes: rec.bytes, Duration: duration,
+			IP: clientIP(r), UserAgent: r.UserAgent(), Referer: r.Referer(),
+			RequestID: r.Header.Get("X-Request-ID"), TraceID: tc.TraceID, SpanID: tc.SpanID,
+			UserID: info.userID, Caller: info.caller,
+		}
+		if rec.writeErr != nil {
+			e.Error = rec.writeErr.Error()
+		}
+		l.write(e)
+	})
+}
+
+func (l *RequestLogger) write(e *AccessLogEntry) {
+	line := l.format(e) + "\n"
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := io.WriteString(l.out, line); err != nil {
+		log.Printf("access log: %v", err)
+	}
+}
+
+// ReopenFile is an append-only file that can be reopened in place, so
+// logrotate can move it and signal us (SIGUSR2) to start a fresh one.
+type ReopenFile struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+}
+
+func OpenReopenFile(path string) (*ReopenFile, error) {
+	rf := &ReopenFile{path: path}
+	return rf, rf.Reopen()
+}
+
+func (rf *ReopenFile) Reopen() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	rf.mu.Lock()
+	old := rf.f
+	rf.f = f
+	rf.mu.Unlock()
+	if old != nil {
+		return old.Close()
+	}
+	return nil
+}
+
+func (rf *ReopenFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.f.Write(p)
+}
+
+func (rf *ReopenFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.f.Close()
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	code     int
+	bytes    int64
+	writeErr error // first failure of writeEncoded; see noteWriteError
+}
+
+func (sr *statusRecorder) WriteHeader(code int) { sr.code = code; sr.ResponseWriter.WriteHeader(code) }
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	n, err := sr.ResponseWriter.Write(b)
+	sr.bytes += int64(n)
+	return n, err
+}
+
+// noteWriteError records err for the access log of the request w answers,
+// when the statusRecorder is reachable through w's Unwrap chain.
+func noteWriteError(w http.ResponseWriter, err error) {
+	for {
+		if sr, ok := w.(*statusRecorder); ok {
+			if sr.writeErr == nil {
+				sr.writeErr = err
+			}
+			return
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return
+		}
+		w = u.Unwrap()
+	}
+}
+
+// Flush passes through to the underlying writer so streaming works over both
+// HTTP/1.1 and HTTP/2. Hijack is deliberately not exposed: HTTP/2 streams
+// can't be hijacked, use http.NewResponseController instead.
+func (sr *statusRecorder) Flush() {
+	if f, ok := sr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (sr *statusRecorder) Unwrap() http.ResponseWriter { return sr.ResponseWriter }
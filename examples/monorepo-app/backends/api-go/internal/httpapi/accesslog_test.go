@@ -0,0 +1,270 @@
+package httpapi_test
+
+import (
+	"encoding/json"
+	"expvar"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+	"github.com/your-org/your-app/backends/api-go/internal/httpapi"
+	"github.com/your-org/your-app/backends/api-go/raijintest"
+)
+
+// requestCount reads a counter of the http_requests_* expvar maps.
+func requestCount(t *testing.T, name, key string) int64 {
+	t.Helper()
+	m, ok := expvar.Get(name).(*expvar.Map)
+	if !ok {
+		t.Fatalf("expvar %s is not published", name)
+	}
+	if v, ok := m.Get(key).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestAccessLogLabelsRequestsWithTheirRoute(t *testing.T) {
+	srv := raijintest.NewServer(t)
+	client := srv.LoginAs(t, "user")
+
+	route := requestCount(t, "http_requests_total", "GET /api/v1/users/me 200")
+	auth := requestCount(t, "http_requests_by_auth", "GET /api/v1/users/me authenticated")
+	resp, err := client.Get(srv.URL + "/api/v1/users/me")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /api/v1/users/me: status %d", resp.StatusCode)
+	}
+	if got := requestCount(t, "http_requests_total", "GET /api/v1/users/me 200"); got != route+1 {
+		t.Errorf("http_requests_total[GET /api/v1/users/me 200] = %d, want %d", got, route+1)
+	}
+	if got := requestCount(t, "http_requests_by_auth", "GET /api/v1/users/me authenticated"); got != auth+1 {
+		t.Errorf("http_requests_by_auth[GET /api/v1/users/me authenticated] = %d, want %d", got, auth+1)
+	}
+}
+
+func TestAccessLogLabelsBatchAsTheBatchRoute(t *testing.T) {
+	srv := raijintest.NewServer(t)
+	client := srv.LoginAs(t, "user")
+
+	batch := requestCount(t, "http_requests_total", "POST /api/v1/batch 200")
+	me := requestCount(t, "http_requests_total", "GET /api/v1/users/me 200")
+	resp, err := client.Post(srv.URL+"/api/v1/batch", "application/json",
+		strings.NewReader(`{"requests":[{"method":"GET","path":"/api/v1/users/me"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST /api/v1/batch: status %d", resp.StatusCode)
+	}
+	if got := requestCount(t, "http_requests_total", "POST /api/v1/batch 200"); got != batch+1 {
+		t.Errorf("http_requests_total[POST /api/v1/batch 200] = %d, want %d", got, batch+1)
+	}
+	if got := requestCount(t, "http_requests_total", "GET /api/v1/users/me 200"); got != me {
+		t.Errorf("the sub-request was counted as its own request: %d, want %d", got, me)
+	}
+}
+
+func TestAccessLogCountsUnknownPathsAsUnmatched(t *testing.T) {
+	srv := raijintest.NewServer(t)
+
+	before := requestCount(t, "http_requests_total", "unmatched 404")
+	resp, err := http.Get(srv.URL + "/api/v1/no-such-route")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := requestCount(t, "http_requests_total", "unmatched 404"); got != before+1 {
+		t.Errorf("http_requests_total[unmatched 404] = %d, want %d", got, before+1)
+	}
+}
+
+func TestSlowRequestLog(t *testing.T) {
+	logs := captureLog(t)
+	logger, err := httpapi.NewRequestLogger("json", io.Discard, config.LogFilter{}, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sleep := func(d time.Duration) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { time.Sleep(d) })
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /slow", sleep(50*time.Millisecond))
+	mux.Handle("GET /fast", sleep(0))
+	mux.Handle("GET /export", httpapi.SlowThreshold(time.Hour)(sleep(50*time.Millisecond)))
+	mux.Handle("GET /strict", httpapi.SlowThreshold(time.Millisecond)(sleep(5*time.Millisecond)))
+	h := logger.Wrap(mux)
+
+	for _, tt := range []struct {
+		path string
+		slow bool
+	}{
+		{"/slow", true},
+		{"/fast", false},
+		{"/export", false}, // the group's threshold is higher
+		{"/strict", true},  // and here lower
+	} {
+		route := "GET " + tt.path
+		before := requestCount(t, "http_slow_requests_total", route)
+		logs.Reset()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tt.path, nil))
+		var want int64
+		if tt.slow {
+			want = 1
+		}
+		if got := requestCount(t, "http_slow_requests_total", route) - before; got != want {
+			t.Errorf("%s: http_slow_requests_total grew by %d, want %d", route, got, want)
+		}
+		if logged := strings.Contains(logs.String(), "WARN slow request: route=\""+route+"\""); logged != tt.slow {
+			t.Errorf("%s: logged %v, want %v:\n%s", route, logged, tt.slow, logs)
+		}
+	}
+
+	// A zero threshold turns the log off.
+	logger.SetFilter(config.LogFilter{}, 0)
+	logs.Reset()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+	if strings.Contains(logs.String(), "slow request") {
+		t.Errorf("logged with the threshold off:\n%s", logs)
+	}
+}
+
+// The WARN line names the user and route the server saw.
+func TestSlowRequestLogDetails(t *testing.T) {
+	logs := captureLog(t)
+	srv := raijintest.NewServer(t, raijintest.WithConfig(func(c *config.Config) { c.SlowThreshold = time.Nanosecond }))
+	user := srv.CreateUser(t, "slow@example.com", raijintest.Password, "user")
+	resp, err := srv.ClientAs(t, user).Get(srv.URL + "/api/v1/users/me")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	var line string
+	for l := range strings.Lines(logs.String()) {
+		if strings.Contains(l, `route="GET /api/v1/users/me"`) {
+			line = l
+		}
+	}
+	for _, want := range []string{"WARN slow request:", "path=/api/v1/users/me", "status=200", "user=" + user.ID, "threshold=1ns", "duration=", "request_id="} {
+		if !strings.Contains(line, want) {
+			t.Errorf("no %q in %q", want, line)
+		}
+	}
+}
+
+// accessLogLines waits for n lines in the JSON access log at path and
+// returns them decoded.
+func accessLogLines(t *testing.T, path string, n int) []httpapi.AccessLogEntry {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		data, _ := os.ReadFile(path)
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(lines) >= n || time.Now().After(deadline) {
+			entries := make([]httpapi.AccessLogEntry, len(lines))
+			for i, line := range lines {
+				if err := json.Unmarshal([]byte(line), &entries[i]); err != nil {
+					t.Fatalf("%v: %s", err, line)
+				}
+				if !strings.Contains(line, `"user_id":`) {
+					t.Errorf("no user_id in %s", line)
+				}
+			}
+			return entries
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// The access log, outside Auth, still learns who made each request:
+// user_id is the user, the internal caller's identity, or "" when
+// anonymous or refused.
+func TestAccessLogUser(t *testing.T) {
+	accessLog := filepath.Join(t.TempDir(), "access.log")
+	srv := raijintest.NewServer(t, raijintest.WithConfig(func(cfg *config.Config) {
+		cfg.AccessLogFormat = "json"
+		cfg.AccessLogOutput = accessLog
+		cfg.InternalAuth = config.InternalAuthConfig{
+			Callers: []config.InternalCaller{{Name: "billing-batch", Role: "admin"}},
+			Secrets: map[string]string{"billing-batch": batchKey},
+			MaxSkew: 5 * time.Minute,
+		}
+	}))
+	user := srv.CreateUser(t, "logged@example.com", raijintest.Password, "user")
+	anonymous := requestCount(t, "http_requests_by_auth", "GET /openapi.json anonymous")
+
+	do(t, signed(t, "GET", srv.URL+"/api/v1/admin/stats", "", "billing-batch", batchKey, nil))
+	for _, step := range []struct {
+		client *http.Client
+		path   string
+	}{
+		{srv.ClientAs(t, user), "/api/v1/users/me"},
+		{&http.Client{Transport: bearer{"not-a-token"}}, "/api/v1/users/me"},
+		{srv.Client(), "/openapi.json"},
+	} {
+		resp, err := step.client.Get(srv.URL + step.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	want := []struct {
+		path           string
+		status         int
+		userID, caller string
+	}{
+		{"/api/v1/admin/stats", http.StatusOK, "internal:billing-batch", "billing-batch"},
+		{"/api/v1/users/me", http.StatusOK, user.ID, ""},
+		{"/api/v1/users/me", http.StatusUnauthorized, "", ""},
+		{"/openapi.json", http.StatusOK, "", ""},
+	}
+	got := accessLogLines(t, accessLog, len(want))
+	if len(got) != len(want) {
+		t.Fatalf("%d access log lines, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		if e := got[i]; e.Path != w.path || e.Status != w.status || e.UserID != w.userID || e.Caller != w.caller {
+			t.Errorf("line %d: %s %d user_id %q caller %q; want %s %d user_id %q caller %q",
+				i+1, e.Path, e.Status, e.UserID, e.Caller, w.path, w.status, w.userID, w.caller)
+		}
+	}
+	if n := requestCount(t, "http_requests_by_auth", "GET /openapi.json anonymous"); n != anonymous+1 {
+		t.Errorf("http_requests_by_auth[GET /openapi.json anonymous] = %d, want %d", n, anonymous+1)
+	}
+}
+
+// The dev format names the user only when there is one.
+func TestAccessLogUserDev(t *testing.T) {
+	accessLog := filepath.Join(t.TempDir(), "access.log")
+	srv := raijintest.NewServer(t, raijintest.WithConfig(func(cfg *config.Config) {
+		cfg.AccessLogFormat = "dev"
+		cfg.AccessLogOutput = accessLog
+	}))
+	user := srv.CreateUser(t, "dev@example.com", raijintest.Password, "user")
+	for _, client := range []*http.Client{srv.ClientAs(t, user), srv.Client()} {
+		resp, err := client.Get(srv.URL + "/api/v1/users/me")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	var lines []string
+	for deadline := time.Now().Add(2 * time.Second); len(lines) < 2 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		data, _ := os.ReadFile(accessLog)
+		lines = strings.Split(strings.TrimSpace(string(data)), "\n")
+	}
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], " user="+user.ID) || strings.Contains(lines[1], "user=") {
+		t.Errorf("dev lines:\n%s", strings.Join(lines, "\n"))
+	}
+}
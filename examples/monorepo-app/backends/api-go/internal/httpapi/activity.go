@@ -0,0 +1,139 @@
+package httpapi
+
+import (
+	"encoding/base64"
+	"fmt"
+	"maps"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+)
+
+// Activity is one entry of a user's activity timeline, a security event
+// in a shape that does not depend on its type. Actor is the ID of the user
+// who acted: the account itself, an admin, "system" for what the server
+// did on its own (mail), or empty when unknown (a failed login).
+type Activity struct {
+	At      time.Time         `json:"at"`
+	Type    string            `json:"type"`
+	Actor   string            `json:"actor,omitempty"`
+	IP      string            `json:"ip,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// ActivityPage is a page of a timeline, newest first. The timeline grows
+// while it is read, so it pages by cursor rather than offset: NextCursor,
+// set when there are older entries, is the cursor parameter for them.
+type ActivityPage struct {
+	Activity   []Activity `json:"activity"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+// GetUserActivity is the timeline support looks at: logins, password and
+// role changes, suspensions, revoked sessions, mail and every admin action
+// on the account, as far back as AUDIT_LOG_RETENTION goes.
+func (h *Handlers) GetUserActivity(w http.ResponseWriter, r *http.Request) {
+	user, err := h.store.GetUserByID(r.PathValue("id"))
+	if err != nil {
+		writeUserError(w, r, err)
+		return
+	}
+	h.respondActivity(w, r, user, false)
+}
+
+// GetMyActivity is the caller's own timeline, without what is internal to
+// the admins: which admin acted, from where, and the details of admin
+// actions.
+func (h *Handlers) GetMyActivity(w http.ResponseWriter, r *http.Request) {
+	user, err := h.store.GetUserByID(r.Context().Value(ctxUserID).(string))
+	if err != nil {
+		writeUserError(w, r, err)
+		return
+	}
+	h.respondActivity(w, r, user, true)
+}
+
+func (h *Handlers) respondActivity(w http.ResponseWriter, r *http.Request, user *User, own bool) {
+	q := r.URL.Query()
+	f := SecurityEventFilter{Subject: user.ID, SubjectEmail: user.Email, Limit: defaultPageLimit}
+	var fields []FieldError
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageLimit {
+			fields = append(fields, FieldError{Field: "limit", Message: fmt.Sprintf("must be between 1 and %d", maxPageLimit)})
+		}
+		f.Limit = n
+	}
+	if v := q.Get("cursor"); v != "" {
+		seq, ok := decodeActivityCursor(v)
+		if !ok {
+			fields = append(fields, FieldError{Field: "cursor", Message: "must be a next_cursor of this timeline"})
+		}
+		f.Before = seq
+	}
+	if len(fields) > 0 {
+		writeErrorFields(w, r, http.StatusBadRequest, api.ErrCodeValidationFailed, "invalid query parameters", fields)
+		return
+	}
+	limit := f.Limit
+	f.Limit++ // one more tells whether there is a next page
+	events := h.store.SecurityEvents(f)
+	page := ActivityPage{Activity: make([]Activity, 0, min(len(events), limit))}
+	if len(events) > limit {
+		events = events[:limit]
+		page.NextCursor = encodeActivityCursor(events[limit-1].Seq)
+	}
+	for _, e := range events {
+		page.Activity = append(page.Activity, activityOf(e, user.ID, own))
+	}
+	respond(w, r, http.StatusOK, page)
+}
+
+// activityOf normalizes e for the timeline of userID; own leaves out what
+// only admins may see.
+func activityOf(e SecurityEvent, userID string, own bool) Activity {
+	a := Activity{At: e.Time, Type: e.Type, Actor: e.UserID, IP: e.IP, Details: maps.Clone(e.Details)}
+	switch {
+	case e.Type == EventAdminAction:
+		a.Type = e.Details["action"]
+		delete(a.Details, "action")
+		delete(a.Details, "user_id")
+	case e.Details["by"] != "":
+		a.Actor = e.Details["by"]
+		delete(a.Details, "by")
+	case e.Type == EventMailSent, e.Type == EventMailFailed:
+		a.Actor, a.IP = "system", ""
+		if own {
+			a.Details = map[string]string{"kind": e.Details["kind"]}
+		}
+	}
+	if own && a.Actor != userID && a.Actor != "system" && a.Actor != "" {
+		a.IP = ""
+		// An org owner who acted is a fellow member, not an admin to hide.
+		if e.Type != EventOrgMembership || e.Details["by_admin"] != "" {
+			a.Actor = "admin"
+		}
+		if e.Type == EventAdminAction {
+			a.Details = nil
+		}
+	}
+	if len(a.Details) == 0 {
+		a.Details = nil
+	}
+	return a
+}
+
+func encodeActivityCursor(seq uint64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(seq, 10)))
+}
+
+func decodeActivityCursor(s string) (uint64, bool) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(string(b), 10, 64)
+	return seq, err == nil && seq > 0
+}
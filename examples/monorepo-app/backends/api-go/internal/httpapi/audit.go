@@ -0,0 +1,200 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"maps"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+)
+
+// Security event types.
+const (
+	EventLogin           = "login"
+	EventLoginFailed     = "login_failed"
+	EventNewDevice       = "new_device"
+	EventRegister        = "register"
+	EventRegisterFailed  = "register_failed"
+	EventTokenRefresh    = "token_refresh"
+	EventRefreshFailed   = "token_refresh_failed"
+	EventAuthFailed      = "auth_failed"
+	EventCSRFRejected    = "csrf_rejected"
+	EventRateLimited     = "rate_limited"
+	EventAdminAction     = "admin_action"
+	EventPasswordChanged = "password_changed"
+	EventPhoneVerified   = "phone_verified"
+	EventTermsAccepted   = "terms_accepted"
+	EventDataExport      = "data_export"
+	EventDeletionRequest = "deletion_scheduled"
+	EventDeletionCancel  = "deletion_cancelled"
+	EventUserDeleted     = "user_deleted"
+	EventMailFailed      = "mail_failed"
+	EventMailSent        = "mail_sent"
+	EventSessionRevoked  = "session_revoked"
+	EventOrgMembership   = "org_membership"
+)
+
+// securityEvent fills the request-derived fields of an event from an
+// eventContext, including the user if Auth already identified one.
+func securityEvent(ctx context.Context, typ, outcome string) SecurityEvent {
+	meta, _ := ctx.Value(ctxRequestMeta).(requestMeta)
+	e := SecurityEvent{
+		Time: time.Now().UTC(), Type: typ, Outcome: outcome,
+		IP: meta.IP, UserAgent: meta.UserAgent, RequestID: meta.RequestID,
+	}
+	if tc, ok := TraceFrom(ctx); ok {
+		e.TraceID, e.SpanID = tc.TraceID, tc.SpanID
+	}
+	if uid, ok := ctx.Value(ctxUserID).(string); ok {
+		e.UserID = uid
+		e.Email, _ = ctx.Value(ctxEmail).(string)
+	}
+	return e
+}
+
+// auditOn records et to sink as a SecurityEvent of type typ; fill adds the
+// event-specific fields.
+func auditOn[T any](bus *EventBus, et EventType[T], sink AuditSink, typ, outcome string, fill func(*SecurityEvent, T)) {
+	et.Subscribe(bus, Sync, func(ctx context.Context, ev T) {
+		e := securityEvent(ctx, typ, outcome)
+		fill(&e, ev)
+		sink.Record(e)
+	})
+}
+
+// SubscribeAudit records the security-relevant events on bus to sink.
+func SubscribeAudit(bus *EventBus, sink AuditSink) {
+	user := func(e *SecurityEvent, ev UserEvent) { e.UserID, e.Email = ev.User.ID, ev.User.Email }
+	failure := func(e *SecurityEvent, ev AuthFailureEvent) {
+		if ev.UserID != "" {
+			e.UserID = ev.UserID
+		}
+		if ev.Email != "" {
+			e.Email = ev.Email
+		}
+		e.Details = map[string]string{"reason": ev.Reason}
+	}
+	rejection := func(e *SecurityEvent, ev RejectionEvent) { e.Details = ev.Details }
+
+	auditOn(bus, UserRegistered, sink, EventRegister, "success", user)
+	auditOn(bus, RegistrationFailed, sink, EventRegisterFailed, "failure", failure)
+	auditOn(bus, LoggedIn, sink, EventLogin, "success", user)
+	auditOn(bus, LoginFailed, sink, EventLoginFailed, "failure", failure)
+	auditOn(bus, NewDeviceLogin, sink, EventNewDevice, "success", func(e *SecurityEvent, ev DeviceEvent) {
+		e.UserID, e.Email = ev.User.ID, ev.User.Email
+		e.Details = map[string]string{"device": ev.Device, "fingerprint": ev.Fingerprint}
+	})
+	auditOn(bus, TokenRefreshed, sink, EventTokenRefresh, "success", user)
+	auditOn(bus, TokenRefreshFailed, sink, EventRefreshFailed, "failure", failure)
+	auditOn(bus, AuthRejected, sink, EventAuthFailed, "failure", rejection)
+	auditOn(bus, CSRFRejected, sink, EventCSRFRejected, "denied", rejection)
+	auditOn(bus, RateLimited, sink, EventRateLimited, "denied", rejection)
+	auditOn(bus, PasswordChanged, sink, EventPasswordChanged, "success", user)
+	auditOn(bus, PhoneVerified, sink, EventPhoneVerified, "success", user)
+	auditOn(bus, TermsAccepted, sink, EventTermsAccepted, "success", func(e *SecurityEvent, ev UserEvent) {
+		e.UserID, e.Email = ev.User.ID, ev.User.Email
+		if n := len(ev.User.TermsAccepted); n > 0 {
+			last := ev.User.TermsAccepted[n-1]
+			e.Details = map[string]string{"terms_version": last.TermsVersion, "privacy_version": last.PrivacyVersion}
+		}
+	})
+	auditOn(bus, DeletionScheduled, sink, EventDeletionRequest, "success", func(e *SecurityEvent, ev UserEvent) {
+		e.UserID, e.Email = ev.User.ID, ev.User.Email
+		e.Details = map[string]string{"delete_after": ev.User.DeleteAfter.Format(time.RFC3339)}
+	})
+	auditOn(bus, DeletionCancelled, sink, EventDeletionCancel, "success", user)
+	auditOn(bus, UserDeleted, sink, EventUserDeleted, "success", user)
+	auditOn(bus, DataExported, sink, EventDataExport, "success", func(e *SecurityEvent, ev DataExportEvent) {
+		e.UserID = ev.UserID
+		e.Details = map[string]string{"export_id": ev.ExportID, "action": ev.Action}
+	})
+	auditOn(bus, MailFailed, sink, EventMailFailed, "failure", func(e *SecurityEvent, ev MailEvent) {
+		e.Email = strings.Join(ev.To, ",")
+		e.Details = map[string]string{"kind": ev.Kind, "attempts": strconv.Itoa(ev.Attempts), "reason": ev.Reason}
+	})
+	auditOn(bus, MailSent, sink, EventMailSent, "success", func(e *SecurityEvent, ev MailEvent) {
+		e.Email = strings.Join(ev.To, ",")
+		e.Details = map[string]string{"kind": ev.Kind, "attempts": strconv.Itoa(ev.Attempts)}
+	})
+	auditOn(bus, SessionRevoked, sink, EventSessionRevoked, "success", func(e *SecurityEvent, ev SessionEvent) {
+		by := e.UserID
+		e.UserID, e.Email = ev.UserID, ""
+		e.Details = map[string]string{"reason": ev.Reason}
+		if ev.SessionID != "" {
+			e.Details["session_id"] = ev.SessionID
+		}
+		if by != "" && by != ev.UserID {
+			e.Details["by"] = by // the admin
+		}
+	})
+	auditOn(bus, OrgMemberChanged, sink, EventOrgMembership, "success", func(e *SecurityEvent, ev OrgMemberEvent) {
+		by := e.UserID
+		e.UserID, e.Email = ev.UserID, ""
+		e.Details = map[string]string{"org_id": ev.OrgID, "role": ev.Role, "action": ev.Action}
+		if by != "" && by != ev.UserID {
+			e.Details["by"] = by // an owner or admin
+		}
+		if ev.ByAdmin {
+			e.Details["by_admin"] = "true"
+		}
+	})
+	auditOn(bus, AdminAction, sink, EventAdminAction, "success", func(e *SecurityEvent, ev AdminActionEvent) {
+		e.Details = map[string]string{"action": ev.Action}
+		maps.Copy(e.Details, ev.Details)
+	})
+}
+
+// AuditSink receives security events. Record must be safe for concurrent
+// use and should not block for long; it runs on the request path.
+type AuditSink interface {
+	Record(SecurityEvent)
+}
+
+// JSONAuditSink writes one JSON object per line.
+type JSONAuditSink struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func NewJSONAuditSink(out io.Writer) *JSONAuditSink { return &JSONAuditSink{out: out} }
+
+func (s *JSONAuditSink) Record(e SecurityEvent) {
+	line, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("audit log: %v", err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.out.Write(append(line, '\n')); err != nil {
+		log.Printf("audit log: %v", err)
+	}
+}
+
+// StoreAuditSink keeps the most recent events in the Store for the admin
+// security-events endpoint.
+type StoreAuditSink struct {
+	store  store.Store
+	retain int
+}
+
+func NewStoreAuditSink(st store.Store, retain int) *StoreAuditSink {
+	return &StoreAuditSink{store: st, retain: retain}
+}
+
+func (s *StoreAuditSink) Record(e SecurityEvent) { s.store.AppendSecurityEvent(e, s.retain) }
+
+// MultiAuditSink fans events out to every sink.
+type MultiAuditSink []AuditSink
+
+func (m MultiAuditSink) Record(e SecurityEvent) {
+	for _, sink := range m {
+		sink.Record(e)
+	}
+}
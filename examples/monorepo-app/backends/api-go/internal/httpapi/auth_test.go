@@ -0,0 +1,120 @@
+package httpapi_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/auth"
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+	"github.com/your-org/your-app/backends/api-go/internal/store/storemock"
+	"github.com/your-org/your-app/backends/api-go/raijintest"
+)
+
+func TestLoginWithoutRandomnessIssuesNoToken(t *testing.T) {
+	st := store.NewMemory()
+	srv := raijintest.NewServer(t, raijintest.WithStore(st))
+	user := srv.CreateUser(t, "someone@example.com", raijintest.Password, "user")
+
+	prev := auth.Rand
+	auth.Rand = iotest.ErrReader(errors.New("no entropy"))
+	t.Cleanup(func() { auth.Rand = prev })
+
+	body, _ := json.Marshal(api.LoginRequest{Email: user.Email, Password: raijintest.Password})
+	resp, err := srv.Client().Post(srv.URL+"/api/v1/auth/login", "application/json", strings.NewReader(string(body)))
+	if err == nil {
+		got, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode < http.StatusInternalServerError {
+			t.Errorf("status %d", resp.StatusCode)
+		}
+		if strings.Contains(string(got), "token") {
+			t.Errorf("a token was issued: %s", got)
+		}
+	}
+	if n := st.CountSessions(); n != 0 {
+		t.Errorf("%d sessions stored", n)
+	}
+}
+
+// The token lifetimes in the configuration are the ones a login issues.
+func TestLoginUsesConfiguredLifetimes(t *testing.T) {
+	st := storemock.New()
+	srv := raijintest.NewServer(t, raijintest.WithStore(st), raijintest.WithConfig(func(c *config.Config) {
+		c.AccessTokenTTL = 2 * time.Minute
+		c.RefreshTokenTTL = 3 * time.Hour
+		c.CSRFTokenTTL = 10 * time.Minute
+	}))
+	user := srv.CreateUser(t, "someone@example.com", raijintest.Password, "user")
+
+	var session api.AuthResponseV2
+	wantStatus(t, send(t, srv.Client(), "POST", srv.URL+"/api/v2/auth/login",
+		api.LoginRequest{Email: user.Email, Password: raijintest.Password}, &session), http.StatusOK)
+	if session.ExpiresIn != 120 || session.RefreshExpiresIn != 3*3600 {
+		t.Errorf("expires_in %d, refresh_expires_in %d", session.ExpiresIn, session.RefreshExpiresIn)
+	}
+	claims, err := auth.VerifyJWT(srv.Config.JWTSecret, session.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := claims.Exp - claims.Iat; d != 120 {
+		t.Errorf("the access token lives %ds", d)
+	}
+	calls := st.Calls("StoreCSRFToken")
+	if len(calls) != 1 || calls[0].Args[3] != 10*time.Minute {
+		t.Errorf("StoreCSRFToken calls %v, want one with a 10m TTL", calls)
+	}
+}
+
+// Registration and admin creation refuse a password over 72 bytes, and a
+// login with one fails even when its first 72 bytes are the password.
+func TestPasswordByteLimit(t *testing.T) {
+	srv := raijintest.NewServer(t)
+	prefix := strings.Repeat("p", auth.MaxPasswordBytes)
+	post := func(client *http.Client, path string, body any) (int, api.APIError) {
+		t.Helper()
+		data, _ := json.Marshal(body)
+		resp, err := client.Post(srv.URL+path, "application/json", strings.NewReader(string(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var e api.APIError
+		json.NewDecoder(resp.Body).Decode(&e)
+		return resp.StatusCode, e
+	}
+	tooLong := func(what string, status int, e api.APIError) {
+		t.Helper()
+		if status != http.StatusBadRequest || e.ErrorCode != api.ErrCodeValidationFailed || len(e.Fields) != 1 || e.Fields[0].Field != "password" {
+			t.Errorf("%s: %d %+v", what, status, e)
+		}
+	}
+
+	for _, password := range []string{prefix + "x", strings.Repeat("é", 37)} { // 73 and 74 bytes
+		status, e := post(srv.Client(), "/api/v1/auth/register", api.RegisterRequest{Email: "long@example.com", Name: "Long", Password: password})
+		tooLong("register", status, e)
+	}
+	status, e := post(srv.LoginAs(t, "admin"), "/api/v1/admin/users",
+		api.CreateUserRequest{Email: "long@example.com", Name: "Long", Password: prefix + "x", Role: "user"})
+	tooLong("admin create", status, e)
+
+	if status, e := post(srv.Client(), "/api/v1/auth/register", api.RegisterRequest{Email: "long@example.com", Name: "Long", Password: prefix}); status != http.StatusCreated {
+		t.Fatalf("register with 72 bytes: %d %+v", status, e)
+	}
+	for password, want := range map[string]int{
+		prefix:        http.StatusOK,
+		prefix + "x":  http.StatusUnauthorized,
+		prefix + "yz": http.StatusUnauthorized,
+	} {
+		if status, e := post(srv.Client(), "/api/v1/auth/login", api.LoginRequest{Email: "long@example.com", Password: password}); status != want {
+			t.Errorf("login with %d bytes: %d %+v, want %d", len(password), status, e, want)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package httpapi
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+	"github.com/your-org/your-app/backends/api-go/reqsign"
+)
+
+// redacted replaces the values BodyLogger must not log.
+const redacted = "[REDACTED]"
+
+// redactedHeaders carry credentials in either direction.
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-CSRF-Token", "X-Proxy-Signature", reqsign.HeaderSignature, bypassHeader}
+
+// BodyLogger logs each request and its response, headers and bodies, as
+// DEBUG lines tied to the request ID (BODY_LOG_ENABLED). Only bodies it can
+// redact are logged: JSON, with the configured fields replaced at any depth
+// (unless numbers, booleans or null), and forms, with those fields' values
+// replaced. Others, and bodies over the size cap, are logged by size only.
+// The query string is redacted like a form, so a token in a link (such as
+// the unsubscribe one) is not logged either.
+type BodyLogger struct {
+	maxBytes int
+	fields   map[string]bool // lowercased
+}
+
+// NewBodyLogger checks the redaction before returning, as
+// LoadEmailTemplates renders every template: a configured field that would
+// leak keeps the server from starting.
+func NewBodyLogger(cfg config.BodyLogConfig) (*BodyLogger, error) {
+	l := &BodyLogger{maxBytes: cfg.MaxBytes, fields: make(map[string]bool, len(cfg.RedactFields))}
+	for _, f := range cfg.RedactFields {
+		l.fields[strings.ToLower(f)] = true
+	}
+	const canary = "body-log-canary-secret"
+	for f := range l.fields {
+		sample := fmt.Sprintf(`{"outer":[{%q:%q,"nested":{%q:{"value":%q}}}]}`, f, canary, strings.ToUpper(f), canary)
+		if out, ok := l.redactJSON([]byte(sample)); !ok || strings.Contains(out, canary) {
+			return nil, fmt.Errorf("body log: field %q is not redacted", f)
+		}
+		u := &url.URL{Path: "/canary", RawQuery: url.QueryEscape(f) + "=" + canary}
+		if strings.Contains(l.redactURI(u), canary) {
+			return nil, fmt.Errorf("body log: query parameter %q is not redacted", f)
+		}
+	}
+	return l, nil
+}
+
+func (l *BodyLogger) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqBody := &cappedBuffer{max: l.maxBytes}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.TeeReader(r.Body, reqBody), r.Body}
+		}
+		rec := &bodyRecorder{ResponseWriter: w, code: http.StatusOK, body: &cappedBuffer{max: l.maxBytes}}
+		next.ServeHTTP(rec, r)
+
+		tc, _ := TraceFrom(r.Context())
+		id := r.Header.Get("X-Request-ID")
+		log.Printf("DEBUG body: request_id=%s trace_id=%s %s %s headers=%s body=%s", id, tc.TraceID,
+			r.Method, l.redactURI(r.URL), redactHeaders(r.Header), l.render(reqBody, r.Header.Get("Content-Type")))
+		log.Printf("DEBUG body: request_id=%s trace_id=%s status=%d headers=%s body=%s", id, tc.TraceID,
+			rec.code, redactHeaders(w.Header()), l.render(rec.body, w.Header().Get("Content-Type")))
+	})
+}
+
+// render is body as it is logged.
+func (l *BodyLogger) render(body *cappedBuffer, contentType string) string {
+	switch {
+	case body.over:
+		return fmt.Sprintf("(over %d bytes, not logged)", l.maxBytes)
+	case body.buf.Len() == 0:
+		return "(empty)"
+	}
+	b := body.buf.Bytes()
+	// Handlers decode JSON whatever the Content-Type says, so JSON is
+	// recognized by its syntax.
+	if out, ok := l.redactJSON(b); ok {
+		return out
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "application/x-www-form-urlencoded" {
+		if out, ok := l.redactForm(b); ok {
+			return out
+		}
+	}
+	return fmt.Sprintf("(%d bytes of %s, not logged)", len(b), cmp.Or(mediaType, "unknown type"))
+}
+
+// formKey matches form field names. Anything else in the place of one,
+// such as truncated JSON, could carry a secret.
+var formKey = regexp.MustCompile(`^[A-Za-z0-9_.\[\]-]+$`)
+
+// redactForm renders a form as JSON with the configured fields' values
+// replaced.
+func (l *BodyLogger) redactForm(b []byte) (string, bool) {
+	form, err := url.ParseQuery(string(b))
+	if err != nil {
+		return "", false
+	}
+	for k := range form {
+		if !formKey.MatchString(k) {
+			return "", false
+		}
+		if l.fields[strings.ToLower(k)] {
+			form[k] = []string{redacted}
+		}
+	}
+	out, _ := json.Marshal(form)
+	return string(out), true
+}
+
+// redactURI is the request URI with the configured fields' values replaced
+// in the query. A query that does not parse is left out.
+func (l *BodyLogger) redactURI(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.EscapedPath()
+	}
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return u.EscapedPath() + "?(unparsable query, not logged)"
+	}
+	for k := range query {
+		if l.fields[strings.ToLower(k)] {
+			query[k] = []string{redacted}
+		}
+	}
+	return u.EscapedPath() + "?" + query.Encode()
+}
+
+// redactJSON re-encodes b with the values of the configured fields
+// replaced, in objects at any depth. It fails on anything but a single
+// JSON value.
+func (l *BodyLogger) redactJSON(b []byte) (string, bool) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return "", false
+	}
+	out, err := json.Marshal(l.redact(v))
+	if err != nil {
+		return "", false
+	}
+	return string(out), true
+}
+
+func (l *BodyLogger) redact(v any) any {
+	switch x := v.(type) {
+	case map[string]any:
+		for k, field := range x {
+			if l.fields[strings.ToLower(k)] && !isJSONScalar(field) {
+				x[k] = redacted
+			} else {
+				x[k] = l.redact(field)
+			}
+		}
+	case []any:
+		for i, item := range x {
+			x[i] = l.redact(item)
+		}
+	}
+	return v
+}
+
+// isJSONScalar reports whether v is a number, boolean or null: never a
+// secret, and left alone so the status "code" of error bodies shows.
+func isJSONScalar(v any) bool {
+	switch v.(type) {
+	case json.Number, bool, nil:
+		return true
+	}
+	return false
+}
+
+// redactHeaders renders h as JSON with the credentials replaced.
+func redactHeaders(h http.Header) string {
+	h = h.Clone()
+	for _, name := range redactedHeaders {
+		if _, ok := h[http.CanonicalHeaderKey(name)]; ok {
+			h.Set(name, redacted)
+		}
+	}
+	out, _ := json.Marshal(h)
+	return string(out)
+}
+
+// cappedBuffer keeps what is written to it up to max bytes; past that it
+// only remembers it overflowed.
+type cappedBuffer struct {
+	buf  bytes.Buffer
+	max  int
+	over bool
+}
+
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	if !c.over {
+		if c.buf.Len()+len(p) > c.max {
+			c.over = true
+			c.buf = bytes.Buffer{}
+		} else {
+			c.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// bodyRecorder copies the response into body for BodyLogger.
+type bodyRecorder struct {
+	http.ResponseWriter
+	code int
+	body *cappedBuffer
+}
+
+func (br *bodyRecorder) WriteHeader(code int) { br.code = code; br.ResponseWriter.WriteHeader(code) }
+
+func (br *bodyRecorder) Write(b []byte) (int, error) {
+	n, err := br.ResponseWriter.Write(b)
+	br.body.Write(b[:n])
+	return n, err
+}
+
+// Flush passes through to the underlying writer, like statusRecorder's.
+func (br *bodyRecorder) Flush() {
+	if f, ok := br.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController and noteWriteError reach the
+// underlying writer.
+func (br *bodyRecorder) Unwrap() http.ResponseWriter { return br.ResponseWriter }
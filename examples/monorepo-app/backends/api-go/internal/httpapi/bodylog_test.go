@@ -0,0 +1,144 @@
+package httpapi_test
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+	"github.com/your-org/your-app/backends/api-go/raijintest"
+)
+
+// captureLog sends the standard logger to a buffer until the test ends.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	out, flags := log.Writer(), log.Flags()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(out); log.SetFlags(flags) })
+	return &buf
+}
+
+// bodyLogLines returns the DEBUG body lines logged so far.
+func bodyLogLines(buf *bytes.Buffer) string {
+	var lines []string
+	for line := range strings.Lines(buf.String()) {
+		if strings.Contains(line, "DEBUG body:") {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+func bodyLogServer(t *testing.T) *raijintest.Server {
+	return raijintest.NewServer(t, raijintest.WithConfig(func(c *config.Config) {
+		c.BodyLog = config.BodyLogConfig{
+			Enabled:      true,
+			MaxBytes:     8192,
+			RedactFields: []string{"password", "access_token", "refresh_token", "csrf_token", "token"},
+		}
+	}))
+}
+
+func TestBodyLogRedactsQuery(t *testing.T) {
+	srv := bodyLogServer(t)
+	buf := captureLog(t)
+
+	const secret = "unsubscribe-token-that-must-not-be-logged"
+	send(t, srv.Client(), "GET", srv.URL+"/api/v1/notifications/unsubscribe?token="+secret+"&lang=pt-BR", nil, nil)
+
+	logged := bodyLogLines(buf)
+	if logged == "" {
+		t.Fatal("nothing logged")
+	}
+	if strings.Contains(logged, secret) {
+		t.Errorf("the token was logged:\n%s", logged)
+	}
+	want := "/api/v1/notifications/unsubscribe?" + url.Values{"token": {"[REDACTED]"}, "lang": {"pt-BR"}}.Encode()
+	if !strings.Contains(logged, want) {
+		t.Errorf("want %s in:\n%s", want, logged)
+	}
+}
+
+func TestBodyLogRedactsCredentials(t *testing.T) {
+	srv := bodyLogServer(t)
+	user := srv.CreateUser(t, "someone@example.com", raijintest.Password, "user")
+	buf := captureLog(t)
+
+	var auth struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		CSRFToken    string `json:"csrf_token"`
+	}
+	wantStatus(t, send(t, srv.Client(), "POST", srv.URL+"/api/v1/auth/login",
+		api.LoginRequest{Email: user.Email, Password: raijintest.Password}, &auth), http.StatusOK)
+
+	form := url.Values{"email": {user.Email}, "password": {raijintest.Password}}
+	resp, err := srv.Client().PostForm(srv.URL+"/api/v1/auth/login", form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL+"/api/v1/users/me", nil)
+	req.Header.Set("Authorization", "Bearer "+auth.AccessToken)
+	req.Header.Set("Cookie", "session="+auth.RefreshToken)
+	if resp, err = srv.Client().Do(req); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	logged := bodyLogLines(buf)
+	for name, secret := range map[string]string{
+		"password":      raijintest.Password,
+		"access token":  auth.AccessToken,
+		"refresh token": auth.RefreshToken,
+		"CSRF token":    auth.CSRFToken,
+	} {
+		if secret == "" {
+			t.Fatalf("login returned no %s", name)
+		}
+		if strings.Contains(logged, secret) {
+			t.Errorf("the %s was logged:\n%s", name, logged)
+		}
+	}
+	if !strings.Contains(logged, user.Email) {
+		t.Errorf("the fields that are not redacted are missing:\n%s", logged)
+	}
+}
+
+// Configured fields are redacted at any depth, in objects within objects
+// and arrays, whatever their case, and an object under one goes whole.
+func TestBodyLogRedactsNested(t *testing.T) {
+	srv := bodyLogServer(t)
+	buf := captureLog(t)
+
+	secrets := []string{"nested-password-1", "token-in-array-2", "token-in-deep-array-3", "object-under-password-4", "mixed-case-token-5"}
+	body := map[string]any{
+		"user":         map[string]any{"email": "nested@example.com", "password": secrets[0]},
+		"devices":      []any{map[string]any{"name": "phone", "token": secrets[1]}},
+		"batches":      []any{[]any{map[string]any{"auth": map[string]any{"refresh_token": secrets[2]}}}},
+		"change":       map[string]any{"password": map[string]any{"old": secrets[3], "new": secrets[3]}},
+		"Access_Token": secrets[4],
+	}
+	send(t, srv.Client(), "POST", srv.URL+"/api/v1/auth/login", body, nil)
+
+	logged := bodyLogLines(buf)
+	if logged == "" {
+		t.Fatal("nothing logged")
+	}
+	for _, secret := range secrets {
+		if strings.Contains(logged, secret) {
+			t.Errorf("%s was logged:\n%s", secret, logged)
+		}
+	}
+	for _, want := range []string{`"email":"nested@example.com"`, `"name":"phone"`, `"password":"[REDACTED]"`, `"token":"[REDACTED]"`, `"refresh_token":"[REDACTED]"`} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("no %s in:\n%s", want, logged)
+		}
+	}
+}
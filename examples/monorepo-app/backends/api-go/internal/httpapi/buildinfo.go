@@ -0,0 +1,62 @@
+package httpapi
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Version, BuildTime and GitCommit describe the binary. cmd/server copies
+// in what -ldflags "-X main.Version=..." set before calling New; unset
+// values fall back to the module build info.
+var (
+	Version   = "dev"
+	BuildTime = "unknown"
+	GitCommit = "unknown"
+	startTime = time.Now()
+)
+
+// buildInfo merges the ldflags values with debug.ReadBuildInfo, so binaries
+// from "go install" or a plain "go build" in a checkout still report the
+// module version and VCS revision.
+var buildInfo = sync.OnceValue(func() BuildInfo {
+	bi, _ := debug.ReadBuildInfo()
+	return mergeBuildInfo(BuildInfo{Version: Version, BuildTime: BuildTime, GitCommit: GitCommit, GoVersion: runtime.Version()}, bi)
+})
+
+// mergeBuildInfo fills the fields of info that ldflags left at their
+// defaults from bi, which may be nil.
+func mergeBuildInfo(info BuildInfo, bi *debug.BuildInfo) BuildInfo {
+	if bi == nil {
+		return info
+	}
+	if info.Version == "dev" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		info.Version = bi.Main.Version
+	}
+	var revision, vcsTime string
+	var modified bool
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.time":
+			vcsTime = s.Value
+		case "vcs.modified":
+			modified = s.Value == "true"
+		}
+	}
+	if info.GitCommit == "unknown" && revision != "" {
+		info.GitCommit = revision
+		if modified {
+			info.GitCommit += "-dirty"
+		}
+	}
+	if info.BuildTime == "unknown" && vcsTime != "" {
+		info.BuildTime = vcsTime
+	}
+	return info
+}
+
+// Build reports the version, commit and build time of the binary.
+func Build() BuildInfo { return buildInfo() }
@@ -0,0 +1,109 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+)
+
+func TestMergeBuildInfo(t *testing.T) {
+	unset := BuildInfo{Version: "dev", BuildTime: "unknown", GitCommit: "unknown", GoVersion: "go1.99"}
+	ldflags := BuildInfo{Version: "v1.2.3", BuildTime: "2026-01-02T03:04:05Z", GitCommit: "abc123", GoVersion: "go1.99"}
+	vcs := func(main string, settings ...string) *debug.BuildInfo {
+		bi := &debug.BuildInfo{Main: debug.Module{Version: main}}
+		for i := 0; i < len(settings); i += 2 {
+			bi.Settings = append(bi.Settings, debug.BuildSetting{Key: settings[i], Value: settings[i+1]})
+		}
+		return bi
+	}
+	checkout := vcs("(devel)", "vcs.revision", "f00d", "vcs.time", "2026-05-06T07:08:09Z", "vcs.modified", "false")
+	for _, tt := range []struct {
+		name string
+		info BuildInfo
+		bi   *debug.BuildInfo
+		want BuildInfo
+	}{
+		{"no build info", unset, nil, unset},
+		{"go install", unset, vcs("v0.4.0"), BuildInfo{Version: "v0.4.0", BuildTime: "unknown", GitCommit: "unknown", GoVersion: "go1.99"}},
+		{"checkout", unset, checkout, BuildInfo{Version: "dev", BuildTime: "2026-05-06T07:08:09Z", GitCommit: "f00d", GoVersion: "go1.99"}},
+		{"dirty checkout", unset, vcs("", "vcs.revision", "f00d", "vcs.modified", "true"), BuildInfo{Version: "dev", BuildTime: "unknown", GitCommit: "f00d-dirty", GoVersion: "go1.99"}},
+		{"ldflags win", ldflags, checkout, ldflags},
+	} {
+		if got := mergeBuildInfo(tt.info, tt.bi); got != tt.want {
+			t.Errorf("%s: %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// GET /version is public, has exactly the build fields, matches the verbose
+// health payload, and stays out of the access log.
+func TestVersionEndpoint(t *testing.T) {
+	st := store.NewMemory()
+	accessLog := filepath.Join(t.TempDir(), "access.log")
+	_, ts := openAPIServer(t, st, func(cfg *config.Config) { cfg.AccessLogOutput = accessLog })
+
+	resp, err := http.Get(ts.URL + "/version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%d: %s", resp.StatusCode, data)
+	}
+	var fields map[string]string
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("%v: %s", err, data)
+	}
+	keys := make([]string, 0, len(fields))
+	for k, v := range fields {
+		keys = append(keys, k)
+		if v == "" {
+			t.Errorf("%s is empty", k)
+		}
+	}
+	slices.Sort(keys)
+	if want := []string{"build_time", "git_commit", "go_version", "version"}; !slices.Equal(keys, want) {
+		t.Errorf("keys %v, want %v", keys, want)
+	}
+	var got BuildInfo
+	json.Unmarshal(data, &got)
+	if got != Build() {
+		t.Errorf("/version %+v, Build() %+v", got, Build())
+	}
+
+	admin, err := st.GetUserByEmail("admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, health := getHealth(t, ts.URL+"/health?verbose=1", openAPIToken(t, admin))
+	var want map[string]any
+	json.Unmarshal(data, &want)
+	if !reflect.DeepEqual(health["build"], want) {
+		t.Errorf("health build %v, /version %v", health["build"], want)
+	}
+
+	if resp, err := http.Get(ts.URL + "/openapi.json"); err == nil {
+		resp.Body.Close()
+	}
+	logged, err := os.ReadFile(accessLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(logged), "/openapi.json") {
+		t.Fatalf("the access log missed a request:\n%s", logged)
+	}
+	if strings.Contains(string(logged), "/version") {
+		t.Errorf("/version was logged:\n%s", logged)
+	}
+}
@@ -0,0 +1,200 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+)
+
+const testCaptchaToken = "solved-captcha"
+
+func TestStaticChallenge(t *testing.T) {
+	c := StaticChallenge{Token: testCaptchaToken}
+	if err := c.Verify(context.Background(), testCaptchaToken, ""); err != nil {
+		t.Errorf("the token: %v", err)
+	}
+	for _, token := range []string{"", "solved", testCaptchaToken + "x"} {
+		if err := c.Verify(context.Background(), token, ""); !errors.Is(err, ErrChallengeFailed) {
+			t.Errorf("%q: %v", token, err)
+		}
+	}
+}
+
+// errUnavailable marks the cases where Verify must fail for a reason other
+// than the user's token.
+var errUnavailable = errors.New("unavailable")
+
+func TestSiteVerifyChallenge(t *testing.T) {
+	var answer string
+	var status int
+	siteverify := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("secret") != "captcha-secret" || r.FormValue("response") != "tok" || r.FormValue("remoteip") != "203.0.113.7" {
+			t.Errorf("form %v", r.Form)
+		}
+		if answer == "slow" {
+			time.Sleep(300 * time.Millisecond)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(answer))
+	}))
+	defer siteverify.Close()
+	cfg := config.Defaults()
+	cfg.Captcha = config.CaptchaConfig{Provider: "hcaptcha", Secret: "captcha-secret", VerifyURL: siteverify.URL, Timeout: 100 * time.Millisecond}
+	c := NewChallengeProvider(cfg, NewOutbound(cfg.Outbound))
+
+	for _, tt := range []struct {
+		name, answer string
+		status       int
+		want         error // nil, ErrChallengeFailed, or errUnavailable
+	}{
+		{"success", `{"success":true}`, http.StatusOK, nil},
+		{"refused", `{"success":false,"error-codes":["invalid-input-response"]}`, http.StatusOK, ErrChallengeFailed},
+		{"bad secret", `{"success":false,"error-codes":["invalid-input-secret"]}`, http.StatusOK, errUnavailable},
+		{"server error", `oops`, http.StatusInternalServerError, errUnavailable},
+		{"garbage", `not json`, http.StatusOK, errUnavailable},
+		{"timeout", "slow", http.StatusOK, errUnavailable},
+	} {
+		answer, status = tt.answer, tt.status
+		err := c.Verify(context.Background(), "tok", "203.0.113.7")
+		switch {
+		case tt.want == nil && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.want == ErrChallengeFailed && !errors.Is(err, ErrChallengeFailed):
+			t.Errorf("%s: %v, want the challenge failed", tt.name, err)
+		case tt.want == errUnavailable && (err == nil || errors.Is(err, ErrChallengeFailed)):
+			t.Errorf("%s: %v, want the provider unavailable", tt.name, err)
+		}
+	}
+
+	cfg.Captcha = config.CaptchaConfig{}
+	if NewChallengeProvider(cfg, NewOutbound(cfg.Outbound)) != nil {
+		t.Error("a provider without CAPTCHA_PROVIDER")
+	}
+}
+
+// captchaServer runs the API with the static provider, trusting loopback
+// proxies so X-Forwarded-For picks the client IP.
+func captchaServer(t *testing.T, opts ...func(*config.Config)) *httptest.Server {
+	t.Helper()
+	withTrustedProxies(t)
+	_, ts := openAPIServer(t, store.NewMemory(), append([]func(*config.Config){func(cfg *config.Config) {
+		cfg.Captcha.Provider = "static"
+		cfg.Captcha.Secret = testCaptchaToken
+		cfg.Captcha.LoginAfter = 2
+		cfg.RateLimitExempt.TrustedProxies = []string{"127.0.0.0/8"}
+	}}, opts...)...)
+	return ts
+}
+
+// postAuth posts body to /api/v1/auth/path from ip and returns the status
+// and error code.
+func postAuth(t *testing.T, ts *httptest.Server, path, ip string, body any) (int, string) {
+	t.Helper()
+	data, _ := json.Marshal(body)
+	req, _ := http.NewRequest("POST", ts.URL+"/api/v1/auth/"+path, strings.NewReader(string(data)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Forwarded-For", ip)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var e APIError
+	json.NewDecoder(resp.Body).Decode(&e)
+	return resp.StatusCode, e.ErrorCode
+}
+
+func TestRegisterChallenge(t *testing.T) {
+	ts := captchaServer(t)
+	register := func(email, token string) (int, string) {
+		return postAuth(t, ts, "register", "203.0.113.1", api.RegisterRequest{Email: email, Name: "Bot?", Password: "register-password", CaptchaToken: token})
+	}
+	for _, token := range []string{"", "wrong"} {
+		if status, code := register("bot@example.com", token); status != http.StatusForbidden || code != api.ErrCodeCaptchaRequired {
+			t.Errorf("token %q: %d %s", token, status, code)
+		}
+	}
+	if status, code := register("human@example.com", testCaptchaToken); status != http.StatusCreated {
+		t.Errorf("solved: %d %s", status, code)
+	}
+
+	ts = captchaServer(t, func(cfg *config.Config) { cfg.Captcha.Register = false })
+	if status, code := postAuth(t, ts, "register", "203.0.113.1", api.RegisterRequest{Email: "x@example.com", Name: "X", Password: "register-password"}); status != http.StatusCreated {
+		t.Errorf("CAPTCHA_REGISTER=false: %d %s", status, code)
+	}
+}
+
+// Logins need the CAPTCHA after CAPTCHA_LOGIN_AFTER failures from the IP,
+// or for the email from any IP, and not before.
+func TestLoginChallenge(t *testing.T) {
+	ts := captchaServer(t)
+	login := func(ip, email, password, token string) (int, string) {
+		return postAuth(t, ts, "login", ip, api.LoginRequest{Email: email, Password: password, CaptchaToken: token})
+	}
+
+	if status, _ := login("203.0.113.1", "admin@example.com", "admin123", ""); status != http.StatusOK {
+		t.Fatalf("first login: %d", status)
+	}
+	for range 2 {
+		if status, code := login("203.0.113.1", "admin@example.com", "wrong-password", ""); status != http.StatusUnauthorized {
+			t.Fatalf("bad password: %d %s", status, code)
+		}
+	}
+	for _, tt := range []struct {
+		name, ip, email string
+	}{
+		{"same IP and email", "203.0.113.1", "admin@example.com"},
+		{"same IP, other email", "203.0.113.1", "someone@example.com"},
+		{"same email, other IP", "198.51.100.9", "admin@example.com"},
+	} {
+		if status, code := login(tt.ip, tt.email, "admin123", ""); status != http.StatusForbidden || code != api.ErrCodeCaptchaRequired {
+			t.Errorf("%s: %d %s, want captcha_required", tt.name, status, code)
+		}
+	}
+	if status, code := login("198.51.100.10", "someone@example.com", "whatever-password", ""); status != http.StatusUnauthorized {
+		t.Errorf("an unrelated IP and email: %d %s", status, code)
+	}
+	if status, code := login("203.0.113.1", "admin@example.com", "admin123", "wrong"); status != http.StatusForbidden || code != api.ErrCodeCaptchaRequired {
+		t.Errorf("wrong token: %d %s", status, code)
+	}
+	if status, code := login("198.51.100.9", "admin@example.com", "admin123", testCaptchaToken); status != http.StatusOK {
+		t.Fatalf("solved: %d %s", status, code)
+	}
+	// A success clears the email's count, not the IP's.
+	if status, _ := login("198.51.100.11", "admin@example.com", "admin123", ""); status != http.StatusOK {
+		t.Errorf("after a success, from a clean IP: %d", status)
+	}
+	if status, _ := login("203.0.113.1", "admin@example.com", "admin123", ""); status != http.StatusForbidden {
+		t.Errorf("after a success, from the failing IP: %d", status)
+	}
+}
+
+// An unreachable provider refuses the request unless CAPTCHA_FAIL_OPEN.
+func TestChallengeProviderDown(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	for _, failOpen := range []bool{false, true} {
+		ts := captchaServer(t, func(cfg *config.Config) {
+			cfg.Captcha.Provider = "turnstile"
+			cfg.Captcha.VerifyURL = down.URL
+			cfg.Captcha.Timeout = time.Second
+			cfg.Captcha.FailOpen = failOpen
+		})
+		status, code := postAuth(t, ts, "register", "203.0.113.1", api.RegisterRequest{Email: "x@example.com", Name: "X", Password: "register-password", CaptchaToken: "tok"})
+		if failOpen && status != http.StatusCreated {
+			t.Errorf("fail open: %d %s", status, code)
+		}
+		if !failOpen && (status != http.StatusServiceUnavailable || code != api.ErrCodeCaptchaUnavailable) {
+			t.Errorf("fail closed: %d %s", status, code)
+		}
+	}
+}
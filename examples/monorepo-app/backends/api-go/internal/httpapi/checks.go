@@ -0,0 +1,144 @@
+package httpapi
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// CheckFunc reports whether a dependency is usable. It must honour ctx.
+type CheckFunc func(ctx context.Context) error
+
+const (
+	StatusHealthy   = "healthy"
+	StatusDegraded  = "degraded"
+	StatusUnhealthy = "unhealthy"
+)
+
+type check struct {
+	fn       CheckFunc
+	critical bool
+}
+
+// Checks is a registry of named dependency checks. Results are cached for
+// ttl so frequent probes don't hammer the backing services.
+type Checks struct {
+	mu        sync.Mutex
+	names     []string
+	checks    map[string]check
+	timeout   time.Duration
+	ttl       time.Duration
+	results   map[string]string
+	status    string
+	checkedAt time.Time
+	running   *checkRun // the run in flight, nil when none
+}
+
+// checkRun is one execution of the checks, which the probes arriving
+// while it is in flight wait for instead of starting their own.
+type checkRun struct {
+	done    chan struct{}
+	results map[string]string
+	status  string
+}
+
+func NewChecks(timeout, ttl time.Duration) *Checks {
+	return &Checks{checks: make(map[string]check), timeout: timeout, ttl: ttl}
+}
+
+// Register adds a critical check: its failure makes the service unhealthy.
+// Registering a name twice replaces the check.
+func (c *Checks) Register(name string, fn CheckFunc) { c.register(name, check{fn: fn, critical: true}) }
+
+// RegisterOptional adds a check whose failure only degrades the service.
+func (c *Checks) RegisterOptional(name string, fn CheckFunc) { c.register(name, check{fn: fn}) }
+
+func (c *Checks) register(name string, chk check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exists := c.checks[name]; !exists {
+		c.names = append(c.names, name)
+	}
+	c.checks[name] = chk
+	c.checkedAt = time.Time{}
+}
+
+// Run executes all checks concurrently under a shared timeout and returns
+// the per-check result ("ok" or the failure) and the overall status.
+// Callers arriving while a run is in flight share its result. The lock is
+// only held to look at the cache, so a slow check holds up nothing but
+// the probes that wait for it.
+func (c *Checks) Run(ctx context.Context) (map[string]string, string) {
+	c.mu.Lock()
+	if !c.checkedAt.IsZero() && time.Since(c.checkedAt) < c.ttl {
+		defer c.mu.Unlock()
+		return c.results, c.status
+	}
+	if run := c.running; run != nil {
+		c.mu.Unlock()
+		<-run.done // bounded by the timeout
+		return run.results, run.status
+	}
+	run := &checkRun{done: make(chan struct{})}
+	c.running = run
+	names, checks := slices.Clone(c.names), maps.Clone(c.checks)
+	c.mu.Unlock()
+
+	// The run is shared, so one caller going away must not cut it short.
+	run.results, run.status = runChecks(context.WithoutCancel(ctx), c.timeout, names, checks)
+
+	c.mu.Lock()
+	c.results, c.status, c.checkedAt = run.results, run.status, time.Now()
+	c.running = nil
+	c.mu.Unlock()
+	close(run.done)
+	return run.results, run.status
+}
+
+func runChecks(ctx context.Context, timeout time.Duration, names []string, checks map[string]check) (map[string]string, string) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type result struct {
+		name string
+		err  error
+	}
+	ch := make(chan result, len(names))
+	for _, name := range names {
+		go func(name string, fn CheckFunc) {
+			ch <- result{name: name, err: fn(ctx)}
+		}(name, checks[name].fn)
+	}
+
+	results := make(map[string]string, len(names))
+	for _, name := range names {
+		results[name] = "timeout"
+	}
+collect:
+	for range names {
+		select {
+		case res := <-ch:
+			if res.err != nil {
+				results[res.name] = res.err.Error()
+			} else {
+				results[res.name] = "ok"
+			}
+		case <-ctx.Done():
+			break collect
+		}
+	}
+	status := StatusHealthy
+	for name, res := range results {
+		if res == "ok" {
+			continue
+		}
+		if checks[name].critical {
+			status = StatusUnhealthy
+			break
+		}
+		status = StatusDegraded
+	}
+	return results, status
+}
@@ -0,0 +1,122 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestChecksStatus(t *testing.T) {
+	tests := []struct {
+		name      string
+		critical  error
+		optional  error
+		want      string
+		wantStore string
+	}{
+		{"all ok", nil, nil, StatusHealthy, "ok"},
+		{"optional failed", nil, errors.New("smtp down"), StatusDegraded, "ok"},
+		{"critical failed", errors.New("store down"), nil, StatusUnhealthy, "store down"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewChecks(time.Second, 0)
+			c.Register("store", func(context.Context) error { return tt.critical })
+			c.RegisterOptional("smtp", func(context.Context) error { return tt.optional })
+			results, status := c.Run(context.Background())
+			if status != tt.want {
+				t.Errorf("status = %q, want %q", status, tt.want)
+			}
+			if results["store"] != tt.wantStore {
+				t.Errorf("store = %q, want %q", results["store"], tt.wantStore)
+			}
+		})
+	}
+}
+
+func TestChecksTimeout(t *testing.T) {
+	c := NewChecks(20*time.Millisecond, 0)
+	c.Register("store", func(ctx context.Context) error { <-ctx.Done(); return ctx.Err() })
+	results, status := c.Run(context.Background())
+	if status != StatusUnhealthy || results["store"] == "ok" {
+		t.Errorf("Run = %v, %q; want the hung check failed", results, status)
+	}
+}
+
+func TestChecksCachesForTTL(t *testing.T) {
+	var calls atomic.Int32
+	c := NewChecks(time.Second, time.Hour)
+	c.Register("store", func(context.Context) error { calls.Add(1); return nil })
+	for range 3 {
+		c.Run(context.Background())
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("check ran %d times within the TTL, want 1", n)
+	}
+}
+
+// Probes arriving during a run share it, and the lock is not held while
+// the checks run.
+func TestChecksCoalesceConcurrentRuns(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	c := NewChecks(5*time.Second, 0)
+	c.Register("store", func(context.Context) error {
+		calls.Add(1)
+		<-release
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	statuses := make([]string, 5)
+	for i := range statuses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, statuses[i] = c.Run(context.Background())
+		}()
+	}
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	registered := make(chan struct{})
+	go func() {
+		c.RegisterOptional("smtp", func(context.Context) error { return nil })
+		close(registered)
+	}()
+	select {
+	case <-registered:
+	case <-time.After(time.Second):
+		t.Fatal("Register blocked behind a running check")
+	}
+
+	close(release)
+	wg.Wait()
+	if n := calls.Load(); n != 1 {
+		t.Errorf("check ran %d times for concurrent probes, want 1", n)
+	}
+	for i, st := range statuses {
+		if st != StatusHealthy {
+			t.Errorf("probe %d: status %q", i, st)
+		}
+	}
+}
+
+// The caller that starts a shared run going away doesn't fail it for the
+// others.
+func TestChecksRunOutlivesItsCaller(t *testing.T) {
+	c := NewChecks(time.Second, 0)
+	c.Register("store", func(ctx context.Context) error {
+		time.Sleep(20 * time.Millisecond)
+		return ctx.Err()
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, status := c.Run(ctx); status != StatusHealthy {
+		t.Errorf("status = %q, want %q", status, StatusHealthy)
+	}
+}
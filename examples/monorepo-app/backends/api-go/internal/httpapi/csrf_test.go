@@ -0,0 +1,68 @@
+package httpapi_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/your-org/your-app/backends/api-go/internal/auth"
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+	"github.com/your-org/your-app/backends/api-go/raijintest"
+)
+
+func TestCSRFTokenIsBoundToItsUser(t *testing.T) {
+	srv := raijintest.NewServer(t)
+	alice := srv.CreateUser(t, "alice@example.com", raijintest.Password, "user")
+	bob := srv.CreateUser(t, "bob@example.com", raijintest.Password, "user")
+	aliceCSRF := auth.GenerateToken()
+	srv.Store.StoreCSRFToken(aliceCSRF, alice.ID, "", srv.Config.CSRFTokenTTL)
+
+	tests := []struct {
+		name string
+		user string // whose access token
+		csrf string
+		want int
+	}{
+		{"own token", srv.Token(t, alice), aliceCSRF, http.StatusOK},
+		{"another user's token", srv.Token(t, bob), aliceCSRF, http.StatusForbidden},
+		{"unknown token", srv.Token(t, alice), "not-a-token", http.StatusForbidden},
+		{"no token", srv.Token(t, alice), "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("PUT", srv.URL+"/api/v1/users/me/notifications", strings.NewReader("{}"))
+			req.Header.Set("Authorization", "Bearer "+tt.user)
+			if tt.csrf != "" {
+				req.Header.Set("X-CSRF-Token", tt.csrf)
+			}
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			wantStatus(t, resp, tt.want)
+		})
+	}
+}
+
+// With CSRF_EXEMPT_BEARER, Auth marks a Bearer token as such, so the write
+// needs no X-CSRF-Token; without credentials it is still 401.
+func TestCSRFExemptBearer(t *testing.T) {
+	srv := raijintest.NewServer(t, raijintest.WithConfig(func(cfg *config.Config) { cfg.CSRFExemptBearer = true }))
+	alice := srv.CreateUser(t, "alice@example.com", raijintest.Password, "user")
+	for _, tt := range []struct {
+		name, token string
+		want        int
+	}{
+		{"Bearer, no CSRF token", srv.Token(t, alice), http.StatusOK},
+		{"no credentials", "", http.StatusUnauthorized},
+	} {
+		client := srv.Client()
+		if tt.token != "" {
+			client = &http.Client{Transport: bearer{tt.token}}
+		}
+		if resp := send(t, client, "PUT", srv.URL+"/api/v1/users/me/notifications", map[string]any{}, nil); resp.StatusCode != tt.want {
+			t.Errorf("%s: %d, want %d", tt.name, resp.StatusCode, tt.want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package httpapi
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/auth"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+)
+
+// DeleteCurrentUser schedules the caller's account for deletion in
+// ACCOUNT_DELETION_GRACE. Its sessions are revoked at once and it cannot
+// log in until CancelDeletion restores it; once the grace period is over
+// the AccountPurger deletes it. It needs a recent login (RequireFreshAuth).
+func (h *Handlers) DeleteCurrentUser(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(ctxUserID).(string)
+	user, err := h.store.UpdateUser(userID, func(u *User) { u.DeleteAfter = auth.Now().Add(h.cfg.DeletionGrace).UTC() })
+	if err != nil {
+		writeUserError(w, r, err)
+		return
+	}
+	h.revokeAllCredentials(user.ID)
+	DeletionScheduled.Publish(eventContext(r), h.events, UserEvent{User: *user})
+	SessionRevoked.Publish(eventContext(r), h.events, SessionEvent{UserID: user.ID, Reason: "deletion"})
+	respond(w, r, http.StatusAccepted, user)
+}
+
+// CancelDeletion restores an account pending deletion, within its grace
+// period, and logs it in. It takes the same body as Login, and failed
+// attempts count against the same limits.
+func (h *Handlers) CancelDeletion(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.checkCredentials(w, r)
+	if !ok {
+		return
+	}
+	if user.Suspended {
+		LoginFailed.Publish(eventContext(r), h.events, AuthFailureEvent{UserID: user.ID, Email: user.Email, Reason: "suspended"})
+		writeErrorCode(w, r, http.StatusForbidden, api.ErrCodeAccountSuspended, "account suspended")
+		return
+	}
+	restored := false
+	user, err := h.store.UpdateUser(user.ID, func(u *User) {
+		if u.PendingDeletion() && auth.Now().Before(u.DeleteAfter) {
+			u.DeleteAfter, restored = time.Time{}, true
+		}
+	})
+	if err != nil {
+		writeUserError(w, r, err)
+		return
+	}
+	if !restored {
+		writeErrorCode(w, r, http.StatusConflict, api.ErrCodeInvalidRequest, "account is not pending deletion")
+		return
+	}
+	DeletionCancelled.Publish(eventContext(r), h.events, UserEvent{User: *user})
+	LoggedIn.Publish(eventContext(r), h.events, UserEvent{User: *user})
+	h.noteDevice(r, user, true)
+	if err := h.respondAuth(w, r, http.StatusOK, user, nil); err != nil {
+		writeIssueError(w, r, err)
+	}
+}
+
+// AccountPurger deletes the accounts whose deletion grace period is over,
+// every ACCOUNT_PURGE_INTERVAL, and publishes UserDeleted for each.
+// Store.PurgeUsers is atomic, so every replica can run one: each account
+// is deleted, and announced, once.
+type AccountPurger struct {
+	store  store.Store
+	events *EventBus
+
+	done     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+func NewAccountPurger(st store.Store, events *EventBus) *AccountPurger {
+	return &AccountPurger{store: st, events: events, done: make(chan struct{})}
+}
+
+// Start purges every interval until Stop.
+func (p *AccountPurger) Start(interval time.Duration) {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.Purge()
+			case <-p.done:
+				return
+			}
+		}
+	}()
+}
+
+// Purge deletes the accounts due now and returns how many there were.
+func (p *AccountPurger) Purge() int {
+	users := p.store.PurgeUsers(auth.Now())
+	for _, u := range users {
+		log.Printf("Deleted account %s (deletion requested, grace period over)", u.ID)
+		UserDeleted.Publish(context.Background(), p.events, UserEvent{User: *u})
+	}
+	return len(users)
+}
+
+// Stop ends the purge loop, waiting for a purge in progress.
+func (p *AccountPurger) Stop() {
+	p.stopOnce.Do(func() { close(p.done) })
+	p.wg.Wait()
+}
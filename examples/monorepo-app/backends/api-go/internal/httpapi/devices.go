@@ -0,0 +1,92 @@
+package httpapi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/auth"
+)
+
+// A device is identified by its browser and OS family plus the client's
+// network (/24 for IPv4, /48 for IPv6): browser updates and DHCP leases do
+// not make it new, another browser or another ISP does.
+var (
+	deviceBrowsers = [][2]string{ // checked in order: Chrome UAs also say Safari, Edge's say Chrome
+		{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"}, {"Chrome/", "Chrome"},
+		{"Safari/", "Safari"}, {"raijinctl", "raijinctl"}, {"curl/", "curl"}, {"Go-http-client", "Go"},
+	}
+	deviceSystems = [][2]string{ // Android UAs also say Linux, iOS ones "like Mac OS X"
+		{"Android", "Android"}, {"iPhone", "iOS"}, {"iPad", "iOS"}, {"Windows", "Windows"},
+		{"Mac OS X", "macOS"}, {"CrOS", "ChromeOS"}, {"Linux", "Linux"},
+	}
+)
+
+// deviceName describes a User-Agent as "Browser on OS", as far as it can
+// tell.
+func deviceName(ua string) string {
+	find := func(families [][2]string) string {
+		for _, f := range families {
+			if strings.Contains(ua, f[0]) {
+				return f[1]
+			}
+		}
+		return ""
+	}
+	browser, system := find(deviceBrowsers), find(deviceSystems)
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return "Unknown browser on " + system
+	case ua != "":
+		if len(ua) > 60 {
+			ua = ua[:60] + "…"
+		}
+		return ua
+	}
+	return "Unknown device"
+}
+
+// deviceFingerprint hashes the device name with the network of ip.
+func deviceFingerprint(device, ip string) string {
+	network := ip
+	if addr := net.ParseIP(ip); addr != nil {
+		if v4 := addr.To4(); v4 != nil {
+			network = v4.Mask(net.CIDRMask(24, 32)).String()
+		} else {
+			network = addr.Mask(net.CIDRMask(48, 128)).String()
+		}
+	}
+	sum := sha256.Sum256([]byte(device + "|" + network))
+	return hex.EncodeToString(sum[:16])
+}
+
+// noteDevice remembers the device r comes from as one of user's. For a
+// login (alert) from a device the account has not used before it publishes
+// NewDeviceLogin and emails the user; the first device an account is seen
+// on is never reported. NEW_DEVICE_ALERTS=false turns it off.
+func (h *Handlers) noteDevice(r *http.Request, user *User, alert bool) {
+	if !h.cfg.NewDeviceAlerts {
+		return
+	}
+	ip, device := clientIP(r), deviceName(r.UserAgent())
+	fingerprint := deviceFingerprint(device, ip)
+	now := auth.Now()
+	isNew, known := h.store.RememberDevice(user.ID, fingerprint, now)
+	if !alert || !isNew || known == 0 {
+		return
+	}
+	NewDeviceLogin.Publish(eventContext(r), h.events, DeviceEvent{User: *user, Device: device, Fingerprint: fingerprint})
+	h.sendEmail(r.Context(), user.Email, messages.Match(r.Header.Get("Accept-Language")), NewDeviceEmail{
+		Name: user.Name, Device: device, IP: ip, At: now,
+		ResetLink:       h.cfg.AppURL + "/reset-password",
+		RevokeLink:      h.cfg.AppURL + "/account/sessions",
+		UnsubscribeLink: h.unsubscribeLink(user.ID, api.NotifyNewDevice),
+	})
+}
@@ -0,0 +1,162 @@
+package httpapi
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+)
+
+//go:embed disposable_domains.txt
+var disposableBaseline string
+
+// disposableFetchTimeout bounds one download of DISPOSABLE_EMAIL_LIST_URL.
+const disposableFetchTimeout = 30 * time.Second
+
+// DisposableDomains knows the domains of disposable (throwaway) mailbox
+// providers: an embedded baseline, plus the list at
+// DISPOSABLE_EMAIL_LIST_URL when set, fetched again every
+// DISPOSABLE_EMAIL_REFRESH and swapped in atomically. A failed download
+// keeps the domains already known, so the baseline always applies.
+type DisposableDomains struct {
+	url      string
+	client   *http.Client
+	baseline map[string]struct{}
+	domains  atomic.Pointer[map[string]struct{}]
+	loaded   chan struct{} // closed once Start's first download is over
+	loadErr  error         // its error
+
+	ctx    context.Context // canceled by Stop
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewDisposableDomains returns the DISPOSABLE_EMAIL_ACTION detector with
+// the embedded list loaded, or nil when it is off.
+func NewDisposableDomains(cfg *config.Config, out *Outbound) *DisposableDomains {
+	if !cfg.Disposable.Enabled() {
+		return nil
+	}
+	d := &DisposableDomains{
+		url: cfg.Disposable.ListURL, client: out.Client("disposable_email", disposableFetchTimeout),
+		baseline: parseDomainList(disposableBaseline),
+		loaded:   make(chan struct{}),
+	}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	d.domains.Store(&d.baseline)
+	return d
+}
+
+// parseDomainList reads one domain per line, in the form emailDomain
+// gives, skipping blank lines and "#" comments.
+func parseDomainList(text string) map[string]struct{} {
+	domains := make(map[string]struct{})
+	for line := range strings.Lines(text) {
+		if line, _, _ = strings.Cut(line, "#"); strings.TrimSpace(line) != "" {
+			domains[domainToASCII(strings.TrimSpace(line))] = struct{}{}
+		}
+	}
+	return domains
+}
+
+// Contains reports whether domain, as returned by emailDomain, or one of
+// its parent domains is disposable.
+func (d *DisposableDomains) Contains(domain string) bool {
+	domains := *d.domains.Load()
+	for domain != "" {
+		if _, ok := domains[domain]; ok {
+			return true
+		}
+		_, domain, _ = strings.Cut(domain, ".")
+	}
+	return false
+}
+
+// Refresh downloads the list at DISPOSABLE_EMAIL_LIST_URL and swaps it in,
+// merged with the baseline. On error the current domains stay.
+func (d *DisposableDomains) Refresh(ctx context.Context) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("list URL answered %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return 0, err
+	}
+	domains := parseDomainList(string(data))
+	if len(domains) == 0 {
+		return 0, errors.New("list is empty")
+	}
+	for domain := range d.baseline {
+		domains[domain] = struct{}{}
+	}
+	d.domains.Store(&domains)
+	return len(domains), nil
+}
+
+// Start refreshes the list now and then every interval until Stop. It
+// does nothing without DISPOSABLE_EMAIL_LIST_URL.
+func (d *DisposableDomains) Start(interval time.Duration) {
+	if d.url == "" {
+		close(d.loaded)
+		return
+	}
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		first := true
+		for {
+			n, err := d.Refresh(d.ctx)
+			if err != nil && d.ctx.Err() == nil {
+				log.Printf("WARN disposable email: refresh from %s: %v; keeping the current list", d.url, err)
+			} else if err == nil {
+				log.Printf("Disposable email: loaded %d domains", n)
+			}
+			if first {
+				d.loadErr, first = err, false
+				close(d.loaded)
+			}
+			select {
+			case <-ticker.C:
+			case <-d.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Loaded waits for Start's first download of the list and returns its
+// error, for the warmup.
+func (d *DisposableDomains) Loaded(ctx context.Context) error {
+	select {
+	case <-d.loaded:
+		return d.loadErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stop ends the refresh loop, abandoning a download in progress.
+func (d *DisposableDomains) Stop() {
+	d.cancel()
+	d.wg.Wait()
+}
@@ -0,0 +1,185 @@
+// API explorer for /openapi.json. Plain DOM, no inline handlers: the page is
+// served under the API's Content-Security-Policy (script-src 'self').
+"use strict";
+
+const auth = {
+  get bearer() { return sessionStorage.getItem("bearer") || ""; },
+  set bearer(v) { sessionStorage.setItem("bearer", v); },
+  get csrf() { return sessionStorage.getItem("csrf") || ""; },
+  set csrf(v) { sessionStorage.setItem("csrf", v); },
+};
+
+let spec;
+
+function el(tag, attrs = {}, ...children) {
+  const e = document.createElement(tag);
+  for (const [k, v] of Object.entries(attrs)) {
+    if (k === "class") e.className = v;
+    else e.setAttribute(k, v);
+  }
+  e.append(...children.filter((c) => c != null));
+  return e;
+}
+
+function resolve(schema) {
+  while (schema && schema.$ref) {
+    schema = spec.components.schemas[schema.$ref.split("/").pop()];
+  }
+  return schema || {};
+}
+
+// example builds a sample value for a request body schema.
+function example(schema, name = "", depth = 0) {
+  schema = resolve(schema);
+  if (depth > 4) return null;
+  switch (schema.type) {
+    case "object": {
+      const out = {};
+      for (const [k, s] of Object.entries(schema.properties || {})) out[k] = example(s, k, depth + 1);
+      return out;
+    }
+    case "array": return [];
+    case "integer": case "number": return 0;
+    case "boolean": return false;
+    case "string":
+      if (schema.format === "date-time") return new Date().toISOString();
+      if (name === "email") return "user@example.com";
+      if (name === "url") return "https://example.com/hook";
+      return "";
+    default: return null;
+  }
+}
+
+function schemaName(content) {
+  const s = content && (content["application/json"] || Object.values(content)[0]);
+  if (!s) return "";
+  return s.schema.$ref ? s.schema.$ref.split("/").pop() : s.schema.type || "";
+}
+
+function refreshAuthButton() {
+  document.getElementById("authorize").classList.toggle("active", auth.bearer !== "");
+}
+
+function renderOperation(path, method, op) {
+  const secured = (op.security || []).length > 0;
+  const needsCSRF = secured && op.security.some((s) => "csrfToken" in s);
+  const params = op.parameters || [];
+  const inputs = {};
+  const body = el("div", { class: "body" });
+
+  if (op.summary) body.append(el("p", {}, op.summary));
+  for (const p of params) {
+    const input = el("input", { placeholder: p.description || p.schema.type, spellcheck: "false" });
+    inputs[p.in + ":" + p.name] = input;
+    body.append(el("label", {}, `${p.name} (${p.in}${p.required ? ", required" : ""})`, input));
+  }
+  let bodyInput;
+  if (op.requestBody) {
+    const schema = op.requestBody.content["application/json"].schema;
+    bodyInput = el("textarea", { rows: "8", spellcheck: "false" });
+    bodyInput.value = JSON.stringify(example(schema), null, 2);
+    body.append(el("label", {}, `Request body (${schemaName(op.requestBody.content)})`, bodyInput));
+  }
+
+  const rows = Object.entries(op.responses).map(([status, r]) =>
+    el("tr", {}, el("td", {}, status), el("td", {}, r.description), el("td", {}, schemaName(r.content))));
+  body.append(el("table", { class: "responses" }, ...rows));
+
+  const result = el("pre", { class: "result", hidden: "" });
+  const send = el("button", { type: "button" }, "Send");
+  send.addEventListener("click", async () => {
+    let url = path;
+    const query = new URLSearchParams();
+    const headers = {};
+    for (const p of params) {
+      const v = inputs[p.in + ":" + p.name].value;
+      if (v === "") continue;
+      if (p.in === "path") url = url.replace(`{${p.name}}`, encodeURIComponent(v));
+      else if (p.in === "query") query.set(p.name, v);
+      else if (p.in === "header") headers[p.name] = v;
+    }
+    if (query.toString()) url += "?" + query;
+    if (secured && auth.bearer) headers["Authorization"] = "Bearer " + auth.bearer;
+    if (needsCSRF && auth.csrf) headers["X-CSRF-Token"] = auth.csrf;
+    const init = { method: method.toUpperCase(), headers };
+    if (bodyInput) {
+      headers["Content-Type"] = "application/json";
+      init.body = bodyInput.value;
+    }
+
+    result.hidden = false;
+    result.textContent = `${init.method} ${url} …`;
+    try {
+      const res = await fetch(url, init);
+      const text = await res.text();
+      let shown = text;
+      try {
+        const json = JSON.parse(text);
+        shown = JSON.stringify(json, null, 2);
+        if (json.access_token) {
+          auth.bearer = json.access_token;
+          if (json.csrf_token) auth.csrf = json.csrf_token;
+          refreshAuthButton();
+        }
+      } catch (_) { /* not JSON */ }
+      const hdrs = [...res.headers].map(([k, v]) => `${k}: ${v}`).join("\n");
+      result.textContent = `${res.status} ${res.statusText}\n${hdrs}\n\n${shown}`;
+    } catch (err) {
+      result.textContent = String(err);
+    }
+  });
+  body.append(send, result);
+
+  return el("details", { class: "op" },
+    el("summary", {},
+      el("span", { class: "method " + method }, method.toUpperCase()),
+      el("span", { class: "path" }, path),
+      el("span", { class: "summary" }, op.summary || ""),
+      secured ? el("span", { class: "lock" }, needsCSRF ? "bearer + csrf" : "bearer") : null),
+    body);
+}
+
+function render() {
+  document.getElementById("title").textContent = spec.info.title;
+  document.getElementById("version").textContent = "v" + spec.info.version + " · OpenAPI " + spec.openapi;
+  const byTag = new Map();
+  for (const [path, item] of Object.entries(spec.paths).sort()) {
+    for (const [method, op] of Object.entries(item)) {
+      const tag = (op.tags || ["other"])[0];
+      if (!byTag.has(tag)) byTag.set(tag, []);
+      byTag.get(tag).push(renderOperation(path, method, op));
+    }
+  }
+  const main = document.getElementById("operations");
+  main.replaceChildren();
+  for (const [tag, ops] of byTag) main.append(el("h2", { class: "tag" }, tag), ...ops);
+}
+
+function setupAuth() {
+  const dialog = document.getElementById("auth-dialog");
+  const bearer = document.getElementById("bearer");
+  const csrf = document.getElementById("csrf");
+  document.getElementById("authorize").addEventListener("click", () => {
+    bearer.value = auth.bearer;
+    csrf.value = auth.csrf;
+    dialog.showModal();
+  });
+  document.getElementById("auth-clear").addEventListener("click", () => {
+    bearer.value = csrf.value = "";
+  });
+  dialog.addEventListener("close", () => {
+    auth.bearer = bearer.value.trim().replace(/^Bearer\s+/i, "");
+    auth.csrf = csrf.value.trim();
+    refreshAuthButton();
+  });
+  refreshAuthButton();
+}
+
+setupAuth();
+fetch("/openapi.json")
+  .then((res) => {
+    if (!res.ok) throw new Error(`/openapi.json: ${res.status}`);
+    return res.json();
+  })
+  .then((s) => { spec = s; render(); })
+  .catch((err) => { document.getElementById("operations").textContent = String(err); });
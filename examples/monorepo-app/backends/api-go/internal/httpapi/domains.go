@@ -0,0 +1,220 @@
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+)
+
+// errInvalidEmail is the error of normalizeEmail, wrapped with the reason.
+var errInvalidEmail = errors.New("invalid email address")
+
+// dnsLabel is a label of a host name in ASCII (punycode) form.
+var dnsLabel = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// validDomain reports whether domain is a host name with at least two
+// labels and a top-level domain that is not all digits.
+func validDomain(domain string) bool {
+	labels := strings.Split(domainToASCII(domain), ".")
+	if len(labels) < 2 || strings.Trim(labels[len(labels)-1], "0123456789") == "" {
+		return false
+	}
+	for _, l := range labels {
+		if !dnsLabel.MatchString(l) {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeEmail trims email and lowercases its domain, the form accounts
+// are stored, looked up and compared in. The local part keeps its case,
+// and plus-addressing ("ana+news@example.com") is kept as it is: it is a
+// legitimate, distinct address. Beyond net/mail's syntax it requires what
+// deliverable addresses have in practice: a bare address (no display
+// name or comments), a single "@", no whitespace, a host name with a dot
+// (no IP literals), and at most 254 bytes (64 for the local part).
+func normalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	local, domain, _ := cutLast(email, "@")
+	switch {
+	case len(email) > 254:
+		return "", fmt.Errorf("%w: longer than 254 characters", errInvalidEmail)
+	case strings.Count(email, "@") != 1:
+		return "", fmt.Errorf("%w: must contain exactly one @", errInvalidEmail)
+	case strings.ContainsFunc(email, unicode.IsSpace):
+		return "", fmt.Errorf("%w: must not contain spaces", errInvalidEmail)
+	case local == "" || len(local) > 64:
+		return "", fmt.Errorf("%w: the part before @ must be 1 to 64 characters", errInvalidEmail)
+	case strings.HasSuffix(domain, ".") || !validDomain(domain):
+		return "", fmt.Errorf("%w: %q is not a domain", errInvalidEmail, domain)
+	}
+	email = local + "@" + strings.ToLower(domain)
+	if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+		return "", fmt.Errorf("%w: not an address", errInvalidEmail)
+	}
+	return email, nil
+}
+
+// checkEmail returns email normalized, or answers r with 400
+// invalid_email_format and returns false when it is not an address.
+func checkEmail(w http.ResponseWriter, r *http.Request, email string) (string, bool) {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		writeErrorFields(w, r, http.StatusBadRequest, api.ErrCodeInvalidEmail, err.Error(),
+			[]FieldError{{Field: "email", Message: "is not a valid email address"}})
+	}
+	return email, err == nil
+}
+
+// emailDomain returns the ASCII form of email's domain, the part after
+// its last "@": lowercased, without a trailing dot, IDN labels converted
+// to punycode. The local part (plus-addressing included) plays no part.
+func emailDomain(email string) string {
+	_, domain, _ := cutLast(strings.TrimSpace(email), "@")
+	return domainToASCII(domain)
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// registrationDomainAllowed applies REGISTRATION_ALLOWED_DOMAINS and
+// REGISTRATION_BLOCKED_DOMAINS to email. A blocked domain is refused even
+// when it is also allowed.
+func (h *Handlers) registrationDomainAllowed(email string) bool {
+	domain := emailDomain(email)
+	if domainListed(h.cfg.Domains.Blocked, domain) {
+		return false
+	}
+	return len(h.cfg.Domains.Allowed) == 0 || domainListed(h.cfg.Domains.Allowed, domain)
+}
+
+// domainListed reports whether domain, in ASCII form, matches an entry of
+// list: "example.com" only itself, "*.example.com" any of its subdomains
+// but not example.com.
+func domainListed(list []string, domain string) bool {
+	if domain == "" {
+		return false
+	}
+	for _, entry := range list {
+		if parent, ok := strings.CutPrefix(entry, "*."); ok {
+			if strings.HasSuffix(domain, "."+domainToASCII(parent)) {
+				return true
+			}
+		} else if domain == domainToASCII(entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// domainToASCII lowercases domain, drops a trailing dot and converts each
+// label with non-ASCII characters to its punycode "xn--" form (RFC 3492),
+// so "Bücher.example" and "xn--bcher-kva.example" compare equal. Unicode
+// normalization is not applied; labels are expected in NFC.
+func domainToASCII(domain string) string {
+	labels := strings.Split(strings.TrimSuffix(strings.ToLower(domain), "."), ".")
+	for i, label := range labels {
+		for _, r := range label {
+			if r >= 0x80 {
+				labels[i] = "xn--" + punycode(label)
+				break
+			}
+		}
+	}
+	return strings.Join(labels, ".")
+}
+
+// Punycode parameters (RFC 3492 section 5).
+const (
+	punyBase        = 36
+	punyTMin        = 1
+	punyTMax        = 26
+	punySkew        = 38
+	punyDamp        = 700
+	punyInitialBias = 72
+	punyInitialN    = 128
+)
+
+// punycode encodes label per RFC 3492, without the "xn--" prefix.
+func punycode(label string) string {
+	runes := []rune(label)
+	var out []byte
+	for _, r := range runes {
+		if r < 0x80 {
+			out = append(out, byte(r))
+		}
+	}
+	basic := len(out)
+	if basic > 0 {
+		out = append(out, '-')
+	}
+	n, delta, bias := rune(punyInitialN), 0, punyInitialBias
+	for handled := basic; handled < len(runes); {
+		m := rune(math.MaxInt32)
+		for _, r := range runes {
+			if r >= n && r < m {
+				m = r
+			}
+		}
+		delta += int(m-n) * (handled + 1)
+		n = m
+		for _, r := range runes {
+			if r < n {
+				delta++
+			}
+			if r != n {
+				continue
+			}
+			q := delta
+			for k := punyBase; ; k += punyBase {
+				t := min(max(k-bias, punyTMin), punyTMax)
+				if q < t {
+					break
+				}
+				out = append(out, punyDigit(t+(q-t)%(punyBase-t)))
+				q = (q - t) / (punyBase - t)
+			}
+			out = append(out, punyDigit(q))
+			bias = punyAdapt(delta, handled+1, handled == basic)
+			delta = 0
+			handled++
+		}
+		delta++
+		n++
+	}
+	return string(out)
+}
+
+func punyDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+func punyAdapt(delta, points int, first bool) int {
+	if first {
+		delta /= punyDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / points
+	k := 0
+	for delta > (punyBase-punyTMin)*punyTMax/2 {
+		delta /= punyBase - punyTMin
+		k += punyBase
+	}
+	return k + (punyBase-punyTMin+1)*delta/(delta+punySkew)
+}
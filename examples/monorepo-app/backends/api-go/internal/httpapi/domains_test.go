@@ -0,0 +1,232 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	long63 := strings.Repeat("a", 63)
+	domain189 := long63 + "." + long63 + "." + strings.Repeat("c", 57) + ".com"
+	valid := map[string]string{
+		"ana@example.com":                         "ana@example.com",
+		"  Ana@Example.COM \n":                    "Ana@example.com", // the local part keeps its case
+		"\tana@example.com":                       "ana@example.com",
+		"ana+news@example.com":                    "ana+news@example.com", // plus-addressing is kept
+		"ana+@example.com":                        "ana+@example.com",
+		"first.last@sub.example.co.uk":            "first.last@sub.example.co.uk",
+		"o'brien@example.ie":                      "o'brien@example.ie",
+		"user_name-1@example-mail.com":            "user_name-1@example-mail.com",
+		"a@b.io":                                  "a@b.io",
+		"1234@example.com":                        "1234@example.com",
+		"ana@123.example":                         "ana@123.example",
+		"ana@xn--bcher-kva.example":               "ana@xn--bcher-kva.example",
+		"ana@BÜCHER.example":                      "ana@bücher.example",
+		"ana@example.xn--p1ai":                    "ana@example.xn--p1ai",
+		strings.Repeat("a", 64) + "@x.io":         strings.Repeat("a", 64) + "@x.io",
+		strings.Repeat("b", 64) + "@" + domain189: strings.Repeat("b", 64) + "@" + domain189, // 254 bytes
+	}
+	for in, want := range valid {
+		if got, err := normalizeEmail(in); err != nil || got != want {
+			t.Errorf("normalizeEmail(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+
+	for _, in := range []string{
+		"",
+		"   ",
+		"foo",
+		"example.com",
+		"@example.com",
+		"ana@",
+		"ana@@example.com",
+		"ana@b@example.com",
+		`"ana@b"@example.com`,
+		"ana @example.com",
+		"ana@exa mple.com",
+		"ana\t@example.com",
+		"ana@localhost",
+		"ana@example",
+		"ana@example.123",
+		"ana@127.0.0.1",
+		"ana@[127.0.0.1]",
+		"ana@example.com.",
+		"ana@.example.com",
+		"ana@example..com",
+		"ana@-example.com",
+		"ana@example-.com",
+		"ana@exa_mple.com",
+		"ana@" + strings.Repeat("a", 64) + ".com",
+		".ana@example.com",
+		"ana.@example.com",
+		"ana..b@example.com",
+		"ana(comment)@example.com",
+		"Ana <ana@example.com>",
+		"<ana@example.com>",
+		"ana@example.com, bo@example.com",
+		strings.Repeat("a", 65) + "@x.io",
+		strings.Repeat("b", 64) + "@x" + domain189, // 255 bytes
+	} {
+		if got, err := normalizeEmail(in); !errors.Is(err, errInvalidEmail) {
+			t.Errorf("normalizeEmail(%q) = %q, %v; want invalid", in, got, err)
+		}
+	}
+}
+
+// Registration and login answer a malformed address with 400
+// invalid_email_format on the email field, and agree on the normalized
+// form.
+func TestEmailFormat(t *testing.T) {
+	_, ts := openAPIServer(t, store.NewMemory())
+	post := func(path string, body any) (*http.Response, APIError) {
+		t.Helper()
+		data, _ := json.Marshal(body)
+		resp, err := http.Post(ts.URL+path, "application/json", strings.NewReader(string(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var e APIError
+		json.NewDecoder(resp.Body).Decode(&e)
+		return resp, e
+	}
+
+	for _, path := range []string{"/api/v1/auth/register", "/api/v1/auth/login"} {
+		resp, e := post(path, api.RegisterRequest{Email: "foo", Name: "Foo", Password: "format-password"})
+		if resp.StatusCode != http.StatusBadRequest || e.ErrorCode != api.ErrCodeInvalidEmail || len(e.Fields) != 1 || e.Fields[0].Field != "email" {
+			t.Errorf("%s foo: %d %+v", path, resp.StatusCode, e)
+		}
+	}
+
+	if resp, e := post("/api/v1/auth/register", api.RegisterRequest{Email: " Ana+Reg@Example.COM ", Name: "Ana", Password: "format-password"}); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("register: %d %+v", resp.StatusCode, e)
+	}
+	if resp, e := post("/api/v1/auth/register", api.RegisterRequest{Email: "Ana+Reg@example.com", Name: "Ana", Password: "format-password"}); resp.StatusCode != http.StatusConflict {
+		t.Errorf("the same address again: %d %+v", resp.StatusCode, e)
+	}
+	if resp, e := post("/api/v1/auth/login", api.LoginRequest{Email: "Ana+Reg@EXAMPLE.com\n", Password: "format-password"}); resp.StatusCode != http.StatusOK {
+		t.Errorf("login with another spelling: %d %+v", resp.StatusCode, e)
+	}
+}
+
+func TestDomainToASCII(t *testing.T) {
+	for domain, want := range map[string]string{
+		"example.com":      "example.com",
+		"Example.COM.":     "example.com",
+		"bücher.example":   "xn--bcher-kva.example",
+		"BÜCHER.example":   "xn--bcher-kva.example",
+		"münchen.de":       "xn--mnchen-3ya.de",
+		"méxico.com":       "xn--mxico-bsa.com",
+		"日本語.jp":           "xn--wgv71a119e.jp",
+		"ñ.example":        "xn--ida.example",
+		"mail.bücher.test": "mail.xn--bcher-kva.test",
+	} {
+		if got := domainToASCII(domain); got != want {
+			t.Errorf("domainToASCII(%q) = %q, want %q", domain, got, want)
+		}
+	}
+}
+
+func TestRegistrationDomainAllowed(t *testing.T) {
+	tests := []struct {
+		name             string
+		allowed, blocked []string
+		email            string
+		want             bool
+	}{
+		{"no lists", nil, nil, "a@anything.example", true},
+		{"allowed", []string{"ourcompany.com"}, nil, "a@ourcompany.com", true},
+		{"allowed, case", []string{"ourcompany.com"}, nil, "a@OurCompany.COM", true},
+		{"allowed, trailing dot", []string{"ourcompany.com"}, nil, "a@ourcompany.com.", true},
+		{"not allowed", []string{"ourcompany.com"}, nil, "a@other.com", false},
+		{"lookalike suffix", []string{"ourcompany.com"}, nil, "a@evilourcompany.com", false},
+		{"plain entry is not a wildcard", []string{"ourcompany.com"}, nil, "a@eu.ourcompany.com", false},
+		{"wildcard subdomain", []string{"*.ourcompany.com"}, nil, "a@eu.ourcompany.com", true},
+		{"wildcard deep subdomain", []string{"*.ourcompany.com"}, nil, "a@mail.eu.ourcompany.com", true},
+		{"wildcard excludes the parent", []string{"*.ourcompany.com"}, nil, "a@ourcompany.com", false},
+		{"plus-addressing", []string{"ourcompany.com"}, nil, "a+signup@ourcompany.com", true},
+		{"domain in the local part", []string{"ourcompany.com"}, nil, `"a@ourcompany.com"@other.com`, false},
+		{"IDN entry, Unicode email", []string{"bücher.example"}, nil, "a@BÜCHER.example", true},
+		{"IDN entry, punycode email", []string{"bücher.example"}, nil, "a@xn--bcher-kva.example", true},
+		{"punycode entry, Unicode email", []string{"xn--bcher-kva.example"}, nil, "a@bücher.example", true},
+		{"IDN wildcard", []string{"*.bücher.example"}, nil, "a@shop.bücher.example", true},
+		{"blocked", nil, []string{"spam.example"}, "a@spam.example", false},
+		{"blocked wildcard", nil, []string{"*.spam.example"}, "a@x.spam.example", false},
+		{"blocked wins over allowed", []string{"*.ourcompany.com"}, []string{"contractors.ourcompany.com"}, "a@contractors.ourcompany.com", false},
+		{"blocked elsewhere", nil, []string{"spam.example"}, "a+spam.example@fine.example", true},
+	}
+	for _, tt := range tests {
+		h := &Handlers{cfg: &config.Config{Domains: config.DomainsConfig{Allowed: tt.allowed, Blocked: tt.blocked}}}
+		if got := h.registrationDomainAllowed(tt.email); got != tt.want {
+			t.Errorf("%s: %s allowed = %v, want %v", tt.name, tt.email, got, tt.want)
+		}
+	}
+}
+
+// Register enforces the lists with email_domain_not_allowed; admins
+// create users in any domain.
+func TestRegistrationDomains(t *testing.T) {
+	_, ts := openAPIServer(t, store.NewMemory(), func(cfg *config.Config) {
+		cfg.Domains = config.DomainsConfig{Allowed: []string{"*.ourcompany.com", "ourcompany.com"}, Blocked: []string{"interns.ourcompany.com"}}
+	})
+	var admin AuthResponse
+	post := func(path string, body any) (int, string) {
+		t.Helper()
+		data, _ := json.Marshal(body)
+		req, _ := http.NewRequest("POST", ts.URL+path, strings.NewReader(string(data)))
+		req.Header.Set("Content-Type", "application/json")
+		if admin.AccessToken != "" {
+			req.Header.Set("Authorization", "Bearer "+admin.AccessToken)
+			req.Header.Set("X-CSRF-Token", admin.CSRFToken)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var e APIError
+		json.NewDecoder(resp.Body).Decode(&e)
+		return resp.StatusCode, e.ErrorCode
+	}
+	login := func() {
+		data, _ := json.Marshal(api.LoginRequest{Email: "admin@example.com", Password: "admin123"})
+		resp, err := http.Post(ts.URL+"/api/v1/auth/login", "application/json", strings.NewReader(string(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if err := json.NewDecoder(resp.Body).Decode(&admin); err != nil || admin.CSRFToken == "" {
+			t.Fatalf("admin login: %d, %v", resp.StatusCode, err)
+		}
+	}
+
+	for email, want := range map[string]int{
+		"ana@OurCompany.com":             http.StatusCreated,
+		"bo+test@eu.ourcompany.com":      http.StatusCreated,
+		"cy@gmail.com":                   http.StatusForbidden,
+		"di@interns.ourcompany.com":      http.StatusForbidden,
+		"ed@ourcompany.com.evil.com":     http.StatusForbidden,
+		"not-an-address@ourcompany.com@": http.StatusBadRequest,
+	} {
+		status, code := post("/api/v1/auth/register", api.RegisterRequest{Email: email, Name: "N", Password: "domain-password"})
+		if status != want {
+			t.Errorf("register %s: %d %s, want %d", email, status, code, want)
+		}
+		if want == http.StatusForbidden && code != api.ErrCodeEmailDomain {
+			t.Errorf("register %s: error code %s", email, code)
+		}
+	}
+
+	login()
+	if status, code := post("/api/v1/admin/users",
+		api.CreateUserRequest{Email: "vendor@gmail.com", Name: "Vendor", Password: "domain-password", Role: "user"}); status != http.StatusCreated {
+		t.Errorf("admin-created user outside the allowlist: %d %s", status, code)
+	}
+}
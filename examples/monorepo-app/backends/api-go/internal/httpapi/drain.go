@@ -0,0 +1,85 @@
+package httpapi
+
+import (
+	"expvar"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+)
+
+var inFlightGauge = new(expvar.Int)
+
+func init() { expvar.Publish("http_in_flight", inFlightGauge) }
+
+// Drain counts in-flight requests and, once Start is called at shutdown,
+// fails /ready at once and rejects other new requests after grace.
+type Drain struct {
+	grace    time.Duration
+	inFlight atomic.Int64
+	started  atomic.Int64 // unix nanos when draining began; 0 if serving
+}
+
+func NewDrain(grace time.Duration) *Drain { return &Drain{grace: grace} }
+
+// Start enters drain mode and reports whether this call did. It is safe
+// to call more than once.
+func (d *Drain) Start() bool { return d.started.CompareAndSwap(0, time.Now().UnixNano()) }
+
+func (d *Drain) Draining() bool { return d.started.Load() != 0 }
+
+// Elapsed is how long the server has been draining; 0 if serving.
+func (d *Drain) Elapsed() time.Duration {
+	started := d.started.Load()
+	if started == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, started))
+}
+
+// PreStop serves POST /internal/drain for a Kubernetes preStop hook: it
+// starts draining, then holds the request until delay has passed since
+// draining began, so by the time the SIGTERM that follows arrives the load
+// balancers have stopped routing here. The shutdown then skips the delay.
+func (d *Drain) PreStop(delay time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d.Start() {
+			log.Printf("Draining on request (delay %v)...", delay)
+		}
+		t := time.NewTimer(delay - d.Elapsed())
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-r.Context().Done():
+			return
+		}
+		writeJSON(w, http.StatusOK, ReadyResponse{Status: "draining"})
+	})
+}
+
+func (d *Drain) InFlight() int64 { return d.inFlight.Load() }
+
+func (d *Drain) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if started := d.started.Load(); started != 0 {
+			if r.URL.Path == "/ready" {
+				writeJSON(w, http.StatusServiceUnavailable, ReadyResponse{Status: "draining"})
+				return
+			}
+			if time.Since(time.Unix(0, started)) > d.grace {
+				w.Header().Set("Connection", "close")
+				writeErrorCode(w, r, http.StatusServiceUnavailable, api.ErrCodeShuttingDown, "server is shutting down")
+				return
+			}
+		}
+		d.inFlight.Add(1)
+		inFlightGauge.Add(1)
+		defer func() {
+			d.inFlight.Add(-1)
+			inFlightGauge.Add(-1)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
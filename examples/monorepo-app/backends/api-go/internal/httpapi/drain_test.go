@@ -0,0 +1,86 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+)
+
+// POST /internal/drain fails /ready at once and holds the hook for
+// DRAIN_DELAY while the public listener keeps serving; the SIGTERM that
+// follows finds the delay spent.
+func TestPreStopDrain(t *testing.T) {
+	const delay = 300 * time.Millisecond
+	s, ts := openAPIServer(t, store.NewMemory(), func(cfg *config.Config) {
+		cfg.InternalAddr = "127.0.0.1:0"
+		cfg.DrainDelay = delay
+		cfg.DrainGrace = time.Minute
+	})
+	internal := httptest.NewServer(s.Internal)
+	defer internal.Close()
+
+	if code, body := getHealth(t, ts.URL+"/ready", ""); code != http.StatusOK || body["status"] != "ready" {
+		t.Fatalf("before the hook: %d %v", code, body)
+	}
+
+	start := time.Now()
+	held := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.Post(internal.URL+"/internal/drain", "application/json", nil)
+		if err != nil {
+			t.Error(err)
+		}
+		held <- resp
+	}()
+	for !s.drain.Draining() {
+		time.Sleep(time.Millisecond)
+	}
+
+	if code, body := getHealth(t, ts.URL+"/ready", ""); code != http.StatusServiceUnavailable || body["status"] != "draining" {
+		t.Errorf("/ready while held: %d %v", code, body)
+	}
+	if code, body := getHealth(t, ts.URL+"/health", ""); code != http.StatusOK || body["draining"] != true {
+		t.Errorf("/health while held: %d %v", code, body)
+	}
+	select {
+	case <-held:
+		t.Fatalf("the hook returned after %s, before DRAIN_DELAY", time.Since(start))
+	default:
+	}
+
+	resp := <-held
+	if resp == nil {
+		t.FailNow()
+	}
+	resp.Body.Close()
+	if took := time.Since(start); resp.StatusCode != http.StatusOK || took < delay || took > delay+time.Second {
+		t.Errorf("hook: %d after %s, want 200 after %s", resp.StatusCode, took, delay)
+	}
+	if code, _ := getHealth(t, ts.URL+"/health", ""); code != http.StatusOK {
+		t.Errorf("within DRAIN_GRACE: %d", code)
+	}
+	if since := s.Drain(); since < delay {
+		t.Errorf("Drain after the hook: %s, want at least %s", since, delay)
+	}
+}
+
+// Past DRAIN_GRACE new requests are refused, /ready still says draining.
+func TestDrainGrace(t *testing.T) {
+	s, ts := openAPIServer(t, store.NewMemory(), func(cfg *config.Config) { cfg.DrainGrace = 0 })
+	if since := s.Drain(); since > 100*time.Millisecond {
+		t.Errorf("Drain without the hook: %s ago, want just now", since)
+	}
+	time.Sleep(time.Millisecond)
+	code, body := getHealth(t, ts.URL+"/health", "")
+	if code != http.StatusServiceUnavailable || body["error_code"] != api.ErrCodeShuttingDown {
+		t.Errorf("past the grace: %d %v", code, body)
+	}
+	if code, body := getHealth(t, ts.URL+"/ready", ""); code != http.StatusServiceUnavailable || body["status"] != "draining" {
+		t.Errorf("/ready past the grace: %d %v", code, body)
+	}
+}
@@ -0,0 +1,252 @@
+package httpapi
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Transactional emails are rendered from emails/<lang>/<kind>.txt (required;
+// defines "subject" and is the text body) and <kind>.html (optional; defines
+// "content", wrapped in emails/layout.html). Files in EMAIL_TEMPLATES_DIR,
+// laid out the same way, replace or add to the embedded ones. Every variant
+// is rendered with its sample data at startup, so a broken template stops
+// the server instead of a send.
+//
+//go:embed emails
+var emailFS embed.FS
+
+// EmailData is the data contract of one message type: the template for
+// EmailKind is executed with the value.
+type EmailData interface {
+	EmailKind() string
+}
+
+type VerificationEmail struct {
+	Name      string
+	Link      string
+	ExpiresIn time.Duration
+}
+
+type PasswordResetEmail struct {
+	Name      string
+	Link      string
+	ExpiresIn time.Duration
+}
+
+type NewDeviceEmail struct {
+	Name       string
+	Device     string // the User-Agent, or a description of it
+	IP         string
+	Location   string // approximate, from the IP; empty when unknown
+	At         time.Time
+	ResetLink  string
+	RevokeLink string // where the user signs out other sessions
+	// UnsubscribeLink turns these alerts off without signing in.
+	UnsubscribeLink string
+}
+
+type InviteEmail struct {
+	InviterName string
+	AppName     string
+	Link        string
+	ExpiresIn   time.Duration
+}
+
+func (VerificationEmail) EmailKind() string  { return "verification" }
+func (PasswordResetEmail) EmailKind() string { return "password_reset" }
+func (NewDeviceEmail) EmailKind() string     { return "new_device" }
+func (InviteEmail) EmailKind() string        { return "invite" }
+
+// emailSamples has one value per message type; it defines the known kinds
+// and is what startup validation and the preview render.
+var emailSamples = map[string]EmailData{
+	"verification":   VerificationEmail{Name: "Ada Lovelace", Link: "https://app.example.com/verify?token=sample", ExpiresIn: 24 * time.Hour},
+	"password_reset": PasswordResetEmail{Name: "Ada Lovelace", Link: "https://app.example.com/reset?token=sample", ExpiresIn: 30 * time.Minute},
+	"new_device": NewDeviceEmail{Name: "Ada Lovelace", Device: "Firefox on Linux", IP: "203.0.113.7", Location: "Lisbon, Portugal",
+		At: time.Date(2026, 1, 2, 15, 4, 0, 0, time.UTC), ResetLink: "https://app.example.com/reset-password",
+		RevokeLink: "https://app.example.com/account/sessions", UnsubscribeLink: "https://app.example.com/unsubscribe?token=sample"},
+	"invite": InviteEmail{InviterName: "Grace Hopper", AppName: "Raijin", Link: "https://app.example.com/invite?token=sample", ExpiresIn: 72 * time.Hour},
+}
+
+type emailTemplate struct {
+	text *texttemplate.Template // the body; defines "subject"
+	html *htmltemplate.Template // nil for text-only messages
+}
+
+// EmailTemplates holds the parsed templates by kind and language.
+type EmailTemplates struct {
+	kinds map[string]map[string]*emailTemplate // kind -> lower-cased tag -> template
+	tags  map[string]string                    // lower-cased tag -> canonical tag
+}
+
+// LoadEmailTemplates parses the embedded templates overlaid with dir (if
+// not empty) and renders each with its sample data.
+func LoadEmailTemplates(dir string) (*EmailTemplates, error) {
+	files, err := emailFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	t := &EmailTemplates{kinds: make(map[string]map[string]*emailTemplate), tags: make(map[string]string)}
+	var errs []error
+	for name, src := range files {
+		tag, file, ok := strings.Cut(name, "/")
+		if !ok {
+			continue // layout.html
+		}
+		kind, ext, _ := strings.Cut(file, ".")
+		if _, ok := emailSamples[kind]; !ok {
+			errs = append(errs, fmt.Errorf("%s: no message type %q", name, kind))
+			continue
+		}
+		if ext == "html" {
+			if _, ok := files[tag+"/"+kind+".txt"]; !ok {
+				errs = append(errs, fmt.Errorf("%s: missing the text version %s.txt", name, kind))
+			}
+			continue
+		}
+		tmpl, err := parseEmailTemplate(tag, name, src, files)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if t.kinds[kind] == nil {
+			t.kinds[kind] = make(map[string]*emailTemplate)
+		}
+		t.kinds[kind][strings.ToLower(tag)] = tmpl
+		t.tags[strings.ToLower(tag)] = tag
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	for kind, sample := range emailSamples {
+		if t.kinds[kind][defaultLanguage] == nil {
+			errs = append(errs, fmt.Errorf("%s/%s.txt: missing (the fallback for every language)", defaultLanguage, kind))
+		}
+		for tag := range t.kinds[kind] {
+			if _, err := t.Render(tag, sample); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return t, errors.Join(errs...)
+}
+
+// emailFiles reads the embedded templates, then those in dir over them,
+// keyed by slash-separated path ("en/verification.txt").
+func emailFiles(dir string) (map[string]string, error) {
+	files := make(map[string]string)
+	read := func(fsys fs.FS) error {
+		return fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || (path.Ext(name) != ".txt" && path.Ext(name) != ".html") {
+				return err
+			}
+			data, err := fs.ReadFile(fsys, name)
+			files[name] = string(data)
+			return err
+		})
+	}
+	embedded, err := fs.Sub(emailFS, "emails")
+	if err != nil {
+		panic(err) // the embed pattern guarantees the directory
+	}
+	if err := read(embedded); err != nil {
+		return nil, err
+	}
+	if dir != "" {
+		if err := read(os.DirFS(dir)); err != nil {
+			return nil, fmt.Errorf("EMAIL_TEMPLATES_DIR: %w", err)
+		}
+	}
+	return files, nil
+}
+
+func parseEmailTemplate(tag, name, src string, files map[string]string) (*emailTemplate, error) {
+	text, err := texttemplate.New(name).Option("missingkey=error").Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	if text.Lookup("subject") == nil {
+		return nil, fmt.Errorf("%s: no {{define \"subject\"}}", name)
+	}
+	tmpl := &emailTemplate{text: text}
+	htmlName := strings.TrimSuffix(name, ".txt") + ".html"
+	htmlSrc, ok := files[htmlName]
+	if !ok {
+		return tmpl, nil
+	}
+	layout, ok := files["layout.html"]
+	if !ok {
+		return nil, fmt.Errorf("%s: layout.html is missing", htmlName)
+	}
+	html, err := htmltemplate.New("layout.html").Option("missingkey=error").
+		Funcs(htmltemplate.FuncMap{"lang": func() string { return tag }}).Parse(layout)
+	if err == nil {
+		_, err = html.New(htmlName).Parse(htmlSrc)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if html.Lookup("content") == nil {
+		return nil, fmt.Errorf("%s: no {{define \"content\"}}", htmlName)
+	}
+	tmpl.html = html
+	return tmpl, nil
+}
+
+// Languages returns the tags kind has templates for.
+func (t *EmailTemplates) Languages(kind string) []string {
+	var tags []string
+	for lower := range t.kinds[kind] {
+		tags = append(tags, t.tags[lower])
+	}
+	slices.Sort(tags)
+	return tags
+}
+
+// lookup picks lang's variant of kind: the exact tag, then any with the
+// same base language, then English.
+func (t *EmailTemplates) lookup(kind, lang string) *emailTemplate {
+	variants := t.kinds[kind]
+	lang = strings.ToLower(lang)
+	if tmpl, ok := variants[lang]; ok {
+		return tmpl
+	}
+	base, _, _ := strings.Cut(lang, "-")
+	for tag, tmpl := range variants {
+		if b, _, _ := strings.Cut(tag, "-"); b == base {
+			return tmpl
+		}
+	}
+	return variants[defaultLanguage]
+}
+
+// Render returns data's message in lang, without recipients.
+func (t *EmailTemplates) Render(lang string, data EmailData) (Message, error) {
+	kind := data.EmailKind()
+	tmpl := t.lookup(kind, lang)
+	if tmpl == nil {
+		return Message{}, fmt.Errorf("email %s: no template", kind)
+	}
+	var subject, text, html strings.Builder
+	if err := tmpl.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, err
+	}
+	if err := tmpl.text.Execute(&text, data); err != nil {
+		return Message{}, err
+	}
+	if tmpl.html != nil {
+		if err := tmpl.html.Execute(&html, data); err != nil {
+			return Message{}, err
+		}
+	}
+	return Message{Subject: strings.TrimSpace(subject.String()), Text: text.String(), HTML: html.String()}, nil
+}
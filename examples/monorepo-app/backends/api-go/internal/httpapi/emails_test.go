@@ -0,0 +1,141 @@
+package httpapi
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// goldenLanguages are the languages every email ships in.
+var goldenLanguages = []string{"en", "pt-BR"}
+
+// TestEmailGolden renders every message type with its sample data in each
+// language and compares subject and bodies with
+// testdata/emails/<lang>/<kind>.{txt,html}. Run with -update after
+// changing a template, and review the diff.
+func TestEmailGolden(t *testing.T) {
+	tmpls, err := LoadEmailTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for kind, sample := range emailSamples {
+		if got := tmpls.Languages(kind); !slices.Equal(got, goldenLanguages) {
+			t.Errorf("%s is in %v, the golden files cover %v", kind, got, goldenLanguages)
+		}
+		for _, lang := range goldenLanguages {
+			t.Run(lang+"/"+kind, func(t *testing.T) {
+				msg, err := tmpls.Render(lang, sample)
+				if err != nil {
+					t.Fatal(err)
+				}
+				base := filepath.Join("testdata", "emails", lang, kind)
+				checkGolden(t, base+".txt", "Subject: "+msg.Subject+"\n\n"+msg.Text)
+				checkGolden(t, base+".html", msg.HTML)
+			})
+		}
+	}
+
+	// No golden file outlives its template.
+	files, _ := filepath.Glob(filepath.Join("testdata", "emails", "*", "*"))
+	for _, f := range files {
+		kind := strings.TrimSuffix(filepath.Base(f), filepath.Ext(f))
+		if _, ok := emailSamples[kind]; !ok || !slices.Contains(goldenLanguages, filepath.Base(filepath.Dir(f))) {
+			t.Errorf("%s: no such email", f)
+		}
+	}
+}
+
+func checkGolden(t *testing.T, path, got string) {
+	t.Helper()
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -run %s -update)", err, t.Name())
+	}
+	if got != string(want) {
+		t.Errorf("%s differs:\n%s", path, lineDiff(string(want), got))
+	}
+}
+
+// lineDiff lists the lines that differ between want and got, cut at 300
+// bytes.
+func lineDiff(want, got string) string {
+	w, g := strings.Split(want, "\n"), strings.Split(got, "\n")
+	var b strings.Builder
+	for i := range max(len(w), len(g)) {
+		var wl, gl string
+		if i < len(w) {
+			wl = w[i]
+		}
+		if i < len(g) {
+			gl = g[i]
+		}
+		if wl != gl {
+			wl, gl = wl[:min(len(wl), 300)], gl[:min(len(gl), 300)]
+			fmt.Fprintf(&b, "line %d:\n-%s\n+%s\n", i+1, wl, gl)
+		}
+	}
+	return b.String()
+}
+
+// TestEmailLanguageFallback picks the closest variant of a template.
+func TestEmailLanguageFallback(t *testing.T) {
+	tmpls, err := LoadEmailTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sample := emailSamples["verification"]
+	render := func(lang string) string {
+		msg, err := tmpls.Render(lang, sample)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg.Subject
+	}
+	en, pt := render("en"), render("pt-BR")
+	if en == pt {
+		t.Fatalf("en and pt-BR share the subject %q", en)
+	}
+	for lang, want := range map[string]string{"pt-br": pt, "pt": pt, "pt-PT": pt, "en-GB": en, "fr": en, "": en} {
+		if got := render(lang); got != want {
+			t.Errorf("%q: got %q, want %q", lang, got, want)
+		}
+	}
+}
+
+// TestEmailEscapesHTML checks that user-controlled values cannot inject
+// markup into the HTML body.
+func TestEmailEscapesHTML(t *testing.T) {
+	tmpls, err := LoadEmailTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := emailSamples["new_device"].(NewDeviceEmail)
+	data.Name, data.Device = `<script>alert(1)</script>`, `"><img src=x onerror=alert(1)>`
+	for _, lang := range goldenLanguages {
+		msg, err := tmpls.Render(lang, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(msg.HTML, "<script>") || strings.Contains(msg.HTML, "<img") {
+			t.Errorf("%s: markup from the data reached the HTML:\n%s", lang, msg.HTML)
+		}
+		if !strings.Contains(msg.Text, data.Name) {
+			t.Errorf("%s: the text body should carry the name as is", lang)
+		}
+	}
+}
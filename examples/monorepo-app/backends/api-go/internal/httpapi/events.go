@@ -0,0 +1,260 @@
+package httpapi
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"sync"
+)
+
+// EventType identifies an event carrying a payload of type T. Handlers
+// publish through it and extensions subscribe to it without touching the
+// handlers:
+//
+//	UserRegistered.Subscribe(bus, Async, func(ctx context.Context, e UserEvent) { ... })
+type EventType[T any] struct{ Name string }
+
+// Events published by the server.
+var (
+	UserRegistered     = EventType[UserEvent]{"user.registered"}
+	RegistrationFailed = EventType[AuthFailureEvent]{"user.registration_failed"}
+	LoggedIn           = EventType[UserEvent]{"auth.login"}
+	LoginFailed        = EventType[AuthFailureEvent]{"auth.login_failed"}
+	NewDeviceLogin     = EventType[DeviceEvent]{"auth.new_device"}
+	TokenRefreshed     = EventType[UserEvent]{"auth.token_refreshed"}
+	TokenRefreshFailed = EventType[AuthFailureEvent]{"auth.token_refresh_failed"}
+	AuthRejected       = EventType[RejectionEvent]{"request.auth_rejected"}
+	CSRFRejected       = EventType[RejectionEvent]{"request.csrf_rejected"}
+	RateLimited        = EventType[RejectionEvent]{"request.rate_limited"}
+	AdminAction        = EventType[AdminActionEvent]{"admin.action"}
+	PasswordChanged    = EventType[UserEvent]{"user.password_changed"}
+	PhoneVerified      = EventType[UserEvent]{"user.phone_verified"}
+	TermsAccepted      = EventType[UserEvent]{"user.terms_accepted"}
+	DeletionScheduled  = EventType[UserEvent]{"user.deletion_scheduled"}
+	DeletionCancelled  = EventType[UserEvent]{"user.deletion_cancelled"}
+	UserDeleted        = EventType[UserEvent]{"user.deleted"}
+	DataExported       = EventType[DataExportEvent]{"user.data_export"}
+	RoleChanged        = EventType[RoleChangedEvent]{"user.role_changed"}
+	UserSuspended      = EventType[UserEvent]{"user.suspended"}
+	SessionRevoked     = EventType[SessionEvent]{"session.revoked"}
+	MailFailed         = EventType[MailEvent]{"mail.failed"}
+	MailSent           = EventType[MailEvent]{"mail.sent"}
+	OrgMemberChanged   = EventType[OrgMemberEvent]{"org.member_changed"}
+	PreferencesChanged = EventType[PreferencesEvent]{"user.preferences_changed"}
+)
+
+type UserEvent struct {
+	User User
+}
+
+// AuthFailureEvent describes a failed login, registration or refresh.
+// UserID is set when the account is known, Email when it was only attempted.
+type AuthFailureEvent struct {
+	UserID string
+	Email  string
+	Reason string
+}
+
+// DeviceEvent describes a login from a device the user had not used.
+type DeviceEvent struct {
+	User        User
+	Device      string // "Browser on OS"
+	Fingerprint string
+}
+
+// RejectionEvent describes a request refused by middleware.
+type RejectionEvent struct {
+	Reason  string
+	Details map[string]string
+}
+
+type AdminActionEvent struct {
+	Action  string
+	Details map[string]string
+}
+
+type RoleChangedEvent struct {
+	User    User
+	OldRole string
+}
+
+// DataExportEvent describes a data export being requested or downloaded.
+type DataExportEvent struct {
+	UserID   string
+	ExportID string
+	Action   string // requested or downloaded
+}
+
+// SessionEvent describes a refresh token revoked outside of rotation:
+// one session (SessionID), or all of the user's when SessionID is empty.
+type SessionEvent struct {
+	UserID    string
+	SessionID string
+	Reason    string
+}
+
+// OrgMemberEvent describes a change to a user's membership of an
+// organization. Action is "added", "role_changed", "removed" (by an
+// owner or admin), "left" or "org_deleted"; Role is the role they have,
+// or had. ByAdmin is set when an admin made the change from /admin/orgs.
+type OrgMemberEvent struct {
+	OrgID   string
+	UserID  string
+	Role    string
+	Action  string
+	ByAdmin bool
+}
+
+// PreferencesEvent describes a change to a user's preferences. Old and New
+// are as saved, unset fields empty; see Preferences.WithDefaults.
+type PreferencesEvent struct {
+	UserID   string
+	Old, New Preferences
+}
+
+// MailEvent describes a message MailQueue sent, or gave up on (Attempts is
+// 0 when it was never tried).
+type MailEvent struct {
+	Kind     string
+	To       []string
+	Attempts int
+	Reason   string
+}
+
+// SubscribeMode selects how a subscriber is called. Sync subscribers run on
+// the publisher's goroutine, in subscription order, before Publish returns.
+// Async subscribers each get a queue and goroutine: delivery is in publish
+// order per subscriber and never blocks the publisher (events are dropped,
+// with a log line, when the queue is full).
+type SubscribeMode int
+
+const (
+	Sync SubscribeMode = iota
+	Async
+)
+
+const asyncEventQueue = 256
+
+// EventBus dispatches published events to subscribers. A panicking
+// subscriber is logged and isolated from the publisher and other
+// subscribers.
+type EventBus struct {
+	mu     sync.RWMutex
+	subs   map[string][]*subscriber
+	closed bool
+	wg     sync.WaitGroup
+}
+
+type subscriber struct {
+	event string
+	fn    func(context.Context, any)
+	queue chan queuedEvent // nil for Sync
+}
+
+type queuedEvent struct {
+	ctx context.Context
+	ev  any
+}
+
+func NewEventBus() *EventBus { return &EventBus{subs: make(map[string][]*subscriber)} }
+
+// Subscribe registers fn for et on bus.
+func (et EventType[T]) Subscribe(bus *EventBus, mode SubscribeMode, fn func(context.Context, T)) {
+	s := &subscriber{event: et.Name, fn: func(ctx context.Context, ev any) { fn(ctx, ev.(T)) }}
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	if mode == Async {
+		s.queue = make(chan queuedEvent, asyncEventQueue)
+		bus.wg.Add(1)
+		go func() {
+			defer bus.wg.Done()
+			for q := range s.queue {
+				s.call(q.ctx, q.ev)
+			}
+		}()
+	}
+	bus.subs[et.Name] = append(bus.subs[et.Name], s)
+}
+
+// Publish delivers ev to et's subscribers. Async subscribers receive a
+// context that keeps ctx's values (request ID, user) but not its
+// cancellation, since they may run after the request has finished.
+func (et EventType[T]) Publish(ctx context.Context, bus *EventBus, ev T) {
+	bus.mu.RLock()
+	defer bus.mu.RUnlock()
+	if bus.closed {
+		log.Printf("WARN event %s published after the bus closed, dropped", et.Name)
+		return
+	}
+	for _, s := range bus.subs[et.Name] {
+		if s.queue == nil {
+			s.call(ctx, ev)
+			continue
+		}
+		select {
+		case s.queue <- queuedEvent{ctx: context.WithoutCancel(ctx), ev: ev}:
+		default:
+			log.Printf("WARN event %s: async subscriber queue full, dropped (request_id=%s)", et.Name, RequestIDFrom(ctx))
+		}
+	}
+}
+
+func (s *subscriber) call(ctx context.Context, ev any) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("ERROR event %s: subscriber panicked: %v (request_id=%s)\n%s", s.event, p, RequestIDFrom(ctx), debug.Stack())
+		}
+	}()
+	s.fn(ctx, ev)
+}
+
+// Close stops accepting events and waits for async subscribers to drain
+// their queues, or for ctx to end.
+func (b *EventBus) Close(ctx context.Context) error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		for _, subs := range b.subs {
+			for _, s := range subs {
+				if s.queue != nil {
+					close(s.queue)
+				}
+			}
+		}
+	}
+	b.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// requestMeta is the request information carried to event subscribers.
+type requestMeta struct {
+	IP        string
+	UserAgent string
+	RequestID string
+}
+
+// eventContext returns r's context annotated for publishing: it keeps the
+// authenticated user (if any) and adds the client IP, user agent and
+// request ID.
+func eventContext(r *http.Request) context.Context {
+	return context.WithValue(r.Context(), ctxRequestMeta, requestMeta{
+		IP: clientIP(r), UserAgent: r.UserAgent(), RequestID: r.Header.Get("X-Request-ID"),
+	})
+}
+
+// RequestIDFrom returns the request ID carried by an event context.
+func RequestIDFrom(ctx context.Context) string {
+	meta, _ := ctx.Value(ctxRequestMeta).(requestMeta)
+	return meta.RequestID
+}
@@ -0,0 +1,193 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+)
+
+var testEvent = EventType[UserEvent]{"test.event"}
+
+// withRequestID returns a context carrying id as eventContext would.
+func withRequestID(id string) context.Context {
+	return context.WithValue(context.Background(), ctxRequestMeta, requestMeta{RequestID: id})
+}
+
+// quietLog sends the standard logger to a buffer until the test ends.
+func quietLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	out := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(out) })
+	return &buf
+}
+
+func TestEventBusSyncOrder(t *testing.T) {
+	bus := NewEventBus()
+	var calls []string
+	for _, name := range []string{"a", "b", "c"} {
+		testEvent.Subscribe(bus, Sync, func(ctx context.Context, e UserEvent) {
+			calls = append(calls, name+":"+e.User.ID+":"+RequestIDFrom(ctx))
+		})
+	}
+	EventType[UserEvent]{"test.other"}.Subscribe(bus, Sync, func(context.Context, UserEvent) {
+		calls = append(calls, "other")
+	})
+
+	testEvent.Publish(withRequestID("req-1"), bus, UserEvent{User: User{ID: "u1"}})
+	testEvent.Publish(withRequestID("req-2"), bus, UserEvent{User: User{ID: "u2"}})
+	// Sync subscribers have all run by the time Publish returns.
+	want := []string{"a:u1:req-1", "b:u1:req-1", "c:u1:req-1", "a:u2:req-2", "b:u2:req-2", "c:u2:req-2"}
+	if !slices.Equal(calls, want) {
+		t.Errorf("got %v, want %v", calls, want)
+	}
+	if err := bus.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	logs := quietLog(t)
+	testEvent.Publish(context.Background(), bus, UserEvent{})
+	if len(calls) != len(want) || !strings.Contains(logs.String(), "after the bus closed") {
+		t.Errorf("published after Close: %v\n%s", calls, logs)
+	}
+}
+
+// Async subscribers don't hold up the publisher, see events in publish
+// order, and keep the request's values past its cancellation.
+func TestEventBusAsync(t *testing.T) {
+	bus := NewEventBus()
+	release := make(chan struct{})
+	var (
+		mu   sync.Mutex
+		seen []string
+	)
+	testEvent.Subscribe(bus, Async, func(ctx context.Context, e UserEvent) {
+		<-release
+		mu.Lock()
+		defer mu.Unlock()
+		if ctx.Err() != nil {
+			t.Errorf("%s: context cancelled: %v", e.User.ID, ctx.Err())
+		}
+		seen = append(seen, e.User.ID+":"+RequestIDFrom(ctx))
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, id := range []string{"u1", "u2", "u3"} {
+			ctx, cancel := context.WithCancel(withRequestID("req-" + id))
+			testEvent.Publish(ctx, bus, UserEvent{User: User{ID: id}})
+			cancel()
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Publish blocked on an async subscriber")
+	}
+
+	close(release)
+	if err := bus.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// Close waited for the queue to drain.
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"u1:req-u1", "u2:req-u2", "u3:req-u3"}; !slices.Equal(seen, want) {
+		t.Errorf("got %v, want %v", seen, want)
+	}
+}
+
+func TestEventBusAsyncQueueFull(t *testing.T) {
+	logs := quietLog(t)
+	bus := NewEventBus()
+	release := make(chan struct{})
+	testEvent.Subscribe(bus, Async, func(context.Context, UserEvent) { <-release })
+	for range asyncEventQueue + 2 {
+		testEvent.Publish(withRequestID("req-full"), bus, UserEvent{})
+	}
+	close(release)
+	bus.Close(context.Background())
+	if !strings.Contains(logs.String(), "queue full, dropped (request_id=req-full)") {
+		t.Errorf("no drop logged:\n%s", logs)
+	}
+}
+
+func TestEventBusCloseDeadline(t *testing.T) {
+	bus := NewEventBus()
+	release := make(chan struct{})
+	defer close(release)
+	testEvent.Subscribe(bus, Async, func(context.Context, UserEvent) { <-release })
+	testEvent.Publish(context.Background(), bus, UserEvent{})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := bus.Close(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Close = %v, want the deadline", err)
+	}
+}
+
+// A panicking subscriber is logged with the request ID; the publisher and
+// the other subscribers carry on.
+func TestEventBusPanicIsolation(t *testing.T) {
+	logs := quietLog(t)
+	bus := NewEventBus()
+	var calls []string
+	var async sync.WaitGroup
+	async.Add(1)
+	testEvent.Subscribe(bus, Sync, func(context.Context, UserEvent) { calls = append(calls, "before") })
+	testEvent.Subscribe(bus, Sync, func(context.Context, UserEvent) { panic("sync boom") })
+	testEvent.Subscribe(bus, Async, func(context.Context, UserEvent) { panic("async boom") })
+	testEvent.Subscribe(bus, Async, func(context.Context, UserEvent) { async.Done() })
+	testEvent.Subscribe(bus, Sync, func(context.Context, UserEvent) { calls = append(calls, "after") })
+
+	testEvent.Publish(withRequestID("req-panic"), bus, UserEvent{})
+	async.Wait()
+	bus.Close(context.Background())
+	if !slices.Equal(calls, []string{"before", "after"}) {
+		t.Errorf("calls %v", calls)
+	}
+	for _, want := range []string{"subscriber panicked: sync boom (request_id=req-panic)", "subscriber panicked: async boom (request_id=req-panic)"} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("no %q in:\n%s", want, logs)
+		}
+	}
+}
+
+// Handlers publish on Server.Events with the request's context, and a
+// subscriber that panics doesn't fail the request.
+func TestEventsFromHandlers(t *testing.T) {
+	logs := quietLog(t)
+	s, ts := openAPIServer(t, store.NewMemory())
+	var got []string
+	UserRegistered.Subscribe(s.Events, Sync, func(context.Context, UserEvent) { panic("extension bug") })
+	UserRegistered.Subscribe(s.Events, Sync, func(ctx context.Context, e UserEvent) {
+		got = append(got, e.User.Email+" "+RequestIDFrom(ctx))
+	})
+
+	req, _ := http.NewRequest("POST", ts.URL+"/api/v1/auth/register",
+		strings.NewReader(`{"email":"hooked@example.com","name":"Hooked","password":"hooked-password"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Request-ID", "req-register")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("register: %d", resp.StatusCode)
+	}
+	if !slices.Equal(got, []string{"hooked@example.com req-register"}) {
+		t.Errorf("subscriber got %v", got)
+	}
+	if !strings.Contains(logs.String(), "event user.registered: subscriber panicked: extension bug (request_id=req-register)") {
+		t.Errorf("the panic was not logged:\n%s", logs)
+	}
+}
@@ -0,0 +1,247 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/auth"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+)
+
+const (
+	// dataExportTTL is how long an export, ready or not, is kept.
+	dataExportTTL = 24 * time.Hour
+	// dataExportQueue bounds the exports waiting for the worker.
+	dataExportQueue = 64
+)
+
+// Data export states.
+const (
+	exportPending = "pending"
+	exportRunning = "running"
+	exportReady   = "ready"
+	exportFailed  = "failed"
+)
+
+// DataExportArchive is the document a user downloads: everything the
+// server holds about them. Manifest describes each section and lists what
+// is deliberately left out.
+type DataExportArchive struct {
+	Manifest       DataExportManifest `json:"manifest"`
+	Profile        User               `json:"profile"`
+	Sessions       []Session          `json:"sessions"`
+	LoginHistory   []LoginRecord      `json:"login_history"`
+	SecurityEvents []SecurityEvent    `json:"security_events"`
+}
+
+type DataExportManifest struct {
+	FormatVersion int               `json:"format_version"`
+	GeneratedAt   time.Time         `json:"generated_at"`
+	UserID        string            `json:"user_id"`
+	Sections      map[string]string `json:"sections"`
+	Excluded      []string          `json:"excluded"`
+}
+
+// LoginRecord is one sign-in attempt in the login history.
+type LoginRecord struct {
+	Time      time.Time `json:"time"`
+	Type      string    `json:"type"`
+	Outcome   string    `json:"outcome"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent,omitempty"`
+}
+
+var dataExportSections = map[string]string{
+	"profile":         "the account as stored: email, name, role, status and timestamps",
+	"sessions":        "signed-in sessions (refresh token families): when each started, was refreshed and expires",
+	"login_history":   "logins, failed logins, token refreshes and new-device alerts, newest first",
+	"security_events": "every audit trail entry referencing the account, by ID or email, newest first",
+}
+
+// dataExportExcluded are kept out of every export: credentials, which
+// would let anyone holding the file sign in, not data about the user.
+var dataExportExcluded = []string{
+	"password hash",
+	"refresh tokens and CSRF tokens (the sessions section lists them without the token values)",
+}
+
+var loginEventTypes = map[string]bool{EventLogin: true, EventLoginFailed: true, EventTokenRefresh: true, EventNewDevice: true}
+
+// DataExports builds data exports off the request path: the handler
+// records a pending export and queues it, a worker assembles the archive
+// and saves its progress in the store as it goes.
+type DataExports struct {
+	store store.Store
+
+	mu     sync.RWMutex // guards queue against send-after-close
+	closed bool
+	queue  chan string
+	wg     sync.WaitGroup
+}
+
+func NewDataExports(st store.Store) *DataExports {
+	return &DataExports{store: st, queue: make(chan string, dataExportQueue)}
+}
+
+// Start launches n export workers.
+func (x *DataExports) Start(n int) {
+	for range max(n, 1) {
+		x.wg.Add(1)
+		go func() {
+			defer x.wg.Done()
+			for id := range x.queue {
+				x.build(id)
+			}
+		}()
+	}
+}
+
+// Enqueue queues export id, and reports false when the queue is full or
+// shutting down.
+func (x *DataExports) Enqueue(id string) bool {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if x.closed {
+		return false
+	}
+	select {
+	case x.queue <- id:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stop stops accepting exports and waits for the queued ones to be built,
+// or for ctx to end.
+func (x *DataExports) Stop(ctx context.Context) error {
+	x.mu.Lock()
+	if !x.closed {
+		x.closed = true
+		close(x.queue)
+	}
+	x.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		x.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (x *DataExports) build(id string) {
+	progress := func(status string, percent int) bool {
+		return x.store.UpdateDataExport(id, func(e *DataExport) { e.Status, e.Progress = status, percent })
+	}
+	export, ok := x.store.GetDataExport(id)
+	if !ok || !progress(exportRunning, 0) {
+		return // expired while queued
+	}
+	archive, err := x.assemble(export.UserID, progress)
+	if err != nil {
+		log.Printf("ERROR data export %s: %v", id, err)
+		x.store.UpdateDataExport(id, func(e *DataExport) { e.Status, e.Error = exportFailed, "could not assemble the export" })
+		return
+	}
+	x.store.UpdateDataExport(id, func(e *DataExport) { e.Status, e.Progress, e.Archive = exportReady, 100, archive })
+}
+
+func (x *DataExports) assemble(userID string, progress func(string, int) bool) ([]byte, error) {
+	user, err := x.store.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	a := DataExportArchive{
+		Manifest: DataExportManifest{
+			FormatVersion: 1, GeneratedAt: auth.Now().UTC(), UserID: user.ID,
+			Sections: dataExportSections, Excluded: dataExportExcluded,
+		},
+		Profile:      *user, // User never serializes the password hash
+		Sessions:     x.store.UserSessions(user.ID),
+		LoginHistory: []LoginRecord{},
+	}
+	progress(exportRunning, 30)
+
+	// By ID, then the entries that only name the email: attempts that never
+	// resolved to the account, such as failed logins or a registration
+	// with a taken email.
+	a.SecurityEvents = x.store.SecurityEvents(SecurityEventFilter{User: user.ID})
+	for _, e := range x.store.SecurityEvents(SecurityEventFilter{User: user.Email}) {
+		if e.UserID != user.ID {
+			a.SecurityEvents = append(a.SecurityEvents, e)
+		}
+	}
+	slices.SortStableFunc(a.SecurityEvents, func(a, b SecurityEvent) int { return b.Time.Compare(a.Time) })
+	for _, e := range a.SecurityEvents {
+		if loginEventTypes[e.Type] {
+			a.LoginHistory = append(a.LoginHistory, LoginRecord{Time: e.Time, Type: e.Type, Outcome: e.Outcome, IP: e.IP, UserAgent: e.UserAgent})
+		}
+	}
+	progress(exportRunning, 80)
+
+	data, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encode: %w", err)
+	}
+	return data, nil
+}
+
+// RequestDataExport starts building a copy of everything held about the
+// caller and answers 202 with its status; poll the Location until it is
+// ready. It needs a recent login (RequireFreshAuth).
+func (h *Handlers) RequestDataExport(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(ctxUserID).(string)
+	now := auth.Now()
+	export := DataExport{
+		ID: auth.GenerateID(), UserID: userID, Status: exportPending,
+		CreatedAt: now.UTC(), ExpiresAt: now.Add(dataExportTTL).UTC(),
+	}
+	h.store.CreateDataExport(export)
+	if !h.exports.Enqueue(export.ID) {
+		h.store.UpdateDataExport(export.ID, func(e *DataExport) { e.Status, e.Error = exportFailed, "server busy" })
+		w.Header().Set("Retry-After", "60")
+		writeErrorCode(w, r, http.StatusServiceUnavailable, api.ErrCodeOverloaded, "too many data exports in progress")
+		return
+	}
+	DataExported.Publish(eventContext(r), h.events, DataExportEvent{UserID: userID, ExportID: export.ID, Action: "requested"})
+	w.Header().Set("Location", r.URL.Path+"/"+export.ID)
+	respond(w, r, http.StatusAccepted, export)
+}
+
+// GetDataExport answers 202 with the status while the export is being
+// built and the archive once it is ready. Exports of other users are
+// reported as not found.
+func (h *Handlers) GetDataExport(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(ctxUserID).(string)
+	export, ok := h.store.GetDataExport(r.PathValue("id"))
+	if !ok || export.UserID != userID {
+		writeErrorCode(w, r, http.StatusNotFound, api.ErrCodeNotFound, "data export not found")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	switch export.Status {
+	case exportReady:
+		DataExported.Publish(eventContext(r), h.events, DataExportEvent{UserID: userID, ExportID: export.ID, Action: "downloaded"})
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="data-export-`+export.ID+`.json"`)
+		w.Write(export.Archive)
+	case exportFailed:
+		writeErrorCode(w, r, http.StatusInternalServerError, api.ErrCodeInternal, "data export failed; request a new one")
+	default:
+		w.Header().Set("Retry-After", "2")
+		respond(w, r, http.StatusAccepted, export)
+	}
+}
@@ -0,0 +1,250 @@
+package httpapi
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+)
+
+// Features evaluates the flags in FEATURE_FLAGS, so unfinished features can
+// ship dark. A flag's state is its FEATURE_FLAGS default unless an admin
+// set another at runtime (kept in the store, so every replica sees it);
+// a per-user override wins over both. An enabled flag with a rollout
+// under 100% is on for that share of users, picked by hashing the flag
+// name with the user ID, so a user keeps their answer as the rollout
+// grows. Flags not in FEATURE_FLAGS are always off.
+type Features struct {
+	store    store.Store
+	defaults atomic.Pointer[[]config.FeatureFlag]
+}
+
+func NewFeatures(st store.Store, flags []config.FeatureFlag) *Features {
+	f := &Features{store: st}
+	f.Set(flags)
+	return f
+}
+
+// Set replaces the flags and their defaults, on a config reload.
+func (f *Features) Set(flags []config.FeatureFlag) {
+	flags = slices.Clone(flags)
+	slices.SortFunc(flags, func(a, b config.FeatureFlag) int { return cmp.Compare(a.Name, b.Name) })
+	f.defaults.Store(&flags)
+}
+
+// lookup returns the FEATURE_FLAGS entry for name.
+func (f *Features) lookup(name string) (config.FeatureFlag, bool) {
+	flags := *f.defaults.Load()
+	i := slices.IndexFunc(flags, func(d config.FeatureFlag) bool { return d.Name == name })
+	if i < 0 {
+		return config.FeatureFlag{}, false
+	}
+	return flags[i], true
+}
+
+// Enabled reports whether flag name is on for the user authenticated on
+// ctx, or for an anonymous caller, for whom a partial rollout is off.
+func (f *Features) Enabled(ctx context.Context, name string) bool {
+	userID, _ := ctx.Value(ctxUserID).(string)
+	flags := f.List()
+	i := slices.IndexFunc(flags, func(s FeatureFlagStatus) bool { return s.Name == name })
+	return i >= 0 && f.evaluate(flags[i], userID, f.overrides(userID))
+}
+
+// For evaluates every flag for userID ("" for anonymous).
+func (f *Features) For(userID string) map[string]bool {
+	overrides := f.overrides(userID)
+	out := make(map[string]bool)
+	for _, s := range f.List() {
+		out[s.Name] = f.evaluate(s, userID, overrides)
+	}
+	return out
+}
+
+func (f *Features) overrides(userID string) map[string]bool {
+	if userID == "" {
+		return nil
+	}
+	return f.store.FeatureOverrides(userID)
+}
+
+func (f *Features) evaluate(s FeatureFlagStatus, userID string, overrides map[string]bool) bool {
+	if on, ok := overrides[s.Name]; ok {
+		return on
+	}
+	return s.Enabled && (s.Rollout >= 100 || userID != "" && rolloutBucket(s.Name, userID) < s.Rollout)
+}
+
+// rolloutBucket places userID in one of 100 buckets for flag name.
+func rolloutBucket(name, userID string) int {
+	h := fnv.New32a()
+	h.Write([]byte(name + ":" + userID))
+	return int(h.Sum32() % 100)
+}
+
+// FeatureFlagStatus is a flag's current state, and where it comes from:
+// "config" for its FEATURE_FLAGS default, "runtime" when an admin set it.
+type FeatureFlagStatus struct {
+	Name      string     `json:"name"`
+	Enabled   bool       `json:"enabled"`
+	Rollout   int        `json:"rollout"`
+	Source    string     `json:"source"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"` // runtime only
+}
+
+// List returns the state of every flag, by name.
+func (f *Features) List() []FeatureFlagStatus {
+	set := make(map[string]store.FeatureFlag)
+	for _, s := range f.store.FeatureFlags() {
+		set[s.Name] = s
+	}
+	defaults := *f.defaults.Load()
+	out := make([]FeatureFlagStatus, len(defaults))
+	for i, d := range defaults {
+		out[i] = FeatureFlagStatus{Name: d.Name, Enabled: d.Enabled, Rollout: d.Rollout, Source: "config"}
+		if s, ok := set[d.Name]; ok {
+			out[i] = FeatureFlagStatus{Name: d.Name, Enabled: s.Enabled, Rollout: s.Rollout, Source: "runtime", UpdatedAt: &s.UpdatedAt}
+		}
+	}
+	return out
+}
+
+// Gate answers 404 not_found for the routes behind it while flag name is
+// off for the caller, as if they did not exist yet. Put it after Auth so
+// the user's overrides and rollout count.
+func (f *Features) Gate(name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !f.Enabled(r.Context(), name) {
+				writeErrorCode(w, r, http.StatusNotFound, api.ErrCodeNotFound, "not found")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type FeatureSet struct {
+	Features map[string]bool `json:"features"`
+}
+
+type FeatureFlagList struct {
+	Flags []FeatureFlagStatus `json:"flags"`
+	Total int                 `json:"total"`
+}
+
+type SetFeatureFlagRequest struct {
+	Enabled *bool `json:"enabled"`
+	Rollout *int  `json:"rollout"` // percent of users; 100 when omitted
+}
+
+type SetFeatureOverrideRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// GetMyFeatures returns every flag evaluated for the current user, for the
+// frontend to gate UI on.
+func (h *Handlers) GetMyFeatures(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(ctxUserID).(string)
+	respond(w, r, http.StatusOK, FeatureSet{Features: h.features.For(userID)})
+}
+
+func (h *Handlers) ListFeatureFlags(w http.ResponseWriter, r *http.Request) {
+	flags := h.features.List()
+	respond(w, r, http.StatusOK, FeatureFlagList{Flags: flags, Total: len(flags)})
+}
+
+// knownFeature reports whether name is in FEATURE_FLAGS, answering r with
+// 404 when it is not.
+func (h *Handlers) knownFeature(w http.ResponseWriter, r *http.Request, name string) bool {
+	if _, ok := h.features.lookup(name); ok {
+		return true
+	}
+	writeErrorCode(w, r, http.StatusNotFound, api.ErrCodeNotFound, fmt.Sprintf("unknown feature flag %q", name))
+	return false
+}
+
+// SetFeatureFlag switches a flag for everyone, in place of its
+// FEATURE_FLAGS default, without a restart.
+func (h *Handlers) SetFeatureFlag(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !h.knownFeature(w, r, name) {
+		return
+	}
+	var req SetFeatureFlagRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorCode(w, r, http.StatusBadRequest, api.ErrCodeInvalidRequest, "invalid request body")
+		return
+	}
+	var fields []FieldError
+	if req.Enabled == nil {
+		fields = append(fields, FieldError{Field: "enabled", Message: "is required"})
+	}
+	rollout := 100
+	if req.Rollout != nil {
+		rollout = *req.Rollout
+	}
+	if rollout < 0 || rollout > 100 {
+		fields = append(fields, FieldError{Field: "rollout", Message: "must be from 0 to 100"})
+	}
+	if len(fields) > 0 {
+		writeErrorFields(w, r, http.StatusBadRequest, api.ErrCodeValidationFailed, "invalid feature flag", fields)
+		return
+	}
+	h.store.SetFeatureFlag(store.FeatureFlag{Name: name, Enabled: *req.Enabled, Rollout: rollout, UpdatedAt: time.Now().UTC()})
+	AdminAction.Publish(eventContext(r), h.events, AdminActionEvent{Action: "feature_flag_set",
+		Details: map[string]string{"flag": name, "enabled": fmt.Sprint(*req.Enabled), "rollout": fmt.Sprint(rollout)}})
+	h.respondFeatureFlag(w, r, name)
+}
+
+// ResetFeatureFlag puts a flag back on its FEATURE_FLAGS default.
+func (h *Handlers) ResetFeatureFlag(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !h.knownFeature(w, r, name) {
+		return
+	}
+	if h.store.DeleteFeatureFlag(name) {
+		AdminAction.Publish(eventContext(r), h.events, AdminActionEvent{Action: "feature_flag_reset", Details: map[string]string{"flag": name}})
+	}
+	h.respondFeatureFlag(w, r, name)
+}
+
+func (h *Handlers) respondFeatureFlag(w http.ResponseWriter, r *http.Request, name string) {
+	flags := h.features.List()
+	i := slices.IndexFunc(flags, func(s FeatureFlagStatus) bool { return s.Name == name })
+	respond(w, r, http.StatusOK, flags[i])
+}
+
+// SetUserFeature turns a flag on or off for one user, whatever its state
+// and rollout; {"enabled": null} removes the override.
+func (h *Handlers) SetUserFeature(w http.ResponseWriter, r *http.Request) {
+	userID, name := r.PathValue("id"), r.PathValue("name")
+	if !h.knownFeature(w, r, name) {
+		return
+	}
+	var req SetFeatureOverrideRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorCode(w, r, http.StatusBadRequest, api.ErrCodeInvalidRequest, "invalid request body")
+		return
+	}
+	if _, err := h.store.GetUserByID(userID); err != nil {
+		writeUserError(w, r, err)
+		return
+	}
+	h.store.SetFeatureOverride(userID, name, req.Enabled)
+	details := map[string]string{"user_id": userID, "flag": name, "enabled": "default"}
+	if req.Enabled != nil {
+		details["enabled"] = fmt.Sprint(*req.Enabled)
+	}
+	AdminAction.Publish(eventContext(r), h.events, AdminActionEvent{Action: "feature_override_set", Details: details})
+	respond(w, r, http.StatusOK, FeatureSet{Features: h.features.For(userID)})
+}
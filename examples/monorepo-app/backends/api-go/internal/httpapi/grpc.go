@@ -0,0 +1,610 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/internal/auth"
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+)
+
+// The services in proto/raijin/v1/raijin.proto plus grpc.health.v1.Health,
+// served over h2c on GRPC_ADDR. Only unary calls and Health/Watch are
+// supported, without compression; that is all the schema needs.
+
+// gRPC status codes, as in google.golang.org/grpc/codes.
+const (
+	grpcOK                = 0
+	grpcCanceled          = 1
+	grpcInvalidArgument   = 3
+	grpcDeadlineExceeded  = 4
+	grpcNotFound          = 5
+	grpcPermissionDenied  = 7
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+	grpcUnavailable       = 14
+	grpcUnauthenticated   = 16
+)
+
+var grpcCodeNames = map[int]string{
+	grpcOK: "OK", grpcCanceled: "Canceled", grpcInvalidArgument: "InvalidArgument",
+	grpcDeadlineExceeded: "DeadlineExceeded", grpcNotFound: "NotFound", grpcPermissionDenied: "PermissionDenied",
+	grpcResourceExhausted: "ResourceExhausted", grpcUnimplemented: "Unimplemented", grpcInternal: "Internal",
+	grpcUnavailable: "Unavailable", grpcUnauthenticated: "Unauthenticated",
+}
+
+// GRPCError is an RPC failure with its status code.
+type GRPCError struct {
+	Code    int
+	Message string
+}
+
+func (e *GRPCError) Error() string { return grpcCodeNames[e.Code] + ": " + e.Message }
+
+func grpcErrorf(code int, format string, args ...any) error {
+	return &GRPCError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// grpcStatus maps err to a status code and message.
+func grpcStatus(err error) (int, string) {
+	var ge *GRPCError
+	switch {
+	case err == nil:
+		return grpcOK, ""
+	case errors.As(err, &ge):
+		return ge.Code, ge.Message
+	case errors.Is(err, context.DeadlineExceeded):
+		return grpcDeadlineExceeded, err.Error()
+	case errors.Is(err, context.Canceled):
+		return grpcCanceled, err.Error()
+	default:
+		return grpcInternal, err.Error()
+	}
+}
+
+const grpcMaxMessage = 4 << 20
+
+var errProtoMalformed = errors.New("malformed protobuf message")
+
+// protoEncoder appends protobuf fields. Zero values are omitted, as proto3
+// does for scalars.
+type protoEncoder []byte
+
+func (e *protoEncoder) tag(field, wireType int) {
+	*e = binary.AppendUvarint(*e, uint64(field<<3|wireType))
+}
+
+func (e *protoEncoder) String(field int, s string) {
+	if s != "" {
+		e.Bytes(field, []byte(s))
+	}
+}
+
+// Bytes writes a length-delimited field, also for embedded messages, which
+// are written even when empty.
+func (e *protoEncoder) Bytes(field int, b []byte) {
+	e.tag(field, 2)
+	*e = binary.AppendUvarint(*e, uint64(len(b)))
+	*e = append(*e, b...)
+}
+
+func (e *protoEncoder) Int64(field int, v int64) {
+	if v != 0 {
+		e.tag(field, 0)
+		*e = binary.AppendUvarint(*e, uint64(v))
+	}
+}
+
+func (e *protoEncoder) Bool(field int, v bool) {
+	if v {
+		e.tag(field, 0)
+		*e = append(*e, 1)
+	}
+}
+
+// decodeProto calls fn for every field of b: v holds varint and fixed-size
+// values, data the bytes of length-delimited ones.
+func decodeProto(b []byte, fn func(field int, v uint64, data []byte)) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errProtoMalformed
+		}
+		b = b[n:]
+		var v uint64
+		var data []byte
+		switch key & 7 {
+		case 0:
+			if v, n = binary.Uvarint(b); n <= 0 {
+				return errProtoMalformed
+			}
+			b = b[n:]
+		case 1:
+			if len(b) < 8 {
+				return errProtoMalformed
+			}
+			v, b = binary.LittleEndian.Uint64(b), b[8:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || l > uint64(len(b)-n) {
+				return errProtoMalformed
+			}
+			data, b = b[n:n+int(l)], b[n+int(l):]
+		case 5:
+			if len(b) < 4 {
+				return errProtoMalformed
+			}
+			v, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		default:
+			return errProtoMalformed
+		}
+		fn(int(key>>3), v, data)
+	}
+	return nil
+}
+
+// decodeStringField returns string field n of b, for the single-field
+// request messages.
+func decodeStringField(b []byte, n int) (string, error) {
+	var s string
+	err := decodeProto(b, func(field int, _ uint64, data []byte) {
+		if field == n {
+			s = string(data)
+		}
+	})
+	if err != nil {
+		return "", grpcErrorf(grpcInvalidArgument, "%v", err)
+	}
+	return s, nil
+}
+
+// encodeUser is raijin.v1.User.
+func encodeUser(u *User) []byte {
+	var e protoEncoder
+	e.String(1, u.ID)
+	e.String(2, u.Email)
+	e.String(3, u.Name)
+	e.String(4, u.Role)
+	e.Int64(5, u.CreatedAt.Unix())
+	e.Int64(6, u.UpdatedAt.Unix())
+	return e
+}
+
+// grpcCall is one RPC as seen by interceptors.
+type grpcCall struct {
+	Method    string // "/raijin.v1.UserService/GetUser"
+	Request   *http.Request
+	RequestID string
+	UserID    string // set by the auth interceptor
+	Body      []byte
+}
+
+type grpcHandler func(ctx context.Context, call *grpcCall) ([]byte, error)
+
+// grpcMethod is a unary method and who may call it.
+type grpcMethod struct {
+	access RouteAccess
+	handle grpcHandler
+}
+
+const (
+	grpcHealthCheck = "/grpc.health.v1.Health/Check"
+	grpcHealthWatch = "/grpc.health.v1.Health/Watch"
+)
+
+// grpc.health.v1.HealthCheckResponse.ServingStatus
+const (
+	healthServing        = 1
+	healthNotServing     = 2
+	healthServiceUnknown = 3
+)
+
+// grpcServices are the services reported by the health service.
+var grpcServices = map[string]bool{"": true, "raijin.v1.UserService": true, "raijin.v1.AuthService": true}
+
+// GRPCServer serves the gRPC API as an http.Handler. Calls go through the
+// same steps as HTTP requests: logging with request IDs, panic recovery,
+// Bearer auth from metadata and per-method rate limits.
+type GRPCServer struct {
+	cfg     *config.Config
+	store   store.Store
+	checks  *Checks
+	drain   *Drain
+	life    *Lifecycle // warmup state, for health
+	events  *EventBus
+	methods map[string]grpcMethod
+	limits  map[string]*RateLimiter // method (or "*") -> limiter
+	handler grpcHandler
+	done    chan struct{}
+	once    sync.Once
+}
+
+// NewGRPCServer wires the methods to st. Rate limits come from
+// GRPC_RATE_LIMITS buckets in rateLimits; undefined ones are reported by
+// rateLimits.Err.
+func NewGRPCServer(cfg *config.Config, st store.Store, checks *Checks, drain *Drain, life *Lifecycle, events *EventBus, rateLimits *RateLimiters) *GRPCServer {
+	s := &GRPCServer{
+		cfg: cfg, store: st, checks: checks, drain: drain, life: life, events: events,
+		limits: make(map[string]*RateLimiter),
+		done:   make(chan struct{}),
+	}
+	s.methods = map[string]grpcMethod{
+		"/raijin.v1.UserService/GetUser":       {AccessAdmin, s.getUser},
+		"/raijin.v1.UserService/ListUsers":     {AccessAdmin, s.listUsers},
+		"/raijin.v1.UserService/Introspect":    {AccessAdmin, s.introspect},
+		"/raijin.v1.AuthService/ValidateToken": {AccessPublic, s.validateToken},
+		grpcHealthCheck:                        {AccessPublic, s.healthCheck},
+	}
+	for method, bucket := range cfg.GRPCRateLimits {
+		if rl := rateLimits.attach(bucket, "gRPC "+method); rl != nil {
+			s.limits[method] = rl
+		}
+	}
+	s.handler = s.logging(s.recovery(s.auth(s.rateLimit(s.dispatch))))
+	return s
+}
+
+func (s *GRPCServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		_, _ = io.WriteString(w, "gRPC over HTTP/2 only\n")
+		return
+	}
+	call := &grpcCall{Method: r.URL.Path, Request: r, RequestID: r.Header.Get("X-Request-ID")}
+	if call.RequestID == "" {
+		call.RequestID = auth.GenerateID()
+		r.Header.Set("X-Request-ID", call.RequestID) // for eventContext
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("X-Request-ID", call.RequestID)
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+
+	ctx := r.Context()
+	if d, ok := parseGRPCTimeout(r.Header.Get("Grpc-Timeout")); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	var resp []byte
+	body, err := readGRPCMessage(r.Body)
+	if err == nil {
+		call.Body = body
+		if call.Method == grpcHealthWatch {
+			err = s.watch(ctx, w, call)
+		} else {
+			resp, err = s.handler(ctx, call)
+		}
+	}
+	if err == nil && resp != nil {
+		err = writeGRPCMessage(w, resp)
+	}
+	code, msg := grpcStatus(err)
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set("Grpc-Message", grpcEncodeMessage(msg))
+	}
+}
+
+// Stop ends Health/Watch streams, reporting NOT_SERVING first. Call it
+// before shutting the http.Server down, which waits for open streams.
+func (s *GRPCServer) Stop() { s.once.Do(func() { close(s.done) }) }
+
+// readGRPCMessage reads the single length-prefixed message of a unary call.
+func readGRPCMessage(body io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(body, prefix[:]); err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "reading message: %v", err)
+	}
+	if prefix[0] != 0 {
+		return nil, grpcErrorf(grpcUnimplemented, "compressed messages are not supported")
+	}
+	n := binary.BigEndian.Uint32(prefix[1:])
+	if n > grpcMaxMessage {
+		return nil, grpcErrorf(grpcResourceExhausted, "message of %d bytes exceeds %d", n, grpcMaxMessage)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(body, msg); err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "reading message: %v", err)
+	}
+	return msg, nil
+}
+
+func writeGRPCMessage(w http.ResponseWriter, msg []byte) error {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	_, err := w.Write(append(frame, msg...))
+	return err
+}
+
+// grpcEncodeMessage percent-encodes a grpc-message value.
+func grpcEncodeMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		if c := msg[i]; c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// parseGRPCTimeout parses a grpc-timeout header such as "100m" or "5S".
+func parseGRPCTimeout(v string) (time.Duration, bool) {
+	if len(v) < 2 || len(v) > 9 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	unit, ok := map[byte]time.Duration{
+		'H': time.Hour, 'M': time.Minute, 'S': time.Second,
+		'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond,
+	}[v[len(v)-1]]
+	return time.Duration(n) * unit, ok
+}
+
+// logging writes one line per call, like the access log.
+func (s *GRPCServer) logging(next grpcHandler) grpcHandler {
+	return func(ctx context.Context, call *grpcCall) ([]byte, error) {
+		start := time.Now()
+		resp, err := next(ctx, call)
+		code, _ := grpcStatus(err)
+		tc, _ := TraceFrom(ctx)
+		log.Printf("gRPC %s %s %v peer=%s user=%s request_id=%s trace_id=%s",
+			call.Method, grpcCodeNames[code], time.Since(start), clientIP(call.Request), call.UserID, call.RequestID, tc.TraceID)
+		return resp, err
+	}
+}
+
+func (s *GRPCServer) recovery(next grpcHandler) grpcHandler {
+	return func(ctx context.Context, call *grpcCall) (resp []byte, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				log.Printf("ERROR gRPC %s panicked: %v (request_id=%s)\n%s", call.Method, p, call.RequestID, debug.Stack())
+				resp, err = nil, grpcErrorf(grpcInternal, "internal error")
+			}
+		}()
+		return next(ctx, call)
+	}
+}
+
+// auth verifies the "authorization: Bearer <token>" metadata for methods
+// that need it, like Middleware.Auth and RequireRole.
+func (s *GRPCServer) auth(next grpcHandler) grpcHandler {
+	return func(ctx context.Context, call *grpcCall) ([]byte, error) {
+		m, ok := s.methods[call.Method]
+		if !ok || m.access == AccessPublic {
+			return next(ctx, call)
+		}
+		claims, err := bearerClaims(call.Request, s.cfg)
+		if err != nil {
+			code, msg := authErrorCode(err)
+			AuthRejected.Publish(eventContext(call.Request), s.events, RejectionEvent{
+				Reason: code, Details: map[string]string{"error_code": code, "path": call.Method},
+			})
+			return nil, grpcErrorf(grpcUnauthenticated, "%s", msg)
+		}
+		if claims.SessionStart != 0 && pastLifetime(time.Unix(claims.SessionStart, 0), s.cfg.MaxSessionLifetime) {
+			return nil, grpcErrorf(grpcUnauthenticated, "session expired, log in again")
+		}
+		if user, err := s.store.GetUserByID(claims.UserID); err == nil {
+			if user.Suspended {
+				return nil, grpcErrorf(grpcPermissionDenied, "account suspended")
+			}
+			if user.TokenRevoked(claims.Iat) {
+				return nil, grpcErrorf(grpcUnauthenticated, "token revoked, log in again")
+			}
+		}
+		if claims.SessionID != "" && s.store.IsSessionRevoked(claims.SessionID) || orgTokenRevoked(s.store, claims) {
+			return nil, grpcErrorf(grpcUnauthenticated, "token revoked, log in again")
+		}
+		if m.access == AccessAdmin && claims.Role != "admin" {
+			return nil, grpcErrorf(grpcPermissionDenied, "insufficient permissions")
+		}
+		call.UserID = claims.UserID
+		ctx = context.WithValue(ctx, ctxUserID, claims.UserID)
+		ctx = context.WithValue(ctx, ctxEmail, claims.Email)
+		ctx = context.WithValue(ctx, ctxRole, claims.Role)
+		call.Request = call.Request.WithContext(ctx)
+		return next(ctx, call)
+	}
+}
+
+// rateLimit applies the method's GRPC_RATE_LIMITS bucket, or the "*" one.
+// Health checks are never limited.
+func (s *GRPCServer) rateLimit(next grpcHandler) grpcHandler {
+	return func(ctx context.Context, call *grpcCall) ([]byte, error) {
+		rl, ok := s.limits[call.Method]
+		if !ok {
+			rl = s.limits["*"]
+		}
+		if rl == nil || strings.HasPrefix(call.Method, "/grpc.health.v1.") {
+			return next(ctx, call)
+		}
+		if allowed, retry := rl.admit(call.Request); !allowed {
+			if rl.onLimit != nil {
+				rl.onLimit(call.Request)
+			}
+			return nil, grpcErrorf(grpcResourceExhausted, "rate limit exceeded, retry in %ds", retrySeconds(retry))
+		}
+		return next(ctx, call)
+	}
+}
+
+func (s *GRPCServer) dispatch(ctx context.Context, call *grpcCall) ([]byte, error) {
+	m, ok := s.methods[call.Method]
+	if !ok {
+		return nil, grpcErrorf(grpcUnimplemented, "unknown method %s", call.Method)
+	}
+	return m.handle(ctx, call)
+}
+
+func (s *GRPCServer) getUser(_ context.Context, call *grpcCall) ([]byte, error) {
+	id, err := decodeStringField(call.Body, 1)
+	if err != nil {
+		return nil, err
+	}
+	if id == "" {
+		return nil, grpcErrorf(grpcInvalidArgument, "id is required")
+	}
+	user, err := s.store.GetUserByID(id)
+	if errors.Is(err, store.ErrUserNotFound) {
+		return nil, grpcErrorf(grpcNotFound, "user not found")
+	}
+	if err != nil {
+		log.Printf("ERROR gRPC %s: store: %v (request_id=%s)", call.Method, err, call.RequestID)
+		return nil, grpcErrorf(grpcInternal, "internal error")
+	}
+	return encodeUser(user), nil
+}
+
+func (s *GRPCServer) listUsers(_ context.Context, _ *grpcCall) ([]byte, error) {
+	users := s.store.ListUsers()
+	var e protoEncoder
+	for _, u := range users {
+		e.Bytes(1, encodeUser(u))
+	}
+	e.Int64(2, int64(len(users)))
+	return e, nil
+}
+
+func (s *GRPCServer) introspect(_ context.Context, call *grpcCall) ([]byte, error) {
+	token, err := decodeStringField(call.Body, 1)
+	if err != nil {
+		return nil, err
+	}
+	var e protoEncoder
+	claims, err := verifyToken(s.cfg, token)
+	if err != nil {
+		return e, nil // inactive
+	}
+	user, err := s.store.GetUserByID(claims.UserID)
+	if err != nil {
+		return e, nil
+	}
+	e.Bool(1, true)
+	e.Bytes(2, encodeUser(user))
+	e.Int64(3, claims.Exp)
+	e.Int64(4, claims.Iat)
+	return e, nil
+}
+
+func (s *GRPCServer) validateToken(_ context.Context, call *grpcCall) ([]byte, error) {
+	token, err := decodeStringField(call.Body, 1)
+	if err != nil {
+		return nil, err
+	}
+	var e protoEncoder
+	claims, err := verifyToken(s.cfg, token)
+	switch {
+	case errors.Is(err, auth.ErrTokenExpired):
+		e.String(5, "expired")
+	case err != nil:
+		e.String(5, "invalid")
+	case claims.SessionStart != 0 && pastLifetime(time.Unix(claims.SessionStart, 0), s.cfg.MaxSessionLifetime):
+		e.String(5, "session_expired")
+	default:
+		e.Bool(1, true)
+		e.String(2, claims.UserID)
+		e.String(3, claims.Role)
+		e.Int64(4, claims.Exp)
+	}
+	return e, nil
+}
+
+// healthStatus is NOT_SERVING while draining or when a readiness check is
+// unhealthy.
+func (s *GRPCServer) healthStatus(ctx context.Context, service string) int {
+	if !grpcServices[service] {
+		return healthServiceUnknown
+	}
+	select {
+	case <-s.done:
+		return healthNotServing
+	default:
+	}
+	if s.drain.Draining() {
+		return healthNotServing
+	}
+	if done, err := s.life.warmupState(); !done || err != nil {
+		return healthNotServing
+	}
+	if _, status := s.checks.Run(ctx); status == StatusUnhealthy {
+		return healthNotServing
+	}
+	return healthServing
+}
+
+func (s *GRPCServer) healthCheck(ctx context.Context, call *grpcCall) ([]byte, error) {
+	service, err := decodeStringField(call.Body, 1)
+	if err != nil {
+		return nil, err
+	}
+	status := s.healthStatus(ctx, service)
+	if status == healthServiceUnknown {
+		return nil, grpcErrorf(grpcNotFound, "unknown service %q", service)
+	}
+	var e protoEncoder
+	e.Int64(1, int64(status))
+	return e, nil
+}
+
+// watch streams the service's status whenever it changes, polling once a
+// second, until the client goes away or Stop is called.
+func (s *GRPCServer) watch(ctx context.Context, w http.ResponseWriter, call *grpcCall) (err error) {
+	service, err := decodeStringField(call.Body, 1)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("ERROR gRPC %s panicked: %v\n%s", call.Method, p, debug.Stack())
+			err = grpcErrorf(grpcInternal, "internal error")
+		}
+	}()
+	log.Printf("gRPC %s started service=%q peer=%s request_id=%s", call.Method, service, clientIP(call.Request), call.RequestID)
+	rc := http.NewResponseController(w)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	last := -1
+	for {
+		if status := s.healthStatus(ctx, service); status != last {
+			var e protoEncoder
+			e.Int64(1, int64(status))
+			if err := writeGRPCMessage(w, e); err != nil {
+				return err
+			}
+			if err := rc.Flush(); err != nil {
+				return err
+			}
+			last = status
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.done:
+			if last != healthNotServing && last != healthServiceUnknown {
+				var e protoEncoder
+				e.Int64(1, healthNotServing)
+				_ = writeGRPCMessage(w, e)
+			}
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
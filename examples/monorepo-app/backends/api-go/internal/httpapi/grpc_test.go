@@ -0,0 +1,425 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/auth"
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+)
+
+// The byte vectors below are hand-encoded from proto/raijin/v1/raijin.proto:
+// a key is field<<3 | wire type, strings and messages are wire type 2 with
+// a varint length, int64 and bool are varints.
+
+// created is 1700000000, a varint of 80 e2 cf aa 06.
+var created = time.Unix(1700000000, 0)
+
+func TestEncodeUser(t *testing.T) {
+	u := &User{ID: "u1", Email: "a@b.c", Role: "admin", CreatedAt: created}
+	want := []byte{
+		0x0a, 2, 'u', '1', // id = 1
+		0x12, 5, 'a', '@', 'b', '.', 'c', // email = 2
+		// name = 3 is empty and omitted
+		0x22, 5, 'a', 'd', 'm', 'i', 'n', // role = 4
+		0x28, 0x80, 0xe2, 0xcf, 0xaa, 0x06, // created_at = 5
+		0x30, 0x80, 0xe2, 0xcf, 0xaa, 0x06, // updated_at = 6
+	}
+	u.UpdatedAt = created
+	if got := encodeUser(u); !bytes.Equal(got, want) {
+		t.Errorf("got  % x\nwant % x", got, want)
+	}
+}
+
+func TestProtoEncoder(t *testing.T) {
+	tests := []struct {
+		name string
+		enc  func(e *protoEncoder)
+		want []byte
+	}{
+		{"empty string omitted", func(e *protoEncoder) { e.String(1, "") }, nil},
+		{"zero int omitted", func(e *protoEncoder) { e.Int64(3, 0) }, nil},
+		{"false omitted", func(e *protoEncoder) { e.Bool(1, false) }, nil},
+		{"empty message kept", func(e *protoEncoder) { e.Bytes(2, nil) }, []byte{0x12, 0}},
+		{"bool", func(e *protoEncoder) { e.Bool(1, true) }, []byte{0x08, 1}},
+		{"int64", func(e *protoEncoder) { e.Int64(2, 300) }, []byte{0x10, 0xac, 0x02}},
+		// Negative int64 values take ten bytes, as in proto3.
+		{"negative int64", func(e *protoEncoder) { e.Int64(4, -1) },
+			[]byte{0x20, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{"field 16 needs a two-byte key", func(e *protoEncoder) { e.String(16, "x") }, []byte{0x82, 0x01, 1, 'x'}},
+		{"long string", func(e *protoEncoder) { e.String(1, strings.Repeat("a", 200)) },
+			append([]byte{0x0a, 0xc8, 0x01}, strings.Repeat("a", 200)...)},
+		// ValidateTokenResponse{valid, user_id, role, exp}
+		{"ValidateTokenResponse", func(e *protoEncoder) {
+			e.Bool(1, true)
+			e.String(2, "u1")
+			e.String(3, "user")
+			e.Int64(4, 1700000000)
+		}, []byte{0x08, 1, 0x12, 2, 'u', '1', 0x1a, 4, 'u', 's', 'e', 'r', 0x20, 0x80, 0xe2, 0xcf, 0xaa, 0x06}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var e protoEncoder
+			tt.enc(&e)
+			if !bytes.Equal(e, tt.want) {
+				t.Errorf("got  % x\nwant % x", []byte(e), tt.want)
+			}
+		})
+	}
+}
+
+// protoField is one field seen by decodeProto.
+type protoField struct {
+	field int
+	v     uint64
+	data  string
+}
+
+func TestDecodeProto(t *testing.T) {
+	msg := []byte{
+		0x08, 0x96, 0x01, // 1: varint 150
+		0x12, 3, 'a', 'b', 'c', // 2: bytes
+		0x19, 1, 0, 0, 0, 0, 0, 0, 0, // 3: fixed64 1
+		0x25, 2, 0, 0, 0, // 4: fixed32 2
+		0x2a, 0, // 5: empty bytes
+		0x82, 0x01, 1, 'x', // 16: bytes
+	}
+	var got []protoField
+	err := decodeProto(msg, func(field int, v uint64, data []byte) {
+		got = append(got, protoField{field, v, string(data)})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []protoField{{1, 150, ""}, {2, 0, "abc"}, {3, 1, ""}, {4, 2, ""}, {5, 0, ""}, {16, 0, "x"}}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("field %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	for name, b := range map[string][]byte{
+		"truncated key":         {0x80},
+		"truncated varint":      {0x08, 0x96},
+		"length past the end":   {0x0a, 5, 'a'},
+		"huge length":           {0x0a, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01},
+		"truncated fixed64":     {0x09, 1, 2, 3},
+		"truncated fixed32":     {0x0d, 1},
+		"start group":           {0x0b},
+		"end group":             {0x0c},
+		"invalid wire type 6":   {0x0e},
+		"garbage after a field": {0x08, 1, 0xff},
+	} {
+		if err := decodeProto(b, func(int, uint64, []byte) {}); !errors.Is(err, errProtoMalformed) {
+			t.Errorf("%s: got %v, want %v", name, err, errProtoMalformed)
+		}
+	}
+}
+
+func TestDecodeStringField(t *testing.T) {
+	// GetUserRequest{id = "u1"} with an unknown varint field before it.
+	s, err := decodeStringField([]byte{0x10, 7, 0x0a, 2, 'u', '1'}, 1)
+	if err != nil || s != "u1" {
+		t.Errorf("got %q, %v", s, err)
+	}
+	if s, err := decodeStringField(nil, 1); err != nil || s != "" {
+		t.Errorf("empty message: got %q, %v", s, err)
+	}
+	_, err = decodeStringField([]byte{0x0a, 9, 'u'}, 1)
+	if code, _ := grpcStatus(err); code != grpcInvalidArgument {
+		t.Errorf("malformed: got %v, want InvalidArgument", err)
+	}
+}
+
+func TestGRPCFraming(t *testing.T) {
+	rec := httptest.NewRecorder()
+	if err := writeGRPCMessage(rec, []byte{0x0a, 1, 'x'}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0, 0, 0, 0, 3, 0x0a, 1, 'x'}; !bytes.Equal(rec.Body.Bytes(), want) {
+		t.Errorf("frame % x, want % x", rec.Body.Bytes(), want)
+	}
+	msg, err := readGRPCMessage(bytes.NewReader(rec.Body.Bytes()))
+	if err != nil || !bytes.Equal(msg, []byte{0x0a, 1, 'x'}) {
+		t.Errorf("read back % x, %v", msg, err)
+	}
+
+	for name, tt := range map[string]struct {
+		frame []byte
+		code  int
+	}{
+		"empty":          {nil, grpcInvalidArgument},
+		"short prefix":   {[]byte{0, 0, 0}, grpcInvalidArgument},
+		"short message":  {[]byte{0, 0, 0, 0, 5, 1}, grpcInvalidArgument},
+		"compressed":     {[]byte{1, 0, 0, 0, 0}, grpcUnimplemented},
+		"over the limit": {[]byte{0, 0x7f, 0xff, 0xff, 0xff}, grpcResourceExhausted},
+	} {
+		_, err := readGRPCMessage(bytes.NewReader(tt.frame))
+		if code, _ := grpcStatus(err); code != tt.code {
+			t.Errorf("%s: got %v, want %s", name, err, grpcCodeNames[tt.code])
+		}
+	}
+}
+
+func TestParseGRPCTimeout(t *testing.T) {
+	for v, want := range map[string]time.Duration{
+		"100m": 100 * time.Millisecond, "5S": 5 * time.Second, "1H": time.Hour, "2M": 2 * time.Minute,
+		"7u": 7 * time.Microsecond, "9n": 9, "99999999S": 99999999 * time.Second,
+	} {
+		if got, ok := parseGRPCTimeout(v); !ok || got != want {
+			t.Errorf("%q: got %v, %v", v, got, ok)
+		}
+	}
+	for _, v := range []string{"", "5", "S", "5s", "-1S", "1.5S", "123456789S"} {
+		if got, ok := parseGRPCTimeout(v); ok {
+			t.Errorf("%q: got %v, want invalid", v, got)
+		}
+	}
+}
+
+func TestGRPCEncodeMessage(t *testing.T) {
+	if got := grpcEncodeMessage("100% naïve\n"); got != "100%25 na%C3%AFve%0A" {
+		t.Errorf("got %q", got)
+	}
+}
+
+// grpcClient calls s.GRPC over cleartext HTTP/2, as a gRPC client does.
+type grpcClient struct {
+	t      *testing.T
+	url    string
+	client *http.Client
+}
+
+func newGRPCClient(t *testing.T, s *Server) *grpcClient {
+	t.Helper()
+	if s.GRPC == nil {
+		t.Fatal("gRPC is disabled")
+	}
+	// GRPC_ADDR serves h2c only; HTTP/1 is on here to reach the handler's
+	// own refusal.
+	ts := httptest.NewUnstartedServer(s.GRPC)
+	ts.Config.Protocols = new(http.Protocols)
+	ts.Config.Protocols.SetUnencryptedHTTP2(true)
+	ts.Config.Protocols.SetHTTP1(true)
+	ts.Start()
+	t.Cleanup(ts.Close)
+	tr := &http.Transport{Protocols: new(http.Protocols)}
+	tr.Protocols.SetUnencryptedHTTP2(true)
+	t.Cleanup(tr.CloseIdleConnections)
+	return &grpcClient{t: t, url: ts.URL, client: &http.Client{Transport: tr}}
+}
+
+// call makes a unary call with metadata (name, value pairs) and returns
+// the response message and the grpc-status trailer.
+func (c *grpcClient) call(method string, req []byte, metadata ...string) ([]byte, int, string) {
+	c.t.Helper()
+	frame := make([]byte, 5, 5+len(req))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(req)))
+	r, _ := http.NewRequest("POST", c.url+method, bytes.NewReader(append(frame, req...)))
+	r.Header.Set("Content-Type", "application/grpc")
+	r.Header.Set("TE", "trailers")
+	for i := 0; i+1 < len(metadata); i += 2 {
+		r.Header.Set(metadata[i], metadata[i+1])
+	}
+	resp, err := c.client.Do(r)
+	if err != nil {
+		c.t.Fatalf("%s: %v", method, err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		c.t.Fatalf("%s: served over %s", method, resp.Proto)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/grpc" {
+		c.t.Fatalf("%s: content type %q", method, ct)
+	}
+	var msg []byte
+	if data, _ := io.ReadAll(resp.Body); len(data) > 0 {
+		if msg, err = readGRPCMessage(bytes.NewReader(data)); err != nil {
+			c.t.Fatalf("%s: %v in % x", method, err, data)
+		}
+	}
+	code, err := strconv.Atoi(resp.Trailer.Get("Grpc-Status"))
+	if err != nil {
+		c.t.Fatalf("%s: grpc-status trailer %q", method, resp.Trailer.Get("Grpc-Status"))
+	}
+	return msg, code, resp.Trailer.Get("Grpc-Message")
+}
+
+// TestGRPCEndToEnd makes calls over HTTP/2 and decodes the answers with
+// the field numbers of the schema.
+func TestGRPCEndToEnd(t *testing.T) {
+	st := store.NewMemory()
+	s, _ := openAPIServer(t, st, func(c *config.Config) { c.GRPCAddr = "127.0.0.1:0" })
+	c := newGRPCClient(t, s)
+
+	admin, err := st.CreateUser("grpc-admin@example.com", "Admin", "grpc-password", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := st.CreateUser("grpc-user@example.com", "User", "grpc-password", "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := func(u *api.User) string {
+		jwt, err := auth.CreateJWT("openapi-test-jwt-secret-not-for-production", auth.Claims{
+			UserID: u.ID, Email: u.Email, Role: u.Role,
+			Exp: time.Now().Add(time.Hour).Unix(), Iat: time.Now().Unix(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return jwt
+	}
+	adminAuth := []string{"Authorization", "Bearer " + token(admin)}
+	request := func(field int, s string) []byte {
+		var e protoEncoder
+		e.String(field, s)
+		return e
+	}
+
+	t.Run("ValidateToken", func(t *testing.T) {
+		jwt := token(user)
+		msg, code, _ := c.call("/raijin.v1.AuthService/ValidateToken", request(1, jwt))
+		if code != grpcOK {
+			t.Fatalf("status %s", grpcCodeNames[code])
+		}
+		got := map[int]any{}
+		if err := decodeProto(msg, func(field int, v uint64, data []byte) {
+			if data != nil {
+				got[field] = string(data)
+			} else {
+				got[field] = v
+			}
+		}); err != nil {
+			t.Fatal(err)
+		}
+		claims, _ := auth.VerifyJWT("openapi-test-jwt-secret-not-for-production", jwt)
+		if got[1] != uint64(1) || got[2] != user.ID || got[3] != "user" || got[4] != uint64(claims.Exp) || got[5] != nil {
+			t.Errorf("got %v", got)
+		}
+
+		msg, code, _ = c.call("/raijin.v1.AuthService/ValidateToken", request(1, "not-a-token"))
+		if reason, _ := decodeStringField(msg, 5); code != grpcOK || reason != "invalid" {
+			t.Errorf("invalid token: status %s, reason %q", grpcCodeNames[code], reason)
+		}
+	})
+
+	t.Run("GetUser", func(t *testing.T) {
+		if _, code, _ := c.call("/raijin.v1.UserService/GetUser", request(1, user.ID)); code != grpcUnauthenticated {
+			t.Errorf("without a token: %s", grpcCodeNames[code])
+		}
+		if _, code, _ := c.call("/raijin.v1.UserService/GetUser", request(1, user.ID), "Authorization", "Bearer "+token(user)); code != grpcPermissionDenied {
+			t.Errorf("as a user: %s", grpcCodeNames[code])
+		}
+		msg, code, _ := c.call("/raijin.v1.UserService/GetUser", request(1, user.ID), adminAuth...)
+		if code != grpcOK {
+			t.Fatalf("status %s", grpcCodeNames[code])
+		}
+		stored, _ := st.GetUserByID(user.ID)
+		if want := encodeUser(stored); !bytes.Equal(msg, want) {
+			t.Errorf("got  % x\nwant % x", msg, want)
+		}
+		if email, _ := decodeStringField(msg, 2); email != user.Email {
+			t.Errorf("email %q", email)
+		}
+		if _, code, _ := c.call("/raijin.v1.UserService/GetUser", request(1, "nobody"), adminAuth...); code != grpcNotFound {
+			t.Errorf("unknown id: %s", grpcCodeNames[code])
+		}
+		if _, code, _ := c.call("/raijin.v1.UserService/GetUser", nil, adminAuth...); code != grpcInvalidArgument {
+			t.Errorf("no id: %s", grpcCodeNames[code])
+		}
+		if _, code, _ := c.call("/raijin.v1.UserService/GetUser", []byte{0x0a, 9}, adminAuth...); code != grpcInvalidArgument {
+			t.Errorf("malformed request: %s", grpcCodeNames[code])
+		}
+	})
+
+	t.Run("ListUsers", func(t *testing.T) {
+		msg, code, _ := c.call("/raijin.v1.UserService/ListUsers", nil, adminAuth...)
+		if code != grpcOK {
+			t.Fatalf("status %s", grpcCodeNames[code])
+		}
+		var emails []string
+		var total uint64
+		if err := decodeProto(msg, func(field int, v uint64, data []byte) {
+			switch field {
+			case 1:
+				email, _ := decodeStringField(data, 2)
+				emails = append(emails, email)
+			case 2:
+				total = v
+			}
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(st.ListUsers()); len(emails) != n || total != uint64(n) {
+			t.Errorf("%d users and total %d, want %d: %v", len(emails), total, n, emails)
+		}
+	})
+
+	t.Run("Introspect", func(t *testing.T) {
+		msg, code, _ := c.call("/raijin.v1.UserService/Introspect", request(1, token(user)), adminAuth...)
+		if code != grpcOK {
+			t.Fatalf("status %s", grpcCodeNames[code])
+		}
+		var active bool
+		var id string
+		_ = decodeProto(msg, func(field int, v uint64, data []byte) {
+			switch field {
+			case 1:
+				active = v == 1
+			case 2:
+				id, _ = decodeStringField(data, 1)
+			}
+		})
+		if !active || id != user.ID {
+			t.Errorf("active %v, user %q", active, id)
+		}
+		// An inactive token is an empty message, not an error.
+		if msg, code, _ := c.call("/raijin.v1.UserService/Introspect", request(1, "not-a-token"), adminAuth...); code != grpcOK || len(msg) != 0 {
+			t.Errorf("inactive: status %s, % x", grpcCodeNames[code], msg)
+		}
+	})
+
+	t.Run("Health", func(t *testing.T) {
+		for service, want := range map[string]uint64{"": healthServing, "raijin.v1.UserService": healthServing} {
+			msg, code, _ := c.call(grpcHealthCheck, request(1, service))
+			var status uint64
+			_ = decodeProto(msg, func(field int, v uint64, _ []byte) { status = v })
+			if code != grpcOK || status != want {
+				t.Errorf("%q: status %s, serving status %d", service, grpcCodeNames[code], status)
+			}
+		}
+		if _, code, _ := c.call(grpcHealthCheck, request(1, "nope.Service")); code != grpcNotFound {
+			t.Errorf("unknown service: %s", grpcCodeNames[code])
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, code, msg := c.call("/raijin.v1.UserService/DeleteUser", nil, adminAuth...)
+		if code != grpcUnimplemented || !strings.Contains(msg, "DeleteUser") {
+			t.Errorf("unknown method: %s %q", grpcCodeNames[code], msg)
+		}
+		// HTTP/1.1 is refused before any call.
+		resp, err := http.Post(c.url+"/raijin.v1.AuthService/ValidateToken", "application/grpc", bytes.NewReader(make([]byte, 5)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnsupportedMediaType {
+			t.Errorf("HTTP/1.1: status %d", resp.StatusCode)
+		}
+	})
+}
@@ -0,0 +1,954 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/auth"
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+	"github.com/your-org/your-app/backends/api-go/internal/saml"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+)
+
+type Handlers struct {
+	cfg          *config.Config
+	store        store.Store
+	maintenance  *Maintenance
+	checks       *Checks
+	drain        *Drain
+	lifecycle    *Lifecycle
+	outbound     *Outbound
+	features     *Features
+	stats        *StatsCache
+	events       *EventBus
+	mail         *MailQueue
+	emails       *EmailTemplates
+	saml         *saml.SP          // nil: SAML login off
+	loginFails   *RateLimiter      // failed logins per email; see Login
+	captcha      ChallengeProvider // nil: CAPTCHA off
+	captchaFails *RateLimiter      // failed logins per IP and email, for the CAPTCHA; nil when off
+	exports      *DataExports
+	sms          SMSSender          // nil: SMS off, and with it the phone login
+	otpPhone     *RateLimiter       // one-time code requests per phone; see otpLimited
+	otpIP        *RateLimiter       // one-time code requests per IP
+	pwned        *PwnedPasswords    // nil: BREACH_CHECK off
+	disposable   *DisposableDomains // nil: DISPOSABLE_EMAIL_ACTION off
+	roles        *RoleCatalog
+}
+
+// HandlerDeps is what Handlers serve with. Config, Store and the
+// components New always builds are required; the ones noted are nil when
+// their feature is off.
+type HandlerDeps struct {
+	Config       *config.Config
+	Store        store.Store
+	Maintenance  *Maintenance
+	Checks       *Checks
+	Drain        *Drain
+	Lifecycle    *Lifecycle
+	Outbound     *Outbound
+	Features     *Features
+	Stats        *StatsCache
+	Events       *EventBus
+	Mail         *MailQueue
+	Emails       *EmailTemplates
+	Roles        *RoleCatalog
+	Exports      *DataExports
+	SAML         *saml.SP           // nil: SAML login off
+	Captcha      ChallengeProvider  // nil: CAPTCHA off
+	SMS          SMSSender          // nil: SMS off
+	Pwned        *PwnedPasswords    // nil: BREACH_CHECK off
+	Disposable   *DisposableDomains // nil: DISPOSABLE_EMAIL_ACTION off
+	LoginFails   *RateLimiter       // failed logins per email
+	CaptchaFails *RateLimiter       // failed logins per IP and email; nil when the CAPTCHA is off
+	OTPPhone     *RateLimiter       // one-time code requests per phone
+	OTPIP        *RateLimiter       // one-time code requests per IP
+}
+
+func NewHandlers(d HandlerDeps) *Handlers {
+	return &Handlers{
+		cfg: d.Config, store: d.Store, maintenance: d.Maintenance, checks: d.Checks, drain: d.Drain,
+		lifecycle: d.Lifecycle, outbound: d.Outbound, features: d.Features, stats: d.Stats, events: d.Events,
+		mail: d.Mail, emails: d.Emails, roles: d.Roles, exports: d.Exports, saml: d.SAML, captcha: d.Captcha,
+		sms: d.SMS, pwned: d.Pwned, disposable: d.Disposable, loginFails: d.LoginFails,
+		captchaFails: d.CaptchaFails, otpPhone: d.OTPPhone, otpIP: d.OTPIP,
+	}
+}
+
+// sendEmail renders data in lang and queues it for to. Templates are
+// checked at startup, so a render error here is only logged.
+func (h *Handlers) sendEmail(ctx context.Context, to, lang string, data EmailData) {
+	msg, err := h.emails.Render(lang, data)
+	if err != nil {
+		log.Printf("ERROR email %s: %v", data.EmailKind(), err)
+		return
+	}
+	msg.To = []string{to}
+	h.mail.Enqueue(ctx, data.EmailKind(), msg)
+}
+
+// Health reports liveness. Degraded still answers 200, and so does a
+// server still warming up; the verbose payload (build, runtime, per-check
+// details, warmup tasks and outbound circuit breakers) is only returned
+// to admins.
+func (h *Handlers) Health(w http.ResponseWriter, r *http.Request) {
+	results, status := h.checks.Run(r.Context())
+	resp := HealthResponse{
+		Status: status, Version: buildInfo().Version,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Uptime:    time.Since(startTime).Round(time.Second).String(),
+	}
+	if st := h.maintenance.Status(); st.Enabled {
+		resp.Maintenance = &st
+	}
+	resp.Draining = h.drain.Draining()
+	if r.URL.Query().Get("verbose") == "1" && h.isAdmin(r) {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		build := buildInfo()
+		resp.Build = &build
+		resp.Environment = h.cfg.Environment
+		resp.Goroutines = runtime.NumGoroutine()
+		resp.Memory = &MemoryStats{
+			AllocBytes: ms.Alloc, TotalAllocBytes: ms.TotalAlloc, SysBytes: ms.Sys,
+			HeapObjects: ms.HeapObjects, NumGC: ms.NumGC,
+		}
+		resp.Checks = results
+		resp.Warmup = h.lifecycle.warmupTasks()
+		resp.Outbound = h.outbound.Status()
+	}
+	code := http.StatusOK
+	if status == StatusUnhealthy {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, resp)
+}
+
+// Version reports build information. It is public and cheap, for deploy
+// tooling that needs to know what is running.
+func (h *Handlers) Version(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, buildInfo())
+}
+
+// OpenAPI serves the OpenAPI document describing this API.
+func (h *Handlers) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSONCached(w, r, openAPIDoc())
+}
+
+// PreviewEmail renders a message type with its sample data, for template
+// work (development only): /dev/emails/ lists them; /dev/emails/{kind}
+// takes ?lang= and ?format=text.
+func (h *Handlers) PreviewEmail(w http.ResponseWriter, r *http.Request) {
+	kind := r.PathValue("kind")
+	if kind == "" {
+		var b strings.Builder
+		b.WriteString("<!DOCTYPE html><title>Emails</title><ul>")
+		for _, kind := range slices.Sorted(maps.Keys(emailSamples)) {
+			fmt.Fprintf(&b, "<li>%s:", htmltemplate.HTMLEscapeString(kind))
+			for _, tag := range h.emails.Languages(kind) {
+				q := url.Values{"lang": {tag}}.Encode()
+				fmt.Fprintf(&b, ` <a href="%[1]s?%[2]s">%[3]s</a> (<a href="%[1]s?%[2]s&amp;format=text">text</a>)`,
+					url.PathEscape(kind), q, htmltemplate.HTMLEscapeString(tag))
+			}
+			b.WriteString("</li>")
+		}
+		b.WriteString("</ul>")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = io.WriteString(w, b.String())
+		return
+	}
+	sample, ok := emailSamples[kind]
+	if !ok {
+		writeErrorCode(w, r, http.StatusNotFound, api.ErrCodeNotFound, "no email type "+kind)
+		return
+	}
+	msg, err := h.emails.Render(r.URL.Query().Get("lang"), sample)
+	if err != nil {
+		writeErrorCode(w, r, http.StatusInternalServerError, api.ErrCodeInternal, err.Error())
+		return
+	}
+	if r.URL.Query().Get("format") == "text" || msg.HTML == "" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = io.WriteString(w, "Subject: "+msg.Subject+"\n\n"+msg.Text)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = io.WriteString(w, msg.HTML)
+}
+
+// Ready reports readiness: 503 while the warmup runs, or if a fatal
+// warmup task failed, and while a critical check fails.
+func (h *Handlers) Ready(w http.ResponseWriter, r *http.Request) {
+	if done, err := h.lifecycle.warmupState(); !done {
+		writeJSON(w, http.StatusServiceUnavailable, ReadyResponse{Status: "warming up"})
+		return
+	} else if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, ReadyResponse{Status: "warmup failed"})
+		return
+	}
+	results, status := h.checks.Run(r.Context())
+	if status == StatusUnhealthy {
+		writeJSON(w, http.StatusServiceUnavailable, ReadyResponse{Status: "not ready", Checks: results})
+		return
+	}
+	if h.maintenance.Status().Enabled {
+		writeJSON(w, http.StatusOK, ReadyResponse{Status: "maintenance", Checks: results})
+		return
+	}
+	writeJSON(w, http.StatusOK, ReadyResponse{Status: "ready", Checks: results})
+}
+
+// isAdmin reports whether r carries an admin token that Auth would accept:
+// not revoked, of an admin neither suspended nor leaving. It is used on
+// public routes that reveal extra detail to admins instead of rejecting
+// others.
+func (h *Handlers) isAdmin(r *http.Request) bool {
+	claims, err := bearerClaims(r, h.cfg)
+	if err != nil || claims.Role != "admin" {
+		return false
+	}
+	_, code, _ := rejectClaims(h.store, h.cfg, claims, r.URL.Path)
+	return code == ""
+}
+
+func (h *Handlers) SetMaintenance(w http.ResponseWriter, r *http.Request) {
+	var req MaintenanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorCode(w, r, http.StatusBadRequest, api.ErrCodeInvalidRequest, "invalid request body")
+		return
+	}
+	h.maintenance.Set(req.Enabled, req.Message)
+	st := h.maintenance.Status()
+	log.Printf("Maintenance mode enabled=%t by user=%v", st.Enabled, r.Context().Value(ctxUserID))
+	AdminAction.Publish(eventContext(r), h.events, AdminActionEvent{
+		Action: "maintenance", Details: map[string]string{"enabled": strconv.FormatBool(st.Enabled)},
+	})
+	respond(w, r, http.StatusOK, st)
+}
+
+// passwordTooLong explains auth.MaxPasswordBytes: past it bcrypt would
+// ignore the rest, so a longer password is refused rather than cut.
+const passwordTooLong = "must be at most 72 bytes (characters outside ASCII take 2 to 4 bytes each)"
+
+func (h *Handlers) Register(w http.ResponseWriter, r *http.Request) {
+	var req RegisterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorCode(w, r, http.StatusBadRequest, api.ErrCodeInvalidRequest, "invalid request body")
+		return
+	}
+	var fields []FieldError
+	for _, f := range [][2]string{{"email", req.Email}, {"name", req.Name}, {"password", req.Password}} {
+		if f[1] == "" {
+			fields = append(fields, FieldError{Field: f[0], Message: "is required"})
+		}
+	}
+	if len(fields) > 0 {
+		writeErrorFields(w, r, http.StatusBadRequest, api.ErrCodeValidationFailed, "email, name and password are required", fields)
+		return
+	}
+	email, ok := checkEmail(w, r, req.Email)
+	if !ok {
+		return
+	}
+	req.Email = email
+	name, problem := cleanText(req.Name, maxNameLen)
+	if problem != "" {
+		writeErrorFields(w, r, http.StatusBadRequest, api.ErrCodeValidationFailed, "invalid name",
+			[]FieldError{{Field: "name", Message: problem}})
+		return
+	}
+	req.Name = name
+	if !h.registrationDomainAllowed(req.Email) {
+		RegistrationFailed.Publish(eventContext(r), h.events, AuthFailureEvent{Email: req.Email, Reason: "email_domain"})
+		writeErrorCode(w, r, http.StatusForbidden, api.ErrCodeEmailDomain, "registration is not open to this email domain")
+		return
+	}
+	disposable := h.disposable != nil && h.disposable.Contains(emailDomain(req.Email))
+	if disposable && h.cfg.Disposable.Action == "reject" {
+		RegistrationFailed.Publish(eventContext(r), h.events, AuthFailureEvent{Email: req.Email, Reason: "disposable_email"})
+		writeErrorCode(w, r, http.StatusForbidden, api.ErrCodeEmailDisposable, "disposable email addresses are not accepted")
+		return
+	}
+	if h.cfg.Terms.Enabled() && !req.AcceptTerms {
+		writeErrorFields(w, r, http.StatusBadRequest, api.ErrCodeValidationFailed, "the terms of service and privacy policy must be accepted",
+			[]FieldError{{Field: "accept_terms", Message: "must be true"}})
+		return
+	}
+	if len(req.Password) < 8 {
+		writeErrorFields(w, r, http.StatusBadRequest, api.ErrCodeValidationFailed, "password must be at least 8 characters",
+			[]FieldError{{Field: "password", Message: "must be at least 8 characters"}})
+		return
+	}
+	if len(req.Password) > auth.MaxPasswordBytes {
+		writeErrorFields(w, r, http.StatusBadRequest, api.ErrCodeValidationFailed, "password must be at most 72 bytes",
+			[]FieldError{{Field: "password", Message: passwordTooLong}})
+		return
+	}
+	warning, ok := h.checkPwned(w, r, req.Password)
+	if !ok {
+		RegistrationFailed.Publish(eventContext(r), h.events, AuthFailureEvent{Email: req.Email, Reason: "password_breached"})
+		return
+	}
+	// Checked last: tokens are single-use, and a validation error would
+	// otherwise make the user solve the challenge again.
+	if h.captcha != nil && h.cfg.Captcha.Register &&
+		!h.challenge(w, r, req.CaptchaToken, RegistrationFailed, AuthFailureEvent{Email: req.Email}) {
+		return
+	}
+	user, err := h.store.CreateUser(req.Email, req.Name, req.Password, "user")
+	if errors.Is(err, store.ErrEmailTaken) {
+		RegistrationFailed.Publish(eventContext(r), h.events, AuthFailureEvent{Email: req.Email, Reason: "email_taken"})
+		writeErrorCode(w, r, http.StatusConflict, api.ErrCodeEmailTaken, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR store: create user: %v (request_id=%s)", err, r.Header.Get("X-Request-ID"))
+		writeErrorCode(w, r, http.StatusInternalServerError, api.ErrCodeInternal, "failed to create user")
+		return
+	}
+	if disposable {
+		// Clip: the copy UpdateUser hands fn shares the slice with readers.
+		user, err = h.store.UpdateUser(user.ID, func(u *User) { u.Flags = append(slices.Clip(u.Flags), api.UserFlagDisposableEmail) })
+		if err != nil {
+			writeUserError(w, r, err)
+			return
+		}
+	}
+	UserRegistered.Publish(eventContext(r), h.events, UserEvent{User: *user})
+	if h.cfg.Terms.Enabled() {
+		if user, err = h.acceptTerms(r, user.ID); err != nil {
+			writeUserError(w, r, err)
+			return
+		}
+	}
+	h.noteDevice(r, user, false)
+	resp, err := h.issueTokens(user, nil)
+	if err != nil {
+		writeIssueError(w, r, err)
+		return
+	}
+	resp.PasswordWarning = warning
+	respond(w, r, http.StatusCreated, resp)
+}
+
+// Login checks email and password (see checkCredentials) and signs the
+// user in unless the account is suspended or pending deletion.
+func (h *Handlers) Login(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.checkCredentials(w, r)
+	if !ok || !h.loginAllowed(w, r, user) {
+		return
+	}
+	LoggedIn.Publish(eventContext(r), h.events, UserEvent{User: *user})
+	h.noteDevice(r, user, true)
+	if err := h.respondAuth(w, r, http.StatusOK, user, nil); err != nil {
+		writeIssueError(w, r, err)
+	}
+}
+
+// loginAllowed reports whether user, who just proved who they are, may
+// log in; when not, the account is suspended or pending deletion, and it
+// answers r with 403, or it is maintenance time (see closedForMaintenance).
+func (h *Handlers) loginAllowed(w http.ResponseWriter, r *http.Request, user *User) bool {
+	if h.closedForMaintenance(w, r, user.Role) {
+		return false
+	}
+	if user.Suspended {
+		LoginFailed.Publish(eventContext(r), h.events, AuthFailureEvent{UserID: user.ID, Email: user.Email, Reason: "suspended"})
+		writeErrorCode(w, r, http.StatusForbidden, api.ErrCodeAccountSuspended, "account suspended")
+		return false
+	}
+	if user.PendingDeletion() {
+		LoginFailed.Publish(eventContext(r), h.events, AuthFailureEvent{UserID: user.ID, Email: user.Email, Reason: "pending_deletion"})
+		writeErrorCode(w, r, http.StatusForbidden, api.ErrCodeAccountDeleting, "account scheduled for deletion; cancel the deletion to log in")
+		return false
+	}
+	return true
+}
+
+// closedForMaintenance answers r with the maintenance 503 when
+// maintenance mode is on and role is not admin. MaintenanceMode lets login
+// and refresh through so admins can still get a token to turn it off.
+func (h *Handlers) closedForMaintenance(w http.ResponseWriter, r *http.Request, role string) bool {
+	st := h.maintenance.Status()
+	if !st.Enabled || role == "admin" {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(maintenanceRetryAfter))
+	writeErrorCode(w, r, http.StatusServiceUnavailable, api.ErrCodeMaintenance, st.Message)
+	return true
+}
+
+// checkCredentials reads a LoginRequest and returns its user when the
+// password matches; otherwise it answers r and returns false. Besides the
+// per-IP auth bucket, failed attempts are limited per email
+// (LOGIN_FAILURE_LIMIT per LOGIN_FAILURE_WINDOW), which catches credential
+// stuffing spread over many IPs. Known and unknown emails count alike and
+// get the same 429, so the limit says nothing about which accounts exist; a
+// success clears it. After CAPTCHA_LOGIN_AFTER failures from the IP or for
+// the email, requests must also carry a solved captcha_token. Emails that
+// are not addresses get 400 invalid_email_format.
+func (h *Handlers) checkCredentials(w http.ResponseWriter, r *http.Request) (*User, bool) {
+	var req LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorCode(w, r, http.StatusBadRequest, api.ErrCodeInvalidRequest, "invalid request body")
+		return nil, false
+	}
+	email, ok := checkEmail(w, r, req.Email)
+	if !ok {
+		return nil, false
+	}
+	req.Email = email
+	emailKey := strings.ToLower(req.Email)
+	if over, retry := h.loginFails.exceeded(emailKey); over {
+		h.loginFails.reject(w, r, retry)
+		return nil, false
+	}
+	if h.loginNeedsChallenge(r, emailKey) &&
+		!h.challenge(w, r, req.CaptchaToken, LoginFailed, AuthFailureEvent{Email: req.Email}) {
+		return nil, false
+	}
+	user, err := h.store.GetUserByEmail(req.Email)
+	if err != nil && !errors.Is(err, store.ErrUserNotFound) {
+		writeUserError(w, r, err)
+		return nil, false
+	}
+	if err != nil {
+		h.loginFailed(r, emailKey, "")
+		LoginFailed.Publish(eventContext(r), h.events, AuthFailureEvent{Email: req.Email, Reason: "unknown_email"})
+		writeErrorCode(w, r, http.StatusUnauthorized, api.ErrCodeInvalidCredentials, "invalid credentials")
+		return nil, false
+	}
+	if err := auth.CheckPassword(user.Password, req.Password); err != nil {
+		h.loginFailed(r, emailKey, user.ID)
+		LoginFailed.Publish(eventContext(r), h.events, AuthFailureEvent{UserID: user.ID, Email: user.Email, Reason: "bad_password"})
+		writeErrorCode(w, r, http.StatusUnauthorized, api.ErrCodeInvalidCredentials, "invalid credentials")
+		return nil, false
+	}
+	h.loginFails.reset(emailKey)
+	if h.captchaFails != nil {
+		h.captchaFails.reset("email:" + emailKey)
+	}
+	return user, true
+}
+
+// loginFailed counts a failed login against the per-email limit and
+// towards the CAPTCHA challenge. userID is the account with the email, ""
+// when there is none; the lockout listing reports it.
+func (h *Handlers) loginFailed(r *http.Request, emailKey, userID string) {
+	h.loginFails.note(emailKey, clientIP(r), userID)
+	if h.captchaFails != nil {
+		h.captchaFails.add("ip:" + clientIP(r))
+		h.captchaFails.add("email:" + emailKey)
+	}
+}
+
+func (h *Handlers) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorCode(w, r, http.StatusBadRequest, api.ErrCodeInvalidRequest, "invalid request body")
+		return
+	}
+	sess, ok := h.store.ValidateRefreshToken(req.RefreshToken)
+	userID := sess.UserID
+	if !ok {
+		TokenRefreshFailed.Publish(eventContext(r), h.events, AuthFailureEvent{Reason: "invalid_token"})
+		writeErrorCode(w, r, http.StatusUnauthorized, api.ErrCodeRefreshInvalid, "invalid refresh token")
+		return
+	}
+	if h.maintenance.Status().Enabled {
+		// Checked before the token is spent, so it still works afterwards.
+		var role string
+		if user, err := h.store.GetUserByID(userID); err == nil {
+			role = user.Role
+		}
+		if h.closedForMaintenance(w, r, role) {
+			return
+		}
+	}
+	h.store.RevokeRefreshToken(req.RefreshToken)
+	if pastLifetime(sess.StartedAt, h.cfg.MaxSessionLifetime) {
+		TokenRefreshFailed.Publish(eventContext(r), h.events, AuthFailureEvent{UserID: userID, Reason: "session_expired"})
+		writeErrorCode(w, r, http.StatusUnauthorized, api.ErrCodeSessionExpired, "session expired, log in again")
+		return
+	}
+	user, err := h.store.GetUserByID(userID)
+	if err != nil && !errors.Is(err, store.ErrUserNotFound) {
+		writeUserError(w, r, err)
+		return
+	}
+	if err != nil {
+		TokenRefreshFailed.Publish(eventContext(r), h.events, AuthFailureEvent{UserID: userID, Reason: "user_not_found"})
+		writeErrorCode(w, r, http.StatusUnauthorized, api.ErrCodeUserNotFound, "user not found")
+		return
+	}
+	if user.Suspended {
+		TokenRefreshFailed.Publish(eventContext(r), h.events, AuthFailureEvent{UserID: userID, Reason: "suspended"})
+		writeErrorCode(w, r, http.StatusForbidden, api.ErrCodeAccountSuspended, "account suspended")
+		return
+	}
+	if user.PendingDeletion() {
+		TokenRefreshFailed.Publish(eventContext(r), h.events, AuthFailureEvent{UserID: userID, Reason: "pending_deletion"})
+		writeErrorCode(w, r, http.StatusForbidden, api.ErrCodeAccountDeleting, "account scheduled for deletion")
+		return
+	}
+	TokenRefreshed.Publish(eventContext(r), h.events, UserEvent{User: *user})
+	if err := h.respondAuth(w, r, http.StatusOK, user, &sess); err != nil {
+		writeIssueError(w, r, err)
+	}
+}
+
+func (h *Handlers) GetCurrentUser(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(ctxUserID).(string)
+	user, err := h.store.GetUserByID(userID)
+	if err != nil {
+		writeUserError(w, r, err)
+		return
+	}
+	writeJSONProjected(w, r, user)
+}
+
+// WhoAmI returns the current user with what the access token of the
+// request says: when it expires, the session it belongs to and how fresh
+// the login behind it is. It reads the claims Auth verified.
+func (h *Handlers) WhoAmI(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(ctxClaims).(*auth.Claims)
+	user, err := h.store.GetUserByID(claims.UserID)
+	if err != nil {
+		writeUserError(w, r, err)
+		return
+	}
+	now := auth.Now()
+	tok := TokenInfo{
+		Credential: r.Context().Value(ctxCredential).(string),
+		IssuedAt:   time.Unix(claims.Iat, 0).UTC(), ExpiresAt: time.Unix(claims.Exp, 0).UTC(),
+		ExpiresIn: max(0, claims.Exp-now.Unix()), Role: claims.Role, SessionID: claims.SessionID,
+		OrgID: claims.OrgID, OrgRole: claims.OrgRole,
+	}
+	if claims.SessionStart != 0 {
+		t := time.Unix(claims.SessionStart, 0).UTC()
+		tok.SessionStartedAt = &t
+	}
+	if claims.AuthTime != 0 {
+		t := time.Unix(claims.AuthTime, 0).UTC()
+		tok.AuthTime = &t
+		tok.Fresh = now.Sub(t) <= h.cfg.ReauthMaxAge
+	}
+	respond(w, r, http.StatusOK, WhoAmI{User: *user, Token: tok})
+}
+
+// writeUserError answers a failed user lookup or update: 404 when the
+// user does not exist, 500 (logged) when the store itself failed.
+func writeUserError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, store.ErrUserNotFound) {
+		writeErrorCode(w, r, http.StatusNotFound, api.ErrCodeUserNotFound, "user not found")
+		return
+	}
+	log.Printf("ERROR store: %v (request_id=%s)", err, r.Header.Get("X-Request-ID"))
+	writeErrorCode(w, r, http.StatusInternalServerError, api.ErrCodeInternal, "internal error")
+}
+
+// flagUnknownRole returns u, or a copy flagged unknown_role when the
+// catalog lacks its role. Users are shared with the store.
+func (h *Handlers) flagUnknownRole(u *User) *User {
+	if h.roles.Known(u.Role) {
+		return u
+	}
+	c := *u
+	c.Flags = append(slices.Clip(c.Flags), api.UserFlagUnknownRole)
+	return &c
+}
+
+// ListUsers lists every user, or with ?pending_deletion=true only the
+// accounts scheduled for deletion.
+func (h *Handlers) ListUsers(w http.ResponseWriter, r *http.Request) {
+	users := h.store.ListUsers()
+	if r.URL.Query().Get("pending_deletion") == "true" {
+		users = slices.DeleteFunc(users, func(u *User) bool { return !u.PendingDeletion() })
+	}
+	for i, u := range users {
+		users[i] = h.flagUnknownRole(u)
+	}
+	if flag := r.URL.Query().Get("flag"); flag != "" {
+		users = slices.DeleteFunc(users, func(u *User) bool { return !slices.Contains(u.Flags, flag) })
+	}
+	writeJSONProjected(w, r, UserList{Users: users, Total: len(users)})
+}
+
+// CreateUser creates an account with any role, without logging it in.
+func (h *Handlers) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var req CreateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorCode(w, r, http.StatusBadRequest, api.ErrCodeInvalidRequest, "invalid request body")
+		return
+	}
+	if req.Role == "" {
+		req.Role = "user"
+	}
+	var fields []FieldError
+	for _, f := range [][2]string{{"email", req.Email}, {"name", req.Name}, {"password", req.Password}} {
+		if f[1] == "" {
+			fields = append(fields, FieldError{Field: f[0], Message: "is required"})
+		}
+	}
+	if name, problem := cleanText(req.Name, maxNameLen); req.Name != "" && problem != "" {
+		fields = append(fields, FieldError{Field: "name", Message: problem})
+	} else {
+		req.Name = name
+	}
+	if req.Password != "" && len(req.Password) < 8 {
+		fields = append(fields, FieldError{Field: "password", Message: "must be at least 8 characters"})
+	} else if len(req.Password) > auth.MaxPasswordBytes {
+		fields = append(fields, FieldError{Field: "password", Message: passwordTooLong})
+	}
+	if len(fields) > 0 {
+		writeErrorFields(w, r, http.StatusBadRequest, api.ErrCodeValidationFailed, "invalid user", fields)
+		return
+	}
+	if !h.knownRole(w, r, req.Role) {
+		return
+	}
+	email, ok := checkEmail(w, r, req.Email)
+	if !ok {
+		return
+	}
+	req.Email = email
+	if _, ok := h.checkPwned(w, r, req.Password); !ok {
+		return
+	}
+	user, err := h.store.CreateUser(req.Email, req.Name, req.Password, req.Role)
+	if errors.Is(err, store.ErrEmailTaken) {
+		writeErrorCode(w, r, http.StatusConflict, api.ErrCodeEmailTaken, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR store: create user: %v (request_id=%s)", err, r.Header.Get("X-Request-ID"))
+		writeErrorCode(w, r, http.StatusInternalServerError, api.ErrCodeInternal, "failed to create user")
+		return
+	}
+	UserRegistered.Publish(eventContext(r), h.events, UserEvent{User: *user})
+	AdminAction.Publish(eventContext(r), h.events, AdminActionEvent{
+		Action: "user_create", Details: map[string]string{"user_id": user.ID, "role": user.Role},
+	})
+	respond(w, r, http.StatusCreated, user)
+}
+
+func (h *Handlers) SetUserRole(w http.ResponseWriter, r *http.Request) {
+	var req SetRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorCode(w, r, http.StatusBadRequest, api.ErrCodeInvalidRequest, "invalid request body")
+		return
+	}
+	if !h.knownRole(w, r, req.Role) {
+		return
+	}
+	var oldRole string
+	user, err := h.store.UpdateUser(r.PathValue("id"), func(u *User) { oldRole, u.Role = u.Role, req.Role })
+	if err != nil {
+		writeUserError(w, r, err)
+		return
+	}
+	if oldRole != user.Role {
+		RoleChanged.Publish(eventContext(r), h.events, RoleChangedEvent{User: *user, OldRole: oldRole})
+		AdminAction.Publish(eventContext(r), h.events, AdminActionEvent{
+			Action: "user_set_role", Details: map[string]string{"user_id": user.ID, "old_role": oldRole, "role": user.Role},
+		})
+	}
+	respond(w, r, http.StatusOK, user)
+}
+
+// SuspendUser blocks an account: its credentials are revoked (see
+// revokeAllCredentials), and login is refused until UnsuspendUser.
+func (h *Handlers) SuspendUser(w http.ResponseWriter, r *http.Request) {
+	user, err := h.store.UpdateUser(r.PathValue("id"), func(u *User) { u.Suspended = true })
+	if err != nil {
+		writeUserError(w, r, err)
+		return
+	}
+	h.revokeAllCredentials(user.ID)
+	UserSuspended.Publish(eventContext(r), h.events, UserEvent{User: *user})
+	SessionRevoked.Publish(eventContext(r), h.events, SessionEvent{UserID: user.ID, Reason: "suspended"})
+	AdminAction.Publish(eventContext(r), h.events, AdminActionEvent{Action: "user_suspend", Details: map[string]string{"user_id": user.ID}})
+	respond(w, r, http.StatusOK, user)
+}
+
+func (h *Handlers) UnsuspendUser(w http.ResponseWriter, r *http.Request) {
+	user, err := h.store.UpdateUser(r.PathValue("id"), func(u *User) { u.Suspended = false })
+	if err != nil {
+		writeUserError(w, r, err)
+		return
+	}
+	AdminAction.Publish(eventContext(r), h.events, AdminActionEvent{Action: "user_unsuspend", Details: map[string]string{"user_id": user.ID}})
+	respond(w, r, http.StatusOK, user)
+}
+
+// RevokeUserTokens signs a user out everywhere; see revokeAllCredentials.
+// Revoked counts the refresh tokens.
+func (h *Handlers) RevokeUserTokens(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	n, err := h.revokeAllCredentials(id)
+	if err != nil {
+		writeUserError(w, r, err)
+		return
+	}
+	SessionRevoked.Publish(eventContext(r), h.events, SessionEvent{UserID: id, Reason: "admin"})
+	AdminAction.Publish(eventContext(r), h.events, AdminActionEvent{
+		Action: "user_revoke_tokens", Details: map[string]string{"user_id": id, "revoked": strconv.Itoa(n)},
+	})
+	respond(w, r, http.StatusOK, RevokedTokens{Revoked: n})
+}
+
+// revokeAllCredentials makes every credential issued to the user so far
+// useless at once: refresh and CSRF tokens are deleted, and access tokens,
+// which cannot be, are refused from now on by setting TokensValidAfter
+// (see Middleware.Auth). It returns how many refresh tokens there were.
+//
+// Access tokens carry iat in whole seconds, so TokensValidAfter is rounded
+// up to the next second: a token issued earlier in the same second is
+// refused too, and issueTokens dates the tokens it issues before then at
+// TokensValidAfter so that logging in again right away works.
+func (h *Handlers) revokeAllCredentials(userID string) (int, error) {
+	after := auth.Now().Truncate(time.Second).Add(time.Second).UTC()
+	if _, err := h.store.UpdateUser(userID, func(u *User) { u.TokensValidAfter = after }); err != nil {
+		return 0, err
+	}
+	h.store.RevokeUserCSRFTokens(userID)
+	return h.store.RevokeUserRefreshTokens(userID), nil
+}
+
+// Backup is a dump of the store's durable data: users (with their password
+// hashes) and webhook subscriptions (with their secrets). Sessions, CSRF
+// tokens and the audit trail are left out.
+type Backup struct {
+	CreatedAt time.Time             `json:"created_at"`
+	Users     []BackupUser          `json:"users"`
+	Webhooks  []WebhookSubscription `json:"webhooks"`
+}
+
+type BackupUser struct {
+	User         User   `json:"user"`
+	PasswordHash string `json:"password_hash"`
+}
+
+func (h *Handlers) Backup(w http.ResponseWriter, r *http.Request) {
+	b := Backup{CreatedAt: time.Now().UTC(), Webhooks: h.store.ListWebhooks()}
+	for _, u := range h.store.ListUsers() {
+		b.Users = append(b.Users, BackupUser{User: *u, PasswordHash: u.Password})
+	}
+	AdminAction.Publish(eventContext(r), h.events, AdminActionEvent{Action: "backup", Details: map[string]string{"users": strconv.Itoa(len(b.Users))}})
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, b)
+}
+
+// ListSecurityEvents serves the audit trail, newest first. Query
+// parameters: type, user (ID or email), since and until (RFC 3339) and
+// limit (default 100, max 1000).
+func (h *Handlers) ListSecurityEvents(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := SecurityEventFilter{Type: q.Get("type"), User: q.Get("user"), Limit: 100}
+	var fields []FieldError
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"since", &f.Since}, {"until", &f.Until}} {
+		if v := q.Get(p.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				fields = append(fields, FieldError{Field: p.name, Message: "must be an RFC 3339 timestamp"})
+			}
+			*p.dst = t
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			fields = append(fields, FieldError{Field: "limit", Message: "must be between 1 and 1000"})
+		}
+		f.Limit = n
+	}
+	if len(fields) > 0 {
+		writeErrorFields(w, r, http.StatusBadRequest, api.ErrCodeValidationFailed, "invalid query parameters", fields)
+		return
+	}
+	if apiVersionOf(r).Paginated {
+		f.Limit = 0 // limit is the page size; the version pages every match
+	}
+	events := h.store.SecurityEvents(f)
+	respond(w, r, http.StatusOK, SecurityEventList{Events: events, Total: len(events)})
+}
+
+type SecurityEventList struct {
+	Events []SecurityEvent `json:"events"`
+	Total  int             `json:"total"`
+}
+
+type WebhookList struct {
+	Webhooks []WebhookSubscription `json:"webhooks"`
+	Total    int                   `json:"total"`
+}
+
+type WebhookDeliveryList struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+	Total      int               `json:"total"`
+}
+
+type WebhookMessageList struct {
+	Messages []WebhookMessage `json:"messages"`
+	Total    int              `json:"total"`
+}
+
+type CreateWebhookRequest struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"` // generated when empty
+	Events []string `json:"events"`
+}
+
+func (h *Handlers) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req CreateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorCode(w, r, http.StatusBadRequest, api.ErrCodeInvalidRequest, "invalid request body")
+		return
+	}
+	var fields []FieldError
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		fields = append(fields, FieldError{Field: "url", Message: "must be an absolute http(s) URL"})
+	} else if u.Scheme != "https" && h.cfg.Environment == "production" {
+		fields = append(fields, FieldError{Field: "url", Message: "must use https in production"})
+	}
+	if len(req.Events) == 0 {
+		fields = append(fields, FieldError{Field: "events", Message: "is required"})
+	}
+	for _, ev := range req.Events {
+		if !webhookEventTypes[ev] {
+			fields = append(fields, FieldError{Field: "events", Message: fmt.Sprintf("unknown event type %q", ev)})
+		}
+	}
+	if len(fields) > 0 {
+		writeErrorFields(w, r, http.StatusBadRequest, api.ErrCodeValidationFailed, "invalid webhook subscription", fields)
+		return
+	}
+	if req.Secret == "" {
+		req.Secret = auth.GenerateToken()
+	}
+	sub := h.store.CreateWebhook(WebhookSubscription{URL: req.URL, Secret: req.Secret, Events: req.Events})
+	AdminAction.Publish(eventContext(r), h.events, AdminActionEvent{Action: "webhook_create", Details: map[string]string{"webhook_id": sub.ID}})
+	respond(w, r, http.StatusCreated, sub)
+}
+
+func (h *Handlers) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	subs := h.store.ListWebhooks()
+	respond(w, r, http.StatusOK, WebhookList{Webhooks: subs, Total: len(subs)})
+}
+
+func (h *Handlers) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !h.store.DeleteWebhook(id) {
+		writeErrorCode(w, r, http.StatusNotFound, api.ErrCodeNotFound, "webhook not found")
+		return
+	}
+	AdminAction.Publish(eventContext(r), h.events, AdminActionEvent{Action: "webhook_delete", Details: map[string]string{"webhook_id": id}})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListWebhookDeliveries shows recent delivery attempts, newest first,
+// optionally filtered by ?subscription=ID (limit 100).
+func (h *Handlers) ListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	deliveries := h.store.WebhookDeliveries(r.URL.Query().Get("subscription"), 100)
+	respond(w, r, http.StatusOK, WebhookDeliveryList{Deliveries: deliveries, Total: len(deliveries)})
+}
+
+// ListDeadWebhooks shows the messages that ran out of delivery attempts,
+// newest first, optionally filtered by ?subscription=ID (limit 100).
+func (h *Handlers) ListDeadWebhooks(w http.ResponseWriter, r *http.Request) {
+	msgs := h.store.DeadWebhookMessages(r.URL.Query().Get("subscription"), 100)
+	respond(w, r, http.StatusOK, WebhookMessageList{Messages: msgs, Total: len(msgs)})
+}
+
+// RedriveWebhook puts a dead message back in the outbox with a fresh set
+// of attempts, due now.
+func (h *Handlers) RedriveWebhook(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	dead := false
+	m, ok := h.store.UpdateWebhookMessage(id, func(m *WebhookMessage) {
+		if dead = m.Status == store.WebhookDead; dead {
+			m.Status, m.Attempts, m.NextAttempt = store.WebhookPending, 0, time.Now()
+		}
+	})
+	if !ok || !dead {
+		writeErrorCode(w, r, http.StatusNotFound, api.ErrCodeNotFound, "dead letter not found")
+		return
+	}
+	AdminAction.Publish(eventContext(r), h.events, AdminActionEvent{Action: "webhook_redrive",
+		Details: map[string]string{"message_id": id, "webhook_id": m.SubscriptionID}})
+	respond(w, r, http.StatusOK, m)
+}
+
+// respondAuth answers r with a token set for user; see issueTokens. When
+// none could be issued it writes nothing and returns the error, for the
+// caller to answer with writeIssueError.
+func (h *Handlers) respondAuth(w http.ResponseWriter, r *http.Request, status int, user *User, prev *Session) error {
+	resp, err := h.issueTokens(user, prev)
+	if err != nil {
+		return err
+	}
+	respond(w, r, status, resp)
+	return nil
+}
+
+// writeIssueError answers r with a 500 (logged) for a failed issueTokens.
+func writeIssueError(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("ERROR issuing tokens: %v (request_id=%s)", err, r.Header.Get("X-Request-ID"))
+	writeErrorCode(w, r, http.StatusInternalServerError, api.ErrCodeInternal, "internal error")
+}
+
+// issueTokens issues a token set for user. prev is the session a refresh
+// continues, nil when the user just presented credentials: only then do
+// the tokens carry auth_time (see RequireFreshAuth) and start a session.
+// On error nothing has been stored.
+func (h *Handlers) issueTokens(user *User, prev *Session) (AuthResponse, error) {
+	now := auth.Now()
+	claims := auth.Claims{
+		UserID: user.ID, Email: user.Email, Role: user.Role,
+		Exp: now.Add(h.cfg.AccessTokenTTL).Unix(), Iat: max(now.Unix(), user.TokensValidAfter.Unix()),
+	}
+	if prev == nil {
+		claims.AuthTime = now.Unix()
+	}
+	sess := h.nextSession(user, prev, now)
+	claims.SessionStart, claims.SessionID = sess.StartedAt.Unix(), sess.ID
+	if sess.OrgID != "" {
+		if m, ok := h.orgMembership(sess.OrgID, user.ID); ok {
+			claims.OrgID, claims.OrgRole = m.OrgID, m.Role
+			claims.Iat = max(claims.Iat, h.store.OrgTokensValidAfter(m.OrgID, user.ID).Unix())
+		} else {
+			sess.OrgID = "" // they left it since the switch
+		}
+	}
+	accessToken, err := auth.CreateJWT(h.cfg.JWTSecret, claims)
+	if err != nil {
+		return AuthResponse{}, err
+	}
+	refreshToken := auth.GenerateToken()
+	h.store.StoreRefreshToken(refreshToken, sess)
+	csrfToken := auth.GenerateToken()
+	h.store.StoreCSRFToken(csrfToken, user.ID, sess.ID, h.cfg.CSRFTokenTTL)
+	return AuthResponse{
+		AccessToken: accessToken, RefreshToken: refreshToken,
+		User: *user, CSRFToken: csrfToken,
+		accessTTL: h.cfg.AccessTokenTTL, refreshTTL: sess.ExpiresAt.Sub(now),
+	}, nil
+}
@@ -0,0 +1,122 @@
+package httpapi_test
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/raijintest"
+)
+
+// headOnTheWire sends a HEAD for path over a raw connection and returns the
+// response and whatever the server wrote after its headers.
+func headOnTheWire(t *testing.T, baseURL, path, token string) (*http.Response, []byte) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(baseURL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	req := "HEAD " + path + " HTTP/1.1\r\nHost: test\r\n"
+	if token != "" {
+		req += "Authorization: Bearer " + token + "\r\n"
+	}
+	if _, err := io.WriteString(conn, req+"\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: "HEAD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	extra, err := io.ReadAll(br)
+	var ne net.Error
+	if err != nil && !(errors.As(err, &ne) && ne.Timeout()) {
+		t.Fatal(err)
+	}
+	return resp, extra
+}
+
+// HEAD gets the GET's status and headers, Content-Length and ETag
+// included, and no body.
+func TestHead(t *testing.T) {
+	srv := raijintest.NewServer(t)
+	user := srv.CreateUser(t, "head@example.com", raijintest.Password, "user")
+	token := srv.Token(t, user)
+	for _, tt := range []struct {
+		path   string
+		token  string
+		status int
+	}{
+		{"/health", "", http.StatusOK},
+		{"/version", "", http.StatusOK},
+		{"/api/v1/users/me", token, http.StatusOK},
+		{"/api/v1/users/me", "", http.StatusUnauthorized},
+		{"/api/v1/nope", "", http.StatusNotFound},
+	} {
+		req, _ := http.NewRequest("GET", srv.URL+tt.path, nil)
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		get, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(get.Body)
+		get.Body.Close()
+
+		head, extra := headOnTheWire(t, srv.URL, tt.path, tt.token)
+		name := tt.path + " " + strconv.Itoa(tt.status)
+		if get.StatusCode != tt.status || head.StatusCode != tt.status {
+			t.Errorf("%s: GET %d, HEAD %d", name, get.StatusCode, head.StatusCode)
+			continue
+		}
+		if len(extra) != 0 {
+			t.Errorf("%s: HEAD sent a body: %q", name, extra)
+		}
+		if got := head.Header.Get("Content-Length"); got != strconv.Itoa(len(body)) {
+			t.Errorf("%s: HEAD Content-Length %q, GET body %d bytes", name, got, len(body))
+		}
+		for _, h := range []http.Header{get.Header, head.Header} {
+			h.Del("Date")
+		}
+		if !maps.EqualFunc(get.Header, head.Header, slices.Equal) {
+			t.Errorf("%s: headers differ\nGET  %v\nHEAD %v", name, get.Header, head.Header)
+		}
+		if tt.status == http.StatusOK && tt.path == "/api/v1/users/me" && head.Header.Get("ETag") == "" {
+			t.Errorf("%s: no ETag", name)
+		}
+	}
+
+	// HEAD is answered 304 like the GET it stands for.
+	req, _ := http.NewRequest("HEAD", srv.URL+"/api/v1/users/me", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	req.Header.Set("If-None-Match", resp.Header.Get("ETag"))
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("HEAD with a matching If-None-Match: %d", resp.StatusCode)
+	}
+
+	// A route without GET has no HEAD either.
+	resp = send(t, srv.Client(), "HEAD", srv.URL+"/api/v1/auth/login", nil, nil)
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != "POST" {
+		t.Errorf("HEAD /api/v1/auth/login: %d, Allow %q", resp.StatusCode, resp.Header.Get("Allow"))
+	}
+}
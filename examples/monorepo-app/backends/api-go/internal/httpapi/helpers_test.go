@@ -0,0 +1,73 @@
+package httpapi_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+)
+
+// newTestConfig is the configuration raijintest.NewServer serves, for
+// tests that build the httpapi.Server themselves (to reload it, say).
+func newTestConfig() *config.Config {
+	cfg := config.Defaults()
+	cfg.Environment = "test"
+	cfg.JWTSecret = "raijintest-jwt-secret-not-for-production"
+	cfg.AuditLogOutput = "off"
+	cfg.AccessLogOutput = os.DevNull
+	cfg.RateLimitBuckets = []config.RateLimitBucket{
+		{Name: "auth", Limit: 10000, Window: time.Minute, Key: "ip"},
+		{Name: "api", Limit: 100000, Window: time.Minute, Key: "ip"},
+	}
+	return cfg
+}
+
+// send makes a request with body marshaled as JSON (none when nil) and
+// decodes a JSON response into out, when out is not nil. It returns the
+// response, whose body has been read and closed.
+func send(t *testing.T, client *http.Client, method, url string, body, out any) *http.Response {
+	t.Helper()
+	var rd io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rd = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, url, rd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("%s %s: reading body: %v", method, url, err)
+	}
+	if out != nil && resp.StatusCode < 300 {
+		if err := json.Unmarshal(data, out); err != nil {
+			t.Fatalf("%s %s: decoding %s: %v", method, url, data, err)
+		}
+	}
+	return resp
+}
+
+// wantStatus fails t unless resp has status code.
+func wantStatus(t *testing.T, resp *http.Response, code int) {
+	t.Helper()
+	if resp.StatusCode != code {
+		t.Fatalf("%s %s: status %d, want %d", resp.Request.Method, resp.Request.URL.Path, resp.StatusCode, code)
+	}
+}
@@ -0,0 +1,123 @@
+package httpapi
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Error messages are written in English in the code; locales/<tag>.json
+// translate them by error code. Codes without a translation (and dynamic
+// messages such as the maintenance notice) keep the English text.
+//
+//go:embed locales/*.json
+var localeFS embed.FS
+
+const defaultLanguage = "en"
+
+var messages = mustLoadCatalog(localeFS)
+
+// Catalog maps language tag -> error code -> message.
+type Catalog struct {
+	langs map[string]map[string]string // keyed by lower-cased tag
+	tags  map[string]string            // lower-cased tag -> canonical tag
+}
+
+func mustLoadCatalog(fsys fs.FS) *Catalog {
+	c := &Catalog{langs: make(map[string]map[string]string), tags: make(map[string]string)}
+	files, err := fs.Glob(fsys, "locales/*.json")
+	if err != nil {
+		panic(err)
+	}
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			panic(err)
+		}
+		var msgs map[string]string
+		if err := json.Unmarshal(data, &msgs); err != nil {
+			panic(fmt.Sprintf("%s: %v", file, err))
+		}
+		tag := strings.TrimSuffix(path.Base(file), ".json")
+		c.langs[strings.ToLower(tag)] = msgs
+		c.tags[strings.ToLower(tag)] = tag
+	}
+	return c
+}
+
+// Match picks the best available language for an Accept-Language header:
+// for each preference (by q-value) try the exact tag, then its base
+// language; fall back to English.
+func (c *Catalog) Match(acceptLanguage string) string {
+	type pref struct {
+		tag string
+		q   float64
+	}
+	var prefs []pref
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		if q > 0 {
+			prefs = append(prefs, pref{strings.ToLower(tag), q})
+		}
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+
+	for _, p := range prefs {
+		base, _, _ := strings.Cut(p.tag, "-")
+		if base == defaultLanguage {
+			return defaultLanguage
+		}
+		if tag, ok := c.tags[p.tag]; ok {
+			return tag
+		}
+		for lower, tag := range c.tags {
+			if b, _, _ := strings.Cut(lower, "-"); b == base {
+				return tag
+			}
+		}
+	}
+	return defaultLanguage
+}
+
+// Message returns the translation of code in lang, or fallback.
+func (c *Catalog) Message(lang, code, fallback string) string {
+	if msg, ok := c.langs[strings.ToLower(lang)][code]; ok {
+		return msg
+	}
+	return fallback
+}
+
+// Locale returns the canonical form of tag if the catalog has messages
+// for it, English included.
+func (c *Catalog) Locale(tag string) (string, bool) {
+	if strings.EqualFold(tag, defaultLanguage) {
+		return defaultLanguage, true
+	}
+	canonical, ok := c.tags[strings.ToLower(tag)]
+	return canonical, ok
+}
+
+// Locales lists the language tags the catalog has messages for, English
+// first.
+func (c *Catalog) Locales() []string {
+	tags := make([]string, 0, len(c.tags)+1)
+	for _, tag := range c.tags {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return append([]string{defaultLanguage}, tags...)
+}
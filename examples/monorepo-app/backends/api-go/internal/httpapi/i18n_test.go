@@ -0,0 +1,131 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+)
+
+func TestCatalogMatch(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                           "en",
+		"pt-BR":                      "pt-BR",
+		"pt-br":                      "pt-BR",
+		"pt":                         "pt-BR", // base language
+		"pt-PT":                      "pt-BR",
+		"en-US":                      "en",
+		"fr":                         "en", // unknown
+		"fr, pt;q=0.5":               "pt-BR",
+		"en;q=0.4, pt-BR;q=0.8":      "pt-BR",
+		"pt-BR;q=0.4, en;q=0.8":      "en",
+		"pt-BR;q=0, fr":              "en", // q=0 refuses it
+		"*":                          "en",
+		"  pt-BR ; q=0.9 , en;q=0.1": "pt-BR",
+		"pt-BR;q=bogus":              "pt-BR",
+	} {
+		if got := messages.Match(header); got != want {
+			t.Errorf("Match(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+// Every translation is keyed by an error code the API declares, so none
+// outlives its code.
+func TestCatalogKeysAreErrorCodes(t *testing.T) {
+	f, err := parser.ParseFile(token.NewFileSet(), "../../api/api.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes := map[string]bool{}
+	ast.Inspect(f, func(n ast.Node) bool {
+		if vs, ok := n.(*ast.ValueSpec); ok && strings.HasPrefix(vs.Names[0].Name, "ErrCode") {
+			for _, v := range vs.Values {
+				if lit, ok := v.(*ast.BasicLit); ok {
+					s, _ := strconv.Unquote(lit.Value)
+					codes[s] = true
+				}
+			}
+		}
+		return true
+	})
+	if len(codes) < 10 {
+		t.Fatalf("found only %d error codes in api/api.go", len(codes))
+	}
+	for _, lang := range messages.Locales()[1:] {
+		msgs := messages.langs[strings.ToLower(lang)]
+		if len(msgs) == 0 {
+			t.Errorf("%s: no messages", lang)
+		}
+		for code, msg := range msgs {
+			if !codes[code] {
+				t.Errorf("%s: %q is not an error code", lang, code)
+			}
+			if msg == "" {
+				t.Errorf("%s: %q is empty", lang, code)
+			}
+		}
+	}
+}
+
+func TestLocalizedErrors(t *testing.T) {
+	_, ts := openAPIServer(t, store.NewMemory())
+	// A new unknown email each time, so no lockout gets in the way.
+	attempt := 0
+	login := func(acceptLanguage string) (*http.Response, APIError) {
+		t.Helper()
+		attempt++
+		req, _ := http.NewRequest("POST", ts.URL+"/api/v1/auth/login",
+			strings.NewReader(fmt.Sprintf(`{"email":"nobody%d@example.com","password":"wrong-password"}`, attempt)))
+		req.Header.Set("Content-Type", "application/json")
+		if acceptLanguage != "" {
+			req.Header.Set("Accept-Language", acceptLanguage)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var body APIError
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return resp, body
+	}
+
+	_, en := login("")
+	want := map[string]string{"": en.Message, "en": en.Message, "fr": en.Message}
+	for _, lang := range []string{"pt-BR", "pt", "fr, pt-BR;q=0.5"} {
+		want[lang] = messages.Message("pt-BR", api.ErrCodeInvalidCredentials, "")
+	}
+	if want["pt-BR"] == "" || want["pt-BR"] == en.Message {
+		t.Fatalf("invalid_credentials reads %q in pt-BR and %q in en", want["pt-BR"], en.Message)
+	}
+	for lang, msg := range want {
+		resp, body := login(lang)
+		if resp.StatusCode != http.StatusUnauthorized || body.ErrorCode != api.ErrCodeInvalidCredentials {
+			t.Errorf("%q: %d %s; the code must not change with the language", lang, resp.StatusCode, body.ErrorCode)
+		}
+		if body.Message != msg {
+			t.Errorf("%q: message %q, want %q", lang, body.Message, msg)
+		}
+		wantLang := "en"
+		if msg != en.Message {
+			wantLang = "pt-BR"
+		}
+		if got := resp.Header.Get("Content-Language"); got != wantLang {
+			t.Errorf("%q: Content-Language %q, want %q", lang, got, wantLang)
+		}
+		if !strings.Contains(strings.Join(resp.Header.Values("Vary"), ","), "Accept-Language") {
+			t.Errorf("%q: no Vary: Accept-Language", lang)
+		}
+	}
+}
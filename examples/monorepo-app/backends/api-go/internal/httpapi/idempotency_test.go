@@ -0,0 +1,61 @@
+package httpapi_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/httpapi"
+	"github.com/your-org/your-app/backends/api-go/raijintest"
+)
+
+// postWithKey posts body as JSON with an Idempotency-Key.
+func postWithKey(t *testing.T, client *http.Client, url, key string, body any) *http.Response {
+	t.Helper()
+	b, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", key)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestRegisterReplaysWithIdempotencyKey(t *testing.T) {
+	srv := raijintest.NewServer(t)
+	req := api.RegisterRequest{Email: "new@example.com", Name: "New", Password: "a long enough passphrase 42"}
+
+	wantStatus(t, postWithKey(t, srv.Client(), srv.URL+"/api/v1/auth/register", "k1", req), http.StatusCreated)
+	replay := postWithKey(t, srv.Client(), srv.URL+"/api/v1/auth/register", "k1", req)
+	wantStatus(t, replay, http.StatusCreated)
+	if replay.Header.Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry was not a replay: headers %v", replay.Header)
+	}
+	req.Name = "Other"
+	wantStatus(t, postWithKey(t, srv.Client(), srv.URL+"/api/v1/auth/register", "k1", req), http.StatusUnprocessableEntity)
+}
+
+// Refresh is not idempotent: a retry with the same key spends the token
+// again instead of getting the rotated tokens back.
+func TestRefreshIgnoresIdempotencyKey(t *testing.T) {
+	srv := raijintest.NewServer(t)
+	srv.CreateUser(t, "someone@example.com", raijintest.Password, "user")
+	var session httpapi.AuthResponse
+	wantStatus(t, send(t, srv.Client(), "POST", srv.URL+"/api/v1/auth/login",
+		api.LoginRequest{Email: "someone@example.com", Password: raijintest.Password}, &session), http.StatusOK)
+
+	req := api.RefreshRequest{RefreshToken: session.RefreshToken}
+	wantStatus(t, postWithKey(t, srv.Client(), srv.URL+"/api/v1/auth/refresh", "k1", req), http.StatusOK)
+	wantStatus(t, postWithKey(t, srv.Client(), srv.URL+"/api/v1/auth/refresh", "k1", req), http.StatusUnauthorized)
+}
@@ -0,0 +1,99 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+)
+
+// NewInternalMux serves operational endpoints: expvar counters at /metrics
+// and, when pprofEnabled, net/http/pprof and /debug/vars behind admin auth.
+// It is meant for the INTERNAL_ADDR listener; on the public one, /metrics
+// must additionally be put behind admin auth.
+func NewInternalMux(mw *Middleware, st store.Store, limiters map[string]*RateLimiter, pprofEnabled bool) *http.ServeMux {
+	publishVar("store", func() any { return st.Stats() })
+	publishVar("rate_limiter_keys", func() any {
+		keys := make(map[string]int, len(limiters))
+		for name, rl := range limiters {
+			keys[name] = rl.Len()
+		}
+		return keys
+	})
+	// Keys evicted per limiter for exceeding RATE_LIMIT_MAX_KEYS.
+	publishVar("rate_limiter_evictions", func() any {
+		evicted := make(map[string]int64, len(limiters))
+		for name, rl := range limiters {
+			evicted[name] = rl.Evicted()
+		}
+		return evicted
+	})
+	// Rejections per limiter: the IP- or user-keyed buckets by name, and
+	// login_email for failed logins per email.
+	publishVar("rate_limited", func() any {
+		rejected := make(map[string]int64, len(limiters))
+		for name, rl := range limiters {
+			rejected[name] = rl.Rejected()
+		}
+		return rejected
+	})
+
+	// Requests per limiter that skipped it through RATE_LIMIT_EXEMPT_CIDRS
+	// ("cidr") or the bypass header ("header").
+	publishVar("rate_limit_bypassed", func() any {
+		bypassed := make(map[string]map[string]int64, len(limiters))
+		for name, rl := range limiters {
+			if rl.exempt != nil {
+				bypassed[name] = rl.Bypassed()
+			}
+		}
+		return bypassed
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", expvar.Handler())
+	if pprofEnabled {
+		// Profile and Trace move their write deadline past the server's
+		// WriteTimeout by ?seconds= themselves (Go 1.23 and later), so
+		// the default 30s profile works on the public listener as well.
+		debug := http.NewServeMux()
+		debug.HandleFunc("GET /debug/pprof/", pprof.Index)
+		debug.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
+		debug.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+		debug.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+		debug.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+		debug.Handle("GET /debug/vars", expvar.Handler())
+		mux.Handle("/debug/", Chain(SlowThreshold(time.Minute), mw.Auth, mw.RequireRole("admin"))(debug))
+	}
+	return mux
+}
+
+var publishMu sync.Mutex
+
+// replaceableVar is an expvar.Func that a later publishVar can swap.
+type replaceableVar struct{ fn atomic.Pointer[func() any] }
+
+func (v *replaceableVar) String() string {
+	b, _ := json.Marshal((*v.fn.Load())())
+	return string(b)
+}
+
+// publishVar publishes fn as name, replacing what an earlier server in the
+// same process (a test, say) published, which expvar.Publish would panic
+// on.
+func publishVar(name string, fn func() any) {
+	publishMu.Lock()
+	defer publishMu.Unlock()
+	if v, ok := expvar.Get(name).(*replaceableVar); ok {
+		v.fn.Store(&fn)
+		return
+	}
+	v := &replaceableVar{}
+	v.fn.Store(&fn)
+	expvar.Publish(name, v)
+}
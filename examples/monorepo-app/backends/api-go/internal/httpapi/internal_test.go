@@ -0,0 +1,147 @@
+package httpapi_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/internal/auth"
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+	"github.com/your-org/your-app/backends/api-go/internal/httpapi"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+	"github.com/your-org/your-app/backends/api-go/raijintest"
+)
+
+func TestPprofNeedsAdmin(t *testing.T) {
+	srv := raijintest.NewServer(t, raijintest.WithConfig(func(c *config.Config) { c.EnablePprof = true }))
+	tests := []struct {
+		name   string
+		client *http.Client
+		want   int
+	}{
+		{"anonymous", srv.Client(), http.StatusUnauthorized},
+		{"user", srv.LoginAs(t, "user"), http.StatusForbidden},
+		{"admin", srv.LoginAs(t, "admin"), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, path := range []string{"/debug/pprof/", "/debug/pprof/profile?seconds=1", "/debug/vars", "/metrics"} {
+				resp := send(t, tt.client, "GET", srv.URL+path, nil, nil)
+				if resp.StatusCode != tt.want {
+					t.Errorf("GET %s: status %d, want %d", path, resp.StatusCode, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// On the public listener a profile may run past SERVER_WRITE_TIMEOUT.
+func TestPprofProfileOutlivesWriteTimeout(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.EnablePprof = true
+	st := store.NewMemory()
+	srv, err := httpapi.New(cfg, st, httpapi.WithMailer(&httpapi.CaptureMailer{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewUnstartedServer(srv.Handler)
+	ts.Config.WriteTimeout = 500 * time.Millisecond
+	ts.Start()
+	t.Cleanup(func() {
+		ts.Close()
+		srv.Close(context.Background())
+	})
+
+	admin, err := st.CreateUser("ops@example.com", "Ops", raijintest.Password, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := auth.CreateJWT(cfg.JWTSecret, auth.Claims{
+		UserID: admin.ID, Email: admin.Email, Role: admin.Role,
+		Exp: time.Now().Add(time.Hour).Unix(), Iat: time.Now().Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", ts.URL+"/debug/pprof/profile?seconds=1", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading the profile: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || len(body) == 0 {
+		t.Fatalf("status %d, %d bytes: %s", resp.StatusCode, len(body), body)
+	}
+}
+
+// With INTERNAL_ADDR set, /metrics and pprof move to the internal
+// handler, and each listener refuses the other's routes.
+func TestInternalListenerSegregation(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.EnablePprof = true
+	cfg.InternalAddr = "127.0.0.1:0"
+	st := store.NewMemory()
+	srv, err := httpapi.New(cfg, st, httpapi.WithMailer(&httpapi.CaptureMailer{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srv.Internal == nil {
+		t.Fatal("no internal handler with INTERNAL_ADDR set")
+	}
+	public, internal := httptest.NewServer(srv.Handler), httptest.NewServer(srv.Internal)
+	t.Cleanup(func() {
+		public.Close()
+		internal.Close()
+		srv.Close(context.Background())
+	})
+	admin, err := st.CreateUser("ops@example.com", "Ops", raijintest.Password, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := auth.CreateJWT(cfg.JWTSecret, auth.Claims{
+		UserID: admin.ID, Email: admin.Email, Role: admin.Role,
+		Exp: time.Now().Add(time.Hour).Unix(), Iat: time.Now().Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, method, url string
+		admin             bool
+		want              int
+	}{
+		{"metrics, internal", "GET", internal.URL + "/metrics", false, http.StatusOK},
+		{"pprof, internal, anonymous", "GET", internal.URL + "/debug/pprof/", false, http.StatusUnauthorized},
+		{"pprof, internal, admin", "GET", internal.URL + "/debug/pprof/", true, http.StatusOK},
+		{"vars, internal, admin", "GET", internal.URL + "/debug/vars", true, http.StatusOK},
+		{"API, internal", "GET", internal.URL + "/api/v1/users/me", true, http.StatusNotFound},
+		{"health, internal", "GET", internal.URL + "/health", false, http.StatusNotFound},
+		{"metrics, public", "GET", public.URL + "/metrics", true, http.StatusNotFound},
+		{"pprof, public", "GET", public.URL + "/debug/pprof/", true, http.StatusNotFound},
+		{"drain, public", "POST", public.URL + "/internal/drain", true, http.StatusNotFound},
+		{"API, public", "GET", public.URL + "/api/v1/users/me", true, http.StatusOK},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, tt.url, nil)
+		if tt.admin {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, resp.StatusCode, tt.want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/auth"
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+	"github.com/your-org/your-app/backends/api-go/reqsign"
+)
+
+// credentialInternal is the ctxCredential of a request signed by an
+// internal caller. Browsers cannot sign, so CSRFProtection lets it through.
+const credentialInternal = "internal"
+
+// internalUserPrefix starts the user ID an internal caller acts as, which
+// no user ID does.
+const internalUserPrefix = "internal:"
+
+const (
+	maxSignedBody = 8 << 20 // read whole to check its hash
+	maxNonceLen   = 128
+)
+
+// defaultCallerScope is what a caller configured without scopes may call.
+var defaultCallerScope = []string{apiVersions[0].Prefix + "/admin"}
+
+// InternalCallerFrom returns the caller that signed the request of ctx,
+// if internalAuth authenticated it.
+func InternalCallerFrom(ctx context.Context) (config.InternalCaller, bool) {
+	c, ok := ctx.Value(ctxInternalCaller).(config.InternalCaller)
+	return c, ok
+}
+
+// internalAuth authenticates a request signed with reqsign by a caller in
+// INTERNAL_CALLERS, in place of Auth's access token. The caller acts as
+// user "internal:<name>" with its configured role, and only on the paths
+// in its scopes. A signature holds for INTERNAL_AUTH_MAX_SKEW either side
+// of its timestamp, and once: its nonce is remembered for that long.
+func (m *Middleware) internalAuth(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	reject := func(status int, code, message string) {
+		AuthRejected.Publish(eventContext(r), m.events, RejectionEvent{
+			Reason: code, Details: map[string]string{"error_code": code, "path": r.URL.Path, "caller": r.Header.Get(reqsign.HeaderCaller), "message": message},
+		})
+		writeErrorCode(w, r, status, code, message)
+	}
+	name := r.Header.Get(reqsign.HeaderCaller)
+	caller, ok := m.callers[name]
+	if !ok {
+		reject(http.StatusUnauthorized, api.ErrCodeSignatureInvalid, "unknown internal caller")
+		return
+	}
+	timestamp := r.Header.Get(reqsign.HeaderTimestamp)
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		reject(http.StatusUnauthorized, api.ErrCodeSignatureInvalid, "missing or malformed "+reqsign.HeaderTimestamp)
+		return
+	}
+	signedAt, maxSkew := time.Unix(ts, 0), m.cfg.InternalAuth.MaxSkew
+	if auth.Now().Sub(signedAt).Abs() > maxSkew {
+		reject(http.StatusUnauthorized, api.ErrCodeSignatureInvalid, "timestamp outside the allowed clock skew")
+		return
+	}
+	nonce := r.Header.Get(reqsign.HeaderNonce)
+	if nonce == "" || len(nonce) > maxNonceLen {
+		reject(http.StatusUnauthorized, api.ErrCodeSignatureInvalid, "missing or malformed "+reqsign.HeaderNonce)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSignedBody+1))
+	if err != nil || len(body) > maxSignedBody {
+		writeErrorCode(w, r, http.StatusRequestEntityTooLarge, api.ErrCodePayloadTooLarge, "request body too large")
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	want := reqsign.Signature(m.cfg.InternalAuth.Secrets[name], r.Method, r.URL.RequestURI(), timestamp, nonce, body)
+	if !hmac.Equal([]byte(want), []byte(r.Header.Get(reqsign.HeaderSignature))) {
+		reject(http.StatusUnauthorized, api.ErrCodeSignatureInvalid, "signature mismatch")
+		return
+	}
+	// Only a verified request uses up its nonce, so forgeries cannot
+	// block the caller's next ones.
+	if !m.store.MarkNonce(name+"\n"+nonce, signedAt.Add(maxSkew)) {
+		reject(http.StatusUnauthorized, api.ErrCodeSignatureInvalid, "nonce already used")
+		return
+	}
+	if !inScopes(caller, routeV1(r.URL.Path)) {
+		reject(http.StatusForbidden, api.ErrCodeForbidden, "path outside the internal caller's scopes")
+		return
+	}
+
+	userID := internalUserPrefix + caller.Name
+	ctx := context.WithValue(r.Context(), ctxUserID, userID)
+	ctx = context.WithValue(ctx, ctxRole, caller.Role)
+	ctx = context.WithValue(ctx, ctxCredential, credentialInternal)
+	// Handlers reading the token's claims find no user or session in
+	// these, rather than none at all.
+	ctx = context.WithValue(ctx, ctxClaims, &auth.Claims{UserID: userID, Role: caller.Role, Iat: ts, Exp: ts})
+	ctx = context.WithValue(ctx, ctxInternalCaller, caller)
+	setRequestUser(r, userID)
+	setRequestCaller(r, caller.Name)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// inScopes reports whether path is one of caller's scopes or below one.
+func inScopes(caller config.InternalCaller, path string) bool {
+	scopes := caller.Scopes
+	if len(scopes) == 0 {
+		scopes = defaultCallerScope
+	}
+	for _, s := range scopes {
+		if path == s || strings.HasPrefix(path, strings.TrimSuffix(s, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
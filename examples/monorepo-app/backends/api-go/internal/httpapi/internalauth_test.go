@@ -0,0 +1,172 @@
+package httpapi_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+	"github.com/your-org/your-app/backends/api-go/raijintest"
+	"github.com/your-org/your-app/backends/api-go/reqsign"
+)
+
+const (
+	batchKey = "billing-batch-key-of-at-least-32-bytes"
+	usersKey = "user-sync-key-of-at-least-32-bytes-long"
+)
+
+// internalAuthServer trusts two callers: billing-batch, an admin on the
+// admin API, and user-sync, an admin on /api/v1/admin/users only.
+func internalAuthServer(t *testing.T) *raijintest.Server {
+	t.Helper()
+	return raijintest.NewServer(t, raijintest.WithConfig(func(cfg *config.Config) {
+		cfg.InternalAuth = config.InternalAuthConfig{
+			Callers: []config.InternalCaller{
+				{Name: "billing-batch", Role: "admin"},
+				{Name: "user-sync", Role: "admin", Scopes: []string{"/api/v1/admin/users"}},
+			},
+			Secrets: map[string]string{"billing-batch": batchKey, "user-sync": usersKey},
+			MaxSkew: 5 * time.Minute,
+		}
+	}))
+}
+
+// signed builds a request signed by caller with key, then lets tamper
+// change it after signing.
+func signed(t *testing.T, method, url, body, caller, key string, tamper func(*http.Request)) *http.Request {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if err := reqsign.Sign(req, caller, key); err != nil {
+		t.Fatal(err)
+	}
+	if tamper != nil {
+		tamper(req)
+	}
+	return req
+}
+
+// resign signs req again with a timestamp of at and nonce.
+func resign(req *http.Request, key string, at time.Time, nonce string) {
+	var body []byte
+	if req.GetBody != nil {
+		rc, _ := req.GetBody()
+		body, _ = io.ReadAll(rc)
+	}
+	ts := strconv.FormatInt(at.Unix(), 10)
+	req.Header.Set(reqsign.HeaderTimestamp, ts)
+	req.Header.Set(reqsign.HeaderNonce, nonce)
+	req.Header.Set(reqsign.HeaderSignature, reqsign.Signature(key, req.Method, req.URL.RequestURI(), ts, nonce, body))
+}
+
+// setBody replaces req's body, keeping the signature.
+func setBody(req *http.Request, body string) {
+	req.Body = io.NopCloser(strings.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader(body)), nil }
+	req.ContentLength = int64(len(body))
+}
+
+// do sends req and returns the status and error code.
+func do(t *testing.T, req *http.Request) (int, string) {
+	t.Helper()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var e api.APIError
+	json.NewDecoder(resp.Body).Decode(&e)
+	return resp.StatusCode, e.ErrorCode
+}
+
+// A signed request runs with the caller's role and skips CSRF; anything
+// changed after signing, a stale timestamp, a replay or an unknown
+// caller or key is refused.
+func TestInternalAuth(t *testing.T) {
+	srv := internalAuthServer(t)
+	stats := srv.URL + "/api/v1/admin/stats"
+	users := srv.URL + "/api/v1/admin/users"
+	newUser := `{"email":"batch@example.com","name":"Batch","password":"batch-user-password","role":"user"}`
+
+	tests := []struct {
+		name              string
+		method, url, body string
+		caller, key       string
+		tamper            func(*http.Request)
+		wantStatus        int
+		wantCode          string
+	}{
+		{"GET", "GET", stats, "", "billing-batch", batchKey, nil, http.StatusOK, ""},
+		{"POST without a CSRF token", "POST", users, newUser, "billing-batch", batchKey, nil, http.StatusCreated, ""},
+		{"tampered body", "POST", users, newUser, "billing-batch", batchKey, func(r *http.Request) {
+			setBody(r, strings.Replace(newUser, `"user"}`, `"admin"}`, 1))
+		}, http.StatusUnauthorized, api.ErrCodeSignatureInvalid},
+		{"tampered query", "GET", stats + "?x=1", "", "billing-batch", batchKey, func(r *http.Request) {
+			r.URL.RawQuery = "x=2"
+		}, http.StatusUnauthorized, api.ErrCodeSignatureInvalid},
+		{"tampered method", "GET", srv.URL + "/api/v1/admin/orgs", "", "billing-batch", batchKey, func(r *http.Request) {
+			r.Method = "POST"
+		}, http.StatusUnauthorized, api.ErrCodeSignatureInvalid},
+		{"another caller's name", "GET", users + "/export", "", "user-sync", batchKey, nil, http.StatusUnauthorized, api.ErrCodeSignatureInvalid},
+		{"wrong key", "GET", stats, "", "billing-batch", usersKey, nil, http.StatusUnauthorized, api.ErrCodeSignatureInvalid},
+		{"unknown caller", "GET", stats, "", "nobody", batchKey, nil, http.StatusUnauthorized, api.ErrCodeSignatureInvalid},
+		{"stale timestamp", "GET", stats, "", "billing-batch", batchKey, func(r *http.Request) {
+			resign(r, batchKey, time.Now().Add(-6*time.Minute), "stale-nonce")
+		}, http.StatusUnauthorized, api.ErrCodeSignatureInvalid},
+		{"future timestamp", "GET", stats, "", "billing-batch", batchKey, func(r *http.Request) {
+			resign(r, batchKey, time.Now().Add(6*time.Minute), "future-nonce")
+		}, http.StatusUnauthorized, api.ErrCodeSignatureInvalid},
+		{"within the skew", "GET", stats, "", "billing-batch", batchKey, func(r *http.Request) {
+			resign(r, batchKey, time.Now().Add(-4*time.Minute), "skewed-nonce")
+		}, http.StatusOK, ""},
+		{"no nonce", "GET", stats, "", "billing-batch", batchKey, func(r *http.Request) {
+			resign(r, batchKey, time.Now(), "")
+		}, http.StatusUnauthorized, api.ErrCodeSignatureInvalid},
+		{"no signature", "GET", stats, "", "billing-batch", batchKey, func(r *http.Request) {
+			r.Header.Del(reqsign.HeaderSignature)
+		}, http.StatusUnauthorized, ""},
+		{"out of scope", "GET", stats, "", "user-sync", usersKey, nil, http.StatusForbidden, api.ErrCodeForbidden},
+		{"in scope", "GET", srv.URL + "/api/v1/admin/users/export", "", "user-sync", usersKey, nil, http.StatusOK, ""},
+		{"outside the admin API", "GET", srv.URL + "/api/v1/users/me", "", "billing-batch", batchKey, nil, http.StatusForbidden, api.ErrCodeForbidden},
+	}
+	for _, tt := range tests {
+		status, code := do(t, signed(t, tt.method, tt.url, tt.body, tt.caller, tt.key, tt.tamper))
+		if status != tt.wantStatus || (tt.wantCode != "" && code != tt.wantCode) {
+			t.Errorf("%s: %d %s, want %d %s", tt.name, status, code, tt.wantStatus, tt.wantCode)
+		}
+	}
+}
+
+// A nonce is good once; a forgery reusing it does not use it up.
+func TestInternalAuthReplay(t *testing.T) {
+	srv := internalAuthServer(t)
+	stats := srv.URL + "/api/v1/admin/stats"
+	now := time.Now()
+
+	forged := signed(t, "GET", stats, "", "billing-batch", "not-the-key-but-as-long-as-the-key", func(r *http.Request) {
+		resign(r, "not-the-key-but-as-long-as-the-key", now, "nonce-1")
+	})
+	if status, _ := do(t, forged); status != http.StatusUnauthorized {
+		t.Fatalf("forged: %d", status)
+	}
+	req := signed(t, "GET", stats, "", "billing-batch", batchKey, func(r *http.Request) { resign(r, batchKey, now, "nonce-1") })
+	if status, code := do(t, req); status != http.StatusOK {
+		t.Fatalf("first use: %d %s", status, code)
+	}
+	replay := req.Clone(req.Context())
+	if status, code := do(t, replay); status != http.StatusUnauthorized || code != api.ErrCodeSignatureInvalid {
+		t.Errorf("replay: %d %s", status, code)
+	}
+	// Nonces are per caller.
+	other := signed(t, "GET", srv.URL+"/api/v1/admin/users/export", "", "user-sync", usersKey, func(r *http.Request) { resign(r, usersKey, now, "nonce-1") })
+	if status, code := do(t, other); status != http.StatusOK {
+		t.Errorf("another caller's nonce-1: %d %s", status, code)
+	}
+}
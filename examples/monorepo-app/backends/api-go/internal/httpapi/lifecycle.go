@@ -0,0 +1,225 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Lifecycle holds the shutdown hooks of the components that run in the
+// background (workers, janitors, pools, open files). Shutdown runs them in
+// reverse registration order, so a component is stopped before whatever
+// it was built on top of.
+//
+// It also holds their warmup tasks: work a component would otherwise do
+// on the first request that needs it. Warmup runs them at startup, and
+// the server is not ready until it returns.
+type Lifecycle struct {
+	mu    sync.Mutex
+	hooks []shutdownHook
+
+	warmups       []warmupHook
+	warmupStart   time.Time
+	warmupResults []WarmupTask // by registration order; nil until Warmup
+	warmed        bool         // Warmup returned
+	warmupErr     error        // the fatal tasks that failed
+}
+
+type shutdownHook struct {
+	name string
+	fn   func(context.Context) error
+}
+
+// OnShutdown registers fn to run at shutdown under name, which is what the
+// log calls it.
+func (l *Lifecycle) OnShutdown(name string, fn func(ctx context.Context) error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.hooks = append(l.hooks, shutdownHook{name, fn})
+}
+
+// stopHook adapts a Stop method that does not wait for anything to a hook.
+func stopHook(stop func()) func(context.Context) error {
+	return func(context.Context) error {
+		stop()
+		return nil
+	}
+}
+
+// Shutdown runs the hooks, last registered first, logging how long each
+// took. When ctx has a deadline every hook gets an equal share of the time
+// left when its turn comes, so time one does not use goes to the rest; a
+// hook still running past its share is abandoned, and the next one starts.
+// Every hook is started even when ctx is already done, so a server
+// shutdown that ran out of time still stops the components whose hooks
+// don't wait (limiters, janitors). It returns the errors of the hooks that
+// failed or were abandoned.
+func (l *Lifecycle) Shutdown(ctx context.Context) error {
+	l.mu.Lock()
+	hooks := slices.Clone(l.hooks)
+	l.mu.Unlock()
+	var errs []error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		h := hooks[i]
+		hctx, cancel := context.WithCancel(ctx)
+		var budget time.Duration
+		if deadline, ok := ctx.Deadline(); ok {
+			budget = time.Until(deadline) / time.Duration(i+1)
+			cancel()
+			hctx, cancel = context.WithTimeout(ctx, budget)
+		}
+		start := time.Now()
+		err := runHook(hctx, h.fn)
+		cancel()
+		took := time.Since(start).Round(time.Millisecond)
+		switch {
+		case errors.Is(err, errHookAbandoned):
+			log.Printf("Shutdown: %s did not finish within %s, moving on", h.name, budget.Round(time.Millisecond))
+		case err != nil:
+			log.Printf("Shutdown: %s failed after %s: %v", h.name, took, err)
+		default:
+			log.Printf("Shutdown: %s done in %s", h.name, took)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", h.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+var errHookAbandoned = errors.New("abandoned past its time budget")
+
+// WarmupTask.Status values.
+const (
+	warmupRunning = "running"
+	warmupOK      = "ok"
+	warmupFailed  = "failed"
+	warmupTimeout = "timeout"
+)
+
+type warmupHook struct {
+	name  string
+	fatal bool
+	fn    func(context.Context) error
+}
+
+// OnWarmup registers fn to run at startup under name, before the server
+// reports ready. If it fails the server does not start.
+func (l *Lifecycle) OnWarmup(name string, fn func(ctx context.Context) error) {
+	l.onWarmup(warmupHook{name, true, fn})
+}
+
+// OnWarmupOptional registers a warmup task whose failure is only logged:
+// the component works without it, slower or with less.
+func (l *Lifecycle) OnWarmupOptional(name string, fn func(ctx context.Context) error) {
+	l.onWarmup(warmupHook{name, false, fn})
+}
+
+func (l *Lifecycle) onWarmup(h warmupHook) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.warmups = append(l.warmups, h)
+}
+
+// Warmup runs the warmup tasks at once, logging how long each took, and
+// returns when all have. A task still running after timeout is abandoned
+// as timed out. It returns the errors of the fatal tasks that failed or
+// timed out; the others are logged as warnings. Tasks registered once it
+// has started do not run.
+func (l *Lifecycle) Warmup(ctx context.Context, timeout time.Duration) error {
+	l.mu.Lock()
+	hooks := slices.Clone(l.warmups)
+	l.warmupStart = time.Now()
+	l.warmupResults = make([]WarmupTask, len(hooks))
+	for i, h := range hooks {
+		l.warmupResults[i] = WarmupTask{Name: h.name, Fatal: h.fatal, Status: warmupRunning}
+	}
+	l.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	errs := make([]error, len(hooks))
+	var wg sync.WaitGroup
+	for i, h := range hooks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			err := runHook(ctx, h.fn)
+			took := time.Since(start)
+			res := WarmupTask{Name: h.name, Fatal: h.fatal, Status: warmupOK, DurationMS: took.Milliseconds()}
+			var outcome string
+			switch {
+			case err == nil:
+				log.Printf("Warmup: %s done in %s", h.name, took.Round(time.Millisecond))
+			case ctx.Err() != nil:
+				res.Status, res.Error = warmupTimeout, fmt.Sprintf("did not finish within %s", timeout)
+				outcome = res.Error
+			default:
+				res.Status, res.Error = warmupFailed, err.Error()
+				outcome = fmt.Sprintf("failed after %s: %v", took.Round(time.Millisecond), err)
+			}
+			if err != nil {
+				if h.fatal {
+					log.Printf("Warmup: %s %s", h.name, outcome)
+					errs[i] = fmt.Errorf("%s: %s", h.name, res.Error)
+				} else {
+					log.Printf("WARN warmup: %s %s; continuing without it", h.name, outcome)
+				}
+			}
+			l.mu.Lock()
+			l.warmupResults[i] = res
+			l.mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	err := errors.Join(errs...)
+	l.mu.Lock()
+	l.warmed, l.warmupErr = true, err
+	l.mu.Unlock()
+	return err
+}
+
+// warmupState reports whether Warmup has returned, and with what error.
+func (l *Lifecycle) warmupState() (done bool, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.warmed, l.warmupErr
+}
+
+// warmupTasks returns how the warmup tasks went, or are going: a running
+// one's duration is how long it has run so far. It is nil before Warmup.
+func (l *Lifecycle) warmupTasks() []WarmupTask {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	tasks := slices.Clone(l.warmupResults)
+	for i := range tasks {
+		if tasks[i].Status == warmupRunning {
+			tasks[i].DurationMS = time.Since(l.warmupStart).Milliseconds()
+		}
+	}
+	return tasks
+}
+
+// runHook runs fn, waiting for it no longer than ctx.
+func runHook(ctx context.Context, fn func(context.Context) error) error {
+	done := make(chan error, 1)
+	go func() { done <- fn(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		// Give a hook that honors ctx the chance to report its own error.
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(10 * time.Millisecond):
+			return errHookAbandoned
+		}
+	}
+}
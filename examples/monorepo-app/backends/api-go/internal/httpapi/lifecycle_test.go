@@ -0,0 +1,193 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShutdownReverseOrder(t *testing.T) {
+	logs := quietLog(t)
+	var l Lifecycle
+	var ran []string
+	for _, name := range []string{"store", "webhooks", "events"} {
+		l.OnShutdown(name, func(context.Context) error {
+			ran = append(ran, name)
+			if name == "webhooks" {
+				return errors.New("2 deliveries lost")
+			}
+			return nil
+		})
+	}
+	err := l.Shutdown(context.Background())
+	if want := []string{"events", "webhooks", "store"}; !slices.Equal(ran, want) {
+		t.Errorf("ran %v, want %v", ran, want)
+	}
+	if err == nil || err.Error() != "webhooks: 2 deliveries lost" {
+		t.Errorf("error %v", err)
+	}
+	for _, want := range []string{"Shutdown: events done in ", "Shutdown: webhooks failed after ", ": 2 deliveries lost", "Shutdown: store done in "} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("no %q in the log:\n%s", want, logs)
+		}
+	}
+}
+
+// A hook that outlives its share of SHUTDOWN_TIMEOUT is abandoned, and the
+// hooks after it still run, within the time that is left.
+func TestShutdownHookOverBudget(t *testing.T) {
+	logs := quietLog(t)
+	var l Lifecycle
+	var mu sync.Mutex
+	var ran []string
+	budgets := map[string]time.Duration{}
+	record := func(name string) func(context.Context) error {
+		return func(ctx context.Context) error {
+			deadline, _ := ctx.Deadline()
+			mu.Lock()
+			defer mu.Unlock()
+			ran = append(ran, name)
+			budgets[name] = time.Until(deadline)
+			return nil
+		}
+	}
+	stuck := make(chan struct{})
+	defer close(stuck)
+	l.OnShutdown("first", record("first"))
+	l.OnShutdown("second", record("second"))
+	l.OnShutdown("stuck", func(context.Context) error {
+		<-stuck // ignores ctx
+		return nil
+	})
+	l.OnShutdown("quick", record("quick"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 400*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := l.Shutdown(ctx)
+	took := time.Since(start)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"quick", "second", "first"}; !slices.Equal(ran, want) {
+		t.Errorf("ran %v, want %v", ran, want)
+	}
+	if !errors.Is(err, errHookAbandoned) || !strings.HasPrefix(err.Error(), "stuck: ") || strings.Contains(err.Error(), "second") {
+		t.Errorf("error %v", err)
+	}
+	// quick had a quarter of 400ms; stuck then a third of what was left,
+	// about 133ms; second and first split the rest.
+	if b := budgets["quick"]; b > 100*time.Millisecond || b < 50*time.Millisecond {
+		t.Errorf("quick's budget %s, want about 100ms", b)
+	}
+	if b := budgets["second"]; b > 150*time.Millisecond || b < 80*time.Millisecond {
+		t.Errorf("second's budget %s, want about 130ms", b)
+	}
+	if b := budgets["first"]; b > 270*time.Millisecond || b < 180*time.Millisecond {
+		t.Errorf("first's budget %s, want second's unused time too, about 260ms", b)
+	}
+	if took > 300*time.Millisecond {
+		t.Errorf("Shutdown took %s", took)
+	}
+	if !strings.Contains(logs.String(), "Shutdown: stuck did not finish within ") {
+		t.Errorf("the abandoned hook was not logged:\n%s", logs)
+	}
+}
+
+// A hook that honors ctx reports its own error when time runs out, and
+// without a deadline hooks get none.
+func TestShutdownHookContext(t *testing.T) {
+	quietLog(t)
+	var l Lifecycle
+	var hasDeadline bool
+	l.OnShutdown("unbounded", func(ctx context.Context) error {
+		_, hasDeadline = ctx.Deadline()
+		return nil
+	})
+	if err := l.Shutdown(context.Background()); err != nil || hasDeadline {
+		t.Errorf("without a deadline: %v, hook deadline %v", err, hasDeadline)
+	}
+
+	l = Lifecycle{}
+	l.OnShutdown("polite", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := l.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, errHookAbandoned) {
+		t.Errorf("a hook that honors ctx: %v", err)
+	}
+}
+
+// The tasks run at once; one still running at the timeout is abandoned,
+// and only a fatal one fails Warmup.
+func TestWarmupTimeout(t *testing.T) {
+	logs := quietLog(t)
+	var l Lifecycle
+	stuck := make(chan struct{})
+	defer close(stuck)
+	l.OnWarmup("cache", func(context.Context) error { <-stuck; return nil })
+	l.OnWarmupOptional("geoip", func(ctx context.Context) error { <-ctx.Done(); return ctx.Err() })
+	l.OnWarmup("store", func(context.Context) error { time.Sleep(50 * time.Millisecond); return nil })
+
+	start := time.Now()
+	err := l.Warmup(context.Background(), 100*time.Millisecond)
+	if took := time.Since(start); took < 100*time.Millisecond || took > 300*time.Millisecond {
+		t.Errorf("Warmup took %s, want about the 100ms timeout", took)
+	}
+	if err == nil || err.Error() != "cache: did not finish within 100ms" {
+		t.Errorf("error %v", err)
+	}
+	var statuses []string
+	for _, task := range l.warmupTasks() {
+		statuses = append(statuses, task.Name+" "+task.Status)
+	}
+	if want := []string{"cache timeout", "geoip timeout", "store ok"}; !slices.Equal(statuses, want) {
+		t.Errorf("tasks %v, want %v", statuses, want)
+	}
+	if done, stateErr := l.warmupState(); !done || stateErr == nil {
+		t.Errorf("state: done %v, %v", done, stateErr)
+	}
+	for _, want := range []string{
+		"Warmup: cache did not finish within 100ms",
+		"WARN warmup: geoip did not finish within 100ms; continuing without it",
+		"Warmup: store done in ",
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("no %q in the log:\n%s", want, logs)
+		}
+	}
+}
+
+// An optional task that fails is a warning; Warmup still succeeds.
+func TestWarmupOptionalFailure(t *testing.T) {
+	logs := quietLog(t)
+	var l Lifecycle
+	l.OnWarmup("store", func(context.Context) error { return nil })
+	l.OnWarmupOptional("disposable domains", func(context.Context) error { return errors.New("list unreachable") })
+
+	if err := l.Warmup(context.Background(), time.Second); err != nil {
+		t.Fatalf("an optional failure failed Warmup: %v", err)
+	}
+	if done, err := l.warmupState(); !done || err != nil {
+		t.Errorf("state: done %v, %v", done, err)
+	}
+	tasks := l.warmupTasks()
+	if len(tasks) != 2 || tasks[1].Fatal || tasks[1].Status != warmupFailed || tasks[1].Error != "list unreachable" {
+		t.Errorf("tasks %+v", tasks)
+	}
+	if !strings.Contains(logs.String(), "WARN warmup: disposable domains failed after ") || !strings.Contains(logs.String(), "list unreachable; continuing without it") {
+		t.Errorf("the failure was not logged as a warning:\n%s", logs)
+	}
+
+	l = Lifecycle{}
+	l.OnWarmup("store", func(context.Context) error { return errors.New("connection refused") })
+	if err := l.Warmup(context.Background(), time.Second); err == nil || err.Error() != "store: connection refused" {
+		t.Errorf("a fatal failure: %v", err)
+	}
+}
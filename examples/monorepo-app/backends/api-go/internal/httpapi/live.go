@@ -0,0 +1,686 @@
+package httpapi
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+)
+
+// Live event types pushed to /api/v1/ws and /api/v1/events clients.
+const (
+	LiveUserRegistered = "user.registered"
+	LiveUserSuspended  = "user.suspended"
+	LiveSessionRevoked = "session.revoked"
+)
+
+// LiveEvent is the JSON pushed to live clients. IDs increase by one per
+// event (per process) and are the SSE event IDs. The first WebSocket message
+// is {"type":"ready"}, once the connection is authenticated.
+type LiveEvent struct {
+	ID   uint64    `json:"id,omitempty"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data,omitempty"`
+}
+
+// liveAuthMessage is the first message of a client that did not send an
+// Authorization header (browsers can't set one on the handshake).
+type liveAuthMessage struct {
+	Type  string `json:"type"` // "auth"
+	Token string `json:"token"`
+}
+
+const (
+	liveSendBuffer   = 64  // queued messages per client
+	liveHistory      = 256 // recent events kept for SSE Last-Event-ID
+	sseHeartbeat     = 15 * time.Second
+	sseRetry         = 3 * time.Second // reconnection delay advertised to clients
+	liveAuthTimeout  = 10 * time.Second
+	liveWriteTimeout = 10 * time.Second
+	liveCloseWait    = 5 * time.Second // for the client's close frame
+	wsMaxMessage     = 4096            // clients only send the auth message
+	wsAcceptGUID     = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+// WebSocket opcodes and close codes.
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+
+	wsCloseNormal        = 1000
+	wsCloseGoingAway     = 1001
+	wsCloseProtocolError = 1002
+	wsClosePolicy        = 1008
+	wsCloseTooBig        = 1009
+	wsCloseTryAgainLater = 1013
+)
+
+// wsError is a protocol violation by the client, closed with code.
+type wsError struct {
+	code   int
+	reason string
+}
+
+func (e *wsError) Error() string { return fmt.Sprintf("websocket %d: %s", e.code, e.reason) }
+
+// LiveHub pushes user and session events from the bus to dashboards over
+// WebSocket (admins) and Server-Sent Events (any user). Each client has its
+// own send buffer; one that falls liveSendBuffer messages behind is
+// disconnected instead of slowing the others down, and is expected to
+// reconnect: SSE clients resume from Last-Event-ID, WebSocket clients
+// refetch.
+//
+// WebSocket connections are hijacked, so http.Server.Shutdown neither sees
+// nor waits for them, and SSE streams would hold it up: Close must be
+// called before shutting the servers down.
+type LiveHub struct {
+	cfg     *config.Config
+	events  *EventBus
+	origins func(string) bool
+	auth    http.Handler // Authorization header: Auth + admin role, then upgrade
+
+	mu      sync.Mutex
+	clients map[*liveClient]bool
+	history []liveMessage // oldest first, at most liveHistory
+	seq     uint64
+	conns   map[*liveConn]bool
+	pending int // upgrades admitted but not yet registered
+	streams int
+	closed  bool
+	done    chan struct{} // closed by Close, ends SSE streams
+	wg      sync.WaitGroup
+}
+
+// liveMessage is an encoded LiveEvent and who it is about.
+type liveMessage struct {
+	id    uint64
+	typ   string
+	owner string // user ID
+	body  []byte
+}
+
+// liveClient is one subscriber: a WebSocket connection or an SSE stream.
+// Admins receive every event, other users only session events about
+// themselves.
+type liveClient struct {
+	userID   string
+	admin    bool
+	ip       string
+	send     chan liveMessage
+	overflow func() // send was full; the message is lost to this client
+}
+
+func (c *liveClient) wants(m liveMessage) bool {
+	return c.admin || (strings.HasPrefix(m.typ, "session.") && m.owner == c.userID)
+}
+
+func NewLiveHub(cfg *config.Config, mw *Middleware, events *EventBus) *LiveHub {
+	hub := &LiveHub{
+		cfg: cfg, events: events, origins: mw.OriginAllowed,
+		clients: make(map[*liveClient]bool), conns: make(map[*liveConn]bool), done: make(chan struct{}),
+	}
+	hub.auth = Chain(mw.Auth, mw.RequireRole("admin"))(http.HandlerFunc(hub.upgrade))
+	return hub
+}
+
+// Subscribe forwards the events live clients receive from bus.
+func (hub *LiveHub) Subscribe(bus *EventBus) {
+	UserRegistered.Subscribe(bus, Sync, func(_ context.Context, e UserEvent) {
+		hub.broadcast(LiveUserRegistered, e.User.ID, e.User)
+	})
+	UserSuspended.Subscribe(bus, Sync, func(_ context.Context, e UserEvent) {
+		hub.broadcast(LiveUserSuspended, e.User.ID, e.User)
+	})
+	SessionRevoked.Subscribe(bus, Sync, func(_ context.Context, e SessionEvent) {
+		data := map[string]any{"user_id": e.UserID, "reason": e.Reason}
+		if e.SessionID != "" {
+			data["session_id"] = e.SessionID
+		}
+		hub.broadcast(LiveSessionRevoked, e.UserID, data)
+	})
+}
+
+// broadcast records the event in the history and queues it for every
+// client that wants it. owner is the user the event is about.
+func (hub *LiveHub) broadcast(typ, owner string, data any) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	body, err := json.Marshal(LiveEvent{ID: hub.seq + 1, Type: typ, Time: time.Now().UTC(), Data: data})
+	if err != nil {
+		log.Printf("ERROR live: encode %s: %v", typ, err)
+		return
+	}
+	hub.seq++
+	m := liveMessage{id: hub.seq, typ: typ, owner: owner, body: body}
+	if len(hub.history) == liveHistory {
+		hub.history = slices.Delete(hub.history, 0, 1)
+	}
+	hub.history = append(hub.history, m)
+	for c := range hub.clients {
+		if !c.wants(m) {
+			continue
+		}
+		select {
+		case c.send <- m:
+		default:
+			log.Printf("WARN live: client %s (user %s) fell behind, disconnecting", c.ip, c.userID)
+			delete(hub.clients, c)
+			go c.overflow()
+		}
+	}
+}
+
+// Stream serves the events as Server-Sent Events. A reconnecting client's
+// Last-Event-ID replays what it missed from the history; when that is no
+// longer possible (too old, or the server restarted) a "reset" event tells
+// it to refetch. Comments are sent every sseHeartbeat so proxies don't time
+// the stream out.
+func (hub *LiveHub) Stream(w http.ResponseWriter, r *http.Request) {
+	var lastID uint64
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			writeErrorCode(w, r, http.StatusBadRequest, api.ErrCodeInvalidRequest, "invalid Last-Event-ID")
+			return
+		}
+		lastID = id
+	}
+	userID, _ := r.Context().Value(ctxUserID).(string)
+	role, _ := r.Context().Value(ctxRole).(string)
+	dropped := make(chan struct{})
+	c := &liveClient{userID: userID, admin: role == "admin", ip: clientIP(r), send: make(chan liveMessage, liveSendBuffer),
+		overflow: func() { close(dropped) }}
+
+	// Subscribe and copy the backlog under one lock so that nothing is
+	// missed or sent twice in between.
+	hub.mu.Lock()
+	switch {
+	case hub.closed:
+		hub.mu.Unlock()
+		writeErrorCode(w, r, http.StatusServiceUnavailable, api.ErrCodeShuttingDown, "server is shutting down")
+		return
+	case hub.streams >= hub.cfg.SSEMaxStreams:
+		hub.mu.Unlock()
+		w.Header().Set("Retry-After", "5")
+		writeErrorCode(w, r, http.StatusServiceUnavailable, api.ErrCodeOverloaded, "too many live connections, please retry")
+		return
+	case r.Method == http.MethodHead:
+		// The stream's headers, without holding a stream open.
+		hub.mu.Unlock()
+		setSSEHeaders(w)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	var backlog []liveMessage
+	reset := lastID > hub.seq || (lastID > 0 && len(hub.history) > 0 && hub.history[0].id > lastID+1)
+	for _, m := range hub.history {
+		if m.id > lastID && c.wants(m) {
+			backlog = append(backlog, m)
+		}
+	}
+	hub.streams++
+	hub.clients[c] = true
+	hub.mu.Unlock()
+	defer func() {
+		hub.mu.Lock()
+		hub.streams--
+		delete(hub.clients, c)
+		hub.mu.Unlock()
+	}()
+
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{}) // the stream outlives SERVER_WRITE_TIMEOUT
+	setSSEHeaders(w)
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry.Milliseconds())
+	if reset {
+		fmt.Fprint(w, "event: reset\ndata: {\"type\":\"reset\"}\n\n")
+	}
+	for _, m := range backlog {
+		writeSSE(w, m)
+	}
+	if err := rc.Flush(); err != nil {
+		log.Printf("ERROR live: SSE stream can't flush: %v", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case m := <-c.send:
+			err = writeSSE(w, m)
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+		case <-dropped:
+			return // the client reconnects with Last-Event-ID
+		case <-hub.done:
+			return
+		case <-r.Context().Done():
+			return
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func setSSEHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx
+}
+
+// writeSSE writes m as one event. The JSON body has no raw newlines, so it
+// fits on a single data line.
+func writeSSE(w io.Writer, m liveMessage) error {
+	_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", m.id, m.typ, m.body)
+	return err
+}
+
+// ServeHTTP checks the origin and upgrades the request. With an
+// Authorization header the usual Auth and admin checks run before the
+// upgrade; without one the client must send liveAuthMessage first.
+func (hub *LiveHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if origin := r.Header.Get("Origin"); origin != "" && !hub.origins(origin) {
+		writeErrorCode(w, r, http.StatusForbidden, api.ErrCodeForbidden, "origin not allowed")
+		return
+	}
+	if r.Header.Get("Authorization") != "" {
+		hub.auth.ServeHTTP(w, r)
+		return
+	}
+	hub.upgrade(w, r)
+}
+
+func (hub *LiveHub) upgrade(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.ProtoMajor != 1 || key == "" || !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		writeErrorCode(w, r, http.StatusBadRequest, api.ErrCodeInvalidRequest, "expected a WebSocket upgrade over HTTP/1.1")
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		writeErrorCode(w, r, http.StatusUpgradeRequired, api.ErrCodeInvalidRequest, "unsupported WebSocket version")
+		return
+	}
+	hub.mu.Lock()
+	switch {
+	case hub.closed:
+		hub.mu.Unlock()
+		writeErrorCode(w, r, http.StatusServiceUnavailable, api.ErrCodeShuttingDown, "server is shutting down")
+		return
+	case len(hub.conns)+hub.pending >= hub.cfg.WSMaxConnections:
+		hub.mu.Unlock()
+		w.Header().Set("Retry-After", "5")
+		writeErrorCode(w, r, http.StatusServiceUnavailable, api.ErrCodeOverloaded, "too many live connections, please retry")
+		return
+	}
+	hub.pending++
+	hub.mu.Unlock()
+
+	sum := sha1.Sum([]byte(key + wsAcceptGUID))
+	w.Header().Set("Upgrade", "websocket")
+	w.Header().Set("Connection", "Upgrade")
+	w.Header().Set("Sec-WebSocket-Accept", base64.StdEncoding.EncodeToString(sum[:]))
+	w.WriteHeader(http.StatusSwitchingProtocols)
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		hub.register(nil)
+		log.Printf("ERROR live: hijack: %v", err)
+		return
+	}
+	_ = conn.SetDeadline(time.Time{}) // clear the server's read/write timeouts
+
+	c := &liveConn{hub: hub, conn: conn, br: rw.Reader, done: make(chan struct{})}
+	c.client = liveClient{admin: true, ip: clientIP(r), send: make(chan liveMessage, liveSendBuffer),
+		overflow: func() { c.close(wsCloseTryAgainLater, "client too slow") }}
+	c.client.userID, _ = r.Context().Value(ctxUserID).(string)
+	if !hub.register(c) {
+		c.close(wsCloseGoingAway, "server shutting down")
+		_ = conn.Close()
+		return
+	}
+	// The handler returns now, so the connection holds no concurrency slot
+	// and does not count as in flight.
+	ctx := context.WithoutCancel(eventContext(r))
+	go func() {
+		defer hub.wg.Done()
+		c.run(ctx, r.URL.Path)
+	}()
+}
+
+// register turns a pending upgrade into a connection (nil: the upgrade
+// failed) that Close waits for. It reports false once the hub is closed.
+func (hub *LiveHub) register(c *liveConn) bool {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	hub.pending--
+	if c == nil || hub.closed {
+		return false
+	}
+	hub.conns[c] = true
+	hub.wg.Add(1)
+	return true
+}
+
+// Close ends SSE streams, sends every WebSocket connection a 1001 close
+// frame and waits for them to end, or for ctx to end (then they are
+// dropped).
+func (hub *LiveHub) Close(ctx context.Context) error {
+	hub.mu.Lock()
+	if !hub.closed {
+		hub.closed = true
+		close(hub.done)
+	}
+	conns := slices.Collect(maps.Keys(hub.conns))
+	hub.mu.Unlock()
+	for _, c := range conns {
+		go c.close(wsCloseGoingAway, "server shutting down")
+	}
+	done := make(chan struct{})
+	go func() {
+		hub.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		for _, c := range conns {
+			_ = c.conn.Close()
+		}
+		return ctx.Err()
+	}
+}
+
+// headerHasToken reports whether the comma-separated header name contains
+// token, case-insensitively.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for t := range strings.SplitSeq(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// liveConn is one WebSocket connection. run reads; a second goroutine
+// writes queued events and pings. Frame writes are serialized by wmu.
+type liveConn struct {
+	hub    *LiveHub
+	conn   net.Conn
+	br     *bufio.Reader
+	client liveClient
+	ready  atomic.Bool // authenticated, receives events
+
+	wmu       sync.Mutex
+	done      chan struct{} // closed once a close frame was sent
+	closeOnce sync.Once
+}
+
+func (c *liveConn) run(ctx context.Context, path string) {
+	defer func() {
+		c.hub.mu.Lock()
+		delete(c.hub.conns, c)
+		delete(c.hub.clients, &c.client)
+		c.hub.mu.Unlock()
+		_ = c.conn.Close()
+	}()
+	if c.client.userID == "" && !c.authenticate(ctx, path) {
+		return
+	}
+	ready, _ := json.Marshal(LiveEvent{Type: "ready", Time: time.Now().UTC()})
+	if c.writeFrame(wsText, ready) != nil {
+		return
+	}
+	c.ready.Store(true)
+	c.hub.mu.Lock()
+	c.hub.clients[&c.client] = true
+	c.hub.mu.Unlock()
+	_ = c.conn.SetReadDeadline(time.Now().Add(2 * c.hub.cfg.WSPingInterval))
+
+	writer := make(chan struct{})
+	go func() {
+		defer close(writer)
+		c.writeLoop()
+	}()
+	for {
+		if _, _, err := c.next(); err != nil {
+			break
+		}
+		// Nothing is expected from an authenticated client.
+	}
+	c.close(wsCloseNormal, "")
+	_ = c.conn.Close()
+	<-writer
+}
+
+// authenticate reads the auth message and checks its token as Auth and
+// RequireRole("admin") would.
+func (c *liveConn) authenticate(ctx context.Context, path string) bool {
+	_ = c.conn.SetReadDeadline(time.Now().Add(liveAuthTimeout))
+	_, msg, err := c.next()
+	if err != nil {
+		return false
+	}
+	var hello liveAuthMessage
+	if json.Unmarshal(msg, &hello) != nil || hello.Type != "auth" || hello.Token == "" {
+		c.close(wsClosePolicy, `expected {"type":"auth","token":"..."}`)
+		return false
+	}
+	claims, err := verifyToken(c.hub.cfg, hello.Token)
+	if err != nil {
+		code, message := authErrorCode(err)
+		AuthRejected.Publish(ctx, c.hub.events, RejectionEvent{
+			Reason: code, Details: map[string]string{"error_code": code, "path": path},
+		})
+		c.close(wsClosePolicy, message)
+		return false
+	}
+	if claims.Role != "admin" {
+		c.close(wsClosePolicy, "insufficient permissions")
+		return false
+	}
+	c.client.userID = claims.UserID
+	return true
+}
+
+func (c *liveConn) writeLoop() {
+	ping := time.NewTicker(c.hub.cfg.WSPingInterval)
+	defer ping.Stop()
+	for {
+		var err error
+		select {
+		case m := <-c.client.send:
+			err = c.writeFrame(wsText, m.body)
+		case <-ping.C:
+			err = c.writeFrame(wsPing, nil)
+		case <-c.done:
+			return
+		}
+		if err != nil {
+			_ = c.conn.Close() // unblocks the reader
+			return
+		}
+	}
+}
+
+// next returns the next data message, answering pings and, once
+// authenticated, extending the read deadline by two ping intervals on every
+// frame. A client close frame is echoed and returned as io.EOF; protocol
+// violations close the connection with their code.
+func (c *liveConn) next() (op byte, msg []byte, err error) {
+	op, msg, err = c.nextMessage()
+	var wsErr *wsError
+	if errors.As(err, &wsErr) {
+		c.close(wsErr.code, wsErr.reason)
+	}
+	return op, msg, err
+}
+
+func (c *liveConn) nextMessage() (op byte, msg []byte, err error) {
+	for {
+		fin, frameOp, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		if c.ready.Load() {
+			c.wmu.Lock()
+			select {
+			case <-c.done:
+			default:
+				_ = c.conn.SetReadDeadline(time.Now().Add(2 * c.hub.cfg.WSPingInterval))
+			}
+			c.wmu.Unlock()
+		}
+
+		switch frameOp {
+		case wsPing:
+			_ = c.writeFrame(wsPong, payload)
+		case wsPong:
+		case wsClose:
+			code := wsCloseNormal
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			c.close(code, "")
+			return 0, nil, io.EOF
+		case wsText, wsBinary, wsContinuation:
+			if (frameOp == wsContinuation) != (op != 0) {
+				return 0, nil, &wsError{wsCloseProtocolError, "unexpected continuation frame"}
+			}
+			if op == 0 {
+				op = frameOp
+			}
+			if len(msg)+len(payload) > wsMaxMessage {
+				return 0, nil, &wsError{wsCloseTooBig, "message too big"}
+			}
+			msg = append(msg, payload...)
+			if fin {
+				return op, msg, nil
+			}
+		default:
+			return 0, nil, &wsError{wsCloseProtocolError, "unknown opcode"}
+		}
+	}
+}
+
+// readFrame reads one client frame. Client frames must be masked; control
+// frames must be final and at most 125 bytes.
+func (c *liveConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err = io.ReadFull(c.br, hdr[:]); err != nil {
+		return
+	}
+	fin, op = hdr[0]&0x80 != 0, hdr[0]&0x0f
+	if hdr[0]&0x70 != 0 || hdr[1]&0x80 == 0 {
+		return false, 0, nil, &wsError{wsCloseProtocolError, "reserved bits set or frame not masked"}
+	}
+	n := uint64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if op >= wsClose && (n > 125 || !fin) {
+		return false, 0, nil, &wsError{wsCloseProtocolError, "invalid control frame"}
+	}
+	if n > wsMaxMessage {
+		return false, 0, nil, &wsError{wsCloseTooBig, "message too big"}
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+var errLiveClosed = errors.New("live connection closed")
+
+// writeFrame sends one unmasked, final frame, unless the connection is
+// closing.
+func (c *liveConn) writeFrame(op byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	select {
+	case <-c.done:
+		return errLiveClosed
+	default:
+	}
+	return c.write(op, payload)
+}
+
+func (c *liveConn) write(op byte, payload []byte) error {
+	frame := make([]byte, 0, len(payload)+10)
+	frame = append(frame, 0x80|op)
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = binary.BigEndian.AppendUint16(append(frame, 126), uint16(n))
+	default:
+		frame = binary.BigEndian.AppendUint64(append(frame, 127), uint64(n))
+	}
+	frame = append(frame, payload...)
+	_ = c.conn.SetWriteDeadline(time.Now().Add(liveWriteTimeout))
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// close sends a close frame once, stops the writer and gives the client
+// liveCloseWait to answer before the reader gives up.
+func (c *liveConn) close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.wmu.Lock()
+		defer c.wmu.Unlock()
+		_ = c.write(wsClose, append(binary.BigEndian.AppendUint16(nil, uint16(code)), reason...))
+		close(c.done)
+		_ = c.conn.SetReadDeadline(time.Now().Add(liveCloseWait))
+	})
+}
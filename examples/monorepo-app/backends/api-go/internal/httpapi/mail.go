@@ -0,0 +1,393 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	mrand "math/rand/v2"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/internal/auth"
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+)
+
+// Message is one outgoing email. Text is required; with HTML as well the
+// message is sent as multipart/alternative.
+type Message struct {
+	To      []string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer sends email. Send blocks until the message is handed off; request
+// handlers go through MailQueue instead, which retries off the request path.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// NewMailer returns the MAIL_DRIVER implementation.
+func NewMailer(cfg *config.Config, out *Outbound) Mailer {
+	if cfg.MailDriver == "smtp" {
+		return NewSMTPMailer(cfg, out.Breaker("smtp"))
+	}
+	return LogMailer{}
+}
+
+// SMTPMailer submits messages to an SMTP relay, opening a connection per
+// message. SMTP_TLS selects "starttls" (required, not opportunistic),
+// implicit "tls" (port 465) or "none". While the relay keeps failing,
+// breaker refuses sends with ErrCircuitOpen.
+type SMTPMailer struct {
+	addr, host string
+	from       string
+	username   string
+	password   string
+	tls        string
+	timeout    time.Duration
+	breaker    *Breaker
+}
+
+// NewSMTPMailer returns the SMTP mailer, guarded by breaker; a nil one
+// never refuses.
+func NewSMTPMailer(cfg *config.Config, breaker *Breaker) *SMTPMailer {
+	if breaker == nil {
+		breaker = NewBreaker("smtp", 0, 0)
+	}
+	return &SMTPMailer{
+		addr: net.JoinHostPort(cfg.SMTPHost, strconv.Itoa(cfg.SMTPPort)), host: cfg.SMTPHost,
+		from: cfg.MailFrom, username: cfg.SMTPUsername, password: cfg.SMTPPassword,
+		tls: cfg.SMTPTLS, timeout: cfg.SMTPTimeout, breaker: breaker,
+	}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	data, err := buildMessage(m.from, msg)
+	if err != nil {
+		return err
+	}
+	if err := m.breaker.Allow(time.Now()); err != nil {
+		return err
+	}
+	err = m.send(ctx, msg, data)
+	// A reply code is the relay answering, even one refusing the message.
+	var reply *textproto.Error
+	if !errors.Is(ctx.Err(), context.Canceled) && m.breaker.Record(err == nil || errors.As(err, &reply), time.Now()) {
+		m.breaker.logTrip()
+	}
+	return err
+}
+
+func (m *SMTPMailer) send(ctx context.Context, msg Message, data []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", m.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	_ = conn.SetDeadline(deadline) // one budget for the whole exchange
+	tlsConfig := &tls.Config{ServerName: m.host, MinVersion: tls.VersionTLS12}
+	if m.tls == "tls" {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if m.tls == "starttls" {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("smtp: server does not support STARTTLS")
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if m.username != "" {
+		if err := c.Auth(smtp.PlainAuth("", m.username, m.password, m.host)); err != nil {
+			return err
+		}
+	}
+	from, _ := mail.ParseAddress(m.from)
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, to := range msg.To {
+		addr, _ := mail.ParseAddress(to)
+		if err := c.Rcpt(addr.Address); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// buildMessage renders msg as an RFC 5322 message with quoted-printable
+// UTF-8 bodies. Addresses are validated, which also rules out header
+// injection through them; the subject is encoded as an RFC 2047 word.
+func buildMessage(from string, msg Message) ([]byte, error) {
+	if len(msg.To) == 0 {
+		return nil, errors.New("mail: no recipients")
+	}
+	if msg.Text == "" {
+		return nil, errors.New("mail: empty text body")
+	}
+	fromAddr, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("mail: from: %w", err)
+	}
+	to := make([]string, len(msg.To))
+	for i, addr := range msg.To {
+		a, err := mail.ParseAddress(addr)
+		if err != nil {
+			return nil, fmt.Errorf("mail: to %q: %w", addr, err)
+		}
+		to[i] = a.String()
+	}
+	var buf bytes.Buffer
+	header := func(k, v string) { fmt.Fprintf(&buf, "%s: %s\r\n", k, v) }
+	header("From", fromAddr.String())
+	header("To", strings.Join(to, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", strings.NewReplacer("\r", " ", "\n", " ").Replace(msg.Subject)))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", "<"+auth.GenerateID()+"@"+fromAddr.Address[strings.LastIndex(fromAddr.Address, "@")+1:]+">")
+	header("MIME-Version", "1.0")
+	part := func(w io.Writer, body string) {
+		qp := quotedprintable.NewWriter(w)
+		_, _ = qp.Write([]byte(body))
+		_ = qp.Close()
+	}
+	if msg.HTML == "" {
+		header("Content-Type", "text/plain; charset=utf-8")
+		header("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		part(&buf, msg.Text)
+		return buf.Bytes(), nil
+	}
+	mw := multipart.NewWriter(&buf)
+	header("Content-Type", `multipart/alternative; boundary="`+mw.Boundary()+`"`)
+	buf.WriteString("\r\n")
+	for _, p := range [][2]string{{"text/plain", msg.Text}, {"text/html", msg.HTML}} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {p[0] + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		part(w, p[1])
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// LogMailer writes messages to the server log instead of sending them, so
+// local flows (links in emails) can be followed from the console.
+type LogMailer struct{}
+
+func (LogMailer) Send(_ context.Context, msg Message) error {
+	log.Printf("MAIL to=%s subject=%q html=%t\n%s", strings.Join(msg.To, ","), msg.Subject, msg.HTML != "", msg.Text)
+	return nil
+}
+
+// CaptureMailer keeps sent messages in memory, for tests.
+type CaptureMailer struct {
+	mu   sync.Mutex
+	sent []Message
+}
+
+func (m *CaptureMailer) Send(_ context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// Sent returns the messages sent so far.
+func (m *CaptureMailer) Sent() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.sent)
+}
+
+// mailStats counts queued messages by outcome: sent, retried, failed (gave
+// up after MAIL_MAX_ATTEMPTS), dropped (queue full or shutting down) and
+// suppressed (the recipient opted out of the message's category).
+var mailStats = expvar.NewMap("mail")
+
+type mailJob struct {
+	ctx  context.Context // the enqueuing request's values, without its cancellation
+	kind string
+	msg  Message
+}
+
+// MailQueue sends messages from a bounded in-process queue, retrying
+// failures with exponential backoff. Enqueue never blocks or fails the
+// caller: undeliverable messages are counted in mailStats and published
+// as MailFailed, sent ones as MailSent; both land in the security audit
+// trail. Recipients suppressed reports are dropped before queueing.
+type MailQueue struct {
+	mailer      Mailer
+	events      *EventBus
+	suppressed  func(kind, to string) bool // nil: nothing is suppressed
+	maxAttempts int
+	backoff     time.Duration
+
+	mu     sync.RWMutex // guards queue against send-after-close
+	closed bool
+	queue  chan mailJob
+	abort  chan struct{} // closed when Stop's deadline passes
+	wg     sync.WaitGroup
+}
+
+func NewMailQueue(mailer Mailer, events *EventBus, suppressed func(kind, to string) bool, cfg *config.Config) *MailQueue {
+	return &MailQueue{
+		mailer:      mailer,
+		events:      events,
+		suppressed:  suppressed,
+		maxAttempts: cfg.MailMaxAttempts,
+		backoff:     cfg.MailBackoff,
+		queue:       make(chan mailJob, cfg.MailQueueSize),
+		abort:       make(chan struct{}),
+	}
+}
+
+// Start launches n sending workers.
+func (q *MailQueue) Start(n int) {
+	for range max(n, 1) {
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			for job := range q.queue {
+				q.deliver(job)
+			}
+		}()
+	}
+}
+
+// Enqueue queues msg; kind names the message type ("verification", ...)
+// for metrics and the audit trail, and decides which recipients may have
+// opted out of it.
+func (q *MailQueue) Enqueue(ctx context.Context, kind string, msg Message) {
+	if q.suppressed != nil {
+		msg.To = slices.DeleteFunc(slices.Clone(msg.To), func(to string) bool { return q.suppressed(kind, to) })
+		if len(msg.To) == 0 {
+			mailStats.Add("suppressed", 1)
+			return
+		}
+	}
+	job := mailJob{ctx: context.WithoutCancel(ctx), kind: kind, msg: msg}
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		q.fail(job, 0, "server shutting down", "dropped")
+		return
+	}
+	select {
+	case q.queue <- job:
+	default:
+		q.fail(job, 0, "queue full", "dropped")
+	}
+}
+
+func (q *MailQueue) deliver(job mailJob) {
+	for attempt := 1; ; attempt++ {
+		err := q.mailer.Send(job.ctx, job.msg)
+		if err == nil {
+			mailStats.Add("sent", 1)
+			MailSent.Publish(job.ctx, q.events, MailEvent{Kind: job.kind, To: job.msg.To, Attempts: attempt})
+			return
+		}
+		// Refused without trying: wait for the probe, without using up an
+		// attempt.
+		var open *CircuitOpenError
+		if errors.As(err, &open) {
+			attempt--
+			select {
+			case <-time.After(time.Until(open.Until)):
+				continue
+			case <-q.abort:
+				q.fail(job, attempt, "shutdown before retry: "+err.Error(), "failed")
+				return
+			}
+		}
+		if attempt >= q.maxAttempts {
+			q.fail(job, attempt, err.Error(), "failed")
+			return
+		}
+		mailStats.Add("retried", 1)
+		log.Printf("WARN mail %s: attempt %d failed, retrying: %v", job.kind, attempt, err)
+
+		// backoff, 2×backoff, 4×backoff... with up to 50% jitter, capped at 10m.
+		wait := min(q.backoff<<(attempt-1), 10*time.Minute)
+		wait += time.Duration(mrand.Int64N(int64(wait)/2 + 1))
+		select {
+		case <-time.After(wait):
+		case <-q.abort:
+			q.fail(job, attempt, "shutdown before retry: "+err.Error(), "failed")
+			return
+		}
+	}
+}
+
+func (q *MailQueue) fail(job mailJob, attempts int, reason, outcome string) {
+	mailStats.Add(outcome, 1)
+	log.Printf("WARN mail %s to %s not sent after %d attempt(s): %s", job.kind, strings.Join(job.msg.To, ","), attempts, reason)
+	MailFailed.Publish(job.ctx, q.events, MailEvent{Kind: job.kind, To: job.msg.To, Attempts: attempts, Reason: reason})
+}
+
+// Stop stops accepting messages and waits for the queue to drain. When ctx
+// ends first, pending retries are abandoned and Stop returns ctx's error
+// once in-flight sends finish.
+func (q *MailQueue) Stop(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.queue)
+	}
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		close(q.abort)
+		<-done
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,50 @@
+package httpapi
+
+import (
+	"sync"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+)
+
+// maintenanceRetryAfter is the Retry-After hint (seconds) sent with 503s.
+const maintenanceRetryAfter = 120
+
+// Maintenance holds the runtime maintenance switch. It is process-local:
+// toggles survive until restart, after which the config default applies,
+// or until a reload changes MAINTENANCE_MODE.
+type Maintenance struct {
+	mu      sync.RWMutex
+	enabled bool
+	message string
+	since   time.Time
+}
+
+func NewMaintenance(cfg *config.Config) *Maintenance {
+	return &Maintenance{enabled: cfg.MaintenanceMode, message: cfg.MaintenanceMessage, since: time.Now()}
+}
+
+func (m *Maintenance) Set(enabled bool, message string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if message != "" {
+		m.message = message
+	}
+	if m.enabled != enabled {
+		m.since = time.Now()
+	}
+	m.enabled = enabled
+}
+
+// SetMessage replaces the message without toggling the switch.
+func (m *Maintenance) SetMessage(message string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.message = message
+}
+
+func (m *Maintenance) Status() MaintenanceStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return MaintenanceStatus{Enabled: m.enabled, Message: m.message, Since: m.since}
+}
@@ -0,0 +1,100 @@
+package httpapi_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/httpapi"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+	"github.com/your-org/your-app/backends/api-go/raijintest"
+)
+
+func TestMaintenanceToggle(t *testing.T) {
+	srv := raijintest.NewServer(t)
+	admin := srv.CreateUser(t, "ops@example.com", raijintest.Password, "admin")
+	srv.CreateUser(t, "someone@example.com", raijintest.Password, "user")
+	adminClient, userClient := srv.ClientAs(t, admin), srv.LoginAs(t, "user")
+	plain := srv.Client()
+
+	var session httpapi.AuthResponse
+	wantStatus(t, send(t, plain, "POST", srv.URL+"/api/v1/auth/login",
+		api.LoginRequest{Email: "ops@example.com", Password: raijintest.Password}, &session), http.StatusOK)
+
+	wantStatus(t, send(t, adminClient, "POST", srv.URL+"/api/v1/admin/maintenance",
+		httpapi.MaintenanceRequest{Enabled: true, Message: "upgrading"}, nil), http.StatusOK)
+
+	resp := send(t, userClient, "GET", srv.URL+"/api/v1/users/me", nil, nil)
+	wantStatus(t, resp, http.StatusServiceUnavailable)
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("maintenance 503 without Retry-After")
+	}
+	wantStatus(t, send(t, plain, "GET", srv.URL+"/health", nil, nil), http.StatusOK)
+
+	// Users can't get in; admins still can, with a password or a refresh
+	// token, once their access token expires.
+	wantStatus(t, send(t, plain, "POST", srv.URL+"/api/v1/auth/login",
+		api.LoginRequest{Email: "someone@example.com", Password: raijintest.Password}, nil), http.StatusServiceUnavailable)
+	wantStatus(t, send(t, plain, "POST", srv.URL+"/api/v1/auth/login",
+		api.LoginRequest{Email: "ops@example.com", Password: raijintest.Password}, nil), http.StatusOK)
+	var refreshed httpapi.AuthResponse
+	wantStatus(t, send(t, plain, "POST", srv.URL+"/api/v1/auth/refresh",
+		api.RefreshRequest{RefreshToken: session.RefreshToken}, &refreshed), http.StatusOK)
+
+	wantStatus(t, send(t, adminClient, "POST", srv.URL+"/api/v1/admin/maintenance",
+		httpapi.MaintenanceRequest{Enabled: false}, nil), http.StatusOK)
+	wantStatus(t, send(t, userClient, "GET", srv.URL+"/api/v1/users/me", nil, nil), http.StatusOK)
+	wantStatus(t, send(t, plain, "POST", srv.URL+"/api/v1/auth/login",
+		api.LoginRequest{Email: "someone@example.com", Password: raijintest.Password}, nil), http.StatusOK)
+}
+
+// A user's refresh during maintenance is refused without spending the
+// token, which works again once maintenance is over.
+func TestMaintenanceKeepsUserRefreshTokens(t *testing.T) {
+	srv := raijintest.NewServer(t)
+	adminClient := srv.LoginAs(t, "admin")
+	srv.CreateUser(t, "someone@example.com", raijintest.Password, "user")
+	plain := srv.Client()
+
+	var session httpapi.AuthResponse
+	wantStatus(t, send(t, plain, "POST", srv.URL+"/api/v1/auth/login",
+		api.LoginRequest{Email: "someone@example.com", Password: raijintest.Password}, &session), http.StatusOK)
+	wantStatus(t, send(t, adminClient, "POST", srv.URL+"/api/v1/admin/maintenance",
+		httpapi.MaintenanceRequest{Enabled: true}, nil), http.StatusOK)
+	wantStatus(t, send(t, plain, "POST", srv.URL+"/api/v1/auth/refresh",
+		api.RefreshRequest{RefreshToken: session.RefreshToken}, nil), http.StatusServiceUnavailable)
+	wantStatus(t, send(t, adminClient, "POST", srv.URL+"/api/v1/admin/maintenance",
+		httpapi.MaintenanceRequest{Enabled: false}, nil), http.StatusOK)
+	wantStatus(t, send(t, plain, "POST", srv.URL+"/api/v1/auth/refresh",
+		api.RefreshRequest{RefreshToken: session.RefreshToken}, nil), http.StatusOK)
+}
+
+func TestMaintenanceModeReload(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.MaintenanceMode = true
+	srv, err := httpapi.New(cfg, store.NewMemory(), httpapi.WithMailer(&httpapi.CaptureMailer{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Warmup(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv.Handler)
+	t.Cleanup(func() {
+		ts.Close()
+		srv.Close(context.Background())
+	})
+
+	wantStatus(t, send(t, ts.Client(), "GET", ts.URL+"/api/v1/auth/whoami", nil, nil), http.StatusServiceUnavailable)
+
+	next := newTestConfig()
+	srv.Reload(next)
+	wantStatus(t, send(t, ts.Client(), "GET", ts.URL+"/api/v1/auth/whoami", nil, nil), http.StatusUnauthorized)
+
+	next = newTestConfig()
+	next.MaintenanceMode = true
+	srv.Reload(next)
+	wantStatus(t, send(t, ts.Client(), "GET", ts.URL+"/api/v1/auth/whoami", nil, nil), http.StatusServiceUnavailable)
+}
@@ -0,0 +1,1397 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/auth"
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+	"github.com/your-org/your-app/backends/api-go/reqsign"
+)
+
+type contextKey string
+
+const (
+	ctxUserID contextKey = "user_id"
+	ctxEmail  contextKey = "email"
+	ctxRole   contextKey = "role"
+	ctxAuthAt contextKey = "auth_time" // int64, 0 for refreshed tokens
+	// ctxCredential is where Auth found the request's credential, such as
+	// credentialBearer; CSRFProtection can only relax for some sources.
+	ctxCredential contextKey = "credential"
+	// ctxClaims holds the verified *auth.Claims of the access token.
+	ctxClaims contextKey = "claims"
+	// ctxOrg holds the OrgContext of a token acting in an organization.
+	ctxOrg contextKey = "org"
+	// ctxInternalCaller holds the config.InternalCaller of a signed request.
+	ctxInternalCaller contextKey = "internal_caller"
+
+	ctxRequestInfo contextKey = "request_info"
+	ctxRequestMeta contextKey = "request_meta"
+	ctxTrace       contextKey = "trace"
+	ctxErrorFormat contextKey = "error_format"
+)
+
+// credentialBearer is the ctxCredential of an access token sent in the
+// Authorization header. A browser never attaches one on its own, so a
+// forged cross-site request cannot carry it.
+const credentialBearer = "bearer"
+
+type Middleware struct {
+	cfg         *config.Config
+	store       store.Store
+	maintenance *Maintenance
+	events      *EventBus
+	callers     map[string]config.InternalCaller // INTERNAL_CALLERS by name
+
+	// Swapped by Reload; everything else in cfg is fixed for the process.
+	origins atomic.Pointer[map[string]bool]
+	headers atomic.Pointer[http.Header]
+}
+
+func NewMiddleware(cfg *config.Config, st store.Store, maintenance *Maintenance, events *EventBus) *Middleware {
+	m := &Middleware{cfg: cfg, store: st, maintenance: maintenance, events: events, callers: make(map[string]config.InternalCaller)}
+	for _, c := range cfg.InternalAuth.Callers {
+		m.callers[c.Name] = c
+	}
+	m.Reload(cfg)
+	return m
+}
+
+// Reload swaps in the CORS origins and security headers from cfg.
+func (m *Middleware) Reload(cfg *config.Config) {
+	origins := make(map[string]bool, len(cfg.AllowedOrigins))
+	for _, o := range cfg.AllowedOrigins {
+		origins[strings.TrimSpace(o)] = true
+	}
+	headers := securityHeaders(cfg)
+	m.origins.Store(&origins)
+	m.headers.Store(&headers)
+}
+
+type errorFormat struct {
+	problem  bool
+	typeBase string
+}
+
+// ErrorFormat decides once per request whether errors are rendered as
+// problem+json (ERROR_FORMAT=problem, or the client Accepts it). It must
+// wrap every handler and middleware that can write an error.
+func (m *Middleware) ErrorFormat(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ef := errorFormat{
+			problem:  m.cfg.ErrorFormat == "problem" || strings.Contains(r.Header.Get("Accept"), "application/problem+json"),
+			typeBase: m.cfg.ProblemTypeBase,
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxErrorFormat, ef)))
+	})
+}
+
+// CSP builds a Content-Security-Policy, keeping directives in insertion order.
+type CSP struct {
+	order      []string
+	directives map[string][]string
+}
+
+// DefaultCSP is the policy the API has always sent.
+func DefaultCSP() *CSP {
+	return ParseCSP("default-src 'none'; script-src 'self'; style-src 'self' 'unsafe-inline'; " +
+		"img-src 'self' data:; font-src 'self'; connect-src 'self'; " +
+		"base-uri 'self'; form-action 'self'; frame-ancestors 'none'")
+}
+
+// ParseCSP reads "directive src src; directive ..." into a CSP.
+func ParseCSP(policy string) *CSP {
+	c := &CSP{directives: make(map[string][]string)}
+	for _, part := range strings.Split(policy, ";") {
+		fields := strings.Fields(part)
+		if len(fields) > 0 {
+			c.Add(fields[0], fields[1:]...)
+		}
+	}
+	return c
+}
+
+// Add appends sources to a directive, creating it if needed.
+func (c *CSP) Add(directive string, sources ...string) {
+	directive = strings.ToLower(directive)
+	existing, ok := c.directives[directive]
+	if !ok {
+		c.order = append(c.order, directive)
+	}
+	for _, src := range sources {
+		if !slices.Contains(existing, src) {
+			existing = append(existing, src)
+		}
+	}
+	c.directives[directive] = existing
+}
+
+// Merge adds every directive of other. A directive that was 'none' is
+// replaced rather than extended, since 'none' can't be combined.
+func (c *CSP) Merge(other *CSP) {
+	for _, d := range other.order {
+		if srcs := c.directives[d]; len(srcs) == 1 && srcs[0] == "'none'" {
+			c.directives[d] = nil
+		}
+		c.Add(d, other.directives[d]...)
+	}
+}
+
+func (c *CSP) Remove(directive string) {
+	directive = strings.ToLower(directive)
+	delete(c.directives, directive)
+	c.order = slices.DeleteFunc(c.order, func(d string) bool { return d == directive })
+}
+
+func (c *CSP) String() string {
+	parts := make([]string, 0, len(c.order))
+	for _, d := range c.order {
+		parts = append(parts, strings.Join(append([]string{d}, c.directives[d]...), " "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// securityHeaders renders the configured header set once at startup.
+func securityHeaders(cfg *config.Config) http.Header {
+	sc := cfg.Security
+	h := make(http.Header)
+	h.Set("X-Content-Type-Options", "nosniff")
+	if sc.FrameProtection != "frame-ancestors" {
+		h.Set("X-Frame-Options", "DENY")
+	}
+	if sc.XSSProtection {
+		h.Set("X-XSS-Protection", "1; mode=block")
+	}
+	h.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+	h.Set("Permissions-Policy", "camera=(), microphone=(), geolocation=()")
+
+	csp := DefaultCSP()
+	if sc.CSPOverride != "" {
+		csp = ParseCSP(sc.CSPOverride)
+	} else if sc.CSPExtra != "" {
+		csp.Merge(ParseCSP(sc.CSPExtra))
+	}
+	if sc.FrameProtection == "x-frame-options" {
+		csp.Remove("frame-ancestors")
+	}
+	h.Set("Content-Security-Policy", csp.String())
+
+	if cfg.Environment == "production" {
+		hsts := "max-age=" + strconv.Itoa(sc.HSTSMaxAge)
+		if sc.HSTSIncludeSubDomains {
+			hsts += "; includeSubDomains"
+		}
+		if sc.HSTSPreload {
+			hsts += "; preload"
+		}
+		h.Set("Strict-Transport-Security", hsts)
+	}
+	return h
+}
+
+func (m *Middleware) SecurityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range *m.headers.Load() {
+			w.Header()[k] = v
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RelaxCSP merges extra ("directive src ...; ...") into the policy set by
+// SecurityHeaders, for the routes it wraps only. SecurityHeaders must run
+// outside it.
+func RelaxCSP(extra string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if policy := w.Header().Get("Content-Security-Policy"); policy != "" {
+				csp := ParseCSP(policy)
+				csp.Merge(ParseCSP(extra))
+				w.Header().Set("Content-Security-Policy", csp.String())
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// OriginAllowed reports whether origin is one of CORS_ORIGINS.
+func (m *Middleware) OriginAllowed(origin string) bool { return (*m.origins.Load())[origin] }
+
+func (m *Middleware) CORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin != "" && m.OriginAllowed(origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-CSRF-Token, X-Request-ID, If-None-Match, Idempotency-Key, traceparent, tracestate")
+			w.Header().Set("Access-Control-Expose-Headers", "ETag, X-Token-Expires-In, X-Session-Expires-At, X-Reauth-Required")
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+			w.Header().Set("Access-Control-Max-Age", "86400")
+			w.Header().Set("Vary", "Origin")
+		}
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+var (
+	errMissingAuth = errors.New("missing authorization header")
+	errInvalidAuth = errors.New("invalid authorization format")
+)
+
+// bearerClaims extracts and verifies the Bearer token on r. Token errors
+// wrap auth.ErrTokenInvalid or auth.ErrTokenExpired.
+func bearerClaims(r *http.Request, cfg *config.Config) (*auth.Claims, error) {
+	h := r.Header.Get("Authorization")
+	if h == "" {
+		return nil, errMissingAuth
+	}
+	parts := strings.SplitN(h, " ", 2)
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return nil, errInvalidAuth
+	}
+	return verifyToken(cfg, parts[1])
+}
+
+// jwtStats counts access tokens verified with JWT_SECRET_PREVIOUS
+// ("previous_secret"): once it stops growing for ACCESS_TOKEN_TTL, no
+// token signed with the old secret is left and it can be dropped.
+var jwtStats = expvar.NewMap("jwt")
+
+// verifyToken verifies an access token with JWT_SECRET or, during a
+// rotation, JWT_SECRET_PREVIOUS. Tokens are only ever signed with
+// JWT_SECRET, so the previous one is tried only on a signature mismatch.
+func verifyToken(cfg *config.Config, token string) (*auth.Claims, error) {
+	claims, err := auth.VerifyJWT(cfg.JWTSecret, token)
+	if cfg.JWTSecretPrevious == "" || !errors.Is(err, auth.ErrTokenSignature) {
+		return claims, err
+	}
+	claims, err = auth.VerifyJWT(cfg.JWTSecretPrevious, token)
+	if err == nil {
+		jwtStats.Add("previous_secret", 1)
+	}
+	return claims, err
+}
+
+// authErrorCode maps a bearerClaims error to its code and public message.
+func authErrorCode(err error) (code, message string) {
+	switch {
+	case errors.Is(err, errMissingAuth):
+		return api.ErrCodeAuthMissing, err.Error()
+	case errors.Is(err, errInvalidAuth):
+		return api.ErrCodeAuthMalformed, err.Error()
+	case errors.Is(err, auth.ErrTokenExpired):
+		return api.ErrCodeTokenExpired, "token expired"
+	case errors.Is(err, auth.ErrTokenMalformed):
+		return api.ErrCodeTokenMalformed, "malformed token"
+	default:
+		return api.ErrCodeTokenInvalid, "invalid token signature"
+	}
+}
+
+// writeAuthError answers r with a 401 and its RFC 6750 challenge: a bare
+// "Bearer" when no credential was sent, otherwise error="invalid_request"
+// for a broken Authorization header or "invalid_token", with message as
+// the error_description. Only the error_code in the body tells the token
+// errors apart: token_expired is worth a refresh, the others need a login.
+func writeAuthError(w http.ResponseWriter, r *http.Request, code, message string) {
+	challenge := "Bearer"
+	switch code {
+	case api.ErrCodeAuthMissing:
+	case api.ErrCodeAuthMalformed:
+		challenge += fmt.Sprintf(` error="invalid_request", error_description=%q`, message)
+	default:
+		challenge += fmt.Sprintf(` error="invalid_token", error_description=%q`, message)
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	writeErrorCode(w, r, http.StatusUnauthorized, code, message)
+}
+
+// rejectClaims is what Auth checks of a token beyond its signature and
+// expiry, for a request to path: the session's lifetime, the user's
+// status and terms, and every revocation. It returns the status (401 or
+// 403), error code and message to refuse the token with, or no code.
+func rejectClaims(st store.Store, cfg *config.Config, claims *auth.Claims, path string) (int, string, string) {
+	if claims.SessionStart != 0 && pastLifetime(time.Unix(claims.SessionStart, 0), cfg.MaxSessionLifetime) {
+		return http.StatusUnauthorized, api.ErrCodeSessionExpired, "session expired, log in again"
+	}
+	// Access tokens outlive a suspension or a deletion request until
+	// they expire; this makes them immediate.
+	// So are new terms: a user must accept them before going on.
+	if user, err := st.GetUserByID(claims.UserID); err == nil {
+		switch terms := cfg.Terms; {
+		case user.Suspended:
+			return http.StatusForbidden, api.ErrCodeAccountSuspended, "account suspended"
+		case user.PendingDeletion():
+			return http.StatusForbidden, api.ErrCodeAccountDeleting, "account scheduled for deletion"
+		case terms.Enabled() && !termsExempt[routeV1(path)] && !user.AcceptedTerms(terms.Version, terms.PrivacyVersion):
+			return http.StatusForbidden, api.ErrCodeTermsRequired, "accept the current terms of service and privacy policy to continue"
+		}
+		// Tokens issued before revokeAllCredentials are dead.
+		if user.TokenRevoked(claims.Iat) {
+			return http.StatusUnauthorized, api.ErrCodeTokenRevoked, "token revoked, log in again"
+		}
+	}
+	// So are those of a session revoked on its own.
+	if claims.SessionID != "" && st.IsSessionRevoked(claims.SessionID) {
+		return http.StatusUnauthorized, api.ErrCodeTokenRevoked, "token revoked, log in again"
+	}
+	// And those acting in an organization the user has left since.
+	if orgTokenRevoked(st, claims) {
+		return http.StatusUnauthorized, api.ErrCodeTokenRevoked, "token revoked for the organization, switch organizations again"
+	}
+	return 0, "", ""
+}
+
+// Auth authenticates the access token of the request, or its signature
+// when an internal caller signed it (see internalAuth).
+func (m *Middleware) Auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(m.callers) > 0 && r.Header.Get(reqsign.HeaderSignature) != "" {
+			m.internalAuth(w, r, next)
+			return
+		}
+		claims, err := bearerClaims(r, m.cfg)
+		if err != nil {
+			code, msg := authErrorCode(err)
+			AuthRejected.Publish(eventContext(r), m.events, RejectionEvent{
+				Reason: code, Details: map[string]string{"error_code": code, "path": r.URL.Path},
+			})
+			writeAuthError(w, r, code, msg)
+			return
+		}
+		if status, code, msg := rejectClaims(m.store, m.cfg, claims, r.URL.Path); code != "" {
+			AuthRejected.Publish(eventContext(r), m.events, RejectionEvent{
+				Reason: code, Details: map[string]string{"error_code": code, "path": r.URL.Path},
+			})
+			if status == http.StatusForbidden {
+				writeErrorCode(w, r, status, code, msg)
+			} else {
+				writeAuthError(w, r, code, msg)
+			}
+			return
+		}
+		ctx := context.WithValue(r.Context(), ctxUserID, claims.UserID)
+		ctx = context.WithValue(ctx, ctxEmail, claims.Email)
+		ctx = context.WithValue(ctx, ctxRole, claims.Role)
+		ctx = context.WithValue(ctx, ctxAuthAt, claims.AuthTime)
+		ctx = context.WithValue(ctx, ctxCredential, credentialBearer)
+		ctx = context.WithValue(ctx, ctxClaims, claims)
+		if claims.OrgID != "" {
+			ctx = context.WithValue(ctx, ctxOrg, OrgContext{ID: claims.OrgID, Role: claims.OrgRole})
+		}
+		setRequestUser(r, claims.UserID)
+		if m.cfg.TokenExpiryHeaders {
+			setTokenExpiry(w.Header(), claims, m.cfg.MaxSessionLifetime)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// setTokenExpiry tells the client, on every authenticated response, how
+// many seconds its access token has left (X-Token-Expires-In), so it can
+// refresh just in time without asking whoami, and with
+// MAX_SESSION_LIFETIME when the session ends for good
+// (X-Session-Expires-At), which no refresh extends. They are set before
+// the handler runs, while nothing is written yet; writeJSONWithETag takes
+// them off again, since a countdown does not belong in a response that
+// may be served again from a cache.
+func setTokenExpiry(h http.Header, claims *auth.Claims, lifetime time.Duration) {
+	h.Set("X-Token-Expires-In", strconv.FormatInt(max(0, claims.Exp-auth.Now().Unix()), 10))
+	if lifetime > 0 && claims.SessionStart != 0 {
+		h.Set("X-Session-Expires-At", time.Unix(claims.SessionStart, 0).Add(lifetime).UTC().Format(time.RFC3339))
+	}
+}
+
+// dropTokenExpiry removes what setTokenExpiry set.
+func dropTokenExpiry(h http.Header) {
+	h.Del("X-Token-Expires-In")
+	h.Del("X-Session-Expires-At")
+}
+
+// CSRFProtection requires a valid X-CSRF-Token, issued to the user Auth
+// authenticated, on writes: another user's token is refused. With
+// CSRF_EXEMPT_BEARER, requests Auth authenticated by an Authorization
+// header (ctxCredential) are let through without one: CSRF rides on
+// credentials the browser attaches by itself, which that header is not.
+// Any other credential source still needs the token, except the signature
+// of an internal caller, which no browser can produce.
+func (m *Middleware) CSRFProtection(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Context().Value(ctxCredential) == credentialInternal {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if m.cfg.CSRFExemptBearer && r.Context().Value(ctxCredential) == credentialBearer {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token := r.Header.Get("X-CSRF-Token")
+		userID, _ := r.Context().Value(ctxUserID).(string)
+		if token == "" || !m.store.ValidateCSRFToken(token, userID) {
+			CSRFRejected.Publish(eventContext(r), m.events, RejectionEvent{
+				Reason:  "csrf_invalid",
+				Details: map[string]string{"method": r.Method, "path": r.URL.Path, "token_present": strconv.FormatBool(token != "")},
+			})
+			writeErrorCode(w, r, http.StatusForbidden, api.ErrCodeCSRFInvalid, "invalid or missing CSRF token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// maintenanceExempt lists the paths still served while in maintenance mode.
+// Login and refresh only issue tokens to admins then (see
+// closedForMaintenance), so the switch can't lock them out.
+var maintenanceExempt = map[string]bool{
+	"/health":                   true,
+	"/ready":                    true,
+	"/version":                  true,
+	"/openapi.json":             true,
+	"/api/v1/auth/login":        true,
+	"/api/v1/auth/refresh":      true,
+	"/api/v1/admin/maintenance": true,
+}
+
+func (m *Middleware) MaintenanceMode(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if maintenanceExempt[routeV1(r.URL.Path)] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if st := m.maintenance.Status(); st.Enabled {
+			w.Header().Set("Retry-After", strconv.Itoa(maintenanceRetryAfter))
+			writeErrorCode(w, r, http.StatusServiceUnavailable, api.ErrCodeMaintenance, st.Message)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+const (
+	idempotencyTTL     = 24 * time.Hour
+	maxIdempotentBody  = 64 << 10 // largest response we keep for replay
+	maxIdempotentInput = 1 << 20  // largest request body we hash
+)
+
+// Idempotent replays the stored response for POST retries carrying the same
+// Idempotency-Key (scoped per user or IP and route). Reusing a key with a
+// different body is a 422; a retry while the first is in flight is a 409.
+// Opt-in per route. 5xx and oversize responses are not kept.
+func (m *Middleware) Idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idemKey := r.Header.Get("Idempotency-Key")
+		if r.Method != http.MethodPost || idemKey == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentInput+1))
+		if err != nil || len(body) > maxIdempotentInput {
+			writeErrorCode(w, r, http.StatusRequestEntityTooLarge, api.ErrCodePayloadTooLarge, "request body too large")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(body)
+		hash := hex.EncodeToString(sum[:])
+
+		principal, _ := r.Context().Value(ctxUserID).(string)
+		if principal == "" {
+			principal = clientIP(r)
+		}
+		key := idemKey + "|" + principal + "|" + r.Method + " " + r.URL.Path
+
+		rec, claimed := m.store.BeginIdempotent(key, hash, idempotencyTTL)
+		switch {
+		case claimed:
+		case rec.RequestHash != hash:
+			writeErrorCode(w, r, http.StatusUnprocessableEntity, api.ErrCodeIdempotencyMismatch, "idempotency key reused with a different request body")
+			return
+		case !rec.Done:
+			writeErrorCode(w, r, http.StatusConflict, api.ErrCodeIdempotencyInFlight, "a request with this idempotency key is already in progress")
+			return
+		default:
+			if rec.ContentType != "" {
+				w.Header().Set("Content-Type", rec.ContentType)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(rec.Status)
+			_, _ = w.Write(rec.Body)
+			return
+		}
+
+		// Release the key unless a response was kept, so a panicking or
+		// failing request can be retried.
+		kept := false
+		defer func() {
+			if !kept {
+				m.store.ReleaseIdempotent(key)
+			}
+		}()
+		cw := &captureWriter{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(cw, r)
+		if cw.code < 500 && !cw.overflow {
+			m.store.CompleteIdempotent(key, cw.code, w.Header().Get("Content-Type"), cw.buf.Bytes())
+			kept = true
+		}
+	})
+}
+
+// captureWriter tees up to maxIdempotentBody bytes of the response.
+type captureWriter struct {
+	http.ResponseWriter
+	code     int
+	buf      bytes.Buffer
+	overflow bool
+}
+
+func (cw *captureWriter) WriteHeader(code int) { cw.code = code; cw.ResponseWriter.WriteHeader(code) }
+
+func (cw *captureWriter) Write(b []byte) (int, error) {
+	if !cw.overflow {
+		if cw.buf.Len()+len(b) > maxIdempotentBody {
+			cw.overflow = true
+			cw.buf.Reset()
+		} else {
+			cw.buf.Write(b)
+		}
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+func (m *Middleware) RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userRole, _ := r.Context().Value(ctxRole).(string)
+			if userRole != role {
+				writeErrorCode(w, r, http.StatusForbidden, api.ErrCodeForbidden, "insufficient permissions")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireOrgRole lets through tokens acting in an organization (see
+// SwitchOrg) with role there; "member" lets owners through as well. A
+// token acting in none gets 403 org_context_required. It must run after
+// Auth, which has already refused the tokens of a membership since
+// removed or changed.
+func (m *Middleware) RequireOrgRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			org, ok := ActiveOrg(r.Context())
+			if !ok {
+				writeErrorCode(w, r, http.StatusForbidden, api.ErrCodeOrgContextRequired, "no organization selected; switch to one at /auth/switch-org")
+				return
+			}
+			if org.Role != role && (role != api.OrgRoleMember || org.Role != api.OrgRoleOwner) {
+				writeErrorCode(w, r, http.StatusForbidden, api.ErrCodeForbidden, "insufficient permissions in the organization")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireFreshAuth lets through tokens issued by a login less than
+// REAUTH_MAX_AGE ago, for sensitive operations; refreshed tokens never
+// qualify. Others get 401 reauth_required: log in again and retry. It must
+// run after Auth.
+func (m *Middleware) RequireFreshAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authAt, _ := r.Context().Value(ctxAuthAt).(int64)
+		if authAt == 0 || auth.Now().Sub(time.Unix(authAt, 0)) > m.cfg.ReauthMaxAge {
+			writeErrorCode(w, r, http.StatusUnauthorized, api.ErrCodeReauthRequired, "recent login required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RateLimiter — simple in-memory, use Redis in production
+//
+// A key may make limit requests per window, counted over a sliding window.
+// With a burst above the limit (SetBurst) it is a token bucket instead:
+// burst requests at once, refilled at limit per window, so that over any
+// span d a key gets at most burst + limit*d/window requests through.
+type RateLimiter struct {
+	mu       sync.Mutex
+	requests map[string]rateKey
+	limit    int
+	burst    int
+	window   time.Duration
+	maxKeys  int // see SetMaxKeys
+	key      func(*http.Request) string
+	onLimit  func(*http.Request) // called for every rejected request, if set
+	now      func() time.Time    // time.Now; tests substitute a clock
+	rejected atomic.Int64
+	evicted  atomic.Int64
+	done     chan struct{}
+	stopOnce sync.Once
+
+	// exempt, when set and exemptible is true, says why a request skips the
+	// limiter ("cidr" or "header"), or "" when it does not.
+	exempt         func(*http.Request) string
+	exemptible     atomic.Bool
+	bypassedCIDR   atomic.Int64
+	bypassedHeader atomic.Int64
+}
+
+// rateKey is what a RateLimiter tracks for one key.
+type rateKey struct {
+	times []time.Time // sliding window: the requests within the window, oldest first
+	// full is, for a token bucket, when the bucket is full again: GCRA's
+	// theoretical arrival time. Each request pushes it window/limit later.
+	full time.Time
+	// sources are the distinct clients behind the events recorded with
+	// note, newest last and at most maxRateSources; ref is what the key
+	// refers to, if a caller said (see note).
+	sources []string
+	ref     string
+}
+
+// maxRateSources caps rateKey.sources: an attack from a botnet would
+// otherwise grow one key without bound.
+const maxRateSources = 20
+
+var _ io.Closer = (*RateLimiter)(nil)
+
+// defaultRateLimitMaxKeys is a new limiter's SetMaxKeys, the default of
+// RATE_LIMIT_MAX_KEYS.
+const defaultRateLimitMaxKeys = 100_000
+
+// NewRateLimiter allows limit requests per key within window. Stale keys are
+// swept every sweepEvery; call Stop to release the sweeper goroutine.
+func NewRateLimiter(limit int, window, sweepEvery time.Duration) *RateLimiter {
+	if sweepEvery <= 0 {
+		sweepEvery = window
+	}
+	rl := &RateLimiter{
+		requests: make(map[string]rateKey),
+		limit:    limit,
+		window:   window,
+		maxKeys:  defaultRateLimitMaxKeys,
+		key:      clientIP,
+		now:      time.Now,
+		done:     make(chan struct{}),
+	}
+	ticker := time.NewTicker(sweepEvery)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				rl.sweep()
+			case <-rl.done:
+				return
+			}
+		}
+	}()
+	return rl
+}
+
+// sweep drops timestamps outside the window and evicts keys that have not
+// been seen for a full window, or whose bucket is full.
+func (rl *RateLimiter) sweep() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := rl.now()
+	for key, k := range rl.requests {
+		k.times = rl.within(k.times, now)
+		if len(k.times) == 0 && !k.full.After(now) {
+			delete(rl.requests, key)
+		} else {
+			rl.requests[key] = k
+		}
+	}
+}
+
+// within returns the times less than a window before now. rl.mu must be
+// held.
+func (rl *RateLimiter) within(times []time.Time, now time.Time) []time.Time {
+	var valid []time.Time
+	for _, t := range times {
+		if now.Sub(t) < rl.window {
+			valid = append(valid, t)
+		}
+	}
+	return valid
+}
+
+// Len returns the number of keys currently tracked.
+func (rl *RateLimiter) Len() int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return len(rl.requests)
+}
+
+// SetMaxKeys caps the keys tracked at once. The sweep only forgets keys
+// idle for a whole window, and keys are cheap to come by (user IDs,
+// emails, IPv6 addresses), so without a cap a flood of distinct keys
+// would grow the map without bound. Past the cap a new key evicts a
+// random tracked one. That resets the evicted key's budget, so a client
+// can get more than its limit while the limiter is flooded; that beats
+// running out of memory, and random eviction cannot be aimed at a given
+// client the way least-recently-used eviction could.
+func (rl *RateLimiter) SetMaxKeys(n int) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.maxKeys = n
+}
+
+// Evicted returns how many keys SetMaxKeys' cap has evicted.
+func (rl *RateLimiter) Evicted() int64 { return rl.evicted.Load() }
+
+// track sets key's state, first evicting a random key when key is new and
+// maxKeys are already tracked. rl.mu must be held.
+func (rl *RateLimiter) track(key string, k rateKey) {
+	if _, ok := rl.requests[key]; !ok && len(rl.requests) >= rl.maxKeys {
+		for other := range rl.requests {
+			delete(rl.requests, other)
+			rl.evicted.Add(1)
+			if len(rl.requests) < rl.maxKeys {
+				break
+			}
+		}
+	}
+	rl.requests[key] = k
+}
+
+// Stop terminates the sweeper goroutine. It is safe to call more than once.
+func (rl *RateLimiter) Stop() {
+	rl.stopOnce.Do(func() { close(rl.done) })
+}
+
+func (rl *RateLimiter) Close() error {
+	rl.Stop()
+	return nil
+}
+
+// SetLimit changes the limit and window for subsequent requests. Tracked
+// timestamps are kept, so tightening takes effect immediately.
+func (rl *RateLimiter) SetLimit(limit int, window time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.limit, rl.window = limit, window
+}
+
+// SetBurst makes the limiter a token bucket of depth burst when burst is
+// above the limit, and a sliding window again otherwise: with burst equal
+// to the limit the two differ only in how soon capacity comes back. The
+// state kept for one does not carry over to the other.
+func (rl *RateLimiter) SetBurst(burst int) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.burst = burst
+}
+
+// allow records a request for key and reports whether it is within the
+// limit; when it is not, retry is how long until it would be.
+func (rl *RateLimiter) allow(key string) (ok bool, retry time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := rl.now()
+	k := rl.requests[key]
+	if rl.burst > rl.limit {
+		interval := rl.window / time.Duration(rl.limit)
+		full := k.full
+		if full.Before(now) {
+			full = now
+		}
+		// The bucket holds burst requests' worth of time; a request takes
+		// one interval of it.
+		if wait := full.Sub(now) - time.Duration(rl.burst-1)*interval; wait > 0 {
+			return false, wait
+		}
+		k.full = full.Add(interval)
+		rl.track(key, k)
+		return true, 0
+	}
+	k.times = rl.within(k.times, now)
+	if n := len(k.times); n >= rl.limit {
+		// Room comes back as the oldest requests leave the window.
+		return false, k.times[n-rl.limit].Add(rl.window).Sub(now)
+	}
+	k.times = append(k.times, now)
+	rl.track(key, k)
+	return true, 0
+}
+
+// exceeded reports, without recording anything, whether key has used up
+// its limit, and if so how long until it has not; see add. A limit of 0
+// or less never trips.
+func (rl *RateLimiter) exceeded(key string) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if rl.limit <= 0 {
+		return false, 0
+	}
+	now := rl.now()
+	times := rl.within(rl.requests[key].times, now)
+	if n := len(times); n >= rl.limit {
+		return true, times[n-rl.limit].Add(rl.window).Sub(now)
+	}
+	return false, 0
+}
+
+// Tripped returns how many keys have used up their limit, that is how many
+// exceeded would turn away right now.
+func (rl *RateLimiter) Tripped() int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if rl.limit <= 0 {
+		return 0
+	}
+	now := rl.now()
+	n := 0
+	for _, k := range rl.requests {
+		if len(rl.within(k.times, now)) >= rl.limit {
+			n++
+		}
+	}
+	return n
+}
+
+// add records one event for key, for limiters that count only some
+// requests (failed logins) instead of every request. Such limiters have
+// no burst.
+func (rl *RateLimiter) add(key string) { rl.note(key, "", "") }
+
+// note is add that also remembers the event's source (a client IP) and,
+// when not empty, ref as what key refers to, for Locked to report.
+func (rl *RateLimiter) note(key, source, ref string) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	k := rl.requests[key]
+	k.times = append(k.times, rl.now())
+	if source != "" {
+		sources := slices.DeleteFunc(slices.Clone(k.sources), func(s string) bool { return s == source })
+		if len(sources) >= maxRateSources {
+			sources = sources[1:]
+		}
+		k.sources = append(sources, source)
+	}
+	if ref != "" {
+		k.ref = ref
+	}
+	rl.track(key, k)
+}
+
+// LockedKey is a key that has used up its limit; see Locked.
+type LockedKey struct {
+	Key     string
+	Ref     string    // what the key refers to, if note was told
+	Count   int       // events within the window
+	Since   time.Time // when the limit was reached
+	Until   time.Time // when exceeded lets the key through again
+	Sources []string  // see note
+}
+
+// Locked returns the keys exceeded turns away right now, the ones Tripped
+// counts, most recently locked first. It only looks at the keys the
+// limiter tracks.
+func (rl *RateLimiter) Locked() []LockedKey {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if rl.limit <= 0 {
+		return nil
+	}
+	now := rl.now()
+	var locked []LockedKey
+	for key, k := range rl.requests {
+		times := rl.within(k.times, now)
+		if n := len(times); n >= rl.limit {
+			locked = append(locked, LockedKey{
+				Key: key, Ref: k.ref, Count: n, Since: times[rl.limit-1],
+				Until: times[n-rl.limit].Add(rl.window), Sources: slices.Clone(k.sources),
+			})
+		}
+	}
+	slices.SortFunc(locked, func(a, b LockedKey) int {
+		if c := b.Since.Compare(a.Since); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Key, b.Key)
+	})
+	return locked
+}
+
+// reset forgets key.
+func (rl *RateLimiter) reset(key string) {
+	rl.mu.Lock()
+	delete(rl.requests, key)
+	rl.mu.Unlock()
+}
+
+// reject answers r with 429 and counts it. Retry-After is retry in whole
+// seconds, rounded up.
+func (rl *RateLimiter) reject(w http.ResponseWriter, r *http.Request, retry time.Duration) {
+	rl.rejected.Add(1)
+	if rl.onLimit != nil {
+		rl.onLimit(r)
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retrySeconds(retry)))
+	writeErrorCode(w, r, http.StatusTooManyRequests, api.ErrCodeRateLimited, "rate limit exceeded")
+}
+
+// retrySeconds is d in whole seconds, rounded up and at least 1.
+func retrySeconds(d time.Duration) int {
+	return max(1, int((d+time.Second-1)/time.Second))
+}
+
+// Rejected returns how many requests the limiter has refused.
+func (rl *RateLimiter) Rejected() int64 { return rl.rejected.Load() }
+
+// Bypassed returns how many requests skipped the limiter, by why: "cidr"
+// for RATE_LIMIT_EXEMPT_CIDRS, "header" for X-RateLimit-Bypass.
+func (rl *RateLimiter) Bypassed() map[string]int64 {
+	return map[string]int64{"cidr": rl.bypassedCIDR.Load(), "header": rl.bypassedHeader.Load()}
+}
+
+// admit is allow for r, unless r is exempt: then it counts the bypass and
+// records nothing.
+func (rl *RateLimiter) admit(r *http.Request) (bool, time.Duration) {
+	if rl.exempt != nil && rl.exemptible.Load() {
+		switch rl.exempt(r) {
+		case "cidr":
+			rl.bypassedCIDR.Add(1)
+			return true, 0
+		case "header":
+			rl.bypassedHeader.Add(1)
+			return true, 0
+		}
+	}
+	return rl.allow(rl.key(r))
+}
+
+func (rl *RateLimiter) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, retry := rl.admit(r); !ok {
+			rl.reject(w, r, retry)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// userKey keys requests by authenticated user, falling back to the client
+// IP for anonymous ones. Limiters using it must run after Middleware.Auth.
+func userKey(r *http.Request) string {
+	if uid, ok := r.Context().Value(ctxUserID).(string); ok && uid != "" {
+		return "user:" + uid
+	}
+	return clientIP(r)
+}
+
+// RateLimiters holds one RateLimiter per configured bucket. Route groups
+// attach buckets by name with Use; RATE_LIMIT_ROUTES adds per-route buckets
+// through PerRoute. Err reports references to undefined buckets or routes.
+type RateLimiters struct {
+	buckets  map[string]config.RateLimitBucket
+	limiters map[string]*RateLimiter
+	routes   map[string]string    // route pattern -> bucket
+	attached map[string][]string  // bucket -> where it is used
+	keyed    map[string][2]string // Keyed limiter -> key, use
+	maxKeys  int                  // RATE_LIMIT_MAX_KEYS, for every limiter
+	exempt   atomic.Pointer[rateLimitExemptions]
+	errs     []error
+}
+
+// rateLimitExemptions is the parsed RateLimitExemptConfig.
+type rateLimitExemptions struct {
+	cidrs   []netip.Prefix
+	proxies []netip.Prefix
+	secret  string
+}
+
+// bypassHeader carries RATE_LIMIT_BYPASS_SECRET.
+const bypassHeader = "X-RateLimit-Bypass"
+
+// SetExemptions swaps in the RATE_LIMIT_EXEMPT_CIDRS, TRUSTED_PROXIES and
+// RATE_LIMIT_BYPASS_SECRET of cfg, which must have passed Validate.
+func (rls *RateLimiters) SetExemptions(cfg config.RateLimitExemptConfig) {
+	cidrs, _ := config.ParseCIDRs(cfg.CIDRs)
+	proxies, _ := config.ParseCIDRs(cfg.TrustedProxies)
+	rls.exempt.Store(&rateLimitExemptions{cidrs: cidrs, proxies: proxies, secret: cfg.BypassSecret})
+}
+
+// exempted says why r skips the buckets that honor exemptions: "header"
+// when it carries the bypass secret, "cidr" when its trusted client
+// address is in RATE_LIMIT_EXEMPT_CIDRS; "" when it does not.
+func (rls *RateLimiters) exempted(r *http.Request) string {
+	ex := rls.exempt.Load()
+	if ex == nil {
+		return ""
+	}
+	if got := r.Header.Get(bypassHeader); ex.secret != "" && got != "" &&
+		subtle.ConstantTimeCompare([]byte(got), []byte(ex.secret)) == 1 {
+		return "header"
+	}
+	if len(ex.cidrs) > 0 {
+		if addr, ok := trustedClientIP(r, ex.proxies); ok && prefixesContain(ex.cidrs, addr) {
+			return "cidr"
+		}
+	}
+	return ""
+}
+
+// trustedClientIP returns the caller's address as far as it can be
+// trusted: the connection's peer, or, when the peer is one of proxies, the
+// nearest X-Forwarded-For hop that is not, so it cannot be forged by
+// sending X-Forwarded-For. Unix socket peers have no address.
+func trustedClientIP(r *http.Request, proxies []netip.Prefix) (netip.Addr, bool) {
+	peer, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	addr := peer.Addr().Unmap()
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0 && prefixesContain(proxies, addr); i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		addr = hop.Unmap()
+	}
+	return addr, true
+}
+
+func prefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewRateLimiters builds the buckets; rejections are published as RateLimited.
+func NewRateLimiters(buckets []config.RateLimitBucket, routes map[string]string, sweepEvery time.Duration, maxKeys int, events *EventBus) *RateLimiters {
+	rls := &RateLimiters{
+		maxKeys:  maxKeys,
+		buckets:  make(map[string]config.RateLimitBucket, len(buckets)),
+		limiters: make(map[string]*RateLimiter, len(buckets)),
+		routes:   routes,
+		attached: make(map[string][]string),
+		keyed:    make(map[string][2]string),
+	}
+	for _, b := range buckets {
+		rl := NewRateLimiter(b.Limit, b.Window, sweepEvery)
+		rl.SetBurst(b.Burst)
+		rl.SetMaxKeys(maxKeys)
+		rl.exempt = rls.exempted
+		rl.exemptible.Store(b.Exempt)
+		if b.Key == "user" {
+			rl.key = userKey
+		}
+		rl.onLimit = func(r *http.Request) {
+			RateLimited.Publish(eventContext(r), events, RejectionEvent{
+				Reason: "rate_limited", Details: map[string]string{"bucket": b.Name, "key": b.Key, "path": r.URL.Path},
+			})
+		}
+		rls.buckets[b.Name], rls.limiters[b.Name] = b, rl
+	}
+	for route, name := range routes {
+		rls.attach(name, route)
+	}
+	return rls
+}
+
+// Keyed registers a limiter that handlers consult themselves, for limits
+// on something only the handler knows, such as an email or phone number in
+// the body. key and use describe it in the startup summary. Rejections are
+// published like a bucket's, and it shows up in Limiters (and so in the
+// metrics) under name.
+func (rls *RateLimiters) Keyed(name, key, use string, limit int, window, sweepEvery time.Duration, events *EventBus) *RateLimiter {
+	rl := NewRateLimiter(limit, window, sweepEvery)
+	rl.SetMaxKeys(rls.maxKeys)
+	rl.onLimit = func(r *http.Request) {
+		RateLimited.Publish(eventContext(r), events, RejectionEvent{
+			Reason: "rate_limited", Details: map[string]string{"bucket": name, "key": key, "path": r.URL.Path},
+		})
+	}
+	rls.limiters[name] = rl
+	rls.keyed[name] = [2]string{key, use}
+	return rl
+}
+
+// LoginFailures returns the limiter of failed logins per email address,
+// which Handlers.Login consults itself: it counts failures only, and keys
+// on the body.
+func (rls *RateLimiters) LoginFailures(limit int, window, sweepEvery time.Duration, events *EventBus) *RateLimiter {
+	return rls.Keyed(loginEmailLimiter, "email", "failed logins", limit, window, sweepEvery, events)
+}
+
+const loginEmailLimiter = "login_email"
+
+func (rls *RateLimiters) attach(name, where string) *RateLimiter {
+	rl, ok := rls.limiters[name]
+	if !ok {
+		rls.errs = append(rls.errs, fmt.Errorf("rate limit bucket %q (used by %s) is not defined", name, where))
+		return nil
+	}
+	rls.attached[name] = append(rls.attached[name], where)
+	return rl
+}
+
+// Use returns middleware for bucket name. where describes the guarded
+// routes for the startup summary.
+func (rls *RateLimiters) Use(name, where string) func(http.Handler) http.Handler {
+	rl := rls.attach(name, where)
+	if rl == nil {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	return rl.Wrap
+}
+
+// PerRoute applies the RATE_LIMIT_ROUTES bucket for the matched route
+// pattern, if any. It must run inside the mux (e.g. as Group middleware) so
+// r.Pattern is set.
+func (rls *RateLimiters) PerRoute(next http.Handler) http.Handler {
+	wrapped := make(map[string]http.Handler, len(rls.routes))
+	for route, name := range rls.routes {
+		if rl, ok := rls.limiters[name]; ok {
+			wrapped[route] = rl.Wrap(next)
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h, ok := wrapped[routeV1(r.Pattern)]; ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Err reports undefined buckets and RATE_LIMIT_ROUTES entries that are not
+// registered on mux. Call it once all routes are registered.
+func (rls *RateLimiters) Err(mux *http.ServeMux) error {
+	errs := rls.errs
+	for route := range rls.routes {
+		method, path, ok := strings.Cut(route, " ")
+		if !ok {
+			method, path = http.MethodGet, route
+		}
+		req, err := http.NewRequest(method, path, nil)
+		if err == nil {
+			if _, pattern := mux.Handler(req); pattern == route {
+				continue
+			}
+		}
+		errs = append(errs, fmt.Errorf("RATE_LIMIT_ROUTES: no route %q", route))
+	}
+	return errors.Join(errs...)
+}
+
+// LogSummary logs each bucket with the routes it guards.
+func (rls *RateLimiters) LogSummary() {
+	names := make([]string, 0, len(rls.buckets))
+	for name := range rls.buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	log.Printf("  Rate limit buckets:")
+	for _, name := range names {
+		b := rls.buckets[name]
+		where := strings.Join(rls.attached[name], ", ")
+		if where == "" {
+			where = "(unused)"
+		}
+		rate := fmt.Sprintf("%d/%s", b.Limit, b.Window)
+		if b.Burst > 0 {
+			rate += fmt.Sprintf(" burst %d", b.Burst)
+		}
+		if !b.Exempt {
+			rate += " noexempt"
+		}
+		log.Printf("    %-12s %s per %-4s -> %s", name, rate, b.Key, where)
+	}
+	for _, name := range slices.Sorted(maps.Keys(rls.keyed)) {
+		rl, k := rls.limiters[name], rls.keyed[name]
+		rl.mu.Lock()
+		limit, window := rl.limit, rl.window
+		rl.mu.Unlock()
+		if limit > 0 {
+			log.Printf("    %-12s %d/%s per %-4s -> %s", name, limit, window, k[0], k[1])
+		} else {
+			log.Printf("    %-12s off", name)
+		}
+	}
+}
+
+// Reload applies new limits and windows to existing buckets. Adding,
+// removing or re-keying buckets requires a restart.
+func (rls *RateLimiters) Reload(buckets []config.RateLimitBucket) {
+	seen := make(map[string]bool, len(buckets))
+	for _, b := range buckets {
+		seen[b.Name] = true
+		cur, ok := rls.buckets[b.Name]
+		switch {
+		case !ok:
+			log.Printf("WARN reload: rate limit bucket %q added, restart required to apply", b.Name)
+		case cur.Key != b.Key:
+			log.Printf("WARN reload: rate limit bucket %q key changed, restart required to apply", b.Name)
+		default:
+			rls.limiters[b.Name].SetLimit(b.Limit, b.Window)
+			rls.limiters[b.Name].SetBurst(b.Burst)
+			rls.limiters[b.Name].exemptible.Store(b.Exempt)
+		}
+	}
+	for name := range rls.buckets {
+		if !seen[name] {
+			log.Printf("WARN reload: rate limit bucket %q removed, restart required to apply", name)
+		}
+	}
+}
+
+// Limiters returns the limiters by bucket name.
+func (rls *RateLimiters) Limiters() map[string]*RateLimiter { return rls.limiters }
+
+// Stop stops every limiter's sweeper.
+func (rls *RateLimiters) Stop() {
+	for _, rl := range rls.limiters {
+		rl.Stop()
+	}
+}
+
+// concurrencyStats exposes every ConcurrencyLimiter by name.
+var concurrencyStats = expvar.NewMap("concurrency_limiter")
+
+// ConcurrencyLimiter caps the number of requests being served at once.
+// When full, a request waits up to wait for a slot before getting a 503.
+// Unlike a RateLimiter it bounds slow requests piling up, not how many
+// arrive per window. A capacity of 0 or less turns it off.
+type ConcurrencyLimiter struct {
+	sem      chan struct{}
+	wait     time.Duration
+	exempt   map[string]bool
+	rejected atomic.Int64
+}
+
+func NewConcurrencyLimiter(name string, capacity int, wait time.Duration, exempt ...string) *ConcurrencyLimiter {
+	cl := &ConcurrencyLimiter{wait: wait, exempt: toSet(exempt)}
+	if capacity > 0 {
+		cl.sem = make(chan struct{}, capacity)
+	}
+	concurrencyStats.Set(name, expvar.Func(func() any {
+		return map[string]int64{"in_use": int64(len(cl.sem)), "capacity": int64(cap(cl.sem)), "rejected": cl.rejected.Load()}
+	}))
+	return cl
+}
+
+func (cl *ConcurrencyLimiter) acquire(ctx context.Context) bool {
+	select {
+	case cl.sem <- struct{}{}:
+		return true
+	default:
+	}
+	if cl.wait <= 0 {
+		return false
+	}
+	timer := time.NewTimer(cl.wait)
+	defer timer.Stop()
+	select {
+	case cl.sem <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Wrap limits next. The slot is released by a deferred call, so a handler
+// that panics gives it back as the panic unwinds.
+func (cl *ConcurrencyLimiter) Wrap(next http.Handler) http.Handler {
+	if cl.sem == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cl.exempt[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !cl.acquire(r.Context()) {
+			cl.rejected.Add(1)
+			w.Header().Set("Retry-After", "1")
+			writeErrorCode(w, r, http.StatusServiceUnavailable, api.ErrCodeOverloaded, "server is at capacity, please retry")
+			return
+		}
+		defer func() { <-cl.sem }()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// unixPeer labels clients connected over a Unix socket, which have no
+// remote address. They share a single rate limit bucket.
+const unixPeer = "unix"
+
+// trustedProxies holds the TRUSTED_PROXIES prefixes clientIP believes
+// X-Forwarded-For from. Like the listeners, it is shared by the process.
+var trustedProxies atomic.Pointer[[]netip.Prefix]
+
+// setTrustedProxies swaps in TRUSTED_PROXIES, which must have passed
+// Validate.
+func setTrustedProxies(cidrs []string) {
+	proxies, _ := config.ParseCIDRs(cidrs)
+	trustedProxies.Store(&proxies)
+}
+
+// clientIP returns the caller's address: the connection's remote host
+// (the PROXY protocol source, when the listener reads it), or the
+// X-Forwarded-For hop a trusted proxy vouches for. Hops a client added
+// itself are ignored, so rate limits, audit events and the like can't be
+// dodged by sending the header.
+func clientIP(r *http.Request) string {
+	if r.RemoteAddr == "" || r.RemoteAddr == "@" {
+		return unixPeer
+	}
+	var proxies []netip.Prefix
+	if p := trustedProxies.Load(); p != nil {
+		proxies = *p
+	}
+	if addr, ok := trustedClientIP(r, proxies); ok {
+		return addr.String()
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+func toSet(items []string) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[item] = true
+	}
+	return set
+}
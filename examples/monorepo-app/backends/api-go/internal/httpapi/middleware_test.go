@@ -0,0 +1,341 @@
+package httpapi
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/auth"
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+	"github.com/your-org/your-app/backends/api-go/internal/proxyproto"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+)
+
+// withTrustedProxies sets TRUSTED_PROXIES for the rest of t.
+func withTrustedProxies(t *testing.T, cidrs ...string) {
+	t.Helper()
+	prev := trustedProxies.Load()
+	setTrustedProxies(cidrs)
+	t.Cleanup(func() { trustedProxies.Store(prev) })
+}
+
+func TestClientIP(t *testing.T) {
+	withTrustedProxies(t, "10.0.0.0/8")
+	tests := []struct {
+		name   string
+		remote string
+		xff    []string
+		want   string
+	}{
+		{"peer", "198.51.100.1:4000", nil, "198.51.100.1"},
+		{"spoofed by a client", "198.51.100.1:4000", []string{"1.2.3.4"}, "198.51.100.1"},
+		{"from a trusted proxy", "10.0.0.5:4000", []string{"203.0.113.9"}, "203.0.113.9"},
+		{"hop prepended by the client", "10.0.0.5:4000", []string{"1.2.3.4, 203.0.113.9"}, "203.0.113.9"},
+		{"chain of trusted proxies", "10.0.0.5:4000", []string{"203.0.113.9", "10.0.0.6"}, "203.0.113.9"},
+		{"garbage from a trusted proxy", "10.0.0.5:4000", []string{"not-an-ip"}, "10.0.0.5"},
+		{"ipv6", "[2001:db8::1]:4000", []string{"1.2.3.4"}, "2001:db8::1"},
+		{"unix socket", "@", []string{"1.2.3.4"}, unixPeer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := clientIP(r); got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestClientIPBehindProxyProtocol sends a request with a forged
+// X-Forwarded-For over a connection whose PROXY v1 header names the client.
+func TestClientIPBehindProxyProtocol(t *testing.T) {
+	withTrustedProxies(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(chan string, 1)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen <- clientIP(r)
+	})}
+	go srv.Serve(&proxyproto.Listener{Listener: ln})
+	t.Cleanup(func() { srv.Close() })
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "PROXY TCP4 203.0.113.7 192.0.2.1 51000 443\r\n"+
+		"GET / HTTP/1.1\r\nHost: api\r\nX-Forwarded-For: 1.2.3.4\r\nConnection: close\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := <-seen; got != "203.0.113.7" {
+		t.Errorf("clientIP = %q, want the PROXY source 203.0.113.7", got)
+	}
+}
+
+// legacyHeaders is what SecurityHeaders sent before the header set was
+// configurable; the defaults must not change it.
+var legacyHeaders = map[string]string{
+	"X-Content-Type-Options": "nosniff",
+	"X-Frame-Options":        "DENY",
+	"X-Xss-Protection":       "1; mode=block",
+	"Referrer-Policy":        "strict-origin-when-cross-origin",
+	"Permissions-Policy":     "camera=(), microphone=(), geolocation=()",
+	"Content-Security-Policy": "default-src 'none'; script-src 'self'; style-src 'self' 'unsafe-inline'; " +
+		"img-src 'self' data:; font-src 'self'; connect-src 'self'; " +
+		"base-uri 'self'; form-action 'self'; frame-ancestors 'none'",
+}
+
+// TestSecurityHeadersGolden renders the header set of each configuration
+// and compares it with testdata/security-headers/<name>.txt.
+func TestSecurityHeadersGolden(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(*config.Config)
+	}{
+		{"default", func(*config.Config) {}},
+		{"production", func(c *config.Config) { c.Environment = "production" }},
+		{"csp-extra", func(c *config.Config) {
+			c.Security.CSPExtra = "img-src https://cdn.example.com; default-src https://api.example.com"
+		}},
+		{"csp-override", func(c *config.Config) {
+			c.Security.CSPOverride = "default-src 'self'; frame-ancestors 'none'"
+			c.Security.CSPExtra = "img-src https://ignored.example.com"
+		}},
+		{"frame-ancestors", func(c *config.Config) { c.Security.FrameProtection = "frame-ancestors" }},
+		{"x-frame-options", func(c *config.Config) { c.Security.FrameProtection = "x-frame-options" }},
+		{"no-xss-protection", func(c *config.Config) { c.Security.XSSProtection = false }},
+		{"hsts", func(c *config.Config) {
+			c.Environment = "production"
+			c.Security.HSTSMaxAge, c.Security.HSTSIncludeSubDomains, c.Security.HSTSPreload = 600, false, false
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Defaults()
+			cfg.Environment = "test"
+			tt.set(cfg)
+			h := securityHeaders(cfg)
+			var b strings.Builder
+			for _, name := range slices.Sorted(maps.Keys(h)) {
+				fmt.Fprintf(&b, "%s: %s\n", name, strings.Join(h[name], ", "))
+			}
+			checkGolden(t, filepath.Join("testdata", "security-headers", tt.name+".txt"), b.String())
+
+			if tt.name != "default" && tt.name != "production" {
+				return
+			}
+			want := maps.Clone(legacyHeaders)
+			if tt.name == "production" {
+				want["Strict-Transport-Security"] = "max-age=63072000; includeSubDomains; preload"
+			}
+			if len(h) != len(want) {
+				t.Errorf("%d headers, want %d: %v", len(h), len(want), h)
+			}
+			for name, v := range want {
+				if got := h.Get(name); got != v {
+					t.Errorf("%s: %q, want %q", name, got, v)
+				}
+			}
+		})
+	}
+}
+
+func TestCSP(t *testing.T) {
+	csp := ParseCSP("default-src 'none';  img-src 'self' data: ; ;script-src 'self'")
+	if got, want := csp.String(), "default-src 'none'; img-src 'self' data:; script-src 'self'"; got != want {
+		t.Errorf("parsed %q, want %q", got, want)
+	}
+	csp.Merge(ParseCSP("IMG-SRC https://cdn.example.com data:; default-src 'self'; connect-src wss:"))
+	if got, want := csp.String(), "default-src 'self'; img-src 'self' data: https://cdn.example.com; script-src 'self'; connect-src wss:"; got != want {
+		t.Errorf("merged %q, want %q", got, want)
+	}
+	csp.Remove("Script-Src")
+	if got, want := csp.String(), "default-src 'self'; img-src 'self' data: https://cdn.example.com; connect-src wss:"; got != want {
+		t.Errorf("removed %q, want %q", got, want)
+	}
+}
+
+// RelaxCSP widens the policy of the routes it wraps only.
+func TestRelaxCSP(t *testing.T) {
+	cfg := config.Defaults()
+	cfg.Environment = "test"
+	m := &Middleware{cfg: cfg}
+	m.Reload(cfg)
+	mux := http.NewServeMux()
+	mux.Handle("/docs", RelaxCSP("script-src https://cdn.example.com; frame-ancestors 'self'")(http.NotFoundHandler()))
+	mux.Handle("/api", http.NotFoundHandler())
+	h := m.SecurityHeaders(mux)
+
+	get := func(path string) string {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec.Header().Get("Content-Security-Policy")
+	}
+	if got := get("/api"); got != legacyHeaders["Content-Security-Policy"] {
+		t.Errorf("/api: %q", got)
+	}
+	docs := get("/docs")
+	if !strings.Contains(docs, "script-src 'self' https://cdn.example.com;") || !strings.HasSuffix(docs, "frame-ancestors 'self'") {
+		t.Errorf("/docs: %q", docs)
+	}
+	if got := get("/api"); got != legacyHeaders["Content-Security-Policy"] {
+		t.Errorf("/api after /docs: %q", got)
+	}
+}
+
+// During a rotation tokens signed with JWT_SECRET_PREVIOUS still verify,
+// and are counted; tokens signed with neither secret do not.
+func TestVerifyTokenRotation(t *testing.T) {
+	const primary, previous, other = "the-new-jwt-secret-0123456789abcdef", "the-old-jwt-secret-0123456789abcdef", "some-other-jwt-secret-0123456789ab"
+	sign := func(secret string, exp time.Time) string {
+		token, err := auth.CreateJWT(secret, auth.Claims{UserID: "u1", Role: "user", Exp: exp.Unix(), Iat: time.Now().Unix()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	used := func() int64 {
+		if v, ok := jwtStats.Get("previous_secret").(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	later, earlier := time.Now().Add(time.Hour), time.Now().Add(-time.Minute)
+	rotating := &config.Config{JWTSecret: primary, JWTSecretPrevious: previous}
+	settled := &config.Config{JWTSecret: primary}
+
+	for _, tt := range []struct {
+		name    string
+		cfg     *config.Config
+		token   string
+		want    error // nil when valid
+		counted bool
+	}{
+		{"primary", rotating, sign(primary, later), nil, false},
+		{"previous", rotating, sign(previous, later), nil, true},
+		{"neither", rotating, sign(other, later), auth.ErrTokenSignature, false},
+		{"previous, expired", rotating, sign(previous, earlier), auth.ErrTokenExpired, false},
+		{"primary, expired", rotating, sign(primary, earlier), auth.ErrTokenExpired, false},
+		{"previous, once dropped", settled, sign(previous, later), auth.ErrTokenSignature, false},
+		{"garbage", rotating, "not.a.token", auth.ErrTokenInvalid, false},
+	} {
+		before := used()
+		claims, err := verifyToken(tt.cfg, tt.token)
+		if tt.want == nil && (err != nil || claims.UserID != "u1") || tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%s: %+v, %v; want %v", tt.name, claims, err, tt.want)
+		}
+		if counted := used() > before; counted != tt.counted {
+			t.Errorf("%s: counted as a previous-secret verification: %v", tt.name, counted)
+		}
+	}
+}
+
+// Over HTTP, a token signed with the previous secret authenticates and
+// one signed with neither is token_invalid.
+func TestJWTSecretPrevious(t *testing.T) {
+	const previous = "the-old-jwt-secret-0123456789abcdef"
+	st := store.NewMemory()
+	_, ts := openAPIServer(t, st, func(cfg *config.Config) { cfg.JWTSecretPrevious = previous })
+	admin, err := st.GetUserByEmail("admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(secret string) string {
+		token, err := auth.CreateJWT(secret, auth.Claims{UserID: admin.ID, Email: admin.Email, Role: admin.Role, Exp: time.Now().Add(time.Hour).Unix(), Iat: time.Now().Unix()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	for _, tt := range []struct {
+		name, token string
+		status      int
+		code        string
+	}{
+		{"primary", openAPIToken(t, admin), http.StatusOK, ""},
+		{"previous", sign(previous), http.StatusOK, ""},
+		{"neither", sign("some-other-jwt-secret-0123456789ab"), http.StatusUnauthorized, api.ErrCodeTokenInvalid},
+	} {
+		req, _ := http.NewRequest("GET", ts.URL+"/api/v1/users/me", nil)
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var e APIError
+		json.NewDecoder(resp.Body).Decode(&e)
+		resp.Body.Close()
+		if resp.StatusCode != tt.status || e.ErrorCode != tt.code {
+			t.Errorf("%s: %d %s, want %d %s", tt.name, resp.StatusCode, e.ErrorCode, tt.status, tt.code)
+		}
+	}
+}
+
+// CSRF_EXEMPT_BEARER lets a write authenticated by the Authorization
+// header through without X-CSRF-Token; any other credential source, such
+// as a cookie, still needs the token, and without the setting so does the
+// header.
+func TestCSRFExemptBearer(t *testing.T) {
+	st := store.NewMemory()
+	st.StoreCSRFToken("csrf-of-u1", "u1", "", time.Hour)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+
+	for _, tt := range []struct {
+		exempt     bool
+		credential string
+		csrf       string
+		want       int
+	}{
+		{false, credentialBearer, "csrf-of-u1", http.StatusNoContent},
+		{false, credentialBearer, "", http.StatusForbidden},
+		{false, "cookie", "csrf-of-u1", http.StatusNoContent},
+		{false, "cookie", "", http.StatusForbidden},
+		{true, credentialBearer, "csrf-of-u1", http.StatusNoContent},
+		{true, credentialBearer, "", http.StatusNoContent},
+		{true, credentialBearer, "not-a-token", http.StatusNoContent},
+		{true, "cookie", "csrf-of-u1", http.StatusNoContent},
+		{true, "cookie", "", http.StatusForbidden},
+		{true, "cookie", "not-a-token", http.StatusForbidden},
+		{true, "", "", http.StatusForbidden}, // no credential recorded
+	} {
+		cfg := config.Defaults()
+		cfg.CSRFExemptBearer = tt.exempt
+		m := NewMiddleware(cfg, st, nil, NewEventBus())
+		req := httptest.NewRequest("POST", "/api/v1/things", nil)
+		ctx := context.WithValue(req.Context(), ctxUserID, "u1")
+		if tt.credential != "" {
+			ctx = context.WithValue(ctx, ctxCredential, tt.credential)
+		}
+		if tt.csrf != "" {
+			req.Header.Set("X-CSRF-Token", tt.csrf)
+		}
+		rec := httptest.NewRecorder()
+		m.CSRFProtection(ok).ServeHTTP(rec, req.WithContext(ctx))
+		if rec.Code != tt.want {
+			t.Errorf("exempt %v, credential %q, CSRF token %q: %d, want %d", tt.exempt, tt.credential, tt.csrf, rec.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package httpapi
+
+import (
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+)
+
+// The wire types clients share (raijinctl) live in package api.
+type (
+	User              = api.User
+	LoginRequest      = api.LoginRequest
+	RegisterRequest   = api.RegisterRequest
+	RefreshRequest    = api.RefreshRequest
+	CreateUserRequest = api.CreateUserRequest
+	SetRoleRequest    = api.SetRoleRequest
+	RevokedTokens     = api.RevokedTokens
+	WhoAmI            = api.WhoAmI
+	TokenInfo         = api.TokenInfo
+	Preferences       = api.Preferences
+	Lockout           = api.Lockout
+	Organization      = api.Organization
+	OrgMembership     = api.OrgMembership
+	OrgMember         = api.OrgMember
+	CreateOrgRequest  = api.CreateOrgRequest
+	UpdateOrgRequest  = api.UpdateOrgRequest
+	SwitchOrgRequest  = api.SwitchOrgRequest
+	SwitchOrgResponse = api.SwitchOrgResponse
+	AuthResponseV2    = api.AuthResponseV2
+	PageInfo          = api.PageInfo
+	APIError          = api.APIError
+	FieldError        = api.FieldError
+	ProblemDetails    = api.ProblemDetails
+	HealthResponse    = api.HealthResponse
+	BuildInfo         = api.BuildInfo
+	MemoryStats       = api.MemoryStats
+	WarmupTask        = api.WarmupTask
+	OutboundStatus    = api.OutboundStatus
+	MaintenanceStatus = api.MaintenanceStatus
+)
+
+// The records the store persists live in package store.
+type (
+	SecurityEvent       = store.SecurityEvent
+	SecurityEventFilter = store.SecurityEventFilter
+	WebhookSubscription = store.WebhookSubscription
+	WebhookDelivery     = store.WebhookDelivery
+	WebhookMessage      = store.WebhookMessage
+	DataExport          = store.DataExport
+	Session             = store.Session
+	Membership          = store.Membership
+)
+
+type AuthResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	User         User   `json:"user"`
+	CSRFToken    string `json:"csrf_token"`
+	// PasswordWarning is set on registration with BREACH_CHECK=warn
+	// when the password appears in a known breach.
+	PasswordWarning string `json:"password_warning,omitempty"`
+
+	accessTTL, refreshTTL time.Duration // for AuthResponseV2
+}
+
+type SessionList struct {
+	Sessions []Session `json:"sessions"`
+	Total    int       `json:"total"`
+}
+
+type UserList struct {
+	Users []*User `json:"users" fields:"items"`
+	Total int     `json:"total"`
+}
+
+type ReadyResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+type MaintenanceRequest struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message"`
+}
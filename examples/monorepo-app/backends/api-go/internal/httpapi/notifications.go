@@ -0,0 +1,157 @@
+package httpapi
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+)
+
+// notificationCategories are the categories a user can opt out of.
+var notificationCategories = []string{api.NotifyNewDevice, api.NotifyProductAnnouncements}
+
+// mailCategories maps the message kinds that belong to a notification
+// category to it; kinds missing here are always sent. Product
+// announcements have no message type yet.
+var mailCategories = map[string]string{
+	"new_device": api.NotifyNewDevice,
+}
+
+// SuppressedMail is the MailQueue check that drops kind for a recipient
+// who opted out of its category, looking the recipient up by address.
+func SuppressedMail(st store.Store) func(kind, to string) bool {
+	return func(kind, to string) bool {
+		category, ok := mailCategories[kind]
+		if !ok {
+			return false
+		}
+		user, err := st.GetUserByEmail(to)
+		return err == nil && slices.Contains(user.Unsubscribed, category)
+	}
+}
+
+func notificationPreferences(user *User) api.NotificationPreferences {
+	return api.NotificationPreferences{
+		NewDevice:            !slices.Contains(user.Unsubscribed, api.NotifyNewDevice),
+		ProductAnnouncements: !slices.Contains(user.Unsubscribed, api.NotifyProductAnnouncements),
+	}
+}
+
+// setUnsubscribed opts user in or out of category. It never writes to
+// the slice in place: the store's previous copy of the user shares it.
+func setUnsubscribed(user *User, category string, out bool) {
+	i := slices.Index(user.Unsubscribed, category)
+	switch {
+	case out && i < 0:
+		user.Unsubscribed = append(slices.Clip(user.Unsubscribed), category)
+	case !out && i >= 0:
+		user.Unsubscribed = slices.Delete(slices.Clone(user.Unsubscribed), i, i+1)
+	}
+}
+
+// unsubscribeMAC keys the token with UNSUBSCRIBE_SECRET, so that
+// rotating JWT_SECRET leaves the links in mail already sent working. When
+// it is unset the JWT secret keys it, like otpHash.
+func (h *Handlers) unsubscribeMAC(userID, category string) string {
+	key := h.cfg.UnsubscribeSecret
+	if key == "" {
+		key = h.cfg.JWTSecret
+	}
+	return unsubscribeMACWith(key, userID, category)
+}
+
+func unsubscribeMACWith(key, userID, category string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte("unsubscribe\x00" + userID + "\x00" + category))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validUnsubscribeMAC checks a token's MAC against every key that may have
+// signed it: UNSUBSCRIBE_SECRET, and the JWT secrets, which keyed the
+// links sent before it was set or before JWT_SECRET was rotated. A forged
+// link could only unsubscribe, so the extra keys cost little.
+func (h *Handlers) validUnsubscribeMAC(userID, category, got string) bool {
+	for _, key := range []string{h.cfg.UnsubscribeSecret, h.cfg.JWTSecret, h.cfg.JWTSecretPrevious} {
+		if key != "" && hmac.Equal([]byte(got), []byte(unsubscribeMACWith(key, userID, category))) {
+			return true
+		}
+	}
+	return false
+}
+
+// unsubscribeLink is the footer link that opts userID out of category
+// without signing in. It points at the frontend, which calls GET
+// /notifications/unsubscribe with the token. The token does not expire.
+func (h *Handlers) unsubscribeLink(userID, category string) string {
+	token := userID + "." + category + "." + h.unsubscribeMAC(userID, category)
+	return h.cfg.AppURL + "/unsubscribe?token=" + url.QueryEscape(token)
+}
+
+// GetNotifications returns the caller's notification toggles.
+func (h *Handlers) GetNotifications(w http.ResponseWriter, r *http.Request) {
+	user, err := h.store.GetUserByID(r.Context().Value(ctxUserID).(string))
+	if err != nil {
+		writeUserError(w, r, err)
+		return
+	}
+	respond(w, r, http.StatusOK, notificationPreferences(user))
+}
+
+// UpdateNotifications turns the categories present in the body on or off
+// for the caller.
+func (h *Handlers) UpdateNotifications(w http.ResponseWriter, r *http.Request) {
+	var req api.UpdateNotificationsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorCode(w, r, http.StatusBadRequest, api.ErrCodeInvalidRequest, "invalid request body")
+		return
+	}
+	user, err := h.store.UpdateUser(r.Context().Value(ctxUserID).(string), func(u *User) {
+		if req.NewDevice != nil {
+			setUnsubscribed(u, api.NotifyNewDevice, !*req.NewDevice)
+		}
+		if req.ProductAnnouncements != nil {
+			setUnsubscribed(u, api.NotifyProductAnnouncements, !*req.ProductAnnouncements)
+		}
+	})
+	if err != nil {
+		writeUserError(w, r, err)
+		return
+	}
+	respond(w, r, http.StatusOK, notificationPreferences(user))
+}
+
+// Unsubscribe opts a user out of one category from the token in a mail
+// footer, without signing in. It only ever unsubscribes, so following a
+// link twice is harmless.
+func (h *Handlers) Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(r.URL.Query().Get("token"), ".")
+	if len(parts) != 3 || !slices.Contains(notificationCategories, parts[1]) ||
+		!h.validUnsubscribeMAC(parts[0], parts[1], parts[2]) {
+		writeErrorCode(w, r, http.StatusBadRequest, api.ErrCodeUnsubscribeInvalid, "invalid unsubscribe link")
+		return
+	}
+	userID, category := parts[0], parts[1]
+	if _, err := h.store.UpdateUser(userID, func(u *User) { setUnsubscribed(u, category, true) }); err != nil {
+		writeUserError(w, r, err)
+		return
+	}
+	respond(w, r, http.StatusOK, api.UnsubscribeResponse{Category: category, Unsubscribed: true})
+}
+
+// GetUserNotifications shows an admin a user's notification toggles, for
+// support ("why don't I get new-device alerts?").
+func (h *Handlers) GetUserNotifications(w http.ResponseWriter, r *http.Request) {
+	user, err := h.store.GetUserByID(r.PathValue("id"))
+	if err != nil {
+		writeUserError(w, r, err)
+		return
+	}
+	respond(w, r, http.StatusOK, notificationPreferences(user))
+}
@@ -0,0 +1,62 @@
+package httpapi_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+	"github.com/your-org/your-app/backends/api-go/raijintest"
+)
+
+const (
+	oldJWTSecret      = "raijintest-jwt-secret-before-the-rotation"
+	unsubscribeSecret = "raijintest-unsubscribe-secret-0123456789"
+)
+
+// unsubscribeToken signs a mail footer's token as the server does.
+func unsubscribeToken(key, userID, category string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte("unsubscribe\x00" + userID + "\x00" + category))
+	return userID + "." + category + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestUnsubscribeLinks(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(*config.Config)
+		key       string // that signed the link
+		want      int
+	}{
+		{"signed with JWT_SECRET", nil, "raijintest-jwt-secret-not-for-production", http.StatusOK},
+		{"signed before a JWT_SECRET rotation", func(c *config.Config) { c.JWTSecretPrevious = oldJWTSecret }, oldJWTSecret, http.StatusOK},
+		{"signed with UNSUBSCRIBE_SECRET", func(c *config.Config) { c.UnsubscribeSecret = unsubscribeSecret }, unsubscribeSecret, http.StatusOK},
+		{"signed before UNSUBSCRIBE_SECRET was set", func(c *config.Config) { c.UnsubscribeSecret = unsubscribeSecret }, "raijintest-jwt-secret-not-for-production", http.StatusOK},
+		{"signed with a secret since dropped", nil, oldJWTSecret, http.StatusBadRequest},
+		{"forged", nil, "guess", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts []raijintest.Option
+			if tt.configure != nil {
+				opts = append(opts, raijintest.WithConfig(tt.configure))
+			}
+			srv := raijintest.NewServer(t, opts...)
+			user := srv.CreateUser(t, "someone@example.com", raijintest.Password, "user")
+
+			token := unsubscribeToken(tt.key, user.ID, api.NotifyNewDevice)
+			resp := send(t, srv.Client(), "GET", srv.URL+"/api/v1/notifications/unsubscribe?token="+url.QueryEscape(token), nil, nil)
+			wantStatus(t, resp, tt.want)
+
+			var prefs api.NotificationPreferences
+			wantStatus(t, send(t, srv.ClientAs(t, user), "GET", srv.URL+"/api/v1/users/me/notifications", nil, &prefs), http.StatusOK)
+			if prefs.NewDevice != (tt.want != http.StatusOK) {
+				t.Errorf("new_device = %v after a %d", prefs.NewDevice, resp.StatusCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,758 @@
+package httpapi
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+)
+
+// RouteAccess is who may call a documented route.
+type RouteAccess int
+
+const (
+	AccessPublic RouteAccess = iota
+	AccessUser               // Bearer token
+	AccessAdmin              // Bearer token with the admin role
+)
+
+// APIRoute documents one route for the OpenAPI document. Request and
+// Response are zero values of the body types (nil: no body); schemas are
+// derived from their json tags, the same ones the handlers encode with.
+type APIRoute struct {
+	Pattern    string // as registered on the mux, "METHOD /path"
+	Summary    string
+	Tag        string
+	Access     RouteAccess
+	Idempotent bool // honors Idempotency-Key
+	Query      []QueryParam
+	Request    any
+	Status     int
+	Response   any
+	Errors     map[int][]string // route-specific error codes by status
+	Responses  map[int]any      // non-error bodies sent with another status
+}
+
+type QueryParam struct {
+	Name, Description string
+	Type              string // "string", "integer" or "boolean"
+}
+
+// fieldsParam documents writeJSONProjected.
+var fieldsParam = QueryParam{"fields", "comma-separated fields of the user to return, e.g. id,email,role", "string"}
+
+// apiRoutes is the API as documented at /openapi.json. Every route
+// registered on the public mux must be listed (see OpenAPIErr).
+var apiRoutes = []APIRoute{
+	{Pattern: "GET /health", Summary: "Liveness and status", Tag: "health", Status: http.StatusOK, Response: HealthResponse{},
+		Query:     []QueryParam{{"verbose", "1 adds build, runtime and check details (admins only)", "string"}},
+		Responses: map[int]any{http.StatusServiceUnavailable: HealthResponse{}}},
+	{Pattern: "GET /ready", Summary: "Readiness checks", Tag: "health", Status: http.StatusOK, Response: ReadyResponse{},
+		Responses: map[int]any{http.StatusServiceUnavailable: ReadyResponse{}}},
+	{Pattern: "GET /version", Summary: "Build information", Tag: "health", Status: http.StatusOK, Response: BuildInfo{}},
+	{Pattern: "GET /openapi.json", Summary: "This document", Tag: "health", Status: http.StatusOK},
+
+	{Pattern: "POST /api/v1/auth/register", Summary: "Create an account", Tag: "auth", Idempotent: true,
+		Request: RegisterRequest{}, Status: http.StatusCreated, Response: AuthResponse{},
+		Errors: map[int][]string{
+			http.StatusBadRequest:         {api.ErrCodeInvalidRequest, api.ErrCodeValidationFailed, api.ErrCodeInvalidEmail},
+			http.StatusForbidden:          {api.ErrCodeEmailDomain, api.ErrCodeEmailDisposable, api.ErrCodeCaptchaRequired},
+			http.StatusConflict:           {api.ErrCodeEmailTaken},
+			http.StatusServiceUnavailable: {api.ErrCodeCaptchaUnavailable},
+		}},
+	{Pattern: "POST /api/v1/auth/login", Summary: "Log in with email and password", Tag: "auth",
+		Request: LoginRequest{}, Status: http.StatusOK, Response: AuthResponse{},
+		Errors: map[int][]string{
+			http.StatusBadRequest:         {api.ErrCodeInvalidRequest, api.ErrCodeInvalidEmail},
+			http.StatusUnauthorized:       {api.ErrCodeInvalidCredentials},
+			http.StatusForbidden:          {api.ErrCodeAccountSuspended, api.ErrCodeAccountDeleting, api.ErrCodeCaptchaRequired},
+			http.StatusServiceUnavailable: {api.ErrCodeCaptchaUnavailable},
+		}},
+	{Pattern: "POST /api/v1/auth/cancel-deletion", Summary: "Restore an account pending deletion and log in", Tag: "auth",
+		Request: LoginRequest{}, Status: http.StatusOK, Response: AuthResponse{},
+		Errors: map[int][]string{
+			http.StatusBadRequest:         {api.ErrCodeInvalidRequest, api.ErrCodeInvalidEmail},
+			http.StatusUnauthorized:       {api.ErrCodeInvalidCredentials},
+			http.StatusForbidden:          {api.ErrCodeAccountSuspended, api.ErrCodeCaptchaRequired},
+			http.StatusConflict:           {api.ErrCodeInvalidRequest},
+			http.StatusServiceUnavailable: {api.ErrCodeCaptchaUnavailable},
+		}},
+	{Pattern: "POST /api/v1/auth/refresh", Summary: "Exchange a refresh token for new tokens", Tag: "auth",
+		Request: RefreshRequest{}, Status: http.StatusOK, Response: AuthResponse{},
+		Errors: map[int][]string{
+			http.StatusBadRequest:   {api.ErrCodeInvalidRequest},
+			http.StatusUnauthorized: {api.ErrCodeRefreshInvalid, api.ErrCodeSessionExpired, api.ErrCodeUserNotFound},
+			http.StatusForbidden:    {api.ErrCodeAccountSuspended, api.ErrCodeAccountDeleting},
+		}},
+	{Pattern: "POST /api/v1/auth/otp/request", Summary: "Text a login code to a verified phone number (202 whether or not one was sent)", Tag: "auth",
+		Request: OTPRequest{}, Status: http.StatusAccepted, Response: OTPChallenge{},
+		Errors: map[int][]string{
+			http.StatusBadRequest: {api.ErrCodeInvalidRequest, api.ErrCodeValidationFailed},
+			http.StatusNotFound:   {api.ErrCodeNotFound},
+		}},
+	{Pattern: "POST /api/v1/auth/otp/verify", Summary: "Log in with a code texted to the phone", Tag: "auth",
+		Request: OTPVerifyRequest{}, Status: http.StatusOK, Response: AuthResponse{},
+		Errors: map[int][]string{
+			http.StatusBadRequest:   {api.ErrCodeInvalidRequest, api.ErrCodeValidationFailed},
+			http.StatusUnauthorized: {api.ErrCodeOTPInvalid},
+			http.StatusForbidden:    {api.ErrCodeAccountSuspended, api.ErrCodeAccountDeleting},
+			http.StatusNotFound:     {api.ErrCodeNotFound},
+		}},
+	{Pattern: "GET /api/v1/auth/saml/login", Summary: "Start SAML single sign-on (redirects to the IdP)", Tag: "auth",
+		Status: http.StatusFound,
+		Errors: map[int][]string{http.StatusNotFound: {api.ErrCodeNotFound}}},
+	{Pattern: "POST /api/v1/auth/saml/acs", Summary: "SAML assertion consumer (form post from the IdP)", Tag: "auth",
+		Status: http.StatusOK, Response: AuthResponse{},
+		Errors: map[int][]string{
+			http.StatusBadRequest:   {api.ErrCodeInvalidRequest},
+			http.StatusUnauthorized: {api.ErrCodeSAMLInvalid},
+			http.StatusForbidden:    {api.ErrCodeAccountSuspended, api.ErrCodeAccountDeleting},
+			http.StatusNotFound:     {api.ErrCodeNotFound},
+		}},
+	{Pattern: "GET /api/v1/auth/saml/metadata", Summary: "SAML service provider metadata (XML)", Tag: "auth",
+		Status: http.StatusOK,
+		Errors: map[int][]string{http.StatusNotFound: {api.ErrCodeNotFound}}},
+
+	{Pattern: "GET /api/v1/auth/whoami", Summary: "Current user and the access token's expiry, session and login freshness", Tag: "auth", Access: AccessUser,
+		Status: http.StatusOK, Response: WhoAmI{},
+		Errors: map[int][]string{http.StatusNotFound: {api.ErrCodeUserNotFound}}},
+	{Pattern: "GET /api/v1/users/me", Summary: "Current user", Tag: "users", Access: AccessUser,
+		Query: []QueryParam{fieldsParam}, Status: http.StatusOK, Response: User{},
+		Errors: map[int][]string{
+			http.StatusBadRequest: {api.ErrCodeValidationFailed},
+			http.StatusNotFound:   {api.ErrCodeUserNotFound},
+		}},
+	{Pattern: "GET /api/v1/roles", Summary: "Roles users can be given: user, admin and ROLES", Tag: "users", Access: AccessUser,
+		Status: http.StatusOK, Response: RoleList{}},
+	{Pattern: "POST /api/v1/users/me/data-export", Summary: "Start an export of everything held about the current user (needs a recent login)",
+		Tag: "users", Access: AccessUser, Status: http.StatusAccepted, Response: DataExport{},
+		Errors: map[int][]string{
+			http.StatusUnauthorized:       {api.ErrCodeReauthRequired},
+			http.StatusServiceUnavailable: {api.ErrCodeOverloaded},
+		}},
+	{Pattern: "GET /api/v1/users/me/data-export/{id}", Summary: "Download a data export; 202 with its status until it is ready",
+		Tag: "users", Access: AccessUser, Status: http.StatusOK, Response: DataExportArchive{},
+		Responses: map[int]any{http.StatusAccepted: DataExport{}},
+		Errors: map[int][]string{
+			http.StatusNotFound:            {api.ErrCodeNotFound},
+			http.StatusInternalServerError: {api.ErrCodeInternal},
+		}},
+	{Pattern: "GET /api/v1/users/me/features", Summary: "Every feature flag, evaluated for the current user", Tag: "users", Access: AccessUser,
+		Status: http.StatusOK, Response: FeatureSet{}},
+	{Pattern: "GET /api/v1/users/me/sessions", Summary: "The current user's signed-in sessions", Tag: "users", Access: AccessUser,
+		Status: http.StatusOK, Response: SessionList{}},
+	{Pattern: "DELETE /api/v1/users/me/sessions/{id}", Summary: "Sign out of one session (X-Reauth-Required when it is the caller's own)", Tag: "users", Access: AccessUser,
+		Status: http.StatusNoContent,
+		Errors: map[int][]string{http.StatusNotFound: {api.ErrCodeNotFound}}},
+	{Pattern: "GET /api/v1/users/me/activity", Summary: "The current user's activity timeline, newest first", Tag: "users", Access: AccessUser,
+		Query: []QueryParam{
+			{"limit", "1-1000, default 50", "integer"},
+			{"cursor", "next_cursor of the previous page", "string"},
+		},
+		Status: http.StatusOK, Response: ActivityPage{},
+		Errors: map[int][]string{http.StatusBadRequest: {api.ErrCodeValidationFailed}}},
+	{Pattern: "GET /api/v1/users/me/preferences", Summary: "The current user's preferences, defaults filled in for the unset ones", Tag: "users", Access: AccessUser,
+		Status: http.StatusOK, Response: Preferences{}},
+	{Pattern: "PUT /api/v1/users/me/preferences", Summary: "Change the preferences present in the body; \"\" or 0 resets one to its default", Tag: "users", Access: AccessUser,
+		Request: api.UpdatePreferencesRequest{}, Status: http.StatusOK, Response: Preferences{},
+		Errors: map[int][]string{http.StatusBadRequest: {api.ErrCodeInvalidRequest, api.ErrCodeValidationFailed}}},
+	{Pattern: "GET /api/v1/users/me/notifications", Summary: "The current user's notification categories, true while their mail is sent", Tag: "users", Access: AccessUser,
+		Status: http.StatusOK, Response: api.NotificationPreferences{}},
+	{Pattern: "PUT /api/v1/users/me/notifications", Summary: "Turn the notification categories present in the body on or off", Tag: "users", Access: AccessUser,
+		Request: api.UpdateNotificationsRequest{}, Status: http.StatusOK, Response: api.NotificationPreferences{},
+		Errors: map[int][]string{http.StatusBadRequest: {api.ErrCodeInvalidRequest}}},
+	{Pattern: "GET /api/v1/notifications/unsubscribe", Summary: "Opt out of one notification category with the signed token from a mail footer", Tag: "users",
+		Query:  []QueryParam{{"token", "the token of the unsubscribe link", "string"}},
+		Status: http.StatusOK, Response: api.UnsubscribeResponse{},
+		Errors: map[int][]string{
+			http.StatusBadRequest: {api.ErrCodeUnsubscribeInvalid},
+			http.StatusNotFound:   {api.ErrCodeUserNotFound},
+		}},
+	{Pattern: "GET /api/v1/users/me/orgs", Summary: "The organizations the current user belongs to, with their role in each", Tag: "orgs", Access: AccessUser,
+		Status: http.StatusOK, Response: OrgMembershipList{}},
+	{Pattern: "POST /api/v1/auth/switch-org", Summary: "Re-issue the access token to act in one of the current user's organizations, or in none", Tag: "orgs", Access: AccessUser,
+		Request: SwitchOrgRequest{}, Status: http.StatusOK, Response: SwitchOrgResponse{},
+		Errors: map[int][]string{
+			http.StatusBadRequest: {api.ErrCodeInvalidRequest},
+			http.StatusNotFound:   {api.ErrCodeOrgNotFound},
+		}},
+	{Pattern: "GET /api/v1/orgs/current", Summary: "The organization the access token acts in, with the current user's role", Tag: "orgs", Access: AccessUser,
+		Status: http.StatusOK, Response: OrgMembership{},
+		Errors: map[int][]string{http.StatusForbidden: {api.ErrCodeOrgContextRequired}}},
+	{Pattern: "PATCH /api/v1/orgs/current", Summary: "Rename the organization the access token acts in, or change its slug (owners only)", Tag: "orgs", Access: AccessUser,
+		Request: UpdateOrgRequest{}, Status: http.StatusOK, Response: Organization{},
+		Errors: map[int][]string{
+			http.StatusBadRequest: {api.ErrCodeInvalidRequest, api.ErrCodeValidationFailed},
+			http.StatusForbidden:  {api.ErrCodeOrgContextRequired, api.ErrCodeForbidden},
+			http.StatusNotFound:   {api.ErrCodeOrgNotFound},
+			http.StatusConflict:   {api.ErrCodeOrgSlugTaken},
+		}},
+	{Pattern: "POST /api/v1/orgs", Summary: "Create an organization owned by the current user", Tag: "orgs", Access: AccessUser,
+		Request: CreateOrgRequest{}, Status: http.StatusCreated, Response: Organization{},
+		Errors: map[int][]string{
+			http.StatusBadRequest: {api.ErrCodeInvalidRequest, api.ErrCodeValidationFailed},
+			http.StatusConflict:   {api.ErrCodeOrgSlugTaken},
+		}},
+	{Pattern: "DELETE /api/v1/orgs/{id}", Summary: "Delete an organization the current user owns (force=true while it has other members)", Tag: "orgs", Access: AccessUser,
+		Query:  []QueryParam{{"force", "true to delete it with its members", "boolean"}},
+		Status: http.StatusNoContent,
+		Errors: map[int][]string{
+			http.StatusForbidden: {api.ErrCodeForbidden},
+			http.StatusNotFound:  {api.ErrCodeOrgNotFound},
+			http.StatusConflict:  {api.ErrCodeOrgHasMembers},
+		}},
+	{Pattern: "GET /api/v1/orgs/{id}/members", Summary: "The members of an organization the current user belongs to", Tag: "orgs", Access: AccessUser,
+		Status: http.StatusOK, Response: OrgMemberList{},
+		Errors: map[int][]string{http.StatusNotFound: {api.ErrCodeOrgNotFound}}},
+	{Pattern: "POST /api/v1/orgs/{id}/members", Summary: "Add an existing user to an organization, by email (owners only)", Tag: "orgs", Access: AccessUser,
+		Request: api.AddOrgMemberRequest{}, Status: http.StatusCreated, Response: OrgMember{},
+		Errors: map[int][]string{
+			http.StatusBadRequest: {api.ErrCodeInvalidRequest, api.ErrCodeValidationFailed, api.ErrCodeInvalidEmail},
+			http.StatusForbidden:  {api.ErrCodeForbidden},
+			http.StatusNotFound:   {api.ErrCodeOrgNotFound, api.ErrCodeUserNotFound},
+			http.StatusConflict:   {api.ErrCodeAlreadyMember},
+		}},
+	{Pattern: "PUT /api/v1/orgs/{id}/members/{uid}/role", Summary: "Make a member an owner or an owner a member (owners only)", Tag: "orgs", Access: AccessUser,
+		Request: SetRoleRequest{}, Status: http.StatusOK, Response: OrgMember{},
+		Errors: map[int][]string{
+			http.StatusBadRequest: {api.ErrCodeInvalidRequest, api.ErrCodeValidationFailed},
+			http.StatusForbidden:  {api.ErrCodeForbidden},
+			http.StatusNotFound:   {api.ErrCodeOrgNotFound, api.ErrCodeUserNotFound},
+			http.StatusConflict:   {api.ErrCodeOrgLastOwner},
+		}},
+	{Pattern: "DELETE /api/v1/orgs/{id}/members/{uid}", Summary: "Remove a member (owners), or leave the organization (uid is the current user)", Tag: "orgs", Access: AccessUser,
+		Status: http.StatusNoContent,
+		Errors: map[int][]string{
+			http.StatusForbidden: {api.ErrCodeForbidden},
+			http.StatusNotFound:  {api.ErrCodeOrgNotFound, api.ErrCodeUserNotFound},
+			http.StatusConflict:  {api.ErrCodeOrgLastOwner},
+		}},
+	{Pattern: "POST /api/v1/users/me/accept-terms", Summary: "Accept the current terms of service and privacy policy", Tag: "users", Access: AccessUser,
+		Request: AcceptTermsRequest{}, Status: http.StatusOK, Response: User{},
+		Errors: map[int][]string{
+			http.StatusBadRequest: {api.ErrCodeInvalidRequest, api.ErrCodeValidationFailed},
+			http.StatusNotFound:   {api.ErrCodeNotFound, api.ErrCodeUserNotFound},
+		}},
+	{Pattern: "POST /api/v1/users/me/phone", Summary: "Text a code confirming a phone number for the current user", Tag: "users", Access: AccessUser,
+		Request: OTPRequest{}, Status: http.StatusAccepted, Response: OTPChallenge{},
+		Errors: map[int][]string{
+			http.StatusBadRequest: {api.ErrCodeInvalidRequest, api.ErrCodeValidationFailed},
+			http.StatusNotFound:   {api.ErrCodeNotFound},
+			http.StatusConflict:   {api.ErrCodePhoneTaken},
+		}},
+	{Pattern: "POST /api/v1/users/me/phone/verify", Summary: "Set the current user's phone number with the texted code", Tag: "users", Access: AccessUser,
+		Request: OTPVerifyRequest{}, Status: http.StatusOK, Response: User{},
+		Errors: map[int][]string{
+			http.StatusBadRequest: {api.ErrCodeInvalidRequest, api.ErrCodeValidationFailed, api.ErrCodeOTPInvalid},
+			http.StatusNotFound:   {api.ErrCodeNotFound, api.ErrCodeUserNotFound},
+			http.StatusConflict:   {api.ErrCodePhoneTaken},
+		}},
+	{Pattern: "DELETE /api/v1/users/me", Summary: "Schedule the current user's account for deletion (needs a recent login)",
+		Tag: "users", Access: AccessUser, Status: http.StatusAccepted, Response: User{},
+		Errors: map[int][]string{
+			http.StatusUnauthorized: {api.ErrCodeReauthRequired},
+			http.StatusNotFound:     {api.ErrCodeUserNotFound},
+		}},
+	{Pattern: "GET /api/v1/users", Summary: "List users", Tag: "users", Access: AccessAdmin,
+		Query: []QueryParam{fieldsParam, {"pending_deletion", "true to list only the accounts scheduled for deletion", "boolean"},
+			{"flag", "list only the accounts with this flag, such as disposable_email", "string"}}, Status: http.StatusOK, Response: UserList{},
+		Errors: map[int][]string{http.StatusBadRequest: {api.ErrCodeValidationFailed}}},
+	{Pattern: "POST /api/v1/batch", Summary: "Run up to 10 API requests in one round trip", Tag: "batch", Access: AccessUser,
+		Request: BatchRequest{}, Status: http.StatusOK, Response: BatchResponse{},
+		Errors: map[int][]string{
+			http.StatusBadRequest:            {api.ErrCodeInvalidRequest, api.ErrCodeValidationFailed},
+			http.StatusRequestEntityTooLarge: {api.ErrCodePayloadTooLarge},
+		}},
+	{Pattern: "GET /api/v1/events", Summary: "Live events as text/event-stream (LiveEvent data; resumes from Last-Event-ID)", Tag: "users",
+		Access: AccessUser, Status: http.StatusOK,
+		Errors: map[int][]string{http.StatusBadRequest: {api.ErrCodeInvalidRequest}}},
+
+	{Pattern: "POST /api/v1/admin/maintenance", Summary: "Toggle maintenance mode", Tag: "admin", Access: AccessAdmin,
+		Request: MaintenanceRequest{}, Status: http.StatusOK, Response: MaintenanceStatus{},
+		Errors: map[int][]string{http.StatusBadRequest: {api.ErrCodeInvalidRequest}}},
+	{Pattern: "GET /api/v1/admin/stats", Summary: "User, session and login totals (cached for ADMIN_STATS_CACHE_TTL)", Tag: "admin", Access: AccessAdmin,
+		Status: http.StatusOK, Response: AdminStats{}},
+	{Pattern: "POST /api/v1/admin/users", Summary: "Create a user with any role", Tag: "admin", Access: AccessAdmin,
+		Request: CreateUserRequest{}, Status: http.StatusCreated, Response: User{},
+		Errors: map[int][]string{
+			http.StatusBadRequest: {api.ErrCodeInvalidRequest, api.ErrCodeValidationFailed, api.ErrCodeInvalidEmail, api.ErrCodeUnknownRole},
+			http.StatusConflict:   {api.ErrCodeEmailTaken},
+		}},
+	{Pattern: "PUT /api/v1/admin/users/{id}/role", Summary: "Change a user's role", Tag: "admin", Access: AccessAdmin,
+		Request: SetRoleRequest{}, Status: http.StatusOK, Response: User{},
+		Errors: map[int][]string{
+			http.StatusBadRequest: {api.ErrCodeInvalidRequest, api.ErrCodeUnknownRole},
+			http.StatusNotFound:   {api.ErrCodeUserNotFound},
+		}},
+	{Pattern: "POST /api/v1/admin/users/{id}/suspend", Summary: "Suspend a user and revoke its sessions", Tag: "admin", Access: AccessAdmin,
+		Status: http.StatusOK, Response: User{},
+		Errors: map[int][]string{http.StatusNotFound: {api.ErrCodeUserNotFound}}},
+	{Pattern: "DELETE /api/v1/admin/users/{id}/suspend", Summary: "Lift a suspension", Tag: "admin", Access: AccessAdmin,
+		Status: http.StatusOK, Response: User{},
+		Errors: map[int][]string{http.StatusNotFound: {api.ErrCodeUserNotFound}}},
+	{Pattern: "POST /api/v1/admin/users/{id}/revoke-tokens", Summary: "Revoke all of a user's tokens, access tokens included", Tag: "admin", Access: AccessAdmin,
+		Status: http.StatusOK, Response: RevokedTokens{},
+		Errors: map[int][]string{http.StatusNotFound: {api.ErrCodeUserNotFound}}},
+	{Pattern: "GET /api/v1/admin/users/{id}/activity", Summary: "A user's activity timeline: security events and admin actions, newest first", Tag: "admin", Access: AccessAdmin,
+		Query: []QueryParam{
+			{"limit", "1-1000, default 50", "integer"},
+			{"cursor", "next_cursor of the previous page", "string"},
+		},
+		Status: http.StatusOK, Response: ActivityPage{},
+		Errors: map[int][]string{
+			http.StatusBadRequest: {api.ErrCodeValidationFailed},
+			http.StatusNotFound:   {api.ErrCodeUserNotFound},
+		}},
+	{Pattern: "GET /api/v1/admin/users/{id}/notifications", Summary: "A user's notification categories, for support", Tag: "admin", Access: AccessAdmin,
+		Status: http.StatusOK, Response: api.NotificationPreferences{},
+		Errors: map[int][]string{http.StatusNotFound: {api.ErrCodeUserNotFound}}},
+	{Pattern: "DELETE /api/v1/admin/users/{id}/sessions/{sid}", Summary: "Sign a user out of one session", Tag: "admin", Access: AccessAdmin,
+		Status: http.StatusNoContent,
+		Errors: map[int][]string{http.StatusNotFound: {api.ErrCodeNotFound, api.ErrCodeUserNotFound}}},
+	{Pattern: "PUT /api/v1/admin/users/{id}/features/{name}", Summary: "Turn a feature flag on or off for one user (null removes the override)", Tag: "admin", Access: AccessAdmin,
+		Request: SetFeatureOverrideRequest{}, Status: http.StatusOK, Response: FeatureSet{},
+		Errors: map[int][]string{
+			http.StatusBadRequest: {api.ErrCodeInvalidRequest},
+			http.StatusNotFound:   {api.ErrCodeNotFound, api.ErrCodeUserNotFound},
+		}},
+	{Pattern: "GET /api/v1/admin/lockouts", Summary: "Emails locked out of password login, unknown accounts flagged", Tag: "admin", Access: AccessAdmin,
+		Status: http.StatusOK, Response: LockoutList{}},
+	{Pattern: "POST /api/v1/admin/lockouts/{userID}/unlock", Summary: "Lift a user's login lockout now", Tag: "admin", Access: AccessAdmin,
+		Status: http.StatusNoContent,
+		Errors: map[int][]string{http.StatusNotFound: {api.ErrCodeUserNotFound}}},
+	{Pattern: "GET /api/v1/admin/features", Summary: "Feature flags and their current state", Tag: "admin", Access: AccessAdmin,
+		Status: http.StatusOK, Response: FeatureFlagList{}},
+	{Pattern: "PUT /api/v1/admin/features/{name}", Summary: "Switch a feature flag for everyone, in place of its FEATURE_FLAGS default", Tag: "admin", Access: AccessAdmin,
+		Request: SetFeatureFlagRequest{}, Status: http.StatusOK, Response: FeatureFlagStatus{},
+		Errors: map[int][]string{
+			http.StatusBadRequest: {api.ErrCodeInvalidRequest, api.ErrCodeValidationFailed},
+			http.StatusNotFound:   {api.ErrCodeNotFound},
+		}},
+	{Pattern: "DELETE /api/v1/admin/features/{name}", Summary: "Put a feature flag back on its FEATURE_FLAGS default", Tag: "admin", Access: AccessAdmin,
+		Status: http.StatusOK, Response: FeatureFlagStatus{},
+		Errors: map[int][]string{http.StatusNotFound: {api.ErrCodeNotFound}}},
+	{Pattern: "GET /api/v1/admin/orgs", Summary: "List organizations", Tag: "admin", Access: AccessAdmin,
+		Status: http.StatusOK, Response: OrgList{}},
+	{Pattern: "POST /api/v1/admin/orgs", Summary: "Create an organization owned by the user with owner_email", Tag: "admin", Access: AccessAdmin,
+		Request: CreateOrgRequest{}, Status: http.StatusCreated, Response: Organization{},
+		Errors: map[int][]string{
+			http.StatusBadRequest: {api.ErrCodeInvalidRequest, api.ErrCodeValidationFailed, api.ErrCodeInvalidEmail},
+			http.StatusNotFound:   {api.ErrCodeUserNotFound},
+			http.StatusConflict:   {api.ErrCodeOrgSlugTaken},
+		}},
+	{Pattern: "GET /api/v1/admin/orgs/{id}", Summary: "Get an organization", Tag: "admin", Access: AccessAdmin,
+		Status: http.StatusOK, Response: Organization{},
+		Errors: map[int][]string{http.StatusNotFound: {api.ErrCodeOrgNotFound}}},
+	{Pattern: "PATCH /api/v1/admin/orgs/{id}", Summary: "Rename an organization or change its slug", Tag: "admin", Access: AccessAdmin,
+		Request: UpdateOrgRequest{}, Status: http.StatusOK, Response: Organization{},
+		Errors: map[int][]string{
+			http.StatusBadRequest: {api.ErrCodeInvalidRequest, api.ErrCodeValidationFailed},
+			http.StatusNotFound:   {api.ErrCodeOrgNotFound},
+			http.StatusConflict:   {api.ErrCodeOrgSlugTaken},
+		}},
+	{Pattern: "DELETE /api/v1/admin/orgs/{id}", Summary: "Delete an organization (force=true while it has members other than its owners)", Tag: "admin", Access: AccessAdmin,
+		Query:  []QueryParam{{"force", "true to delete it with its members", "boolean"}},
+		Status: http.StatusNoContent,
+		Errors: map[int][]string{
+			http.StatusNotFound: {api.ErrCodeOrgNotFound},
+			http.StatusConflict: {api.ErrCodeOrgHasMembers},
+		}},
+	{Pattern: "GET /api/v1/admin/orgs/{id}/members", Summary: "The members of an organization", Tag: "admin", Access: AccessAdmin,
+		Status: http.StatusOK, Response: OrgMemberList{},
+		Errors: map[int][]string{http.StatusNotFound: {api.ErrCodeOrgNotFound}}},
+	{Pattern: "POST /api/v1/admin/orgs/{id}/members", Summary: "Add an existing user to an organization, by email", Tag: "admin", Access: AccessAdmin,
+		Request: api.AddOrgMemberRequest{}, Status: http.StatusCreated, Response: OrgMember{},
+		Errors: map[int][]string{
+			http.StatusBadRequest: {api.ErrCodeInvalidRequest, api.ErrCodeValidationFailed, api.ErrCodeInvalidEmail},
+			http.StatusNotFound:   {api.ErrCodeOrgNotFound, api.ErrCodeUserNotFound},
+			http.StatusConflict:   {api.ErrCodeAlreadyMember},
+		}},
+	{Pattern: "DELETE /api/v1/admin/orgs/{id}/members/{uid}", Summary: "Remove a user from an organization", Tag: "admin", Access: AccessAdmin,
+		Status: http.StatusNoContent,
+		Errors: map[int][]string{
+			http.StatusNotFound: {api.ErrCodeOrgNotFound, api.ErrCodeUserNotFound},
+			http.StatusConflict: {api.ErrCodeOrgLastOwner},
+		}},
+	{Pattern: "GET /api/v1/admin/users/export", Summary: "Stream users as application/x-ndjson, one User per line (gzip with Accept-Encoding)", Tag: "admin",
+		Access: AccessAdmin, Query: []QueryParam{{"format", "ndjson, the default and only format", "string"},
+			{"pending_deletion", "true to export only the accounts scheduled for deletion", "boolean"},
+			{"flag", "export only the accounts with this flag, such as disposable_email", "string"}},
+		Status: http.StatusOK, Errors: map[int][]string{http.StatusBadRequest: {api.ErrCodeValidationFailed}}},
+	{Pattern: "GET /api/v1/admin/backup", Summary: "Dump users (with password hashes) and webhooks", Tag: "admin", Access: AccessAdmin,
+		Status: http.StatusOK, Response: Backup{}},
+	{Pattern: "GET /api/v1/admin/security-events", Summary: "Query the security audit log", Tag: "admin", Access: AccessAdmin,
+		Query: []QueryParam{
+			{"type", "event type", "string"},
+			{"user", "user ID or email", "string"},
+			{"since", "RFC 3339 timestamp", "string"},
+			{"until", "RFC 3339 timestamp", "string"},
+			{"limit", "1-1000, default 100", "integer"},
+		},
+		Status: http.StatusOK, Response: SecurityEventList{},
+		Errors: map[int][]string{http.StatusBadRequest: {api.ErrCodeValidationFailed}}},
+	{Pattern: "GET /api/v1/admin/webhooks", Summary: "List webhook subscriptions", Tag: "admin", Access: AccessAdmin,
+		Status: http.StatusOK, Response: WebhookList{}},
+	{Pattern: "POST /api/v1/admin/webhooks", Summary: "Create a webhook subscription", Tag: "admin", Access: AccessAdmin,
+		Request: CreateWebhookRequest{}, Status: http.StatusCreated, Response: WebhookSubscription{},
+		Errors: map[int][]string{http.StatusBadRequest: {api.ErrCodeInvalidRequest, api.ErrCodeValidationFailed}}},
+	{Pattern: "DELETE /api/v1/admin/webhooks/{id}", Summary: "Delete a webhook subscription", Tag: "admin", Access: AccessAdmin,
+		Status: http.StatusNoContent,
+		Errors: map[int][]string{http.StatusNotFound: {api.ErrCodeNotFound}}},
+	{Pattern: "GET /api/v1/admin/webhooks/deliveries", Summary: "Recent webhook delivery attempts", Tag: "admin", Access: AccessAdmin,
+		Query:  []QueryParam{{"subscription", "subscription ID", "string"}},
+		Status: http.StatusOK, Response: WebhookDeliveryList{}},
+	{Pattern: "GET /api/v1/admin/webhooks/dead-letters", Summary: "Webhook messages that ran out of attempts", Tag: "admin", Access: AccessAdmin,
+		Query:  []QueryParam{{"subscription", "subscription ID", "string"}},
+		Status: http.StatusOK, Response: WebhookMessageList{}},
+	{Pattern: "POST /api/v1/admin/webhooks/dead-letters/{id}/redrive", Summary: "Retry a dead webhook message", Tag: "admin", Access: AccessAdmin,
+		Status: http.StatusOK, Response: WebhookMessage{},
+		Errors: map[int][]string{http.StatusNotFound: {api.ErrCodeNotFound}}},
+	{Pattern: "GET /api/v1/ws", Summary: "Live user and session events (WebSocket; LiveEvent text messages)", Tag: "admin",
+		Access: AccessAdmin, Status: http.StatusSwitchingProtocols,
+		Errors: map[int][]string{
+			http.StatusBadRequest:      {api.ErrCodeInvalidRequest},
+			http.StatusUpgradeRequired: {api.ErrCodeInvalidRequest},
+		}},
+}
+
+// undocumentedRoutes are operational endpoints and the explorer, left out
+// of the document.
+var undocumentedRoutes = map[string]bool{
+	"GET /metrics": true, "/debug/": true, "GET /docs/": true,
+	"GET /dev/emails/": true, "GET /dev/emails/{kind}": true,
+}
+
+// errorCodes lists every ErrCode* value, for the error_code enumeration.
+var errorCodes = []string{
+	api.ErrCodeInvalidRequest, api.ErrCodeValidationFailed, api.ErrCodePayloadTooLarge, api.ErrCodeInvalidCredentials,
+	api.ErrCodeEmailTaken, api.ErrCodeInvalidEmail, api.ErrCodeEmailDomain, api.ErrCodeEmailDisposable, api.ErrCodePhoneTaken, api.ErrCodeOTPInvalid, api.ErrCodeAuthMissing, api.ErrCodeAuthMalformed, api.ErrCodeTokenMalformed, api.ErrCodeTokenInvalid, api.ErrCodeTokenExpired, api.ErrCodeTokenRevoked,
+	api.ErrCodeRefreshInvalid, api.ErrCodeReauthRequired, api.ErrCodeSessionExpired, api.ErrCodeSignatureInvalid, api.ErrCodeCSRFInvalid, api.ErrCodeForbidden, api.ErrCodeUnknownRole, api.ErrCodeAccountSuspended, api.ErrCodeAccountDeleting, api.ErrCodeTermsRequired, api.ErrCodeSAMLInvalid,
+	api.ErrCodeCaptchaRequired, api.ErrCodeCaptchaUnavailable, api.ErrCodeUserNotFound, api.ErrCodeRateLimited,
+	api.ErrCodeMaintenance, api.ErrCodeShuttingDown, api.ErrCodeOverloaded, api.ErrCodeUpstreamUnavailable, api.ErrCodeIdempotencyMismatch, api.ErrCodeIdempotencyInFlight, api.ErrCodeNotFound,
+	api.ErrCodeMethodNotAllowed, api.ErrCodeInternal, api.ErrCodeOrgNotFound, api.ErrCodeOrgSlugTaken, api.ErrCodeAlreadyMember,
+	api.ErrCodeOrgLastOwner, api.ErrCodeOrgHasMembers, api.ErrCodeOrgContextRequired, api.ErrCodeUnsubscribeInvalid,
+}
+
+// routeErrors adds the errors middleware can return on rt to its own.
+func routeErrors(rt APIRoute, method string) map[int][]string {
+	errs := make(map[int][]string)
+	add := func(status int, codes ...string) { errs[status] = append(errs[status], codes...) }
+	for status, codes := range rt.Errors {
+		add(status, codes...)
+	}
+	if rt.Access != AccessPublic {
+		add(http.StatusUnauthorized, api.ErrCodeAuthMissing, api.ErrCodeAuthMalformed, api.ErrCodeTokenMalformed, api.ErrCodeTokenInvalid, api.ErrCodeTokenExpired, api.ErrCodeTokenRevoked, api.ErrCodeSessionExpired)
+		add(http.StatusForbidden, api.ErrCodeAccountSuspended, api.ErrCodeAccountDeleting, api.ErrCodeTermsRequired)
+		if method != http.MethodGet {
+			add(http.StatusForbidden, api.ErrCodeCSRFInvalid)
+		}
+	}
+	if rt.Access == AccessAdmin {
+		add(http.StatusForbidden, api.ErrCodeForbidden)
+	}
+	if strings.HasPrefix(rt.Pattern[len(method)+1:], "/api/") {
+		add(http.StatusTooManyRequests, api.ErrCodeRateLimited)
+	}
+	if rt.Idempotent {
+		add(http.StatusConflict, api.ErrCodeIdempotencyInFlight)
+		add(http.StatusUnprocessableEntity, api.ErrCodeIdempotencyMismatch)
+		add(http.StatusRequestEntityTooLarge, api.ErrCodePayloadTooLarge)
+	}
+	add(http.StatusServiceUnavailable, api.ErrCodeMaintenance, api.ErrCodeShuttingDown, api.ErrCodeOverloaded)
+	add(http.StatusInternalServerError, api.ErrCodeInternal)
+	for status, codes := range errs {
+		slices.Sort(codes)
+		errs[status] = slices.Compact(codes)
+	}
+	return errs
+}
+
+// OpenAPIDocument builds the OpenAPI 3.1 document for routes.
+func OpenAPIDocument(routes []APIRoute) map[string]any {
+	schemas := map[string]any{}
+	ref := func(v any) map[string]any { return jsonSchema(reflect.TypeOf(v), schemas) }
+	errorContent := map[string]any{
+		"application/json":         map[string]any{"schema": ref(APIError{})},
+		"application/problem+json": map[string]any{"schema": ref(ProblemDetails{})},
+	}
+	paths := map[string]any{}
+	for _, rt := range routes {
+		method, path, _ := strings.Cut(rt.Pattern, " ")
+		op := map[string]any{
+			"operationId": strings.ToLower(method) + strings.NewReplacer("/", "_", "{", "", "}", "", "-", "_", ".", "_").Replace(path),
+			"summary":     rt.Summary,
+			"tags":        []string{rt.Tag},
+		}
+		var params []any
+		for _, seg := range strings.Split(path, "/") {
+			if name, ok := strings.CutPrefix(seg, "{"); ok {
+				params = append(params, map[string]any{"name": strings.TrimSuffix(name, "}"), "in": "path", "required": true,
+					"schema": map[string]any{"type": "string"}})
+			}
+		}
+		for _, q := range rt.Query {
+			params = append(params, map[string]any{"name": q.Name, "in": "query", "description": q.Description,
+				"schema": map[string]any{"type": q.Type}})
+		}
+		if rt.Idempotent {
+			params = append(params, map[string]any{"name": "Idempotency-Key", "in": "header",
+				"description": "replays the first response for a repeated key", "schema": map[string]any{"type": "string"}})
+		}
+		if params != nil {
+			op["parameters"] = params
+		}
+		switch rt.Access {
+		case AccessUser, AccessAdmin:
+			sec := map[string]any{"bearerAuth": []string{}}
+			if method != http.MethodGet {
+				sec["csrfToken"] = []string{}
+			}
+			op["security"] = []any{sec}
+		default:
+			op["security"] = []any{}
+		}
+		if rt.Request != nil {
+			op["requestBody"] = map[string]any{"required": true,
+				"content": map[string]any{"application/json": map[string]any{"schema": ref(rt.Request)}}}
+		}
+		ok := map[string]any{"description": http.StatusText(rt.Status)}
+		if rt.Response != nil {
+			ok["content"] = map[string]any{"application/json": map[string]any{"schema": ref(rt.Response)}}
+		}
+		responses := map[string]any{strconv.Itoa(rt.Status): ok}
+		errContent := errorContent
+		if versionOfPath(path).Problem {
+			errContent = map[string]any{"application/problem+json": errorContent["application/problem+json"]}
+		}
+		for status, codes := range routeErrors(rt, method) {
+			responses[strconv.Itoa(status)] = map[string]any{
+				"description": http.StatusText(status) + ": error_code one of " + strings.Join(codes, ", "),
+				"content":     errContent,
+			}
+		}
+		for status, body := range rt.Responses {
+			responses[strconv.Itoa(status)] = map[string]any{"description": http.StatusText(status),
+				"content": map[string]any{"application/json": map[string]any{"schema": ref(body)}}}
+		}
+		op["responses"] = responses
+		item, _ := paths[path].(map[string]any)
+		if item == nil {
+			item = map[string]any{}
+			paths[path] = item
+		}
+		item[strings.ToLower(method)] = op
+	}
+	if s, ok := schemas["APIError"].(map[string]any); ok {
+		s["properties"].(map[string]any)["error_code"] = map[string]any{"type": "string", "enum": errorCodes}
+	}
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":   "Raijin API",
+			"version": buildInfo().Version,
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				"csrfToken": map[string]any{"type": "apiKey", "in": "header", "name": "X-CSRF-Token",
+					"description": "csrf_token from the auth response; required on unsafe methods"},
+			},
+		},
+	}
+}
+
+// jsonSchema returns the schema for t, adding named structs to schemas and
+// referring to them by name.
+func jsonSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	switch t {
+	case reflect.TypeFor[time.Time]():
+		return map[string]any{"type": "string", "format": "date-time"}
+	case reflect.TypeFor[time.Duration]():
+		return map[string]any{"type": "integer", "description": "nanoseconds"}
+	case reflect.TypeFor[json.RawMessage]():
+		return map[string]any{"description": "any JSON value"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return jsonSchema(t.Elem(), schemas)
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": jsonSchema(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": jsonSchema(t.Elem(), schemas)}
+	case reflect.Struct:
+	default:
+		return map[string]any{}
+	}
+	name := schemaName(t)
+	if _, ok := schemas[name]; ok && name != "" {
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	s := map[string]any{"type": "object"}
+	if name != "" {
+		schemas[name] = s // before the fields, for recursive types
+	}
+	props := map[string]any{}
+	var required []string
+	for _, f := range reflect.VisibleFields(t) {
+		name, opts, ok := jsonFieldName(f)
+		if !ok {
+			continue
+		}
+		props[name] = jsonSchema(f.Type, schemas)
+		if !strings.Contains(opts, "omitempty") && !strings.Contains(opts, "omitzero") {
+			required = append(required, name)
+			if f.Type.Kind() == reflect.Pointer {
+				props[name] = nullable(props[name].(map[string]any))
+			}
+		}
+	}
+	s["properties"] = props
+	if required != nil {
+		s["required"] = required
+	}
+	if name == "" {
+		return s
+	}
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+// nullable is s that also admits null, as a nil pointer field that is not
+// omitted encodes.
+func nullable(s map[string]any) map[string]any {
+	if t, ok := s["type"].(string); ok {
+		s = maps.Clone(s)
+		s["type"] = []string{t, "null"}
+		return s
+	}
+	return map[string]any{"anyOf": []any{s, map[string]any{"type": "null"}}}
+}
+
+// jsonFieldName is the name encoding/json gives f, with its tag options;
+// ok is false for fields it leaves out.
+func jsonFieldName(f reflect.StructField) (name, opts string, ok bool) {
+	if !f.IsExported() || f.Anonymous {
+		return "", "", false
+	}
+	name, opts, _ = strings.Cut(f.Tag.Get("json"), ",")
+	if name == "-" {
+		return "", "", false
+	}
+	if name == "" {
+		name = f.Name
+	}
+	return name, opts, true
+}
+
+// schemaName is t's component name. Generic instantiations are named after
+// their type arguments: ListPage[*api.User] is ListPageUser.
+func schemaName(t reflect.Type) string {
+	base, args, ok := strings.Cut(t.Name(), "[")
+	if !ok {
+		return base
+	}
+	for arg := range strings.SplitSeq(strings.TrimSuffix(args, "]"), ",") {
+		arg = strings.TrimLeft(arg, "*[]")
+		base += arg[strings.LastIndex(arg, ".")+1:]
+	}
+	return base
+}
+
+// OpenAPIErr reports routes registered on rt but missing from routes, and
+// documented routes that are not registered. Patterns in undocumented,
+// such as proxied prefixes, may be registered without docs.
+func OpenAPIErr(rt *Router, routes []APIRoute, undocumented ...string) error {
+	var errs []error
+	documented := make(map[string]bool, len(routes))
+	for _, r := range routes {
+		documented[r.Pattern] = true
+	}
+	registered := make(map[string]bool)
+	for _, p := range rt.Patterns() {
+		registered[p] = true
+		if !documented[p] && !undocumentedRoutes[p] && !slices.Contains(undocumented, p) {
+			errs = append(errs, fmt.Errorf("route %q is not in apiRoutes", p))
+		}
+	}
+	for _, r := range routes {
+		if !registered[r.Pattern] {
+			errs = append(errs, fmt.Errorf("apiRoutes documents %q, which is not registered", r.Pattern))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// documentedRoutes is apiRoutes plus, for every later API version, its
+// copy of the /api/v1 routes with the bodies shaped as that version serves
+// them.
+func documentedRoutes() []APIRoute {
+	routes := slices.Clone(apiRoutes)
+	for _, v := range apiVersions[1:] {
+		for _, rt := range apiRoutes {
+			method, path, _ := strings.Cut(rt.Pattern, " ")
+			rest, ok := strings.CutPrefix(path, apiVersions[0].Prefix+"/")
+			if !ok {
+				continue
+			}
+			rt.Pattern = method + " " + v.Prefix + "/" + rest
+			if _, ok := rt.Response.(lister); ok && v.Paginated {
+				rt.Query = slices.DeleteFunc(slices.Clone(rt.Query), func(q QueryParam) bool { return q.Name == "limit" })
+				rt.Query = append(rt.Query,
+					QueryParam{"limit", fmt.Sprintf("page size, 1-%d, default %d", maxPageLimit, defaultPageLimit), "integer"},
+					QueryParam{"offset", "items to skip", "integer"})
+				rt.Errors = maps.Clone(rt.Errors)
+				if rt.Errors == nil {
+					rt.Errors = map[int][]string{}
+				}
+				if codes := rt.Errors[http.StatusBadRequest]; !slices.Contains(codes, api.ErrCodeValidationFailed) {
+					rt.Errors[http.StatusBadRequest] = append(slices.Clone(codes), api.ErrCodeValidationFailed)
+				}
+			}
+			if v.Transform != nil && rt.Response != nil {
+				rt.Response, _ = v.Transform(nil, rt.Response)
+			}
+			routes = append(routes, rt)
+		}
+	}
+	return routes
+}
+
+// openAPIDoc is the document served at /openapi.json.
+var openAPIDoc = sync.OnceValue(func() map[string]any { return OpenAPIDocument(documentedRoutes()) })
+
+//go:embed docs
+var docsFS embed.FS
+
+// docsCSP is what the explorer needs whatever CSP_OVERRIDE the API runs
+// with: its own script and stylesheet, and fetch to this origin.
+const docsCSP = "script-src 'self'; style-src 'self'; connect-src 'self'; img-src 'self' data:"
+
+// DocsHandler serves the API explorer under /docs/.
+func DocsHandler() http.Handler {
+	sub, err := fs.Sub(docsFS, "docs")
+	if err != nil {
+		panic(err) // the embed pattern guarantees the directory
+	}
+	return Chain(RelaxCSP(docsCSP))(http.StripPrefix("/docs/", http.FileServerFS(sub)))
+}
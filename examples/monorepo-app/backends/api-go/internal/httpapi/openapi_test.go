@@ -0,0 +1,375 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/auth"
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+)
+
+// openAPIServer serves the API as raijintest does, on st, with opts
+// applied to the config.
+func openAPIServer(t *testing.T, st store.Store, opts ...func(*config.Config)) (*Server, *httptest.Server) {
+	t.Helper()
+	s, ts := coldAPIServer(t, st, opts...)
+	if err := s.Warmup(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return s, ts
+}
+
+// coldAPIServer is openAPIServer before Warmup: /ready answers 503.
+func coldAPIServer(t *testing.T, st store.Store, opts ...func(*config.Config)) (*Server, *httptest.Server) {
+	t.Helper()
+	cfg := config.Defaults()
+	cfg.Environment = "test"
+	cfg.JWTSecret = "openapi-test-jwt-secret-not-for-production"
+	cfg.AuditLogOutput = "off"
+	cfg.AccessLogOutput = os.DevNull
+	cfg.RateLimitBuckets = []config.RateLimitBucket{
+		{Name: "auth", Limit: 10000, Window: time.Minute, Key: "ip"},
+		{Name: "api", Limit: 100000, Window: time.Minute, Key: "ip"},
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	s, err := New(cfg, st, WithMailer(&CaptureMailer{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s.Handler)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.Drain()
+		s.CloseStreams(ctx)
+		ts.Close()
+		s.Close(ctx)
+	})
+	return s, ts
+}
+
+// openAPIToken signs an access token for u that openAPIServer accepts.
+func openAPIToken(t *testing.T, u *api.User) string {
+	t.Helper()
+	jwt, err := auth.CreateJWT("openapi-test-jwt-secret-not-for-production", auth.Claims{
+		UserID: u.ID, Email: u.Email, Role: u.Role,
+		Exp: time.Now().Add(time.Hour).Unix(), Iat: time.Now().Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jwt
+}
+
+// fetchOpenAPI returns the document the server serves.
+func fetchOpenAPI(t *testing.T, ts *httptest.Server) map[string]any {
+	t.Helper()
+	resp, err := ts.Client().Get(ts.URL + "/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var doc map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// TestOpenAPIMatchesRouter compares the operations of the served document
+// with the patterns registered on the router, both ways.
+func TestOpenAPIMatchesRouter(t *testing.T) {
+	s, ts := openAPIServer(t, store.NewMemory())
+	doc := fetchOpenAPI(t, ts)
+
+	documented := map[string]bool{}
+	for path, item := range doc["paths"].(map[string]any) {
+		for method := range item.(map[string]any) {
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+	registered := map[string]bool{}
+	for _, p := range s.router.Patterns() {
+		registered[p] = true
+		if !documented[p] && !undocumentedRoutes[p] {
+			t.Errorf("%s is registered but not in /openapi.json", p)
+		}
+	}
+	for op := range documented {
+		if !registered[op] {
+			t.Errorf("/openapi.json documents %s, which is not registered", op)
+		}
+	}
+	if len(documented) < 100 {
+		t.Errorf("only %d operations documented", len(documented))
+	}
+}
+
+// TestErrorCodesEnumerated checks that the error_code enumeration lists
+// every ErrCode constant of package api.
+func TestErrorCodesEnumerated(t *testing.T) {
+	file, err := parser.ParseFile(token.NewFileSet(), filepath.Join("..", "..", "api", "api.go"), nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			for i, name := range spec.(*ast.ValueSpec).Names {
+				if !strings.HasPrefix(name.Name, "ErrCode") {
+					continue
+				}
+				n++
+				code, _ := strconv.Unquote(spec.(*ast.ValueSpec).Values[i].(*ast.BasicLit).Value)
+				if !slices.Contains(errorCodes, code) {
+					t.Errorf("api.%s (%q) is missing from errorCodes", name.Name, code)
+				}
+			}
+		}
+	}
+	if n != len(errorCodes) {
+		t.Errorf("api has %d error codes, errorCodes lists %d", n, len(errorCodes))
+	}
+}
+
+// TestResponsesMatchSchemas calls every documented GET route, and the
+// auth routes, and checks that the status is documented for the route and
+// the body matches the schema documented for that status.
+func TestResponsesMatchSchemas(t *testing.T) {
+	st := store.NewMemory()
+	_, ts := openAPIServer(t, st)
+	doc := fetchOpenAPI(t, ts)
+	v := &schemaValidator{schemas: doc["components"].(map[string]any)["schemas"].(map[string]any)}
+
+	admin, err := st.CreateUser("openapi-admin@example.com", "Admin", "openapi-password", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	org, err := st.CreateOrg(api.Organization{Name: "Acme", Slug: "acme"}, admin.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwt, err := auth.CreateJWT("openapi-test-jwt-secret-not-for-production", auth.Claims{
+		UserID: admin.ID, Email: admin.Email, Role: admin.Role,
+		Exp: time.Now().Add(time.Hour).Unix(), Iat: time.Now().Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := map[string]string{"id": admin.ID, "uid": admin.ID}
+
+	// Streams, redirects and the document itself are not JSON bodies to
+	// check.
+	skip := regexp.MustCompile(`/events$|/ws$|/saml/login$|^/openapi\.json$`)
+	checked := 0
+	for path, item := range doc["paths"].(map[string]any) {
+		op, ok := item.(map[string]any)["get"].(map[string]any)
+		if !ok || skip.MatchString(path) {
+			continue
+		}
+		url := regexp.MustCompile(`\{(\w+)\}`).ReplaceAllStringFunc(path, func(p string) string {
+			name := p[1 : len(p)-1]
+			if strings.Contains(path, "/orgs/") && name == "id" {
+				return org.ID
+			}
+			return ids[name]
+		})
+		req, _ := http.NewRequest("GET", ts.URL+url, nil)
+		req.Header.Set("Authorization", "Bearer "+jwt)
+		checkResponse(t, v, ts.Client(), req, "GET "+path, op)
+		checked++
+	}
+	if checked < 50 {
+		t.Errorf("only %d GET routes checked", checked)
+	}
+
+	for _, prefix := range []string{"/api/v1", "/api/v2"} {
+		paths := doc["paths"].(map[string]any)
+		post := func(path string, body any) []byte {
+			b, _ := json.Marshal(body)
+			req, _ := http.NewRequest("POST", ts.URL+path, strings.NewReader(string(b)))
+			req.Header.Set("Content-Type", "application/json")
+			return checkResponse(t, v, ts.Client(), req, "POST "+path, paths[path].(map[string]any)["post"].(map[string]any))
+		}
+		email := "user" + strings.ReplaceAll(prefix, "/", "-") + "@example.com"
+		post(prefix+"/auth/register", api.RegisterRequest{Email: email, Name: "User", Password: "openapi-password", AcceptTerms: true})
+		post(prefix+"/auth/register", api.RegisterRequest{Email: email, Name: "User", Password: "openapi-password", AcceptTerms: true})
+		post(prefix+"/auth/login", api.LoginRequest{Email: email, Password: "wrong-password"})
+		var login struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		json.Unmarshal(post(prefix+"/auth/login", api.LoginRequest{Email: email, Password: "openapi-password"}), &login)
+		post(prefix+"/auth/refresh", api.RefreshRequest{RefreshToken: login.RefreshToken})
+		post(prefix+"/auth/refresh", api.RefreshRequest{RefreshToken: "unknown"})
+	}
+}
+
+// checkResponse sends req and checks the response against op. It returns
+// the body.
+func checkResponse(t *testing.T, v *schemaValidator, client *http.Client, req *http.Request, name string, op map[string]any) []byte {
+	t.Helper()
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	documented, ok := op["responses"].(map[string]any)[strconv.Itoa(resp.StatusCode)].(map[string]any)
+	if !ok {
+		t.Errorf("%s: status %d is not documented: %s", name, resp.StatusCode, body)
+		return body
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	content, _ := documented["content"].(map[string]any)
+	if content == nil || (!strings.HasSuffix(mediaType, "json") && len(body) > 0) {
+		return body // no body documented, or a file download
+	}
+	media, ok := content[mediaType].(map[string]any)
+	if !ok {
+		t.Errorf("%s: %d sent as %s, documented as %v", name, resp.StatusCode, mediaType, slices.Collect(mapKeys(content)))
+		return body
+	}
+	var got any
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Errorf("%s: %v in %s", name, err, body)
+		return body
+	}
+	for _, problem := range v.validate(media["schema"].(map[string]any), got, "body") {
+		t.Errorf("%s: %d: %s", name, resp.StatusCode, problem)
+	}
+	return body
+}
+
+func mapKeys(m map[string]any) func(func(string) bool) {
+	return func(yield func(string) bool) {
+		for k := range m {
+			if !yield(k) {
+				return
+			}
+		}
+	}
+}
+
+// schemaValidator checks JSON values against the subset of JSON Schema
+// OpenAPIDocument produces.
+type schemaValidator struct {
+	schemas map[string]any
+}
+
+func (v *schemaValidator) validate(schema map[string]any, value any, at string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		return v.validate(v.schemas[strings.TrimPrefix(ref, "#/components/schemas/")].(map[string]any), value, at)
+	}
+	if anyOf, ok := schema["anyOf"].([]any); ok {
+		var problems []string
+		for _, s := range anyOf {
+			p := v.validate(s.(map[string]any), value, at)
+			if len(p) == 0 {
+				return nil
+			}
+			problems = append(problems, p...)
+		}
+		return problems
+	}
+	var problems []string
+	fail := func(format string, args ...any) { problems = append(problems, at+": "+fmt.Sprintf(format, args...)) }
+	if enum, ok := schema["enum"].([]any); ok && !slices.Contains(enum, value) {
+		fail("%v is not one of %v", value, enum)
+	}
+	typ, _ := schema["type"].(string)
+	if types, ok := schema["type"].([]any); ok {
+		if value == nil && slices.Contains(types, any("null")) {
+			return nil
+		}
+		typ = types[0].(string)
+	}
+	if value == nil {
+		// Nil slices and maps encode as null, which the document leaves
+		// implicit.
+		if typ != "" && typ != "array" && typ != "object" {
+			fail("null, want %s", typ)
+		}
+		return problems
+	}
+	switch typ {
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			fail("%T, want an object", value)
+			break
+		}
+		props, _ := schema["properties"].(map[string]any)
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := obj[name.(string)]; !ok {
+				fail("missing %s", name)
+			}
+		}
+		extra, _ := schema["additionalProperties"].(map[string]any)
+		for name, field := range obj {
+			switch s, ok := props[name].(map[string]any); {
+			case ok:
+				problems = append(problems, v.validate(s, field, at+"."+name)...)
+			case extra != nil:
+				problems = append(problems, v.validate(extra, field, at+"."+name)...)
+			case props != nil:
+				fail("undocumented field %s", name)
+			}
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			fail("%T, want an array", value)
+			break
+		}
+		for i, item := range items {
+			problems = append(problems, v.validate(schema["items"].(map[string]any), item, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			fail("%T, want a string", value)
+		} else if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+				fail("%q is not a date-time", s)
+			}
+		}
+	case "integer":
+		if n, ok := value.(float64); !ok || n != float64(int64(n)) {
+			fail("%v, want an integer", value)
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			fail("%T, want a number", value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			fail("%T, want a boolean", value)
+		}
+	}
+	return problems
+}
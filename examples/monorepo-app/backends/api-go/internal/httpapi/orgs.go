@@ -0,0 +1,566 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/auth"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+)
+
+// maxOrgNameLen is the longest Organization.Name, in characters.
+const maxOrgNameLen = 100
+
+// orgSlug matches an Organization.Slug: 3 to 50 lowercase letters, digits
+// and hyphens, starting and ending with a letter or digit.
+var orgSlug = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,48}[a-z0-9]$`)
+
+// slugify derives a slug from an organization's name: its ASCII letters
+// and digits, lowercased, with every run of anything else turned into one
+// hyphen. The result may still not be a valid slug (a name in another
+// script), in which case the slug has to be given.
+func slugify(name string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(name) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			hyphen = false
+		} else {
+			hyphen = true
+		}
+	}
+	return strings.TrimSuffix(b.String()[:min(b.Len(), 50)], "-")
+}
+
+type OrgList struct {
+	Orgs  []Organization `json:"orgs"`
+	Total int            `json:"total"`
+}
+
+type OrgMembershipList struct {
+	Orgs  []OrgMembership `json:"orgs"`
+	Total int             `json:"total"`
+}
+
+type OrgMemberList struct {
+	Members []OrgMember `json:"members"`
+	Total   int         `json:"total"`
+}
+
+// checkOrgName and checkOrgSlug return the field error for an invalid name
+// or slug, with the value cleaned up.
+func checkOrgName(name *string) []FieldError {
+	clean, problem := cleanText(*name, maxOrgNameLen)
+	if problem != "" {
+		return []FieldError{{Field: "name", Message: problem}}
+	}
+	*name = clean
+	return nil
+}
+
+func checkOrgSlug(slug *string) []FieldError {
+	*slug = strings.TrimSpace(*slug)
+	if !orgSlug.MatchString(*slug) {
+		return []FieldError{{Field: "slug", Message: "must be 3 to 50 lowercase letters, digits and hyphens, not starting or ending with a hyphen"}}
+	}
+	return nil
+}
+
+// writeOrgError answers a failed organization lookup or change.
+func writeOrgError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, store.ErrOrgNotFound):
+		writeErrorCode(w, r, http.StatusNotFound, api.ErrCodeOrgNotFound, "organization not found")
+	case errors.Is(err, store.ErrNotMember):
+		writeErrorCode(w, r, http.StatusNotFound, api.ErrCodeUserNotFound, "user not in the organization")
+	case errors.Is(err, store.ErrSlugTaken):
+		writeErrorCode(w, r, http.StatusConflict, api.ErrCodeOrgSlugTaken, err.Error())
+	case errors.Is(err, store.ErrAlreadyMember):
+		writeErrorCode(w, r, http.StatusConflict, api.ErrCodeAlreadyMember, err.Error())
+	case errors.Is(err, store.ErrLastOwner):
+		writeErrorCode(w, r, http.StatusConflict, api.ErrCodeOrgLastOwner, err.Error())
+	case errors.Is(err, store.ErrOrgHasMembers):
+		writeErrorCode(w, r, http.StatusConflict, api.ErrCodeOrgHasMembers, err.Error()+"; remove them or pass force=true")
+	default:
+		writeUserError(w, r, err)
+	}
+}
+
+// CreateOrg creates an organization, a tenant users belong to, with the
+// caller as its owner. Owners add existing users to it and manage their
+// roles there, which are unrelated to their account roles; admins manage
+// every organization from /admin/orgs.
+func (h *Handlers) CreateOrg(w http.ResponseWriter, r *http.Request) {
+	var req CreateOrgRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorCode(w, r, http.StatusBadRequest, api.ErrCodeInvalidRequest, "invalid request body")
+		return
+	}
+	fields := checkCreateOrg(&req)
+	if req.OwnerEmail != "" {
+		fields = append(fields, FieldError{Field: "owner_email", Message: "is only accepted from admins"})
+	}
+	if len(fields) > 0 {
+		writeErrorFields(w, r, http.StatusBadRequest, api.ErrCodeValidationFailed, "invalid organization", fields)
+		return
+	}
+	h.createOrg(w, r, req, r.Context().Value(ctxUserID).(string), false)
+}
+
+// AdminCreateOrg creates an organization owned by the user with
+// owner_email.
+func (h *Handlers) AdminCreateOrg(w http.ResponseWriter, r *http.Request) {
+	var req CreateOrgRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorCode(w, r, http.StatusBadRequest, api.ErrCodeInvalidRequest, "invalid request body")
+		return
+	}
+	fields := checkCreateOrg(&req)
+	if req.OwnerEmail == "" {
+		fields = append(fields, FieldError{Field: "owner_email", Message: "is required"})
+	}
+	if len(fields) > 0 {
+		writeErrorFields(w, r, http.StatusBadRequest, api.ErrCodeValidationFailed, "invalid organization", fields)
+		return
+	}
+	email, ok := checkEmail(w, r, req.OwnerEmail)
+	if !ok {
+		return
+	}
+	owner, err := h.store.GetUserByEmail(email)
+	if err != nil {
+		writeUserError(w, r, err)
+		return
+	}
+	if org, ok := h.createOrg(w, r, req, owner.ID, true); ok {
+		AdminAction.Publish(eventContext(r), h.events, AdminActionEvent{
+			Action: "org_create", Details: map[string]string{"org_id": org.ID, "user_id": owner.ID},
+		})
+	}
+}
+
+// checkCreateOrg validates req, deriving its slug from the name when it
+// has none.
+func checkCreateOrg(req *CreateOrgRequest) []FieldError {
+	fields := checkOrgName(&req.Name)
+	if req.Slug == "" && len(fields) == 0 {
+		if req.Slug = slugify(req.Name); !orgSlug.MatchString(req.Slug) {
+			return append(fields, FieldError{Field: "slug", Message: "is required: no valid slug can be derived from the name"})
+		}
+	}
+	return append(fields, checkOrgSlug(&req.Slug)...)
+}
+
+func (h *Handlers) createOrg(w http.ResponseWriter, r *http.Request, req CreateOrgRequest, ownerID string, admin bool) (Organization, bool) {
+	org, err := h.store.CreateOrg(Organization{Name: req.Name, Slug: req.Slug}, ownerID)
+	if err != nil {
+		writeOrgError(w, r, err)
+		return Organization{}, false
+	}
+	OrgMemberChanged.Publish(eventContext(r), h.events, OrgMemberEvent{OrgID: org.ID, UserID: ownerID, Role: api.OrgRoleOwner, Action: "added", ByAdmin: admin})
+	respond(w, r, http.StatusCreated, org)
+	return org, true
+}
+
+// ListMyOrgs lists the organizations the caller belongs to, with their
+// role in each.
+func (h *Handlers) ListMyOrgs(w http.ResponseWriter, r *http.Request) {
+	orgs := []OrgMembership{}
+	for _, m := range h.store.UserOrgs(r.Context().Value(ctxUserID).(string)) {
+		org, err := h.store.GetOrg(m.OrgID)
+		if err != nil {
+			continue // deleted meanwhile
+		}
+		orgs = append(orgs, OrgMembership{Organization: org, Role: m.Role, JoinedAt: m.JoinedAt})
+	}
+	respond(w, r, http.StatusOK, OrgMembershipList{Orgs: orgs, Total: len(orgs)})
+}
+
+// callerOrg returns the organization of the request's path, and the
+// caller's membership of it. Organizations the caller is not in answer
+// 404, as if they did not exist; owner requires the caller to own it.
+func (h *Handlers) callerOrg(w http.ResponseWriter, r *http.Request, owner bool) (Organization, Membership, bool) {
+	org, err := h.store.GetOrg(r.PathValue("id"))
+	if err != nil {
+		writeOrgError(w, r, err)
+		return Organization{}, Membership{}, false
+	}
+	m, ok := h.orgMembership(org.ID, r.Context().Value(ctxUserID).(string))
+	switch {
+	case !ok:
+		writeOrgError(w, r, store.ErrOrgNotFound)
+	case owner && m.Role != api.OrgRoleOwner:
+		writeErrorCode(w, r, http.StatusForbidden, api.ErrCodeForbidden, "only the organization's owners may do this")
+	default:
+		return org, m, true
+	}
+	return Organization{}, Membership{}, false
+}
+
+// orgMembership returns userID's membership of orgID.
+func (h *Handlers) orgMembership(orgID, userID string) (Membership, bool) {
+	for _, m := range h.store.UserOrgs(userID) {
+		if m.OrgID == orgID {
+			return m, true
+		}
+	}
+	return Membership{}, false
+}
+
+// adminOrg returns the organization of the request's path, for the admin
+// routes.
+func (h *Handlers) adminOrg(w http.ResponseWriter, r *http.Request) (Organization, bool) {
+	org, err := h.store.GetOrg(r.PathValue("id"))
+	if err != nil {
+		writeOrgError(w, r, err)
+		return Organization{}, false
+	}
+	return org, true
+}
+
+func (h *Handlers) ListOrgMembers(w http.ResponseWriter, r *http.Request) {
+	if org, _, ok := h.callerOrg(w, r, false); ok {
+		h.respondOrgMembers(w, r, org)
+	}
+}
+
+func (h *Handlers) AdminListOrgMembers(w http.ResponseWriter, r *http.Request) {
+	if org, ok := h.adminOrg(w, r); ok {
+		h.respondOrgMembers(w, r, org)
+	}
+}
+
+func (h *Handlers) respondOrgMembers(w http.ResponseWriter, r *http.Request, org Organization) {
+	members := []OrgMember{}
+	for _, m := range h.store.OrgMembers(org.ID) {
+		if u, err := h.store.GetUserByID(m.UserID); err == nil {
+			members = append(members, orgMemberOf(m, u))
+		}
+	}
+	respond(w, r, http.StatusOK, OrgMemberList{Members: members, Total: len(members)})
+}
+
+func orgMemberOf(m Membership, u *User) OrgMember {
+	return OrgMember{UserID: u.ID, Email: u.Email, Name: u.Name, Role: m.Role, JoinedAt: m.JoinedAt}
+}
+
+// AddOrgMember adds an existing user, by email, to one of the caller's
+// organizations. There is no invitation to accept: the organization shows
+// in the user's GET /users/me/orgs from then on.
+func (h *Handlers) AddOrgMember(w http.ResponseWriter, r *http.Request) {
+	if org, _, ok := h.callerOrg(w, r, true); ok {
+		h.addOrgMember(w, r, org, false)
+	}
+}
+
+func (h *Handlers) AdminAddOrgMember(w http.ResponseWriter, r *http.Request) {
+	if org, ok := h.adminOrg(w, r); ok {
+		h.addOrgMember(w, r, org, true)
+	}
+}
+
+func (h *Handlers) addOrgMember(w http.ResponseWriter, r *http.Request, org Organization, admin bool) {
+	var req api.AddOrgMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorCode(w, r, http.StatusBadRequest, api.ErrCodeInvalidRequest, "invalid request body")
+		return
+	}
+	if req.Role == "" {
+		req.Role = api.OrgRoleMember
+	}
+	if !checkOrgRole(w, r, req.Role) {
+		return
+	}
+	email, ok := checkEmail(w, r, req.Email)
+	if !ok {
+		return
+	}
+	user, err := h.store.GetUserByEmail(email)
+	if err != nil {
+		writeUserError(w, r, err)
+		return
+	}
+	m, err := h.store.AddOrgMember(Membership{OrgID: org.ID, UserID: user.ID, Role: req.Role})
+	if err != nil {
+		writeOrgError(w, r, err)
+		return
+	}
+	OrgMemberChanged.Publish(eventContext(r), h.events, OrgMemberEvent{OrgID: org.ID, UserID: user.ID, Role: m.Role, Action: "added", ByAdmin: admin})
+	if admin {
+		AdminAction.Publish(eventContext(r), h.events, AdminActionEvent{
+			Action: "org_member_add", Details: map[string]string{"org_id": org.ID, "user_id": user.ID, "role": m.Role},
+		})
+	}
+	respond(w, r, http.StatusCreated, orgMemberOf(m, user))
+}
+
+// checkOrgRole answers 400 unless role is an organization role.
+func checkOrgRole(w http.ResponseWriter, r *http.Request, role string) bool {
+	if role == api.OrgRoleOwner || role == api.OrgRoleMember {
+		return true
+	}
+	writeErrorFields(w, r, http.StatusBadRequest, api.ErrCodeValidationFailed, "invalid member",
+		[]FieldError{{Field: "role", Message: `must be "owner" or "member"`}})
+	return false
+}
+
+// SetOrgMemberRole makes a member an owner, or an owner a member; the
+// last owner cannot step down.
+func (h *Handlers) SetOrgMemberRole(w http.ResponseWriter, r *http.Request) {
+	org, _, ok := h.callerOrg(w, r, true)
+	if !ok {
+		return
+	}
+	var req SetRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorCode(w, r, http.StatusBadRequest, api.ErrCodeInvalidRequest, "invalid request body")
+		return
+	}
+	if !checkOrgRole(w, r, req.Role) {
+		return
+	}
+	user, err := h.store.GetUserByID(r.PathValue("uid"))
+	if err != nil {
+		writeUserError(w, r, err)
+		return
+	}
+	m, err := h.store.SetOrgMemberRole(org.ID, user.ID, req.Role)
+	if err != nil {
+		writeOrgError(w, r, err)
+		return
+	}
+	OrgMemberChanged.Publish(eventContext(r), h.events, OrgMemberEvent{OrgID: org.ID, UserID: user.ID, Role: m.Role, Action: "role_changed"})
+	respond(w, r, http.StatusOK, orgMemberOf(m, user))
+}
+
+// RemoveOrgMember takes a user out of an organization: an owner removing
+// anyone, or any member leaving. The last owner cannot leave; they can
+// delete the organization instead.
+func (h *Handlers) RemoveOrgMember(w http.ResponseWriter, r *http.Request) {
+	org, caller, ok := h.callerOrg(w, r, false)
+	if !ok {
+		return
+	}
+	userID, action := r.PathValue("uid"), "removed"
+	if userID == caller.UserID {
+		action = "left"
+	} else if caller.Role != api.OrgRoleOwner {
+		writeErrorCode(w, r, http.StatusForbidden, api.ErrCodeForbidden, "only the organization's owners may remove other members")
+		return
+	}
+	h.removeOrgMember(w, r, org, userID, action, false)
+}
+
+func (h *Handlers) AdminRemoveOrgMember(w http.ResponseWriter, r *http.Request) {
+	if org, ok := h.adminOrg(w, r); ok {
+		h.removeOrgMember(w, r, org, r.PathValue("uid"), "removed", true)
+	}
+}
+
+func (h *Handlers) removeOrgMember(w http.ResponseWriter, r *http.Request, org Organization, userID, action string, admin bool) {
+	var role string
+	for _, m := range h.store.OrgMembers(org.ID) {
+		if m.UserID == userID {
+			role = m.Role
+		}
+	}
+	if err := h.store.RemoveOrgMember(org.ID, userID); err != nil {
+		writeOrgError(w, r, err)
+		return
+	}
+	OrgMemberChanged.Publish(eventContext(r), h.events, OrgMemberEvent{OrgID: org.ID, UserID: userID, Role: role, Action: action, ByAdmin: admin})
+	if admin {
+		AdminAction.Publish(eventContext(r), h.events, AdminActionEvent{
+			Action: "org_member_remove", Details: map[string]string{"org_id": org.ID, "user_id": userID},
+		})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteOrg deletes one of the caller's organizations; see deleteOrg.
+func (h *Handlers) DeleteOrg(w http.ResponseWriter, r *http.Request) {
+	if org, _, ok := h.callerOrg(w, r, true); ok {
+		h.deleteOrg(w, r, org, false)
+	}
+}
+
+func (h *Handlers) AdminDeleteOrg(w http.ResponseWriter, r *http.Request) {
+	if org, ok := h.adminOrg(w, r); ok {
+		h.deleteOrg(w, r, org, true)
+	}
+}
+
+// deleteOrg deletes org with its memberships. While members other than the
+// owners remain it answers 409 org_has_members, unless ?force=true, so an
+// organization is not pulled from under its members by accident.
+func (h *Handlers) deleteOrg(w http.ResponseWriter, r *http.Request, org Organization, admin bool) {
+	force := r.URL.Query().Get("force") == "true"
+	removed, err := h.store.DeleteOrg(org.ID, force)
+	if err != nil {
+		writeOrgError(w, r, err)
+		return
+	}
+	for _, m := range removed {
+		OrgMemberChanged.Publish(eventContext(r), h.events, OrgMemberEvent{OrgID: org.ID, UserID: m.UserID, Role: m.Role, Action: "org_deleted", ByAdmin: admin})
+	}
+	if admin {
+		AdminAction.Publish(eventContext(r), h.events, AdminActionEvent{
+			Action: "org_delete", Details: map[string]string{"org_id": org.ID, "slug": org.Slug},
+		})
+	}
+	log.Printf("Organization %s (%s) deleted with %d memberships (request_id=%s)", org.ID, org.Slug, len(removed), r.Header.Get("X-Request-ID"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handlers) ListOrgs(w http.ResponseWriter, r *http.Request) {
+	orgs := h.store.ListOrgs()
+	respond(w, r, http.StatusOK, OrgList{Orgs: orgs, Total: len(orgs)})
+}
+
+func (h *Handlers) GetOrg(w http.ResponseWriter, r *http.Request) {
+	if org, ok := h.adminOrg(w, r); ok {
+		respond(w, r, http.StatusOK, org)
+	}
+}
+
+// UpdateOrg renames an organization or changes its slug.
+func (h *Handlers) UpdateOrg(w http.ResponseWriter, r *http.Request) {
+	h.updateOrg(w, r, r.PathValue("id"), true)
+}
+
+// UpdateCurrentOrg is UpdateOrg for the owners of the organization the
+// token acts in.
+func (h *Handlers) UpdateCurrentOrg(w http.ResponseWriter, r *http.Request) {
+	org, _ := ActiveOrg(r.Context())
+	h.updateOrg(w, r, org.ID, false)
+}
+
+func (h *Handlers) updateOrg(w http.ResponseWriter, r *http.Request, id string, admin bool) {
+	var req UpdateOrgRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorCode(w, r, http.StatusBadRequest, api.ErrCodeInvalidRequest, "invalid request body")
+		return
+	}
+	var fields []FieldError
+	if req.Name != nil {
+		fields = append(fields, checkOrgName(req.Name)...)
+	}
+	if req.Slug != nil {
+		fields = append(fields, checkOrgSlug(req.Slug)...)
+	}
+	if len(fields) > 0 {
+		writeErrorFields(w, r, http.StatusBadRequest, api.ErrCodeValidationFailed, "invalid organization", fields)
+		return
+	}
+	org, err := h.store.UpdateOrg(id, func(o *Organization) {
+		if req.Name != nil {
+			o.Name = *req.Name
+		}
+		if req.Slug != nil {
+			o.Slug = *req.Slug
+		}
+	})
+	if err != nil {
+		writeOrgError(w, r, err)
+		return
+	}
+	if admin {
+		AdminAction.Publish(eventContext(r), h.events, AdminActionEvent{
+			Action: "org_update", Details: map[string]string{"org_id": org.ID, "name": org.Name, "slug": org.Slug},
+		})
+	}
+	respond(w, r, http.StatusOK, org)
+}
+
+// OrgContext is the organization a request acts in, from the org_id and
+// org_role claims of its access token.
+type OrgContext struct {
+	ID   string
+	Role string
+}
+
+// ActiveOrg returns the organization the request on ctx acts in, if any.
+// Routes that need one sit behind Middleware.RequireOrgRole.
+func ActiveOrg(ctx context.Context) (OrgContext, bool) {
+	org, ok := ctx.Value(ctxOrg).(OrgContext)
+	return org, ok
+}
+
+// orgTokenRevoked reports whether claims act in an organization whose
+// membership was removed, or its role changed, after they were issued.
+func orgTokenRevoked(st store.Store, claims *auth.Claims) bool {
+	if claims.OrgID == "" {
+		return false
+	}
+	after := st.OrgTokensValidAfter(claims.OrgID, claims.UserID)
+	return !after.IsZero() && claims.Iat < after.Unix()
+}
+
+// SwitchOrg re-issues the caller's access token to act in one of their
+// organizations, with org_id and org_role claims, or in none. The other
+// claims stay, the expiry included, so switching never extends a token.
+// The refresh token is not rotated; the session remembers the
+// organization instead, and its refreshes keep acting in it.
+func (h *Handlers) SwitchOrg(w http.ResponseWriter, r *http.Request) {
+	var req SwitchOrgRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorCode(w, r, http.StatusBadRequest, api.ErrCodeInvalidRequest, "invalid request body")
+		return
+	}
+	claims := *r.Context().Value(ctxClaims).(*auth.Claims)
+	now := auth.Now()
+	claims.Iat, claims.OrgID, claims.OrgRole = now.Unix(), "", ""
+	var resp SwitchOrgResponse
+	if req.OrgID != "" {
+		m, ok := h.orgMembership(req.OrgID, claims.UserID)
+		if !ok {
+			writeOrgError(w, r, store.ErrOrgNotFound)
+			return
+		}
+		org, err := h.store.GetOrg(m.OrgID)
+		if err != nil {
+			writeOrgError(w, r, err)
+			return
+		}
+		claims.OrgID, claims.OrgRole = m.OrgID, m.Role
+		claims.Iat = max(claims.Iat, h.store.OrgTokensValidAfter(m.OrgID, claims.UserID).Unix())
+		resp.Org = &OrgMembership{Organization: org, Role: m.Role, JoinedAt: m.JoinedAt}
+	}
+	token, err := auth.CreateJWT(h.cfg.JWTSecret, claims)
+	if err != nil {
+		writeIssueError(w, r, err)
+		return
+	}
+	if claims.SessionID != "" {
+		h.store.SetSessionOrg(claims.SessionID, claims.OrgID)
+	}
+	resp.AccessToken, resp.ExpiresIn = token, max(0, claims.Exp-now.Unix())
+	respond(w, r, http.StatusOK, resp)
+}
+
+// GetCurrentOrg returns the organization the token acts in, with the
+// caller's role there.
+func (h *Handlers) GetCurrentOrg(w http.ResponseWriter, r *http.Request) {
+	active, _ := ActiveOrg(r.Context())
+	org, err := h.store.GetOrg(active.ID)
+	if err != nil {
+		writeOrgError(w, r, err)
+		return
+	}
+	m, ok := h.orgMembership(org.ID, r.Context().Value(ctxUserID).(string))
+	if !ok {
+		writeOrgError(w, r, store.ErrOrgNotFound)
+		return
+	}
+	respond(w, r, http.StatusOK, OrgMembership{Organization: org, Role: m.Role, JoinedAt: m.JoinedAt})
+}
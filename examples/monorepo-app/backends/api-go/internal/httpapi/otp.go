@@ -0,0 +1,317 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/auth"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+)
+
+const (
+	// otpTTL is how long a one-time code can be used.
+	otpTTL = 5 * time.Minute
+	// otpMaxActive caps the unexpired codes per phone and purpose;
+	// further requests send nothing until one expires or is used.
+	otpMaxActive = 3
+	// otpMaxAttempts wrong codes burn every code they were tried against.
+	otpMaxAttempts = 5
+)
+
+// One-time code purposes.
+const (
+	otpLogin  = "login"
+	otpVerify = "verify"
+)
+
+// e164 is an E.164 phone number: "+", a country code and at most 15
+// digits in all.
+var e164 = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// normalizePhone returns phone in E.164 form, dropping the spaces,
+// dashes, dots and parentheses people type, and false when it is not a
+// valid number.
+func normalizePhone(phone string) (string, bool) {
+	phone = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(" -.()", r) {
+			return -1
+		}
+		return r
+	}, phone)
+	return phone, e164.MatchString(phone)
+}
+
+type OTPRequest struct {
+	Phone string `json:"phone"`
+}
+
+type OTPVerifyRequest struct {
+	Phone string `json:"phone"`
+	Code  string `json:"code"`
+}
+
+// OTPChallenge answers a code request. It is the same whether or not a
+// code was sent, so it says nothing about which numbers are registered.
+type OTPChallenge struct {
+	ExpiresIn int `json:"expires_in"` // seconds a code sent now stays valid
+}
+
+// generateOTP returns a uniformly random 6-digit code.
+func generateOTP() string {
+	var b [4]byte
+	for {
+		auth.ReadRand(b[:])
+		// Reject the top of the range so every code is equally likely.
+		if v := binary.BigEndian.Uint32(b[:]); v < 4_294_000_000 {
+			return fmt.Sprintf("%06d", v%1_000_000)
+		}
+	}
+}
+
+// otpHash keys the stored hash with the JWT secret: a 6-digit code is
+// trivially brute-forced from a plain hash, not from an HMAC.
+func (h *Handlers) otpHash(purpose, userID, phone, code string) []byte {
+	mac := hmac.New(sha256.New, []byte(h.cfg.JWTSecret))
+	mac.Write([]byte(purpose + "\x00" + userID + "\x00" + phone + "\x00" + code))
+	return mac.Sum(nil)
+}
+
+// sendOTP issues a code for phone, unless otpMaxActive are already out,
+// and texts it in the background: how long the request takes must not
+// tell whether the number is registered.
+func (h *Handlers) sendOTP(purpose, userID, phone string) {
+	code := generateOTP()
+	c := store.OTPCode{
+		ID: auth.GenerateID(), Phone: phone, Purpose: purpose, UserID: userID,
+		Hash: h.otpHash(purpose, userID, phone, code), ExpiresAt: auth.Now().Add(otpTTL),
+	}
+	if !h.store.CreateOTP(c, otpMaxActive) {
+		return
+	}
+	body := fmt.Sprintf("Your verification code is %s. It expires in %d minutes.", code, int(otpTTL.Minutes()))
+	go func() {
+		if err := h.sms.Send(context.Background(), phone, body); err != nil {
+			log.Printf("ERROR sms: %s code: %v", purpose, err)
+		}
+	}()
+}
+
+// checkOTP consumes the code userID ("" for a login) entered for phone
+// and reports whether it was valid. Hashes are compared in constant time.
+// A wrong code counts against every active one, and burns those that
+// reach otpMaxAttempts.
+func (h *Handlers) checkOTP(purpose, userID, phone, code string) bool {
+	want := h.otpHash(purpose, userID, phone, code)
+	var active []store.OTPCode
+	for _, c := range h.store.ActiveOTPs(phone, purpose) {
+		if c.UserID == userID {
+			active = append(active, c)
+		}
+	}
+	for _, c := range active {
+		if subtle.ConstantTimeCompare(c.Hash, want) == 1 {
+			return h.store.DeleteOTP(c.ID)
+		}
+	}
+	for _, c := range active {
+		burnt := false
+		h.store.UpdateOTP(c.ID, func(o *store.OTPCode) {
+			o.Attempts++
+			burnt = o.Attempts >= otpMaxAttempts
+		})
+		if burnt {
+			h.store.DeleteOTP(c.ID)
+		}
+	}
+	return false
+}
+
+// otpLimited applies the per-IP and per-phone limits on code requests
+// (OTP_IP_LIMIT and OTP_PHONE_LIMIT per OTP_LIMIT_WINDOW), answering 429
+// when either is used up. Unknown numbers count like known ones.
+func (h *Handlers) otpLimited(w http.ResponseWriter, r *http.Request, phone string) bool {
+	if ok, retry := h.otpIP.allow(clientIP(r)); !ok {
+		h.otpIP.reject(w, r, retry)
+		return true
+	}
+	if ok, retry := h.otpPhone.allow(phone); !ok {
+		h.otpPhone.reject(w, r, retry)
+		return true
+	}
+	return false
+}
+
+// checkPhone returns phone normalized, or answers r with 400 and returns
+// false when it is not a valid number.
+func checkPhone(w http.ResponseWriter, r *http.Request, phone string) (string, bool) {
+	phone, ok := normalizePhone(phone)
+	if !ok {
+		writeErrorFields(w, r, http.StatusBadRequest, api.ErrCodeValidationFailed, "phone must be an E.164 number",
+			[]FieldError{{Field: "phone", Message: "must be an E.164 number, such as +5511912345678"}})
+	}
+	return phone, ok
+}
+
+// validCode reports whether code has the shape of a code, answering r
+// with 400 when it does not. Malformed codes count as no attempt.
+func validCode(w http.ResponseWriter, r *http.Request, code string) bool {
+	if len(code) == 6 && strings.Trim(code, "0123456789") == "" {
+		return true
+	}
+	writeErrorFields(w, r, http.StatusBadRequest, api.ErrCodeValidationFailed, "code must be 6 digits",
+		[]FieldError{{Field: "code", Message: "must be 6 digits"}})
+	return false
+}
+
+// smsOff answers 404 when SMS is not configured (SMS_DRIVER unset).
+func (h *Handlers) smsOff(w http.ResponseWriter, r *http.Request) bool {
+	if h.sms == nil {
+		writeErrorCode(w, r, http.StatusNotFound, api.ErrCodeNotFound, "SMS is not configured")
+		return true
+	}
+	return false
+}
+
+// RequestLoginOTP texts a login code to a verified phone number. It
+// answers 202 whether or not the number belongs to an account that may
+// log in, and limits requests per IP and per number.
+func (h *Handlers) RequestLoginOTP(w http.ResponseWriter, r *http.Request) {
+	if h.smsOff(w, r) {
+		return
+	}
+	var req OTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorCode(w, r, http.StatusBadRequest, api.ErrCodeInvalidRequest, "invalid request body")
+		return
+	}
+	phone, ok := checkPhone(w, r, req.Phone)
+	if !ok || h.otpLimited(w, r, phone) {
+		return
+	}
+	user, err := h.store.GetUserByPhone(phone)
+	switch {
+	case err == nil && !user.Suspended && !user.PendingDeletion():
+		h.sendOTP(otpLogin, "", phone)
+	case err != nil && !errors.Is(err, store.ErrUserNotFound):
+		writeUserError(w, r, err)
+		return
+	}
+	respond(w, r, http.StatusAccepted, OTPChallenge{ExpiresIn: int(otpTTL.Seconds())})
+}
+
+// VerifyLoginOTP logs in with a code from RequestLoginOTP, like Login
+// does with a password.
+func (h *Handlers) VerifyLoginOTP(w http.ResponseWriter, r *http.Request) {
+	if h.smsOff(w, r) {
+		return
+	}
+	var req OTPVerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorCode(w, r, http.StatusBadRequest, api.ErrCodeInvalidRequest, "invalid request body")
+		return
+	}
+	phone, ok := checkPhone(w, r, req.Phone)
+	if !ok || !validCode(w, r, req.Code) {
+		return
+	}
+	var user *User
+	if h.checkOTP(otpLogin, "", phone, req.Code) {
+		u, err := h.store.GetUserByPhone(phone)
+		if err != nil && !errors.Is(err, store.ErrUserNotFound) {
+			writeUserError(w, r, err)
+			return
+		}
+		user = u
+	}
+	if user == nil {
+		LoginFailed.Publish(eventContext(r), h.events, AuthFailureEvent{Reason: "otp_invalid"})
+		writeErrorCode(w, r, http.StatusUnauthorized, api.ErrCodeOTPInvalid, "invalid or expired code")
+		return
+	}
+	if !h.loginAllowed(w, r, user) {
+		return
+	}
+	LoggedIn.Publish(eventContext(r), h.events, UserEvent{User: *user})
+	h.noteDevice(r, user, true)
+	if err := h.respondAuth(w, r, http.StatusOK, user, nil); err != nil {
+		writeIssueError(w, r, err)
+	}
+}
+
+// RequestPhoneVerification texts a code confirming the caller owns phone;
+// VerifyPhone then sets it on the account. A number already on another
+// account is 409 phone_taken.
+func (h *Handlers) RequestPhoneVerification(w http.ResponseWriter, r *http.Request) {
+	if h.smsOff(w, r) {
+		return
+	}
+	userID := r.Context().Value(ctxUserID).(string)
+	var req OTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorCode(w, r, http.StatusBadRequest, api.ErrCodeInvalidRequest, "invalid request body")
+		return
+	}
+	phone, ok := checkPhone(w, r, req.Phone)
+	if !ok {
+		return
+	}
+	owner, err := h.store.GetUserByPhone(phone)
+	switch {
+	case err == nil && owner.ID != userID:
+		writeErrorCode(w, r, http.StatusConflict, api.ErrCodePhoneTaken, "phone number already in use")
+		return
+	case err != nil && !errors.Is(err, store.ErrUserNotFound):
+		writeUserError(w, r, err)
+		return
+	}
+	if h.otpLimited(w, r, phone) {
+		return
+	}
+	h.sendOTP(otpVerify, userID, phone)
+	respond(w, r, http.StatusAccepted, OTPChallenge{ExpiresIn: int(otpTTL.Seconds())})
+}
+
+// VerifyPhone sets the caller's phone number with a code from
+// RequestPhoneVerification.
+func (h *Handlers) VerifyPhone(w http.ResponseWriter, r *http.Request) {
+	if h.smsOff(w, r) {
+		return
+	}
+	userID := r.Context().Value(ctxUserID).(string)
+	var req OTPVerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorCode(w, r, http.StatusBadRequest, api.ErrCodeInvalidRequest, "invalid request body")
+		return
+	}
+	phone, ok := checkPhone(w, r, req.Phone)
+	if !ok || !validCode(w, r, req.Code) {
+		return
+	}
+	if !h.checkOTP(otpVerify, userID, phone, req.Code) {
+		writeErrorCode(w, r, http.StatusBadRequest, api.ErrCodeOTPInvalid, "invalid or expired code")
+		return
+	}
+	user, err := h.store.SetUserPhone(userID, phone)
+	if errors.Is(err, store.ErrPhoneTaken) {
+		writeErrorCode(w, r, http.StatusConflict, api.ErrCodePhoneTaken, err.Error())
+		return
+	}
+	if err != nil {
+		writeUserError(w, r, err)
+		return
+	}
+	PhoneVerified.Publish(eventContext(r), h.events, UserEvent{User: *user})
+	respond(w, r, http.StatusOK, user)
+}
@@ -0,0 +1,221 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/internal/auth"
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+)
+
+// ErrCircuitOpen is what a call to a dependency whose circuit breaker is
+// open fails with, wrapped in a *CircuitOpenError. It fails at once,
+// without leaving the process, and callers degrade as they would after a
+// failed call: the CAPTCHA fails open or closed per CAPTCHA_FAIL_OPEN, a
+// password goes unchecked, mail waits for the circuit to close.
+var ErrCircuitOpen = errors.New("circuit open")
+
+// CircuitOpenError is a call Breaker refused: Dependency failed too many
+// times in a row, and is left alone until Until.
+type CircuitOpenError struct {
+	Dependency string
+	Until      time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s: circuit open until %s", e.Dependency, e.Until.UTC().Format(time.RFC3339))
+}
+
+func (e *CircuitOpenError) Unwrap() error { return ErrCircuitOpen }
+
+// OutboundStatus.State values.
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half_open"
+)
+
+// Breaker is a circuit breaker: after tripAfter consecutive failures it
+// opens, refusing calls for cooldown. Then it is half open: the next call
+// goes through as a probe and the rest are held back for another
+// cooldown. The probe's success closes the circuit, its failure opens it
+// again. A tripAfter of 0 never opens.
+type Breaker struct {
+	name      string
+	tripAfter int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int // consecutive
+	openUntil time.Time
+	probing   bool  // Allow let the half-open probe through
+	opened    int64 // times it opened, again after a failed probe included
+	rejected  int64 // calls refused while open
+}
+
+func NewBreaker(name string, tripAfter int, cooldown time.Duration) *Breaker {
+	return &Breaker{name: name, tripAfter: tripAfter, cooldown: cooldown}
+}
+
+// Allow returns a *CircuitOpenError when a call may not be made at now.
+// When the circuit is half open, the call it allows is the probe.
+func (b *Breaker) Allow(now time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tripAfter == 0 || b.failures < b.tripAfter {
+		return nil
+	}
+	if now.Before(b.openUntil) {
+		b.rejected++
+		return &CircuitOpenError{Dependency: b.name, Until: b.openUntil}
+	}
+	b.openUntil = now.Add(b.cooldown)
+	b.probing = true
+	return nil
+}
+
+// Record notes the outcome of a call Allow let through, and reports
+// whether it opened the circuit.
+func (b *Breaker) Record(ok bool, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	probe := b.probing
+	b.probing = false
+	if ok {
+		b.failures = 0
+		return false
+	}
+	b.failures++
+	if b.tripAfter == 0 || b.failures < b.tripAfter {
+		return false
+	}
+	b.openUntil = now.Add(b.cooldown)
+	// It opens on reaching tripAfter, and again when the probe fails;
+	// calls made before it opened that fail late only push openUntil.
+	if probe || b.failures == b.tripAfter {
+		b.opened++
+		return true
+	}
+	return false
+}
+
+// Status reports the breaker's state at now. While a probe is in flight
+// the circuit is open.
+func (b *Breaker) Status(now time.Time) OutboundStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := OutboundStatus{State: circuitClosed, Failures: b.failures, Opened: b.opened, Rejected: b.rejected}
+	switch {
+	case b.tripAfter == 0 || b.failures < b.tripAfter:
+	case now.Before(b.openUntil):
+		until := b.openUntil.UTC()
+		st.State, st.OpenUntil = circuitOpen, &until
+	default:
+		st.State = circuitHalfOpen
+	}
+	return st
+}
+
+// open reports whether the circuit is open at now.
+func (b *Breaker) open(now time.Time) bool {
+	return b.Status(now).State == circuitOpen
+}
+
+// logTrip logs that the circuit just opened.
+func (b *Breaker) logTrip() {
+	b.mu.Lock()
+	failures := b.failures
+	b.mu.Unlock()
+	log.Printf("WARN outbound %s: %d consecutive failures, refusing calls for %s", b.name, failures, b.cooldown)
+}
+
+// Outbound builds the clients of the server's calls to third parties
+// with OUTBOUND_*'s policy, and keeps their breakers, one per dependency,
+// for /metrics ("outbound") and the verbose /health.
+type Outbound struct {
+	cfg config.OutboundConfig
+
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+}
+
+func NewOutbound(cfg config.OutboundConfig) *Outbound {
+	o := &Outbound{cfg: cfg, breakers: make(map[string]*Breaker)}
+	publishVar("outbound", func() any { return o.Status() })
+	return o
+}
+
+// Breaker returns the breaker of dependency name, for calls that are not
+// HTTP; Client's use theirs on their own.
+func (o *Outbound) Breaker(name string) *Breaker {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	b, ok := o.breakers[name]
+	if !ok {
+		b = NewBreaker(name, o.cfg.TripAfter, o.cfg.Cooldown)
+		o.breakers[name] = b
+	}
+	return b
+}
+
+// Client returns the client for dependency name: requests time out after
+// timeout, up to OUTBOUND_MAX_CONNS connections per host are opened, and
+// name's breaker counts errors, 429 and 5xx responses as failures. Build
+// requests with http.NewRequestWithContext so the trace is propagated; a
+// request the caller cancels is not counted either way.
+func (o *Outbound) Client(name string, timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: breakerTransport{breaker: o.Breaker(name), base: o.transport()}}
+}
+
+// UnguardedClient is Client without a breaker, for callers that keep
+// their own per destination, as webhooks do per subscription.
+func (o *Outbound) UnguardedClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: o.transport()}
+}
+
+func (o *Outbound) transport() http.RoundTripper {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxConnsPerHost = o.cfg.MaxConns
+	t.MaxIdleConnsPerHost = o.cfg.MaxConns
+	return traceTransport{base: t}
+}
+
+// Status returns the state of every dependency's breaker.
+func (o *Outbound) Status() map[string]OutboundStatus {
+	o.mu.Lock()
+	breakers := maps.Clone(o.breakers)
+	o.mu.Unlock()
+	now := auth.Now()
+	status := make(map[string]OutboundStatus, len(breakers))
+	for name, b := range breakers {
+		status[name] = b.Status(now)
+	}
+	return status
+}
+
+// breakerTransport guards base with breaker.
+type breakerTransport struct {
+	breaker *Breaker
+	base    http.RoundTripper
+}
+
+func (t breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.breaker.Allow(auth.Now()); err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	if errors.Is(req.Context().Err(), context.Canceled) {
+		return resp, err
+	}
+	failed := err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	if t.breaker.Record(!failed, auth.Now()) {
+		t.breaker.logTrip()
+	}
+	return resp, err
+}
@@ -0,0 +1,259 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/internal/auth"
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+)
+
+var breakerT0 = time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+// Closed until tripAfter failures in a row, open for the cooldown, then
+// half open: one probe goes through, and its success closes the circuit.
+func TestBreakerCloses(t *testing.T) {
+	b := NewBreaker("captcha", 3, time.Minute)
+	now := breakerT0
+	for i := range 2 {
+		if err := b.Allow(now); err != nil {
+			t.Fatalf("call %d: %v", i+1, err)
+		}
+		if b.Record(false, now) {
+			t.Fatalf("failure %d opened the circuit", i+1)
+		}
+	}
+	if st := b.Status(now); st.State != circuitClosed || st.Failures != 2 {
+		t.Errorf("after 2 failures: %+v", st)
+	}
+	b.Record(true, now)
+	if st := b.Status(now); st.State != circuitClosed || st.Failures != 0 {
+		t.Errorf("a success did not reset the count: %+v", st)
+	}
+
+	for i := range 3 {
+		if opened := b.Record(false, now); opened != (i == 2) {
+			t.Errorf("failure %d: opened %v", i+1, opened)
+		}
+	}
+	st := b.Status(now)
+	if st.State != circuitOpen || st.Opened != 1 || st.OpenUntil == nil || !st.OpenUntil.Equal(now.Add(time.Minute)) {
+		t.Errorf("after 3 failures: %+v", st)
+	}
+	err := b.Allow(now.Add(59 * time.Second))
+	var open *CircuitOpenError
+	if !errors.Is(err, ErrCircuitOpen) || !errors.As(err, &open) || open.Dependency != "captcha" || !open.Until.Equal(now.Add(time.Minute)) {
+		t.Errorf("a call while open: %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	if st := b.Status(now); st.State != circuitHalfOpen {
+		t.Errorf("after the cooldown: %+v", st)
+	}
+	if err := b.Allow(now); err != nil {
+		t.Fatalf("the probe: %v", err)
+	}
+	if err := b.Allow(now); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("a second call during the probe: %v", err)
+	}
+	if st := b.Status(now); st.State != circuitOpen || st.Rejected != 2 {
+		t.Errorf("during the probe: %+v", st)
+	}
+	if b.Record(true, now) {
+		t.Error("the probe's success opened the circuit")
+	}
+	if st := b.Status(now); st.State != circuitClosed || st.Failures != 0 || st.Opened != 1 {
+		t.Errorf("after the probe: %+v", st)
+	}
+	if err := b.Allow(now); err != nil {
+		t.Errorf("closed again: %v", err)
+	}
+}
+
+// A failed probe opens the circuit again, counted and logged like the
+// first time; a late failure of a call made before it opened is not.
+func TestBreakerProbeFails(t *testing.T) {
+	logs := quietLog(t)
+	b := NewBreaker("sms", 2, time.Minute)
+	now := breakerT0
+	b.Record(false, now)
+	if !b.Record(false, now) {
+		t.Fatal("2 failures did not open the circuit")
+	}
+	if b.Record(false, now.Add(time.Second)) {
+		t.Error("a call in flight when it opened opened it again")
+	}
+
+	now = now.Add(time.Minute + time.Second)
+	if err := b.Allow(now); err != nil {
+		t.Fatalf("the probe: %v", err)
+	}
+	if !b.Record(false, now) {
+		t.Fatal("the failed probe did not report the circuit opening again")
+	}
+	b.logTrip()
+	st := b.Status(now)
+	if st.State != circuitOpen || st.Opened != 2 || !st.OpenUntil.Equal(now.Add(time.Minute)) {
+		t.Errorf("after the failed probe: %+v", st)
+	}
+	if err := b.Allow(now.Add(30 * time.Second)); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("within the new cooldown: %v", err)
+	}
+	if !strings.Contains(logs.String(), "WARN outbound sms: 4 consecutive failures, refusing calls for 1m0s") {
+		t.Errorf("the second trip was not logged:\n%s", logs)
+	}
+
+	// The next probe can still close it.
+	now = now.Add(time.Minute)
+	if err := b.Allow(now); err != nil {
+		t.Fatalf("the second probe: %v", err)
+	}
+	b.Record(true, now)
+	if st := b.Status(now); st.State != circuitClosed || st.Opened != 2 {
+		t.Errorf("after the second probe: %+v", st)
+	}
+}
+
+// setNow makes auth.Now a manual clock at t0 for the test.
+func setNow(t *testing.T, t0 time.Time) func(time.Duration) {
+	var mu sync.Mutex
+	now := t0
+	prev := auth.Now
+	auth.Now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	t.Cleanup(func() { auth.Now = prev })
+	return func(d time.Duration) {
+		mu.Lock()
+		now = now.Add(d)
+		mu.Unlock()
+	}
+}
+
+// A dependency that hangs costs each call its timeout, and after
+// tripAfter of those, nothing: calls fail at once without reaching it.
+func TestOutboundHangingDependency(t *testing.T) {
+	logs := quietLog(t)
+	var hits atomic.Int32
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		<-r.Context().Done()
+	}))
+	defer hanging.Close()
+	o := NewOutbound(config.OutboundConfig{MaxConns: 4, TripAfter: 2, Cooldown: time.Minute})
+	client := o.Client("captcha", 100*time.Millisecond)
+
+	for i := range 2 {
+		start := time.Now()
+		_, err := client.Get(hanging.URL)
+		var ne net.Error
+		if took := time.Since(start); !errors.As(err, &ne) || !ne.Timeout() || took < 100*time.Millisecond || took > 2*time.Second {
+			t.Fatalf("call %d: %v after %s, want a timeout after 100ms", i+1, err, took)
+		}
+	}
+	start := time.Now()
+	_, err := client.Get(hanging.URL)
+	if !errors.Is(err, ErrCircuitOpen) || time.Since(start) > 50*time.Millisecond {
+		t.Errorf("with the circuit open: %v after %s", err, time.Since(start))
+	}
+	if hits.Load() != 2 {
+		t.Errorf("the dependency got %d calls, want 2", hits.Load())
+	}
+	if st := o.Status()["captcha"]; st.State != circuitOpen || st.Opened != 1 || st.Rejected != 1 {
+		t.Errorf("status %+v", st)
+	}
+	if !strings.Contains(logs.String(), "WARN outbound captcha: 2 consecutive failures") {
+		t.Errorf("the trip was not logged:\n%s", logs)
+	}
+
+	// A call the caller gives up on says nothing about the dependency.
+	o = NewOutbound(config.OutboundConfig{MaxConns: 4, TripAfter: 1, Cooldown: time.Minute})
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", hanging.URL, nil)
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := o.Client("captcha", time.Second).Do(req); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled: %v", err)
+	}
+	if st := o.Status()["captcha"]; st.State != circuitClosed || st.Failures != 0 {
+		t.Errorf("after a canceled call: %+v", st)
+	}
+}
+
+// 5xx and 429 answers count as failures; once the cooldown is over a
+// probe finds the dependency back and closes the circuit, or finds it
+// still failing and opens it again.
+func TestOutboundErroringDependency(t *testing.T) {
+	quietLog(t)
+	advance := setNow(t, breakerT0)
+	var status atomic.Int32
+	status.Store(http.StatusInternalServerError)
+	var hits atomic.Int32
+	erroring := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer erroring.Close()
+	o := NewOutbound(config.OutboundConfig{MaxConns: 4, TripAfter: 3, Cooldown: time.Minute})
+	client := o.Client("breach check", time.Second)
+	get := func() (int, error) {
+		t.Helper()
+		resp, err := client.Get(erroring.URL)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	for _, code := range []int32{500, 429, 503} {
+		status.Store(code)
+		if got, err := get(); err != nil || got != int(code) {
+			t.Fatalf("a %d answer: %d, %v", code, got, err)
+		}
+	}
+	if _, err := get(); !errors.Is(err, ErrCircuitOpen) || hits.Load() != 3 {
+		t.Fatalf("after 3 failures: %v, %d calls made", err, hits.Load())
+	}
+	st := o.Status()["breach check"]
+	if st.State != circuitOpen || !st.OpenUntil.Equal(breakerT0.Add(time.Minute)) {
+		t.Errorf("open: %+v", st)
+	}
+
+	// Still failing: the probe opens it again.
+	advance(time.Minute)
+	if o.Status()["breach check"].State != circuitHalfOpen {
+		t.Errorf("after the cooldown: %+v", o.Status()["breach check"])
+	}
+	if got, err := get(); err != nil || got != 503 {
+		t.Fatalf("the first probe: %d, %v", got, err)
+	}
+	if st := o.Status()["breach check"]; st.State != circuitOpen || st.Opened != 2 {
+		t.Errorf("after the failed probe: %+v", st)
+	}
+	if _, err := get(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("after the failed probe: %v", err)
+	}
+
+	// Back: the probe closes it.
+	advance(time.Minute)
+	status.Store(http.StatusOK)
+	if got, err := get(); err != nil || got != 200 {
+		t.Fatalf("the second probe: %d, %v", got, err)
+	}
+	if got, err := get(); err != nil || got != 200 {
+		t.Errorf("closed: %d, %v", got, err)
+	}
+	if st := o.Status()["breach check"]; st.State != circuitClosed || st.Failures != 0 || st.Opened != 2 || st.Rejected != 2 {
+		t.Errorf("closed: %+v", st)
+	}
+}
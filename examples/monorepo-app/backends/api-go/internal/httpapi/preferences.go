@@ -0,0 +1,90 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+)
+
+var themes = []string{api.ThemeSystem, api.ThemeLight, api.ThemeDark}
+
+// checkPreferences validates the fields present in req, replacing the
+// locale with its canonical tag (pt-br becomes pt-BR).
+func checkPreferences(req *api.UpdatePreferencesRequest) []FieldError {
+	var fields []FieldError
+	if req.Theme != nil && *req.Theme != "" && !slices.Contains(themes, *req.Theme) {
+		fields = append(fields, FieldError{Field: "theme", Message: "must be one of " + strings.Join(themes, ", ")})
+	}
+	if req.Locale != nil && *req.Locale != "" {
+		if tag, ok := messages.Locale(*req.Locale); ok {
+			*req.Locale = tag
+		} else {
+			fields = append(fields, FieldError{Field: "locale", Message: "must be one of " + strings.Join(messages.Locales(), ", ")})
+		}
+	}
+	if req.Timezone != nil && *req.Timezone != "" {
+		// LoadLocation also takes "Local", the server's own zone.
+		if _, err := time.LoadLocation(*req.Timezone); err != nil || *req.Timezone == "Local" {
+			fields = append(fields, FieldError{Field: "timezone", Message: "must be an IANA time zone name, e.g. America/Sao_Paulo"})
+		}
+	}
+	if n := req.ItemsPerPage; n != nil && *n != 0 && (*n < api.MinItemsPerPage || *n > api.MaxItemsPerPage) {
+		fields = append(fields, FieldError{Field: "items_per_page", Message: fmt.Sprintf("must be between %d and %d", api.MinItemsPerPage, api.MaxItemsPerPage)})
+	}
+	return fields
+}
+
+// GetPreferences returns the caller's preferences, the unset ones with
+// their defaults.
+func (h *Handlers) GetPreferences(w http.ResponseWriter, r *http.Request) {
+	user, err := h.store.GetUserByID(r.Context().Value(ctxUserID).(string))
+	if err != nil {
+		writeUserError(w, r, err)
+		return
+	}
+	respond(w, r, http.StatusOK, user.Preferences.WithDefaults())
+}
+
+// UpdatePreferences changes the caller's preferences present in the body
+// and publishes PreferencesChanged if any of them did change.
+func (h *Handlers) UpdatePreferences(w http.ResponseWriter, r *http.Request) {
+	var req api.UpdatePreferencesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorCode(w, r, http.StatusBadRequest, api.ErrCodeInvalidRequest, "invalid request body")
+		return
+	}
+	if fields := checkPreferences(&req); len(fields) > 0 {
+		writeErrorFields(w, r, http.StatusBadRequest, api.ErrCodeValidationFailed, "invalid preferences", fields)
+		return
+	}
+	var old Preferences
+	user, err := h.store.UpdateUser(r.Context().Value(ctxUserID).(string), func(u *User) {
+		old = u.Preferences
+		p := &u.Preferences
+		if req.Theme != nil {
+			p.Theme = *req.Theme
+		}
+		if req.Locale != nil {
+			p.Locale = *req.Locale
+		}
+		if req.Timezone != nil {
+			p.Timezone = *req.Timezone
+		}
+		if req.ItemsPerPage != nil {
+			p.ItemsPerPage = *req.ItemsPerPage
+		}
+	})
+	if err != nil {
+		writeUserError(w, r, err)
+		return
+	}
+	if user.Preferences != old {
+		PreferencesChanged.Publish(eventContext(r), h.events, PreferencesEvent{UserID: user.ID, Old: old, New: user.Preferences})
+	}
+	respond(w, r, http.StatusOK, user.Preferences.WithDefaults())
+}
@@ -0,0 +1,102 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+)
+
+// proxySignature is the X-Proxy-Signature value for a request proxied for
+// userID at timestamp (Unix seconds): "t=<timestamp>,sha256=" +
+// hex(HMAC-SHA256(PROXY_SECRET, "<timestamp>.<user ID>.<METHOD> <path>")),
+// path with its query as the upstream receives it. Like webhookSignature,
+// the timestamp is signed so upstreams can refuse stale requests.
+func proxySignature(secret string, timestamp int64, userID, method, path string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.%s.%s %s", timestamp, userID, method, path)
+	return fmt.Sprintf("t=%d,sha256=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// Proxy forwards the requests below a PROXY_ROUTES prefix to its upstream.
+// It runs behind Auth and sends the caller's user ID in X-User-ID, signed
+// in X-Proxy-Signature; clients can't set either. The caller's credentials
+// (the access token, cookies, the CSRF token, signatures, the rate limit
+// bypass: redactedHeaders) are not forwarded. Upstream errors and timeouts answer 502 in our error
+// shape; the upstream's own responses pass through as they are.
+type Proxy struct {
+	route     config.ProxyRoute
+	secret    string
+	transport *http.Transport
+	proxy     *httputil.ReverseProxy
+}
+
+func NewProxy(route config.ProxyRoute, secret string) (*Proxy, error) {
+	upstream, err := url.Parse(route.Upstream)
+	if err != nil {
+		return nil, fmt.Errorf("proxy %s: %w", route.Prefix, err)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = route.Timeout
+	transport.MaxIdleConns, transport.MaxIdleConnsPerHost = route.MaxIdle, route.MaxIdle
+	transport.DisableKeepAlives = route.MaxIdle == 0
+	p := &Proxy{route: route, secret: secret, transport: transport}
+	p.proxy = &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			if route.Strip {
+				pr.Out.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(pr.Out.URL.Path, route.Prefix), "/")
+				pr.Out.URL.RawPath = ""
+			}
+			pr.SetURL(upstream)
+			pr.SetXForwarded()
+			userID, _ := pr.In.Context().Value(ctxUserID).(string)
+			for _, name := range redactedHeaders {
+				pr.Out.Header.Del(name)
+			}
+			pr.Out.Header.Set("X-User-ID", userID)
+			pr.Out.Header.Set("X-Proxy-Signature", proxySignature(secret, time.Now().Unix(), userID, pr.Out.Method, pr.Out.URL.RequestURI()))
+			for name, value := range route.Headers {
+				pr.Out.Header.Set(name, value)
+			}
+		},
+		Transport:    transport,
+		ErrorHandler: p.upstreamError,
+	}
+	return p, nil
+}
+
+// Pattern is the mux pattern for the route: every method and every path
+// below the prefix.
+func (p *Proxy) Pattern() string { return p.route.Prefix + "/" }
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) { p.proxy.ServeHTTP(w, r) }
+
+// upstreamError answers a request the upstream did not: it was down, too
+// slow or dropped the connection.
+func (p *Proxy) upstreamError(w http.ResponseWriter, r *http.Request, err error) {
+	if r.Context().Err() != nil {
+		return // the client is gone
+	}
+	message := "upstream unavailable"
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		message = "upstream timed out"
+	}
+	log.Printf("WARN proxy %s -> %s: %v", p.route.Prefix, p.route.Upstream, err)
+	writeErrorCode(w, r, http.StatusBadGateway, api.ErrCodeUpstreamUnavailable, message)
+}
+
+// Close drops the idle upstream connections.
+func (p *Proxy) Close() { p.transport.CloseIdleConnections() }
@@ -0,0 +1,59 @@
+package httpapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+	"github.com/your-org/your-app/backends/api-go/raijintest"
+)
+
+func TestProxyStripsCredentials(t *testing.T) {
+	got := make(chan http.Header, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Clone()
+	}))
+	defer upstream.Close()
+	srv := raijintest.NewServer(t, raijintest.WithConfig(func(c *config.Config) {
+		c.Proxy.Secret = "raijintest-proxy-secret-0123456789abcdef"
+		c.Proxy.Routes = []config.ProxyRoute{{Prefix: "/api/v1/reports", Upstream: upstream.URL, Timeout: 5 * time.Second, MaxIdle: 1}}
+	}))
+	user := srv.CreateUser(t, "someone@example.com", raijintest.Password, "user")
+
+	req, _ := http.NewRequest("POST", srv.URL+"/api/v1/reports/monthly", nil)
+	for name, value := range map[string]string{
+		"Cookie":               "session=abc",
+		"Proxy-Authorization":  "Basic Zm9vOmJhcg==",
+		"X-RateLimit-Bypass":   "bypass-secret",
+		"X-Internal-Signature": "forged",
+		"X-Proxy-Signature":    "forged",
+		"X-User-ID":            "someone-else",
+		"X-Report":             "kept",
+	} {
+		req.Header.Set(name, value)
+	}
+	resp, err := srv.ClientAs(t, user).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	wantStatus(t, resp, http.StatusOK)
+
+	h := <-got
+	for _, name := range []string{"Authorization", "Cookie", "Proxy-Authorization", "X-CSRF-Token", "X-RateLimit-Bypass", "X-Internal-Signature"} {
+		if v := h.Get(name); v != "" {
+			t.Errorf("upstream got %s: %q", name, v)
+		}
+	}
+	if h.Get("X-User-ID") != user.ID {
+		t.Errorf("X-User-ID = %q, want %q", h.Get("X-User-ID"), user.ID)
+	}
+	if sig := h.Get("X-Proxy-Signature"); sig == "forged" || sig == "" {
+		t.Errorf("X-Proxy-Signature = %q, want ours", sig)
+	}
+	if h.Get("X-Report") != "kept" {
+		t.Errorf("X-Report = %q, want other headers passed through", h.Get("X-Report"))
+	}
+}
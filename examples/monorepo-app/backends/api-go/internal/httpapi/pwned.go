@@ -0,0 +1,174 @@
+package httpapi
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+)
+
+// pwnedCacheTTL is how long a range response is reused; the corpus
+// changes a few times a year.
+const pwnedCacheTTL = 24 * time.Hour
+
+// PwnedPasswords checks passwords against a Pwned Passwords range API
+// with its k-anonymity protocol: only the first 5 hex characters of the
+// password's SHA-1 leave the process, and the suffixes the API returns
+// for that prefix are matched locally. Range responses are cached.
+type PwnedPasswords struct {
+	url    string
+	client *http.Client
+	cache  *rangeCache
+}
+
+// NewPwnedPasswords returns the BREACH_CHECK checker, or nil when it is
+// off.
+func NewPwnedPasswords(cfg *config.Config, out *Outbound) *PwnedPasswords {
+	if !cfg.Pwned.Enabled() {
+		return nil
+	}
+	return &PwnedPasswords{
+		url: strings.TrimSuffix(cfg.Pwned.URL, "/") + "/", client: out.Client("breach_check", cfg.Pwned.Timeout),
+		cache: newRangeCache(cfg.Pwned.CacheSize),
+	}
+}
+
+// Count returns how many times password appears in the breach corpus.
+func (p *PwnedPasswords) Count(ctx context.Context, password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+	body, ok := p.cache.get(prefix)
+	if !ok {
+		var err error
+		if body, err = p.fetch(ctx, prefix); err != nil {
+			return 0, err
+		}
+		p.cache.put(prefix, body)
+	}
+	// Lines are "SUFFIX:COUNT"; padding lines have a count of 0.
+	for line := range strings.Lines(body) {
+		s, n, _ := strings.Cut(strings.TrimSpace(line), ":")
+		if s == suffix {
+			count, _ := strconv.Atoi(n)
+			return count, nil
+		}
+	}
+	return 0, nil
+}
+
+func (p *PwnedPasswords) fetch(ctx context.Context, prefix string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url+prefix, nil)
+	if err != nil {
+		return "", err
+	}
+	// Padding hides the prefix's true number of suffixes from observers
+	// of the response size.
+	req.Header.Set("Add-Padding", "true")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("pwned passwords: range API answered %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("pwned passwords: %w", err)
+	}
+	return strings.ToUpper(string(data)), nil
+}
+
+// rangeCache is an LRU cache of range responses by prefix, each kept for
+// pwnedCacheTTL. A size of 0 caches nothing.
+type rangeCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // of *rangeEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type rangeEntry struct {
+	prefix    string
+	body      string
+	fetchedAt time.Time
+}
+
+func newRangeCache(size int) *rangeCache {
+	return &rangeCache{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (c *rangeCache) get(prefix string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[prefix]
+	if !ok {
+		return "", false
+	}
+	e := el.Value.(*rangeEntry)
+	if time.Since(e.fetchedAt) > pwnedCacheTTL {
+		c.order.Remove(el)
+		delete(c.entries, prefix)
+		return "", false
+	}
+	c.order.MoveToFront(el)
+	return e.body, true
+}
+
+func (c *rangeCache) put(prefix, body string) {
+	if c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[prefix]; ok {
+		el.Value = &rangeEntry{prefix: prefix, body: body, fetchedAt: time.Now()}
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[prefix] = c.order.PushFront(&rangeEntry{prefix: prefix, body: body, fetchedAt: time.Now()})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*rangeEntry).prefix)
+	}
+}
+
+// pwnedWarning is the password_warning of BREACH_CHECK=warn.
+const pwnedWarning = "this password appears in known data breaches; consider changing it"
+
+// checkPwned applies BREACH_CHECK to password, which must already have
+// passed the local rules. With block a breached password is answered with
+// 400 and false; with warn the warning for the response is returned. When
+// the API cannot be reached the password is let through. Neither the
+// password nor its hash is ever logged.
+func (h *Handlers) checkPwned(w http.ResponseWriter, r *http.Request, password string) (warning string, ok bool) {
+	if h.pwned == nil {
+		return "", true
+	}
+	count, err := h.pwned.Count(r.Context(), password)
+	switch {
+	case err != nil:
+		log.Printf("WARN pwned passwords: %v; password not checked (request_id=%s)", err, r.Header.Get("X-Request-ID"))
+		return "", true
+	case count == 0:
+		return "", true
+	case h.cfg.Pwned.Policy == "warn":
+		return pwnedWarning, true
+	}
+	writeErrorFields(w, r, http.StatusBadRequest, api.ErrCodeValidationFailed, "password appears in known data breaches",
+		[]FieldError{{Field: "password", Message: "appears in known data breaches; choose another"}})
+	return "", false
+}
@@ -0,0 +1,193 @@
+package httpapi
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+)
+
+const breachedPassword = "correct-horse-battery"
+
+// rangeServer is a stub Pwned Passwords range API that knows
+// breachedPassword, seen 42 times, and records the prefixes asked for.
+type rangeServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	prefixes []string
+}
+
+func newRangeServer(t *testing.T) *rangeServer {
+	sum := sha1.Sum([]byte(breachedPassword))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	rs := &rangeServer{}
+	rs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefix := strings.TrimPrefix(r.URL.Path, "/range/")
+		rs.mu.Lock()
+		rs.prefixes = append(rs.prefixes, prefix)
+		rs.mu.Unlock()
+		if r.Header.Get("Add-Padding") != "true" {
+			t.Errorf("no Add-Padding for %s", prefix)
+		}
+		fmt.Fprintln(w, "0018A45C4D1DEF81644B54AB7F969B88D65:3")
+		if prefix == hash[:5] {
+			// Lowercase, as some mirrors answer.
+			fmt.Fprintf(w, "%s:42\r\n", strings.ToLower(hash[5:]))
+		}
+		fmt.Fprintln(w, "FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF:0") // padding
+	}))
+	t.Cleanup(rs.Close)
+	return rs
+}
+
+func (rs *rangeServer) requests() []string {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return append([]string(nil), rs.prefixes...)
+}
+
+func pwnedConfig(url, policy string) *config.Config {
+	cfg := config.Defaults()
+	cfg.Pwned = config.PwnedConfig{Policy: policy, URL: url + "/range", Timeout: time.Second, CacheSize: 2}
+	return cfg
+}
+
+func TestPwnedCount(t *testing.T) {
+	rs := newRangeServer(t)
+	cfg := pwnedConfig(rs.URL, "block")
+	p := NewPwnedPasswords(cfg, NewOutbound(cfg.Outbound))
+	ctx := t.Context()
+
+	for _, tt := range []struct {
+		password string
+		want     int
+	}{
+		{breachedPassword, 42},
+		{"an-unlisted-password", 0},
+		{breachedPassword, 42}, // from the cache
+	} {
+		if n, err := p.Count(ctx, tt.password); err != nil || n != tt.want {
+			t.Errorf("%s: %d, %v; want %d", tt.password, n, err, tt.want)
+		}
+	}
+	reqs := rs.requests()
+	if len(reqs) != 2 {
+		t.Fatalf("range requests %v, want two", reqs)
+	}
+	for _, prefix := range reqs {
+		if len(prefix) != 5 {
+			t.Errorf("sent %q: only 5 hex characters of the hash may leave", prefix)
+		}
+	}
+
+	cfg.Pwned.CacheSize = 0
+	p = NewPwnedPasswords(cfg, NewOutbound(cfg.Outbound))
+	p.Count(ctx, breachedPassword)
+	p.Count(ctx, breachedPassword)
+	if n := len(rs.requests()); n != 4 {
+		t.Errorf("without a cache: %d requests, want 4", n)
+	}
+
+	if NewPwnedPasswords(pwnedConfig(rs.URL, "off"), NewOutbound(cfg.Outbound)) != nil {
+		t.Error("a checker with BREACH_CHECK=off")
+	}
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer broken.Close()
+	cfg = pwnedConfig(broken.URL, "block")
+	if _, err := NewPwnedPasswords(cfg, NewOutbound(cfg.Outbound)).Count(ctx, breachedPassword); err == nil {
+		t.Error("a 503 from the range API counted as an answer")
+	}
+}
+
+func TestRangeCacheLRU(t *testing.T) {
+	c := newRangeCache(2)
+	c.put("AAAAA", "a")
+	c.put("BBBBB", "b")
+	c.get("AAAAA") // now the most recently used
+	c.put("CCCCC", "c")
+	for prefix, want := range map[string]bool{"AAAAA": true, "BBBBB": false, "CCCCC": true} {
+		if _, ok := c.get(prefix); ok != want {
+			t.Errorf("%s cached: %v, want %v", prefix, ok, want)
+		}
+	}
+
+	c.entries["AAAAA"].Value.(*rangeEntry).fetchedAt = time.Now().Add(-pwnedCacheTTL - time.Minute)
+	if _, ok := c.get("AAAAA"); ok {
+		t.Error("an entry past pwnedCacheTTL was served")
+	}
+}
+
+// BREACH_CHECK at registration: block refuses a breached password, warn
+// accepts it with password_warning, and an unreachable API lets it
+// through. The local rules are checked first, and the password never
+// reaches the logs.
+func TestBreachCheckRegistration(t *testing.T) {
+	rs := newRangeServer(t)
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	sum := sha1.Sum([]byte(breachedPassword))
+	hash := hex.EncodeToString(sum[:])
+
+	for i, tt := range []struct {
+		name, url, policy string
+		status            int
+		warning           bool
+	}{
+		{"block", rs.URL, "block", http.StatusBadRequest, false},
+		{"warn", rs.URL, "warn", http.StatusCreated, true},
+		{"off", rs.URL, "off", http.StatusCreated, false},
+		{"unreachable", down.URL, "block", http.StatusCreated, false},
+	} {
+		logs := quietLog(t)
+		_, ts := openAPIServer(t, store.NewMemory(), func(cfg *config.Config) { cfg.Pwned = pwnedConfig(tt.url, tt.policy).Pwned })
+		register := func(password string) (*http.Response, map[string]any) {
+			body, _ := json.Marshal(api.RegisterRequest{Email: fmt.Sprintf("pwned%d@example.com", i), Name: "P", Password: password})
+			resp, err := http.Post(ts.URL+"/api/v1/auth/register", "application/json", strings.NewReader(string(body)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			var out map[string]any
+			json.NewDecoder(resp.Body).Decode(&out)
+			return resp, out
+		}
+
+		before := len(rs.requests())
+		if resp, _ := register("short"); resp.StatusCode != http.StatusBadRequest || len(rs.requests()) != before {
+			t.Errorf("%s: a password failing the local rules: %d, range API asked", tt.name, resp.StatusCode)
+		}
+		resp, body := register(breachedPassword)
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: %d, want %d: %v", tt.name, resp.StatusCode, tt.status, body)
+		}
+		if tt.status == http.StatusBadRequest && body["error_code"] != api.ErrCodeValidationFailed {
+			t.Errorf("%s: %v", tt.name, body)
+		}
+		if got, _ := body["password_warning"].(string); (got != "") != tt.warning {
+			t.Errorf("%s: password_warning %q", tt.name, got)
+		}
+		if tt.policy == "off" && len(rs.requests()) != before {
+			t.Errorf("off: the range API was asked")
+		}
+		if tt.name == "unreachable" && !strings.Contains(logs.String(), "password not checked") {
+			t.Errorf("unreachable: not logged:\n%s", logs)
+		}
+		for _, secret := range []string{breachedPassword, hash, strings.ToUpper(hash), strings.ToUpper(hash[5:])} {
+			if strings.Contains(logs.String(), secret) {
+				t.Errorf("%s: the logs hold %q", tt.name, secret)
+			}
+		}
+	}
+}
@@ -0,0 +1,429 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+)
+
+// waitGoroutines waits for the goroutine count to drop to at most n.
+func waitGoroutines(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines still running, want at most %d", runtime.NumGoroutine(), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRateLimiterStopReleasesSweeper(t *testing.T) {
+	before := runtime.NumGoroutine()
+	limiters := make([]*RateLimiter, 100)
+	for i := range limiters {
+		limiters[i] = NewRateLimiter(10, time.Minute, time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n < before+len(limiters) {
+		t.Fatalf("%d goroutines after starting %d limiters from %d", n, len(limiters), before)
+	}
+	for _, rl := range limiters {
+		rl.Stop()
+	}
+	waitGoroutines(t, before)
+	// Stopping twice, or through Close, is harmless.
+	limiters[0].Stop()
+	if err := limiters[0].Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
+
+func TestRateLimiterSweepForgetsIdleKeys(t *testing.T) {
+	rl := NewRateLimiter(10, 20*time.Millisecond, time.Hour)
+	defer rl.Stop()
+	rl.allow("idle")
+	time.Sleep(30 * time.Millisecond)
+	rl.allow("active")
+	rl.sweep()
+	if n := rl.Len(); n != 1 {
+		t.Errorf("%d keys after the sweep, want only the active one", n)
+	}
+}
+
+func TestRateLimiterMaxKeys(t *testing.T) {
+	const max = 50
+	for name, record := range map[string]func(rl *RateLimiter, key string){
+		"sliding window": func(rl *RateLimiter, key string) { rl.allow(key) },
+		"token bucket":   func(rl *RateLimiter, key string) { rl.SetBurst(20); rl.allow(key) },
+		"counted events": func(rl *RateLimiter, key string) { rl.add(key) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			rl := NewRateLimiter(10, time.Minute, time.Hour)
+			defer rl.Stop()
+			rl.SetMaxKeys(max)
+			for i := range 2 * max {
+				record(rl, fmt.Sprintf("key-%d", i))
+				if n := rl.Len(); n > max {
+					t.Fatalf("%d keys tracked after %d inserts, cap %d", n, i+1, max)
+				}
+			}
+			if n := rl.Len(); n != max {
+				t.Errorf("%d keys tracked, want the cap of %d", n, max)
+			}
+			if n := rl.Evicted(); n != max {
+				t.Errorf("%d keys evicted, want %d", n, max)
+			}
+
+			// A tracked key is updated in place, without evicting another.
+			rl.mu.Lock()
+			var tracked string
+			for key := range rl.requests {
+				tracked = key
+				break
+			}
+			rl.mu.Unlock()
+			record(rl, tracked)
+			if n := rl.Evicted(); n != max {
+				t.Errorf("recording a tracked key evicted another: %d evictions", n)
+			}
+		})
+	}
+}
+
+func TestRateLimiterLoweredMaxKeys(t *testing.T) {
+	rl := NewRateLimiter(10, time.Minute, time.Hour)
+	defer rl.Stop()
+	for i := range 100 {
+		rl.allow(fmt.Sprintf("key-%d", i))
+	}
+	rl.SetMaxKeys(10)
+	rl.allow("new")
+	if n := rl.Len(); n != 10 {
+		t.Errorf("%d keys tracked after lowering the cap to 10", n)
+	}
+}
+
+func TestRateLimiterMaxKeysConcurrent(t *testing.T) {
+	const max = 100
+	rl := NewRateLimiter(10, time.Minute, time.Hour)
+	defer rl.Stop()
+	rl.SetMaxKeys(max)
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range max {
+				rl.allow(fmt.Sprintf("key-%d-%d", g, i))
+			}
+		}()
+	}
+	wg.Wait()
+	if n := rl.Len(); n != max {
+		t.Errorf("%d keys tracked after %d inserts, cap %d", n, 8*max, max)
+	}
+}
+
+// A server shutdown that used up the deadline still stops the components:
+// their hooks run, even if the ones that wait are cut short.
+func TestShutdownPastDeadlineStopsLimiters(t *testing.T) {
+	before := runtime.NumGoroutine()
+	var l Lifecycle
+	rl := NewRateLimiter(10, time.Minute, time.Minute)
+	l.OnShutdown("rate limits", stopHook(rl.Stop))
+	l.OnShutdown("mail", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+	if err := l.Shutdown(ctx); err == nil {
+		t.Error("Shutdown past the deadline reported no error for the hook that waits")
+	}
+	select {
+	case <-rl.done:
+	case <-time.After(time.Second):
+		t.Fatal("the limiter was not stopped")
+	}
+	waitGoroutines(t, before)
+}
+
+// fakeClock makes rl's clock one the test moves by hand. The sweeper must
+// not run meanwhile: create rl with a long sweepEvery.
+func fakeClock(rl *RateLimiter) *time.Time {
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	rl.now = func() time.Time { return now }
+	return &now
+}
+
+func TestRateLimiterBurst(t *testing.T) {
+	rl := NewRateLimiter(10, time.Minute, time.Hour)
+	defer rl.Stop()
+	rl.SetBurst(20)
+	now := fakeClock(rl)
+
+	for i := range 20 {
+		if ok, _ := rl.allow("k"); !ok {
+			t.Fatalf("request %d of the burst refused", i+1)
+		}
+	}
+	ok, retry := rl.allow("k")
+	if ok || retry != 6*time.Second {
+		t.Fatalf("past the burst: %v, retry %s; want refused for one interval, 6s", ok, retry)
+	}
+	*now = now.Add(retry - time.Nanosecond)
+	if ok, _ := rl.allow("k"); ok {
+		t.Error("allowed before Retry-After")
+	}
+	*now = now.Add(time.Nanosecond)
+	if ok, _ := rl.allow("k"); !ok {
+		t.Error("refused at Retry-After")
+	}
+	// Idle for a full refill, the whole burst is back.
+	*now = now.Add(2 * time.Minute)
+	for i := range 20 {
+		if ok, _ := rl.allow("k"); !ok {
+			t.Fatalf("after the refill, request %d refused", i+1)
+		}
+	}
+}
+
+// With burst equal to the limit, or unset, the limiter keeps its sliding
+// window: Retry-After is when the oldest request leaves the window.
+func TestRateLimiterBurstAtLimit(t *testing.T) {
+	for _, burst := range []int{0, 10} {
+		rl := NewRateLimiter(10, time.Minute, time.Hour)
+		defer rl.Stop()
+		rl.SetBurst(burst)
+		now := fakeClock(rl)
+		for range 10 {
+			rl.allow("k")
+			*now = now.Add(time.Second)
+		}
+		if ok, retry := rl.allow("k"); ok || retry != 50*time.Second {
+			t.Errorf("burst %d: %v, retry %s; want refused until the first request is a minute old", burst, ok, retry)
+		}
+	}
+}
+
+// However a client spends its burst, the requests let through over any
+// span d are at most burst + d*limit/window; with no burst, at most limit
+// within any window.
+func TestRateLimiterLongRunRate(t *testing.T) {
+	const limit, window = 10, time.Minute
+	interval := window / limit
+	gaps := []time.Duration{0, 0, 0, time.Millisecond, 500 * time.Millisecond, 2 * time.Second, interval, 30 * time.Second, 3 * time.Minute}
+	for _, burst := range []int{0, limit, 2 * limit, 5 * limit} {
+		for seed := range uint64(5) {
+			rl := NewRateLimiter(limit, window, time.Hour)
+			rl.SetBurst(burst)
+			now := fakeClock(rl)
+			start := *now
+			r := rand.New(rand.NewPCG(seed, uint64(burst)))
+			var allowed []time.Time
+			for range 5000 {
+				*now = now.Add(gaps[r.IntN(len(gaps))])
+				if ok, _ := rl.allow("k"); ok {
+					allowed = append(allowed, *now)
+				}
+			}
+			rl.Stop()
+
+			// Over allowed[i..j], j-i+1 <= burst + span/interval; that is,
+			// j - t_j/interval never rises more than burst-1 above its lowest
+			// value before j.
+			lowest, busiest := math.Inf(1), 0
+			for j, at := range allowed {
+				level := float64(j) - float64(at.Sub(start))/float64(interval)
+				lowest = min(lowest, level)
+				if burst > limit && level-lowest+1 > float64(burst)+1e-9 {
+					t.Fatalf("burst %d, seed %d: %.1f requests over the sustained rate by %s", burst, seed, level-lowest+1, at.Sub(start))
+				}
+				i, _ := slices.BinarySearchFunc(allowed, at.Add(-window+1), time.Time.Compare)
+				busiest = max(busiest, j-i+1)
+			}
+			if burst <= limit && busiest > limit {
+				t.Errorf("burst %d, seed %d: %d requests within a window", burst, seed, busiest)
+			}
+			if burst > limit && busiest <= limit {
+				t.Errorf("burst %d, seed %d: the burst was never used", burst, seed)
+			}
+			total := now.Sub(start)
+			if max := max(burst, limit) + int(total/interval) + 1; len(allowed) > max {
+				t.Errorf("burst %d, seed %d: %d requests in %s, sustained rate allows %d", burst, seed, len(allowed), total, max)
+			}
+		}
+	}
+}
+
+// The 429's Retry-After comes from the bucket: a refill interval, not the
+// window.
+func TestRateLimiterBurstRetryAfter(t *testing.T) {
+	rl := NewRateLimiter(2, 10*time.Second, time.Hour)
+	defer rl.Stop()
+	rl.SetBurst(4)
+	fakeClock(rl)
+	h := rl.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i := range 5 {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/auth/login", nil))
+		switch {
+		case i < 4 && rec.Code != http.StatusOK:
+			t.Errorf("request %d: %d", i+1, rec.Code)
+		case i == 4 && (rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "5"):
+			t.Errorf("request 5: %d, Retry-After %q; want 429 after 5s", rec.Code, rec.Header().Get("Retry-After"))
+		}
+	}
+}
+
+const bypassSecret = "uptime-monitor-bypass-0123456789abcdef"
+
+func TestRateLimitExempted(t *testing.T) {
+	rls := NewRateLimiters(nil, nil, time.Hour, 100, NewEventBus())
+	rls.SetExemptions(config.RateLimitExemptConfig{
+		CIDRs: []string{"203.0.113.0/24", "2001:db8::/32"}, TrustedProxies: []string{"10.0.0.0/8"}, BypassSecret: bypassSecret,
+	})
+	for _, tt := range []struct {
+		name, peer, xff, header, want string
+	}{
+		{"bypass header", "198.51.100.1:1234", "", bypassSecret, "header"},
+		{"wrong secret", "198.51.100.1:1234", "", bypassSecret + "x", ""},
+		{"secret prefix", "198.51.100.1:1234", "", bypassSecret[:10], ""},
+		{"exempt peer", "203.0.113.9:1234", "", "", "cidr"},
+		{"exempt IPv6 peer", "[2001:db8::1]:1234", "", "", "cidr"},
+		{"IPv4-mapped peer", "[::ffff:203.0.113.9]:1234", "", "", "cidr"},
+		{"other peer", "198.51.100.1:1234", "", "", ""},
+		{"forged X-Forwarded-For", "198.51.100.1:1234", "203.0.113.9", "", ""},
+		{"X-Forwarded-For from a trusted proxy", "10.1.2.3:1234", "203.0.113.9", "", "cidr"},
+		{"client behind the exempt one", "10.1.2.3:1234", "198.51.100.1, 203.0.113.9", "", "cidr"},
+		{"exempt address forged behind the client", "10.1.2.3:1234", "203.0.113.9, 198.51.100.1", "", ""},
+		{"garbage X-Forwarded-For", "10.1.2.3:1234", "not-an-ip", "", ""},
+		{"unix socket", "@", "", "", ""},
+	} {
+		r := httptest.NewRequest("GET", "/api/v1/things", nil)
+		r.RemoteAddr = tt.peer
+		if tt.xff != "" {
+			r.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		if tt.header != "" {
+			r.Header.Set(bypassHeader, tt.header)
+		}
+		if got := rls.exempted(r); got != tt.want {
+			t.Errorf("%s: %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	rls.SetExemptions(config.RateLimitExemptConfig{})
+	r := httptest.NewRequest("GET", "/api/v1/things", nil)
+	r.Header.Set(bypassHeader, "")
+	if got := rls.exempted(r); got != "" {
+		t.Errorf("no secret configured, empty header: %q", got)
+	}
+}
+
+// Exempt requests skip the api bucket without being counted, but not the
+// auth bucket unless it is flagged exempt. A reload swaps the exemptions.
+func TestRateLimitExemptions(t *testing.T) {
+	withTrustedProxies(t)
+	bucket := func(spec string) config.RateLimitBucket {
+		b, err := config.ParseRateLimitBucket(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	st := store.NewMemory()
+	s, ts := openAPIServer(t, st, func(cfg *config.Config) {
+		cfg.RateLimitBuckets = []config.RateLimitBucket{bucket("auth:2/1m:ip"), bucket("api:2/1m:ip")}
+		cfg.RateLimitExempt = config.RateLimitExemptConfig{BypassSecret: bypassSecret}
+	})
+	admin, err := st.GetUserByEmail("admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := openAPIToken(t, admin)
+	do := func(method, path, bypass, xff string) int {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(`{"email":"admin@example.com","password":"admin123"}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		if bypass != "" {
+			req.Header.Set(bypassHeader, bypass)
+		}
+		if xff != "" {
+			req.Header.Set("X-Forwarded-For", xff)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	statuses := func(n int, method, path, bypass, xff string) []int {
+		var got []int
+		for range n {
+			got = append(got, do(method, path, bypass, xff))
+		}
+		return got
+	}
+	api := s.rateLimits.limiters["api"]
+
+	if got := statuses(5, "GET", "/api/v1/users/me", bypassSecret, ""); !slices.Equal(got, []int{200, 200, 200, 200, 200}) {
+		t.Errorf("api with the bypass header: %v", got)
+	}
+	if got := statuses(3, "GET", "/api/v1/users/me", "", ""); !slices.Equal(got, []int{200, 200, 429}) {
+		t.Errorf("api without it, after the bypassed requests: %v", got)
+	}
+	if got := statuses(3, "POST", "/api/v1/auth/login", bypassSecret, ""); !slices.Equal(got, []int{200, 200, 429}) {
+		t.Errorf("auth with the bypass header: %v", got)
+	}
+	if got := api.Bypassed(); got["header"] != 5 || got["cidr"] != 0 {
+		t.Errorf("api bypassed %v", got)
+	}
+	if got := s.rateLimits.limiters["auth"].Bypassed(); got["header"] != 0 {
+		t.Errorf("auth bypassed %v", got)
+	}
+
+	// SIGHUP: a new secret, an exempt network behind a trusted proxy, and
+	// the auth bucket flagged exempt.
+	next := *s.cfg
+	next.RateLimitBuckets = []config.RateLimitBucket{bucket("auth:2/1m exempt:ip"), bucket("api:2/1m:ip")}
+	next.RateLimitExempt = config.RateLimitExemptConfig{
+		CIDRs: []string{"203.0.113.0/24"}, TrustedProxies: []string{"127.0.0.0/8"}, BypassSecret: bypassSecret + "-rotated",
+	}
+	s.Reload(&next)
+	for _, tt := range []struct {
+		name, method, path, bypass, xff string
+		want                            int
+	}{
+		{"old secret", "GET", "/api/v1/users/me", bypassSecret, "", 429},
+		{"new secret", "GET", "/api/v1/users/me", bypassSecret + "-rotated", "", 200},
+		{"exempt network", "GET", "/api/v1/users/me", "", "203.0.113.9", 200},
+		{"other network", "GET", "/api/v1/users/me", "", "198.51.100.9", 200},
+		{"other network again", "GET", "/api/v1/users/me", "", "198.51.100.9", 200},
+		{"other network over the limit", "GET", "/api/v1/users/me", "", "198.51.100.9", 429},
+		{"auth, now exempt", "POST", "/api/v1/auth/login", bypassSecret + "-rotated", "", 200},
+	} {
+		if got := do(tt.method, tt.path, tt.bypass, tt.xff); got != tt.want {
+			t.Errorf("after the reload, %s: %d, want %d", tt.name, got, tt.want)
+		}
+	}
+	if got := api.Bypassed(); got["header"] != 6 || got["cidr"] != 1 {
+		t.Errorf("api bypassed after the reload %v", got)
+	}
+}
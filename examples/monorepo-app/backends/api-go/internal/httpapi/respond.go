@@ -0,0 +1,293 @@
+package httpapi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+)
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) error {
+	return writeEncoded(w, status, "application/json; charset=utf-8", data)
+}
+
+// encodeBuffers recycles writeEncoded's buffers.
+var encodeBuffers = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// writeEncoded writes data as JSON with an explicit Content-Length, so a
+// HEAD (which the mux routes to the GET handler and net/http answers
+// without the body) gets the same headers as the GET, whatever the size.
+// data is encoded before anything is sent: if that fails the answer is a
+// 500 internal_error instead of status with a truncated body. Encoding
+// and write errors are returned, and noted for the access log.
+func writeEncoded(w http.ResponseWriter, status int, contentType string, data interface{}) error {
+	buf := encodeBuffers.Get().(*bytes.Buffer)
+	defer func() {
+		if buf.Cap() <= 64<<10 {
+			buf.Reset()
+			encodeBuffers.Put(buf)
+		}
+	}()
+	if err := json.NewEncoder(buf).Encode(data); err != nil {
+		err = fmt.Errorf("encoding %T: %w", data, err)
+		noteWriteError(w, err)
+		buf.Reset()
+		status, contentType = http.StatusInternalServerError, "application/json; charset=utf-8"
+		_ = json.NewEncoder(buf).Encode(APIError{
+			Error: http.StatusText(status), ErrorCode: api.ErrCodeInternal, Message: "failed to encode response", Code: status,
+		})
+		_ = writeBody(w, status, contentType, buf.Bytes())
+		return err
+	}
+	if err := writeBody(w, status, contentType, buf.Bytes()); err != nil {
+		noteWriteError(w, err)
+		return err
+	}
+	return nil
+}
+
+func writeBody(w http.ResponseWriter, status int, contentType string, body []byte) error {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	_, err := w.Write(body)
+	return err
+}
+
+// writeJSONCached writes a 200 JSON response with a strong ETag over the
+// serialized (uncompressed) body, answering 304 when If-None-Match matches.
+// Any compression layer added later must weaken the ETag (W/) it forwards.
+// Only use it for successful responses; errors go through writeErrorCode.
+func writeJSONCached(w http.ResponseWriter, r *http.Request, data interface{}) {
+	data, ok := versionBody(w, r, data)
+	if !ok {
+		return
+	}
+	writeJSONWithETag(w, r, data)
+}
+
+// writeJSONProjected is writeJSONCached for resources: ?fields=id,email
+// narrows the body to those fields (see projectFields), and the ETag is
+// that of the narrowed body.
+func writeJSONProjected(w http.ResponseWriter, r *http.Request, data interface{}) {
+	data, ok := versionBody(w, r, data)
+	if !ok {
+		return
+	}
+	if q := r.URL.Query(); q.Has("fields") {
+		var fields []FieldError
+		if data, fields = projectFields(data, strings.Split(q.Get("fields"), ",")); len(fields) > 0 {
+			writeErrorFields(w, r, http.StatusBadRequest, api.ErrCodeValidationFailed, "invalid query parameters", fields)
+			return
+		}
+	}
+	writeJSONWithETag(w, r, data)
+}
+
+func writeJSONWithETag(w http.ResponseWriter, r *http.Request, data interface{}) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		writeErrorCode(w, r, http.StatusInternalServerError, api.ErrCodeInternal, "failed to encode response")
+		return
+	}
+	body = append(body, '\n')
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	dropTokenExpiry(w.Header())
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+// etagMatches applies the weak comparison RFC 9110 prescribes for
+// If-None-Match against a comma-separated list (or "*").
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// projectFields narrows data, a struct or pointer to one, to the named
+// JSON fields. A list envelope (a slice field tagged fields:"items")
+// narrows each item instead and keeps its other fields. Names come from
+// the json tags of the item type, so fields tagged json:"-" can't be
+// selected. Unknown names are reported as fields errors.
+func projectFields(data any, names []string) (any, []FieldError) {
+	t := reflect.TypeOf(data)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return data, nil
+	}
+	items, item := "", t
+	for _, f := range reflect.VisibleFields(t) {
+		if name, _, ok := jsonFieldName(f); ok && f.Tag.Get("fields") == "items" && f.Type.Kind() == reflect.Slice {
+			items, item = name, f.Type.Elem()
+			for item.Kind() == reflect.Pointer {
+				item = item.Elem()
+			}
+			break
+		}
+	}
+	known := map[string]bool{}
+	var order []string
+	if item.Kind() == reflect.Struct {
+		for _, f := range reflect.VisibleFields(item) {
+			if name, _, ok := jsonFieldName(f); ok {
+				known[name] = true
+				order = append(order, name)
+			}
+		}
+	}
+	keep := map[string]bool{}
+	var unknown []FieldError
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		switch {
+		case name == "":
+		case !known[name]:
+			unknown = append(unknown, FieldError{Field: "fields", Message: fmt.Sprintf("unknown field %q", name)})
+		default:
+			keep[name] = true
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, unknown
+	}
+	if len(keep) == 0 {
+		return data, nil // ?fields= (empty): everything
+	}
+	var fields []string
+	for _, name := range order {
+		if keep[name] {
+			fields = append(fields, name)
+		}
+	}
+	return projection{v: data, fields: fields, items: items}, nil
+}
+
+// projection marshals v with only fields (in struct order), applied to
+// the items field of v when set. Omitted (omitempty) fields stay omitted.
+type projection struct {
+	v      any
+	fields []string
+	items  string
+}
+
+func (p projection) MarshalJSON() ([]byte, error) {
+	if p.items == "" {
+		return marshalPicked(p.v, p.fields)
+	}
+	var env map[string]json.RawMessage
+	var keys []string
+	b, err := json.Marshal(p.v)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &env); err != nil {
+		return nil, err
+	}
+	for _, f := range reflect.VisibleFields(reflect.Indirect(reflect.ValueOf(p.v)).Type()) {
+		if name, _, ok := jsonFieldName(f); ok {
+			keys = append(keys, name)
+		}
+	}
+	var list []json.RawMessage
+	if err := json.Unmarshal(env[p.items], &list); err != nil {
+		return nil, err
+	}
+	for i, raw := range list {
+		if list[i], err = marshalPicked(raw, p.fields); err != nil {
+			return nil, err
+		}
+	}
+	if list != nil {
+		if env[p.items], err = json.Marshal(list); err != nil {
+			return nil, err
+		}
+	}
+	return marshalObject(env, keys), nil
+}
+
+// marshalPicked encodes v as a JSON object holding only fields.
+func marshalPicked(v any, fields []string) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil || string(b) == "null" {
+		return b, err
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return nil, err
+	}
+	return marshalObject(obj, fields), nil
+}
+
+// marshalObject encodes the keys of obj that are present, in keys order.
+func marshalObject(obj map[string]json.RawMessage, keys []string) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for _, k := range keys {
+		raw, ok := obj[k]
+		if !ok {
+			continue
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(k)
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(raw)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes()
+}
+
+func writeErrorCode(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	writeErrorFields(w, r, status, code, message, nil)
+}
+
+// writeErrorFields writes an error in the format negotiated for r (see
+// Middleware.ErrorFormat): APIError by default, RFC 7807 problem+json when
+// configured, requested via Accept or required by r's API version.
+func writeErrorFields(w http.ResponseWriter, r *http.Request, status int, code, message string, fields []FieldError) {
+	lang := messages.Match(r.Header.Get("Accept-Language"))
+	message = messages.Message(lang, code, message)
+	w.Header().Set("Content-Language", lang)
+	w.Header().Add("Vary", "Accept-Language")
+
+	ef, _ := r.Context().Value(ctxErrorFormat).(errorFormat)
+	if !ef.problem && !apiVersionOf(r).Problem {
+		writeJSON(w, status, APIError{Error: http.StatusText(status), ErrorCode: code, Message: message, Code: status, Fields: fields})
+		return
+	}
+	typ := "about:blank"
+	if ef.typeBase != "" {
+		typ = ef.typeBase + code
+	}
+	writeEncoded(w, status, "application/problem+json", ProblemDetails{
+		Type: typ, Title: http.StatusText(status), Status: status, Detail: message,
+		Instance: r.Header.Get("X-Request-ID"), Code: code, Errors: fields,
+	})
+}
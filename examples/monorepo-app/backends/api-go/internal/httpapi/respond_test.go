@@ -0,0 +1,206 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+)
+
+func TestETagMatches(t *testing.T) {
+	const etag = `"abc"`
+	for _, tt := range []struct {
+		header string
+		want   bool
+	}{
+		{`"abc"`, true},
+		{`"abd"`, false},
+		{``, false},
+		{`abc`, false},
+		{`W/"abc"`, true},
+		{`"x", "abc"`, true},
+		{`"x","abc" , "y"`, true},
+		{`"x", "y"`, false},
+		{`*`, true},
+		{`"ab", "c"`, false},
+	} {
+		if got := etagMatches(tt.header, etag); got != tt.want {
+			t.Errorf("If-None-Match %s: %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestConditionalGet(t *testing.T) {
+	st := store.NewMemory()
+	_, ts := openAPIServer(t, st)
+	user, err := st.CreateUser("etag@example.com", "ETag", "etag-password", "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := openAPIToken(t, user)
+	get := func(path, ifNoneMatch string) (*http.Response, string) {
+		t.Helper()
+		req, _ := http.NewRequest("GET", ts.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	resp, _ := get("/api/v1/users/me", "")
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || len(etag) < 3 || etag[0] != '"' {
+		t.Fatalf("%d, ETag %q", resp.StatusCode, etag)
+	}
+	if cc := resp.Header.Get("Cache-Control"); cc != "private, no-cache" {
+		t.Errorf("Cache-Control %q", cc)
+	}
+
+	for _, tt := range []struct {
+		name, header string
+		want         int
+	}{
+		{"match", etag, http.StatusNotModified},
+		{"weak match", "W/" + etag, http.StatusNotModified},
+		{"mismatch", `"0000"`, http.StatusOK},
+		{"one of several", `"0000", ` + etag + `, "1111"`, http.StatusNotModified},
+		{"none of several", `"0000", "1111"`, http.StatusOK},
+	} {
+		resp, body := get("/api/v1/users/me", tt.header)
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s: %d, want %d", tt.name, resp.StatusCode, tt.want)
+			continue
+		}
+		if resp.Header.Get("ETag") != etag {
+			t.Errorf("%s: ETag %q, want %q", tt.name, resp.Header.Get("ETag"), etag)
+		}
+		if tt.want == http.StatusNotModified && body != "" {
+			t.Errorf("%s: 304 with a body: %q", tt.name, body)
+		}
+	}
+
+	// A changed resource has a new ETag; a narrowed one has its own.
+	if _, err := st.UpdateUser(user.ID, func(u *api.User) { u.Name = "Renamed" }); err != nil {
+		t.Fatal(err)
+	}
+	resp, _ = get("/api/v1/users/me", etag)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") == etag {
+		t.Errorf("after an update: %d, ETag %q", resp.StatusCode, resp.Header.Get("ETag"))
+	}
+	if resp, _ = get("/api/v1/users/me?fields=id", resp.Header.Get("ETag")); resp.StatusCode != http.StatusOK {
+		t.Errorf("?fields=id answered the full body's ETag with %d", resp.StatusCode)
+	}
+
+	// Errors are never cached, whatever If-None-Match says.
+	for _, path := range []string{"/api/v1/users/me?fields=nope", "/api/v1/admin/users"} {
+		resp, _ := get(path, "*")
+		if resp.StatusCode < 400 || resp.Header.Get("ETag") != "" {
+			t.Errorf("%s: %d, ETag %q", path, resp.StatusCode, resp.Header.Get("ETag"))
+		}
+	}
+}
+
+// brokenWriter fails every Write, like a connection the client dropped.
+type brokenWriter struct{ *httptest.ResponseRecorder }
+
+func (brokenWriter) Write([]byte) (int, error) { return 0, errors.New("connection reset") }
+
+func TestWriteJSON(t *testing.T) {
+	// Small and large bodies in turn: a recycled buffer holds nothing of
+	// the previous one.
+	for _, data := range []any{
+		map[string]string{"name": strings.Repeat("x", 100<<10)},
+		map[string]int{"n": 1},
+		map[string]string{"name": strings.Repeat("y", 1000)},
+		map[string]int{"n": 2},
+	} {
+		rec := httptest.NewRecorder()
+		if err := writeJSON(rec, http.StatusCreated, data); err != nil {
+			t.Fatal(err)
+		}
+		want, _ := json.Marshal(data)
+		if rec.Code != http.StatusCreated || rec.Body.String() != string(want)+"\n" {
+			t.Errorf("%d %.40q, want %.40q", rec.Code, rec.Body, want)
+		}
+		if cl := rec.Header().Get("Content-Length"); cl != strconv.Itoa(rec.Body.Len()) {
+			t.Errorf("Content-Length %s for %d bytes", cl, rec.Body.Len())
+		}
+	}
+
+	// A value that cannot be encoded gets a 500, not a truncated 200.
+	rec := httptest.NewRecorder()
+	err := writeJSON(rec, http.StatusOK, map[string]any{"id": "u1", "updates": make(chan int)})
+	var e APIError
+	if err == nil || !strings.Contains(err.Error(), "chan int") {
+		t.Errorf("error %v", err)
+	}
+	if rec.Code != http.StatusInternalServerError || json.Unmarshal(rec.Body.Bytes(), &e) != nil || e.ErrorCode != api.ErrCodeInternal {
+		t.Errorf("%d %s", rec.Code, rec.Body)
+	}
+	if strings.Contains(rec.Body.String(), "u1") || rec.Header().Get("Content-Length") != strconv.Itoa(rec.Body.Len()) {
+		t.Errorf("partial body or wrong length: %q, Content-Length %s", rec.Body, rec.Header().Get("Content-Length"))
+	}
+
+	if err := writeJSON(brokenWriter{httptest.NewRecorder()}, http.StatusOK, map[string]int{"n": 1}); err == nil {
+		t.Error("a failed write was not returned")
+	}
+}
+
+// The request logger records encoding and write failures, with the
+// request ID, in the access log entry and an ERROR line.
+func TestWriteJSONErrorsAreLogged(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		data   any
+		broken bool // the client went away
+		status int
+		want   string
+	}{
+		{"marshal", map[string]any{"c": make(chan int)}, false, http.StatusInternalServerError, "encoding map[string]interface {}: json: unsupported type: chan int"},
+		{"write", map[string]int{"n": 1}, true, http.StatusOK, "connection reset"},
+		{"none", map[string]int{"n": 1}, false, http.StatusOK, ""},
+	} {
+		logs := quietLog(t)
+		var out bytes.Buffer
+		l, err := NewRequestLogger("json", &out, config.LogFilter{}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("GET", "/thing", nil)
+		req.Header.Set("X-Request-ID", "req-188")
+		var w http.ResponseWriter = httptest.NewRecorder()
+		if tt.broken {
+			w = brokenWriter{httptest.NewRecorder()}
+		}
+		l.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, tt.data)
+		})).ServeHTTP(w, req)
+		var entry AccessLogEntry
+		if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+			t.Fatalf("%s: %v: %s", tt.name, err, out.Bytes())
+		}
+		if entry.Status != tt.status || entry.Error != tt.want {
+			t.Errorf("%s: access log status %d, error %q; want %d, %q", tt.name, entry.Status, entry.Error, tt.status, tt.want)
+		}
+		logged := strings.Contains(logs.String(), "ERROR writing response") && strings.Contains(logs.String(), "request_id=req-188")
+		if logged != (tt.want != "") {
+			t.Errorf("%s: ERROR line %v:\n%s", tt.name, logged, logs)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+)
+
+// builtinRoles are always in the catalog: RequireRole("admin") guards the
+// admin API, and new accounts get "user".
+var builtinRoles = []string{"user", "admin"}
+
+// RoleCatalog is the set of values User.Role can take: builtinRoles plus
+// ROLES. Every write of a role is checked against it. Set swaps the
+// catalog on a config reload; users whose role left it keep it, and the
+// admin list flags them (UserFlagUnknownRole).
+type RoleCatalog struct {
+	roles atomic.Pointer[[]string]
+}
+
+func NewRoleCatalog(extra []string) *RoleCatalog {
+	c := &RoleCatalog{}
+	c.Set(extra)
+	return c
+}
+
+// Set makes the catalog builtinRoles plus extra.
+func (c *RoleCatalog) Set(extra []string) {
+	roles := slices.Clone(builtinRoles)
+	for _, r := range extra {
+		if !slices.Contains(roles, r) {
+			roles = append(roles, r)
+		}
+	}
+	c.roles.Store(&roles)
+}
+
+// List returns the roles, built-ins first. The caller must not modify it.
+func (c *RoleCatalog) List() []string { return *c.roles.Load() }
+
+// Known reports whether role is in the catalog.
+func (c *RoleCatalog) Known(role string) bool { return slices.Contains(c.List(), role) }
+
+// knownRole reports whether role is in the catalog, answering r with 400
+// unknown_role when it is not.
+func (h *Handlers) knownRole(w http.ResponseWriter, r *http.Request, role string) bool {
+	if h.roles.Known(role) {
+		return true
+	}
+	writeErrorFields(w, r, http.StatusBadRequest, api.ErrCodeUnknownRole, fmt.Sprintf("unknown role %q", role),
+		[]FieldError{{Field: "role", Message: "must be one of " + strings.Join(h.roles.List(), ", ")}})
+	return false
+}
+
+type RoleList struct {
+	Roles []string `json:"roles"`
+	Total int      `json:"total"`
+}
+
+// ListRoles lists the roles users can be given, for role pickers.
+func (h *Handlers) ListRoles(w http.ResponseWriter, r *http.Request) {
+	roles := h.roles.List()
+	respond(w, r, http.StatusOK, RoleList{Roles: roles, Total: len(roles)})
+}
@@ -0,0 +1,279 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+)
+
+var (
+	routesURL = flag.String("routes-url", "", "sweep the routes of the server at this URL instead of an in-process one")
+	routesOut = flag.String("routes-out", "", "with -routes-url, where to write the transcript")
+)
+
+// TestRouteGolden sends every registered route the same requests, as
+// nobody and as the seeded admin, and compares the responses with
+// testdata/routes.golden. It pins routing, auth, CSRF, validation and
+// error bodies across refactors: a change to the transcript is a change
+// in behavior. Run with -update after an intended one and review the
+// diff.
+//
+// With -routes-url the sweep runs against another build instead, started
+// with the default configuration and RATE_LIMIT_BUCKETS raised, and the
+// transcript goes to -routes-out. Two builds behave the same when their
+// transcripts do:
+//
+//	go test ./internal/httpapi -run TestRouteGolden -routes-url=http://127.0.0.1:8080 -routes-out=/tmp/before.txt
+func TestRouteGolden(t *testing.T) {
+	s, ts := openAPIServer(t, store.NewMemory())
+	patterns := s.router.Patterns()
+	if *routesURL == "" {
+		checkGolden(t, filepath.Join("testdata", "routes.golden"), sweepRoutes(t, ts.URL, patterns))
+		return
+	}
+	if *routesOut == "" {
+		t.Fatal("-routes-url needs -routes-out")
+	}
+	if err := os.WriteFile(*routesOut, []byte(sweepRoutes(t, *routesURL, patterns)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// sweepRoutes returns the transcript of the sweep against base.
+func sweepRoutes(t *testing.T, base string, patterns []string) string {
+	t.Helper()
+	client := &http.Client{
+		Timeout:       10 * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	var login struct {
+		AccessToken string `json:"access_token"`
+		CSRFToken   string `json:"csrf_token"`
+		User        struct {
+			ID string `json:"id"`
+		} `json:"user"`
+	}
+	body := strings.NewReader(`{"email":"admin@example.com","password":"admin123"}`)
+	resp, err := client.Post(base+"/api/v1/auth/login", "application/json", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&login)
+	resp.Body.Close()
+	if err != nil || login.AccessToken == "" {
+		t.Fatalf("admin login: %d %v", resp.StatusCode, err)
+	}
+
+	// Reads first, then writes, so what the writes change shows up in as
+	// few entries as possible; the writes the admin makes come last.
+	type route struct{ method, pattern, path string }
+	var reads, writes []route
+	for _, p := range patterns {
+		method, path, ok := strings.Cut(p, " ")
+		if !ok {
+			method, path = "GET", p
+		}
+		r := route{method, path, fillPattern(path, login.User.ID)}
+		if method == "GET" || method == "HEAD" {
+			reads = append(reads, r)
+		} else {
+			writes = append(writes, r)
+		}
+	}
+	sortRoutes := func(rs []route) {
+		slices.SortFunc(rs, func(a, b route) int { return strings.Compare(a.pattern+" "+a.method, b.pattern+" "+b.method) })
+	}
+	sortRoutes(reads)
+	sortRoutes(writes)
+
+	n := &normalizer{seen: map[string]string{}}
+	var out strings.Builder
+	send := func(r route, as string) {
+		var rd io.Reader
+		if r.method != "GET" && r.method != "HEAD" {
+			rd = strings.NewReader("{}")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, r.method, base+r.path, rd)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+		if as == "admin" {
+			req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+			req.Header.Set("X-CSRF-Token", login.CSRFToken)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			fmt.Fprintf(&out, "### %s %s as %s\nerror\n\n", r.method, r.path, as)
+			return
+		}
+		defer resp.Body.Close()
+		var data []byte
+		// Metrics count every request the process served, in any test.
+		if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") && !strings.HasPrefix(r.path, "/metrics") {
+			data, _ = io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		}
+		fmt.Fprintf(&out, "### %s %s as %s\n%d\n", r.method, n.replace(r.path), as, resp.StatusCode)
+		var names []string
+		for name := range resp.Header {
+			if !volatileHeaders[name] {
+				names = append(names, name)
+			}
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			value := strings.Join(resp.Header.Values(name), ", ")
+			if countdownHeaders[name] {
+				value = "<seconds>"
+			}
+			fmt.Fprintf(&out, "%s: %s\n", name, n.replace(value))
+		}
+		if len(data) > 0 {
+			body := n.replace(strings.TrimRight(string(data), "\n"))
+			if len(body) > 4096 {
+				body = body[:4096] + "..."
+			}
+			out.WriteString("\n" + body + "\n")
+		}
+		out.WriteString("\n")
+	}
+	for _, as := range []string{"nobody", "admin"} {
+		for _, r := range reads {
+			send(r, as)
+		}
+	}
+	for _, as := range []string{"nobody", "admin"} {
+		for _, r := range writes {
+			send(r, as)
+		}
+	}
+	return out.String()
+}
+
+// fillPattern puts values into the wildcards of a route pattern: the
+// admin's ID for IDs, "x" for the rest.
+func fillPattern(path, adminID string) string {
+	return wildcard.ReplaceAllStringFunc(path, func(w string) string {
+		switch strings.Trim(w, "{}.") {
+		case "id", "uid", "userID":
+			return adminID
+		case "$":
+			return ""
+		default:
+			return "x"
+		}
+	})
+}
+
+var wildcard = regexp.MustCompile(`\{[^}]*\}`)
+
+// volatileHeaders change from one run to the next.
+var volatileHeaders = map[string]bool{
+	"Date": true, "Content-Length": true, "X-Request-Id": true, "Traceparent": true,
+	"Server-Timing": true, "X-Response-Time": true, "Etag": true, "Last-Modified": true,
+}
+
+// countdownHeaders count down with the clock; only their presence is
+// recorded.
+var countdownHeaders = map[string]bool{
+	"X-Token-Expires-In": true, "Retry-After": true, "Ratelimit-Reset": true, "X-Ratelimit-Reset": true,
+}
+
+// normalizer replaces the values that differ between runs with
+// placeholders. IDs and tokens are numbered, the same value always with
+// the same number, so the transcript still shows which are equal; times
+// and durations, which depend on where the clock's second falls, are not.
+type normalizer struct {
+	seen map[string]string
+	n    map[string]int
+}
+
+var volatile = []struct {
+	kind     string
+	numbered bool
+	re       *regexp.Regexp
+}{
+	{"jwt", true, regexp.MustCompile(`eyJ[\w-]+\.[\w-]+\.[\w-]+`)},
+	{"time", false, regexp.MustCompile(`\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:?\d{2})?`)},
+	{"http-date", false, regexp.MustCompile(`[A-Z][a-z]{2}, \d{2} [A-Z][a-z]{2} \d{4} \d{2}:\d{2}:\d{2} GMT`)},
+	{"token", true, regexp.MustCompile(`\b[0-9a-f]{64}\b`)},
+	{"id", true, regexp.MustCompile(`\b[0-9a-f]{32}\b`)},
+	{"span", true, regexp.MustCompile(`\b[0-9a-f]{16}\b`)},
+	{"bcrypt", true, regexp.MustCompile(`\$2[aby]\$\d\d\$[./A-Za-z0-9]{53}`)},
+	{"secret", true, regexp.MustCompile(`\b[A-Za-z0-9_-]{40,}\b`)},
+	{"duration", false, regexp.MustCompile(`\b(\d+(\.\d+)?(ns|µs|us|ms|s|m|h))+\b`)}, // 2m42s as well as 42s
+	{"seconds", false, regexp.MustCompile(`"(expires_in|uptime_seconds|age)":\s*\d+`)},
+	{"epoch", false, regexp.MustCompile(`\b1[6-9]\d{8}\b`)},
+}
+
+func (n *normalizer) replace(s string) string {
+	if n.n == nil {
+		n.n = map[string]int{}
+	}
+	for _, v := range volatile {
+		s = v.re.ReplaceAllStringFunc(s, func(m string) string {
+			if !v.numbered {
+				if key, _, ok := strings.Cut(m, ":"); ok && strings.HasPrefix(m, `"`) {
+					return key + `:"<` + v.kind + `>"`
+				}
+				return "<" + v.kind + ">"
+			}
+			if p, ok := n.seen[m]; ok {
+				return p
+			}
+			n.n[v.kind]++
+			p := fmt.Sprintf("<%s-%d>", v.kind, n.n[v.kind])
+			n.seen[m] = p
+			return p
+		})
+	}
+	return s
+}
+
+// TestRouteGoldenStable runs the sweep twice and expects the same
+// transcript, so that a golden diff is never noise.
+func TestRouteGoldenStable(t *testing.T) {
+	if *routesURL != "" {
+		t.Skip("sweeping another build")
+	}
+	var runs []string
+	for range 2 {
+		s, ts := openAPIServer(t, store.NewMemory())
+		runs = append(runs, sweepRoutes(t, ts.URL, s.router.Patterns()))
+	}
+	if runs[0] != runs[1] {
+		t.Errorf("two sweeps differ:\n%s", lineDiff(runs[0], runs[1]))
+	}
+	if !bytes.Contains([]byte(runs[0]), []byte("### GET /api/v1/users/me as admin\n200\n")) {
+		t.Error("the admin is not signed in during the sweep")
+	}
+}
+
+// Durations are placeholders however many units they have, so an uptime
+// past a minute does not break the golden file.
+func TestNormalizerDurations(t *testing.T) {
+	n := &normalizer{seen: map[string]string{}}
+	for in, want := range map[string]string{
+		`"uptime":"42s"`:        `"uptime":"<duration>"`,
+		`"uptime":"2m42s"`:      `"uptime":"<duration>"`,
+		`"uptime":"1h2m3.5s"`:   `"uptime":"<duration>"`,
+		`took 150ms, then 1m0s`: `took <duration>, then <duration>`,
+	} {
+		if got := n.replace(in); got != want {
+			t.Errorf("%s: %s, want %s", in, got, want)
+		}
+	}
+}
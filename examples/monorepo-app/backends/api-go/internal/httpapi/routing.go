@@ -0,0 +1,284 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+)
+
+// Chain composes middleware; the first one listed runs outermost.
+func Chain(mws ...func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		for i := len(mws) - 1; i >= 0; i-- {
+			h = mws[i](h)
+		}
+		return h
+	}
+}
+
+// Router is a ServeMux that remembers its patterns, so startup checks can
+// compare them with the OpenAPI document.
+type Router struct {
+	*http.ServeMux
+	patterns []string
+}
+
+func NewRouter() *Router { return &Router{ServeMux: http.NewServeMux()} }
+
+func (rt *Router) Handle(pattern string, h http.Handler) {
+	rt.patterns = append(rt.patterns, pattern)
+	rt.ServeMux.Handle(pattern, h)
+}
+
+func (rt *Router) HandleFunc(pattern string, h func(http.ResponseWriter, *http.Request)) {
+	rt.Handle(pattern, http.HandlerFunc(h))
+}
+
+// Patterns returns the registered patterns in registration order.
+func (rt *Router) Patterns() []string { return slices.Clone(rt.patterns) }
+
+// Group registers routes on a mux under a common path prefix and
+// middleware chain.
+type Group struct {
+	mux    *Router
+	prefix string
+	mws    []func(http.Handler) http.Handler
+}
+
+func NewGroup(mux *Router, prefix string, mws ...func(http.Handler) http.Handler) *Group {
+	return &Group{mux: mux, prefix: prefix, mws: mws}
+}
+
+// Group returns a sub-group whose prefix and middleware extend g's.
+func (g *Group) Group(prefix string, mws ...func(http.Handler) http.Handler) *Group {
+	all := make([]func(http.Handler) http.Handler, 0, len(g.mws)+len(mws))
+	all = append(append(all, g.mws...), mws...)
+	return &Group{mux: g.mux, prefix: g.prefix + prefix, mws: all}
+}
+
+// Handle registers h for pattern ("[METHOD ]/path") below the group prefix.
+func (g *Group) Handle(pattern string, h http.Handler) {
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok {
+		method, path = "", pattern
+	} else {
+		method += " "
+	}
+	g.mux.Handle(method+g.prefix+path, Chain(g.mws...)(h))
+}
+
+func (g *Group) HandleFunc(pattern string, h http.HandlerFunc) { g.Handle(pattern, h) }
+
+// JSONFallbacks serves mux, replacing its plain-text 404 and 405 replies
+// with APIError bodies. The Allow header computed by the mux is preserved.
+func JSONFallbacks(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h, pattern := mux.Handler(r)
+		if pattern != "" {
+			setRequestPattern(r, pattern)
+			mux.ServeHTTP(w, r)
+			return
+		}
+		probe := &probeWriter{header: make(http.Header), code: http.StatusOK}
+		h.ServeHTTP(probe, r)
+		switch probe.code {
+		case http.StatusNotFound:
+			writeErrorCode(w, r, http.StatusNotFound, api.ErrCodeNotFound, "no route for "+r.URL.Path)
+		case http.StatusMethodNotAllowed:
+			w.Header().Set("Allow", probe.header.Get("Allow"))
+			writeErrorCode(w, r, http.StatusMethodNotAllowed, api.ErrCodeMethodNotAllowed, "method "+r.Method+" not allowed")
+		default:
+			// Canonicalizing redirects and the like.
+			mux.ServeHTTP(w, r)
+		}
+	})
+}
+
+// probeWriter records the status a handler would send, discarding the body.
+type probeWriter struct {
+	header http.Header
+	code   int
+}
+
+func (p *probeWriter) Header() http.Header         { return p.header }
+func (p *probeWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (p *probeWriter) WriteHeader(code int)        { p.code = code }
+
+const (
+	maxBatchRequests = 10
+	maxBatchBody     = 1 << 20
+)
+
+type BatchRequest struct {
+	Requests []BatchSubRequest `json:"requests"`
+	Atomic   bool              `json:"atomic"` // stop at the first failure
+}
+
+type BatchSubRequest struct {
+	Method string          `json:"method"`
+	Path   string          `json:"path"` // on this API, with an optional query
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// BatchResponse lists one result per sub-request that ran, in order.
+// Aborted is set when an atomic batch stopped at a failure (status >= 400);
+// the sub-requests after it did not run, the ones before it are not undone.
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+	Aborted bool          `json:"aborted,omitempty"`
+}
+
+type BatchResult struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body,omitempty"` // non-JSON bodies as a string
+}
+
+// batchHeaders are copied from the batch request to every sub-request.
+var batchHeaders = []string{"Authorization", "X-CSRF-Token", "Accept-Language", "User-Agent", "X-Request-ID", "X-Forwarded-For"}
+
+// batchExcluded are the routes a sub-request may not target: the batch
+// endpoint itself and the streams, which never complete.
+var batchExcluded = map[string]bool{"POST /api/v1/batch": true, "GET /api/v1/events": true, "GET /api/v1/ws": true}
+
+// Batch runs sub-requests against the router, one after the other, as the
+// caller. Each goes through its route's middleware like a request of its
+// own, so it is authorized and rate limited separately; the batch's CSRF
+// token is forwarded, so it is only sent once. A failed sub-request does not
+// stop the others unless the batch is atomic.
+type Batch struct {
+	mux *Router
+	api http.Handler
+}
+
+func NewBatch(mux *Router) *Batch { return &Batch{mux: mux, api: JSONFallbacks(mux.ServeMux)} }
+
+func (b *Batch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req BatchRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBody)).Decode(&req); err != nil {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			writeErrorCode(w, r, http.StatusRequestEntityTooLarge, api.ErrCodePayloadTooLarge, "request body too large")
+			return
+		}
+		writeErrorCode(w, r, http.StatusBadRequest, api.ErrCodeInvalidRequest, "invalid request body")
+		return
+	}
+	var fields []FieldError
+	switch {
+	case len(req.Requests) == 0:
+		fields = append(fields, FieldError{Field: "requests", Message: "is required"})
+	case len(req.Requests) > maxBatchRequests:
+		fields = append(fields, FieldError{Field: "requests", Message: fmt.Sprintf("at most %d sub-requests", maxBatchRequests)})
+	}
+	subs := make([]*http.Request, 0, len(req.Requests))
+	for i, sr := range req.Requests {
+		sub, field, err := newBatchSubRequest(r, sr)
+		if err == nil {
+			if _, pattern := b.mux.Handler(sub); batchExcluded[routeV1(pattern)] {
+				field, err = "path", fmt.Errorf("%s can't be batched", pattern)
+			}
+		}
+		if err != nil {
+			fields = append(fields, FieldError{Field: fmt.Sprintf("requests[%d].%s", i, field), Message: err.Error()})
+			continue
+		}
+		subs = append(subs, sub)
+	}
+	if len(fields) > 0 {
+		writeErrorFields(w, r, http.StatusBadRequest, api.ErrCodeValidationFailed, "invalid batch", fields)
+		return
+	}
+
+	resp := BatchResponse{Results: make([]BatchResult, 0, len(subs))}
+	for _, sub := range subs {
+		if r.Context().Err() != nil {
+			return // the client is gone
+		}
+		rec := &batchRecorder{header: make(http.Header)}
+		b.api.ServeHTTP(rec, sub)
+		resp.Results = append(resp.Results, rec.result())
+		if req.Atomic && rec.status() >= 400 {
+			resp.Aborted = true
+			break
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// newBatchSubRequest builds the request for sr, or reports which field of
+// it is invalid.
+func newBatchSubRequest(r *http.Request, sr BatchSubRequest) (*http.Request, string, error) {
+	if sr.Method == "" {
+		return nil, "method", errors.New("is required")
+	}
+	u, err := url.Parse(sr.Path)
+	if err != nil || u.Scheme != "" || u.Host != "" || !strings.HasPrefix(sr.Path, "/") || strings.HasPrefix(sr.Path, "//") {
+		return nil, "path", errors.New("must be a path on this API, like /api/v1/users/me")
+	}
+	var body io.Reader = http.NoBody
+	if len(sr.Body) > 0 {
+		body = bytes.NewReader(sr.Body)
+	}
+	sub, err := http.NewRequestWithContext(r.Context(), strings.ToUpper(sr.Method), u.RequestURI(), body)
+	if err != nil {
+		return nil, "method", errors.New("is not a valid HTTP method")
+	}
+	sub.Host, sub.RemoteAddr = r.Host, r.RemoteAddr
+	sub.Proto, sub.ProtoMajor, sub.ProtoMinor = r.Proto, r.ProtoMajor, r.ProtoMinor
+	for _, h := range batchHeaders {
+		for _, v := range r.Header.Values(h) {
+			sub.Header.Add(h, v)
+		}
+	}
+	if len(sr.Body) > 0 {
+		sub.Header.Set("Content-Type", "application/json")
+	}
+	return sub, "", nil
+}
+
+// batchRecorder buffers a sub-request's response.
+type batchRecorder struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (br *batchRecorder) Header() http.Header { return br.header }
+
+func (br *batchRecorder) WriteHeader(code int) {
+	if br.code == 0 {
+		br.code = code
+	}
+}
+
+func (br *batchRecorder) Write(b []byte) (int, error) {
+	br.WriteHeader(http.StatusOK)
+	return br.body.Write(b)
+}
+
+func (br *batchRecorder) status() int {
+	if br.code == 0 {
+		return http.StatusOK
+	}
+	return br.code
+}
+
+func (br *batchRecorder) result() BatchResult {
+	res := BatchResult{Status: br.status()}
+	switch body := br.body.Bytes(); {
+	case len(body) == 0:
+	case json.Valid(body):
+		res.Body = bytes.TrimSpace(body)
+	default:
+		res.Body, _ = json.Marshal(string(body))
+	}
+	return res
+}
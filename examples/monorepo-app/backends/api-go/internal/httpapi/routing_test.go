@@ -0,0 +1,156 @@
+package httpapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/httpapi"
+	"github.com/your-org/your-app/backends/api-go/raijintest"
+)
+
+// tracer returns middleware that appends name to *calls on the way in and
+// "/"+name on the way out.
+func tracer(calls *[]string, name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*calls = append(*calls, name)
+			next.ServeHTTP(w, r)
+			*calls = append(*calls, "/"+name)
+		})
+	}
+}
+
+func TestChainOrder(t *testing.T) {
+	var calls []string
+	h := httpapi.Chain(tracer(&calls, "a"), tracer(&calls, "b"), tracer(&calls, "c"))(
+		http.HandlerFunc(func(http.ResponseWriter, *http.Request) { calls = append(calls, "handler") }))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if want := []string{"a", "b", "c", "handler", "/c", "/b", "/a"}; !slices.Equal(calls, want) {
+		t.Errorf("got %v, want %v", calls, want)
+	}
+
+	calls = nil
+	httpapi.Chain()(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { calls = append(calls, "handler") })).
+		ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if !slices.Equal(calls, []string{"handler"}) {
+		t.Errorf("empty chain: %v", calls)
+	}
+}
+
+func TestGroups(t *testing.T) {
+	var calls []string
+	mux := httpapi.NewRouter()
+	api := httpapi.NewGroup(mux, "/api/v1", tracer(&calls, "rl"), tracer(&calls, "auth"))
+	admin := api.Group("/admin", tracer(&calls, "admin"))
+	// A sibling created after admin must not share its middleware.
+	users := api.Group("/users", tracer(&calls, "users"))
+	handler := func(name string) http.HandlerFunc {
+		return func(http.ResponseWriter, *http.Request) { calls = append(calls, name) }
+	}
+	api.HandleFunc("GET /ping", handler("ping"))
+	admin.HandleFunc("POST /stats", handler("stats"))
+	users.HandleFunc("GET /me", handler("me"))
+	users.HandleFunc("/any", handler("any"))
+
+	if want := []string{"GET /api/v1/ping", "POST /api/v1/admin/stats", "GET /api/v1/users/me", "/api/v1/users/any"}; !slices.Equal(mux.Patterns(), want) {
+		t.Errorf("patterns %v, want %v", mux.Patterns(), want)
+	}
+	for _, tt := range []struct {
+		method, path string
+		want         string
+	}{
+		{"GET", "/api/v1/ping", "rl auth ping /auth /rl"},
+		{"POST", "/api/v1/admin/stats", "rl auth admin stats /admin /auth /rl"},
+		{"GET", "/api/v1/users/me", "rl auth users me /users /auth /rl"},
+		{"DELETE", "/api/v1/users/any", "rl auth users any /users /auth /rl"},
+	} {
+		calls = nil
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if got := strings.Join(calls, " "); got != tt.want {
+			t.Errorf("%s %s: %s, want %s", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+// On the server, the admin group authenticates before it checks the
+// role.
+func TestAdminGroupOrder(t *testing.T) {
+	srv := raijintest.NewServer(t)
+	for _, tt := range []struct {
+		name   string
+		client *http.Client
+		want   int
+	}{
+		{"anonymous", srv.Client(), http.StatusUnauthorized},
+		{"user", srv.LoginAs(t, "user"), http.StatusForbidden},
+		{"admin", srv.LoginAs(t, "admin"), http.StatusOK},
+	} {
+		resp := send(t, tt.client, "GET", srv.URL+"/api/v1/admin/stats", nil, nil)
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s: %d, want %d", tt.name, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+// Unknown paths and wrong methods get APIError bodies with the same
+// security and CORS headers as any other response.
+func TestJSONFallbacks(t *testing.T) {
+	srv := raijintest.NewServer(t)
+	for _, tt := range []struct {
+		method, path string
+		status       int
+		code, allow  string
+	}{
+		{"GET", "/api/v1/nope", http.StatusNotFound, api.ErrCodeNotFound, ""},
+		{"DELETE", "/nope", http.StatusNotFound, api.ErrCodeNotFound, ""},
+		{"PUT", "/health", http.StatusMethodNotAllowed, api.ErrCodeMethodNotAllowed, "GET, HEAD"},
+		{"DELETE", "/api/v1/auth/login", http.StatusMethodNotAllowed, api.ErrCodeMethodNotAllowed, "POST"},
+	} {
+		req, _ := http.NewRequest(tt.method, srv.URL+tt.path, nil)
+		req.Header.Set("Origin", "http://localhost:5173")
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body api.APIError
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		name := tt.method + " " + tt.path
+		if resp.StatusCode != tt.status || err != nil {
+			t.Errorf("%s: %d, %v", name, resp.StatusCode, err)
+			continue
+		}
+		if body.ErrorCode != tt.code || body.Code != tt.status || body.Message == "" {
+			t.Errorf("%s: body %+v", name, body)
+		}
+		if got := resp.Header.Get("Allow"); got != tt.allow {
+			t.Errorf("%s: Allow %q, want %q", name, got, tt.allow)
+		}
+		if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+			t.Errorf("%s: Content-Type %q", name, ct)
+		}
+		if resp.Header.Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("%s: no security headers", name)
+		}
+		if resp.Header.Get("Access-Control-Allow-Origin") != "http://localhost:5173" {
+			t.Errorf("%s: no CORS headers", name)
+		}
+	}
+
+	// The mux's own redirects to the clean path still go through.
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(srv.URL + "/api/v1//users/me")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 3 || resp.Header.Get("Location") != "/api/v1/users/me" {
+		t.Errorf("unclean path: %d to %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+}
@@ -0,0 +1,208 @@
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/auth"
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+	"github.com/your-org/your-app/backends/api-go/internal/saml"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+)
+
+const (
+	// samlRequestTTL is how long a user has to get through the IdP.
+	samlRequestTTL = 10 * time.Minute
+	// maxSAMLBody caps the ACS form; signed responses are a few KiB.
+	maxSAMLBody = 256 << 10
+
+	samlPrefix = "/api/v1/auth/saml"
+)
+
+// NewSAMLProvider builds the service provider from cfg.SAML, or returns
+// nil when SAML is off. The IdP posts to, and the metadata names, the
+// /api/v1 endpoints.
+func NewSAMLProvider(cfg *config.Config) (*saml.SP, error) {
+	c := cfg.SAML
+	if !c.Enabled() {
+		return nil, nil
+	}
+	cert, err := saml.LoadCertificate(c.IdPCertPath)
+	if err != nil {
+		return nil, fmt.Errorf("SAML IdP certificate: %w", err)
+	}
+	sp := &saml.SP{
+		EntityID:    c.EntityID,
+		ACSURL:      c.BaseURL + samlPrefix + "/acs",
+		IdPEntityID: c.IdPEntityID,
+		IdPSSOURL:   c.IdPSSOURL,
+		IdPCert:     cert,
+		ClockSkew:   c.ClockSkew,
+	}
+	if sp.EntityID == "" {
+		sp.EntityID = c.BaseURL + samlPrefix + "/metadata"
+	}
+	return sp, nil
+}
+
+// samlOff answers the SAML routes when SAML_IDP_SSO_URL is not set.
+func (h *Handlers) samlOff(w http.ResponseWriter, r *http.Request) bool {
+	if h.saml == nil {
+		writeErrorCode(w, r, http.StatusNotFound, api.ErrCodeNotFound, "SAML login is not configured")
+		return true
+	}
+	return false
+}
+
+// SAMLMetadata serves the SP metadata to register with the IdP.
+func (h *Handlers) SAMLMetadata(w http.ResponseWriter, r *http.Request) {
+	if h.samlOff(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	w.Write(h.saml.Metadata())
+}
+
+// SAMLLogin starts SP-initiated login: it records a pending AuthnRequest
+// under a fresh RelayState and redirects the browser to the IdP.
+func (h *Handlers) SAMLLogin(w http.ResponseWriter, r *http.Request) {
+	if h.samlOff(w, r) {
+		return
+	}
+	requestID := "_" + auth.GenerateID() // xs:ID must not start with a digit
+	relayState := auth.GenerateToken()
+	target, err := h.saml.AuthnRequestURL(requestID, relayState, auth.Now())
+	if err != nil {
+		log.Printf("ERROR saml: AuthnRequest: %v", err)
+		writeErrorCode(w, r, http.StatusInternalServerError, api.ErrCodeInternal, "internal error")
+		return
+	}
+	h.store.StoreSAMLRequest(relayState, requestID, samlRequestTTL)
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// SAMLACS consumes the IdP's response and logs the user in.
+//
+// The IdP's browser POST is cross-site by design and carries no
+// X-CSRF-Token; the auth routes are outside CSRFProtection anyway. What
+// stands in for it is RelayState: it must name a request this server
+// started, is accepted once and binds the response to that request's ID
+// (InResponseTo), so a response cannot be replayed or injected into a
+// login nobody started. IdP-initiated logins are refused for that reason.
+func (h *Handlers) SAMLACS(w http.ResponseWriter, r *http.Request) {
+	if h.samlOff(w, r) {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxSAMLBody)
+	if err := r.ParseForm(); err != nil {
+		writeErrorCode(w, r, http.StatusBadRequest, api.ErrCodeInvalidRequest, "invalid form body")
+		return
+	}
+	fail := func(reason string, err error) {
+		log.Printf("WARN saml: rejected response (%s): %v", reason, err)
+		LoginFailed.Publish(eventContext(r), h.events, AuthFailureEvent{Reason: reason})
+		writeErrorCode(w, r, http.StatusUnauthorized, api.ErrCodeSAMLInvalid, "invalid SAML response")
+	}
+	requestID, ok := h.store.ConsumeSAMLRequest(r.PostForm.Get("RelayState"))
+	if !ok {
+		fail("saml_state", errors.New("unknown, used or expired RelayState"))
+		return
+	}
+	assertion, err := h.saml.ParseResponse(r.PostForm.Get("SAMLResponse"), requestID, auth.Now())
+	if err != nil {
+		fail("saml_invalid", err)
+		return
+	}
+	if !h.store.MarkSAMLAssertion(assertion.ID, assertion.Expires) {
+		fail("saml_replay", fmt.Errorf("assertion %s already used", assertion.ID))
+		return
+	}
+
+	user, err := h.samlUser(r, assertion)
+	if err != nil {
+		if errors.Is(err, errSAMLNoEmail) {
+			fail("saml_invalid", err)
+		} else {
+			writeUserError(w, r, err)
+		}
+		return
+	}
+	if user.Suspended {
+		LoginFailed.Publish(eventContext(r), h.events, AuthFailureEvent{UserID: user.ID, Email: user.Email, Reason: "suspended"})
+		writeErrorCode(w, r, http.StatusForbidden, api.ErrCodeAccountSuspended, "account suspended")
+		return
+	}
+	if user.PendingDeletion() {
+		LoginFailed.Publish(eventContext(r), h.events, AuthFailureEvent{UserID: user.ID, Email: user.Email, Reason: "pending_deletion"})
+		writeErrorCode(w, r, http.StatusForbidden, api.ErrCodeAccountDeleting, "account scheduled for deletion; cancel the deletion to log in")
+		return
+	}
+	LoggedIn.Publish(eventContext(r), h.events, UserEvent{User: *user})
+	h.noteDevice(r, user, true)
+	if err := h.respondAuth(w, r, http.StatusOK, user, nil); err != nil {
+		writeIssueError(w, r, err)
+	}
+}
+
+var errSAMLNoEmail = errors.New("assertion carries no email address")
+
+// samlUser maps an assertion to the local user with its email, creating
+// the account on first login. The email is SAML_EMAIL_ATTRIBUTE or, when
+// that is unset, an email-format NameID; the name is SAML_NAME_ATTRIBUTE.
+// If SAML_ROLE_ATTRIBUTE is set and names a known role, the IdP owns the
+// user's role. Accounts created here get an unguessable password: they log
+// in through the IdP.
+func (h *Handlers) samlUser(r *http.Request, a *saml.Assertion) (*User, error) {
+	c := h.cfg.SAML
+	email := a.NameID
+	if c.EmailAttribute != "" {
+		email = a.Attribute(c.EmailAttribute)
+	} else if !a.IsEmail() {
+		email = ""
+	}
+	if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+		return nil, fmt.Errorf("%w (NameID %q)", errSAMLNoEmail, a.NameID)
+	}
+	role := ""
+	if c.RoleAttribute != "" {
+		if v := a.Attribute(c.RoleAttribute); h.roles.Known(v) {
+			role = v
+		}
+	}
+
+	user, err := h.store.GetUserByEmail(email)
+	if errors.Is(err, store.ErrUserNotFound) {
+		name := a.Attribute(c.NameAttribute)
+		if name == "" {
+			name, _, _ = strings.Cut(email, "@")
+		}
+		if role == "" {
+			role = "user"
+		}
+		user, err = h.store.CreateUser(email, name, auth.GenerateToken(), role)
+		if errors.Is(err, store.ErrEmailTaken) { // a concurrent first login
+			user, err = h.store.GetUserByEmail(email)
+		} else if err == nil {
+			UserRegistered.Publish(eventContext(r), h.events, UserEvent{User: *user})
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if role != "" && role != user.Role {
+		var oldRole string
+		user, err = h.store.UpdateUser(user.ID, func(u *User) { oldRole, u.Role = u.Role, role })
+		if err != nil {
+			return nil, err
+		}
+		RoleChanged.Publish(eventContext(r), h.events, RoleChangedEvent{User: *user, OldRole: oldRole})
+	}
+	return user, nil
+}
@@ -0,0 +1,156 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/internal/auth"
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+	"github.com/your-org/your-app/backends/api-go/internal/saml"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+)
+
+// Self-check outcomes. A warning is reported, but does not fail the check.
+const (
+	SelfCheckOK   = "ok"
+	SelfCheckWarn = "warn"
+	SelfCheckFail = "fail"
+)
+
+// certWarnWithin is how close to expiry a certificate gets a warning.
+const certWarnWithin = 14 * 24 * time.Hour
+
+// SelfCheckResult is the outcome of one check of SelfCheck.
+type SelfCheckResult struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"` // SelfCheckOK, SelfCheckWarn or SelfCheckFail
+	Detail     string `json:"detail,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// SelfCheckReport is what server --check prints. Status is the worst of
+// the checks.
+type SelfCheckReport struct {
+	Status string            `json:"status"`
+	Checks []SelfCheckResult `json:"checks"`
+}
+
+// Failed returns the failed checks.
+func (r SelfCheckReport) Failed() []SelfCheckResult { return r.with(SelfCheckFail) }
+
+// Warnings returns the checks that passed with a warning.
+func (r SelfCheckReport) Warnings() []SelfCheckResult { return r.with(SelfCheckWarn) }
+
+func (r SelfCheckReport) with(status string) []SelfCheckResult {
+	var out []SelfCheckResult
+	for _, c := range r.Checks {
+		if c.Status == status {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// errSelfCheckWarn marks a check error as a warning.
+type errSelfCheckWarn struct{ error }
+
+// SelfCheck verifies that the instance can serve before it takes traffic:
+// the configuration validates, the store answers, the JWT secrets sign
+// and verify a token, every email template renders, and the certificates
+// it is given parse and have not expired (a warning within 14 days). It
+// runs every check, whatever the earlier ones found; Validate's warnings
+// are logged as usual.
+func SelfCheck(ctx context.Context, cfg *config.Config, st store.Store) SelfCheckReport {
+	report := SelfCheckReport{Status: SelfCheckOK}
+	run := func(name string, fn func() error) {
+		start := time.Now()
+		err := fn()
+		res := SelfCheckResult{Name: name, Status: SelfCheckOK, DurationMS: time.Since(start).Milliseconds()}
+		var warn errSelfCheckWarn
+		switch {
+		case errors.As(err, &warn):
+			res.Status, res.Detail = SelfCheckWarn, warn.Error()
+			if report.Status == SelfCheckOK {
+				report.Status = SelfCheckWarn
+			}
+		case err != nil:
+			res.Status, res.Detail = SelfCheckFail, err.Error()
+			report.Status = SelfCheckFail
+		}
+		report.Checks = append(report.Checks, res)
+	}
+
+	run("config", cfg.Validate)
+	run("store", func() error {
+		ctx, cancel := context.WithTimeout(ctx, cfg.ReadyCheckTimeout)
+		defer cancel()
+		return st.Ping(ctx)
+	})
+	run("jwt", func() error {
+		if err := checkJWTSecret(cfg.JWTSecret); err != nil {
+			return fmt.Errorf("JWT_SECRET: %w", err)
+		}
+		if cfg.JWTSecretPrevious != "" {
+			if err := checkJWTSecret(cfg.JWTSecretPrevious); err != nil {
+				return fmt.Errorf("JWT_SECRET_PREVIOUS: %w", err)
+			}
+		}
+		return nil
+	})
+	run("email_templates", func() error {
+		_, err := LoadEmailTemplates(cfg.EmailTemplatesDir)
+		return err
+	})
+	if cfg.SAML.Enabled() {
+		run("saml_idp_cert", func() error { return checkCertificate(cfg.SAML.IdPCertPath) })
+	}
+	if cfg.BodyLog.Enabled {
+		run("body_log", func() error {
+			_, err := NewBodyLogger(cfg.BodyLog)
+			return err
+		})
+	}
+	return report
+}
+
+// checkJWTSecret signs a token with secret and verifies it back, as Auth
+// would.
+func checkJWTSecret(secret string) error {
+	if secret == "" {
+		return errors.New("not loaded")
+	}
+	now := auth.Now()
+	token, err := auth.CreateJWT(secret, auth.Claims{UserID: "self-check", Role: "user", Iat: now.Unix(), Exp: now.Add(time.Minute).Unix()})
+	if err != nil {
+		return err
+	}
+	claims, err := auth.VerifyJWT(secret, token)
+	if err != nil {
+		return err
+	}
+	if claims.UserID != "self-check" {
+		return errors.New("verified token lost its claims")
+	}
+	return nil
+}
+
+// checkCertificate loads the PEM certificate at path and checks it is
+// valid now, warning when it expires within certWarnWithin.
+func checkCertificate(path string) error {
+	cert, err := saml.LoadCertificate(path)
+	if err != nil {
+		return err
+	}
+	now := auth.Now()
+	switch {
+	case now.Before(cert.NotBefore):
+		return fmt.Errorf("%s: not valid before %s", path, cert.NotBefore.UTC().Format(time.RFC3339))
+	case !now.Before(cert.NotAfter):
+		return fmt.Errorf("%s: expired %s", path, cert.NotAfter.UTC().Format(time.RFC3339))
+	case cert.NotAfter.Sub(now) < certWarnWithin:
+		return errSelfCheckWarn{fmt.Errorf("%s: expires %s", path, cert.NotAfter.UTC().Format(time.RFC3339))}
+	}
+	return nil
+}
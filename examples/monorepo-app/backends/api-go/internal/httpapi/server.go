@@ -0,0 +1,571 @@
+// Package httpapi is the HTTP API: handlers, middleware, routing and the
+// components behind them (events, audit log, webhooks, mail, live events,
+// gRPC). New wires them into a Server; cmd/server owns the listeners and
+// signals.
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+)
+
+// Server is the API wired to a store.
+type Server struct {
+	Handler  http.Handler // the public API
+	Internal http.Handler // /metrics and pprof for INTERNAL_ADDR; nil when Handler serves them
+	GRPC     http.Handler // the gRPC API for GRPC_ADDR; nil when disabled
+	Events   *EventBus    // what the handlers publish; subscribe to extend the server
+
+	mu           sync.Mutex     // serializes Reload
+	cfg          *config.Config // effective configuration
+	router       *Router        // the public routes
+	mw           *Middleware
+	maintenance  *Maintenance
+	accessLog    *RequestLogger
+	rateLimits   *RateLimiters
+	loginFails   *RateLimiter // failed logins per email
+	captchaFails *RateLimiter // failed logins per IP and email, for the CAPTCHA; nil when off
+	drain        *Drain
+	checks       *Checks
+	roles        *RoleCatalog
+	features     *Features
+	live         *LiveHub
+	grpc         *GRPCServer
+	accessFile   *ReopenFile
+	auditFile    *ReopenFile
+	lifecycle    Lifecycle
+}
+
+// Option replaces a component New would otherwise build from the
+// configuration.
+type Option func(*options)
+
+type options struct {
+	mailer Mailer
+	sms    SMSSender
+}
+
+// WithMailer sends mail through m instead of the MAIL_DRIVER mailer.
+func WithMailer(m Mailer) Option {
+	return func(o *options) { o.mailer = m }
+}
+
+// WithSMSSender sends text messages through s instead of the SMS_DRIVER
+// sender, and turns the phone login on even without SMS_DRIVER.
+func WithSMSSender(s SMSSender) Option {
+	return func(o *options) { o.sms = s }
+}
+
+// New builds the API for cfg, which must have passed Validate, on top of
+// st. It starts the background workers and registers their warmup tasks
+// and shutdown hooks; Warmup and Close run them.
+func New(cfg *config.Config, st store.Store, opts ...Option) (*Server, error) {
+	outbound := NewOutbound(cfg.Outbound)
+	o := options{mailer: NewMailer(cfg, outbound), sms: NewSMSSender(cfg, outbound)}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	s := &Server{cfg: cfg}
+	maintenance := NewMaintenance(cfg)
+	checks := NewChecks(cfg.ReadyCheckTimeout, cfg.ReadyCacheTTL)
+	s.checks = checks
+	checks.Register("store", st.Ping)
+	s.lifecycle.OnWarmup("store", st.Ping)
+	checks.Register("jwt", func(context.Context) error {
+		if cfg.JWTSecret == "" {
+			return fmt.Errorf("jwt secret not loaded")
+		}
+		return nil
+	})
+	var audit MultiAuditSink
+	switch cfg.AuditLogOutput {
+	case "off":
+	case "stdout":
+		audit = append(audit, NewJSONAuditSink(os.Stdout))
+	default:
+		f, err := OpenReopenFile(cfg.AuditLogOutput)
+		if err != nil {
+			return nil, fmt.Errorf("audit log: %w", err)
+		}
+		s.auditFile = f
+		s.lifecycle.OnShutdown("audit log", func(context.Context) error { return f.Close() })
+		audit = append(audit, NewJSONAuditSink(f))
+	}
+	if cfg.AuditLogRetention > 0 {
+		audit = append(audit, NewStoreAuditSink(st, cfg.AuditLogRetention))
+	}
+	emails, err := LoadEmailTemplates(cfg.EmailTemplatesDir)
+	if err != nil {
+		return nil, fmt.Errorf("email templates:\n%w", err)
+	}
+	var accessOut io.Writer = os.Stdout
+	if cfg.AccessLogOutput != "stdout" {
+		f, err := OpenReopenFile(cfg.AccessLogOutput)
+		if err != nil {
+			return nil, fmt.Errorf("access log: %w", err)
+		}
+		accessOut, s.accessFile = f, f
+		s.lifecycle.OnShutdown("access log", func(context.Context) error { return f.Close() })
+	}
+	accessLog, err := NewRequestLogger(cfg.AccessLogFormat, accessOut, cfg.AccessLogFilter, cfg.SlowThreshold)
+	if err != nil {
+		return nil, fmt.Errorf("access log: %w", err)
+	}
+
+	events := NewEventBus()
+	s.Events = events
+	SubscribeAudit(events, audit)
+	webhooks := NewWebhooks(st, cfg, outbound)
+	webhooks.Start(cfg.WebhookWorkers)
+	webhooks.Subscribe(events)
+	s.lifecycle.OnShutdown("webhooks", func(ctx context.Context) error {
+		if err := webhooks.Stop(ctx); err != nil {
+			return fmt.Errorf("gave up on in-flight deliveries: %w", err)
+		}
+		return nil
+	})
+	// Registered after the webhooks, so it closes first: async subscribers
+	// may still emit webhooks. The components registered below publish
+	// events, and stop before it.
+	s.lifecycle.OnShutdown("events", func(ctx context.Context) error {
+		if err := events.Close(ctx); err != nil {
+			return fmt.Errorf("async subscribers did not drain: %w", err)
+		}
+		return nil
+	})
+	mailQueue := NewMailQueue(o.mailer, events, SuppressedMail(st), cfg)
+	mailQueue.Start(cfg.MailWorkers)
+	s.lifecycle.OnShutdown("mail", func(ctx context.Context) error {
+		if err := mailQueue.Stop(ctx); err != nil {
+			return fmt.Errorf("gave up on pending retries: %w", err)
+		}
+		return nil
+	})
+	rateLimits := NewRateLimiters(cfg.RateLimitBuckets, cfg.RateLimitRoutes, cfg.RateLimitSweep, cfg.RateLimitMaxKeys, events)
+	s.lifecycle.OnShutdown("rate limits", stopHook(rateLimits.Stop))
+	rateLimits.SetExemptions(cfg.RateLimitExempt)
+	setTrustedProxies(cfg.RateLimitExempt.TrustedProxies)
+	loginFails := rateLimits.LoginFailures(cfg.LoginFailureLimit, cfg.LoginFailureWindow, cfg.RateLimitSweep, events)
+	sp, err := NewSAMLProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+	captcha := NewChallengeProvider(cfg, outbound)
+	if captcha != nil {
+		s.captchaFails = NewRateLimiter(cfg.Captcha.LoginAfter, cfg.Captcha.LoginWindow, cfg.RateLimitSweep)
+		s.captchaFails.SetMaxKeys(cfg.RateLimitMaxKeys)
+		s.lifecycle.OnShutdown("captcha failures", stopHook(s.captchaFails.Stop))
+	}
+	exports := NewDataExports(st)
+	exports.Start(1)
+	s.lifecycle.OnShutdown("data exports", func(ctx context.Context) error {
+		if err := exports.Stop(ctx); err != nil {
+			return fmt.Errorf("gave up on queued exports: %w", err)
+		}
+		return nil
+	})
+	purger := NewAccountPurger(st, events)
+	purger.Start(cfg.PurgeInterval)
+	s.lifecycle.OnShutdown("account purger", stopHook(purger.Stop))
+	s.roles = NewRoleCatalog(cfg.Roles)
+	disposable := NewDisposableDomains(cfg, outbound)
+	if disposable != nil {
+		disposable.Start(cfg.Disposable.Refresh)
+		s.lifecycle.OnShutdown("disposable domains", stopHook(disposable.Stop))
+		if cfg.Disposable.ListURL != "" {
+			// The embedded list applies until the download is in.
+			s.lifecycle.OnWarmupOptional("disposable domains", disposable.Loaded)
+		}
+	}
+	drain := NewDrain(cfg.DrainGrace)
+	s.features = NewFeatures(st, cfg.FeatureFlags)
+	otpPhone := rateLimits.Keyed("otp_phone", "phone", "one-time codes", cfg.SMS.PhoneLimit, cfg.SMS.Window, cfg.RateLimitSweep, events)
+	otpIP := rateLimits.Keyed("otp_ip", "ip", "one-time codes", cfg.SMS.IPLimit, cfg.SMS.Window, cfg.RateLimitSweep, events)
+	handlers := NewHandlers(HandlerDeps{
+		Config: cfg, Store: st, Maintenance: maintenance, Checks: checks, Drain: drain,
+		Lifecycle: &s.lifecycle, Outbound: outbound, Features: s.features,
+		Stats: NewStatsCache(st, loginFails, cfg.AdminStatsCacheTTL), Events: events,
+		Mail: mailQueue, Emails: emails, Roles: s.roles, Exports: exports,
+		SAML: sp, Captcha: captcha, SMS: o.sms, Pwned: NewPwnedPasswords(cfg, outbound), Disposable: disposable,
+		LoginFails: loginFails, CaptchaFails: s.captchaFails, OTPPhone: otpPhone, OTPIP: otpIP,
+	})
+	mw := NewMiddleware(cfg, st, maintenance, events)
+	live := NewLiveHub(cfg, mw, events)
+	live.Subscribe(events)
+
+	mux := NewRouter()
+
+	// Public
+	mux.HandleFunc("GET /health", handlers.Health)
+	mux.HandleFunc("GET /ready", handlers.Ready)
+	mux.HandleFunc("GET /version", handlers.Version)
+	mux.HandleFunc("GET /openapi.json", handlers.OpenAPI)
+	if cfg.EnableDocs {
+		mux.Handle("GET /docs/", DocsHandler())
+	}
+	if cfg.Environment == "development" {
+		preview := Chain(RelaxCSP("style-src 'unsafe-inline'"))(http.HandlerFunc(handlers.PreviewEmail))
+		mux.Handle("GET /dev/emails/", preview)
+		mux.Handle("GET /dev/emails/{kind}", preview)
+	}
+
+	// The API, once per version: same handlers and limits (buckets are
+	// shared across versions), bodies shaped per version (see APIVersion).
+	authCL := NewConcurrencyLimiter("auth", cfg.MaxConcurrentAuth, cfg.ConcurrencyWait)
+	for _, v := range apiVersions {
+		// Auth (rate limited)
+		login := NewGroup(mux, v.Prefix+"/auth", rateLimits.Use("auth", v.Prefix+"/auth/*"), rateLimits.PerRoute, authCL.Wrap)
+		login.Handle("POST /register", mw.Idempotent(http.HandlerFunc(handlers.Register)))
+		login.HandleFunc("POST /login", handlers.Login)
+		// Not Idempotent: a replay would hand out the rotated tokens again
+		// for a day, and keep them in the store meanwhile.
+		login.HandleFunc("POST /refresh", handlers.RefreshToken)
+		login.HandleFunc("POST /cancel-deletion", handlers.CancelDeletion)
+		// Phone login; answers 404 unless SMS is configured.
+		login.HandleFunc("POST /otp/request", handlers.RequestLoginOTP)
+		login.HandleFunc("POST /otp/verify", handlers.VerifyLoginOTP)
+		// SAML SSO; answers 404 unless configured. The ACS is a cross-site
+		// POST from the IdP: RelayState, not CSRF, protects it.
+		login.HandleFunc("GET /saml/login", handlers.SAMLLogin)
+		login.HandleFunc("POST /saml/acs", handlers.SAMLACS)
+		login.HandleFunc("GET /saml/metadata", handlers.SAMLMetadata)
+
+		// Unsubscribe links in mail footers: the signed token is the only
+		// credential, so it shares the auth bucket.
+		unsubscribe := NewGroup(mux, v.Prefix+"/notifications", rateLimits.Use("auth", v.Prefix+"/notifications/*"), rateLimits.PerRoute)
+		unsubscribe.HandleFunc("GET /unsubscribe", handlers.Unsubscribe)
+
+		// Protected
+		api := NewGroup(mux, v.Prefix, mw.Auth, rateLimits.Use("api", v.Prefix+"/*"), rateLimits.PerRoute, mw.CSRFProtection)
+		api.HandleFunc("GET /users/me", handlers.GetCurrentUser)
+		api.HandleFunc("GET /auth/whoami", handlers.WhoAmI)
+		api.HandleFunc("GET /roles", handlers.ListRoles)
+		api.HandleFunc("GET /users/me/features", handlers.GetMyFeatures)
+		api.HandleFunc("GET /users/me/sessions", handlers.ListSessions)
+		api.HandleFunc("DELETE /users/me/sessions/{id}", handlers.RevokeMySession)
+		api.HandleFunc("GET /users/me/activity", handlers.GetMyActivity)
+		api.HandleFunc("GET /users/me/orgs", handlers.ListMyOrgs)
+		api.HandleFunc("GET /users/me/preferences", handlers.GetPreferences)
+		api.HandleFunc("PUT /users/me/preferences", handlers.UpdatePreferences)
+		api.HandleFunc("GET /users/me/notifications", handlers.GetNotifications)
+		api.HandleFunc("PUT /users/me/notifications", handlers.UpdateNotifications)
+		api.HandleFunc("POST /auth/switch-org", handlers.SwitchOrg)
+		api.Group("", mw.RequireOrgRole("member")).HandleFunc("GET /orgs/current", handlers.GetCurrentOrg)
+		api.Group("", mw.RequireOrgRole("owner")).HandleFunc("PATCH /orgs/current", handlers.UpdateCurrentOrg)
+		api.HandleFunc("POST /orgs", handlers.CreateOrg)
+		api.HandleFunc("DELETE /orgs/{id}", handlers.DeleteOrg)
+		api.HandleFunc("GET /orgs/{id}/members", handlers.ListOrgMembers)
+		api.HandleFunc("POST /orgs/{id}/members", handlers.AddOrgMember)
+		api.HandleFunc("PUT /orgs/{id}/members/{uid}/role", handlers.SetOrgMemberRole)
+		api.HandleFunc("DELETE /orgs/{id}/members/{uid}", handlers.RemoveOrgMember)
+		api.HandleFunc("POST /users/me/accept-terms", handlers.AcceptTerms)
+		api.HandleFunc("POST /users/me/phone", handlers.RequestPhoneVerification)
+		api.HandleFunc("POST /users/me/phone/verify", handlers.VerifyPhone)
+		fresh := api.Group("", mw.RequireFreshAuth)
+		fresh.HandleFunc("DELETE /users/me", handlers.DeleteCurrentUser)
+		fresh.HandleFunc("POST /users/me/data-export", handlers.RequestDataExport)
+		api.HandleFunc("GET /users/me/data-export/{id}", handlers.GetDataExport)
+		api.Group("", mw.RequireRole("admin")).HandleFunc("GET /users", handlers.ListUsers)
+		api.Handle("GET /events", SlowThreshold(math.MaxInt64)(http.HandlerFunc(live.Stream)))
+		api.Handle("POST /batch", NewBatch(mux))
+
+		admin := api.Group("/admin", mw.RequireRole("admin"))
+		admin.HandleFunc("POST /maintenance", handlers.SetMaintenance)
+		admin.HandleFunc("GET /stats", handlers.AdminStats)
+		admin.HandleFunc("POST /users", handlers.CreateUser)
+		admin.Handle("GET /users/export", SlowThreshold(math.MaxInt64)(http.HandlerFunc(handlers.ExportUsers)))
+		admin.HandleFunc("PUT /users/{id}/role", handlers.SetUserRole)
+		admin.HandleFunc("POST /users/{id}/suspend", handlers.SuspendUser)
+		admin.HandleFunc("DELETE /users/{id}/suspend", handlers.UnsuspendUser)
+		admin.HandleFunc("POST /users/{id}/revoke-tokens", handlers.RevokeUserTokens)
+		admin.HandleFunc("GET /users/{id}/activity", handlers.GetUserActivity)
+		admin.HandleFunc("GET /users/{id}/notifications", handlers.GetUserNotifications)
+		admin.HandleFunc("DELETE /users/{id}/sessions/{sid}", handlers.RevokeUserSession)
+		admin.HandleFunc("PUT /users/{id}/features/{name}", handlers.SetUserFeature)
+		admin.HandleFunc("GET /lockouts", handlers.ListLockouts)
+		admin.HandleFunc("POST /lockouts/{userID}/unlock", handlers.UnlockUser)
+		admin.HandleFunc("GET /features", handlers.ListFeatureFlags)
+		admin.HandleFunc("PUT /features/{name}", handlers.SetFeatureFlag)
+		admin.HandleFunc("DELETE /features/{name}", handlers.ResetFeatureFlag)
+		admin.HandleFunc("GET /orgs", handlers.ListOrgs)
+		admin.HandleFunc("POST /orgs", handlers.AdminCreateOrg)
+		admin.HandleFunc("GET /orgs/{id}", handlers.GetOrg)
+		admin.HandleFunc("PATCH /orgs/{id}", handlers.UpdateOrg)
+		admin.HandleFunc("DELETE /orgs/{id}", handlers.AdminDeleteOrg)
+		admin.HandleFunc("GET /orgs/{id}/members", handlers.AdminListOrgMembers)
+		admin.HandleFunc("POST /orgs/{id}/members", handlers.AdminAddOrgMember)
+		admin.HandleFunc("DELETE /orgs/{id}/members/{uid}", handlers.AdminRemoveOrgMember)
+		admin.HandleFunc("GET /backup", handlers.Backup)
+		admin.HandleFunc("GET /security-events", handlers.ListSecurityEvents)
+		admin.HandleFunc("GET /webhooks", handlers.ListWebhooks)
+		admin.HandleFunc("POST /webhooks", handlers.CreateWebhook)
+		admin.HandleFunc("DELETE /webhooks/{id}", handlers.DeleteWebhook)
+		admin.HandleFunc("GET /webhooks/deliveries", handlers.ListWebhookDeliveries)
+		admin.HandleFunc("GET /webhooks/dead-letters", handlers.ListDeadWebhooks)
+		admin.HandleFunc("POST /webhooks/dead-letters/{id}/redrive", handlers.RedriveWebhook)
+
+		// Live events: the token may come in the first message instead of
+		// the Authorization header, so LiveHub applies Auth itself.
+		mux.Handle("GET "+v.Prefix+"/ws", Chain(rateLimits.Use("api", v.Prefix+"/ws"), rateLimits.PerRoute)(live))
+	}
+
+	// Proxied prefixes (PROXY_ROUTES): authenticated and limited like the
+	// API, but not part of it, so not in the OpenAPI document.
+	var proxied []string
+	for _, route := range cfg.Proxy.Routes {
+		p, err := NewProxy(route, cfg.Proxy.Secret)
+		if err != nil {
+			return nil, err
+		}
+		mux.Handle(p.Pattern(), Chain(mw.Auth, rateLimits.Use("api", route.Prefix+"/*"), rateLimits.PerRoute, mw.CSRFProtection)(p))
+		s.lifecycle.OnShutdown("proxy "+route.Prefix, stopHook(p.Close))
+		proxied = append(proxied, p.Pattern())
+	}
+
+	// gRPC on its own listener: h2c only, no public HTTP middleware.
+	if cfg.GRPCAddr != "" {
+		s.grpc = NewGRPCServer(cfg, st, checks, drain, &s.lifecycle, events, rateLimits)
+		s.GRPC = Trace(s.grpc)
+	}
+	if err := rateLimits.Err(mux.ServeMux); err != nil {
+		return nil, fmt.Errorf("rate limits: %w", err)
+	}
+
+	// Internal: on INTERNAL_ADDR if set (and refused here), else admin-only here
+	internalMux := NewInternalMux(mw, st, rateLimits.Limiters(), cfg.EnablePprof)
+	if cfg.InternalAddr == "" {
+		mux.Handle("GET /metrics", Chain(mw.Auth, mw.RequireRole("admin"))(internalMux))
+		if cfg.EnablePprof {
+			mux.Handle("/debug/", internalMux)
+		}
+	} else {
+		internalMux.Handle("POST /internal/drain", SlowThreshold(math.MaxInt64)(drain.PreStop(cfg.DrainDelay)))
+		s.Internal = Trace(accessLog.Wrap(mw.ErrorFormat(JSONFallbacks(internalMux))))
+	}
+
+	// Apply global middleware
+	// SSE streams are capped by SSE_MAX_STREAMS instead of holding a slot.
+	unlimited := []string{"/health", "/ready"}
+	for _, v := range apiVersions {
+		unlimited = append(unlimited, v.Prefix+"/events")
+	}
+	globalCL := NewConcurrencyLimiter("global", cfg.MaxConcurrent, cfg.ConcurrencyWait, unlimited...)
+	if err := OpenAPIErr(mux, documentedRoutes(), proxied...); err != nil {
+		return nil, fmt.Errorf("OpenAPI: %w", err)
+	}
+	handler := JSONFallbacks(mux.ServeMux)
+	if cfg.Static.Enabled {
+		handler = NewStatic(cfg.Static).Fallback(mux.ServeMux, handler)
+	}
+	handler = globalCL.Wrap(handler)
+	handler = mw.MaintenanceMode(handler)
+	handler = mw.CORS(handler)
+	handler = mw.SecurityHeaders(handler)
+	handler = drain.Wrap(handler)
+	handler = mw.ErrorFormat(handler)
+	if cfg.BodyLog.Enabled {
+		bodyLog, err := NewBodyLogger(cfg.BodyLog)
+		if err != nil {
+			return nil, err
+		}
+		handler = bodyLog.Wrap(handler)
+	}
+	handler = accessLog.Wrap(handler)
+	s.Handler = Trace(handler)
+
+	s.mw, s.maintenance, s.accessLog, s.rateLimits, s.loginFails = mw, maintenance, accessLog, rateLimits, loginFails
+	s.drain, s.live, s.router = drain, live, mux
+	return s, nil
+}
+
+// LogSummary logs what the running configuration enables.
+func (s *Server) LogSummary() {
+	cfg := s.cfg
+	log.Printf("  CORS origins: %v", cfg.AllowedOrigins)
+	log.Printf("  Access log: %s -> %s", cfg.AccessLogFormat, cfg.AccessLogOutput)
+	log.Printf("  Audit log: %s (keeping %d in memory)", cfg.AuditLogOutput, cfg.AuditLogRetention)
+	log.Printf("  Mail: %s (from %s)", cfg.MailDriver, cfg.MailFrom)
+	if cfg.SMS.Enabled() {
+		log.Printf("  SMS: %s (phone login on)", cfg.SMS.Driver)
+	}
+	if cfg.Environment == "development" {
+		log.Printf("  Email previews: /dev/emails/")
+	}
+	s.rateLimits.LogSummary()
+	if cfg.MaxConcurrent > 0 || cfg.MaxConcurrentAuth > 0 {
+		log.Printf("  Concurrency: %s requests, %s on auth routes (waiting up to %s)",
+			capacityString(cfg.MaxConcurrent), capacityString(cfg.MaxConcurrentAuth), cfg.ConcurrencyWait)
+	}
+	log.Printf("  Demo user: admin@example.com / admin123")
+	if cfg.MaintenanceMode {
+		log.Printf("  Maintenance mode: enabled")
+	}
+	if cfg.EnableH2C {
+		log.Printf("  h2c: enabled (HTTP/1.1 + cleartext HTTP/2)")
+	}
+	if pp := cfg.ProxyProtocol; pp.Enabled {
+		from := "every peer"
+		if len(pp.Allowed) > 0 {
+			from = strings.Join(pp.Allowed, ", ")
+		}
+		log.Printf("  PROXY protocol: v1/v2 headers from %s", from)
+	}
+	if cfg.EnableDocs {
+		log.Printf("  API explorer: /docs/")
+	}
+	if cfg.Static.Enabled {
+		from := "embedded"
+		if cfg.Static.Dir != "" {
+			from = cfg.Static.Dir
+		}
+		log.Printf("  Frontend: / (%s)", from)
+	}
+	if cfg.BodyLog.Enabled {
+		log.Printf("  Body log (debug): bodies up to %d bytes, redacting %s", cfg.BodyLog.MaxBytes, strings.Join(cfg.BodyLog.RedactFields, ", "))
+	}
+	for _, route := range cfg.Proxy.Routes {
+		log.Printf("  Proxy: %s/ -> %s", route.Prefix, route.Upstream)
+	}
+	for _, c := range cfg.InternalAuth.Callers {
+		scopes := c.Scopes
+		if len(scopes) == 0 {
+			scopes = defaultCallerScope
+		}
+		log.Printf("  Internal caller: %s as %s on %s", c.Name, c.Role, strings.Join(scopes, ", "))
+	}
+	log.Printf("  Live events: /api/v1/ws (max %d connections), /api/v1/events (max %d streams)", cfg.WSMaxConnections, cfg.SSEMaxStreams)
+}
+
+// capacityString is a ConcurrencyLimiter capacity for LogSummary.
+func capacityString(n int) string {
+	if n <= 0 {
+		return "unlimited"
+	}
+	return "max " + strconv.Itoa(n)
+}
+
+// Reload swaps the hot-reloadable subset of next (see Config.Reload) into
+// the running components. next must have passed Validate.
+func (s *Server) Reload(next *config.Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	effective, changed := s.cfg.Reload(next)
+	if len(changed) == 0 {
+		log.Printf("Reload: no reloadable changes")
+		return
+	}
+	s.mw.Reload(effective)
+	s.maintenance.SetMessage(effective.MaintenanceMessage)
+	if slices.Contains(changed, "MaintenanceMode") {
+		s.maintenance.Set(effective.MaintenanceMode, "")
+	}
+	s.accessLog.SetFilter(effective.AccessLogFilter, effective.SlowThreshold)
+	s.rateLimits.Reload(effective.RateLimitBuckets)
+	s.rateLimits.SetExemptions(effective.RateLimitExempt)
+	setTrustedProxies(effective.RateLimitExempt.TrustedProxies)
+	s.loginFails.SetLimit(effective.LoginFailureLimit, effective.LoginFailureWindow)
+	s.roles.Set(effective.Roles)
+	s.features.Set(effective.FeatureFlags)
+	s.cfg = effective
+	log.Printf("Reload: applied %s", strings.Join(changed, ", "))
+}
+
+// LogsToFiles reports whether the access or audit log is written to a
+// file, which ReopenLogs reopens after rotation.
+func (s *Server) LogsToFiles() bool { return s.accessFile != nil || s.auditFile != nil }
+
+// ReopenLogs reopens the access and audit log files.
+func (s *Server) ReopenLogs() {
+	if s.accessFile != nil {
+		if err := s.accessFile.Reopen(); err != nil {
+			log.Printf("Access log reopen: %v", err)
+		}
+	}
+	if s.auditFile != nil {
+		if err := s.auditFile.Reopen(); err != nil {
+			log.Printf("Audit log reopen: %v", err)
+		}
+	}
+}
+
+// Drain fails readiness and new requests (see Drain) ahead of shutdown.
+// It returns how long ago draining began: more than 0 when POST
+// /internal/drain started it.
+func (s *Server) Drain() time.Duration {
+	if s.drain.Start() {
+		return 0
+	}
+	return s.drain.Elapsed()
+}
+
+// Ready reports whether the warmup is over and the critical /ready checks
+// pass, as a load balancer would see it.
+func (s *Server) Ready(ctx context.Context) bool {
+	if done, err := s.lifecycle.warmupState(); !done || err != nil {
+		return false
+	}
+	_, status := s.checks.Run(ctx)
+	return status != StatusUnhealthy
+}
+
+// InFlight is the number of requests being served.
+func (s *Server) InFlight() int64 { return s.drain.InFlight() }
+
+// CloseStreams ends gRPC Health/Watch streams and live connections, which
+// http.Server.Shutdown would otherwise wait for. Call it before Shutdown.
+func (s *Server) CloseStreams(ctx context.Context) {
+	if s.grpc != nil {
+		s.grpc.Stop()
+	}
+	if err := s.live.Close(ctx); err != nil {
+		log.Printf("Live: dropped connections that did not close: %v", err)
+	}
+}
+
+// OnShutdown registers a hook for Close to run before the hooks of the
+// components New built; see Lifecycle.
+func (s *Server) OnShutdown(name string, fn func(ctx context.Context) error) {
+	s.lifecycle.OnShutdown(name, fn)
+}
+
+// OnWarmup registers a warmup task whose failure stops the server, to run
+// with those of the components New built. Register it before Warmup.
+func (s *Server) OnWarmup(name string, fn func(ctx context.Context) error) {
+	s.lifecycle.OnWarmup(name, fn)
+}
+
+// OnWarmupOptional registers a warmup task whose failure is only logged.
+func (s *Server) OnWarmupOptional(name string, fn func(ctx context.Context) error) {
+	s.lifecycle.OnWarmupOptional(name, fn)
+}
+
+// Warmup runs the warmup tasks, each for up to WARMUP_TIMEOUT, and
+// returns the errors of the fatal ones that failed; see Lifecycle. Until
+// it returns, and after such an error, /ready answers 503. Call it once
+// the listeners are serving, so probes see the server warming up.
+func (s *Server) Warmup(ctx context.Context) error {
+	s.mu.Lock()
+	timeout := s.cfg.WarmupTimeout
+	s.mu.Unlock()
+	return s.lifecycle.Warmup(ctx, timeout)
+}
+
+// Close runs the shutdown hooks once the HTTP servers are shut down,
+// within what is left of ctx: it stops the background workers and closes
+// the log files. It returns the errors of the hooks that failed.
+func (s *Server) Close(ctx context.Context) error {
+	return s.lifecycle.Shutdown(ctx)
+}
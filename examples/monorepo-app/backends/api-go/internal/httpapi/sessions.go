@@ -0,0 +1,106 @@
+package httpapi
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/auth"
+)
+
+// nextSession is the session of a refresh token issued to user at now:
+// a new one for a login (prev nil), else prev carried through rotation.
+//
+// With REFRESH_SLIDING (the default) a session lasts REFRESH_TOKEN_TTL
+// past its last refresh, up to REFRESH_MAX_SESSION_AGE after the login,
+// which no refresh extends. Without it, rotation keeps the expiry and the
+// session ends REFRESH_TOKEN_TTL after the login however active it is.
+func (h *Handlers) nextSession(user *User, prev *Session, now time.Time) Session {
+	var s Session
+	switch {
+	case prev == nil:
+		lifetime := h.cfg.RefreshTokenTTL
+		if h.cfg.RefreshSliding {
+			lifetime = h.cfg.MaxSessionAge
+		}
+		s = Session{ID: auth.GenerateID(), UserID: user.ID, StartedAt: now, Deadline: now.Add(lifetime)}
+		s.ExpiresAt = now.Add(h.cfg.RefreshTokenTTL)
+	case h.cfg.RefreshSliding:
+		s = *prev
+		s.ExpiresAt = now.Add(h.cfg.RefreshTokenTTL)
+	default:
+		s = *prev
+	}
+	s.RefreshedAt = now
+	if s.ExpiresAt.After(s.Deadline) {
+		s.ExpiresAt = s.Deadline // the cap wins over the sliding window
+	}
+	return s
+}
+
+// pastLifetime reports whether a session started at start is older than
+// MAX_SESSION_LIFETIME, lifetime; never when it is 0 (unset).
+func pastLifetime(start time.Time, lifetime time.Duration) bool {
+	return lifetime > 0 && auth.Now().Sub(start) > lifetime
+}
+
+// ListSessions lists the caller's signed-in sessions, oldest first, with
+// when each expires unless refreshed and its absolute deadline, the
+// earlier of the refresh cap and MAX_SESSION_LIFETIME.
+func (h *Handlers) ListSessions(w http.ResponseWriter, r *http.Request) {
+	sessions := h.store.UserSessions(r.Context().Value(ctxUserID).(string))
+	if lifetime := h.cfg.MaxSessionLifetime; lifetime > 0 {
+		for i, s := range sessions {
+			if end := s.StartedAt.Add(lifetime); end.Before(s.Deadline) {
+				sessions[i].Deadline = end
+				if s.ExpiresAt.After(end) {
+					sessions[i].ExpiresAt = end
+				}
+			}
+		}
+	}
+	respond(w, r, http.StatusOK, SessionList{Sessions: sessions, Total: len(sessions)})
+}
+
+// RevokeMySession signs the caller out of one of their sessions, such as
+// a lost device's: its refresh and CSRF tokens stop working at once, and
+// so do its access tokens (by their sid claim). A session ID that is not
+// the caller's is 404, like one that does not exist. Revoking the session
+// of the request itself is allowed; X-Reauth-Required then tells the
+// client its tokens are gone.
+func (h *Handlers) RevokeMySession(w http.ResponseWriter, r *http.Request) {
+	h.revokeSession(w, r, r.Context().Value(ctxUserID).(string), r.PathValue("id"), "user")
+}
+
+// RevokeUserSession is RevokeMySession for an admin, on any user.
+func (h *Handlers) RevokeUserSession(w http.ResponseWriter, r *http.Request) {
+	userID, sessionID := r.PathValue("id"), r.PathValue("sid")
+	if _, err := h.store.GetUserByID(userID); err != nil {
+		writeUserError(w, r, err)
+		return
+	}
+	if !h.revokeSession(w, r, userID, sessionID, "admin") {
+		return
+	}
+	AdminAction.Publish(eventContext(r), h.events, AdminActionEvent{
+		Action: "user_revoke_session", Details: map[string]string{"user_id": userID, "session_id": sessionID},
+	})
+}
+
+// revokeSession revokes sessionID of userID and answers 204, or 404 when
+// there is no such live session.
+func (h *Handlers) revokeSession(w http.ResponseWriter, r *http.Request, userID, sessionID, reason string) bool {
+	// An access token issued now expires within ACCESS_TOKEN_TTL; past
+	// that the session's ID need not be remembered.
+	if !h.store.RevokeSession(userID, sessionID, auth.Now().Add(h.cfg.AccessTokenTTL)) {
+		writeErrorCode(w, r, http.StatusNotFound, api.ErrCodeNotFound, "session not found")
+		return false
+	}
+	SessionRevoked.Publish(eventContext(r), h.events, SessionEvent{UserID: userID, SessionID: sessionID, Reason: reason})
+	if claims, _ := r.Context().Value(ctxClaims).(*auth.Claims); claims != nil && claims.SessionID == sessionID {
+		dropTokenExpiry(w.Header())
+		w.Header().Set("X-Reauth-Required", "true")
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
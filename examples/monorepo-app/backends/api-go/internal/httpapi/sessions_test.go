@@ -0,0 +1,233 @@
+package httpapi_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+	"github.com/your-org/your-app/backends/api-go/internal/httpapi"
+	"github.com/your-org/your-app/backends/api-go/raijintest"
+)
+
+// slidingSession logs in on a server with a 1h refresh window and a 3h
+// session cap, under a manual clock started at t0.
+func slidingSession(t *testing.T, sliding bool) (*raijintest.Server, *raijintest.Clock, api.AuthResponseV2) {
+	t.Helper()
+	clock := raijintest.NewClock(time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC))
+	srv := raijintest.NewServer(t, raijintest.WithClock(clock.Now), raijintest.WithConfig(func(cfg *config.Config) {
+		cfg.RefreshTokenTTL = time.Hour
+		cfg.MaxSessionAge = 3 * time.Hour
+		cfg.RefreshSliding = sliding
+	}))
+	srv.CreateUser(t, "slide@example.com", raijintest.Password, "user")
+	var session api.AuthResponseV2
+	wantStatus(t, send(t, srv.Client(), "POST", srv.URL+"/api/v2/auth/login",
+		api.LoginRequest{Email: "slide@example.com", Password: raijintest.Password}, &session), http.StatusOK)
+	return srv, clock, session
+}
+
+// refresh rotates session's refresh token and returns the new session.
+func refresh(t *testing.T, srv *raijintest.Server, session api.AuthResponseV2) api.AuthResponseV2 {
+	t.Helper()
+	var next api.AuthResponseV2
+	wantStatus(t, send(t, srv.Client(), "POST", srv.URL+"/api/v2/auth/refresh",
+		api.RefreshRequest{RefreshToken: session.RefreshToken}, &next), http.StatusOK)
+	return next
+}
+
+// onlySession returns the one session GET /users/me/sessions lists.
+func onlySession(t *testing.T, srv *raijintest.Server, session api.AuthResponseV2) httpapi.Session {
+	t.Helper()
+	var list httpapi.SessionList
+	client := &http.Client{Transport: bearer{session.AccessToken}}
+	wantStatus(t, send(t, client, "GET", srv.URL+"/api/v1/users/me/sessions", nil, &list), http.StatusOK)
+	if len(list.Sessions) != 1 {
+		t.Fatalf("%d sessions", len(list.Sessions))
+	}
+	return list.Sessions[0]
+}
+
+// bearer authenticates every request with an access token.
+type bearer struct{ token string }
+
+func (b bearer) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+b.token)
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// Each refresh extends the session by the window from now, until the
+// extension would pass the cap: the cap wins, and the session ends there.
+func TestSlidingRefresh(t *testing.T) {
+	srv, clock, session := slidingSession(t, true)
+	t0 := clock.Now()
+	first := onlySession(t, srv, session)
+	if !first.StartedAt.Equal(t0) || !first.ExpiresAt.Equal(t0.Add(time.Hour)) || !first.Deadline.Equal(t0.Add(3*time.Hour)) {
+		t.Fatalf("after login: %+v", first)
+	}
+
+	for i, want := range []struct {
+		expiresAt time.Time
+		in        time.Duration // refresh_expires_in
+	}{
+		{t0.Add(50*time.Minute + time.Hour), time.Hour},
+		{t0.Add(100*time.Minute + time.Hour), time.Hour},
+		{t0.Add(3 * time.Hour), 30 * time.Minute}, // 150m + 1h would pass the 3h cap
+	} {
+		clock.Advance(50 * time.Minute)
+		session = refresh(t, srv, session)
+		s := onlySession(t, srv, session)
+		if s.ID != first.ID || !s.StartedAt.Equal(t0) || !s.Deadline.Equal(first.Deadline) || !s.RefreshedAt.Equal(clock.Now()) {
+			t.Errorf("refresh %d: the family changed: %+v", i+1, s)
+		}
+		if !s.ExpiresAt.Equal(want.expiresAt) {
+			t.Errorf("refresh %d: expires %s, want %s", i+1, s.ExpiresAt, want.expiresAt)
+		}
+		if got := time.Duration(session.RefreshExpiresIn) * time.Second; got != want.in {
+			t.Errorf("refresh %d: refresh_expires_in %s, want %s", i+1, got, want.in)
+		}
+	}
+
+	// Refreshing right before the cap cannot push it out either.
+	clock.Advance(29 * time.Minute)
+	session = refresh(t, srv, session)
+	if s := onlySession(t, srv, session); !s.ExpiresAt.Equal(first.Deadline) {
+		t.Errorf("near the cap: expires %s, want %s", s.ExpiresAt, first.Deadline)
+	}
+	clock.Advance(time.Minute)
+	resp := send(t, srv.Client(), "POST", srv.URL+"/api/v2/auth/refresh", api.RefreshRequest{RefreshToken: session.RefreshToken}, nil)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("past the cap: %d", resp.StatusCode)
+	}
+}
+
+// Without REFRESH_SLIDING, rotation keeps the login's expiry.
+func TestFixedRefresh(t *testing.T) {
+	srv, clock, session := slidingSession(t, false)
+	t0 := clock.Now()
+	clock.Advance(50 * time.Minute)
+	session = refresh(t, srv, session)
+	s := onlySession(t, srv, session)
+	if !s.ExpiresAt.Equal(t0.Add(time.Hour)) || !s.Deadline.Equal(t0.Add(time.Hour)) {
+		t.Errorf("expires %s, deadline %s; want both at %s", s.ExpiresAt, s.Deadline, t0.Add(time.Hour))
+	}
+	clock.Advance(10 * time.Minute)
+	resp := send(t, srv.Client(), "POST", srv.URL+"/api/v2/auth/refresh", api.RefreshRequest{RefreshToken: session.RefreshToken}, nil)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("an hour after the login: %d", resp.StatusCode)
+	}
+}
+
+// Authenticated responses count down the access token's seconds with the
+// clock and, under MAX_SESSION_LIFETIME, give the session's hard end.
+func TestTokenExpiryHeaders(t *testing.T) {
+	clock := raijintest.NewClock(time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC))
+	t0 := clock.Now()
+	srv := raijintest.NewServer(t, raijintest.WithClock(clock.Now), raijintest.WithConfig(func(cfg *config.Config) {
+		cfg.AccessTokenTTL = 15 * time.Minute
+		cfg.MaxSessionLifetime = 8 * time.Hour
+	}))
+	srv.CreateUser(t, "expiry@example.com", raijintest.Password, "user")
+	var session api.AuthResponseV2
+	wantStatus(t, send(t, srv.Client(), "POST", srv.URL+"/api/v2/auth/login",
+		api.LoginRequest{Email: "expiry@example.com", Password: raijintest.Password}, &session), http.StatusOK)
+	client := &http.Client{Transport: bearer{session.AccessToken}}
+
+	// Cacheable responses carry no countdown.
+	resp := send(t, client, "GET", srv.URL+"/api/v1/users/me", nil, nil)
+	if resp.Header.Get("ETag") == "" || resp.Header.Get("X-Token-Expires-In") != "" || resp.Header.Get("X-Session-Expires-At") != "" {
+		t.Errorf("with an ETag: %v", resp.Header)
+	}
+
+	for _, tt := range []struct {
+		advance time.Duration
+		want    string
+	}{
+		{0, "900"},
+		{2 * time.Minute, "780"},
+		{12*time.Minute + 59*time.Second, "1"},
+		{time.Second, "0"}, // valid to the second
+	} {
+		clock.Advance(tt.advance)
+		resp := send(t, client, "GET", srv.URL+"/api/v1/users/me/features", nil, nil)
+		wantStatus(t, resp, http.StatusOK)
+		if got := resp.Header.Get("X-Token-Expires-In"); got != tt.want {
+			t.Errorf("at %s: X-Token-Expires-In %q, want %q", clock.Now().Sub(t0), got, tt.want)
+		}
+		if got, want := resp.Header.Get("X-Session-Expires-At"), t0.Add(8*time.Hour).Format(time.RFC3339); got != want {
+			t.Errorf("at %s: X-Session-Expires-At %q, want %q", clock.Now().Sub(t0), got, want)
+		}
+	}
+	clock.Advance(time.Second)
+	resp = send(t, client, "GET", srv.URL+"/api/v1/users/me/features", nil, nil)
+	if resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("X-Token-Expires-In") != "" {
+		t.Errorf("expired: %d, X-Token-Expires-In %q", resp.StatusCode, resp.Header.Get("X-Token-Expires-In"))
+	}
+
+	// A token that is not tied to a session has no session end to give.
+	resp = send(t, srv.ClientAs(t, srv.CreateUser(t, "nosession@example.com", raijintest.Password, "user")), "GET", srv.URL+"/api/v1/users/me/features", nil, nil)
+	if resp.Header.Get("X-Token-Expires-In") == "" || resp.Header.Get("X-Session-Expires-At") != "" {
+		t.Errorf("sessionless token: %v", resp.Header)
+	}
+	resp = send(t, srv.Client(), "GET", srv.URL+"/health", nil, nil)
+	if resp.Header.Get("X-Token-Expires-In") != "" {
+		t.Error("an anonymous response carries X-Token-Expires-In")
+	}
+}
+
+func TestTokenExpiryHeadersOff(t *testing.T) {
+	srv := raijintest.NewServer(t, raijintest.WithConfig(func(cfg *config.Config) {
+		cfg.TokenExpiryHeaders = false
+		cfg.MaxSessionLifetime = 8 * time.Hour
+	}))
+	resp := send(t, srv.LoginAs(t, "user"), "GET", srv.URL+"/api/v1/users/me/features", nil, nil)
+	wantStatus(t, resp, http.StatusOK)
+	for _, h := range []string{"X-Token-Expires-In", "X-Session-Expires-At"} {
+		if got := resp.Header.Get(h); got != "" {
+			t.Errorf("TOKEN_EXPIRY_HEADERS=false: %s %q", h, got)
+		}
+	}
+}
+
+// A revocation refuses every access token issued in its second, even
+// after it, and accepts those of the next; a login right after it works,
+// its tokens dated at the next second.
+func TestTokenRevokedBoundary(t *testing.T) {
+	t0 := time.Date(2026, 3, 1, 9, 0, 0, 400_000_000, time.UTC)
+	clock := raijintest.NewClock(t0)
+	srv := raijintest.NewServer(t, raijintest.WithClock(clock.Now))
+	user := srv.CreateUser(t, "revoked@example.com", raijintest.Password, "user")
+	me := func(token string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest("GET", srv.URL+"/api/v1/users/me/features", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return do(t, req)
+	}
+
+	before := srv.Token(t, user) // iat 09:00:00
+	wantStatus(t, send(t, srv.LoginAs(t, "admin"), "POST", srv.URL+"/api/v1/admin/users/"+user.ID+"/revoke-tokens", nil, nil), http.StatusOK)
+	clock.Advance(599 * time.Millisecond) // 09:00:00.999
+	sameSecond := srv.Token(t, user)
+	var session api.AuthResponseV2
+	wantStatus(t, send(t, srv.Client(), "POST", srv.URL+"/api/v2/auth/login",
+		api.LoginRequest{Email: user.Email, Password: raijintest.Password}, &session), http.StatusOK)
+	clock.Advance(time.Millisecond) // 09:00:01
+	nextSecond := srv.Token(t, user)
+
+	for _, tt := range []struct {
+		name, token string
+		want        int
+	}{
+		{"issued before the revocation", before, http.StatusUnauthorized},
+		{"issued later in its second", sameSecond, http.StatusUnauthorized},
+		{"issued the next second", nextSecond, http.StatusOK},
+		{"logged in again at once", session.AccessToken, http.StatusOK},
+	} {
+		status, code := me(tt.token)
+		if status != tt.want || (tt.want == http.StatusUnauthorized && code != api.ErrCodeTokenRevoked) {
+			t.Errorf("%s: %d %s, want %d", tt.name, status, code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+)
+
+// SMSSender sends a text message to an E.164 phone number. Send blocks
+// until the gateway has accepted the message.
+type SMSSender interface {
+	Send(ctx context.Context, to, body string) error
+}
+
+// NewSMSSender returns the SMS_DRIVER implementation, or nil when SMS is
+// off.
+func NewSMSSender(cfg *config.Config, out *Outbound) SMSSender {
+	switch cfg.SMS.Driver {
+	case "":
+		return nil
+	case "http":
+		return &HTTPSMS{URL: cfg.SMS.URL, Token: cfg.SMS.Token, Timeout: cfg.SMS.Timeout, client: out.Client("sms", cfg.SMS.Timeout)}
+	}
+	return LogSMS{}
+}
+
+// HTTPSMS posts {"to", "body"} as JSON to a gateway (a provider's API or
+// a small relay in front of it), with Token as a Bearer token when set.
+// Any 2xx is success.
+type HTTPSMS struct {
+	URL     string
+	Token   string
+	Timeout time.Duration
+	client  *http.Client
+}
+
+func (s *HTTPSMS) Send(ctx context.Context, to, body string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+	payload, _ := json.Marshal(map[string]string{"to": to, "body": body})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("sms: gateway answered %s", resp.Status)
+	}
+	return nil
+}
+
+// LogSMS writes messages to the server log instead of sending them, so
+// the phone flows can be followed from the console.
+type LogSMS struct{}
+
+func (LogSMS) Send(_ context.Context, to, body string) error {
+	log.Printf("SMS to=%s\n%s", to, body)
+	return nil
+}
@@ -0,0 +1,122 @@
+package httpapi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+)
+
+// The frontend served with ENABLE_STATIC: its build output, copied here
+// before go build. The placeholder index.html only says so.
+//
+//go:embed static
+var staticFS embed.FS
+
+// staticCSP is merged into the API's CSP for HTML pages: what a built SPA
+// needs besides the scripts, styles, images and fetches to this origin
+// the API policy already allows.
+const staticCSP = "manifest-src 'self'; worker-src 'self'; media-src 'self'"
+
+// hashedAsset matches file names with a content hash (app-3f9a1c2e.js,
+// index-B5x_Qz9a.css): a new build renames them, so they can be cached
+// for good. Names without a digit in the hash are not taken for one.
+var hashedAsset = regexp.MustCompile(`[.-]([A-Za-z0-9_-]*[0-9][A-Za-z0-9_-]*)\.[A-Za-z0-9]+$`)
+
+// Static serves a single-page app: files as they are, and index.html for
+// any other GET outside the API, so client-side routes survive a reload.
+type Static struct {
+	files fs.FS
+	page  func(http.Handler) http.Handler // the CSP for index.html
+}
+
+// NewStatic serves STATIC_DIR, or the embedded build when it is not set.
+func NewStatic(cfg config.StaticConfig) *Static {
+	var files fs.FS = os.DirFS(cfg.Dir)
+	if cfg.Dir == "" {
+		sub, err := fs.Sub(staticFS, "static")
+		if err != nil {
+			panic(err) // the embed pattern guarantees the directory
+		}
+		files = sub
+	}
+	csp := ParseCSP(staticCSP)
+	if cfg.CSPExtra != "" {
+		csp.Merge(ParseCSP(cfg.CSPExtra))
+	}
+	return &Static{files: files, page: RelaxCSP(csp.String())}
+}
+
+// Fallback serves the app for GET and HEAD requests mux has no route for,
+// and hands the rest to next. Paths under /api never reach the app: an
+// unknown API route stays a JSON 404.
+func (s *Static) Fallback(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method != http.MethodGet && r.Method != http.MethodHead) ||
+			r.URL.Path == "/api" || strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if _, pattern := mux.Handler(r); pattern != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		s.ServeHTTP(w, r)
+	})
+}
+
+// ServeHTTP serves the file at r's path. Missing files with an extension
+// are assets and get a 404; other paths get index.html. Hashed assets are
+// cached for a year, everything else is revalidated every time.
+func (s *Static) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+	if name == "" || name == "index.html" {
+		s.serveIndex(w, r)
+		return
+	}
+	data, err := fs.ReadFile(s.files, name)
+	switch {
+	case err == nil:
+		if hashedAsset.MatchString(name) {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+	case path.Ext(name) != "" && !isDir(s.files, name):
+		writeErrorCode(w, r, http.StatusNotFound, api.ErrCodeNotFound, "no file "+r.URL.Path)
+	default:
+		s.serveIndex(w, r)
+	}
+}
+
+// serveIndex sends index.html with the page CSP, never cached without
+// revalidation: it names the current build's hashed assets.
+func (s *Static) serveIndex(w http.ResponseWriter, r *http.Request) {
+	s.page(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := fs.ReadFile(s.files, "index.html")
+		if err != nil { // removed from STATIC_DIR since startup
+			writeErrorCode(w, r, http.StatusNotFound, api.ErrCodeNotFound, "no index.html")
+			return
+		}
+		sum := sha256.Sum256(data)
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:8])+`"`)
+		w.Header().Set("Cache-Control", "no-cache")
+		http.ServeContent(w, r, "index.html", time.Time{}, bytes.NewReader(data))
+	})).ServeHTTP(w, r)
+}
+
+func isDir(files fs.FS, name string) bool {
+	st, err := fs.Stat(files, name)
+	return err == nil && st.IsDir()
+}
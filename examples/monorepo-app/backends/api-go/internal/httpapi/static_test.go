@@ -0,0 +1,169 @@
+package httpapi_test
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+	"github.com/your-org/your-app/backends/api-go/raijintest"
+)
+
+const testIndex = `<!doctype html><title>App</title><script src="/assets/app-3f9a1c2e.js"></script>`
+
+// staticServer serves the API with ENABLE_STATIC over a small build in
+// STATIC_DIR.
+func staticServer(t *testing.T) *raijintest.Server {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"index.html":                testIndex,
+		"assets/app-3f9a1c2e.js":    "console.log('app')",
+		"assets/index-B5x_Qz9a.css": "body{}",
+		"assets/logo.svg":           "<svg/>",
+		"robots.txt":                "User-agent: *",
+	} {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return raijintest.NewServer(t, raijintest.WithConfig(func(cfg *config.Config) {
+		cfg.Static = config.StaticConfig{Enabled: true, Dir: dir, CSPExtra: "connect-src https://telemetry.example"}
+	}))
+}
+
+// fetch sends method to url and returns the response and its body.
+func fetch(t *testing.T, method, url string, header ...string) (*http.Response, string) {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+func TestStaticFiles(t *testing.T) {
+	srv := staticServer(t)
+	tests := []struct {
+		path, contentType, cache string
+		body                     string // "" for index.html
+	}{
+		{"/", "text/html", "no-cache", ""},
+		{"/index.html", "text/html", "no-cache", ""},
+		{"/dashboard/settings", "text/html", "no-cache", ""}, // a client-side route
+		{"/users/42", "text/html", "no-cache", ""},
+		{"/assets", "text/html", "no-cache", ""}, // a directory
+		{"/assets/app-3f9a1c2e.js", "text/javascript", "public, max-age=31536000, immutable", "console.log('app')"},
+		{"/assets/index-B5x_Qz9a.css", "text/css", "public, max-age=31536000, immutable", "body{}"},
+		{"/assets/logo.svg", "image/svg+xml", "no-cache", "<svg/>"},
+		{"/robots.txt", "text/plain", "no-cache", "User-agent: *"},
+		{"/../../etc/passwd.txt", "application/json", "", ""}, // kept inside STATIC_DIR: a missing asset
+	}
+	for _, tt := range tests {
+		resp, body := fetch(t, "GET", srv.URL+tt.path)
+		want := tt.body
+		if want == "" && strings.HasPrefix(tt.contentType, "text/html") {
+			want = testIndex
+		}
+		if tt.contentType == "application/json" {
+			if resp.StatusCode != http.StatusNotFound || !strings.Contains(body, `"not_found"`) {
+				t.Errorf("%s: %d %s", tt.path, resp.StatusCode, body)
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK || body != want {
+			t.Errorf("%s: %d %q, want %q", tt.path, resp.StatusCode, body, want)
+		}
+		if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, tt.contentType) {
+			t.Errorf("%s: Content-Type %q, want %s", tt.path, ct, tt.contentType)
+		}
+		if cc := resp.Header.Get("Cache-Control"); cc != tt.cache {
+			t.Errorf("%s: Cache-Control %q, want %q", tt.path, cc, tt.cache)
+		}
+	}
+
+	// A missing asset is a 404, not the app.
+	for _, path := range []string{"/assets/app-00000000.js", "/favicon.ico"} {
+		if resp, body := fetch(t, "GET", srv.URL+path); resp.StatusCode != http.StatusNotFound || strings.Contains(body, "<title>") {
+			t.Errorf("%s: %d %s", path, resp.StatusCode, body)
+		}
+	}
+
+	resp, _ := fetch(t, "GET", srv.URL+"/")
+	etag := resp.Header.Get("ETag")
+	if resp, _ := fetch(t, "GET", srv.URL+"/dashboard", "If-None-Match", etag); etag == "" || resp.StatusCode != http.StatusNotModified {
+		t.Errorf("index.html revalidated with ETag %q: %d", etag, resp.StatusCode)
+	}
+	if resp, body := fetch(t, "HEAD", srv.URL+"/dashboard"); resp.StatusCode != http.StatusOK || body != "" || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Errorf("HEAD: %d %q", resp.StatusCode, body)
+	}
+}
+
+// The API always wins: unknown API routes and methods get the JSON 404 or
+// 405, never index.html.
+func TestStaticAPIFirst(t *testing.T) {
+	srv := staticServer(t)
+	for _, tt := range []struct {
+		method, path string
+		want         int
+	}{
+		{"GET", "/api/v1/unknown", http.StatusNotFound},
+		{"GET", "/api/v2/unknown", http.StatusNotFound},
+		{"GET", "/api/v1/users/me", http.StatusUnauthorized},
+		{"GET", "/api", http.StatusNotFound},
+		{"GET", "/api/", http.StatusNotFound},
+		{"GET", "/health", http.StatusOK},
+		{"POST", "/dashboard", http.StatusNotFound},
+		{"POST", "/health", http.StatusMethodNotAllowed},
+	} {
+		resp, body := fetch(t, tt.method, srv.URL+tt.path)
+		if resp.StatusCode != tt.want || !strings.Contains(resp.Header.Get("Content-Type"), "json") || strings.Contains(body, "<title>") {
+			t.Errorf("%s %s: %d %s %q, want %d JSON", tt.method, tt.path, resp.StatusCode, resp.Header.Get("Content-Type"), body, tt.want)
+		}
+	}
+}
+
+// HTML pages get the API's CSP widened for an app and by STATIC_CSP_EXTRA;
+// everything else keeps the API's.
+func TestStaticCSP(t *testing.T) {
+	srv := staticServer(t)
+	resp, _ := fetch(t, "GET", srv.URL+"/dashboard")
+	page := resp.Header.Get("Content-Security-Policy")
+	for _, want := range []string{"default-src 'none'", "script-src 'self'", "manifest-src 'self'", "worker-src 'self'", "media-src 'self'", "connect-src 'self' https://telemetry.example", "frame-ancestors 'none'"} {
+		if !strings.Contains(page, want) {
+			t.Errorf("index.html CSP %q has no %q", page, want)
+		}
+	}
+	for _, path := range []string{"/health", "/assets/app-3f9a1c2e.js"} {
+		resp, _ := fetch(t, "GET", srv.URL+path)
+		if csp := resp.Header.Get("Content-Security-Policy"); csp == "" || strings.Contains(csp, "manifest-src") || strings.Contains(csp, "telemetry") {
+			t.Errorf("%s: CSP %q", path, csp)
+		}
+	}
+}
+
+// Without STATIC_DIR the embedded build is served, and without
+// ENABLE_STATIC nothing is.
+func TestStaticEmbedded(t *testing.T) {
+	srv := raijintest.NewServer(t, raijintest.WithConfig(func(cfg *config.Config) { cfg.Static.Enabled = true }))
+	if resp, body := fetch(t, "GET", srv.URL+"/dashboard"); resp.StatusCode != http.StatusOK || !strings.Contains(body, "<html") {
+		t.Errorf("embedded: %d %.100s", resp.StatusCode, body)
+	}
+	srv = raijintest.NewServer(t)
+	if resp, body := fetch(t, "GET", srv.URL+"/dashboard"); resp.StatusCode != http.StatusNotFound || strings.Contains(body, "<html") {
+		t.Errorf("ENABLE_STATIC=false: %d %.100s", resp.StatusCode, body)
+	}
+}
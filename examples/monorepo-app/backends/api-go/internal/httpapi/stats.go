@@ -0,0 +1,61 @@
+package httpapi
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+)
+
+// AdminStats is the dashboard summary of GET /admin/stats. LockedAccounts
+// are the emails locked out by LOGIN_FAILURE_LIMIT on this instance;
+// LoginsToday counts since midnight UTC, as far back as the audit log
+// goes (AUDIT_LOG_RETENTION).
+type AdminStats struct {
+	store.UserCounts
+	ActiveSessions int       `json:"active_sessions"`
+	LoginsToday    int       `json:"logins_today"`
+	LockedAccounts int       `json:"locked_accounts"`
+	GeneratedAt    time.Time `json:"generated_at"` // when these were computed; the cache is ADMIN_STATS_CACHE_TTL
+}
+
+// StatsCache computes AdminStats with the store's aggregate methods and
+// keeps them for ttl, so a dashboard polling every few seconds costs a
+// handful of queries per ttl rather than per request. Concurrent callers
+// wait for one computation instead of each running their own.
+type StatsCache struct {
+	store      store.Store
+	loginFails *RateLimiter
+	ttl        time.Duration
+
+	mu    sync.Mutex
+	stats AdminStats
+}
+
+func NewStatsCache(st store.Store, loginFails *RateLimiter, ttl time.Duration) *StatsCache {
+	return &StatsCache{store: st, loginFails: loginFails, ttl: ttl}
+}
+
+// Get returns the cached stats, computing them again once they are older
+// than ttl.
+func (c *StatsCache) Get() AdminStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.stats.GeneratedAt.IsZero() && time.Since(c.stats.GeneratedAt) < c.ttl {
+		return c.stats
+	}
+	now := time.Now().UTC()
+	c.stats = AdminStats{
+		UserCounts:     c.store.CountUsers(now),
+		ActiveSessions: c.store.CountSessions(),
+		LoginsToday:    c.store.CountSecurityEvents(SecurityEventFilter{Type: EventLogin, Since: now.Truncate(24 * time.Hour)}),
+		LockedAccounts: c.loginFails.Tripped(),
+		GeneratedAt:    now,
+	}
+	return c.stats
+}
+
+func (h *Handlers) AdminStats(w http.ResponseWriter, r *http.Request) {
+	respond(w, r, http.StatusOK, h.stats.Get())
+}
@@ -0,0 +1,63 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/auth"
+)
+
+type AcceptTermsRequest struct {
+	AcceptTerms bool `json:"accept_terms"`
+}
+
+// termsExempt lists the paths a user who has not accepted the current
+// terms can still use (see Middleware.Auth).
+var termsExempt = map[string]bool{
+	"/api/v1/users/me/accept-terms": true,
+}
+
+// acceptTerms records that userID accepted the current TERMS_VERSION and
+// PRIVACY_VERSION from r's client IP.
+func (h *Handlers) acceptTerms(r *http.Request, userID string) (*User, error) {
+	rec := api.TermsAcceptance{
+		TermsVersion: h.cfg.Terms.Version, PrivacyVersion: h.cfg.Terms.PrivacyVersion,
+		AcceptedAt: auth.Now().UTC(), IP: clientIP(r),
+	}
+	user, err := h.store.UpdateUser(userID, func(u *User) {
+		// Clip: the copy UpdateUser hands fn shares the slice with readers.
+		u.TermsAccepted = append(slices.Clip(u.TermsAccepted), rec)
+	})
+	if err == nil {
+		TermsAccepted.Publish(eventContext(r), h.events, UserEvent{User: *user})
+	}
+	return user, err
+}
+
+// AcceptTerms records the caller's acceptance of the current terms of
+// service and privacy policy, which lifts terms_acceptance_required. It
+// answers 404 while acceptance is not tracked.
+func (h *Handlers) AcceptTerms(w http.ResponseWriter, r *http.Request) {
+	if !h.cfg.Terms.Enabled() {
+		writeErrorCode(w, r, http.StatusNotFound, api.ErrCodeNotFound, "no terms to accept")
+		return
+	}
+	var req AcceptTermsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorCode(w, r, http.StatusBadRequest, api.ErrCodeInvalidRequest, "invalid request body")
+		return
+	}
+	if !req.AcceptTerms {
+		writeErrorFields(w, r, http.StatusBadRequest, api.ErrCodeValidationFailed, "accept_terms must be true",
+			[]FieldError{{Field: "accept_terms", Message: "must be true"}})
+		return
+	}
+	user, err := h.acceptTerms(r, r.Context().Value(ctxUserID).(string))
+	if err != nil {
+		writeUserError(w, r, err)
+		return
+	}
+	respond(w, r, http.StatusOK, user)
+}
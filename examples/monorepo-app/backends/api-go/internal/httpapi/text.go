@@ -0,0 +1,42 @@
+package httpapi
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxNameLen is the longest User.Name, in characters.
+const maxNameLen = 100
+
+// cleanText applies the policy for names and other short free text: it
+// trims surrounding whitespace and returns the text, or a field error
+// message when the rest is empty, longer than max characters (code
+// points), or holds a character no one types (see hiddenRune).
+func cleanText(s string, max int) (string, string) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", "is required"
+	}
+	if utf8.RuneCountInString(s) > max {
+		return "", fmt.Sprintf("must be at most %d characters", max)
+	}
+	if i := strings.IndexFunc(s, hiddenRune); i >= 0 {
+		r, _ := utf8.DecodeRuneInString(s[i:])
+		return "", fmt.Sprintf("must not contain control or invisible formatting characters (U+%04X)", r)
+	}
+	return s, ""
+}
+
+// hiddenRune reports whether r is a control, format, private-use or
+// replacement character: zero-width spaces, bidirectional overrides and
+// the like, which make text render unlike what it holds. The zero-width
+// joiner and non-joiner are allowed, as emoji sequences and several
+// scripts (Persian, the Indic ones) need them.
+func hiddenRune(r rune) bool {
+	if r == '\u200c' || r == '\u200d' {
+		return false
+	}
+	return r == utf8.RuneError || unicode.In(r, unicode.Cc, unicode.Cf, unicode.Co, unicode.Cs)
+}
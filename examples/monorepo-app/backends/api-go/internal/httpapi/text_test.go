@@ -0,0 +1,90 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+)
+
+func TestCleanText(t *testing.T) {
+	for in, want := range map[string]string{
+		"Ana":                           "Ana",
+		"  Ana Souza \n":                "Ana Souza",
+		"José":                          "José",
+		"Jose\u0301":                    "Jose\u0301",   // a combining accent is a mark, not a format character
+		"Ana 👩\u200D💻":                  "Ana 👩\u200D💻", // ZWJ sequence
+		"🇧🇷 🎉":                          "🇧🇷 🎉",
+		"می\u200Cخواهم":                 "می\u200Cخواهم", // ZWNJ in Persian
+		"محمد":                          "محمد",
+		"李小龙":                           "李小龙",
+		strings.Repeat("x", maxNameLen): strings.Repeat("x", maxNameLen),
+		strings.Repeat("é", maxNameLen): strings.Repeat("é", maxNameLen), // characters, not bytes
+		strings.Repeat("😀", maxNameLen): strings.Repeat("😀", maxNameLen),
+	} {
+		if got, problem := cleanText(in, maxNameLen); problem != "" || got != want {
+			t.Errorf("cleanText(%q) = %q, %q; want %q", in, got, problem, want)
+		}
+	}
+
+	for in, problem := range map[string]string{
+		"":                                "is required",
+		" \t\n ":                          "is required",
+		strings.Repeat("x", maxNameLen+1): "at most 100",
+		strings.Repeat("😀", maxNameLen+1): "at most 100",
+		"\u202Egnp.exe":                   "U+202E", // right-to-left override
+		"Ana\u202Dx":                      "U+202D",
+		"Ana\u2067x\u2069":                "U+2067", // isolates
+		"A\u200Bna":                       "U+200B", // zero-width space
+		"\uFEFFAna":                       "U+FEFF",
+		"Ana\u00ADx":                      "U+00AD", // soft hyphen
+		"Ana\x00":                         "U+0000",
+		"Ana\tSouza":                      "U+0009",
+		"Ana\nSouza":                      "U+000A",
+		"Ana\x1b[31m":                     "U+001B",
+		"Ana\u0085x":                      "U+0085",
+		"Ana\uE000":                       "U+E000", // private use
+		"Ana\xff":                         "U+FFFD", // not UTF-8
+		strings.Repeat("a", 2<<20):        "at most 100",
+	} {
+		if got, p := cleanText(in, maxNameLen); !strings.Contains(p, problem) || got != "" {
+			t.Errorf("cleanText(%.20q) = %q, %q; want a problem with %q", in, got, p, problem)
+		}
+	}
+}
+
+// Register checks and trims the name, answering validation_failed on the
+// name field.
+func TestRegisterName(t *testing.T) {
+	_, ts := openAPIServer(t, store.NewMemory())
+	register := func(name string) (int, []byte) {
+		t.Helper()
+		data, _ := json.Marshal(api.RegisterRequest{Email: "name@example.com", Name: name, Password: "name-password"})
+		resp, err := http.Post(ts.URL+"/api/v1/auth/register", "application/json", strings.NewReader(string(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var body json.RawMessage
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body
+	}
+
+	for _, name := range []string{"\u202Eevil", "zero\u200Bwidth", "bell\a", strings.Repeat("n", 2<<20)} {
+		status, body := register(name)
+		var e APIError
+		json.Unmarshal(body, &e)
+		if status != http.StatusBadRequest || e.ErrorCode != api.ErrCodeValidationFailed || len(e.Fields) != 1 || e.Fields[0].Field != "name" {
+			t.Errorf("%.20q: %d %s", name, status, body)
+		}
+	}
+
+	status, body := register("  Ana 👩\u200D💻  ")
+	var auth AuthResponse
+	if status != http.StatusCreated || json.Unmarshal(body, &auth) != nil || auth.User.Name != "Ana 👩\u200D💻" {
+		t.Errorf("an emoji name: %d %s", status, body)
+	}
+}
@@ -0,0 +1,116 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/your-org/your-app/backends/api-go/internal/auth"
+)
+
+// TraceContext is the W3C trace context of a request. TraceID is shared
+// with the caller; SpanID is this server's span, so it becomes the parent-id
+// of every call the server makes while handling the request.
+type TraceContext struct {
+	TraceID  string
+	SpanID   string
+	ParentID string // caller's span, empty when the trace started here
+	Flags    string
+	State    string // tracestate, forwarded verbatim
+}
+
+var traceparentRe = regexp.MustCompile(`^([0-9a-f]{2})-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})(-.*)?$`)
+
+// parseTraceparent validates a traceparent header. Unknown future versions
+// are accepted as long as their version-00 prefix is well formed.
+func parseTraceparent(h string) (TraceContext, bool) {
+	m := traceparentRe.FindStringSubmatch(strings.TrimSpace(h))
+	if m == nil || m[1] == "ff" || (m[1] == "00" && m[5] != "") {
+		return TraceContext{}, false
+	}
+	if strings.Trim(m[2], "0") == "" || strings.Trim(m[3], "0") == "" {
+		return TraceContext{}, false
+	}
+	return TraceContext{TraceID: m[2], ParentID: m[3], Flags: m[4]}, true
+}
+
+// parseTracestate joins the tracestate header lines and drops the whole
+// value if it is not a list of at most 32 key=value members.
+func parseTracestate(lines []string) string {
+	var members []string
+	for _, line := range lines {
+		for _, m := range strings.Split(line, ",") {
+			if m = strings.TrimSpace(m); m != "" {
+				members = append(members, m)
+			}
+		}
+	}
+	if len(members) == 0 || len(members) > 32 {
+		return ""
+	}
+	for _, m := range members {
+		k, v, ok := strings.Cut(m, "=")
+		if !ok || k == "" || v == "" || len(m) > 256 {
+			return ""
+		}
+	}
+	return strings.Join(members, ",")
+}
+
+func newSpanID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Trace reads traceparent/tracestate into the request context. A missing or
+// malformed traceparent starts a new trace instead of failing the request.
+func Trace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tc, ok := parseTraceparent(r.Header.Get("traceparent"))
+		if ok {
+			tc.State = parseTracestate(r.Header.Values("tracestate"))
+		} else {
+			tc = TraceContext{TraceID: auth.GenerateID(), Flags: "00"}
+		}
+		tc.SpanID = newSpanID()
+		next.ServeHTTP(w, r.WithContext(withTrace(r.Context(), tc)))
+	})
+}
+
+func withTrace(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, ctxTrace, tc)
+}
+
+// traceparent formats tc as a traceparent header, with SpanID as the
+// parent of the callee.
+func (tc TraceContext) traceparent() string {
+	return "00-" + tc.TraceID + "-" + tc.SpanID + "-" + tc.Flags
+}
+
+// TraceFrom returns the trace context of ctx, if any.
+func TraceFrom(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(ctxTrace).(TraceContext)
+	return tc, ok
+}
+
+// traceTransport adds the trace context of each outgoing request's context.
+type traceTransport struct {
+	base http.RoundTripper
+}
+
+func (t traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tc, ok := TraceFrom(req.Context())
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context()) // RoundTrippers must not modify the caller's request
+	req.Header.Set("traceparent", tc.traceparent())
+	if tc.State != "" {
+		req.Header.Set("tracestate", tc.State)
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,228 @@
+package httpapi
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+)
+
+const (
+	testTraceID  = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testParentID = "00f067aa0ba902b7"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	for _, tt := range []struct {
+		header string
+		ok     bool
+	}{
+		{"00-" + testTraceID + "-" + testParentID + "-01", true},
+		{" 00-" + testTraceID + "-" + testParentID + "-00 ", true},
+		{"01-" + testTraceID + "-" + testParentID + "-01-future", true}, // a later version may append fields
+		{"00-" + testTraceID + "-" + testParentID + "-01-extra", false},
+		{"ff-" + testTraceID + "-" + testParentID + "-01", false},
+		{"00-" + strings.ToUpper(testTraceID) + "-" + testParentID + "-01", false},
+		{"00-" + strings.Repeat("0", 32) + "-" + testParentID + "-01", false},
+		{"00-" + testTraceID + "-" + strings.Repeat("0", 16) + "-01", false},
+		{"00-" + testTraceID[1:] + "-" + testParentID + "-01", false},
+		{"00-" + testTraceID + "-" + testParentID, false},
+		{"", false},
+		{"garbage", false},
+	} {
+		tc, ok := parseTraceparent(tt.header)
+		if ok != tt.ok {
+			t.Errorf("%q: ok = %v, want %v", tt.header, ok, tt.ok)
+			continue
+		}
+		if ok && (tc.TraceID != testTraceID || tc.ParentID != testParentID || tc.SpanID != "") {
+			t.Errorf("%q: %+v", tt.header, tc)
+		}
+	}
+}
+
+func TestParseTracestate(t *testing.T) {
+	many := make([]string, 33)
+	for i := range many {
+		many[i] = "k" + string(rune('a'+i%26)) + "=v"
+	}
+	for _, tt := range []struct {
+		lines []string
+		want  string
+	}{
+		{nil, ""},
+		{[]string{"congo=t61rcWkgMzE"}, "congo=t61rcWkgMzE"},
+		{[]string{"rojo=00f067aa0ba902b7, congo=t61rcWkgMzE"}, "rojo=00f067aa0ba902b7,congo=t61rcWkgMzE"},
+		{[]string{"rojo=1", "congo=2"}, "rojo=1,congo=2"},
+		{[]string{"rojo=1,,congo=2"}, "rojo=1,congo=2"},
+		{[]string{"rojo=1,congo"}, ""},
+		{[]string{"=1"}, ""},
+		{[]string{"k=" + strings.Repeat("v", 300)}, ""},
+		{[]string{strings.Join(many, ",")}, ""},
+		{[]string{strings.Join(many[:32], ",")}, strings.Join(many[:32], ",")},
+	} {
+		if got := parseTracestate(tt.lines); got != tt.want {
+			t.Errorf("%q: %q, want %q", tt.lines, got, tt.want)
+		}
+	}
+}
+
+func TestTraceMiddleware(t *testing.T) {
+	var got TraceContext
+	h := Trace(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got, _ = TraceFrom(r.Context()) }))
+	serve := func(traceparent, tracestate string) TraceContext {
+		got = TraceContext{}
+		r := httptest.NewRequest("GET", "/", nil)
+		if traceparent != "" {
+			r.Header.Set("traceparent", traceparent)
+		}
+		if tracestate != "" {
+			r.Header.Set("tracestate", tracestate)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%q: %d", traceparent, rec.Code)
+		}
+		return got
+	}
+
+	tc := serve("00-"+testTraceID+"-"+testParentID+"-01", "rojo=1")
+	if tc.TraceID != testTraceID || tc.ParentID != testParentID || tc.Flags != "01" || tc.State != "rojo=1" {
+		t.Errorf("continued trace: %+v", tc)
+	}
+	if len(tc.SpanID) != 16 || tc.SpanID == testParentID {
+		t.Errorf("span %q", tc.SpanID)
+	}
+
+	// A malformed header starts a new trace; its tracestate goes with it.
+	for _, bad := range []string{"", "garbage", "00-" + testTraceID + "-" + testParentID} {
+		tc := serve(bad, "rojo=1")
+		if len(tc.TraceID) != 32 || tc.TraceID == testTraceID || tc.ParentID != "" || tc.State != "" || len(tc.SpanID) != 16 {
+			t.Errorf("%q: %+v", bad, tc)
+		}
+	}
+	if a, b := serve("", ""), serve("", ""); a.TraceID == b.TraceID {
+		t.Error("two new traces share an ID")
+	}
+}
+
+// Outbound clients send this server's span as the parent of the call.
+func TestOutboundInjectsTrace(t *testing.T) {
+	var headers http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { headers = r.Header.Clone() }))
+	defer backend.Close()
+	out := NewOutbound(config.Defaults().Outbound)
+	tc := TraceContext{TraceID: testTraceID, SpanID: "b7ad6b7169203331", ParentID: testParentID, Flags: "01", State: "rojo=1"}
+
+	for name, client := range map[string]*http.Client{
+		"Client":          out.Client("trace-test", time.Second),
+		"UnguardedClient": out.UnguardedClient(time.Second),
+	} {
+		req, _ := http.NewRequestWithContext(withTrace(context.Background(), tc), "GET", backend.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := headers.Get("traceparent"); got != "00-"+testTraceID+"-b7ad6b7169203331-01" {
+			t.Errorf("%s: traceparent %q", name, got)
+		}
+		if got := headers.Get("tracestate"); got != "rojo=1" {
+			t.Errorf("%s: tracestate %q", name, got)
+		}
+		if req.Header.Get("traceparent") != "" {
+			t.Errorf("%s: the caller's request was modified", name)
+		}
+
+		req, _ = http.NewRequest("GET", backend.URL, nil)
+		resp, err = client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if headers.Get("traceparent") != "" || headers.Get("tracestate") != "" {
+			t.Errorf("%s: trace headers without a trace: %v", name, headers)
+		}
+	}
+}
+
+// readLines waits for path to hold n lines containing substr and returns
+// the lines decoded.
+func readLines(t *testing.T, path, substr string, n int) []map[string]any {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		data, _ := os.ReadFile(path)
+		if strings.Count(string(data), substr) >= n || time.Now().After(deadline) {
+			var lines []map[string]any
+			sc := bufio.NewScanner(strings.NewReader(string(data)))
+			for sc.Scan() {
+				var line map[string]any
+				if err := json.Unmarshal(sc.Bytes(), &line); err != nil {
+					t.Fatalf("%s: %v: %s", path, err, sc.Text())
+				}
+				lines = append(lines, line)
+			}
+			return lines
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// The trace of a request shows up in its access log line and in the
+// security events it causes.
+func TestTraceInLogs(t *testing.T) {
+	dir := t.TempDir()
+	accessLog, auditLog := filepath.Join(dir, "access.log"), filepath.Join(dir, "audit.log")
+	_, ts := openAPIServer(t, store.NewMemory(), func(cfg *config.Config) {
+		cfg.AccessLogFormat = "json"
+		cfg.AccessLogOutput = accessLog
+		cfg.AuditLogOutput = auditLog
+	})
+	login := func(traceparent string) {
+		t.Helper()
+		req, _ := http.NewRequest("POST", ts.URL+"/api/v1/auth/login",
+			strings.NewReader(`{"email":"nobody@example.com","password":"wrong-password"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("traceparent", traceparent)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("%q: %d, want 401 (malformed headers are not rejected)", traceparent, resp.StatusCode)
+		}
+	}
+	login("00-" + testTraceID + "-" + testParentID + "-01")
+	login("00-not-a-trace")
+
+	access := readLines(t, accessLog, "/api/v1/auth/login", 2)
+	audit := readLines(t, auditLog, EventLoginFailed, 2)
+	for name, lines := range map[string][]map[string]any{"access log": access, "audit log": audit} {
+		if len(lines) < 2 {
+			t.Fatalf("%s: %d lines", name, len(lines))
+		}
+		first, second := lines[len(lines)-2], lines[len(lines)-1]
+		if first["trace_id"] != testTraceID {
+			t.Errorf("%s: trace_id %v, want the caller's", name, first["trace_id"])
+		}
+		span, _ := first["span_id"].(string)
+		if len(span) != 16 || span == testParentID {
+			t.Errorf("%s: span_id %q, want this server's", name, span)
+		}
+		if id, _ := second["trace_id"].(string); len(id) != 32 || id == testTraceID {
+			t.Errorf("%s: malformed traceparent got trace_id %q, want a new one", name, id)
+		}
+	}
+}
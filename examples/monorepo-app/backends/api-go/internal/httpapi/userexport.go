@@ -0,0 +1,121 @@
+package httpapi
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+)
+
+// exportFlushEvery is how many users ExportUsers encodes between flushes,
+// so the client sees progress and the buffers stay small.
+const exportFlushEvery = 500
+
+// ExportUsers streams the users as NDJSON, one User per line, in
+// ListUsers order and with its filters (pending_deletion, flag). Unlike
+// GET /users it never holds the whole list: users come from
+// ForEachUser and are flushed every exportFlushEvery, gzipped when the
+// client accepts it. The stream outlives SERVER_WRITE_TIMEOUT and stops
+// when the client goes away. A store failure after the first line cuts
+// the connection, so a truncated export can't pass for a complete one.
+func (h *Handlers) ExportUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if format := q.Get("format"); format != "" && format != "ndjson" {
+		writeErrorFields(w, r, http.StatusBadRequest, api.ErrCodeValidationFailed, "invalid query parameters",
+			[]FieldError{{Field: "format", Message: "must be ndjson"}})
+		return
+	}
+	filter := store.UserFilter{PendingDeletion: q.Get("pending_deletion") == "true"}
+	flag := q.Get("flag")
+
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Add("Vary", "Accept-Encoding")
+	sent := &sentWriter{w: w}
+	var out io.Writer = sent
+	var gz *gzip.Writer
+	if acceptsGzip(r) {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz = gzip.NewWriter(sent)
+		out = gz
+	}
+	bw := bufio.NewWriterSize(out, 32<<10)
+	enc := json.NewEncoder(bw)
+	flush := func() error {
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+		if gz != nil {
+			if err := gz.Flush(); err != nil {
+				return err
+			}
+		}
+		return rc.Flush()
+	}
+
+	n := 0
+	err := h.store.ForEachUser(r.Context(), filter, func(u *User) error {
+		u = h.flagUnknownRole(u)
+		if flag != "" && !slices.Contains(u.Flags, flag) {
+			return nil
+		}
+		if err := enc.Encode(u); err != nil {
+			return err
+		}
+		if n++; n%exportFlushEvery == 0 {
+			return flush()
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		return // the client is gone
+	case err != nil && !sent.wrote:
+		// Nothing sent yet: answer with an error instead.
+		w.Header().Del("Content-Encoding")
+		writeUserError(w, r, err)
+		return
+	case err != nil:
+		log.Printf("ERROR users export: %v after %d users (request_id=%s)", err, n, r.Header.Get("X-Request-ID"))
+		panic(http.ErrAbortHandler)
+	}
+	if err := bw.Flush(); err == nil && gz != nil {
+		_ = gz.Close()
+	}
+	AdminAction.Publish(eventContext(r), h.events, AdminActionEvent{Action: "users_export", Details: map[string]string{"users": strconv.Itoa(n)}})
+}
+
+// acceptsGzip reports whether the request's Accept-Encoding allows gzip.
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(enc), ";")
+		if strings.TrimSpace(name) == "gzip" {
+			return strings.ReplaceAll(params, " ", "") != "q=0"
+		}
+	}
+	return false
+}
+
+// sentWriter tells whether anything reached the response yet.
+type sentWriter struct {
+	w     io.Writer
+	wrote bool
+}
+
+func (s *sentWriter) Write(p []byte) (int, error) {
+	s.wrote = true
+	return s.w.Write(p)
+}
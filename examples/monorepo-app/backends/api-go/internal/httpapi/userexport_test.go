@@ -0,0 +1,245 @@
+package httpapi_test
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/httpapi"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+	"github.com/your-org/your-app/backends/api-go/internal/store/storemock"
+	"github.com/your-org/your-app/backends/api-go/raijintest"
+)
+
+// syntheticUsers makes ForEachUser yield n made-up users, each built as
+// it is asked for, and returns how many fn took. n < 0 never ends.
+func syntheticUsers(st *storemock.Store, n int, each func(i int)) *int {
+	var yielded int
+	st.ForEachUserFunc = func(ctx context.Context, _ store.UserFilter, fn func(*api.User) error) error {
+		for i := 0; n < 0 || i < n; i++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if each != nil {
+				each(i)
+			}
+			u := &api.User{ID: fmt.Sprintf("user-%07d", i), Email: fmt.Sprintf("user%d@example.com", i), Name: "Synthetic User", Role: "user"}
+			if err := fn(u); err != nil {
+				return err
+			}
+			yielded++
+		}
+		return nil
+	}
+	return &yielded
+}
+
+// getExport requests the export with Accept-Encoding set to encoding.
+func getExport(t *testing.T, client *http.Client, url, encoding string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set("Accept-Encoding", encoding)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// 100k users stream through, plain and gzipped, one User per line: every
+// 10k users the store waits for the client to have all but the last
+// thousand (ExportUsers flushes every 500), which a handler holding the
+// export back would never let it.
+func TestExportUsersStreams(t *testing.T) {
+	const n, every, lag = 100_000, 10_000, 1000
+	st := storemock.New()
+	srv := raijintest.NewServer(t, raijintest.WithStore(st))
+	admin := srv.LoginAs(t, "admin")
+
+	for _, encoding := range []string{"identity", "gzip"} {
+		var received atomic.Int64
+		var stalled atomic.Int64
+		stalled.Store(-1)
+		yielded := syntheticUsers(st, n, func(i int) {
+			if i == 0 || i%every != 0 || stalled.Load() >= 0 {
+				return
+			}
+			for deadline := time.Now().Add(5 * time.Second); received.Load() < int64(i-lag); time.Sleep(time.Millisecond) {
+				if time.Now().After(deadline) {
+					stalled.Store(int64(i))
+					return
+				}
+			}
+		})
+
+		resp := getExport(t, admin, srv.URL+"/api/v1/admin/users/export?format=ndjson", encoding)
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/x-ndjson" {
+			t.Fatalf("%s: %d %s", encoding, resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		var body io.Reader = resp.Body
+		if encoding == "gzip" {
+			if resp.Header.Get("Content-Encoding") != "gzip" {
+				t.Fatalf("gzip: Content-Encoding %q", resp.Header.Get("Content-Encoding"))
+			}
+			zr, err := gzip.NewReader(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body = zr
+		} else if resp.Header.Get("Content-Encoding") != "" {
+			t.Errorf("identity: Content-Encoding %q", resp.Header.Get("Content-Encoding"))
+		}
+
+		lines := 0
+		sc := bufio.NewScanner(body)
+		for sc.Scan() {
+			var u api.User
+			if err := json.Unmarshal(sc.Bytes(), &u); err != nil {
+				t.Fatalf("%s: line %d: %v: %s", encoding, lines+1, err, sc.Bytes())
+			}
+			if want := fmt.Sprintf("user-%07d", lines); u.ID != want {
+				t.Fatalf("%s: line %d is %s, want %s", encoding, lines+1, u.ID, want)
+			}
+			lines++
+			received.Store(int64(lines))
+		}
+		resp.Body.Close()
+		if err := sc.Err(); err != nil {
+			t.Fatalf("%s: %v", encoding, err)
+		}
+		if lines != n || *yielded != n {
+			t.Errorf("%s: %d lines from %d users, want %d", encoding, lines, *yielded, n)
+		}
+		if i := stalled.Load(); i >= 0 {
+			t.Errorf("%s: at user %d the client still had fewer than %d: the export is held back", encoding, i, i-lag)
+		}
+	}
+}
+
+// A client going away mid-stream stops the iteration.
+func TestExportUsersClientGone(t *testing.T) {
+	st := storemock.New()
+	srv := raijintest.NewServer(t, raijintest.WithStore(st))
+	endless := storemock.New()
+	yielded := syntheticUsers(endless, -1, nil)
+	done := make(chan error, 1)
+	var reqCtx context.Context
+	st.ForEachUserFunc = func(ctx context.Context, f store.UserFilter, fn func(*api.User) error) error {
+		reqCtx = ctx
+		err := endless.ForEachUserFunc(ctx, f, fn)
+		done <- err
+		return err
+	}
+
+	resp := getExport(t, srv.LoginAs(t, "admin"), srv.URL+"/api/v1/admin/users/export", "identity")
+	if _, err := io.ReadFull(resp.Body, make([]byte, 1<<10)); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	select {
+	case err := <-done:
+		// The failed write or the canceled context, whichever comes first.
+		if err == nil {
+			t.Error("the iteration ended as if complete")
+		}
+		select {
+		case <-reqCtx.Done():
+		case <-time.After(5 * time.Second):
+			t.Error("the request context is still live")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("still iterating after the client left (%d users)", *yielded)
+	}
+}
+
+// A store failure answers 500 while nothing is sent, and cuts the
+// connection after: a truncated export must not look complete.
+func TestExportUsersStoreError(t *testing.T) {
+	st := storemock.New()
+	srv := raijintest.NewServer(t, raijintest.WithStore(st))
+	admin := srv.LoginAs(t, "admin")
+	boom := errors.New("database went away")
+
+	st.Fail("ForEachUser", boom)
+	resp := getExport(t, admin, srv.URL+"/api/v1/admin/users/export", "identity")
+	var e api.APIError
+	json.NewDecoder(resp.Body).Decode(&e)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError || e.ErrorCode != api.ErrCodeInternal {
+		t.Errorf("failing at once: %d %+v", resp.StatusCode, e)
+	}
+
+	inner := storemock.New()
+	syntheticUsers(inner, 5000, nil)
+	st.ForEachUserFunc = func(ctx context.Context, f store.UserFilter, fn func(*api.User) error) error {
+		if err := inner.ForEachUserFunc(ctx, f, fn); err != nil {
+			return err
+		}
+		return boom
+	}
+	resp = getExport(t, admin, srv.URL+"/api/v1/admin/users/export", "identity")
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failing late: %d", resp.StatusCode)
+	}
+	if data, err := io.ReadAll(resp.Body); err == nil {
+		t.Errorf("failing late: the export ended cleanly after %d lines", strings.Count(string(data), "\n"))
+	}
+}
+
+// The export takes GET /users's filters, flags unknown roles like it,
+// and is for admins only.
+func TestExportUsersFilters(t *testing.T) {
+	srv := raijintest.NewServer(t)
+	admin := srv.LoginAs(t, "admin")
+	leaving := srv.CreateUser(t, "leaving@example.com", raijintest.Password, "user")
+	srv.Store.UpdateUser(leaving.ID, func(u *api.User) { u.DeleteAfter = time.Now().Add(time.Hour) })
+	legacy := srv.CreateUser(t, "legacy@example.com", raijintest.Password, "user")
+	srv.Store.UpdateUser(legacy.ID, func(u *api.User) { u.Role = "auditor" })
+
+	export := func(query string) []api.User {
+		t.Helper()
+		resp := getExport(t, admin, srv.URL+"/api/v1/admin/users/export"+query, "identity")
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: %d", query, resp.StatusCode)
+		}
+		var users []api.User
+		dec := json.NewDecoder(resp.Body)
+		for {
+			var u api.User
+			if err := dec.Decode(&u); err == io.EOF {
+				return users
+			} else if err != nil {
+				t.Fatalf("%s: %v", query, err)
+			}
+			users = append(users, u)
+		}
+	}
+	var listed httpapi.UserList
+	wantStatus(t, send(t, admin, "GET", srv.URL+"/api/v1/users", nil, &listed), http.StatusOK)
+	if all := export(""); len(all) != len(listed.Users) {
+		t.Errorf("everyone: %d users, GET /users lists %d", len(all), len(listed.Users))
+	}
+	if got := export("?pending_deletion=true"); len(got) != 1 || got[0].ID != leaving.ID {
+		t.Errorf("pending_deletion: %+v", got)
+	}
+	if got := export("?flag=" + api.UserFlagUnknownRole); len(got) != 1 || got[0].ID != legacy.ID {
+		t.Errorf("flag=unknown_role: %+v", got)
+	}
+
+	resp := send(t, admin, "GET", srv.URL+"/api/v1/admin/users/export?format=csv", nil, nil)
+	wantStatus(t, resp, http.StatusBadRequest)
+	resp = send(t, srv.LoginAs(t, "user"), "GET", srv.URL+"/api/v1/admin/users/export", nil, nil)
+	wantStatus(t, resp, http.StatusForbidden)
+}
@@ -0,0 +1,160 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+)
+
+// APIVersion is one /api/vN mount of the API. Every version serves the same
+// handlers, which write v1 bodies through respond (or writeJSONCached);
+// what a version changes is the shape of those bodies and of errors. The
+// version is picked from the request path, never negotiated.
+type APIVersion struct {
+	Prefix string
+	// Problem makes every error problem+json, whatever ERROR_FORMAT and
+	// Accept say.
+	Problem bool
+	// Paginated versions page list responses by the limit and offset query
+	// parameters (see paginate).
+	Paginated bool
+	// Transform reshapes a v1 body; r is nil when only the shape is wanted
+	// (OpenAPI). Field errors are about r's query. Nil leaves bodies as is.
+	Transform func(r *http.Request, body any) (any, []FieldError)
+}
+
+// apiVersions are mounted by main. The first one also applies to paths
+// outside the API (health, docs, ...).
+var apiVersions = []*APIVersion{
+	{Prefix: "/api/v1"},
+	{Prefix: "/api/v2", Problem: true, Paginated: true, Transform: v2Body},
+}
+
+// apiVersionOf returns the version r's path belongs to.
+func apiVersionOf(r *http.Request) *APIVersion { return versionOfPath(r.URL.Path) }
+
+func versionOfPath(path string) *APIVersion {
+	for _, v := range apiVersions {
+		if path == v.Prefix || strings.HasPrefix(path, v.Prefix+"/") {
+			return v
+		}
+	}
+	return apiVersions[0]
+}
+
+// routeV1 rewrites a route pattern or path of any version to its /api/v1
+// form, so settings keyed by v1 routes (RATE_LIMIT_ROUTES, maintenance
+// exemptions) cover every version.
+func routeV1(pattern string) string {
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok {
+		method, path = "", pattern
+	} else {
+		method += " "
+	}
+	if v := versionOfPath(path); v != apiVersions[0] {
+		path = apiVersions[0].Prefix + strings.TrimPrefix(path, v.Prefix)
+	}
+	return method + path
+}
+
+// respond writes body as JSON, shaped for r's API version.
+func respond(w http.ResponseWriter, r *http.Request, status int, body any) {
+	body, ok := versionBody(w, r, body)
+	if !ok {
+		return
+	}
+	writeJSON(w, status, body)
+}
+
+// versionBody applies r's version Transform to body. On invalid query
+// parameters it writes the 400 itself and reports false.
+func versionBody(w http.ResponseWriter, r *http.Request, body any) (any, bool) {
+	v := apiVersionOf(r)
+	if v.Transform == nil {
+		return body, true
+	}
+	body, fields := v.Transform(r, body)
+	if len(fields) > 0 {
+		writeErrorFields(w, r, http.StatusBadRequest, api.ErrCodeValidationFailed, "invalid query parameters", fields)
+		return nil, false
+	}
+	return body, true
+}
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 1000
+)
+
+// lister is implemented by the v1 list bodies ({"<items>": [...], "total": n}).
+type lister interface {
+	page(r *http.Request) (any, []FieldError)
+}
+
+func (l UserList) page(r *http.Request) (any, []FieldError)          { return paginate(r, l.Users) }
+func (l SecurityEventList) page(r *http.Request) (any, []FieldError) { return paginate(r, l.Events) }
+func (l WebhookList) page(r *http.Request) (any, []FieldError)       { return paginate(r, l.Webhooks) }
+func (l WebhookDeliveryList) page(r *http.Request) (any, []FieldError) {
+	return paginate(r, l.Deliveries)
+}
+func (l WebhookMessageList) page(r *http.Request) (any, []FieldError) { return paginate(r, l.Messages) }
+func (l FeatureFlagList) page(r *http.Request) (any, []FieldError)    { return paginate(r, l.Flags) }
+func (l OrgList) page(r *http.Request) (any, []FieldError)            { return paginate(r, l.Orgs) }
+func (l OrgMembershipList) page(r *http.Request) (any, []FieldError)  { return paginate(r, l.Orgs) }
+func (l OrgMemberList) page(r *http.Request) (any, []FieldError)      { return paginate(r, l.Members) }
+
+func v2Body(r *http.Request, body any) (any, []FieldError) {
+	switch b := body.(type) {
+	case lister:
+		return b.page(r)
+	case AuthResponse:
+		return AuthResponseV2{
+			AccessToken: b.AccessToken, TokenType: "Bearer", ExpiresIn: int64(b.accessTTL.Seconds()),
+			RefreshToken: b.RefreshToken, RefreshExpiresIn: int64(b.refreshTTL.Seconds()),
+			User: b.User, CSRFToken: b.CSRFToken, PasswordWarning: b.PasswordWarning,
+		}, nil
+	}
+	return body, nil
+}
+
+// paginate returns the page of items selected by r's limit (1-1000,
+// default 50) and offset query parameters.
+func paginate[T any](r *http.Request, items []T) (any, []FieldError) {
+	page := api.ListPage[T]{Data: []T{}, Page: PageInfo{Limit: defaultPageLimit, Total: len(items)}}
+	if r == nil {
+		return page, nil
+	}
+	q := r.URL.Query()
+	var fields []FieldError
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageLimit {
+			fields = append(fields, FieldError{Field: "limit", Message: fmt.Sprintf("must be between 1 and %d", maxPageLimit)})
+		}
+		page.Page.Limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			fields = append(fields, FieldError{Field: "offset", Message: "must be a non-negative integer"})
+		}
+		page.Page.Offset = n
+	}
+	if len(fields) > 0 {
+		return nil, fields
+	}
+	start := min(page.Page.Offset, len(items))
+	end := min(start+page.Page.Limit, len(items))
+	page.Data = append(page.Data, items[start:end]...)
+	if end < len(items) {
+		q.Set("limit", strconv.Itoa(page.Page.Limit))
+		q.Set("offset", strconv.Itoa(end))
+		next := r.URL.Path + "?" + q.Encode()
+		page.Page.Next = &next
+	}
+	return page, nil
+}
@@ -0,0 +1,392 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	mrand "math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/internal/auth"
+	"github.com/your-org/your-app/backends/api-go/internal/config"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+)
+
+// Webhook event types.
+const (
+	WebhookUserRegistered         = "user.registered"
+	WebhookUserDeleted            = "user.deleted"
+	WebhookUserRoleChanged        = "user.role_changed"
+	WebhookUserPreferencesChanged = "user.preferences_changed"
+)
+
+var webhookEventTypes = map[string]bool{
+	WebhookUserRegistered:         true,
+	WebhookUserDeleted:            true,
+	WebhookUserRoleChanged:        true,
+	WebhookUserPreferencesChanged: true,
+}
+
+// WebhookEvent is the JSON body POSTed to subscribers.
+type WebhookEvent struct {
+	ID   string    `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data"`
+}
+
+// webhookSignature is the X-Raijin-Signature value for body sent at
+// timestamp (Unix seconds): "t=<timestamp>,sha256=" +
+// hex(HMAC-SHA256(secret, "<timestamp>." + body)). The timestamp is
+// signed with the body so receivers can refuse stale deliveries: a
+// captured one cannot be replayed later under a fresh timestamp.
+func webhookSignature(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,sha256=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// webhookPollInterval is how often the dispatcher looks for due messages
+// when Emit has not woken it: for retries, redrives and messages whose
+// lease ran out.
+const webhookPollInterval = time.Second
+
+// Webhooks delivers events to subscribers through the store's outbox.
+// Emit persists one message per subscriber before returning; a dispatcher
+// claims due messages for the workers, which POST each once and reschedule
+// a failure with exponential backoff, until after maxAttempts it is dead:
+// kept for the admin API to list and redrive. Delivery is at least once, so
+// receivers should dedupe on X-Raijin-Delivery.
+type Webhooks struct {
+	store       store.Store
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+	maxPending  int           // WEBHOOK_QUEUE_SIZE: past it Emit dead-letters
+	lease       time.Duration // how long a claimed message is left to its worker
+	breakers    *webhookBreakers
+
+	delivered, retried, dead atomic.Int64
+
+	jobs       chan store.WebhookMessage
+	wake       chan struct{} // Emit's nudge to the dispatcher
+	stop       chan struct{} // closed by Stop
+	stopOnce   sync.Once
+	ctx        context.Context // of the POSTs, canceled when Stop's deadline passes
+	cancel     context.CancelFunc
+	dispatcher sync.WaitGroup
+	workers    sync.WaitGroup
+}
+
+func NewWebhooks(st store.Store, cfg *config.Config, out *Outbound) *Webhooks {
+	wh := &Webhooks{
+		store:       st,
+		client:      out.UnguardedClient(cfg.WebhookTimeout),
+		maxAttempts: cfg.WebhookMaxAttempts,
+		backoff:     cfg.WebhookBackoff,
+		maxPending:  cfg.WebhookQueueSize,
+		lease:       2*cfg.WebhookTimeout + time.Minute,
+		breakers:    &webhookBreakers{tripAfter: cfg.WebhookTripAfter, cooldown: cfg.WebhookCooldown, subs: make(map[string]*Breaker)},
+		jobs:        make(chan store.WebhookMessage),
+		wake:        make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+	}
+	wh.ctx, wh.cancel = context.WithCancel(context.Background())
+	publishVar("webhooks", func() any {
+		return map[string]int64{
+			"delivered": wh.delivered.Load(), "retried": wh.retried.Load(), "dead": wh.dead.Load(),
+			"open_circuits": int64(wh.breakers.open(time.Now())),
+		}
+	})
+	return wh
+}
+
+// Start launches the dispatcher and n delivery workers.
+func (wh *Webhooks) Start(n int) {
+	n = max(n, 1)
+	for range n {
+		wh.workers.Add(1)
+		go func() {
+			defer wh.workers.Done()
+			for m := range wh.jobs {
+				wh.deliver(m)
+			}
+		}()
+	}
+	wh.dispatcher.Add(1)
+	go wh.dispatch(n)
+}
+
+// dispatch hands due messages to the workers, n at a time, until Stop;
+// then it hands out what is due once more, so events emitted just before
+// shutdown still go out.
+func (wh *Webhooks) dispatch(n int) {
+	defer wh.dispatcher.Done()
+	defer close(wh.jobs)
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+	for {
+		stopping := false
+		select {
+		case <-wh.wake:
+		case <-ticker.C:
+		case <-wh.stop:
+			stopping = true
+		}
+		for wh.ctx.Err() == nil {
+			msgs := wh.store.ClaimWebhookMessages(time.Now(), n, wh.lease)
+			for _, m := range msgs {
+				wh.jobs <- m
+			}
+			if len(msgs) < n {
+				break
+			}
+		}
+		if stopping {
+			return
+		}
+	}
+}
+
+// Subscribe forwards the user lifecycle events on bus to webhook
+// subscribers.
+func (wh *Webhooks) Subscribe(bus *EventBus) {
+	UserRegistered.Subscribe(bus, Sync, func(ctx context.Context, e UserEvent) { wh.Emit(ctx, WebhookUserRegistered, e.User) })
+	UserDeleted.Subscribe(bus, Sync, func(ctx context.Context, e UserEvent) { wh.Emit(ctx, WebhookUserDeleted, e.User) })
+	RoleChanged.Subscribe(bus, Sync, func(ctx context.Context, e RoleChangedEvent) {
+		wh.Emit(ctx, WebhookUserRoleChanged, map[string]any{"user": e.User, "old_role": e.OldRole})
+	})
+	PreferencesChanged.Subscribe(bus, Sync, func(ctx context.Context, e PreferencesEvent) {
+		wh.Emit(ctx, WebhookUserPreferencesChanged, map[string]any{
+			"user_id": e.UserID, "preferences": e.New.WithDefaults(), "old_preferences": e.Old.WithDefaults(),
+		})
+	})
+}
+
+// Emit adds eventType to the outbox for every subscriber. It never waits
+// on a delivery. With WEBHOOK_QUEUE_SIZE messages already pending, the
+// event is dead-lettered instead. Deliveries carry ctx's trace.
+func (wh *Webhooks) Emit(ctx context.Context, eventType string, data any) {
+	subs := wh.store.WebhooksFor(eventType)
+	if len(subs) == 0 {
+		return
+	}
+	now := time.Now().UTC()
+	ev := WebhookEvent{ID: auth.GenerateID(), Type: eventType, Time: now, Data: data}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("webhook %s: %v", eventType, err)
+		return
+	}
+	status, reason, next := store.WebhookPending, "", now
+	if wh.store.Stats().WebhookQueued+len(subs) > wh.maxPending {
+		status, reason, next = store.WebhookDead, "outbox full", time.Time{}
+	}
+	tc, traced := TraceFrom(ctx)
+	msgs := make([]store.WebhookMessage, len(subs))
+	for i, sub := range subs {
+		msgs[i] = store.WebhookMessage{
+			ID: auth.GenerateID(), SubscriptionID: sub.ID, EventID: ev.ID, EventType: eventType, Body: body,
+			Status: status, LastError: reason, NextAttempt: next, CreatedAt: now,
+		}
+		if traced {
+			msgs[i].Traceparent, msgs[i].Tracestate = tc.traceparent(), tc.State
+		}
+	}
+	wh.store.EnqueueWebhookMessages(msgs)
+	if status == store.WebhookDead {
+		wh.dead.Add(int64(len(msgs)))
+		log.Printf("WARN webhook %s: outbox full (%d pending), event %s dead-lettered", eventType, wh.maxPending, ev.ID)
+		return
+	}
+	select {
+	case wh.wake <- struct{}{}:
+	default:
+	}
+}
+
+// deliver makes one attempt at m, unless its subscription's circuit is
+// open, and records the outcome in the outbox.
+func (wh *Webhooks) deliver(m store.WebhookMessage) {
+	i := slices.IndexFunc(wh.store.WebhooksFor(m.EventType), func(s WebhookSubscription) bool { return s.ID == m.SubscriptionID })
+	if i < 0 {
+		wh.store.DeleteWebhookMessage(m.ID) // unsubscribed meanwhile
+		return
+	}
+	sub := wh.store.WebhooksFor(m.EventType)[i]
+	if ok, until := wh.breakers.allow(sub.ID, time.Now()); !ok {
+		wh.store.UpdateWebhookMessage(m.ID, func(m *store.WebhookMessage) { m.NextAttempt = until })
+		return
+	}
+	attempt := m.Attempts + 1
+	start := time.Now()
+	code, err := wh.post(sub, m, attempt)
+	if err != nil && wh.ctx.Err() != nil {
+		// Cut short by shutdown: not the subscriber's fault, so not an
+		// attempt. A persistent store retries it on the next start.
+		wh.store.UpdateWebhookMessage(m.ID, func(m *store.WebhookMessage) { m.NextAttempt = start })
+		return
+	}
+	d := WebhookDelivery{
+		SubscriptionID: sub.ID, EventID: m.EventID, EventType: m.EventType,
+		Attempt: attempt, StatusCode: code, Time: start.UTC(), DurationMS: time.Since(start).Milliseconds(),
+	}
+	if wh.breakers.record(sub.ID, err == nil, time.Now()) {
+		log.Printf("WARN webhook subscription %s: %d consecutive failures, holding deliveries back for %s",
+			sub.ID, wh.breakers.tripAfter, wh.breakers.cooldown)
+	}
+	switch {
+	case err == nil:
+		d.Outcome = "delivered"
+		wh.store.DeleteWebhookMessage(m.ID)
+		wh.delivered.Add(1)
+	case attempt >= wh.maxAttempts:
+		d.Outcome, d.Error = "dead", err.Error()
+		wh.store.UpdateWebhookMessage(m.ID, func(m *store.WebhookMessage) {
+			m.Status, m.Attempts, m.LastError, m.NextAttempt = store.WebhookDead, attempt, d.Error, time.Time{}
+		})
+		wh.dead.Add(1)
+		log.Printf("WARN webhook dead letter: message=%s subscription=%s event=%s type=%s attempts=%d reason=%q",
+			m.ID, sub.ID, m.EventID, m.EventType, attempt, d.Error)
+	default:
+		d.Outcome, d.Error = "retrying", err.Error()
+		// backoff, 2×backoff, 4×backoff... with up to 50% jitter, capped at 10m.
+		wait := min(wh.backoff<<(attempt-1), 10*time.Minute)
+		wait += time.Duration(mrand.Int64N(int64(wait)/2 + 1))
+		wh.store.UpdateWebhookMessage(m.ID, func(m *store.WebhookMessage) {
+			m.Attempts, m.LastError, m.NextAttempt = attempt, d.Error, time.Now().Add(wait)
+		})
+		wh.retried.Add(1)
+	}
+	wh.store.AppendWebhookDelivery(d, webhookDeliveryRetention)
+}
+
+func (wh *Webhooks) post(sub WebhookSubscription, m store.WebhookMessage, attempt int) (int, error) {
+	ctx := wh.ctx
+	if tc, ok := parseTraceparent(m.Traceparent); ok {
+		tc.SpanID, tc.State = tc.ParentID, m.Tracestate
+		ctx = withTrace(ctx, tc)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(m.Body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "raijin-webhooks/"+buildInfo().Version)
+	req.Header.Set("X-Raijin-Event", m.EventType)
+	req.Header.Set("X-Raijin-Delivery", m.EventID)
+	req.Header.Set("X-Raijin-Attempt", strconv.Itoa(attempt))
+	req.Header.Set("X-Raijin-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Raijin-Signature", webhookSignature(sub.Secret, timestamp, m.Body))
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Stop stops dispatching once what is due has been handed out, and waits
+// for the deliveries in flight. When ctx ends first, those are cut short
+// and Stop returns ctx's error. Messages still pending (retries waiting
+// out their backoff) stay in the outbox for the next start, which only a
+// persistent store keeps.
+func (wh *Webhooks) Stop(ctx context.Context) error {
+	wh.stopOnce.Do(func() { close(wh.stop) })
+	done := make(chan struct{})
+	go func() {
+		wh.dispatcher.Wait()
+		wh.workers.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		wh.cancel()
+		<-done
+		err = ctx.Err()
+	}
+	wh.cancel()
+	if n := wh.store.Stats().WebhookQueued; n > 0 {
+		log.Printf("Webhooks: %d messages left pending in the outbox", n)
+	}
+	return err
+}
+
+// webhookBreakers are the circuit breakers of the subscriptions, in this
+// process: after tripAfter consecutive failed deliveries a subscription's
+// messages are held back (without using up attempts) for cooldown, then a
+// single delivery probes it. Success closes the circuit; failure holds
+// the messages back for another cooldown. Only subscriptions that are
+// failing have one.
+type webhookBreakers struct {
+	mu        sync.Mutex
+	tripAfter int
+	cooldown  time.Duration
+	subs      map[string]*Breaker
+}
+
+// allow reports whether subscription id may be delivered to at now, and
+// when not, until when to hold its message back.
+func (b *webhookBreakers) allow(id string, now time.Time) (bool, time.Time) {
+	b.mu.Lock()
+	s := b.subs[id]
+	b.mu.Unlock()
+	var open *CircuitOpenError
+	if s != nil && errors.As(s.Allow(now), &open) {
+		return false, open.Until
+	}
+	return true, time.Time{}
+}
+
+// record notes the outcome of a delivery to subscription id and reports
+// whether it opened the circuit.
+func (b *webhookBreakers) record(id string, ok bool, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ok {
+		delete(b.subs, id)
+		return false
+	}
+	s := b.subs[id]
+	if s == nil {
+		s = NewBreaker("webhook "+id, b.tripAfter, b.cooldown)
+		b.subs[id] = s
+	}
+	return s.Record(false, now)
+}
+
+// open returns how many circuits are open at now.
+func (b *webhookBreakers) open(now time.Time) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for _, s := range b.subs {
+		if s.open(now) {
+			n++
+		}
+	}
+	return n
+}
+
+// webhookDeliveryRetention caps the delivery attempts kept for the admin API.
+const webhookDeliveryRetention = 1000
@@ -0,0 +1,233 @@
+// Package proxyproto reads the PROXY protocol header (v1 text or v2
+// binary) a TCP load balancer puts in front of each connection, so the
+// connection reports the client's address instead of the balancer's.
+// Wrap the raw listener, under any TLS listener: the header comes before
+// the handshake.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// v2Signature starts every v2 header.
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// maxV1Len is the longest v1 header, CRLF included, per the spec.
+const maxV1Len = 107
+
+// Listener accepts connections that start with a PROXY header. Peers
+// outside Allowed, when set, are served as they are: their header is not
+// read, so only the balancers can claim another address. A header that is
+// missing, malformed or not complete within Timeout closes the connection.
+type Listener struct {
+	net.Listener
+	Timeout time.Duration  // for reading the header; 0 waits forever
+	Allowed []netip.Prefix // balancer addresses; empty trusts every peer
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.trusted(c.RemoteAddr()) {
+		return c, nil
+	}
+	return &Conn{Conn: c, br: bufio.NewReader(c), timeout: l.Timeout}, nil
+}
+
+func (l *Listener) trusted(addr net.Addr) bool {
+	if len(l.Allowed) == 0 {
+		return true
+	}
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return false
+	}
+	for _, p := range l.Allowed {
+		if p.Contains(ap.Addr().Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a connection whose header is read on first use, by the
+// goroutine serving it rather than the one accepting.
+type Conn struct {
+	net.Conn
+	br      *bufio.Reader
+	timeout time.Duration
+	once    sync.Once
+	src     net.Addr // from the header; nil for LOCAL and UNKNOWN
+	err     error
+
+	mu       sync.Mutex
+	deadline time.Time // the read deadline set by the server, restored after the header
+}
+
+func (c *Conn) init() {
+	c.once.Do(func() {
+		if c.timeout > 0 {
+			c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		}
+		c.src, c.err = ReadHeader(c.br)
+		if c.timeout > 0 {
+			c.mu.Lock()
+			c.Conn.SetReadDeadline(c.deadline)
+			c.mu.Unlock()
+		}
+		if c.err != nil {
+			log.Printf("WARN proxy protocol: %s: %v", c.Conn.RemoteAddr(), c.err)
+			c.Conn.Close()
+		}
+	})
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	if c.init(); c.err != nil {
+		return 0, c.err
+	}
+	return c.br.Read(b)
+}
+
+// RemoteAddr is the client's address from the header, or the peer's when
+// the header has none (LOCAL health checks, UNKNOWN) or was rejected.
+func (c *Conn) RemoteAddr() net.Addr {
+	if c.init(); c.src != nil {
+		return c.src
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// ReadHeader consumes a v1 or v2 header from r and returns the source
+// address it carries, nil for a v2 LOCAL command, a v1 UNKNOWN or an
+// address family other than TCP or UDP over IPv4 or IPv6.
+func ReadHeader(r *bufio.Reader) (net.Addr, error) {
+	start, err := r.Peek(len(v2Signature)) // shorter than any v1 header too
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	switch {
+	case bytes.Equal(start, v2Signature):
+		return readV2(r)
+	case bytes.HasPrefix(start, []byte("PROXY ")):
+		return readV1(r)
+	}
+	return nil, errors.New("no PROXY header")
+}
+
+// readV1 parses "PROXY TCP4|TCP6 src dst sport dport\r\n" or
+// "PROXY UNKNOWN ...\r\n".
+func readV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < maxV1Len {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("reading v1 header: %w", err)
+		}
+		line = append(line, b)
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			break
+		}
+	}
+	text, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, fmt.Errorf("v1 header longer than %d bytes", maxV1Len)
+	}
+	fields := strings.Split(text, " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("malformed v1 header %q", text)
+	}
+	src, err := netip.ParseAddr(fields[2])
+	if err != nil || src.Is4() != (fields[1] == "TCP4") {
+		return nil, fmt.Errorf("v1 header: bad source address %q", fields[2])
+	}
+	if _, err := netip.ParseAddr(fields[3]); err != nil {
+		return nil, fmt.Errorf("v1 header: bad destination address %q", fields[3])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil || (len(fields[4]) > 1 && fields[4][0] == '0') {
+		return nil, fmt.Errorf("v1 header: bad source port %q", fields[4])
+	}
+	if _, err := strconv.ParseUint(fields[5], 10, 16); err != nil {
+		return nil, fmt.Errorf("v1 header: bad destination port %q", fields[5])
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(src, uint16(port))), nil
+}
+
+// readV2 parses the binary header: signature, version and command,
+// family and transport, length, then the addresses and any TLVs, which
+// are skipped.
+func readV2(r *bufio.Reader) (net.Addr, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, fmt.Errorf("reading v2 header: %w", err)
+	}
+	if version := hdr[12] >> 4; version != 2 {
+		return nil, fmt.Errorf("v2 header: version %d", version)
+	}
+	command, family, transport := hdr[12]&0x0f, hdr[13]>>4, hdr[13]&0x0f
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("reading v2 addresses: %w", err)
+	}
+	switch command {
+	case 0x0: // LOCAL: the balancer's own connection, such as a health check
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("v2 header: command %d", command)
+	}
+	var size int
+	switch family {
+	case 0x1: // AF_INET
+		size = 4
+	case 0x2: // AF_INET6
+		size = 16
+	default: // AF_UNSPEC, AF_UNIX
+		return nil, nil
+	}
+	if len(body) < 2*size+4 {
+		return nil, fmt.Errorf("v2 header: %d bytes of addresses, want at least %d", len(body), 2*size+4)
+	}
+	src, _ := netip.AddrFromSlice(body[:size])
+	port := binary.BigEndian.Uint16(body[2*size:])
+	ap := netip.AddrPortFrom(src, port)
+	switch transport {
+	case 0x1: // STREAM
+		return net.TCPAddrFromAddrPort(ap), nil
+	case 0x2: // DGRAM
+		return net.UDPAddrFromAddrPort(ap), nil
+	}
+	return nil, fmt.Errorf("v2 header: transport %d", transport)
+}
@@ -0,0 +1,260 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+// v2Header builds a v2 header: command is 0 for LOCAL, 1 for PROXY;
+// proto the family and transport byte (0x11 TCP over IPv4, 0x21 over
+// IPv6, 0x12 UDP over IPv4); body the addresses, ports and TLVs.
+func v2Header(command, proto byte, body []byte) []byte {
+	h := append([]byte{}, v2Signature...)
+	h = append(h, 0x20|command, proto)
+	h = binary.BigEndian.AppendUint16(h, uint16(len(body)))
+	return append(h, body...)
+}
+
+// v2Addrs is the address block for src:sport to dst:dport.
+func v2Addrs(src, dst string, sport, dport uint16) []byte {
+	b := netip.MustParseAddr(src).AsSlice()
+	b = append(b, netip.MustParseAddr(dst).AsSlice()...)
+	b = binary.BigEndian.AppendUint16(b, sport)
+	return binary.BigEndian.AppendUint16(b, dport)
+}
+
+// tlv is a type-length-value entry, which ReadHeader skips.
+func tlv(typ byte, value string) []byte {
+	return append(binary.BigEndian.AppendUint16([]byte{typ}, uint16(len(value))), value...)
+}
+
+// readAll runs ReadHeader on header followed by a request, and returns
+// what the connection reads after it.
+func readAll(t *testing.T, header []byte) (net.Addr, string, error) {
+	t.Helper()
+	r := bufio.NewReader(io.MultiReader(bytes.NewReader(header), strings.NewReader("GET / HTTP/1.1\r\n")))
+	addr, err := ReadHeader(r)
+	rest, _ := io.ReadAll(r)
+	return addr, string(rest), err
+}
+
+func TestReadHeaderV1(t *testing.T) {
+	tests := []struct {
+		name, header string
+		want         string // the source address; "" for none
+		wantErr      string
+	}{
+		{"TCP4", "PROXY TCP4 203.0.113.7 10.0.0.1 51234 443\r\n", "203.0.113.7:51234", ""},
+		{"TCP6", "PROXY TCP6 2001:db8::7 2001:db8::1 51234 443\r\n", "[2001:db8::7]:51234", ""},
+		{"UNKNOWN", "PROXY UNKNOWN\r\n", "", ""},
+		{"UNKNOWN with addresses", "PROXY UNKNOWN ffff:f...f:ffff ffff:f...f:ffff 65535 65535\r\n", "", ""},
+		{"IPv6 as TCP4", "PROXY TCP4 2001:db8::7 10.0.0.1 51234 443\r\n", "", "bad source address"},
+		{"bad destination", "PROXY TCP4 203.0.113.7 nowhere 51234 443\r\n", "", "bad destination address"},
+		{"port out of range", "PROXY TCP4 203.0.113.7 10.0.0.1 65536 443\r\n", "", "bad source port"},
+		{"leading zero", "PROXY TCP4 203.0.113.7 10.0.0.1 0443 443\r\n", "", "bad source port"},
+		{"missing port", "PROXY TCP4 203.0.113.7 10.0.0.1 51234\r\n", "", "malformed v1 header"},
+		{"UDP", "PROXY UDP4 203.0.113.7 10.0.0.1 51234 443\r\n", "", "malformed v1 header"},
+		{"LF only", "PROXY TCP4 203.0.113.7 10.0.0.1 51234 443\n", "", "malformed v1 header"}, // runs into the request
+		{"too long", "PROXY TCP4 " + strings.Repeat("1", 100) + "\r\n", "", "longer than 107 bytes"},
+	}
+	for _, tt := range tests {
+		addr, rest, err := readAll(t, []byte(tt.header))
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := addrString(addr); got != tt.want {
+			t.Errorf("%s: source %s, want %s", tt.name, got, tt.want)
+		}
+		if rest != "GET / HTTP/1.1\r\n" {
+			t.Errorf("%s: %q left after the header", tt.name, rest)
+		}
+	}
+}
+
+func TestReadHeaderV2(t *testing.T) {
+	ipv4 := v2Addrs("203.0.113.7", "10.0.0.1", 51234, 443)
+	tlvs := append(tlv(0x01, "h2"), tlv(0x02, "api.example.com")...) // ALPN, authority
+	tests := []struct {
+		name    string
+		header  []byte
+		want    string
+		udp     bool
+		wantErr string
+	}{
+		{"TCP over IPv4", v2Header(1, 0x11, ipv4), "203.0.113.7:51234", false, ""},
+		{"TCP over IPv6", v2Header(1, 0x21, v2Addrs("2001:db8::7", "2001:db8::1", 51234, 443)), "[2001:db8::7]:51234", false, ""},
+		{"UDP", v2Header(1, 0x12, ipv4), "203.0.113.7:51234", true, ""},
+		{"TLVs skipped", v2Header(1, 0x11, append(ipv4, tlvs...)), "203.0.113.7:51234", false, ""},
+		{"LOCAL", v2Header(0, 0x00, nil), "", false, ""},
+		{"LOCAL with addresses", v2Header(0, 0x11, ipv4), "", false, ""},
+		{"AF_UNSPEC", v2Header(1, 0x00, nil), "", false, ""},
+		{"AF_UNIX", v2Header(1, 0x31, make([]byte, 216)), "", false, ""},
+		{"bad signature", append([]byte("\r\n\r\n\x00\r\nQUIX\n"), v2Header(1, 0x11, ipv4)[12:]...), "", false, "no PROXY header"},
+		{"version 1", append(append([]byte{}, v2Signature...), append([]byte{0x11, 0x11, 0, 12}, ipv4...)...), "", false, "version 1"},
+		{"unknown command", v2Header(2, 0x11, ipv4), "", false, "command 2"},
+		{"unknown transport", v2Header(1, 0x13, ipv4), "", false, "transport 3"},
+		{"truncated header", v2Header(1, 0x11, ipv4)[:14], "", false, "reading v2 header"},
+		{"truncated addresses", v2Header(1, 0x11, ipv4)[:20], "", false, "reading v2 addresses"},
+		{"length too short", v2Header(1, 0x21, ipv4), "", false, "12 bytes of addresses, want at least 36"},
+		{"empty", nil, "", false, "reading header"},
+	}
+	for _, tt := range tests {
+		addr, err := ReadHeader(bufio.NewReader(bytes.NewReader(tt.header)))
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := addrString(addr); got != tt.want {
+			t.Errorf("%s: source %s, want %s", tt.name, got, tt.want)
+		}
+		if _, isUDP := addr.(*net.UDPAddr); addr != nil && isUDP != tt.udp {
+			t.Errorf("%s: source %T", tt.name, addr)
+		}
+	}
+
+	// The request after the header, TLVs and all, is left to read.
+	_, rest, err := readAll(t, v2Header(1, 0x11, append(ipv4, tlvs...)))
+	if err != nil || rest != "GET / HTTP/1.1\r\n" {
+		t.Errorf("after the TLVs: %q, %v", rest, err)
+	}
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
+
+// quietLog discards the standard log for the test.
+func quietLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	out := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(out) })
+	return &buf
+}
+
+// accept wraps a loopback listener in l, sends what the client writes
+// and returns the connection the server accepted.
+func accept(t *testing.T, l *Listener, send string) net.Conn {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Listener = ln
+	t.Cleanup(func() { ln.Close() })
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	if send != "" {
+		if _, err := io.WriteString(client, send); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// A balancer that connects and sends nothing is cut off after Timeout,
+// and the server's own deadline is restored once a header is read.
+func TestListenerTimeout(t *testing.T) {
+	logs := quietLog(t)
+	c := accept(t, &Listener{Timeout: 100 * time.Millisecond}, "")
+	start := time.Now()
+	_, err := c.Read(make([]byte, 1))
+	if took := time.Since(start); err == nil || took < 100*time.Millisecond || took > 2*time.Second {
+		t.Errorf("Read: %v after %s, want an error after the 100ms timeout", err, took)
+	}
+	if !strings.Contains(logs.String(), "WARN proxy protocol: ") {
+		t.Errorf("the timeout was not logged:\n%s", logs)
+	}
+
+	c = accept(t, &Listener{Timeout: 50 * time.Millisecond}, "PROXY TCP4 203.0.113.7 10.0.0.1 51234 443\r\n")
+	c.SetReadDeadline(time.Time{})
+	if c.RemoteAddr().String() != "203.0.113.7:51234" {
+		t.Fatalf("RemoteAddr %s", c.RemoteAddr())
+	}
+	read := make(chan error, 1)
+	go func() {
+		_, err := c.Read(make([]byte, 1))
+		read <- err
+	}()
+	select {
+	case err := <-read:
+		t.Errorf("the header timeout outlived the header: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+// Only peers in Allowed may send a header; anyone else's bytes, a header
+// included, reach the server as they are, under the peer's own address.
+func TestListenerAllowed(t *testing.T) {
+	logs := quietLog(t)
+	const header = "PROXY TCP4 203.0.113.7 10.0.0.1 51234 443\r\n"
+	loopback := []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+	balancers := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("fd00::/8")}
+
+	c := accept(t, &Listener{Allowed: loopback}, header+"ping")
+	if got := c.RemoteAddr().String(); got != "203.0.113.7:51234" {
+		t.Errorf("trusted peer: RemoteAddr %s", got)
+	}
+	if got := readN(t, c, 4); got != "ping" {
+		t.Errorf("trusted peer: read %q", got)
+	}
+
+	c = accept(t, &Listener{Allowed: balancers}, header)
+	if ap := netip.MustParseAddrPort(c.RemoteAddr().String()); !ap.Addr().IsLoopback() {
+		t.Errorf("untrusted peer: RemoteAddr %s, want its own", ap)
+	}
+	if got := readN(t, c, len(header)); got != header {
+		t.Errorf("untrusted peer: read %q, want its header passed through", got)
+	}
+
+	// A trusted peer must send a header.
+	c = accept(t, &Listener{Allowed: loopback}, "GET / HTTP/1.1\r\n\r\n")
+	if _, err := c.Read(make([]byte, 1)); err == nil || !strings.Contains(err.Error(), "no PROXY header") {
+		t.Errorf("trusted peer without a header: %v", err)
+	}
+	if !strings.Contains(logs.String(), "no PROXY header") {
+		t.Errorf("the rejection was not logged:\n%s", logs)
+	}
+}
+
+func readN(t *testing.T, c net.Conn, n int) string {
+	t.Helper()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
@@ -0,0 +1,133 @@
+package saml
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	nsDSig = "http://www.w3.org/2000/09/xmldsig#"
+
+	algExcC14N   = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	algEnveloped = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	algRSASHA256 = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	algRSASHA512 = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha512"
+	algDigest256 = "http://www.w3.org/2001/04/xmlenc#sha256"
+	algDigest512 = "http://www.w3.org/2001/04/xmlenc#sha512"
+)
+
+var signatureHashes = map[string]crypto.Hash{algRSASHA256: crypto.SHA256, algRSASHA512: crypto.SHA512}
+var digestHashes = map[string]crypto.Hash{algDigest256: crypto.SHA256, algDigest512: crypto.SHA512}
+
+// errNotSigned is returned by verify when el carries no signature.
+var errNotSigned = errors.New("not signed")
+
+// verify checks the enveloped XML signature of el (a direct ds:Signature
+// child) against cert. Only what SAML IdPs produce is accepted: exclusive
+// C14N, RSA with SHA-256 or SHA-512, one Reference to el itself. The
+// certificate in KeyInfo, if any, is ignored: trust comes from cert alone.
+// SHA-1 is refused.
+func verify(el *node, cert *x509.Certificate) error {
+	sig := el.child(nsDSig, "Signature")
+	if sig == nil {
+		return errNotSigned
+	}
+	if n := len(el.all(nsDSig, "Signature")); n > 1 {
+		return fmt.Errorf("%d signatures", n)
+	}
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("IdP certificate has no RSA key")
+	}
+	info := sig.child(nsDSig, "SignedInfo")
+	if info == nil {
+		return errors.New("no SignedInfo")
+	}
+	cm := info.child(nsDSig, "CanonicalizationMethod")
+	if cm == nil || cm.attr("Algorithm") != algExcC14N {
+		return errors.New("unsupported canonicalization")
+	}
+	hash, ok := signatureHashes[info.child(nsDSig, "SignatureMethod").attrOrEmpty("Algorithm")]
+	if !ok {
+		return errors.New("unsupported signature method")
+	}
+
+	refs := info.all(nsDSig, "Reference")
+	if len(refs) != 1 {
+		return fmt.Errorf("%d references", len(refs))
+	}
+	ref := refs[0]
+	id := el.attr("ID")
+	if id == "" || ref.attr("URI") != "#"+id {
+		return errors.New("reference is not to the signed element")
+	}
+	var inclusive []string
+	if ts := ref.child(nsDSig, "Transforms"); ts != nil {
+		for _, t := range ts.all(nsDSig, "Transform") {
+			switch t.attr("Algorithm") {
+			case algEnveloped:
+			case algExcC14N:
+				inclusive = prefixList(t)
+			default:
+				return fmt.Errorf("unsupported transform %q", t.attr("Algorithm"))
+			}
+		}
+	}
+	digestHash, ok := digestHashes[ref.child(nsDSig, "DigestMethod").attrOrEmpty("Algorithm")]
+	if !ok {
+		return errors.New("unsupported digest method")
+	}
+	want, err := base64.StdEncoding.DecodeString(compact(ref.child(nsDSig, "DigestValue").text()))
+	if err != nil {
+		return errors.New("malformed digest")
+	}
+	h := digestHash.New()
+	h.Write(canonicalize(el, sig, inclusive))
+	if subtle.ConstantTimeCompare(h.Sum(nil), want) != 1 {
+		return errors.New("digest mismatch")
+	}
+
+	value, err := base64.StdEncoding.DecodeString(compact(sig.child(nsDSig, "SignatureValue").text()))
+	if err != nil {
+		return errors.New("malformed signature value")
+	}
+	h = hash.New()
+	h.Write(canonicalize(info, nil, prefixList(cm)))
+	if err := rsa.VerifyPKCS1v15(pub, hash, h.Sum(nil), value); err != nil {
+		return errors.New("bad signature")
+	}
+	return nil
+}
+
+// prefixList reads the InclusiveNamespaces PrefixList under a C14N
+// method or transform.
+func prefixList(n *node) []string {
+	if in := n.child(algExcC14N, "InclusiveNamespaces"); in != nil {
+		return strings.Fields(in.attr("PrefixList"))
+	}
+	return nil
+}
+
+// attrOrEmpty is attr that tolerates a missing element.
+func (n *node) attrOrEmpty(name string) string {
+	if n == nil {
+		return ""
+	}
+	return n.attr(name)
+}
+
+// compact drops the whitespace IdPs wrap base64 values with.
+func compact(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\n' || r == '\r' || r == '\t' {
+			return -1
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,472 @@
+package saml
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The responses below are shaped like Okta's: saml2/saml2p prefixes, an
+// xs:string attribute value and so an InclusiveNamespaces list of "xs",
+// signatures after the Issuer. They are written already canonical, so the
+// digests are taken over the literal text, with the signature left out,
+// and do not depend on canonicalize.
+
+const (
+	testACS      = "https://sp.example.com/api/v1/auth/saml/acs"
+	testSP       = "https://sp.example.com"
+	testIdP      = "https://idp.example.com"
+	testRequest  = "req-1"
+	testNotAfter = "2026-10-16T12:05:00.000Z"
+
+	algRSASHA1  = "http://www.w3.org/2000/09/xmldsig#rsa-sha1"
+	algDigest1  = "http://www.w3.org/2000/09/xmldsig#sha1"
+	algC14N     = "http://www.w3.org/TR/2001/REC-xml-c14n-20010315"
+	algRSAMD5   = "http://www.w3.org/2001/04/xmldsig-more#rsa-md5"
+	algDigest3x = "http://www.w3.org/2007/05/xmldsig-more#sha3-256"
+)
+
+// testNow is a minute into the assertion's five-minute window.
+var testNow = time.Date(2026, 10, 16, 12, 1, 0, 0, time.UTC)
+
+// testHashes signs with the hash an algorithm names, including the ones
+// verify refuses; unknown algorithms are signed with SHA-256.
+var testHashes = map[string]crypto.Hash{
+	algRSASHA256: crypto.SHA256, algRSASHA512: crypto.SHA512, algRSASHA1: crypto.SHA1,
+	algDigest256: crypto.SHA256, algDigest512: crypto.SHA512, algDigest1: crypto.SHA1,
+}
+
+type testKey struct {
+	key  *rsa.PrivateKey
+	cert *x509.Certificate
+}
+
+var idpKeys = sync.OnceValues(func() (*testKey, *testKey) { return newTestKey(), newTestKey() })
+
+// newTestKey is a self-signed IdP signing certificate.
+func newTestKey() *testKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.example.com"},
+		NotBefore:    testNow.Add(-time.Hour),
+		NotAfter:     testNow.Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(err)
+	}
+	return &testKey{key, cert}
+}
+
+// idpResponse describes a response to sign; the zero value of a field is
+// the valid default.
+type idpResponse struct {
+	NameID       string
+	Audience     string
+	Recipient    string
+	NotOnOrAfter string
+
+	SignResponse  bool
+	SignAssertion bool
+
+	C14NAlg      string // CanonicalizationMethod
+	SignatureAlg string
+	DigestAlg    string
+	Key          *testKey
+}
+
+func (r idpResponse) or(v, def string) string {
+	if v == "" {
+		return def
+	}
+	return v
+}
+
+// signedInfo is the canonical SignedInfo of a signature over id, alone;
+// inside the document its ds namespace comes from the Signature.
+func (r idpResponse) signedInfo(id, digest string) string {
+	return `<ds:SignedInfo xmlns:ds="http://www.w3.org/2000/09/xmldsig#">` +
+		`<ds:CanonicalizationMethod Algorithm="` + r.or(r.C14NAlg, algExcC14N) + `"></ds:CanonicalizationMethod>` +
+		`<ds:SignatureMethod Algorithm="` + r.or(r.SignatureAlg, algRSASHA256) + `"></ds:SignatureMethod>` +
+		`<ds:Reference URI="#` + id + `"><ds:Transforms>` +
+		`<ds:Transform Algorithm="` + algEnveloped + `"></ds:Transform>` +
+		`<ds:Transform Algorithm="` + algExcC14N + `"><ec:InclusiveNamespaces xmlns:ec="http://www.w3.org/2001/10/xml-exc-c14n#" PrefixList="xs"></ec:InclusiveNamespaces></ds:Transform>` +
+		`</ds:Transforms><ds:DigestMethod Algorithm="` + r.or(r.DigestAlg, algDigest256) + `"></ds:DigestMethod>` +
+		`<ds:DigestValue>` + digest + `</ds:DigestValue></ds:Reference></ds:SignedInfo>`
+}
+
+// signature signs the canonical form of the element with the given ID.
+func (r idpResponse) signature(t *testing.T, id, c14n string) string {
+	t.Helper()
+	key := r.Key
+	if key == nil {
+		key, _ = idpKeys()
+	}
+	hashed := func(alg, data string) (crypto.Hash, []byte) {
+		hash, ok := testHashes[alg]
+		if !ok {
+			hash = crypto.SHA256
+		}
+		h := hash.New()
+		h.Write([]byte(data))
+		return hash, h.Sum(nil)
+	}
+	_, digest := hashed(r.or(r.DigestAlg, algDigest256), c14n)
+	info := r.signedInfo(id, base64.StdEncoding.EncodeToString(digest))
+	hash, sum := hashed(r.or(r.SignatureAlg, algRSASHA256), info)
+	value, err := rsa.SignPKCS1v15(rand.Reader, key.key, hash, sum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return `<ds:Signature xmlns:ds="http://www.w3.org/2000/09/xmldsig#">` +
+		strings.Replace(info, ` xmlns:ds="http://www.w3.org/2000/09/xmldsig#"`, "", 1) +
+		`<ds:SignatureValue>` + wrap(value) + `</ds:SignatureValue>` +
+		`<ds:KeyInfo><ds:X509Data><ds:X509Certificate>` + wrap(key.cert.Raw) + `</ds:X509Certificate></ds:X509Data></ds:KeyInfo>` +
+		`</ds:Signature>`
+}
+
+// wrap is base64 in 64-column lines, as IdPs write it.
+func wrap(b []byte) string {
+	s := base64.StdEncoding.EncodeToString(b)
+	var lines []string
+	for len(s) > 64 {
+		lines, s = append(lines, s[:64]), s[64:]
+	}
+	return "\n" + strings.Join(append(lines, s), "\n") + "\n"
+}
+
+// assertion is the canonical Assertion with sig after its Issuer.
+func (r idpResponse) assertion(sig string) string {
+	notAfter := r.or(r.NotOnOrAfter, testNotAfter)
+	return `<saml2:Assertion xmlns:saml2="urn:oasis:names:tc:SAML:2.0:assertion" xmlns:xs="http://www.w3.org/2001/XMLSchema" ID="id-assertion" IssueInstant="2026-10-16T12:00:00.000Z" Version="2.0">
+    <saml2:Issuer Format="urn:oasis:names:tc:SAML:2.0:nameid-format:entity">` + testIdP + `</saml2:Issuer>` + sig + `
+    <saml2:Subject>
+      <saml2:NameID Format="urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress">` + r.or(r.NameID, "alice@example.com") + `</saml2:NameID>
+      <saml2:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer">
+        <saml2:SubjectConfirmationData InResponseTo="` + testRequest + `" NotOnOrAfter="` + notAfter + `" Recipient="` + r.or(r.Recipient, testACS) + `"></saml2:SubjectConfirmationData>
+      </saml2:SubjectConfirmation>
+    </saml2:Subject>
+    <saml2:Conditions NotBefore="2026-10-16T11:59:00.000Z" NotOnOrAfter="` + notAfter + `">
+      <saml2:AudienceRestriction>
+        <saml2:Audience>` + r.or(r.Audience, testSP) + `</saml2:Audience>
+      </saml2:AudienceRestriction>
+    </saml2:Conditions>
+    <saml2:AuthnStatement AuthnInstant="2026-10-16T12:00:00.000Z" SessionIndex="id-assertion">
+      <saml2:AuthnContext>
+        <saml2:AuthnContextClassRef>urn:oasis:names:tc:SAML:2.0:ac:classes:PasswordProtectedTransport</saml2:AuthnContextClassRef>
+      </saml2:AuthnContext>
+    </saml2:AuthnStatement>
+    <saml2:AttributeStatement>
+      <saml2:Attribute Name="firstName" NameFormat="urn:oasis:names:tc:SAML:2.0:attrname-format:unspecified">
+        <saml2:AttributeValue xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="xs:string">Alice</saml2:AttributeValue>
+      </saml2:Attribute>
+    </saml2:AttributeStatement>
+  </saml2:Assertion>`
+}
+
+// response is the canonical Response with sig after its Issuer.
+func (r idpResponse) response(sig, assertion string) string {
+	return `<saml2p:Response xmlns:saml2p="urn:oasis:names:tc:SAML:2.0:protocol" Destination="` + testACS + `" ID="id-response" InResponseTo="` + testRequest + `" IssueInstant="2026-10-16T12:00:00.000Z" Version="2.0">
+  <saml2:Issuer xmlns:saml2="urn:oasis:names:tc:SAML:2.0:assertion" Format="urn:oasis:names:tc:SAML:2.0:nameid-format:entity">` + testIdP + `</saml2:Issuer>` + sig + `
+  <saml2p:Status>
+    <saml2p:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success"></saml2p:StatusCode>
+  </saml2p:Status>
+  ` + assertion + `
+</saml2p:Response>`
+}
+
+// sign returns the response document, signed as r asks: the assertion
+// first, then the response over it, as IdPs that sign both do.
+func (r idpResponse) sign(t *testing.T) string {
+	t.Helper()
+	var asSig, respSig string
+	if r.SignAssertion {
+		asSig = r.signature(t, "id-assertion", r.assertion(""))
+	}
+	assertion := r.assertion(asSig)
+	if r.SignResponse {
+		respSig = r.signature(t, "id-response", r.response("", assertion))
+	}
+	return r.response(respSig, assertion)
+}
+
+func testServiceProvider() *SP {
+	key, _ := idpKeys()
+	return &SP{EntityID: testSP, ACSURL: testACS, IdPEntityID: testIdP, IdPCert: key.cert, ClockSkew: time.Minute}
+}
+
+// parse posts doc to the SP as the IdP would.
+func parse(doc string, now time.Time) (*Assertion, error) {
+	return testServiceProvider().ParseResponse(base64.StdEncoding.EncodeToString([]byte(doc)), testRequest, now)
+}
+
+// wantRejected expects doc to be refused for a reason containing why.
+func wantRejected(t *testing.T, doc, why string) {
+	t.Helper()
+	a, err := parse(doc, testNow)
+	if err == nil {
+		t.Fatalf("accepted, NameID %q", a.NameID)
+	}
+	if !errors.Is(err, ErrInvalid) || !strings.Contains(err.Error(), why) {
+		t.Errorf("got %v, want %q", err, why)
+	}
+}
+
+// replace is strings.Replace that fails the test when old is missing.
+func replace(t *testing.T, s, old, new string) string {
+	t.Helper()
+	if !strings.Contains(s, old) {
+		t.Fatalf("%q not in the document", old)
+	}
+	return strings.Replace(s, old, new, 1)
+}
+
+func TestParseSignedResponse(t *testing.T) {
+	for _, r := range []idpResponse{
+		{SignResponse: true, SignAssertion: true},
+		{SignResponse: true},
+		{SignAssertion: true},
+		{SignAssertion: true, SignatureAlg: algRSASHA512, DigestAlg: algDigest512},
+	} {
+		t.Run(fmt.Sprintf("response %v assertion %v %s", r.SignResponse, r.SignAssertion, r.or(r.SignatureAlg, "")), func(t *testing.T) {
+			a, err := parse(r.sign(t), testNow)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a.ID != "id-assertion" || a.NameID != "alice@example.com" || !a.IsEmail() || a.Attribute("firstName") != "Alice" {
+				t.Errorf("got %+v", a)
+			}
+			if want := time.Date(2026, 10, 16, 12, 6, 0, 0, time.UTC); !a.Expires.Equal(want) {
+				t.Errorf("Expires %v, want NotOnOrAfter plus the skew, %v", a.Expires, want)
+			}
+		})
+	}
+}
+
+// TestCanonicalFixture checks canonicalize against the literal text the
+// fixture signs.
+func TestCanonicalFixture(t *testing.T) {
+	r := idpResponse{SignResponse: true, SignAssertion: true}
+	doc := r.sign(t)
+	resp, err := parseXML([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	as := resp.child(nsAssertion, "Assertion")
+	if got, want := string(canonicalize(as, as.child(nsDSig, "Signature"), []string{"xs"})), r.assertion(""); got != want {
+		t.Errorf("assertion:\ngot  %s\nwant %s", got, want)
+	}
+	info := as.child(nsDSig, "Signature").child(nsDSig, "SignedInfo")
+	if got := string(canonicalize(info, nil, nil)); !strings.HasPrefix(got, `<ds:SignedInfo xmlns:ds="http://www.w3.org/2000/09/xmldsig#"><ds:CanonicalizationMethod`) {
+		t.Errorf("SignedInfo: %s", got)
+	}
+}
+
+// TestParseReserializedResponse signs a response, then writes it out the
+// way an IdP's XML library might: namespaces declared on the root,
+// attributes in another order, empty elements closed short, character
+// references, comments and an XML declaration. Canonicalization undoes
+// all of it, so the signatures still hold.
+func TestParseReserializedResponse(t *testing.T) {
+	doc := idpResponse{SignResponse: true, SignAssertion: true}.sign(t)
+	const (
+		saml2 = ` xmlns:saml2="urn:oasis:names:tc:SAML:2.0:assertion"`
+		ds    = ` xmlns:ds="http://www.w3.org/2000/09/xmldsig#"`
+	)
+	doc = strings.ReplaceAll(doc, saml2, "")
+	doc = strings.ReplaceAll(doc, ds, "")
+	for _, e := range []string{"saml2p:StatusCode", "ds:CanonicalizationMethod", "ds:SignatureMethod", "ds:Transform", "ec:InclusiveNamespaces", "ds:DigestMethod", "saml2:SubjectConfirmationData"} {
+		doc = closeEmpty(doc, e)
+	}
+	doc = replace(t, doc, `<saml2p:Response xmlns:saml2p="urn:oasis:names:tc:SAML:2.0:protocol" Destination="`+testACS+`" ID="id-response"`,
+		`<saml2p:Response Version="2.0" xmlns:unused="urn:unused"`+ds+saml2+` xmlns:saml2p='urn:oasis:names:tc:SAML:2.0:protocol' ID='id-response' Destination="`+testACS+`"`)
+	doc = replace(t, doc, `IssueInstant="2026-10-16T12:00:00.000Z" Version="2.0">
+  <saml2:Issuer`, `IssueInstant="2026-10-16T12:00:00.000Z">
+  <saml2:Issuer`)
+	doc = replace(t, doc, `>Alice<`, `>&#65;lice<`)
+	doc = replace(t, doc, `<saml2:Subject>`, `<saml2:Subject><!-- subject -->`)
+	doc = `<?xml version="1.0" encoding="UTF-8"?>` + "\n<!-- issued by the IdP -->\n" + doc + "\n"
+
+	a, err := parse(doc, testNow)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, doc)
+	}
+	if a.NameID != "alice@example.com" || a.Attribute("firstName") != "Alice" {
+		t.Errorf("got %+v", a)
+	}
+}
+
+// outer returns the first element named name in doc, tags included.
+func outer(doc, name string) string {
+	end := "</" + name + ">"
+	return doc[strings.Index(doc, "<"+name) : strings.Index(doc, end)+len(end)]
+}
+
+// closeEmpty closes the empty element e short, as <e .../>.
+func closeEmpty(doc, e string) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(doc, "<"+e+" ")
+		if i < 0 {
+			return b.String() + doc
+		}
+		end := strings.Index(doc[i:], ">") + i
+		if !strings.HasPrefix(doc[end+1:], "</"+e+">") {
+			b.WriteString(doc[:end+1])
+			doc = doc[end+1:]
+			continue
+		}
+		b.WriteString(doc[:end] + "/>")
+		doc = doc[end+1+len("</"+e+">"):]
+	}
+}
+
+func TestSignatureWrapping(t *testing.T) {
+	const evilNameID = "admin@example.com"
+	evil := strings.NewReplacer(`ID="id-assertion"`, `ID="id-evil"`, "alice@example.com", evilNameID).Replace(idpResponse{}.assertion(""))
+	assertionSigned := idpResponse{SignAssertion: true}
+	responseSigned := idpResponse{SignResponse: true}
+
+	t.Run("modified after signing", func(t *testing.T) {
+		wantRejected(t, replace(t, assertionSigned.sign(t), "alice@example.com", evilNameID), "digest mismatch")
+		wantRejected(t, replace(t, responseSigned.sign(t), "alice@example.com", evilNameID), "digest mismatch")
+	})
+
+	t.Run("duplicate ID", func(t *testing.T) {
+		// The signed assertion hidden in Extensions, an altered copy with
+		// the same ID where the SP reads.
+		doc := assertionSigned.sign(t)
+		signed := outer(doc, "saml2:Assertion")
+		forged := strings.Replace(signed, "alice@example.com", evilNameID, 1)
+		doc = replace(t, doc, signed, forged)
+		doc = replace(t, doc, "<saml2p:Status>", "<saml2p:Extensions>"+signed+"</saml2p:Extensions><saml2p:Status>")
+		wantRejected(t, doc, "duplicate ID")
+	})
+
+	t.Run("assertion moved", func(t *testing.T) {
+		doc := assertionSigned.sign(t)
+		signed := outer(doc, "saml2:Assertion")
+		doc = replace(t, doc, signed, evil)
+		doc = replace(t, doc, "<saml2p:Status>", "<saml2p:Extensions>"+signed+"</saml2p:Extensions><saml2p:Status>")
+		wantRejected(t, doc, "neither the response nor the assertion is signed")
+	})
+
+	t.Run("assertion wrapped in another", func(t *testing.T) {
+		doc := assertionSigned.sign(t)
+		signed := outer(doc, "saml2:Assertion")
+		wrapped := replace(t, evil, "<saml2:Subject>", "<saml2:Advice>"+signed+"</saml2:Advice><saml2:Subject>")
+		wantRejected(t, replace(t, doc, signed, wrapped), "neither the response nor the assertion is signed")
+	})
+
+	t.Run("signature moved to another assertion", func(t *testing.T) {
+		doc := assertionSigned.sign(t)
+		sig := outer(doc, "ds:Signature")
+		signed := outer(doc, "saml2:Assertion")
+		forged := replace(t, evil, "</saml2:Issuer>", "</saml2:Issuer>"+sig)
+		wantRejected(t, replace(t, doc, signed, forged), "reference is not to the signed element")
+	})
+
+	t.Run("extra assertion", func(t *testing.T) {
+		for _, r := range []idpResponse{assertionSigned, responseSigned} {
+			doc := r.sign(t)
+			wantRejected(t, replace(t, doc, "</saml2p:Response>", evil+"</saml2p:Response>"), "2 assertions")
+			wantRejected(t, replace(t, doc, "<saml2p:Status>", evil+"<saml2p:Status>"), "2 assertions")
+		}
+	})
+
+	t.Run("extra assertion under a signed response", func(t *testing.T) {
+		// Inserting anything breaks the response digest even where the SP
+		// does not look.
+		doc := responseSigned.sign(t)
+		wantRejected(t, replace(t, doc, "<saml2p:Status>", "<saml2p:Extensions>"+evil+"</saml2p:Extensions><saml2p:Status>"), "digest mismatch")
+	})
+
+	t.Run("two signatures", func(t *testing.T) {
+		doc := assertionSigned.sign(t)
+		sig := outer(doc, "ds:Signature")
+		wantRejected(t, replace(t, doc, sig, sig+sig), "2 signatures")
+	})
+
+	t.Run("unsigned", func(t *testing.T) {
+		wantRejected(t, idpResponse{}.sign(t), "neither the response nor the assertion is signed")
+	})
+
+	t.Run("signed by another key", func(t *testing.T) {
+		// KeyInfo carries the signer's certificate; only the configured
+		// one counts.
+		_, other := idpKeys()
+		wantRejected(t, idpResponse{SignAssertion: true, Key: other}.sign(t), "bad signature")
+		wantRejected(t, idpResponse{SignResponse: true, Key: other}.sign(t), "bad signature")
+	})
+
+	t.Run("DTD", func(t *testing.T) {
+		doc := `<!DOCTYPE r [<!ENTITY e "alice@example.com">]>` + assertionSigned.sign(t)
+		wantRejected(t, doc, "DTDs are not allowed")
+	})
+}
+
+// TestCommentInNameID is the comment truncation attack: the IdP signs
+// alice@example.com.evil.com, the attacker splits it with a comment,
+// which canonicalization drops so the signature still holds. The SP must
+// read the whole text, not the text before the comment.
+func TestCommentInNameID(t *testing.T) {
+	const signed = "alice@example.com.evil.com"
+	for _, r := range []idpResponse{{NameID: signed, SignAssertion: true}, {NameID: signed, SignResponse: true}} {
+		doc := replace(t, r.sign(t), ">"+signed+"<", ">alice@example.com<!---->.evil.com<")
+		a, err := parse(doc, testNow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.NameID != signed {
+			t.Errorf("NameID %q, want %q", a.NameID, signed)
+		}
+	}
+}
+
+func TestRefusedAlgorithms(t *testing.T) {
+	tests := []struct {
+		name string
+		r    idpResponse
+		why  string
+	}{
+		{"RSA-SHA1 signature", idpResponse{SignatureAlg: algRSASHA1}, "unsupported signature method"},
+		{"SHA-1 digest", idpResponse{DigestAlg: algDigest1}, "unsupported digest method"},
+		{"RSA-MD5 signature", idpResponse{SignatureAlg: algRSAMD5}, "unsupported signature method"},
+		{"unknown digest", idpResponse{DigestAlg: algDigest3x}, "unsupported digest method"},
+		{"inclusive canonicalization", idpResponse{C14NAlg: algC14N}, "unsupported canonicalization"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.r
+			r.SignAssertion = true
+			wantRejected(t, r.sign(t), "assertion signature: "+tt.why)
+			r.SignAssertion, r.SignResponse = false, true
+			wantRejected(t, r.sign(t), "response signature: "+tt.why)
+		})
+	}
+
+	t.Run("unknown transform", func(t *testing.T) {
+		doc := replace(t, idpResponse{SignAssertion: true}.sign(t), `<ds:Transform Algorithm="`+algEnveloped+`">`,
+			`<ds:Transform Algorithm="http://www.w3.org/TR/1999/REC-xslt-19991116">`)
+		wantRejected(t, doc, "unsupported transform")
+	})
+}
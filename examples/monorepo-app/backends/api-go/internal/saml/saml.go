@@ -0,0 +1,305 @@
+// Package saml is a minimal SAML 2.0 service provider: SP-initiated login
+// over the HTTP-Redirect binding, responses over HTTP-POST, and SP
+// metadata. It verifies XML signatures itself (exclusive C14N, RSA-SHA256
+// or SHA-512) and reads assertion data only from the signed elements, so
+// signature-wrapping tricks have nothing to hide behind. Encrypted
+// assertions and signed AuthnRequests are not supported.
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	nsProtocol  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	nsAssertion = "urn:oasis:names:tc:SAML:2.0:assertion"
+	nsMetadata  = "urn:oasis:names:tc:SAML:2.0:metadata"
+
+	bindingPOST   = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	statusSuccess = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	methodBearer  = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+
+	// NameIDEmail is the NameID format the SP asks for.
+	NameIDEmail = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+)
+
+// ErrInvalid wraps every reason a response is rejected.
+var ErrInvalid = errors.New("invalid SAML response")
+
+// SP is this service provider and the IdP it trusts.
+type SP struct {
+	EntityID string // ours, published in the metadata
+	ACSURL   string // where the IdP posts responses
+
+	IdPEntityID string
+	IdPSSOURL   string
+	IdPCert     *x509.Certificate
+
+	ClockSkew time.Duration // tolerated between our clock and the IdP's
+}
+
+// LoadCertificate reads a PEM certificate, as exported by the IdP.
+func LoadCertificate(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s: no PEM certificate", path)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// AuthnRequestURL returns the IdP URL that starts a login for requestID,
+// carrying relayState back to the ACS.
+func (sp *SP) AuthnRequestURL(requestID, relayState string, now time.Time) (string, error) {
+	req := fmt.Sprintf(`<samlp:AuthnRequest xmlns:samlp="%s" xmlns:saml="%s" ID="%s" Version="2.0" IssueInstant="%s" Destination="%s" AssertionConsumerServiceURL="%s" ProtocolBinding="%s">`+
+		`<saml:Issuer>%s</saml:Issuer><samlp:NameIDPolicy Format="%s" AllowCreate="true"/></samlp:AuthnRequest>`,
+		nsProtocol, nsAssertion, escapeAttr(requestID), now.UTC().Format(time.RFC3339), escapeAttr(sp.IdPSSOURL),
+		escapeAttr(sp.ACSURL), bindingPOST, escapeText(sp.EntityID), NameIDEmail)
+
+	var buf bytes.Buffer
+	fw, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+	fw.Write([]byte(req))
+	if err := fw.Close(); err != nil {
+		return "", err
+	}
+	u, err := url.Parse(sp.IdPSSOURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("SAMLRequest", base64.StdEncoding.EncodeToString(buf.Bytes()))
+	q.Set("RelayState", relayState)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Metadata is the SP metadata document to register with the IdP.
+func (sp *SP) Metadata() []byte {
+	return fmt.Appendf(nil, `<?xml version="1.0" encoding="UTF-8"?>
+<md:EntityDescriptor xmlns:md="%s" entityID="%s">
+  <md:SPSSODescriptor AuthnRequestsSigned="false" WantAssertionsSigned="true" protocolSupportEnumeration="%s">
+    <md:NameIDFormat>%s</md:NameIDFormat>
+    <md:AssertionConsumerService Binding="%s" Location="%s" index="0" isDefault="true"/>
+  </md:SPSSODescriptor>
+</md:EntityDescriptor>
+`, nsMetadata, escapeAttr(sp.EntityID), nsProtocol, NameIDEmail, bindingPOST, escapeAttr(sp.ACSURL))
+}
+
+// Assertion is what the SP takes from a valid response.
+type Assertion struct {
+	ID           string
+	NameID       string
+	NameIDFormat string
+	Attributes   map[string][]string
+	// Expires is when the assertion stops being usable; its ID must be
+	// remembered until then to refuse replays.
+	Expires time.Time
+}
+
+// Attribute returns the first value of the named attribute.
+func (a *Assertion) Attribute(name string) string {
+	if v := a.Attributes[name]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// ParseResponse validates the base64 SAMLResponse form value posted to the
+// ACS in answer to requestID. It checks the signature (of the response or
+// of its assertion), issuer, status, destination, audience, recipient and
+// validity window. Replay protection is the caller's: see Expires. Errors
+// wrap ErrInvalid.
+func (sp *SP) ParseResponse(samlResponse, requestID string, now time.Time) (*Assertion, error) {
+	a, err := sp.parseResponse(samlResponse, requestID, now)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return a, nil
+}
+
+func (sp *SP) parseResponse(samlResponse, requestID string, now time.Time) (*Assertion, error) {
+	data, err := base64.StdEncoding.DecodeString(compact(samlResponse))
+	if err != nil {
+		return nil, errors.New("SAMLResponse is not base64")
+	}
+	resp, err := parseXML(data)
+	if err != nil {
+		return nil, fmt.Errorf("malformed XML: %v", err)
+	}
+	if !resp.is(nsProtocol, "Response") {
+		return nil, errors.New("not a Response")
+	}
+	// Signature references are by ID: a duplicated ID could point the
+	// verifier at one element and the reader at another.
+	ids := map[string]bool{}
+	var dup bool
+	resp.walk(func(n *node) {
+		if id := n.attr("ID"); id != "" {
+			dup = dup || ids[id]
+			ids[id] = true
+		}
+	})
+	if dup {
+		return nil, errors.New("duplicate ID")
+	}
+	if len(resp.all(nsAssertion, "EncryptedAssertion")) > 0 {
+		return nil, errors.New("encrypted assertions are not supported")
+	}
+	assertions := resp.all(nsAssertion, "Assertion")
+	if len(assertions) != 1 {
+		return nil, fmt.Errorf("%d assertions, want 1", len(assertions))
+	}
+	as := assertions[0]
+
+	respErr, asErr := verify(resp, sp.IdPCert), verify(as, sp.IdPCert)
+	switch {
+	case respErr != nil && respErr != errNotSigned:
+		return nil, fmt.Errorf("response signature: %v", respErr)
+	case asErr != nil && asErr != errNotSigned:
+		return nil, fmt.Errorf("assertion signature: %v", asErr)
+	case respErr != nil && asErr != nil:
+		return nil, errors.New("neither the response nor the assertion is signed")
+	}
+
+	if resp.attr("Version") != "2.0" || as.attr("Version") != "2.0" {
+		return nil, errors.New("not SAML 2.0")
+	}
+	if d := resp.attr("Destination"); d != "" && d != sp.ACSURL {
+		return nil, fmt.Errorf("destination %q is not the ACS", d)
+	}
+	if got := resp.attr("InResponseTo"); got != requestID {
+		return nil, fmt.Errorf("InResponseTo %q does not match the request", got)
+	}
+	if iss := resp.child(nsAssertion, "Issuer"); iss != nil && iss.text() != sp.IdPEntityID {
+		return nil, fmt.Errorf("response issuer %q is not the IdP", iss.text())
+	}
+	status := resp.child(nsProtocol, "Status")
+	if status == nil {
+		return nil, errors.New("no Status")
+	}
+	if code := status.child(nsProtocol, "StatusCode").attrOrEmpty("Value"); code != statusSuccess {
+		return nil, fmt.Errorf("status %q", code)
+	}
+	if iss := as.child(nsAssertion, "Issuer").text(); iss != sp.IdPEntityID {
+		return nil, fmt.Errorf("assertion issuer %q is not the IdP", iss)
+	}
+
+	a := &Assertion{ID: as.attr("ID"), Attributes: map[string][]string{}}
+	if a.ID == "" {
+		return nil, errors.New("assertion has no ID")
+	}
+
+	cond := as.child(nsAssertion, "Conditions")
+	if cond == nil {
+		return nil, errors.New("no Conditions")
+	}
+	if err := sp.window(cond, now, &a.Expires); err != nil {
+		return nil, fmt.Errorf("conditions: %v", err)
+	}
+	for _, ar := range cond.all(nsAssertion, "AudienceRestriction") {
+		ok := false
+		for _, aud := range ar.all(nsAssertion, "Audience") {
+			ok = ok || aud.text() == sp.EntityID
+		}
+		if !ok {
+			return nil, errors.New("audience does not include this SP")
+		}
+	}
+
+	subject := as.child(nsAssertion, "Subject")
+	if subject == nil {
+		return nil, errors.New("no Subject")
+	}
+	nameID := subject.child(nsAssertion, "NameID")
+	a.NameID, a.NameIDFormat = nameID.text(), nameID.attrOrEmpty("Format")
+	if a.NameID == "" {
+		return nil, errors.New("no NameID")
+	}
+	var confirmErr error = errors.New("no bearer SubjectConfirmation")
+	for _, sc := range subject.all(nsAssertion, "SubjectConfirmation") {
+		if sc.attr("Method") != methodBearer {
+			continue
+		}
+		if confirmErr = sp.confirm(sc.child(nsAssertion, "SubjectConfirmationData"), requestID, now, &a.Expires); confirmErr == nil {
+			break
+		}
+	}
+	if confirmErr != nil {
+		return nil, fmt.Errorf("subject confirmation: %v", confirmErr)
+	}
+
+	for _, st := range as.all(nsAssertion, "AttributeStatement") {
+		for _, at := range st.all(nsAssertion, "Attribute") {
+			name := at.attr("Name")
+			for _, v := range at.all(nsAssertion, "AttributeValue") {
+				a.Attributes[name] = append(a.Attributes[name], v.text())
+			}
+		}
+	}
+	return a, nil
+}
+
+// confirm checks a bearer SubjectConfirmationData.
+func (sp *SP) confirm(data *node, requestID string, now time.Time, expires *time.Time) error {
+	if data == nil {
+		return errors.New("no SubjectConfirmationData")
+	}
+	if r := data.attr("Recipient"); r != sp.ACSURL {
+		return fmt.Errorf("recipient %q is not the ACS", r)
+	}
+	if irt := data.attr("InResponseTo"); irt != "" && irt != requestID {
+		return fmt.Errorf("InResponseTo %q does not match the request", irt)
+	}
+	if data.attr("NotOnOrAfter") == "" {
+		return errors.New("no NotOnOrAfter")
+	}
+	if data.attr("NotBefore") != "" {
+		return errors.New("bearer confirmation must not carry NotBefore")
+	}
+	return sp.window(data, now, expires)
+}
+
+// window checks the NotBefore/NotOnOrAfter attributes of n against now,
+// give or take ClockSkew, and lowers *expires to NotOnOrAfter.
+func (sp *SP) window(n *node, now time.Time, expires *time.Time) error {
+	if s := n.attr("NotBefore"); s != "" {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return fmt.Errorf("NotBefore: %v", err)
+		}
+		if now.Add(sp.ClockSkew).Before(t) {
+			return errors.New("not yet valid")
+		}
+	}
+	if s := n.attr("NotOnOrAfter"); s != "" {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return fmt.Errorf("NotOnOrAfter: %v", err)
+		}
+		if !now.Add(-sp.ClockSkew).Before(t) {
+			return errors.New("expired")
+		}
+		if t = t.Add(sp.ClockSkew); expires.IsZero() || t.Before(*expires) {
+			*expires = t
+		}
+	}
+	return nil
+}
+
+// IsEmail reports whether the NameID is declared to be an email address.
+func (a *Assertion) IsEmail() bool {
+	return a.NameIDFormat == NameIDEmail || (a.NameIDFormat == "" && strings.Contains(a.NameID, "@"))
+}
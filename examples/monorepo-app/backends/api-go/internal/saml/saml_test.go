@@ -0,0 +1,82 @@
+package saml
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseResponseConditions(t *testing.T) {
+	signed := func(r idpResponse) idpResponse { r.SignAssertion, r.SignResponse = true, true; return r }
+	tests := []struct {
+		name string
+		r    idpResponse
+		now  time.Time
+		why  string
+	}{
+		{"expired", idpResponse{}, time.Date(2026, 10, 16, 12, 6, 0, 0, time.UTC), "conditions: expired"},
+		{"expired past the skew", idpResponse{}, time.Date(2026, 10, 16, 12, 10, 0, 0, time.UTC), "conditions: expired"},
+		{"confirmation expired", idpResponse{NotOnOrAfter: "2026-10-16T11:59:30.000Z"}, testNow, "expired"},
+		{"not yet valid", idpResponse{}, time.Date(2026, 10, 16, 11, 57, 0, 0, time.UTC), "conditions: not yet valid"},
+		{"malformed NotOnOrAfter", idpResponse{NotOnOrAfter: "tomorrow"}, testNow, "NotOnOrAfter"},
+		{"wrong audience", idpResponse{Audience: "https://other-sp.example.com"}, testNow, "audience does not include this SP"},
+		{"audience prefix", idpResponse{Audience: testSP + ".evil.com"}, testNow, "audience does not include this SP"},
+		{"wrong recipient", idpResponse{Recipient: "https://other-sp.example.com/acs"}, testNow, "subject confirmation: recipient"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := signed(tt.r).sign(t)
+			a, err := parse(doc, tt.now)
+			if err == nil || !strings.Contains(err.Error(), tt.why) {
+				t.Errorf("got %+v, %v; want %q", a, err, tt.why)
+			}
+		})
+	}
+}
+
+// TestParseResponseClockSkew accepts a response within ClockSkew of its
+// window on either side.
+func TestParseResponseClockSkew(t *testing.T) {
+	doc := idpResponse{SignAssertion: true}.sign(t)
+	for _, now := range []time.Time{
+		time.Date(2026, 10, 16, 11, 58, 30, 0, time.UTC), // 30s before NotBefore
+		time.Date(2026, 10, 16, 12, 5, 30, 0, time.UTC),  // 30s after NotOnOrAfter
+	} {
+		if _, err := parse(doc, now); err != nil {
+			t.Errorf("at %v: %v", now, err)
+		}
+	}
+}
+
+func TestParseResponseEnvelope(t *testing.T) {
+	sp := testServiceProvider()
+	doc := idpResponse{SignAssertion: true}.sign(t)
+	post := base64.StdEncoding.EncodeToString([]byte(doc))
+
+	if _, err := sp.ParseResponse(post, "another-request", testNow); err == nil || !strings.Contains(err.Error(), "InResponseTo") {
+		t.Errorf("another request: %v", err)
+	}
+	// Nor is it taken as an unsolicited response.
+	if _, err := sp.ParseResponse(post, "", testNow); err == nil {
+		t.Error("accepted an answer to another request as unsolicited")
+	}
+	other := *sp
+	other.ACSURL = "https://sp.example.com/other/acs"
+	if _, err := other.ParseResponse(post, testRequest, testNow); err == nil || !strings.Contains(err.Error(), "destination") {
+		t.Errorf("another ACS: %v", err)
+	}
+	other = *sp
+	other.IdPEntityID = "https://other-idp.example.com"
+	if _, err := other.ParseResponse(post, testRequest, testNow); err == nil || !strings.Contains(err.Error(), "issuer") {
+		t.Errorf("another IdP: %v", err)
+	}
+	if _, err := sp.ParseResponse("not base64!", testRequest, testNow); err == nil {
+		t.Error("accepted a response that is not base64")
+	}
+	// Base64 wrapped over lines, as some IdPs post it.
+	if _, err := sp.ParseResponse(wrap([]byte(doc)), testRequest, testNow); err != nil {
+		t.Errorf("wrapped base64: %v", err)
+	}
+	wantRejected(t, replace(t, doc, "status:Success", "status:Responder"), `status "urn:oasis:names:tc:SAML:2.0:status:Responder"`)
+}
@@ -0,0 +1,242 @@
+package saml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+const nsXML = "http://www.w3.org/XML/1998/namespace"
+
+// node is an element of a parsed document. Names keep their prefixes as
+// written (encoding/xml's RawToken), because exclusive canonicalization
+// has to reproduce them; lookup resolves them.
+type node struct {
+	prefix, local string
+	attrs         []xml.Attr // Name.Space is the prefix; xmlns declarations included
+	children      []any      // *node or string
+	parent        *node
+}
+
+// parseXML reads a document into a tree. DTDs are refused outright: SAML
+// has no use for them and they are the usual vehicle for entity attacks.
+func parseXML(data []byte) (*node, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	var root, cur *node
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &node{prefix: t.Name.Space, local: t.Name.Local, attrs: slices.Clone(t.Attr), parent: cur}
+			if cur == nil {
+				if root != nil {
+					return nil, errors.New("more than one root element")
+				}
+				root = n
+			} else {
+				cur.children = append(cur.children, n)
+			}
+			cur = n
+		case xml.EndElement:
+			if cur == nil || t.Name.Space != cur.prefix || t.Name.Local != cur.local {
+				return nil, errors.New("mismatched end element")
+			}
+			cur = cur.parent
+		case xml.CharData:
+			if cur != nil {
+				cur.children = append(cur.children, string(t))
+			}
+		case xml.Directive:
+			return nil, errors.New("DTDs are not allowed")
+		}
+	}
+	if root == nil || cur != nil {
+		return nil, errors.New("incomplete document")
+	}
+	return root, nil
+}
+
+// lookup resolves prefix ("" for the default namespace) at n.
+func (n *node) lookup(prefix string) string {
+	if prefix == "xml" {
+		return nsXML
+	}
+	for e := n; e != nil; e = e.parent {
+		for _, a := range e.attrs {
+			if (prefix == "" && a.Name.Space == "" && a.Name.Local == "xmlns") ||
+				(prefix != "" && a.Name.Space == "xmlns" && a.Name.Local == prefix) {
+				return a.Value
+			}
+		}
+	}
+	return ""
+}
+
+func (n *node) is(ns, local string) bool { return n.local == local && n.lookup(n.prefix) == ns }
+
+// attr returns the unprefixed attribute name.
+func (n *node) attr(name string) string {
+	for _, a := range n.attrs {
+		if a.Name.Space == "" && a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func (n *node) child(ns, local string) *node {
+	for _, c := range n.children {
+		if c, ok := c.(*node); ok && c.is(ns, local) {
+			return c
+		}
+	}
+	return nil
+}
+
+func (n *node) all(ns, local string) []*node {
+	var out []*node
+	for _, c := range n.children {
+		if c, ok := c.(*node); ok && c.is(ns, local) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// text is n's character content, trimmed.
+func (n *node) text() string {
+	if n == nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, c := range n.children {
+		if s, ok := c.(string); ok {
+			b.WriteString(s)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// walk calls fn for n and every element below it.
+func (n *node) walk(fn func(*node)) {
+	fn(n)
+	for _, c := range n.children {
+		if c, ok := c.(*node); ok {
+			c.walk(fn)
+		}
+	}
+}
+
+// canonicalize writes the subtree at n in Exclusive XML Canonicalization
+// (without comments), leaving out the element skip (the enveloped
+// signature) and declaring the inclusive prefixes wherever they are in
+// scope, as an InclusiveNamespaces PrefixList asks.
+func canonicalize(n, skip *node, inclusive []string) []byte {
+	var b bytes.Buffer
+	c14nElement(&b, n, skip, inclusive, map[string]string{})
+	return b.Bytes()
+}
+
+func c14nElement(b *bytes.Buffer, n, skip *node, inclusive []string, rendered map[string]string) {
+	// Namespaces visibly used by the element and its attributes, plus the
+	// inclusive ones, unless an output ancestor already declared them.
+	used := []string{n.prefix}
+	for _, a := range n.attrs {
+		if a.Name.Space != "" && a.Name.Space != "xmlns" && a.Name.Space != "xml" {
+			used = append(used, a.Name.Space)
+		}
+	}
+	for _, p := range inclusive {
+		if p == "#default" {
+			p = ""
+		}
+		if p == "" || n.lookup(p) != "" {
+			used = append(used, p)
+		}
+	}
+	slices.Sort(used)
+	used = slices.Compact(used)
+
+	scope := rendered
+	var decls []string
+	for _, p := range used {
+		uri := n.lookup(p)
+		prev, seen := rendered[p]
+		if (seen && prev == uri) || (!seen && p == "" && uri == "") {
+			continue
+		}
+		if len(decls) == 0 {
+			scope = make(map[string]string, len(rendered)+1)
+			for k, v := range rendered {
+				scope[k] = v
+			}
+		}
+		scope[p] = uri
+		if p == "" {
+			decls = append(decls, fmt.Sprintf(` xmlns="%s"`, escapeAttr(uri)))
+		} else {
+			decls = append(decls, fmt.Sprintf(` xmlns:%s="%s"`, p, escapeAttr(uri)))
+		}
+	}
+
+	type attr struct{ ns, local, qname, value string }
+	var attrs []attr
+	for _, a := range n.attrs {
+		if a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns") {
+			continue
+		}
+		at := attr{local: a.Name.Local, qname: a.Name.Local, value: a.Value}
+		if a.Name.Space != "" {
+			at.ns, at.qname = n.lookup(a.Name.Space), a.Name.Space+":"+a.Name.Local
+		}
+		attrs = append(attrs, at)
+	}
+	slices.SortFunc(attrs, func(x, y attr) int {
+		if c := strings.Compare(x.ns, y.ns); c != 0 {
+			return c
+		}
+		return strings.Compare(x.local, y.local)
+	})
+
+	name := n.local
+	if n.prefix != "" {
+		name = n.prefix + ":" + n.local
+	}
+	b.WriteString("<" + name)
+	for _, d := range decls {
+		b.WriteString(d)
+	}
+	for _, a := range attrs {
+		fmt.Fprintf(b, ` %s="%s"`, a.qname, escapeAttr(a.value))
+	}
+	b.WriteString(">")
+	for _, c := range n.children {
+		switch c := c.(type) {
+		case *node:
+			if c != skip {
+				c14nElement(b, c, skip, inclusive, scope)
+			}
+		case string:
+			b.WriteString(escapeText(c))
+		}
+	}
+	b.WriteString("</" + name + ">")
+}
+
+var (
+	textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
+func escapeText(s string) string { return textEscaper.Replace(s) }
+func escapeAttr(s string) string { return attrEscaper.Replace(s) }
@@ -0,0 +1,131 @@
+package saml
+
+import "testing"
+
+// The expected outputs follow the Exclusive XML Canonicalization
+// recommendation; those without an InclusiveNamespaces list agree with
+// Python's xml.etree.ElementTree.canonicalize.
+func TestCanonicalize(t *testing.T) {
+	tests := []struct {
+		name      string
+		doc       string
+		path      []int // child elements to descend to before canonicalizing
+		inclusive []string
+		want      string
+	}{
+		{
+			name: "namespaces and attributes sorted, unused namespaces dropped",
+			doc:  `<a:root xmlns:a="urn:a" xmlns:b="urn:b" xmlns:unused="urn:u" z="1" a:y="2" b:x="3"><b:child/><!-- note --><c xmlns="urn:d" >t &amp; &lt; &gt; &#xD;</c></a:root>`,
+			want: `<a:root xmlns:a="urn:a" xmlns:b="urn:b" z="1" a:y="2" b:x="3"><b:child></b:child><c xmlns="urn:d">t &amp; &lt; &gt; &#xD;</c></a:root>`,
+		},
+		{
+			name: "declarations pushed down to where they are used",
+			doc:  `<root xmlns:p="urn:p"><p:a><p:b/></p:a><p:c/></root>`,
+			want: `<root><p:a xmlns:p="urn:p"><p:b></p:b></p:a><p:c xmlns:p="urn:p"></p:c></root>`,
+		},
+		{
+			name: "subtree declares what it inherits",
+			doc:  `<r xmlns="urn:r" xmlns:p="urn:p"><p:e a="1"/></r>`,
+			path: []int{0},
+			want: `<p:e xmlns:p="urn:p" a="1"></p:e>`,
+		},
+		{
+			name: "default namespace undeclared",
+			doc:  `<r xmlns="urn:r"><e xmlns=""/></r>`,
+			want: `<r xmlns="urn:r"><e xmlns=""></e></r>`,
+		},
+		{
+			name:      "inclusive prefix in scope",
+			doc:       `<r xmlns:xs="urn:xs" xmlns:p="urn:p"><p:e v="xs:string"><p:f/></p:e></r>`,
+			path:      []int{0},
+			inclusive: []string{"xs"},
+			want:      `<p:e xmlns:p="urn:p" xmlns:xs="urn:xs" v="xs:string"><p:f></p:f></p:e>`,
+		},
+		{
+			name:      "inclusive prefix out of scope",
+			doc:       `<p:e xmlns:p="urn:p"/>`,
+			inclusive: []string{"xs"},
+			want:      `<p:e xmlns:p="urn:p"></p:e>`,
+		},
+		{
+			name: "without the inclusive prefix",
+			doc:  `<r xmlns:xs="urn:xs" xmlns:p="urn:p"><p:e v="xs:string"/></r>`,
+			path: []int{0},
+			want: `<p:e xmlns:p="urn:p" v="xs:string"></p:e>`,
+		},
+		{
+			name: "attribute escaping",
+			doc:  `<e a="&quot;&#9;&#10;&#13;&lt;&gt;&amp;'"/>`,
+			want: `<e a="&quot;&#x9;&#xA;&#xD;&lt;>&amp;'"></e>`,
+		},
+		{
+			name: "single quotes and character references",
+			doc:  `<e a='"x"'>&#65;&#x42;</e>`,
+			want: `<e a="&quot;x&quot;">AB</e>`,
+		},
+		{
+			name: "CDATA becomes text",
+			doc:  `<e><![CDATA[a<b]]></e>`,
+			want: `<e>a&lt;b</e>`,
+		},
+		{
+			name: "comments dropped",
+			doc:  `<e>a<!--c-->b</e>`,
+			want: `<e>ab</e>`,
+		},
+		{
+			name: "whitespace kept",
+			doc:  "<e>\n  <f> x </f>\n</e>",
+			want: "<e>\n  <f> x </f>\n</e>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := parseXML([]byte(tt.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, i := range tt.path {
+				n = elements(n)[i]
+			}
+			if got := string(canonicalize(n, nil, tt.inclusive)); got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanonicalizeSkips(t *testing.T) {
+	n, err := parseXML([]byte(`<e ID="1"> <s xmlns="urn:s"><x/></s> <f/></e>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(canonicalize(n, elements(n)[0], nil)), `<e ID="1">  <f></f></e>`; got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestParseXMLRefusesDTDs(t *testing.T) {
+	for _, doc := range []string{
+		`<!DOCTYPE e [<!ENTITY x "y">]><e>&x;</e>`,
+		`<!DOCTYPE e SYSTEM "file:///etc/passwd"><e/>`,
+		`<e/><f/>`,
+		`<e>`,
+		``,
+	} {
+		if _, err := parseXML([]byte(doc)); err == nil {
+			t.Errorf("%q: parsed", doc)
+		}
+	}
+}
+
+// elements returns the child elements of n.
+func elements(n *node) []*node {
+	var out []*node
+	for _, c := range n.children {
+		if c, ok := c.(*node); ok {
+			out = append(out, c)
+		}
+	}
+	return out
+}
@@ -0,0 +1,1128 @@
+package store
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/auth"
+)
+
+// Memory is the in-memory Store. Data lives as long as the process.
+type Memory struct {
+	mu            sync.RWMutex
+	users         map[string]*api.User
+	emailIndex    map[string]string
+	phoneIndex    map[string]string
+	refreshTokens map[string]Session
+	csrfTokens    map[string]csrfToken
+	revokedSess   map[string]time.Time // session ID -> forget after
+	idempotency   map[string]*IdempotencyRecord
+	nextPurge     time.Time
+	events        []SecurityEvent // oldest first
+	eventSeq      uint64
+	webhooks      map[string]*WebhookSubscription
+	deliveries    []WebhookDelivery // oldest first
+	outbox        map[string]*WebhookMessage
+	samlRequests  map[string]samlRequest
+	samlSeen      map[string]time.Time // assertion ID -> forget after
+	nextSAMLPurge time.Time
+	nonces        map[string]time.Time // caller and nonce -> forget after
+	nextNonceScan time.Time
+	devices       map[string]map[string]time.Time // user ID -> fingerprint -> last seen
+	exports       map[string]*DataExport
+	otps          map[string]*OTPCode
+	featureFlags  map[string]FeatureFlag
+	featureUsers  map[string]map[string]bool // user ID -> flag -> enabled
+	orgs          map[string]*api.Organization
+	slugIndex     map[string]string
+	memberships   map[string]map[string]Membership // org ID -> user ID -> membership
+	orgRevoked    map[string]time.Time             // org ID + "/" + user ID -> tokens valid after
+}
+
+// maxDeadWebhookMessages caps the dead webhook messages kept; the oldest
+// is dropped first.
+const maxDeadWebhookMessages = 1000
+
+// maxKnownDevices caps the devices remembered per user; the least recently
+// seen is forgotten first.
+const maxKnownDevices = 50
+
+// NewMemory returns an empty Memory store seeded with the demo admin,
+// admin@example.com / admin123.
+func NewMemory() *Memory {
+	s := &Memory{
+		users:         make(map[string]*api.User),
+		emailIndex:    make(map[string]string),
+		phoneIndex:    make(map[string]string),
+		refreshTokens: make(map[string]Session),
+		csrfTokens:    make(map[string]csrfToken),
+		revokedSess:   make(map[string]time.Time),
+		idempotency:   make(map[string]*IdempotencyRecord),
+		webhooks:      make(map[string]*WebhookSubscription),
+		outbox:        make(map[string]*WebhookMessage),
+		samlRequests:  make(map[string]samlRequest),
+		samlSeen:      make(map[string]time.Time),
+		nonces:        make(map[string]time.Time),
+		devices:       make(map[string]map[string]time.Time),
+		exports:       make(map[string]*DataExport),
+		otps:          make(map[string]*OTPCode),
+		featureFlags:  make(map[string]FeatureFlag),
+		featureUsers:  make(map[string]map[string]bool),
+		orgs:          make(map[string]*api.Organization),
+		slugIndex:     make(map[string]string),
+		memberships:   make(map[string]map[string]Membership),
+		orgRevoked:    make(map[string]time.Time),
+	}
+
+	hashedPw, _ := auth.HashPassword("admin123")
+	adminID := auth.GenerateID()
+	now := auth.Now()
+	s.users[adminID] = &api.User{
+		ID: adminID, Email: "admin@example.com", Name: "Admin",
+		Role: "admin", Password: hashedPw,
+		CreatedAt: now, UpdatedAt: now,
+	}
+	s.emailIndex["admin@example.com"] = adminID
+
+	return s
+}
+
+// Ping reports whether the store can serve requests. The in-memory store is
+// always reachable; a database-backed store would round-trip here.
+func (s *Memory) Ping(ctx context.Context) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return ctx.Err()
+}
+
+func (s *Memory) Stats() Stats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	st := Stats{Users: len(s.users), RefreshTokens: len(s.refreshTokens), CSRFTokens: len(s.csrfTokens), Events: len(s.events)}
+	for _, m := range s.outbox {
+		if m.Status == WebhookDead {
+			st.WebhookDead++
+		} else {
+			st.WebhookQueued++
+		}
+	}
+	return st
+}
+
+func (s *Memory) CreateUser(email, name, password, role string) (*api.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.emailIndex[email]; exists {
+		return nil, ErrEmailTaken
+	}
+	hashedPw, err := auth.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	id := auth.GenerateID()
+	now := auth.Now()
+	user := &api.User{
+		ID: id, Email: email, Name: name, Role: role,
+		Password: hashedPw, CreatedAt: now, UpdatedAt: now,
+	}
+	s.users[id] = user
+	s.emailIndex[email] = id
+	return user, nil
+}
+
+func (s *Memory) GetUserByEmail(email string) (*api.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	id, ok := s.emailIndex[email]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return s.users[id], nil
+}
+
+func (s *Memory) GetUserByPhone(phone string) (*api.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	id, ok := s.phoneIndex[phone]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return s.users[id], nil
+}
+
+func (s *Memory) GetUserByID(id string) (*api.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	user, ok := s.users[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (s *Memory) ListUsers() []*api.User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	users := make([]*api.User, 0, len(s.users))
+	for _, u := range s.users {
+		users = append(users, u)
+	}
+	// Stable order keeps list responses (and their ETags) deterministic.
+	sort.Slice(users, func(i, j int) bool {
+		if !users[i].CreatedAt.Equal(users[j].CreatedAt) {
+			return users[i].CreatedAt.Before(users[j].CreatedAt)
+		}
+		return users[i].ID < users[j].ID
+	})
+	return users
+}
+
+// ForEachUser walks a snapshot of the user pointers, taken under the lock
+// and sorted like ListUsers, calling fn without holding the lock: users
+// are immutable once stored, so only the slice is extra memory.
+func (s *Memory) ForEachUser(ctx context.Context, f UserFilter, fn func(*api.User) error) error {
+	for _, u := range s.ListUsers() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if f.PendingDeletion && !u.PendingDeletion() {
+			continue
+		}
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateUser applies fn to a copy of the user and stores the copy, so a
+// *api.User handed out earlier never changes under its reader.
+func (s *Memory) UpdateUser(id string, fn func(*api.User)) (*api.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	updated := *user
+	fn(&updated)
+	updated.UpdatedAt = auth.Now()
+	s.users[id] = &updated
+	return &updated, nil
+}
+
+// SetUserPhone sets the user's phone number, or clears it when phone is
+// empty. A number another user has is ErrPhoneTaken.
+func (s *Memory) SetUserPhone(id, phone string) (*api.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	if owner, taken := s.phoneIndex[phone]; taken && owner != id {
+		return nil, ErrPhoneTaken
+	}
+	delete(s.phoneIndex, user.Phone)
+	if phone != "" {
+		s.phoneIndex[phone] = id
+	}
+	updated := *user
+	updated.Phone, updated.UpdatedAt = phone, auth.Now()
+	s.users[id] = &updated
+	return &updated, nil
+}
+
+func (s *Memory) PurgeUsers(before time.Time) []*api.User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var purged []*api.User
+	for id, u := range s.users {
+		if !u.PendingDeletion() || u.DeleteAfter.After(before) {
+			continue
+		}
+		delete(s.users, id)
+		delete(s.emailIndex, u.Email)
+		if u.Phone != "" {
+			delete(s.phoneIndex, u.Phone)
+		}
+		delete(s.devices, id)
+		delete(s.featureUsers, id)
+		for _, members := range s.memberships {
+			delete(members, id)
+		}
+		for token, sess := range s.refreshTokens {
+			if sess.UserID == id {
+				delete(s.refreshTokens, token)
+			}
+		}
+		for eid, e := range s.exports {
+			if e.UserID == id {
+				delete(s.exports, eid)
+			}
+		}
+		for oid, c := range s.otps {
+			if c.UserID == id || (u.Phone != "" && c.Phone == u.Phone) {
+				delete(s.otps, oid)
+			}
+		}
+		purged = append(purged, u)
+	}
+	return purged
+}
+
+func (s *Memory) CountUsers(now time.Time) UserCounts {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c := UserCounts{Total: len(s.users), ByRole: make(map[string]int)}
+	for _, u := range s.users {
+		c.ByRole[u.Role]++
+		switch age := now.Sub(u.CreatedAt); {
+		case age < 24*time.Hour:
+			c.Signups24h++
+			fallthrough
+		case age < 7*24*time.Hour:
+			c.Signups7d++
+			fallthrough
+		case age < 30*24*time.Hour:
+			c.Signups30d++
+		}
+		if u.Suspended {
+			c.Suspended++
+		}
+		if u.PendingDeletion() {
+			c.PendingDeletion++
+		}
+	}
+	return c
+}
+
+func (s *Memory) StoreRefreshToken(token string, sess Session) {
+	s.mu.Lock()
+	s.refreshTokens[token] = sess
+	s.mu.Unlock()
+}
+func (s *Memory) ValidateRefreshToken(token string) (Session, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sess, ok := s.refreshTokens[token]
+	if !ok || !auth.Now().Before(sess.ExpiresAt) {
+		return Session{}, false
+	}
+	return sess, true
+}
+func (s *Memory) RevokeRefreshToken(token string) {
+	s.mu.Lock()
+	delete(s.refreshTokens, token)
+	s.mu.Unlock()
+}
+
+// RevokeUserRefreshTokens revokes every refresh token of userID and returns
+// how many there were.
+func (s *Memory) RevokeUserRefreshTokens(userID string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for token, sess := range s.refreshTokens {
+		if sess.UserID == userID {
+			delete(s.refreshTokens, token)
+			n++
+		}
+	}
+	return n
+}
+
+// UserSessions lists userID's unexpired sessions, oldest first.
+func (s *Memory) UserSessions(userID string) []Session {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := auth.Now()
+	out := []Session{}
+	for _, sess := range s.refreshTokens {
+		if sess.UserID == userID && now.Before(sess.ExpiresAt) {
+			out = append(out, sess)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].StartedAt.Before(out[j].StartedAt) })
+	return out
+}
+
+func (s *Memory) CountSessions() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := auth.Now()
+	n := 0
+	for _, sess := range s.refreshTokens {
+		if now.Before(sess.ExpiresAt) {
+			n++
+		}
+	}
+	return n
+}
+
+type csrfToken struct {
+	userID    string
+	sessionID string
+	expiresAt time.Time
+}
+
+func (s *Memory) StoreCSRFToken(token, userID, sessionID string, ttl time.Duration) {
+	s.mu.Lock()
+	s.csrfTokens[token] = csrfToken{userID: userID, sessionID: sessionID, expiresAt: auth.Now().Add(ttl)}
+	s.mu.Unlock()
+}
+func (s *Memory) ValidateCSRFToken(token, userID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.csrfTokens[token]
+	return ok && t.userID == userID && auth.Now().Before(t.expiresAt)
+}
+
+// RevokeUserCSRFTokens revokes every CSRF token issued to userID and
+// returns how many there were.
+func (s *Memory) RevokeUserCSRFTokens(userID string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for token, t := range s.csrfTokens {
+		if t.userID == userID {
+			delete(s.csrfTokens, token)
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Memory) RevokeSession(userID, sessionID string, until time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := auth.Now()
+	found := false
+	for token, sess := range s.refreshTokens {
+		if sess.UserID == userID && sess.ID == sessionID {
+			found = found || now.Before(sess.ExpiresAt)
+			delete(s.refreshTokens, token)
+		}
+	}
+	if !found {
+		return false
+	}
+	for token, t := range s.csrfTokens {
+		if t.userID == userID && t.sessionID == sessionID {
+			delete(s.csrfTokens, token)
+		}
+	}
+	for id, forget := range s.revokedSess {
+		if !now.Before(forget) {
+			delete(s.revokedSess, id)
+		}
+	}
+	s.revokedSess[sessionID] = until
+	return true
+}
+
+func (s *Memory) SetSessionOrg(sessionID, orgID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for token, sess := range s.refreshTokens {
+		if sess.ID == sessionID {
+			sess.OrgID = orgID
+			s.refreshTokens[token] = sess
+		}
+	}
+}
+
+func (s *Memory) IsSessionRevoked(sessionID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	forget, ok := s.revokedSess[sessionID]
+	return ok && auth.Now().Before(forget)
+}
+
+// BeginIdempotent claims key for a request whose body hashes to hash. If the
+// key is already known its record is returned unchanged and claimed is false.
+func (s *Memory) BeginIdempotent(key, hash string, ttl time.Duration) (rec IdempotencyRecord, claimed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := auth.Now()
+	if now.After(s.nextPurge) {
+		for k, r := range s.idempotency {
+			if now.After(r.ExpiresAt) {
+				delete(s.idempotency, k)
+			}
+		}
+		s.nextPurge = now.Add(time.Minute)
+	}
+	if existing, ok := s.idempotency[key]; ok && now.Before(existing.ExpiresAt) {
+		return *existing, false
+	}
+	s.idempotency[key] = &IdempotencyRecord{RequestHash: hash, ExpiresAt: now.Add(ttl)}
+	return IdempotencyRecord{}, true
+}
+
+// CompleteIdempotent stores the response for a claimed key.
+func (s *Memory) CompleteIdempotent(key string, status int, contentType string, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rec, ok := s.idempotency[key]; ok {
+		rec.Done, rec.Status, rec.ContentType, rec.Body = true, status, contentType, body
+	}
+}
+
+// ReleaseIdempotent forgets a claimed key so the request may be retried.
+func (s *Memory) ReleaseIdempotent(key string) {
+	s.mu.Lock()
+	delete(s.idempotency, key)
+	s.mu.Unlock()
+}
+
+// AppendSecurityEvent records e, keeping at most retain events.
+func (s *Memory) AppendSecurityEvent(e SecurityEvent, retain int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.eventSeq++
+	e.Seq = s.eventSeq
+	s.events = append(s.events, e)
+	if over := len(s.events) - retain; over > 0 {
+		s.events = append(s.events[:0:0], s.events[over:]...)
+	}
+}
+
+// SecurityEvents returns matching events, newest first.
+func (s *Memory) SecurityEvents(f SecurityEventFilter) []SecurityEvent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := []SecurityEvent{}
+	for i := len(s.events) - 1; i >= 0 && (f.Limit <= 0 || len(out) < f.Limit); i-- {
+		if e := s.events[i]; f.matches(e) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func (s *Memory) CountSecurityEvents(f SecurityEventFilter) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	n := 0
+	for _, e := range s.events {
+		if f.matches(e) {
+			n++
+		}
+	}
+	return n
+}
+
+func (f SecurityEventFilter) matches(e SecurityEvent) bool {
+	switch {
+	case f.Type != "" && e.Type != f.Type,
+		f.User != "" && e.UserID != f.User && !strings.EqualFold(e.Email, f.User),
+		!f.Since.IsZero() && e.Time.Before(f.Since),
+		!f.Until.IsZero() && !e.Time.Before(f.Until),
+		f.Before != 0 && e.Seq >= f.Before:
+		return false
+	}
+	if f.Subject != "" {
+		return e.UserID == f.Subject ||
+			e.Type == "admin_action" && e.Details["user_id"] == f.Subject ||
+			f.SubjectEmail != "" && strings.EqualFold(e.Email, f.SubjectEmail)
+	}
+	return true
+}
+
+type samlRequest struct {
+	id        string
+	expiresAt time.Time
+}
+
+func (s *Memory) StoreSAMLRequest(relayState, requestID string, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.purgeSAML()
+	s.samlRequests[relayState] = samlRequest{id: requestID, expiresAt: auth.Now().Add(ttl)}
+}
+
+// ConsumeSAMLRequest returns the request started with relayState, once.
+func (s *Memory) ConsumeSAMLRequest(relayState string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	req, ok := s.samlRequests[relayState]
+	delete(s.samlRequests, relayState)
+	if !ok || !auth.Now().Before(req.expiresAt) {
+		return "", false
+	}
+	return req.id, true
+}
+
+// MarkSAMLAssertion records assertion id until until, and reports whether
+// it was new.
+func (s *Memory) MarkSAMLAssertion(id string, until time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.purgeSAML()
+	if exp, seen := s.samlSeen[id]; seen && auth.Now().Before(exp) {
+		return false
+	}
+	s.samlSeen[id] = until
+	return true
+}
+
+// MarkNonce records key until until, and reports whether it was new.
+// Expired nonces are dropped at most once a minute.
+func (s *Memory) MarkNonce(key string, until time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := auth.Now()
+	if !now.Before(s.nextNonceScan) {
+		for k, exp := range s.nonces {
+			if !now.Before(exp) {
+				delete(s.nonces, k)
+			}
+		}
+		s.nextNonceScan = now.Add(time.Minute)
+	}
+	if exp, seen := s.nonces[key]; seen && now.Before(exp) {
+		return false
+	}
+	s.nonces[key] = until
+	return true
+}
+
+// purgeSAML drops expired SAML state, at most once a minute. s.mu must be
+// held.
+func (s *Memory) purgeSAML() {
+	now := auth.Now()
+	if now.Before(s.nextSAMLPurge) {
+		return
+	}
+	for k, r := range s.samlRequests {
+		if !now.Before(r.expiresAt) {
+			delete(s.samlRequests, k)
+		}
+	}
+	for id, exp := range s.samlSeen {
+		if !now.Before(exp) {
+			delete(s.samlSeen, id)
+		}
+	}
+	s.nextSAMLPurge = now.Add(time.Minute)
+}
+
+func (s *Memory) RememberDevice(userID, fingerprint string, at time.Time) (bool, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	known := s.devices[userID]
+	if known == nil {
+		known = make(map[string]time.Time)
+		s.devices[userID] = known
+	}
+	_, seen := known[fingerprint]
+	n := len(known)
+	known[fingerprint] = at
+	if len(known) > maxKnownDevices {
+		oldest, oldestAt := "", at
+		for fp, t := range known {
+			if t.Before(oldestAt) {
+				oldest, oldestAt = fp, t
+			}
+		}
+		delete(known, oldest)
+	}
+	return !seen, n
+}
+
+func (s *Memory) CreateOTP(c OTPCode, maxActive int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now, active := auth.Now(), 0
+	for id, o := range s.otps {
+		switch {
+		case !now.Before(o.ExpiresAt):
+			delete(s.otps, id)
+		case o.Phone == c.Phone && o.Purpose == c.Purpose:
+			active++
+		}
+	}
+	if active >= maxActive {
+		return false
+	}
+	s.otps[c.ID] = &c
+	return true
+}
+
+// ActiveOTPs returns the unexpired codes for phone and purpose.
+func (s *Memory) ActiveOTPs(phone, purpose string) []OTPCode {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := auth.Now()
+	var out []OTPCode
+	for _, o := range s.otps {
+		if o.Phone == phone && o.Purpose == purpose && now.Before(o.ExpiresAt) {
+			out = append(out, *o)
+		}
+	}
+	return out
+}
+
+// UpdateOTP applies fn to the code and reports whether it still exists.
+func (s *Memory) UpdateOTP(id string, fn func(*OTPCode)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.otps[id]
+	if !ok || !auth.Now().Before(o.ExpiresAt) {
+		return false
+	}
+	fn(o)
+	return true
+}
+
+func (s *Memory) DeleteOTP(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.otps[id]
+	delete(s.otps, id)
+	return ok && auth.Now().Before(o.ExpiresAt)
+}
+
+func (s *Memory) CreateDataExport(e DataExport) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.purgeDataExports()
+	s.exports[e.ID] = &e
+}
+
+func (s *Memory) GetDataExport(id string) (DataExport, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, ok := s.exports[id]
+	if !ok || !auth.Now().Before(e.ExpiresAt) {
+		return DataExport{}, false
+	}
+	return *e, true
+}
+
+// UpdateDataExport applies fn to the export and reports whether it still
+// exists.
+func (s *Memory) UpdateDataExport(id string, fn func(*DataExport)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.exports[id]
+	if !ok || !auth.Now().Before(e.ExpiresAt) {
+		return false
+	}
+	fn(e)
+	return true
+}
+
+// purgeDataExports drops expired exports and their archives. s.mu must be
+// held.
+func (s *Memory) purgeDataExports() {
+	now := auth.Now()
+	for id, e := range s.exports {
+		if !now.Before(e.ExpiresAt) {
+			delete(s.exports, id)
+		}
+	}
+}
+
+// CreateWebhook stores a subscription and returns it with its ID set.
+func (s *Memory) CreateWebhook(sub WebhookSubscription) WebhookSubscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub.ID, sub.CreatedAt = auth.GenerateID(), auth.Now()
+	s.webhooks[sub.ID] = &sub
+	return sub
+}
+
+// ListWebhooks returns every subscription, oldest first, without secrets.
+func (s *Memory) ListWebhooks() []WebhookSubscription {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	subs := make([]WebhookSubscription, 0, len(s.webhooks))
+	for _, sub := range s.webhooks {
+		c := *sub
+		c.Secret = ""
+		subs = append(subs, c)
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].CreatedAt.Before(subs[j].CreatedAt) })
+	return subs
+}
+
+func (s *Memory) DeleteWebhook(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.webhooks[id]
+	delete(s.webhooks, id)
+	for mid, m := range s.outbox {
+		if m.SubscriptionID == id {
+			delete(s.outbox, mid)
+		}
+	}
+	return ok
+}
+
+// WebhooksFor returns the subscriptions (with secrets) for eventType.
+func (s *Memory) WebhooksFor(eventType string) []WebhookSubscription {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var subs []WebhookSubscription
+	for _, sub := range s.webhooks {
+		if slices.Contains(sub.Events, eventType) {
+			subs = append(subs, *sub)
+		}
+	}
+	return subs
+}
+
+// AppendWebhookDelivery records d, keeping at most retain attempts.
+func (s *Memory) AppendWebhookDelivery(d WebhookDelivery, retain int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deliveries = append(s.deliveries, d)
+	if over := len(s.deliveries) - retain; over > 0 {
+		s.deliveries = append(s.deliveries[:0:0], s.deliveries[over:]...)
+	}
+}
+
+// WebhookDeliveries returns recent attempts, newest first, optionally for
+// one subscription.
+func (s *Memory) WebhookDeliveries(subscriptionID string, limit int) []WebhookDelivery {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := []WebhookDelivery{}
+	for i := len(s.deliveries) - 1; i >= 0 && len(out) < limit; i-- {
+		if subscriptionID == "" || s.deliveries[i].SubscriptionID == subscriptionID {
+			out = append(out, s.deliveries[i])
+		}
+	}
+	return out
+}
+
+func (s *Memory) EnqueueWebhookMessages(msgs []WebhookMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range msgs {
+		s.outbox[m.ID] = &m
+	}
+	s.trimDeadWebhookMessages()
+}
+
+// ClaimWebhookMessages returns the oldest due pending messages.
+func (s *Memory) ClaimWebhookMessages(now time.Time, limit int, lease time.Duration) []WebhookMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due []*WebhookMessage
+	for _, m := range s.outbox {
+		if m.Status == WebhookPending && !m.NextAttempt.After(now) {
+			due = append(due, m)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].NextAttempt.Before(due[j].NextAttempt) })
+	out := make([]WebhookMessage, 0, min(len(due), limit))
+	for _, m := range due[:min(len(due), limit)] {
+		m.NextAttempt = now.Add(lease)
+		out = append(out, *m)
+	}
+	return out
+}
+
+// UpdateWebhookMessage applies fn to the message and returns it, or false
+// when it no longer exists.
+func (s *Memory) UpdateWebhookMessage(id string, fn func(*WebhookMessage)) (WebhookMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.outbox[id]
+	if !ok {
+		return WebhookMessage{}, false
+	}
+	fn(m)
+	s.trimDeadWebhookMessages()
+	return *m, true
+}
+
+func (s *Memory) DeleteWebhookMessage(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.outbox, id)
+}
+
+// DeadWebhookMessages returns dead messages, newest first, optionally for
+// one subscription.
+func (s *Memory) DeadWebhookMessages(subscriptionID string, limit int) []WebhookMessage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := []WebhookMessage{}
+	for _, m := range s.outbox {
+		if m.Status == WebhookDead && (subscriptionID == "" || m.SubscriptionID == subscriptionID) {
+			out = append(out, *m)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out[:min(len(out), limit)]
+}
+
+// trimDeadWebhookMessages drops the oldest dead messages past
+// maxDeadWebhookMessages. s.mu must be held.
+func (s *Memory) trimDeadWebhookMessages() {
+	var dead []*WebhookMessage
+	for _, m := range s.outbox {
+		if m.Status == WebhookDead {
+			dead = append(dead, m)
+		}
+	}
+	if len(dead) <= maxDeadWebhookMessages {
+		return
+	}
+	sort.Slice(dead, func(i, j int) bool { return dead[i].CreatedAt.Before(dead[j].CreatedAt) })
+	for _, m := range dead[:len(dead)-maxDeadWebhookMessages] {
+		delete(s.outbox, m.ID)
+	}
+}
+
+var _ Store = (*Memory)(nil)
+
+func (s *Memory) SetFeatureFlag(f FeatureFlag) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.featureFlags[f.Name] = f
+}
+
+func (s *Memory) DeleteFeatureFlag(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.featureFlags[name]
+	delete(s.featureFlags, name)
+	return ok
+}
+
+// FeatureFlags returns the flags set at runtime, by name.
+func (s *Memory) FeatureFlags() []FeatureFlag {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	flags := make([]FeatureFlag, 0, len(s.featureFlags))
+	for _, f := range s.featureFlags {
+		flags = append(flags, f)
+	}
+	sort.Slice(flags, func(i, j int) bool { return flags[i].Name < flags[j].Name })
+	return flags
+}
+
+func (s *Memory) SetFeatureOverride(userID, flag string, enabled *bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if enabled == nil {
+		delete(s.featureUsers[userID], flag)
+		if len(s.featureUsers[userID]) == 0 {
+			delete(s.featureUsers, userID)
+		}
+		return
+	}
+	if s.featureUsers[userID] == nil {
+		s.featureUsers[userID] = make(map[string]bool)
+	}
+	s.featureUsers[userID][flag] = *enabled
+}
+
+func (s *Memory) FeatureOverrides(userID string) map[string]bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return maps.Clone(s.featureUsers[userID])
+}
+
+func (s *Memory) CreateOrg(o api.Organization, ownerID string) (api.Organization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[ownerID]; !ok {
+		return api.Organization{}, ErrUserNotFound
+	}
+	if _, taken := s.slugIndex[o.Slug]; taken {
+		return api.Organization{}, ErrSlugTaken
+	}
+	o.ID, o.CreatedAt = auth.GenerateID(), auth.Now()
+	s.orgs[o.ID] = &o
+	s.slugIndex[o.Slug] = o.ID
+	s.memberships[o.ID] = map[string]Membership{
+		ownerID: {OrgID: o.ID, UserID: ownerID, Role: api.OrgRoleOwner, JoinedAt: o.CreatedAt},
+	}
+	return o, nil
+}
+
+func (s *Memory) GetOrg(id string) (api.Organization, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	o, ok := s.orgs[id]
+	if !ok {
+		return api.Organization{}, ErrOrgNotFound
+	}
+	return *o, nil
+}
+
+// ListOrgs returns every organization, by slug.
+func (s *Memory) ListOrgs() []api.Organization {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	orgs := make([]api.Organization, 0, len(s.orgs))
+	for _, o := range s.orgs {
+		orgs = append(orgs, *o)
+	}
+	sort.Slice(orgs, func(i, j int) bool { return orgs[i].Slug < orgs[j].Slug })
+	return orgs
+}
+
+func (s *Memory) UpdateOrg(id string, fn func(*api.Organization)) (api.Organization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.orgs[id]
+	if !ok {
+		return api.Organization{}, ErrOrgNotFound
+	}
+	updated := *o
+	fn(&updated)
+	updated.ID, updated.CreatedAt = o.ID, o.CreatedAt
+	if updated.Slug != o.Slug {
+		if _, taken := s.slugIndex[updated.Slug]; taken {
+			return api.Organization{}, ErrSlugTaken
+		}
+		delete(s.slugIndex, o.Slug)
+		s.slugIndex[updated.Slug] = id
+	}
+	s.orgs[id] = &updated
+	return updated, nil
+}
+
+func (s *Memory) DeleteOrg(id string, force bool) ([]Membership, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.orgs[id]
+	if !ok {
+		return nil, ErrOrgNotFound
+	}
+	removed := slices.Collect(maps.Values(s.memberships[id]))
+	if !force && slices.ContainsFunc(removed, func(m Membership) bool { return m.Role != api.OrgRoleOwner }) {
+		return nil, ErrOrgHasMembers
+	}
+	for _, m := range removed {
+		s.revokeOrgTokens(m)
+	}
+	delete(s.orgs, id)
+	delete(s.slugIndex, o.Slug)
+	delete(s.memberships, id)
+	return removed, nil
+}
+
+func (s *Memory) AddOrgMember(m Membership) (Membership, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	members, ok := s.memberships[m.OrgID]
+	switch {
+	case !ok:
+		return Membership{}, ErrOrgNotFound
+	case s.users[m.UserID] == nil:
+		return Membership{}, ErrUserNotFound
+	}
+	if _, in := members[m.UserID]; in {
+		return Membership{}, ErrAlreadyMember
+	}
+	m.JoinedAt = auth.Now()
+	members[m.UserID] = m
+	return m, nil
+}
+
+func (s *Memory) SetOrgMemberRole(orgID, userID, role string) (Membership, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, err := s.orgMember(orgID, userID)
+	if err != nil {
+		return Membership{}, err
+	}
+	if role != api.OrgRoleOwner && s.lastOwner(m) {
+		return Membership{}, ErrLastOwner
+	}
+	if m.Role != role {
+		s.revokeOrgTokens(m)
+	}
+	m.Role = role
+	s.memberships[orgID][userID] = m
+	return m, nil
+}
+
+func (s *Memory) RemoveOrgMember(orgID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, err := s.orgMember(orgID, userID)
+	if err != nil {
+		return err
+	}
+	if s.lastOwner(m) {
+		return ErrLastOwner
+	}
+	s.revokeOrgTokens(m)
+	delete(s.memberships[orgID], userID)
+	return nil
+}
+
+// revokeOrgTokens ends the access tokens m's user holds for m's
+// organization.
+func (s *Memory) revokeOrgTokens(m Membership) {
+	s.orgRevoked[m.OrgID+"/"+m.UserID] = auth.Now().Truncate(time.Second).Add(time.Second).UTC()
+}
+
+func (s *Memory) OrgTokensValidAfter(orgID, userID string) time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.orgRevoked[orgID+"/"+userID]
+}
+
+func (s *Memory) orgMember(orgID, userID string) (Membership, error) {
+	members, ok := s.memberships[orgID]
+	if !ok {
+		return Membership{}, ErrOrgNotFound
+	}
+	m, ok := members[userID]
+	if !ok {
+		return Membership{}, ErrNotMember
+	}
+	return m, nil
+}
+
+// lastOwner reports whether m is the only owner of its organization.
+func (s *Memory) lastOwner(m Membership) bool {
+	if m.Role != api.OrgRoleOwner {
+		return false
+	}
+	for _, other := range s.memberships[m.OrgID] {
+		if other.UserID != m.UserID && other.Role == api.OrgRoleOwner {
+			return false
+		}
+	}
+	return true
+}
+
+// OrgMembers returns the members of an organization, by when they joined.
+func (s *Memory) OrgMembers(orgID string) []Membership {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	members := slices.Collect(maps.Values(s.memberships[orgID]))
+	sort.Slice(members, func(i, j int) bool { return members[i].JoinedAt.Before(members[j].JoinedAt) })
+	return members
+}
+
+// UserOrgs returns the memberships of a user, by when they joined.
+func (s *Memory) UserOrgs(userID string) []Membership {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []Membership
+	for _, members := range s.memberships {
+		if m, ok := members[userID]; ok {
+			out = append(out, m)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].JoinedAt.Before(out[j].JoinedAt) })
+	return out
+}
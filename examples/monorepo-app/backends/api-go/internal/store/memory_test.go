@@ -0,0 +1,77 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+)
+
+func TestValidateCSRFToken(t *testing.T) {
+	s := NewMemory()
+	s.StoreCSRFToken("live", "u1", "s1", time.Hour)
+	s.StoreCSRFToken("expired", "u1", "s1", -time.Second)
+
+	tests := []struct {
+		token, userID string
+		want          bool
+	}{
+		{"live", "u1", true},
+		{"live", "u2", false},
+		{"live", "", false},
+		{"expired", "u1", false},
+		{"unknown", "u1", false},
+	}
+	for _, tt := range tests {
+		if got := s.ValidateCSRFToken(tt.token, tt.userID); got != tt.want {
+			t.Errorf("ValidateCSRFToken(%q, %q) = %v, want %v", tt.token, tt.userID, got, tt.want)
+		}
+	}
+}
+
+func TestForEachUser(t *testing.T) {
+	s := NewMemory()
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		if _, err := s.CreateUser(email, "N", "each-password", "user"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, _ := s.GetUserByEmail("b@example.com")
+	s.UpdateUser(b.ID, func(u *api.User) { u.DeleteAfter = time.Now().Add(time.Hour) })
+
+	walk := func(ctx context.Context, f UserFilter, fn func(*api.User) error) ([]string, error) {
+		var ids []string
+		err := s.ForEachUser(ctx, f, func(u *api.User) error {
+			ids = append(ids, u.ID)
+			if fn != nil {
+				return fn(u)
+			}
+			return nil
+		})
+		return ids, err
+	}
+
+	var want []string
+	for _, u := range s.ListUsers() {
+		want = append(want, u.ID)
+	}
+	if got, err := walk(context.Background(), UserFilter{}, nil); err != nil || !slices.Equal(got, want) {
+		t.Errorf("everyone: %v, %v; want %v in ListUsers order", got, err, want)
+	}
+	if got, err := walk(context.Background(), UserFilter{PendingDeletion: true}, nil); err != nil || !slices.Equal(got, []string{b.ID}) {
+		t.Errorf("pending deletion: %v, %v", got, err)
+	}
+
+	stop := errors.New("stop")
+	if got, err := walk(context.Background(), UserFilter{}, func(*api.User) error { return stop }); err != stop || len(got) != 1 {
+		t.Errorf("fn failing: %d users, %v", len(got), err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	got, err := walk(ctx, UserFilter{}, func(*api.User) error { cancel(); return nil })
+	if !errors.Is(err, context.Canceled) || len(got) != 1 {
+		t.Errorf("canceled: %d users, %v", len(got), err)
+	}
+}
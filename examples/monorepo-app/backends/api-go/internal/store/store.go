@@ -0,0 +1,366 @@
+// Package store defines the Store the server persists to, its records and
+// Memory, the in-memory implementation (swap for PostgreSQL/pgx in
+// production).
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+)
+
+var (
+	ErrEmailTaken   = errors.New("email already registered")
+	ErrPhoneTaken   = errors.New("phone number already in use")
+	ErrUserNotFound = errors.New("user not found")
+
+	ErrOrgNotFound   = errors.New("organization not found")
+	ErrSlugTaken     = errors.New("organization slug already in use")
+	ErrAlreadyMember = errors.New("already a member of the organization")
+	ErrNotMember     = errors.New("not a member of the organization")
+	ErrLastOwner     = errors.New("the organization would be left without an owner")
+	ErrOrgHasMembers = errors.New("the organization has members other than its owners")
+)
+
+// Store is the persistence the server runs on. Implementations must be
+// safe for concurrent use; every method but Ping is expected to be fast.
+type Store interface {
+	// Ping reports whether the store can serve requests.
+	Ping(ctx context.Context) error
+	Stats() Stats
+
+	// Users. Returned users must not be modified; use UpdateUser, or
+	// SetUserPhone for the phone number, which is unique like the email.
+	CreateUser(email, name, password, role string) (*api.User, error)
+	GetUserByEmail(email string) (*api.User, error)
+	GetUserByPhone(phone string) (*api.User, error)
+	GetUserByID(id string) (*api.User, error)
+	ListUsers() []*api.User
+	// ForEachUser calls fn with every user f selects, in ListUsers order,
+	// without loading them all at once: a SQL store pages through them
+	// with a cursor. It stops at the first error from fn, or when ctx
+	// ends, and returns it.
+	ForEachUser(ctx context.Context, f UserFilter, fn func(*api.User) error) error
+	UpdateUser(id string, fn func(*api.User)) (*api.User, error)
+	SetUserPhone(id, phone string) (*api.User, error)
+	// PurgeUsers deletes the users pending deletion whose DeleteAfter is
+	// not after before, with their sessions, devices and exports, and
+	// returns them. It must be atomic: when replicas race, each user is
+	// returned by exactly one call.
+	PurgeUsers(before time.Time) []*api.User
+	// CountUsers totals the users for the admin stats, signups counted
+	// back from now. A SQL store answers it with aggregate queries.
+	CountUsers(now time.Time) UserCounts
+
+	// Refresh and CSRF tokens. A refresh token is the current token of
+	// its Session; rotation stores the next one with the same session.
+	// RevokeSession ends one session of userID: its refresh and CSRF
+	// tokens go, and IsSessionRevoked reports its ID until until, when the
+	// access tokens issued for it have expired. It is false when userID
+	// has no live session with that ID.
+	StoreRefreshToken(token string, s Session)
+	ValidateRefreshToken(token string) (Session, bool)
+	RevokeRefreshToken(token string)
+	RevokeUserRefreshTokens(userID string) int
+	UserSessions(userID string) []Session // live sessions, without their tokens
+	CountSessions() int                   // live sessions, of every user
+	StoreCSRFToken(token, userID, sessionID string, ttl time.Duration)
+	ValidateCSRFToken(token, userID string) bool // issued to userID and not expired
+	RevokeUserCSRFTokens(userID string) int
+	RevokeSession(userID, sessionID string, until time.Time) bool
+	IsSessionRevoked(sessionID string) bool
+	// SetSessionOrg sets the organization a session acts in, which its
+	// refreshes carry on, without rotating its refresh token.
+	SetSessionOrg(sessionID, orgID string)
+
+	// Idempotency-Key records.
+	BeginIdempotent(key, hash string, ttl time.Duration) (rec IdempotencyRecord, claimed bool)
+	CompleteIdempotent(key string, status int, contentType string, body []byte)
+	ReleaseIdempotent(key string)
+
+	// Security audit trail.
+	AppendSecurityEvent(e SecurityEvent, retain int)
+	SecurityEvents(f SecurityEventFilter) []SecurityEvent
+	CountSecurityEvents(f SecurityEventFilter) int // ignores f.Limit
+
+	// SAML: AuthnRequests awaiting a response, keyed by their RelayState,
+	// and the assertions already used (MarkSAMLAssertion is false for a
+	// replay).
+	StoreSAMLRequest(relayState, requestID string, ttl time.Duration)
+	ConsumeSAMLRequest(relayState string) (requestID string, ok bool)
+	MarkSAMLAssertion(id string, until time.Time) bool
+
+	// Nonces of signed internal requests, per caller: MarkNonce records
+	// key until until and is false for a replay.
+	MarkNonce(key string, until time.Time) bool
+
+	// Known devices per user, by fingerprint. RememberDevice adds
+	// fingerprint (or refreshes when it was last seen) and reports whether
+	// it was new and how many devices the user had before.
+	RememberDevice(userID, fingerprint string, at time.Time) (isNew bool, known int)
+
+	// One-time codes sent by SMS, per phone and purpose. CreateOTP stores c
+	// unless maxActive unexpired codes are already out. DeleteOTP reports
+	// whether it removed the code, so of two racing verifications only one
+	// consumes it.
+	CreateOTP(c OTPCode, maxActive int) bool
+	ActiveOTPs(phone, purpose string) []OTPCode
+	UpdateOTP(id string, fn func(*OTPCode)) bool
+	DeleteOTP(id string) bool
+
+	// Data exports. The worker records progress and the archive with
+	// UpdateDataExport; an export is dropped once it expires.
+	CreateDataExport(e DataExport)
+	GetDataExport(id string) (DataExport, bool)
+	UpdateDataExport(id string, fn func(*DataExport)) bool
+
+	// Webhook subscriptions and delivery attempts.
+	CreateWebhook(sub WebhookSubscription) WebhookSubscription
+	ListWebhooks() []WebhookSubscription
+	DeleteWebhook(id string) bool
+	WebhooksFor(eventType string) []WebhookSubscription
+	AppendWebhookDelivery(d WebhookDelivery, retain int)
+	WebhookDeliveries(subscriptionID string, limit int) []WebhookDelivery
+
+	// Webhook outbox: a WebhookMessage per event and subscription, added
+	// before the request that emitted the event returns, so a store that
+	// persists survives a restart with its deliveries. ClaimWebhookMessages
+	// leases up to limit pending messages due by now, pushing their
+	// NextAttempt lease ahead, so the message of a worker that died is
+	// claimed again once its lease runs out. A delivered message is
+	// deleted; a dead one stays for the admin API to list and redrive.
+	// Deleting a subscription deletes its messages.
+	EnqueueWebhookMessages(msgs []WebhookMessage)
+	ClaimWebhookMessages(now time.Time, limit int, lease time.Duration) []WebhookMessage
+	UpdateWebhookMessage(id string, fn func(*WebhookMessage)) (WebhookMessage, bool)
+	DeleteWebhookMessage(id string)
+	DeadWebhookMessages(subscriptionID string, limit int) []WebhookMessage
+
+	// Feature flags: the state an admin set at runtime, which replaces a
+	// flag's FEATURE_FLAGS default until deleted, and per-user overrides,
+	// which win over both. SetFeatureOverride with a nil enabled removes
+	// the user's override; purging a user removes all of them.
+	SetFeatureFlag(f FeatureFlag)
+	DeleteFeatureFlag(name string) bool
+	FeatureFlags() []FeatureFlag
+	SetFeatureOverride(userID, flag string, enabled *bool)
+	FeatureOverrides(userID string) map[string]bool
+
+	// Organizations and their members. A SQL store keeps them in two
+	// tables whose keys enforce what Memory checks by hand:
+	//
+	//	organizations (id PRIMARY KEY, name, slug UNIQUE, created_at)
+	//	org_memberships (
+	//	    org_id  REFERENCES organizations (id) ON DELETE CASCADE,
+	//	    user_id REFERENCES users (id) ON DELETE CASCADE,
+	//	    role CHECK (role IN ('owner', 'member')), joined_at,
+	//	    PRIMARY KEY (org_id, user_id))
+	//
+	// CreateOrg inserts o, with a new ID, and ownerID as its owner in one
+	// transaction. AddOrgMember fails with ErrOrgNotFound, ErrUserNotFound
+	// or ErrAlreadyMember; RemoveOrgMember and SetOrgMemberRole refuse with
+	// ErrLastOwner to leave the organization without an owner. DeleteOrg
+	// deletes the organization with its memberships and returns them, but
+	// refuses with ErrOrgHasMembers while members other than the owners
+	// remain, unless force. Purging a user removes their memberships.
+	//
+	// Every removal or role change records, per organization and user,
+	// the instant from which their access tokens for that organization
+	// are valid again (rounded up to the whole second, like
+	// User.TokensValidAfter); OrgTokensValidAfter returns it, zero when
+	// there was none. It outlives the membership, so a SQL store keeps it
+	// in a table of its own, without foreign keys.
+	CreateOrg(o api.Organization, ownerID string) (api.Organization, error)
+	GetOrg(id string) (api.Organization, error)
+	ListOrgs() []api.Organization
+	UpdateOrg(id string, fn func(*api.Organization)) (api.Organization, error)
+	DeleteOrg(id string, force bool) ([]Membership, error)
+	AddOrgMember(m Membership) (Membership, error)
+	SetOrgMemberRole(orgID, userID, role string) (Membership, error)
+	RemoveOrgMember(orgID, userID string) error
+	OrgMembers(orgID string) []Membership
+	UserOrgs(userID string) []Membership
+	OrgTokensValidAfter(orgID, userID string) time.Time
+}
+
+// Stats are the store's record counts, published at /metrics.
+type Stats struct {
+	Users         int `json:"users"`
+	RefreshTokens int `json:"refresh_tokens"`
+	CSRFTokens    int `json:"csrf_tokens"`
+	Events        int `json:"security_events"`
+	WebhookQueued int `json:"webhook_outbox_pending"`
+	WebhookDead   int `json:"webhook_outbox_dead"`
+}
+
+// UserCounts are the user totals of the admin stats. The signups are the
+// users created within the last 24 hours, 7 days and 30 days.
+type UserCounts struct {
+	Total           int            `json:"total_users"`
+	ByRole          map[string]int `json:"users_by_role"`
+	Signups24h      int            `json:"signups_24h"`
+	Signups7d       int            `json:"signups_7d"`
+	Signups30d      int            `json:"signups_30d"`
+	Suspended       int            `json:"suspended_users"`
+	PendingDeletion int            `json:"pending_deletion"`
+}
+
+// IdempotencyRecord is the saved outcome of a request made with an
+// Idempotency-Key. Done is false while the first request is still running.
+type IdempotencyRecord struct {
+	RequestHash string
+	Done        bool
+	Status      int
+	ContentType string
+	Body        []byte
+	ExpiresAt   time.Time
+}
+
+// Session is one signed-in session: the family of refresh tokens rotated
+// from a single login. Refreshes move ExpiresAt (when sliding), never
+// past Deadline.
+type Session struct {
+	ID          string    `json:"id"` // of the family, the same across rotations
+	UserID      string    `json:"-"`
+	StartedAt   time.Time `json:"started_at"`   // the login
+	RefreshedAt time.Time `json:"refreshed_at"` // when the current token was issued
+	ExpiresAt   time.Time `json:"expires_at"`   // of the current token
+	Deadline    time.Time `json:"deadline"`     // absolute end of the session
+	// OrgID is the organization it acts in; see SetSessionOrg.
+	OrgID string `json:"org_id,omitempty"`
+}
+
+// OTPCode is a one-time code sent to Phone, stored as a keyed hash.
+// Purpose is "login", or "verify" to confirm Phone for UserID.
+type OTPCode struct {
+	ID        string
+	Phone     string
+	Purpose   string
+	UserID    string
+	Hash      []byte
+	Attempts  int // wrong codes entered while it was active
+	ExpiresAt time.Time
+}
+
+// DataExport is a copy of everything held about a user, built in the
+// background. Archive is set once Status is "ready".
+type DataExport struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"-"`
+	Status    string    `json:"status"`   // pending, running, ready or failed
+	Progress  int       `json:"progress"` // percent
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Archive   []byte    `json:"-"`
+}
+
+// SecurityEvent is one entry of the security audit trail. It is kept apart
+// from the access log: fewer, richer records meant to be retained.
+type SecurityEvent struct {
+	Time      time.Time         `json:"time"`
+	Type      string            `json:"type"`
+	UserID    string            `json:"user_id,omitempty"`
+	Email     string            `json:"email,omitempty"` // attempted email when there is no user
+	IP        string            `json:"ip"`
+	UserAgent string            `json:"user_agent,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+	TraceID   string            `json:"trace_id,omitempty"`
+	SpanID    string            `json:"span_id,omitempty"`
+	Outcome   string            `json:"outcome"` // success, failure or denied
+	Details   map[string]string `json:"details,omitempty"`
+	// Seq is set by AppendSecurityEvent, increasing with every event
+	// appended (a SQL store's auto-increment key), for cursor pagination.
+	Seq uint64 `json:"-"`
+}
+
+// WebhookSubscription is an endpoint that receives the listed event types.
+// Secret is only returned when the subscription is created.
+type WebhookSubscription struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookDelivery records one delivery attempt.
+type WebhookDelivery struct {
+	SubscriptionID string    `json:"subscription_id"`
+	EventID        string    `json:"event_id"`
+	EventType      string    `json:"event_type"`
+	Attempt        int       `json:"attempt"`
+	StatusCode     int       `json:"status_code,omitempty"`
+	Error          string    `json:"error,omitempty"`
+	Outcome        string    `json:"outcome"` // delivered, retrying or dead
+	Time           time.Time `json:"time"`
+	DurationMS     int64     `json:"duration_ms"`
+}
+
+// WebhookMessage is one event for one subscription in the webhook outbox:
+// pending until delivered, when it is deleted, or dead once its last
+// attempt failed.
+type WebhookMessage struct {
+	ID             string          `json:"id"`
+	SubscriptionID string          `json:"subscription_id"`
+	EventID        string          `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Body           json.RawMessage `json:"body"` // the WebhookEvent, as signed
+	Traceparent    string          `json:"-"`    // of the request that emitted the event
+	Tracestate     string          `json:"-"`
+	Status         string          `json:"status"` // pending or dead
+	Attempts       int             `json:"attempts"`
+	NextAttempt    time.Time       `json:"next_attempt,omitzero"` // unset once dead
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// Webhook message statuses.
+const (
+	WebhookPending = "pending"
+	WebhookDead    = "dead"
+)
+
+// FeatureFlag is the state of a flag set through the admin API.
+type FeatureFlag struct {
+	Name      string    `json:"name"`
+	Enabled   bool      `json:"enabled"`
+	Rollout   int       `json:"rollout"` // percent of users it is on for when enabled
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Membership is a user's place in an organization, with their role in it
+// (api.OrgRoleOwner or api.OrgRoleMember).
+type Membership struct {
+	OrgID    string    `json:"org_id"`
+	UserID   string    `json:"user_id"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// UserFilter selects users for ForEachUser. Zero fields match everyone.
+type UserFilter struct {
+	PendingDeletion bool // only accounts scheduled for deletion
+}
+
+// SecurityEventFilter selects events for SecurityEvents. Zero fields match
+// everything; User matches the user ID or the (attempted) email.
+//
+// Subject, a user ID, selects a user's activity timeline: the events
+// recorded under the user and the admin actions on them (type
+// "admin_action" with Details "user_id"); SubjectEmail adds the events
+// recorded under their email, such as mail sent to it. Before keeps the events older than the one with
+// that Seq, the cursor of the page before.
+type SecurityEventFilter struct {
+	Type         string
+	User         string
+	Subject      string
+	SubjectEmail string
+	Since        time.Time
+	Until        time.Time
+	Before       uint64
+	Limit        int
+}
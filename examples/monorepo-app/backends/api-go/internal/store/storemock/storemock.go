@@ -0,0 +1,775 @@
+// Package storemock is a programmable store.Store for tests. Each method
+// records its call, waits Latency, then runs its Func field if set and
+// otherwise forwards to Fallback (a fresh store.Memory by default). So a
+// test overrides only the methods it cares about:
+//
+//	st := storemock.New()
+//	st.Fail("GetUserByID", storemock.ErrInjected)
+//	srv := raijintest.NewServer(t, raijintest.WithStore(st))
+//	// GET /api/v2/users/me now answers 500 internal_error
+//
+// It lives under internal/ because the Store it fakes does.
+package storemock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/auth"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+)
+
+// ErrInjected is a stand-in for a store failure (a dropped connection, a
+// timeout) that tests can match with errors.Is.
+var ErrInjected = errors.New("storemock: injected error")
+
+// Call is one recorded method call.
+type Call struct {
+	Method string
+	Args   []any
+}
+
+// Store is the fake. Set the Func fields before the store is shared; they
+// are read without locking.
+type Store struct {
+	Fallback store.Store
+	// Latency is added to every call, e.g. to exercise request timeouts.
+	// Ping gives up early if its context ends.
+	Latency time.Duration
+
+	PingFunc                    func(ctx context.Context) error
+	StatsFunc                   func() store.Stats
+	CreateUserFunc              func(email, name, password, role string) (*api.User, error)
+	GetUserByEmailFunc          func(email string) (*api.User, error)
+	GetUserByPhoneFunc          func(phone string) (*api.User, error)
+	GetUserByIDFunc             func(id string) (*api.User, error)
+	ListUsersFunc               func() []*api.User
+	ForEachUserFunc             func(ctx context.Context, f store.UserFilter, fn func(*api.User) error) error
+	UpdateUserFunc              func(id string, fn func(*api.User)) (*api.User, error)
+	SetUserPhoneFunc            func(id, phone string) (*api.User, error)
+	PurgeUsersFunc              func(before time.Time) []*api.User
+	CountUsersFunc              func(now time.Time) store.UserCounts
+	StoreRefreshTokenFunc       func(token string, sess store.Session)
+	ValidateRefreshTokenFunc    func(token string) (store.Session, bool)
+	RevokeRefreshTokenFunc      func(token string)
+	RevokeUserRefreshTokensFunc func(userID string) int
+	StoreCSRFTokenFunc          func(token, userID, sessionID string, ttl time.Duration)
+	ValidateCSRFTokenFunc       func(token, userID string) bool
+	RevokeUserCSRFTokensFunc    func(userID string) int
+	RevokeSessionFunc           func(userID, sessionID string, until time.Time) bool
+	IsSessionRevokedFunc        func(sessionID string) bool
+	SetSessionOrgFunc           func(sessionID, orgID string)
+	BeginIdempotentFunc         func(key, hash string, ttl time.Duration) (store.IdempotencyRecord, bool)
+	CompleteIdempotentFunc      func(key string, status int, contentType string, body []byte)
+	ReleaseIdempotentFunc       func(key string)
+	AppendSecurityEventFunc     func(e store.SecurityEvent, retain int)
+	SecurityEventsFunc          func(f store.SecurityEventFilter) []store.SecurityEvent
+	CountSecurityEventsFunc     func(f store.SecurityEventFilter) int
+	StoreSAMLRequestFunc        func(relayState, requestID string, ttl time.Duration)
+	ConsumeSAMLRequestFunc      func(relayState string) (string, bool)
+	MarkSAMLAssertionFunc       func(id string, until time.Time) bool
+	MarkNonceFunc               func(key string, until time.Time) bool
+	RememberDeviceFunc          func(userID, fingerprint string, at time.Time) (bool, int)
+	UserSessionsFunc            func(userID string) []store.Session
+	CountSessionsFunc           func() int
+	CreateOTPFunc               func(c store.OTPCode, maxActive int) bool
+	ActiveOTPsFunc              func(phone, purpose string) []store.OTPCode
+	UpdateOTPFunc               func(id string, fn func(*store.OTPCode)) bool
+	DeleteOTPFunc               func(id string) bool
+	CreateDataExportFunc        func(e store.DataExport)
+	GetDataExportFunc           func(id string) (store.DataExport, bool)
+	UpdateDataExportFunc        func(id string, fn func(*store.DataExport)) bool
+	CreateWebhookFunc           func(sub store.WebhookSubscription) store.WebhookSubscription
+	ListWebhooksFunc            func() []store.WebhookSubscription
+	DeleteWebhookFunc           func(id string) bool
+	WebhooksForFunc             func(eventType string) []store.WebhookSubscription
+	AppendWebhookDeliveryFunc   func(d store.WebhookDelivery, retain int)
+	WebhookDeliveriesFunc       func(subscriptionID string, limit int) []store.WebhookDelivery
+	EnqueueWebhookMessagesFunc  func(msgs []store.WebhookMessage)
+	ClaimWebhookMessagesFunc    func(now time.Time, limit int, lease time.Duration) []store.WebhookMessage
+	UpdateWebhookMessageFunc    func(id string, fn func(*store.WebhookMessage)) (store.WebhookMessage, bool)
+	DeleteWebhookMessageFunc    func(id string)
+	DeadWebhookMessagesFunc     func(subscriptionID string, limit int) []store.WebhookMessage
+	SetFeatureFlagFunc          func(f store.FeatureFlag)
+	DeleteFeatureFlagFunc       func(name string) bool
+	FeatureFlagsFunc            func() []store.FeatureFlag
+	SetFeatureOverrideFunc      func(userID, flag string, enabled *bool)
+	FeatureOverridesFunc        func(userID string) map[string]bool
+	CreateOrgFunc               func(o api.Organization, ownerID string) (api.Organization, error)
+	GetOrgFunc                  func(id string) (api.Organization, error)
+	ListOrgsFunc                func() []api.Organization
+	UpdateOrgFunc               func(id string, fn func(*api.Organization)) (api.Organization, error)
+	DeleteOrgFunc               func(id string, force bool) ([]store.Membership, error)
+	AddOrgMemberFunc            func(m store.Membership) (store.Membership, error)
+	SetOrgMemberRoleFunc        func(orgID, userID, role string) (store.Membership, error)
+	RemoveOrgMemberFunc         func(orgID, userID string) error
+	OrgMembersFunc              func(orgID string) []store.Membership
+	UserOrgsFunc                func(userID string) []store.Membership
+	OrgTokensValidAfterFunc     func(orgID, userID string) time.Time
+
+	mu    sync.Mutex
+	calls []Call
+}
+
+// New returns a Store that behaves like store.NewMemory until told
+// otherwise.
+func New() *Store {
+	return &Store{Fallback: store.NewMemory()}
+}
+
+// Fail makes method return err from now on. Only the methods that return
+// an error can fail: Ping, CreateUser, GetUserByEmail, GetUserByID,
+// ForEachUser and UpdateUser.
+func (s *Store) Fail(method string, err error) {
+	switch method {
+	case "Ping":
+		s.PingFunc = func(context.Context) error { return err }
+	case "CreateUser":
+		s.CreateUserFunc = func(string, string, string, string) (*api.User, error) { return nil, err }
+	case "GetUserByEmail":
+		s.GetUserByEmailFunc = func(string) (*api.User, error) { return nil, err }
+	case "GetUserByID":
+		s.GetUserByIDFunc = func(string) (*api.User, error) { return nil, err }
+	case "ForEachUser":
+		s.ForEachUserFunc = func(context.Context, store.UserFilter, func(*api.User) error) error { return err }
+	case "UpdateUser":
+		s.UpdateUserFunc = func(string, func(*api.User)) (*api.User, error) { return nil, err }
+	default:
+		panic(fmt.Sprintf("storemock: %s cannot fail", method))
+	}
+}
+
+// Calls returns the recorded calls to method, oldest first, or every call
+// when method is "".
+func (s *Store) Calls(method string) []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []Call
+	for _, c := range s.calls {
+		if method == "" || c.Method == method {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Reset forgets the recorded calls.
+func (s *Store) Reset() {
+	s.mu.Lock()
+	s.calls = nil
+	s.mu.Unlock()
+}
+
+// User returns a user fixture with role, its password hashed from
+// "password", as GetUserByID or GetUserByEmail would.
+func User(role string) *api.User {
+	hashed, _ := auth.HashPassword("password")
+	now := auth.Now()
+	id := auth.GenerateID()
+	return &api.User{
+		ID: id, Email: id + "@storemock.local", Name: "Mock " + role, Role: role,
+		Password: hashed, CreatedAt: now, UpdatedAt: now,
+	}
+}
+
+// Returning makes GetUserByID and GetUserByEmail find u, whatever they
+// are asked for.
+func (s *Store) Returning(u *api.User) {
+	s.GetUserByIDFunc = func(string) (*api.User, error) { return u, nil }
+	s.GetUserByEmailFunc = func(string) (*api.User, error) { return u, nil }
+}
+
+func (s *Store) record(method string, args ...any) {
+	s.mu.Lock()
+	s.calls = append(s.calls, Call{Method: method, Args: args})
+	s.mu.Unlock()
+	if s.Latency > 0 {
+		time.Sleep(s.Latency)
+	}
+}
+
+func (s *Store) Ping(ctx context.Context) error {
+	s.mu.Lock()
+	s.calls = append(s.calls, Call{Method: "Ping"})
+	s.mu.Unlock()
+	if s.Latency > 0 {
+		t := time.NewTimer(s.Latency)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if s.PingFunc != nil {
+		return s.PingFunc(ctx)
+	}
+	return s.Fallback.Ping(ctx)
+}
+
+func (s *Store) Stats() store.Stats {
+	s.record("Stats")
+	if s.StatsFunc != nil {
+		return s.StatsFunc()
+	}
+	return s.Fallback.Stats()
+}
+
+// CreateUser records the password as "<redacted>" so call logs can be
+// printed safely.
+func (s *Store) CreateUser(email, name, password, role string) (*api.User, error) {
+	s.record("CreateUser", email, name, "<redacted>", role)
+	if s.CreateUserFunc != nil {
+		return s.CreateUserFunc(email, name, password, role)
+	}
+	return s.Fallback.CreateUser(email, name, password, role)
+}
+
+func (s *Store) GetUserByEmail(email string) (*api.User, error) {
+	s.record("GetUserByEmail", email)
+	if s.GetUserByEmailFunc != nil {
+		return s.GetUserByEmailFunc(email)
+	}
+	return s.Fallback.GetUserByEmail(email)
+}
+
+func (s *Store) GetUserByPhone(phone string) (*api.User, error) {
+	s.record("GetUserByPhone", phone)
+	if s.GetUserByPhoneFunc != nil {
+		return s.GetUserByPhoneFunc(phone)
+	}
+	return s.Fallback.GetUserByPhone(phone)
+}
+
+func (s *Store) GetUserByID(id string) (*api.User, error) {
+	s.record("GetUserByID", id)
+	if s.GetUserByIDFunc != nil {
+		return s.GetUserByIDFunc(id)
+	}
+	return s.Fallback.GetUserByID(id)
+}
+
+func (s *Store) ListUsers() []*api.User {
+	s.record("ListUsers")
+	if s.ListUsersFunc != nil {
+		return s.ListUsersFunc()
+	}
+	return s.Fallback.ListUsers()
+}
+
+func (s *Store) ForEachUser(ctx context.Context, f store.UserFilter, fn func(*api.User) error) error {
+	s.record("ForEachUser", f)
+	if s.ForEachUserFunc != nil {
+		return s.ForEachUserFunc(ctx, f, fn)
+	}
+	return s.Fallback.ForEachUser(ctx, f, fn)
+}
+
+func (s *Store) UpdateUser(id string, fn func(*api.User)) (*api.User, error) {
+	s.record("UpdateUser", id)
+	if s.UpdateUserFunc != nil {
+		return s.UpdateUserFunc(id, fn)
+	}
+	return s.Fallback.UpdateUser(id, fn)
+}
+
+func (s *Store) SetUserPhone(id, phone string) (*api.User, error) {
+	s.record("SetUserPhone", id, phone)
+	if s.SetUserPhoneFunc != nil {
+		return s.SetUserPhoneFunc(id, phone)
+	}
+	return s.Fallback.SetUserPhone(id, phone)
+}
+
+func (s *Store) PurgeUsers(before time.Time) []*api.User {
+	s.record("PurgeUsers", before)
+	if s.PurgeUsersFunc != nil {
+		return s.PurgeUsersFunc(before)
+	}
+	return s.Fallback.PurgeUsers(before)
+}
+
+func (s *Store) CountUsers(now time.Time) store.UserCounts {
+	s.record("CountUsers", now)
+	if s.CountUsersFunc != nil {
+		return s.CountUsersFunc(now)
+	}
+	return s.Fallback.CountUsers(now)
+}
+
+func (s *Store) StoreRefreshToken(token string, sess store.Session) {
+	s.record("StoreRefreshToken", token, sess)
+	if s.StoreRefreshTokenFunc != nil {
+		s.StoreRefreshTokenFunc(token, sess)
+		return
+	}
+	s.Fallback.StoreRefreshToken(token, sess)
+}
+
+func (s *Store) ValidateRefreshToken(token string) (store.Session, bool) {
+	s.record("ValidateRefreshToken", token)
+	if s.ValidateRefreshTokenFunc != nil {
+		return s.ValidateRefreshTokenFunc(token)
+	}
+	return s.Fallback.ValidateRefreshToken(token)
+}
+
+func (s *Store) RevokeRefreshToken(token string) {
+	s.record("RevokeRefreshToken", token)
+	if s.RevokeRefreshTokenFunc != nil {
+		s.RevokeRefreshTokenFunc(token)
+		return
+	}
+	s.Fallback.RevokeRefreshToken(token)
+}
+
+func (s *Store) RevokeUserRefreshTokens(userID string) int {
+	s.record("RevokeUserRefreshTokens", userID)
+	if s.RevokeUserRefreshTokensFunc != nil {
+		return s.RevokeUserRefreshTokensFunc(userID)
+	}
+	return s.Fallback.RevokeUserRefreshTokens(userID)
+}
+
+func (s *Store) StoreCSRFToken(token, userID, sessionID string, ttl time.Duration) {
+	s.record("StoreCSRFToken", token, userID, sessionID, ttl)
+	if s.StoreCSRFTokenFunc != nil {
+		s.StoreCSRFTokenFunc(token, userID, sessionID, ttl)
+		return
+	}
+	s.Fallback.StoreCSRFToken(token, userID, sessionID, ttl)
+}
+
+func (s *Store) ValidateCSRFToken(token, userID string) bool {
+	s.record("ValidateCSRFToken", token, userID)
+	if s.ValidateCSRFTokenFunc != nil {
+		return s.ValidateCSRFTokenFunc(token, userID)
+	}
+	return s.Fallback.ValidateCSRFToken(token, userID)
+}
+
+func (s *Store) RevokeUserCSRFTokens(userID string) int {
+	s.record("RevokeUserCSRFTokens", userID)
+	if s.RevokeUserCSRFTokensFunc != nil {
+		return s.RevokeUserCSRFTokensFunc(userID)
+	}
+	return s.Fallback.RevokeUserCSRFTokens(userID)
+}
+
+func (s *Store) RevokeSession(userID, sessionID string, until time.Time) bool {
+	s.record("RevokeSession", userID, sessionID, until)
+	if s.RevokeSessionFunc != nil {
+		return s.RevokeSessionFunc(userID, sessionID, until)
+	}
+	return s.Fallback.RevokeSession(userID, sessionID, until)
+}
+
+func (s *Store) SetSessionOrg(sessionID, orgID string) {
+	s.record("SetSessionOrg", sessionID, orgID)
+	if s.SetSessionOrgFunc != nil {
+		s.SetSessionOrgFunc(sessionID, orgID)
+		return
+	}
+	s.Fallback.SetSessionOrg(sessionID, orgID)
+}
+
+func (s *Store) IsSessionRevoked(sessionID string) bool {
+	s.record("IsSessionRevoked", sessionID)
+	if s.IsSessionRevokedFunc != nil {
+		return s.IsSessionRevokedFunc(sessionID)
+	}
+	return s.Fallback.IsSessionRevoked(sessionID)
+}
+
+func (s *Store) BeginIdempotent(key, hash string, ttl time.Duration) (store.IdempotencyRecord, bool) {
+	s.record("BeginIdempotent", key, hash, ttl)
+	if s.BeginIdempotentFunc != nil {
+		return s.BeginIdempotentFunc(key, hash, ttl)
+	}
+	return s.Fallback.BeginIdempotent(key, hash, ttl)
+}
+
+func (s *Store) CompleteIdempotent(key string, status int, contentType string, body []byte) {
+	s.record("CompleteIdempotent", key, status, contentType, body)
+	if s.CompleteIdempotentFunc != nil {
+		s.CompleteIdempotentFunc(key, status, contentType, body)
+		return
+	}
+	s.Fallback.CompleteIdempotent(key, status, contentType, body)
+}
+
+func (s *Store) ReleaseIdempotent(key string) {
+	s.record("ReleaseIdempotent", key)
+	if s.ReleaseIdempotentFunc != nil {
+		s.ReleaseIdempotentFunc(key)
+		return
+	}
+	s.Fallback.ReleaseIdempotent(key)
+}
+
+func (s *Store) AppendSecurityEvent(e store.SecurityEvent, retain int) {
+	s.record("AppendSecurityEvent", e, retain)
+	if s.AppendSecurityEventFunc != nil {
+		s.AppendSecurityEventFunc(e, retain)
+		return
+	}
+	s.Fallback.AppendSecurityEvent(e, retain)
+}
+
+func (s *Store) SecurityEvents(f store.SecurityEventFilter) []store.SecurityEvent {
+	s.record("SecurityEvents", f)
+	if s.SecurityEventsFunc != nil {
+		return s.SecurityEventsFunc(f)
+	}
+	return s.Fallback.SecurityEvents(f)
+}
+
+func (s *Store) CountSecurityEvents(f store.SecurityEventFilter) int {
+	s.record("CountSecurityEvents", f)
+	if s.CountSecurityEventsFunc != nil {
+		return s.CountSecurityEventsFunc(f)
+	}
+	return s.Fallback.CountSecurityEvents(f)
+}
+
+func (s *Store) StoreSAMLRequest(relayState, requestID string, ttl time.Duration) {
+	s.record("StoreSAMLRequest", relayState, requestID, ttl)
+	if s.StoreSAMLRequestFunc != nil {
+		s.StoreSAMLRequestFunc(relayState, requestID, ttl)
+		return
+	}
+	s.Fallback.StoreSAMLRequest(relayState, requestID, ttl)
+}
+
+func (s *Store) ConsumeSAMLRequest(relayState string) (string, bool) {
+	s.record("ConsumeSAMLRequest", relayState)
+	if s.ConsumeSAMLRequestFunc != nil {
+		return s.ConsumeSAMLRequestFunc(relayState)
+	}
+	return s.Fallback.ConsumeSAMLRequest(relayState)
+}
+
+func (s *Store) MarkSAMLAssertion(id string, until time.Time) bool {
+	s.record("MarkSAMLAssertion", id, until)
+	if s.MarkSAMLAssertionFunc != nil {
+		return s.MarkSAMLAssertionFunc(id, until)
+	}
+	return s.Fallback.MarkSAMLAssertion(id, until)
+}
+
+func (s *Store) MarkNonce(key string, until time.Time) bool {
+	s.record("MarkNonce", key, until)
+	if s.MarkNonceFunc != nil {
+		return s.MarkNonceFunc(key, until)
+	}
+	return s.Fallback.MarkNonce(key, until)
+}
+
+func (s *Store) RememberDevice(userID, fingerprint string, at time.Time) (bool, int) {
+	s.record("RememberDevice", userID, fingerprint, at)
+	if s.RememberDeviceFunc != nil {
+		return s.RememberDeviceFunc(userID, fingerprint, at)
+	}
+	return s.Fallback.RememberDevice(userID, fingerprint, at)
+}
+
+func (s *Store) UserSessions(userID string) []store.Session {
+	s.record("UserSessions", userID)
+	if s.UserSessionsFunc != nil {
+		return s.UserSessionsFunc(userID)
+	}
+	return s.Fallback.UserSessions(userID)
+}
+
+func (s *Store) CountSessions() int {
+	s.record("CountSessions")
+	if s.CountSessionsFunc != nil {
+		return s.CountSessionsFunc()
+	}
+	return s.Fallback.CountSessions()
+}
+
+// CreateOTP records the code without its hash.
+func (s *Store) CreateOTP(c store.OTPCode, maxActive int) bool {
+	s.record("CreateOTP", c.Phone, c.Purpose, maxActive)
+	if s.CreateOTPFunc != nil {
+		return s.CreateOTPFunc(c, maxActive)
+	}
+	return s.Fallback.CreateOTP(c, maxActive)
+}
+
+func (s *Store) ActiveOTPs(phone, purpose string) []store.OTPCode {
+	s.record("ActiveOTPs", phone, purpose)
+	if s.ActiveOTPsFunc != nil {
+		return s.ActiveOTPsFunc(phone, purpose)
+	}
+	return s.Fallback.ActiveOTPs(phone, purpose)
+}
+
+func (s *Store) UpdateOTP(id string, fn func(*store.OTPCode)) bool {
+	s.record("UpdateOTP", id)
+	if s.UpdateOTPFunc != nil {
+		return s.UpdateOTPFunc(id, fn)
+	}
+	return s.Fallback.UpdateOTP(id, fn)
+}
+
+func (s *Store) DeleteOTP(id string) bool {
+	s.record("DeleteOTP", id)
+	if s.DeleteOTPFunc != nil {
+		return s.DeleteOTPFunc(id)
+	}
+	return s.Fallback.DeleteOTP(id)
+}
+
+func (s *Store) CreateDataExport(e store.DataExport) {
+	s.record("CreateDataExport", e)
+	if s.CreateDataExportFunc != nil {
+		s.CreateDataExportFunc(e)
+		return
+	}
+	s.Fallback.CreateDataExport(e)
+}
+
+func (s *Store) GetDataExport(id string) (store.DataExport, bool) {
+	s.record("GetDataExport", id)
+	if s.GetDataExportFunc != nil {
+		return s.GetDataExportFunc(id)
+	}
+	return s.Fallback.GetDataExport(id)
+}
+
+func (s *Store) UpdateDataExport(id string, fn func(*store.DataExport)) bool {
+	s.record("UpdateDataExport", id)
+	if s.UpdateDataExportFunc != nil {
+		return s.UpdateDataExportFunc(id, fn)
+	}
+	return s.Fallback.UpdateDataExport(id, fn)
+}
+
+func (s *Store) CreateWebhook(sub store.WebhookSubscription) store.WebhookSubscription {
+	s.record("CreateWebhook", sub)
+	if s.CreateWebhookFunc != nil {
+		return s.CreateWebhookFunc(sub)
+	}
+	return s.Fallback.CreateWebhook(sub)
+}
+
+func (s *Store) ListWebhooks() []store.WebhookSubscription {
+	s.record("ListWebhooks")
+	if s.ListWebhooksFunc != nil {
+		return s.ListWebhooksFunc()
+	}
+	return s.Fallback.ListWebhooks()
+}
+
+func (s *Store) DeleteWebhook(id string) bool {
+	s.record("DeleteWebhook", id)
+	if s.DeleteWebhookFunc != nil {
+		return s.DeleteWebhookFunc(id)
+	}
+	return s.Fallback.DeleteWebhook(id)
+}
+
+func (s *Store) WebhooksFor(eventType string) []store.WebhookSubscription {
+	s.record("WebhooksFor", eventType)
+	if s.WebhooksForFunc != nil {
+		return s.WebhooksForFunc(eventType)
+	}
+	return s.Fallback.WebhooksFor(eventType)
+}
+
+func (s *Store) AppendWebhookDelivery(d store.WebhookDelivery, retain int) {
+	s.record("AppendWebhookDelivery", d, retain)
+	if s.AppendWebhookDeliveryFunc != nil {
+		s.AppendWebhookDeliveryFunc(d, retain)
+		return
+	}
+	s.Fallback.AppendWebhookDelivery(d, retain)
+}
+
+func (s *Store) WebhookDeliveries(subscriptionID string, limit int) []store.WebhookDelivery {
+	s.record("WebhookDeliveries", subscriptionID, limit)
+	if s.WebhookDeliveriesFunc != nil {
+		return s.WebhookDeliveriesFunc(subscriptionID, limit)
+	}
+	return s.Fallback.WebhookDeliveries(subscriptionID, limit)
+}
+
+func (s *Store) EnqueueWebhookMessages(msgs []store.WebhookMessage) {
+	s.record("EnqueueWebhookMessages", msgs)
+	if s.EnqueueWebhookMessagesFunc != nil {
+		s.EnqueueWebhookMessagesFunc(msgs)
+		return
+	}
+	s.Fallback.EnqueueWebhookMessages(msgs)
+}
+
+func (s *Store) ClaimWebhookMessages(now time.Time, limit int, lease time.Duration) []store.WebhookMessage {
+	s.record("ClaimWebhookMessages", now, limit, lease)
+	if s.ClaimWebhookMessagesFunc != nil {
+		return s.ClaimWebhookMessagesFunc(now, limit, lease)
+	}
+	return s.Fallback.ClaimWebhookMessages(now, limit, lease)
+}
+
+func (s *Store) UpdateWebhookMessage(id string, fn func(*store.WebhookMessage)) (store.WebhookMessage, bool) {
+	s.record("UpdateWebhookMessage", id)
+	if s.UpdateWebhookMessageFunc != nil {
+		return s.UpdateWebhookMessageFunc(id, fn)
+	}
+	return s.Fallback.UpdateWebhookMessage(id, fn)
+}
+
+func (s *Store) DeleteWebhookMessage(id string) {
+	s.record("DeleteWebhookMessage", id)
+	if s.DeleteWebhookMessageFunc != nil {
+		s.DeleteWebhookMessageFunc(id)
+		return
+	}
+	s.Fallback.DeleteWebhookMessage(id)
+}
+
+func (s *Store) DeadWebhookMessages(subscriptionID string, limit int) []store.WebhookMessage {
+	s.record("DeadWebhookMessages", subscriptionID, limit)
+	if s.DeadWebhookMessagesFunc != nil {
+		return s.DeadWebhookMessagesFunc(subscriptionID, limit)
+	}
+	return s.Fallback.DeadWebhookMessages(subscriptionID, limit)
+}
+
+func (s *Store) SetFeatureFlag(f store.FeatureFlag) {
+	s.record("SetFeatureFlag", f)
+	if s.SetFeatureFlagFunc != nil {
+		s.SetFeatureFlagFunc(f)
+		return
+	}
+	s.Fallback.SetFeatureFlag(f)
+}
+
+func (s *Store) DeleteFeatureFlag(name string) bool {
+	s.record("DeleteFeatureFlag", name)
+	if s.DeleteFeatureFlagFunc != nil {
+		return s.DeleteFeatureFlagFunc(name)
+	}
+	return s.Fallback.DeleteFeatureFlag(name)
+}
+
+func (s *Store) FeatureFlags() []store.FeatureFlag {
+	s.record("FeatureFlags")
+	if s.FeatureFlagsFunc != nil {
+		return s.FeatureFlagsFunc()
+	}
+	return s.Fallback.FeatureFlags()
+}
+
+func (s *Store) SetFeatureOverride(userID, flag string, enabled *bool) {
+	s.record("SetFeatureOverride", userID, flag, enabled)
+	if s.SetFeatureOverrideFunc != nil {
+		s.SetFeatureOverrideFunc(userID, flag, enabled)
+		return
+	}
+	s.Fallback.SetFeatureOverride(userID, flag, enabled)
+}
+
+func (s *Store) FeatureOverrides(userID string) map[string]bool {
+	s.record("FeatureOverrides", userID)
+	if s.FeatureOverridesFunc != nil {
+		return s.FeatureOverridesFunc(userID)
+	}
+	return s.Fallback.FeatureOverrides(userID)
+}
+
+func (s *Store) CreateOrg(o api.Organization, ownerID string) (api.Organization, error) {
+	s.record("CreateOrg", o, ownerID)
+	if s.CreateOrgFunc != nil {
+		return s.CreateOrgFunc(o, ownerID)
+	}
+	return s.Fallback.CreateOrg(o, ownerID)
+}
+
+func (s *Store) GetOrg(id string) (api.Organization, error) {
+	s.record("GetOrg", id)
+	if s.GetOrgFunc != nil {
+		return s.GetOrgFunc(id)
+	}
+	return s.Fallback.GetOrg(id)
+}
+
+func (s *Store) ListOrgs() []api.Organization {
+	s.record("ListOrgs")
+	if s.ListOrgsFunc != nil {
+		return s.ListOrgsFunc()
+	}
+	return s.Fallback.ListOrgs()
+}
+
+func (s *Store) UpdateOrg(id string, fn func(*api.Organization)) (api.Organization, error) {
+	s.record("UpdateOrg", id)
+	if s.UpdateOrgFunc != nil {
+		return s.UpdateOrgFunc(id, fn)
+	}
+	return s.Fallback.UpdateOrg(id, fn)
+}
+
+func (s *Store) DeleteOrg(id string, force bool) ([]store.Membership, error) {
+	s.record("DeleteOrg", id, force)
+	if s.DeleteOrgFunc != nil {
+		return s.DeleteOrgFunc(id, force)
+	}
+	return s.Fallback.DeleteOrg(id, force)
+}
+
+func (s *Store) AddOrgMember(m store.Membership) (store.Membership, error) {
+	s.record("AddOrgMember", m)
+	if s.AddOrgMemberFunc != nil {
+		return s.AddOrgMemberFunc(m)
+	}
+	return s.Fallback.AddOrgMember(m)
+}
+
+func (s *Store) SetOrgMemberRole(orgID, userID, role string) (store.Membership, error) {
+	s.record("SetOrgMemberRole", orgID, userID, role)
+	if s.SetOrgMemberRoleFunc != nil {
+		return s.SetOrgMemberRoleFunc(orgID, userID, role)
+	}
+	return s.Fallback.SetOrgMemberRole(orgID, userID, role)
+}
+
+func (s *Store) RemoveOrgMember(orgID, userID string) error {
+	s.record("RemoveOrgMember", orgID, userID)
+	if s.RemoveOrgMemberFunc != nil {
+		return s.RemoveOrgMemberFunc(orgID, userID)
+	}
+	return s.Fallback.RemoveOrgMember(orgID, userID)
+}
+
+func (s *Store) OrgMembers(orgID string) []store.Membership {
+	s.record("OrgMembers", orgID)
+	if s.OrgMembersFunc != nil {
+		return s.OrgMembersFunc(orgID)
+	}
+	return s.Fallback.OrgMembers(orgID)
+}
+
+func (s *Store) UserOrgs(userID string) []store.Membership {
+	s.record("UserOrgs", userID)
+	if s.UserOrgsFunc != nil {
+		return s.UserOrgsFunc(userID)
+	}
+	return s.Fallback.UserOrgs(userID)
+}
+
+func (s *Store) OrgTokensValidAfter(orgID, userID string) time.Time {
+	s.record("OrgTokensValidAfter", orgID, userID)
+	if s.OrgTokensValidAfterFunc != nil {
+		return s.OrgTokensValidAfterFunc(orgID, userID)
+	}
+	return s.Fallback.OrgTokensValidAfter(orgID, userID)
+}
+
+var _ store.Store = (*Store)(nil)
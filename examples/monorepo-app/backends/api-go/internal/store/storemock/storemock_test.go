@@ -0,0 +1,116 @@
+package storemock_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/internal/auth"
+	"github.com/your-org/your-app/backends/api-go/internal/store"
+	"github.com/your-org/your-app/backends/api-go/internal/store/storemock"
+	"github.com/your-org/your-app/backends/api-go/raijintest"
+)
+
+// do sends body as JSON and returns the status and the raw response body.
+func do(t *testing.T, client *http.Client, method, url string, body any) (int, []byte) {
+	t.Helper()
+	var rd io.Reader
+	if body != nil {
+		b, _ := json.Marshal(body)
+		rd = bytes.NewReader(b)
+	}
+	req, _ := http.NewRequest(method, url, rd)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, data
+}
+
+// wantError checks for an error response with status and code.
+func wantError(t *testing.T, status int, body []byte, wantStatus int, wantCode string) {
+	t.Helper()
+	var e api.APIError
+	if err := json.Unmarshal(body, &e); err != nil || status != wantStatus || e.ErrorCode != wantCode {
+		t.Errorf("got %d %s, want %d %s", status, body, wantStatus, wantCode)
+	}
+}
+
+// failHashing makes CreateUser fail the way it does when bcrypt does.
+func failHashing(st *storemock.Store) {
+	st.CreateUserFunc = func(email, name, password, role string) (*api.User, error) {
+		_, err := auth.HashPassword(strings.Repeat("x", auth.MaxPasswordBytes+1))
+		return nil, fmt.Errorf("hashing the password: %w", err)
+	}
+}
+
+func TestRegisterHashFailure(t *testing.T) {
+	st := storemock.New()
+	failHashing(st)
+	srv := raijintest.NewServer(t, raijintest.WithStore(st))
+
+	status, body := do(t, srv.Client(), "POST", srv.URL+"/api/v1/auth/register",
+		api.RegisterRequest{Email: "new@example.com", Name: "New", Password: "a-long-password", AcceptTerms: true})
+	wantError(t, status, body, http.StatusInternalServerError, api.ErrCodeInternal)
+	if bytes.Contains(body, []byte("token")) || bytes.Contains(body, []byte("hashing")) {
+		t.Errorf("the response says too much: %s", body)
+	}
+	if n := len(st.Calls("CreateUser")); n != 1 {
+		t.Errorf("CreateUser called %d times", n)
+	}
+	if _, err := st.Fallback.GetUserByEmail("new@example.com"); !errors.Is(err, store.ErrUserNotFound) {
+		t.Errorf("the user was created: %v", err)
+	}
+}
+
+func TestAdminCreateUserHashFailure(t *testing.T) {
+	st := storemock.New()
+	srv := raijintest.NewServer(t, raijintest.WithStore(st))
+	admin := srv.LoginAs(t, "admin")
+	failHashing(st)
+
+	status, body := do(t, admin, "POST", srv.URL+"/api/v1/admin/users",
+		map[string]string{"email": "new@example.com", "name": "New", "password": "a-long-password"})
+	wantError(t, status, body, http.StatusInternalServerError, api.ErrCodeInternal)
+}
+
+func TestGetCurrentUserStoreError(t *testing.T) {
+	st := storemock.New()
+	srv := raijintest.NewServer(t, raijintest.WithStore(st))
+	client := srv.LoginAs(t, "user")
+
+	status, body := do(t, client, "GET", srv.URL+"/api/v1/users/me", nil)
+	if status != http.StatusOK {
+		t.Fatalf("before the failure: %d %s", status, body)
+	}
+
+	st.Fail("GetUserByID", storemock.ErrInjected)
+	status, body = do(t, client, "GET", srv.URL+"/api/v1/users/me", nil)
+	wantError(t, status, body, http.StatusInternalServerError, api.ErrCodeInternal)
+	if bytes.Contains(body, []byte(storemock.ErrInjected.Error())) {
+		t.Errorf("the store error reached the client: %s", body)
+	}
+
+	// A user that is gone is not a store failure.
+	st.Fail("GetUserByID", store.ErrUserNotFound)
+	status, body = do(t, client, "GET", srv.URL+"/api/v1/users/me", nil)
+	wantError(t, status, body, http.StatusNotFound, api.ErrCodeUserNotFound)
+}
+
+func TestFailOnlyErrorMethods(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Fail accepted a method that cannot fail")
+		}
+	}()
+	storemock.New().Fail("ListUsers", storemock.ErrInjected)
+}
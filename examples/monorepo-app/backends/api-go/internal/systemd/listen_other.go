@@ -0,0 +1,8 @@
+//go:build !unix
+
+package systemd
+
+import "net"
+
+// Listeners returns nil: there is no socket activation outside Unix.
+func Listeners() (map[string][]net.Listener, error) { return nil, nil }
@@ -0,0 +1,52 @@
+//go:build unix
+
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// listenFDsStart is the first file descriptor systemd passes.
+const listenFDsStart = 3
+
+// Listeners returns the sockets systemd passed in LISTEN_FDS, keyed by
+// their LISTEN_FDNAMES name (FileDescriptorName= in the socket unit, which
+// defaults to the unit's name), or nil when the process was not socket
+// activated. It unsets the variables, so that child processes do not take
+// the sockets for theirs.
+func Listeners() (map[string][]net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	out := make(map[string][]net.Listener)
+	for i := range n {
+		fd := listenFDsStart + i
+		syscall.CloseOnExec(fd)
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		ln, err := net.FileListener(f)
+		f.Close() // FileListener holds a duplicate
+		if err != nil {
+			return nil, fmt.Errorf("fd %d (%q): %w", fd, name, err)
+		}
+		out[name] = append(out[name], ln)
+	}
+	return out, nil
+}
@@ -0,0 +1,103 @@
+//go:build unix
+
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// When RAIJIN_TEST_LISTENERS is set the test binary calls Listeners and
+// prints what it got instead of running the tests: the sockets must be
+// fds 3 and up, which only a fresh process can arrange. LISTEN_PID is its
+// own pid unless RAIJIN_TEST_LISTEN_PID says otherwise, as systemd only
+// learns it after the fork.
+func init() {
+	if os.Getenv("RAIJIN_TEST_LISTENERS") != "1" {
+		return
+	}
+	pid := os.Getenv("RAIJIN_TEST_LISTEN_PID")
+	if pid == "" {
+		pid = strconv.Itoa(os.Getpid())
+	}
+	os.Setenv("LISTEN_PID", pid)
+	listeners, err := Listeners()
+	if err != nil {
+		fmt.Println("error", err)
+	}
+	for name, lns := range listeners {
+		for _, ln := range lns {
+			fmt.Println(name, ln.Addr())
+		}
+	}
+	fmt.Printf("env %q %q %q\n", os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES"))
+	os.Exit(0)
+}
+
+// activate runs the child with lns as fds 3 and up and env added, and
+// returns its output lines, sorted.
+func activate(t *testing.T, lns []*net.TCPListener, env ...string) []string {
+	t.Helper()
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), append([]string{"RAIJIN_TEST_LISTENERS=1"}, env...)...)
+	for _, ln := range lns {
+		f, err := ln.File()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		cmd.ExtraFiles = append(cmd.ExtraFiles, f)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+	slices.Sort(lines)
+	return lines
+}
+
+func TestListeners(t *testing.T) {
+	var lns []*net.TCPListener
+	for range 3 {
+		ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+		lns = append(lns, ln)
+	}
+	cleared := `env "" "" ""`
+
+	got := activate(t, lns, "LISTEN_FDS=3", "LISTEN_FDNAMES=http:http:metrics")
+	want := []string{cleared, "http " + lns[0].Addr().String(), "http " + lns[1].Addr().String(), "metrics " + lns[2].Addr().String()}
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("activated:\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Only as many as LISTEN_FDS says, and those without a name under "".
+	got = activate(t, lns, "LISTEN_FDS=2", "LISTEN_FDNAMES=http")
+	want = []string{" " + lns[1].Addr().String(), cleared, "http " + lns[0].Addr().String()}
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("LISTEN_FDS=2:\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	for _, env := range [][]string{
+		{"RAIJIN_TEST_LISTEN_PID=1", "LISTEN_FDS=3", "LISTEN_FDNAMES=http:http:metrics"}, // another process's
+		{"RAIJIN_TEST_LISTEN_PID=not-a-pid", "LISTEN_FDS=3"},
+		{"LISTEN_FDS=0"},
+		{"LISTEN_FDS="},
+	} {
+		if got := activate(t, lns, env...); !slices.Equal(got, []string{cleared}) {
+			t.Errorf("%v: %v, want no listeners", env, got)
+		}
+	}
+}
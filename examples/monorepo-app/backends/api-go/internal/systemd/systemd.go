@@ -0,0 +1,48 @@
+// Package systemd implements the parts of the systemd service protocol the
+// server uses, with the standard library only: taking over the sockets of
+// a socket unit (sd_listen_fds) and reporting the service state to the
+// manager (sd_notify), watchdog included. Outside systemd, when its
+// variables are not set, every function is a no-op.
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Notify sends state, newline-separated assignments such as "READY=1",
+// to the service manager at NOTIFY_SOCKET. It does nothing when that is
+// not set.
+func Notify(state string) error {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return nil
+	}
+	// A leading "@" is an abstract socket, which package net handles.
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// Notifying reports whether the service manager listens for Notify.
+func Notifying() bool { return os.Getenv("NOTIFY_SOCKET") != "" }
+
+// WatchdogInterval is how often to send "WATCHDOG=1": half of
+// WATCHDOG_USEC (WatchdogSec= in the service unit), or 0 when the
+// watchdog is off or meant for another process.
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
@@ -0,0 +1,87 @@
+//go:build unix
+
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fakeManager binds a NOTIFY_SOCKET in a temporary directory and returns
+// it, to read what Notify sends.
+func fakeManager(t *testing.T) *net.UnixConn {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	return conn
+}
+
+func TestNotify(t *testing.T) {
+	manager := fakeManager(t)
+	if !Notifying() {
+		t.Error("Notifying with NOTIFY_SOCKET set: false")
+	}
+	buf := make([]byte, 1024)
+	for _, state := range []string{"READY=1", "RELOADING=1", "STOPPING=1", "STATUS=draining\nWATCHDOG=1"} {
+		if err := Notify(state); err != nil {
+			t.Fatalf("%q: %v", state, err)
+		}
+		manager.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := manager.Read(buf)
+		if err != nil {
+			t.Fatalf("%q: %v", state, err)
+		}
+		if got := string(buf[:n]); got != state {
+			t.Errorf("the manager got %q, want %q", got, state)
+		}
+	}
+}
+
+func TestNotifyUnset(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	os.Unsetenv("NOTIFY_SOCKET")
+	if Notifying() {
+		t.Error("Notifying without NOTIFY_SOCKET: true")
+	}
+	if err := Notify("READY=1"); err != nil {
+		t.Errorf("without NOTIFY_SOCKET: %v", err)
+	}
+
+	// A manager that went away is an error, for the caller to log.
+	t.Setenv("NOTIFY_SOCKET", filepath.Join(t.TempDir(), "gone"))
+	if err := Notify("READY=1"); err == nil {
+		t.Error("no error for a missing socket")
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	self := strconv.Itoa(os.Getpid())
+	for _, tt := range []struct {
+		usec, pid string
+		want      time.Duration
+	}{
+		{"", "", 0},
+		{"30000000", "", 15 * time.Second},
+		{"30000000", self, 15 * time.Second},
+		{"30000000", "1", 0}, // another process's watchdog
+		{"1000", "", 500 * time.Microsecond},
+		{"0", "", 0},
+		{"-5", "", 0},
+		{"30s", "", 0},
+	} {
+		t.Setenv("WATCHDOG_USEC", tt.usec)
+		t.Setenv("WATCHDOG_PID", tt.pid)
+		if got := WatchdogInterval(); got != tt.want {
+			t.Errorf("WATCHDOG_USEC=%q WATCHDOG_PID=%q: %s, want %s", tt.usec, tt.pid, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package raijintest_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/your-org/your-app/backends/api-go/api"
+	"github.com/your-org/your-app/backends/api-go/raijintest"
+)
+
+// authResponse is the /api/v1 login, register and refresh response.
+type authResponse struct {
+	AccessToken  string   `json:"access_token"`
+	RefreshToken string   `json:"refresh_token"`
+	CSRFToken    string   `json:"csrf_token"`
+	User         api.User `json:"user"`
+}
+
+// call sends body as JSON with headers (name, value pairs) and decodes a
+// successful response into out. It returns the response, body closed.
+func call(t *testing.T, client *http.Client, method, url string, body, out any, headers ...string) *http.Response {
+	t.Helper()
+	var rd io.Reader
+	if body != nil {
+		b, _ := json.Marshal(body)
+		rd = bytes.NewReader(b)
+	}
+	req, _ := http.NewRequest(method, url, rd)
+	req.Header.Set("Content-Type", "application/json")
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if out != nil && resp.StatusCode < 300 {
+		if err := json.Unmarshal(data, out); err != nil {
+			t.Fatalf("%s %s: %v in %s", method, url, err, data)
+		}
+	}
+	return resp
+}
+
+func wantStatus(t *testing.T, resp *http.Response, want int) {
+	t.Helper()
+	if resp.StatusCode != want {
+		t.Fatalf("%s %s: status %d, want %d", resp.Request.Method, resp.Request.URL.Path, resp.StatusCode, want)
+	}
+}
+
+// TestAuthFlow registers, logs in, refreshes and logs out over HTTP.
+func TestAuthFlow(t *testing.T) {
+	srv := raijintest.NewServer(t)
+	client := srv.Client()
+	v1 := srv.URL + "/api/v1"
+
+	var reg authResponse
+	wantStatus(t, call(t, client, "POST", v1+"/auth/register",
+		api.RegisterRequest{Email: "flow@example.com", Name: "Flow", Password: raijintest.Password, AcceptTerms: true}, &reg), http.StatusCreated)
+	if reg.AccessToken == "" || reg.RefreshToken == "" || reg.CSRFToken == "" || reg.User.Email != "flow@example.com" {
+		t.Fatalf("register: %+v", reg)
+	}
+
+	wantStatus(t, call(t, client, "POST", v1+"/auth/login",
+		api.LoginRequest{Email: "flow@example.com", Password: "not-the-password"}, nil), http.StatusUnauthorized)
+	var login authResponse
+	wantStatus(t, call(t, client, "POST", v1+"/auth/login",
+		api.LoginRequest{Email: "flow@example.com", Password: raijintest.Password}, &login), http.StatusOK)
+	bearer := "Bearer " + login.AccessToken
+
+	var me api.User
+	wantStatus(t, call(t, client, "GET", v1+"/users/me", nil, &me, "Authorization", bearer), http.StatusOK)
+	if me.ID != reg.User.ID {
+		t.Errorf("users/me is %s, registered %s", me.ID, reg.User.ID)
+	}
+
+	// A refresh rotates the refresh token: the old one stops working.
+	var refreshed authResponse
+	wantStatus(t, call(t, client, "POST", v1+"/auth/refresh", api.RefreshRequest{RefreshToken: login.RefreshToken}, &refreshed), http.StatusOK)
+	if refreshed.RefreshToken == "" || refreshed.RefreshToken == login.RefreshToken {
+		t.Fatalf("refresh did not rotate the refresh token: %+v", refreshed)
+	}
+	wantStatus(t, call(t, client, "POST", v1+"/auth/refresh", api.RefreshRequest{RefreshToken: login.RefreshToken}, nil), http.StatusUnauthorized)
+	bearer = "Bearer " + refreshed.AccessToken
+
+	// Logging out is revoking the session of the request.
+	var who api.WhoAmI
+	wantStatus(t, call(t, client, "GET", v1+"/auth/whoami", nil, &who, "Authorization", bearer), http.StatusOK)
+	if who.Token.SessionID == "" {
+		t.Fatal("whoami reports no session")
+	}
+	resp := call(t, client, "DELETE", v1+"/users/me/sessions/"+who.Token.SessionID, nil, nil,
+		"Authorization", bearer, "X-CSRF-Token", refreshed.CSRFToken)
+	wantStatus(t, resp, http.StatusNoContent)
+	if resp.Header.Get("X-Reauth-Required") != "true" {
+		t.Error("logout did not set X-Reauth-Required")
+	}
+	wantStatus(t, call(t, client, "GET", v1+"/users/me", nil, nil, "Authorization", bearer), http.StatusUnauthorized)
+	wantStatus(t, call(t, client, "POST", v1+"/auth/refresh", api.RefreshRequest{RefreshToken: refreshed.RefreshToken}, nil), http.StatusUnauthorized)
+
+	// The registration's session is another one, still signed in.
+	wantStatus(t, call(t, client, "GET", v1+"/users/me", nil, nil, "Authorization", "Bearer "+reg.AccessToken), http.StatusOK)
+}
+
+// TestCSRF checks that state-changing requests need the CSRF token of
+// the caller's login, and that LoginAs clients send one.
+func TestCSRF(t *testing.T) {
+	srv := raijintest.NewServer(t)
+	client := srv.Client()
+	v1 := srv.URL + "/api/v1"
+	user := srv.CreateUser(t, "csrf@example.com", raijintest.Password, "user")
+
+	var login authResponse
+	wantStatus(t, call(t, client, "POST", v1+"/auth/login", api.LoginRequest{Email: user.Email, Password: raijintest.Password}, &login), http.StatusOK)
+	bearer := "Bearer " + login.AccessToken
+	prefs := map[string]any{}
+
+	wantStatus(t, call(t, client, "PUT", v1+"/users/me/notifications", prefs, nil, "Authorization", bearer), http.StatusForbidden)
+	wantStatus(t, call(t, client, "PUT", v1+"/users/me/notifications", prefs, nil, "Authorization", bearer, "X-CSRF-Token", "forged"), http.StatusForbidden)
+	wantStatus(t, call(t, client, "PUT", v1+"/users/me/notifications", prefs, nil, "Authorization", bearer, "X-CSRF-Token", login.CSRFToken), http.StatusOK)
+	// Reads need none.
+	wantStatus(t, call(t, client, "GET", v1+"/users/me/notifications", nil, nil, "Authorization", bearer), http.StatusOK)
+
+	wantStatus(t, call(t, srv.LoginAs(t, "user"), "PUT", v1+"/users/me/notifications", prefs, nil), http.StatusOK)
+	// Another user's token is refused, even when the client sends it.
+	wantStatus(t, call(t, srv.LoginAs(t, "user"), "PUT", v1+"/users/me/notifications", prefs, nil, "X-CSRF-Token", login.CSRFToken), http.StatusForbidden)
+}
+
+// TestLoginAs checks the clients LoginAs and ClientAs hand out.
+func TestLoginAs(t *testing.T) {
+	srv := raijintest.NewServer(t)
+	v1 := srv.URL + "/api/v1"
+
+	var me api.User
+	wantStatus(t, call(t, srv.LoginAs(t, "admin"), "GET", v1+"/users/me", nil, &me), http.StatusOK)
+	if me.Role != "admin" {
+		t.Errorf("LoginAs(admin) is a %s", me.Role)
+	}
+	wantStatus(t, call(t, srv.LoginAs(t, "admin"), "GET", v1+"/admin/stats", nil, nil), http.StatusOK)
+	wantStatus(t, call(t, srv.LoginAs(t, "user"), "GET", v1+"/admin/stats", nil, nil), http.StatusForbidden)
+	wantStatus(t, call(t, srv.Client(), "GET", v1+"/users/me", nil, nil), http.StatusUnauthorized)
+
+	// Each call is a new user, who can also log in over HTTP.
+	var first, second api.User
+	call(t, srv.LoginAs(t, "user"), "GET", v1+"/users/me", nil, &first)
+	call(t, srv.LoginAs(t, "user"), "GET", v1+"/users/me", nil, &second)
+	if first.ID == second.ID {
+		t.Error("LoginAs returned the same user twice")
+	}
+	wantStatus(t, call(t, srv.Client(), "POST", v1+"/auth/login", api.LoginRequest{Email: first.Email, Password: raijintest.Password}, nil), http.StatusOK)
+
+	user := srv.CreateUser(t, "client-as@example.com", raijintest.Password, "user")
+	wantStatus(t, call(t, srv.ClientAs(t, user), "GET", v1+"/users/me", nil, &me), http.StatusOK)
+	if me.ID != user.ID {
+		t.Errorf("ClientAs(%s) is %s", user.ID, me.ID)
+	}
+}
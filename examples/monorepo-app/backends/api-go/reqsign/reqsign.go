@@ -0,0 +1,71 @@
+// Package reqsign signs requests from internal callers: services listed in
+// the server's INTERNAL_CALLERS, such as batch jobs, that call the API with
+// a shared key instead of a user's token. Sign is all a Go caller needs:
+//
+//	req, _ := http.NewRequest("POST", base+"/api/v1/admin/users", body)
+//	if err := reqsign.Sign(req, "billing-batch", key); err != nil {
+//		...
+//	}
+//	resp, err := http.DefaultClient.Do(req)
+//
+// The server checks the signature with the same functions, so the two
+// cannot drift apart.
+package reqsign
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The headers of a signed request. Every one of them is required.
+const (
+	HeaderCaller    = "X-Internal-Caller"    // the name in INTERNAL_CALLERS
+	HeaderTimestamp = "X-Internal-Timestamp" // Unix seconds; stale past INTERNAL_AUTH_MAX_SKEW
+	HeaderNonce     = "X-Internal-Nonce"     // unique per request; a repeat is a replay
+	HeaderSignature = "X-Internal-Signature" // see Signature
+)
+
+// Signature is the X-Internal-Signature of a request: "sha256=" and the
+// hex HMAC-SHA256 with key of
+//
+//	"<METHOD>\n<path?query>\n<timestamp>\n<nonce>\n<hex SHA-256 of the body>"
+//
+// where target is the request URI as sent, path and query.
+func Signature(key, method, target, timestamp, nonce string, body []byte) string {
+	sum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(key))
+	io.WriteString(mac, method+"\n"+target+"\n"+timestamp+"\n"+nonce+"\n"+hex.EncodeToString(sum[:]))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign sets the headers that authenticate req as caller, with a fresh
+// timestamp and nonce. It reads the body to hash it and puts back a copy,
+// so call it last, right before sending; a retry must be signed again.
+func Sign(req *http.Request, caller, key string) error {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+		req.ContentLength = int64(len(body))
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := rand.Text()
+	req.Header.Set(HeaderCaller, caller)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderNonce, nonce)
+	req.Header.Set(HeaderSignature, Signature(key, req.Method, req.URL.RequestURI(), timestamp, nonce, body))
+	return nil
+}
@@ -0,0 +1,79 @@
+package reqsign
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignature(t *testing.T) {
+	const key = "a-caller-key-of-at-least-32-bytes!"
+	base := Signature(key, "POST", "/api/v1/admin/users?x=1", "1767225600", "n1", []byte(`{"a":1}`))
+	if !strings.HasPrefix(base, "sha256=") || len(base) != len("sha256=")+64 {
+		t.Fatalf("signature %q", base)
+	}
+	if again := Signature(key, "POST", "/api/v1/admin/users?x=1", "1767225600", "n1", []byte(`{"a":1}`)); again != base {
+		t.Error("the same request signed twice differs")
+	}
+	for name, sig := range map[string]string{
+		"key":       Signature(key+"x", "POST", "/api/v1/admin/users?x=1", "1767225600", "n1", []byte(`{"a":1}`)),
+		"method":    Signature(key, "PUT", "/api/v1/admin/users?x=1", "1767225600", "n1", []byte(`{"a":1}`)),
+		"path":      Signature(key, "POST", "/api/v1/admin/users/x?x=1", "1767225600", "n1", []byte(`{"a":1}`)),
+		"query":     Signature(key, "POST", "/api/v1/admin/users?x=2", "1767225600", "n1", []byte(`{"a":1}`)),
+		"timestamp": Signature(key, "POST", "/api/v1/admin/users?x=1", "1767225601", "n1", []byte(`{"a":1}`)),
+		"nonce":     Signature(key, "POST", "/api/v1/admin/users?x=1", "1767225600", "n2", []byte(`{"a":1}`)),
+		"body":      Signature(key, "POST", "/api/v1/admin/users?x=1", "1767225600", "n1", []byte(`{"a":2}`)),
+		// The fields are separated, so moving text between them shows.
+		"shifted": Signature(key, "POST", "/api/v1/admin/users?x=1\n1767225600", "", "n1", []byte(`{"a":1}`)),
+	} {
+		if sig == base {
+			t.Errorf("another %s, the same signature", name)
+		}
+	}
+}
+
+// Sign sets every header, with a signature the server recomputes from
+// what arrives, and leaves the body readable, also for a redirect.
+func TestSign(t *testing.T) {
+	const key = "a-caller-key-of-at-least-32-bytes!"
+	req, _ := http.NewRequest("POST", "http://api.example/api/v1/admin/users?notify=true", strings.NewReader(`{"email":"x@example.com"}`))
+	if err := Sign(req, "billing-batch", key); err != nil {
+		t.Fatal(err)
+	}
+	ts, err := strconv.ParseInt(req.Header.Get(HeaderTimestamp), 10, 64)
+	if err != nil || time.Since(time.Unix(ts, 0)).Abs() > time.Minute {
+		t.Errorf("timestamp %q", req.Header.Get(HeaderTimestamp))
+	}
+	if req.Header.Get(HeaderCaller) != "billing-batch" || req.Header.Get(HeaderNonce) == "" {
+		t.Errorf("headers %v", req.Header)
+	}
+	body, _ := io.ReadAll(req.Body)
+	if string(body) != `{"email":"x@example.com"}` || req.ContentLength != int64(len(body)) {
+		t.Errorf("body after signing: %q (%d bytes)", body, req.ContentLength)
+	}
+	want := Signature(key, "POST", "/api/v1/admin/users?notify=true", req.Header.Get(HeaderTimestamp), req.Header.Get(HeaderNonce), body)
+	if got := req.Header.Get(HeaderSignature); got != want {
+		t.Errorf("signature %q, want %q", got, want)
+	}
+	again, _ := req.GetBody()
+	if body, _ := io.ReadAll(again); string(body) != `{"email":"x@example.com"}` {
+		t.Errorf("GetBody: %q", body)
+	}
+
+	nonce := req.Header.Get(HeaderNonce)
+	Sign(req, "billing-batch", key)
+	if req.Header.Get(HeaderNonce) == nonce {
+		t.Error("signing again kept the nonce")
+	}
+
+	get, _ := http.NewRequest("GET", "http://api.example/api/v1/admin/stats", nil)
+	if err := Sign(get, "billing-batch", key); err != nil {
+		t.Fatal(err)
+	}
+	if want := Signature(key, "GET", "/api/v1/admin/stats", get.Header.Get(HeaderTimestamp), get.Header.Get(HeaderNonce), nil); get.Header.Get(HeaderSignature) != want || get.Body != nil {
+		t.Errorf("no body: %q, body %v", get.Header.Get(HeaderSignature), get.Body)
+	}
+}
@@ -16,37 +16,37 @@ check_fail() { echo "  ✗ $1"; ERRORS=$((ERRORS+1)); }
 # ---------- Go API ----------
 echo "[Go API]"
 
-if grep -q 'net/http' backends/api-go/cmd/server/main.go 2>/dev/null; then
+if grep -rq 'net/http' backends/api-go/cmd backends/api-go/internal 2>/dev/null; then
   check_pass "Usando stdlib net/http (sem deps desnecessárias)"
 else
   check_warn "Verificar framework HTTP usado"
 fi
 
-if grep -q 'bcrypt' backends/api-go/cmd/server/main.go 2>/dev/null; then
+if grep -rq 'bcrypt' backends/api-go/cmd backends/api-go/internal 2>/dev/null; then
   check_pass "Bcrypt para hashing de senhas"
 else
   check_fail "Hashing de senhas não encontrado"
 fi
 
-if grep -q 'X-CSRF-Token' backends/api-go/cmd/server/main.go 2>/dev/null; then
+if grep -rq 'X-CSRF-Token' backends/api-go/cmd backends/api-go/internal 2>/dev/null; then
   check_pass "Proteção CSRF implementada"
 else
   check_fail "CSRF não configurado"
 fi
 
-if grep -q 'Strict-Transport-Security' backends/api-go/cmd/server/main.go 2>/dev/null; then
+if grep -rq 'Strict-Transport-Security' backends/api-go/cmd backends/api-go/internal 2>/dev/null; then
   check_pass "HSTS header configurado"
 else
   check_fail "HSTS não encontrado"
 fi
 
-if grep -q 'Content-Security-Policy' backends/api-go/cmd/server/main.go 2>/dev/null; then
+if grep -rq 'Content-Security-Policy' backends/api-go/cmd backends/api-go/internal 2>/dev/null; then
   check_pass "CSP header configurado"
 else
   check_warn "CSP não encontrado no backend"
 fi
 
-if grep -q 'RateLimiter' backends/api-go/cmd/server/main.go 2>/dev/null; then
+if grep -rq 'RateLimiter' backends/api-go/cmd backends/api-go/internal 2>/dev/null; then
   check_pass "Rate limiting implementado"
 else
   check_fail "Rate limiting não encontrado"